@@ -0,0 +1,19 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type certCommand struct {
+	cmd *cobra.Command
+}
+
+func newCertCommand() *certCommand {
+	certCommand := &certCommand{}
+	certCommand.cmd = &cobra.Command{
+		Use:   "cert",
+		Short: "Manage cached TLS certificates",
+	}
+
+	certCommand.cmd.AddCommand(newCertDeleteCommand().cmd)
+
+	return certCommand
+}
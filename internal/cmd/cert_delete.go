@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"net/rpc"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type certDeleteCommand struct {
+	cmd  *cobra.Command
+	args server.CertDeleteArgs
+}
+
+func newCertDeleteCommand() *certDeleteCommand {
+	certDeleteCommand := &certDeleteCommand{}
+	certDeleteCommand.cmd = &cobra.Command{
+		Use:     "delete <hostname>",
+		Short:   "Delete the cached certificates of a host that is no longer served",
+		RunE:    certDeleteCommand.run,
+		Args:    cobra.ExactArgs(1),
+		Aliases: []string{"rm"},
+	}
+
+	return certDeleteCommand
+}
+
+func (c *certDeleteCommand) run(cmd *cobra.Command, args []string) error {
+	var deleted bool
+
+	c.args.Host = args[0]
+	c.args.CachePath = globalConfig.CertificatePath()
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		err := client.Call("kamal-proxy.CertDelete", c.args, &deleted)
+		if err != nil {
+			return err
+		}
+
+		if !deleted {
+			fmt.Printf("No cached certificates found for %s\n", c.args.Host)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type chaosCommand struct {
+	cmd *cobra.Command
+}
+
+func newChaosCommand() *chaosCommand {
+	chaosCommand := &chaosCommand{}
+	chaosCommand.cmd = &cobra.Command{
+		Use:   "chaos",
+		Short: "Inject latency or errors into a service's traffic for resiliency testing",
+	}
+
+	chaosCommand.cmd.AddCommand(newChaosStartCommand().cmd)
+	chaosCommand.cmd.AddCommand(newChaosStopCommand().cmd)
+
+	return chaosCommand
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"net/rpc"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+type chaosStartCommand struct {
+	cmd  *cobra.Command
+	args server.ChaosStartArgs
+}
+
+func newChaosStartCommand() *chaosStartCommand {
+	chaosStartCommand := &chaosStartCommand{}
+	chaosStartCommand.cmd = &cobra.Command{
+		Use:       "start <service>",
+		Short:     "Start injecting faults into a percentage of requests",
+		RunE:      chaosStartCommand.run,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"service"},
+	}
+
+	chaosStartCommand.cmd.Flags().IntVar(&chaosStartCommand.args.Percentage, "percent", 0, "Percentage of requests to affect")
+	chaosStartCommand.cmd.Flags().DurationVar(&chaosStartCommand.args.Latency, "latency", 0, "Latency to add to affected requests")
+	chaosStartCommand.cmd.Flags().IntVar(&chaosStartCommand.args.ErrorStatus, "error-status", 0, "Error status to respond to affected requests with")
+	chaosStartCommand.cmd.Flags().DurationVar(&chaosStartCommand.args.Duration, "duration", server.DefaultChaosDuration, "How long to inject faults for before stopping automatically")
+
+	chaosStartCommand.cmd.MarkFlagRequired("percent")
+	chaosStartCommand.cmd.MarkFlagsOneRequired("latency", "error-status")
+
+	return chaosStartCommand
+}
+
+func (c *chaosStartCommand) run(cmd *cobra.Command, args []string) error {
+	c.args.Service = args[0]
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response bool
+		return client.Call("kamal-proxy.ChaosStart", c.args, &response)
+	})
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"net/rpc"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+type chaosStopCommand struct {
+	cmd  *cobra.Command
+	args server.ChaosStopArgs
+}
+
+func newChaosStopCommand() *chaosStopCommand {
+	chaosStopCommand := &chaosStopCommand{}
+	chaosStopCommand.cmd = &cobra.Command{
+		Use:       "stop <service>",
+		Short:     "Stop injecting faults into a service",
+		RunE:      chaosStopCommand.run,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"service"},
+	}
+
+	return chaosStopCommand
+}
+
+func (c *chaosStopCommand) run(cmd *cobra.Command, args []string) error {
+	c.args.Service = args[0]
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response bool
+		return client.Call("kamal-proxy.ChaosStop", c.args, &response)
+	})
+}
@@ -0,0 +1,19 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type debugCommand struct {
+	cmd *cobra.Command
+}
+
+func newDebugCommand() *debugCommand {
+	debugCommand := &debugCommand{}
+	debugCommand.cmd = &cobra.Command{
+		Use:   "debug",
+		Short: "Collect runtime diagnostics from the proxy",
+	}
+
+	debugCommand.cmd.AddCommand(newDebugDumpCommand().cmd)
+
+	return debugCommand
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type debugDumpCommand struct {
+	cmd       *cobra.Command
+	directory string
+}
+
+func newDebugDumpCommand() *debugDumpCommand {
+	debugDumpCommand := &debugDumpCommand{}
+	debugDumpCommand.cmd = &cobra.Command{
+		Use:   "dump",
+		Short: "Write the proxy's heap and goroutine profiles to disk",
+		RunE:  debugDumpCommand.run,
+		Args:  cobra.NoArgs,
+	}
+
+	debugDumpCommand.cmd.Flags().StringVarP(&debugDumpCommand.directory, "directory", "d", "", "Directory to write the profiles to (defaults to a new kamal-proxy-debug-<timestamp> directory)")
+
+	return debugDumpCommand
+}
+
+func (c *debugDumpCommand) run(cmd *cobra.Command, args []string) error {
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.DebugDumpResponse
+
+		err := client.Call("kamal-proxy.DebugDump", true, &response)
+		if err != nil {
+			return err
+		}
+
+		directory := c.directory
+		if directory == "" {
+			directory = "kamal-proxy-debug-" + time.Now().UTC().Format("20060102T150405Z")
+		}
+
+		err = os.MkdirAll(directory, 0700)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(response.Profiles)) {
+			path := filepath.Join(directory, name)
+			err := os.WriteFile(path, response.Profiles[name], 0600)
+			if err != nil {
+				return err
+			}
+			fmt.Println(path)
+		}
+
+		return nil
+	})
+}
@@ -1,18 +1,30 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/rpc"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/basecamp/kamal-proxy/internal/server"
 )
 
+const deployProgressInterval = time.Millisecond * 250
+
 type deployCommand struct {
-	cmd        *cobra.Command
-	args       server.DeployArgs
-	tlsStaging bool
+	cmd                     *cobra.Command
+	args                    server.DeployArgs
+	showProgress            bool
+	showDiagnostics         bool
+	diagnosticsFormat       string
+	diagnosticsHealthChecks int
+	template                string
+	preserveOptions         bool
 }
 
 func newDeployCommand() *deployCommand {
@@ -26,31 +38,155 @@ func newDeployCommand() *deployCommand {
 		ValidArgs: []string{"service"},
 	}
 
-	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetURL, "target", "", "Target host to deploy")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetURL, "target", "", "Target host to deploy, or file:// followed by the absolute path of a local directory to serve")
 	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.Hosts, "host", []string{}, "Host(s) to serve this target on (empty for wildcard)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.template, "template", "", "Name of a template to take options from; options given here override the template's")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.preserveOptions, "preserve-options", false, "Keep the current value of any option that isn't given, rather than resetting it to its default")
 
 	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.TLSEnabled, "tls", false, "Configure TLS for this target (requires a non-empty host)")
-	deployCommand.cmd.Flags().BoolVar(&deployCommand.tlsStaging, "tls-staging", false, "Use Let's Encrypt staging environment for certificate provisioning")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.ACMEStaging, "acme-staging", false, "Obtain untrusted test certificates from the Let's Encrypt staging environment, cached separately")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.ACMEStaging, "tls-staging", false, "Use Let's Encrypt staging environment for certificate provisioning")
+	deployCommand.cmd.Flags().MarkDeprecated("tls-staging", "use --acme-staging instead")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.SecurityHeaders, "security-headers", "", "Add a preset of security headers to responses (strict or relaxed)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.ContentSecurityPolicy, "content-security-policy", "", "Content-Security-Policy to add to responses, replacing the one from --security-headers")
+	deployCommand.cmd.Flags().StringToStringVar(&deployCommand.args.ServiceOptions.SecurityHeaderOverrides, "security-header", nil, "Override a security header, as name=value, or remove it with an empty value (can be specified multiple times)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.SignatureSecret, "signature-secret", "", "Require requests to be signed with this shared secret, rejecting those that aren't with a 403")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.SignatureHeader, "signature-header", server.DefaultSignatureHeader, "Header containing the request signature")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.SignatureParam, "signature-param", server.DefaultSignatureParam, "Query param containing the request signature, for signed URLs")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.SignatureMaxSkew, "signature-max-skew", server.DefaultSignatureMaxSkew, "Maximum difference between a signature's timestamp and the current time")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.SignedPaths, "signed-path", nil, "Only require signatures for paths matching this pattern, such as /webhooks/* (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.OIDCIssuer, "oidc-issuer", "", "Require users to sign in with this OpenID Connect provider (such as https://accounts.google.com)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.OIDCClientID, "oidc-client-id", "", "Client ID registered with the OpenID Connect provider")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.OIDCClientSecret, "oidc-client-secret", "", "Client secret registered with the OpenID Connect provider")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.OIDCScopes, "oidc-scope", nil, "Scope to request when signing in (default openid, email and profile; may be specified multiple times)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.OIDCAllowedEmailDomains, "oidc-allowed-email-domain", nil, "Only allow users with a verified email address in this domain (may be specified multiple times)")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.OIDCSessionDuration, "oidc-session-duration", server.DefaultOIDCSessionDuration, "How long users stay signed in before signing in with the provider again")
+	deployCommand.cmd.Flags().Float64Var(&deployCommand.args.ServiceOptions.SLOAvailability, "slo-availability", 0, "Percentage of requests that should succeed without a 5xx response, such as 99.9 (0 to not track availability)")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.SLOLatency, "slo-latency", 0, "Time within which requests should be answered (0 to not track latency)")
+	deployCommand.cmd.Flags().Float64Var(&deployCommand.args.ServiceOptions.SLOLatencyTarget, "slo-latency-target", server.DefaultSLOLatencyTarget, "Percentage of requests that should be answered within --slo-latency")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.SLOWindow, "slo-window", server.DefaultSLOWindow, "Rolling window over which SLOs are measured")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.DisableHTTP2, "disable-http2", false, "Only allow HTTP/1.1 for this service's clients, for those with broken HTTP/2 support")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.PauseACMEChallenges, "pause-acme-challenges", false, "Hold ACME HTTP-01 challenges for the target while the service is paused or stopped, instead of passing them on")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.LenientHTTP, "lenient-http", false, "Allow ambiguous requests to this service when the proxy runs with --strict-http, for legacy clients")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.SLOProtectBudget, "slo-protect-budget", false, "Turn off non-essential features, such as chaos injection, while an error budget is exhausted")
+	deployCommand.cmd.Flags().StringArrayVar(&deployCommand.args.ServiceOptions.Schedule, "schedule", nil, "Only serve requests during these hours, such as 'mon-fri 08:00-18:00' (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.ScheduleTimezone, "schedule-timezone", "UTC", "Time zone of the schedule, such as Europe/London")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.ScheduleClosed, "schedule-closed", server.DefaultScheduleClosed, "How to answer requests outside the schedule (maintenance, to show the maintenance page, or disable, to respond with a 404)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.ScheduleMessage, "schedule-message", "", "Message to show on the maintenance page outside the schedule")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.ExternalAuthURL, "external-auth-url", "", "Ask this authorization service whether each request is allowed; responses other than 200 are sent to the client instead")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.ExternalAuthTimeout, "external-auth-timeout", server.DefaultExternalAuthTimeout, "Maximum time to wait for the authorization service to respond")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.ExternalAuthCacheDuration, "external-auth-cache-duration", 0, "How long to cache the authorization service's decisions (0 to ask for every request)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.ExternalAuthResponseHeaders, "external-auth-response-header", nil, "Header to copy from the authorization service's response to allowed requests, such as X-User-Id (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.ExternalAuthCacheKeyHeaders, "external-auth-cache-key-header", nil, "Header, such as X-Api-Key, that cached authorization decisions depend on, in addition to Authorization and Cookie (may be specified multiple times)")
+	deployCommand.cmd.Flags().Float64Var(&deployCommand.args.ServiceOptions.TraceSampleRate, "trace-sample-rate", 0, "Share of requests to trace, between 0 and 1, for requests that don't already have a traceparent header (0 to leave tracing to the target)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.TraceForceHeader, "trace-force-header", "", "Always trace requests that have this header, such as X-Debug-Trace")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.MiddlewareOrder, "middleware-order", nil, "Order of the service's middleware, from the outermost in (any not given follow in the default order: "+strings.Join(server.DefaultMiddlewareOrder, ", ")+")")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.DisabledMiddleware, "disable-middleware", nil, "Skip one of the service's middleware, even when its options are set (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.FailoverAddress, "failover-address", "", "Standby host to point the service's DNS record at when all of its targets are down (requires run --dns-failover-url)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.FailoverRecord, "failover-record", "", "DNS record to update on failover (defaults to the service's hosts)")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.FailoverAfter, "failover-after", server.DefaultFailoverAfter, "How long all targets must be down before failing over")
+	deployCommand.cmd.Flags().StringToStringVar(&deployCommand.args.ServiceOptions.WellKnownFiles, "well-known-file", nil, "Serve a file in place of the target's response, as request-path=file, such as /robots.txt=/config/robots.txt (can be specified multiple times)")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.BlockIndexing, "block-indexing", false, "Ask crawlers not to index the service, with a robots.txt that disallows everything and an X-Robots-Tag header")
+	deployCommand.cmd.Flags().StringArrayVar(&deployCommand.args.ServiceOptions.Plugins, "plugin", nil, "Run a WebAssembly plugin for requests, as <path>[:<hook>,...] with hooks request_received, before_upstream and before_response (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringToStringVar(&deployCommand.args.ServiceOptions.PluginConfig, "plugin-config", nil, "Configuration to pass to the service's plugins, as name=value (can be specified multiple times)")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.PluginTimeout, "plugin-timeout", server.DefaultPluginTimeout, "Maximum time a plugin may take to handle each hook")
+	deployCommand.cmd.Flags().StringToStringVar(&deployCommand.args.ServiceOptions.RedirectHosts, "redirect-host", nil, "Permanently redirect another host to one of the service's hosts, as from=to (can be specified multiple times)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.ACMEChallenge, "acme-challenge", server.DefaultACMEChallenge, "ACME challenge to use for automatic TLS (any or tls-alpn-01, which doesn't need the HTTP port)")
 	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.TLSCertificatePath, "tls-certificate-path", "", "Configure custom TLS certificate path (PEM format)")
 	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.TLSPrivateKeyPath, "tls-private-key-path", "", "Configure custom TLS private key path (PEM format)")
 
 	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.DeployTimeout, "deploy-timeout", server.DefaultDeployTimeout, "Maximum time to wait for the new target to become healthy")
 	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.DrainTimeout, "drain-timeout", server.DefaultDrainTimeout, "Maximum time to allow existing connections to drain before removing old target")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.Force, "force", false, "Deploy even if the target and options are the same as the current deployment's")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.QueueTimeout, "queue-timeout", 0, "Maximum time to wait for another deploy of the service to finish (0 to fail straight away)")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.TTL, "ttl", 0, "Remove the service automatically once this long has passed since it was last deployed (0 to keep it indefinitely)")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.TTLRemoveCertificates, "ttl-remove-certificates", false, "Also remove the service's automatically obtained TLS certificates when its TTL expires")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.ServiceOptions.DeployAnnotationPeriod, "deploy-annotation-period", 0, "Annotate logs and metrics with a deploy ID and the previous target for this long after switching targets; the metrics are removed after twice this long (0 to disable)")
 	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.TargetOptions.HealthCheckConfig.Interval, "health-check-interval", server.DefaultHealthCheckInterval, "Interval between health checks")
 	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.TargetOptions.HealthCheckConfig.Timeout, "health-check-timeout", server.DefaultHealthCheckTimeout, "Time each health check must complete in")
 	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.HealthCheckConfig.Path, "health-check-path", server.DefaultHealthCheckPath, "Path to check for health")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.HealthCheckConfig.Type, "health-check-type", server.HealthCheckTypeHTTP, "How to check for health: http (request the health check path), tcp (open a connection), or exec (run the health check command)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.HealthCheckConfig.Command, "health-check-command", "", "Shell command to run for exec health checks; exiting with 0 means healthy, and the target's address is in $KAMAL_PROXY_TARGET")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.BlockHealthCheckPath, "block-health-check-path", false, "Answer requests for the health check path that come through the proxy with a 404, rather than passing them to the target")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.HealthCheckAllowedCIDRs, "health-check-allow", nil, "Only pass requests for the health check path to the target when they come from this IP address or CIDR range (may be specified multiple times)")
+	deployCommand.cmd.Flags().IntVar(&deployCommand.args.TargetOptions.PrewarmConnections, "prewarm-connections", 0, "Number of keep-alive connections to open to the new target before switching traffic to it")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.ExpectedVersion, "expect-version", "", "Only switch traffic to the new target if its version path returns this version, such as a commit SHA")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.VersionPath, "version-path", "", "Path that returns the target's version, for --expect-version (default /version)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.SmokeTestPaths, "smoke-test", nil, "Once the new target is healthy, request these paths from it, and only switch traffic to it if they all return 2xx (may be specified multiple times)")
 
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.TargetOptions.DNSRefreshInterval, "dns-refresh-interval", 0, "Re-resolve the target host at this interval, balancing requests across all of its addresses (0 to disable)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.Discovery, "discovery", "", "Discover the target's addresses from a registry (srv:<name>, consul://<host>/<service>, or etcd://<host>/<prefix>)")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.TargetOptions.DiscoveryInterval, "discovery-interval", server.DefaultDiscoveryInterval, "Interval between discovery lookups")
 	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.TargetOptions.ResponseTimeout, "target-timeout", server.DefaultTargetTimeout, "Maximum time to wait for the target server to respond when serving requests")
+	deployCommand.cmd.Flags().IntVar(&deployCommand.args.TargetOptions.Retries, "retries", 0, "Number of times to retry requests that fail to reach the target; requests with a body are retried only when buffered in memory")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.DirectoryListing, "directory-listing", false, "List the contents of directories that have no index.html, when serving a local directory")
+	deployCommand.cmd.Flags().StringArrayVar(&deployCommand.args.TargetOptions.DirectoryCredentials, "directory-auth", nil, "Require basic auth with these credentials, as user:password, when serving a local directory (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.StaticDirectory, "static-directory", "", "Serve the target's static assets from this local directory, sending other requests to the target")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.StaticTargetPaths, "static-target-path", nil, "Always send requests for paths matching this pattern to the target, rather than the static directory (default /api and /api/*; may be specified multiple times)")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.SPA, "spa", false, "Serve index.html for paths without a file extension that aren't in the served directory, for single-page apps")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.Precompressed, "precompressed", false, "Serve .br and .gz variants of local files, where they exist, to clients that accept them")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.TargetProtocol, "target-protocol", server.TargetProtocolHTTP1, "Protocol to send requests to the target with (http1, h2c)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.IPFamily, "target-ip-family", server.IPFamilyAny, "IP family to connect to the target with: any, ipv4, ipv6, prefer-ipv4 or prefer-ipv6")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.TargetOptions.HappyEyeballsDelay, "happy-eyeballs-delay", server.DefaultHappyEyeballsDelay, "How long to wait for the preferred IP family before also trying the other (negative to only try it once the preferred one fails)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.DeadlineHeader, "deadline-header", "", "Header that tells the target how many milliseconds it has left to respond (e.g. X-Request-Deadline)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.ClientTimeoutHeader, "client-timeout-header", "", "Header that clients can use to ask for a shorter timeout than --target-timeout, in milliseconds or as a duration")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.TargetOptions.UpgradedIdleTimeout, "upgraded-idle-timeout", 0, "Close WebSockets and other upgraded connections that have been idle for this long (default of 0 means never)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.Balance, "balance", server.BalanceRoundRobin, "How to spread requests across the target's addresses (round-robin, least-latency, least-loaded or hash)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.BalanceLoadPath, "balance-load-path", "", "Path on each of the target's addresses that reports its load as a number, for least-loaded balancing")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.BalanceHashHeader, "balance-hash-header", "", "Header that identifies clients, such as X-Tenant-ID, for hash balancing (defaults to the client's IP)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.Pool, "pool", "", "Balance requests across the addresses in this target pool, managed with kamal-proxy pool")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.TargetOptions.HedgeDelay, "hedge-delay", 0, "Send a second copy of GET and HEAD requests that haven't been answered after this long to another of the target's addresses, using whichever responds first (0 to disable)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.HedgePaths, "hedge-path", nil, "Only hedge requests for paths matching this pattern (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.TimeoutPagePath, "timeout-page", "", "Path to a file (such as an HTML or JSON document) to respond with when the target times out")
 
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.DrainResponseHeaders, "drain-response-headers", false, "Add Connection: close and X-Deploy-In-Progress headers to responses sent while the target is draining")
 	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.BufferRequests, "buffer-requests", false, "Buffer requests before forwarding to target")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.UnbufferedRequestPaths, "unbuffered-request-path", nil, "Stream request bodies for paths matching this pattern (such as /uploads/*) instead of buffering them (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringArrayVar(&deployCommand.args.TargetOptions.PriorityRules, "priority", nil, "Set the priority of matching requests for load shedding, as <critical|normal|low>:path=<pattern> or <critical|normal|low>:header=<name>[=<pattern>] (may be specified multiple times)")
+	deployCommand.cmd.Flags().IntVar(&deployCommand.args.TargetOptions.ShedLowAt, "shed-low-at", 0, "Reject low priority requests with a 503 once this many requests are in flight (0 to never shed them)")
+	deployCommand.cmd.Flags().IntVar(&deployCommand.args.TargetOptions.ShedNormalAt, "shed-normal-at", 0, "Reject normal and low priority requests with a 503 once this many requests are in flight (0 to never shed them)")
+	deployCommand.cmd.Flags().DurationVar(&deployCommand.args.TargetOptions.ShedRetryAfter, "shed-retry-after", server.DefaultShedRetryAfter, "How long to ask clients to wait before retrying shed requests")
+	deployCommand.cmd.Flags().IntVar(&deployCommand.args.TargetOptions.FairShare, "fair-share", 0, "Reject requests with a 429 from any client holding more than this percentage of the requests in flight (0 to disable)")
+	deployCommand.cmd.Flags().IntVar(&deployCommand.args.TargetOptions.FairShareMinInflight, "fair-share-min-inflight", server.DefaultFairShareMinInflight, "Only apply the fair share once this many requests are in flight")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.FairShareKey, "fair-share-key", "", "Header that identifies clients for the fair share, such as an API key (default is the client IP)")
+	deployCommand.cmd.Flags().StringArrayVar(&deployCommand.args.TargetOptions.ResponseRewrites, "rewrite-response", nil, "Rewrite responses from the target, as body:<old>=<new>, body-regexp:<pattern>=<replacement> or location:<old prefix>=<new prefix> (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.ResponseRewriteContentTypes, "rewrite-content-type", nil, "Content type of response bodies to rewrite (default text/html; may be specified multiple times)")
+	deployCommand.cmd.Flags().StringArrayVar(&deployCommand.args.TargetOptions.ResponseValidations, "validate-response", nil, "Check responses from the target, as header:<name>[=<value>], max-size:<bytes> or content-type:<type> (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.ResponseValidationAction, "invalid-response-action", server.ResponseValidationActionLog, "What to do with responses that fail validation: log, fix or reject")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.DeduplicateRequests, "deduplicate-requests", false, "Share the response to a request with any concurrent duplicates that have the same Idempotency-Key, instead of sending them to the target")
 	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.BufferResponses, "buffer-responses", false, "Buffer responses before forwarding to client")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.GenerateETags, "generate-etags", false, "Answer conditional requests for buffered responses, generating ETags for those without one")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.ETagContentTypes, "etag-content-type", nil, "Content type of responses to generate ETags for (default text/html and application/json; may be specified multiple times)")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.ServeRanges, "serve-ranges", false, "Answer range requests from buffered responses, when the target sends the whole body")
 	deployCommand.cmd.Flags().Int64Var(&deployCommand.args.TargetOptions.MaxMemoryBufferSize, "buffer-memory", server.DefaultMaxMemoryBufferSize, "Max size of memory buffer")
+	deployCommand.cmd.Flags().Int64Var(&deployCommand.args.TargetOptions.MaxRequestHeaderSize, "max-request-header-size", 0, "Max total size of the request headers forwarded to the target (0 for unlimited)")
+	deployCommand.cmd.Flags().IntVar(&deployCommand.args.TargetOptions.MaxRequestHeaderCount, "max-request-header-count", 0, "Max number of request headers forwarded to the target (0 for unlimited)")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.TargetOptions.RequestHeaderLimitAction, "request-header-limit-action", server.HeaderLimitReject, "What to do with requests over the header limits: reject them with a 431, or trim their largest headers")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.RequestTimingHeaders, "request-timing-headers", false, "Send X-Request-Start (as t=<microseconds>) and X-Queue-Time (in milliseconds) to the target, for APMs to measure queue time, and log them")
 	deployCommand.cmd.Flags().Int64Var(&deployCommand.args.TargetOptions.MaxRequestBodySize, "max-request-body", server.DefaultMaxRequestBodySize, "Max size of request body when buffering (default of 0 means unlimited)")
 	deployCommand.cmd.Flags().Int64Var(&deployCommand.args.TargetOptions.MaxResponseBodySize, "max-response-body", server.DefaultMaxResponseBodySize, "Max size of response body when buffering (default of 0 means unlimited)")
 	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.ErrorPagePath, "error-pages", "", "Path to custom error pages")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.BlockInformationalResponses, "block-informational-responses", false, "Don't forward 1xx informational responses, such as 103 Early Hints, from the target")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.AllowedMethods, "allow-method", nil, "Only allow requests using these methods (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.DeniedMethods, "deny-method", nil, "Reject requests using these methods (may be specified multiple times)")
+
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.WebSocketOrigins, "websocket-origin", nil, "Only allow WebSockets from these origins, such as https://*.example.com or same-origin (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.ServiceOptions.WebSocketSubprotocols, "websocket-subprotocol", nil, "Only offer these WebSocket subprotocols to the target (may be specified multiple times)")
+	deployCommand.cmd.Flags().IntVar(&deployCommand.args.ServiceOptions.MaxWebSocketConnections, "max-websocket-connections", 0, "Maximum number of WebSocket connections open at once (0 means unlimited)")
+
+	deployCommand.cmd.Flags().StringVar(&deployCommand.args.ServiceOptions.TenantHeader, "tenant-header", "", "Header that names the tenant of each request, for tenants given their own target with kamal-proxy tenant set")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.ServiceOptions.TenantSubdomain, "tenant-subdomain", false, "Name the tenant of each request by the first label of its host, for tenants given their own target with kamal-proxy tenant set")
+
+	deployCommand.cmd.Flags().StringToStringVar(&deployCommand.args.TargetOptions.Labels, "label", nil, "Label to attach to the target, as name=value, included in logs and status output (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.LogRequestHeaders, "log-request-header", nil, "Additional request header to log, optionally as <header>=<field> to choose its log field (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.LogResponseHeaders, "log-response-header", nil, "Additional response header to log, such as X-Runtime, optionally as <header>=<field> to choose its log field (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.LogQueryParams, "log-query-param", nil, "Query param to log; when set, all other query params are scrubbed from the logs (may be specified multiple times)")
+	deployCommand.cmd.Flags().StringArrayVar(&deployCommand.args.TargetOptions.LogTagRules, "log-tag", nil, "Tag matching requests in the logs, as <name>=<value>:path=<pattern> or <name>=<value>:header=<name>[=<pattern>] (may be specified multiple times)")
 
-	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.LogRequestHeaders, "log-request-header", nil, "Additional request header to log (may be specified multiple times)")
-	deployCommand.cmd.Flags().StringSliceVar(&deployCommand.args.TargetOptions.LogResponseHeaders, "log-response-header", nil, "Additional response header to log (may be specified multiple times)")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.showProgress, "progress", true, "Show health check results while waiting for the target to become healthy")
+	deployCommand.cmd.Flags().BoolVar(&deployCommand.showDiagnostics, "diagnostics", true, "Show diagnostics about the target if it fails to become healthy")
+	deployCommand.cmd.Flags().StringVar(&deployCommand.diagnosticsFormat, "diagnostics-format", "text", "Format of the failure diagnostics (text or json)")
+	deployCommand.cmd.Flags().IntVar(&deployCommand.diagnosticsHealthChecks, "diagnostics-health-checks", server.DefaultDiagnosticHealthChecks, "Number of recent health check results to include in the failure diagnostics")
 
 	deployCommand.cmd.Flags().BoolVar(&deployCommand.args.TargetOptions.ForwardHeaders, "forward-headers", false, "Forward X-Forwarded headers to target (default false if TLS enabled; otherwise true)")
 
@@ -65,34 +201,331 @@ func (c *deployCommand) run(cmd *cobra.Command, args []string) error {
 
 	if c.args.ServiceOptions.TLSEnabled {
 		c.args.ServiceOptions.ACMECachePath = globalConfig.CertificatePath()
-
-		if c.tlsStaging {
-			c.args.ServiceOptions.ACMEDirectory = server.ACMEStagingDirectoryURL
-		}
 	}
 
 	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
-		var response bool
-		return client.Call("kamal-proxy.Deploy", c.args, &response)
+		// Note the latest deployment before starting ours, so that we only
+		// report on the one we start.
+		progress, err := c.fetchProgress(client, server.DeployProgressArgs{Service: c.args.Service})
+		if err != nil {
+			return err
+		}
+
+		var response server.DeployResponse
+		if c.showProgress {
+			err = c.deployWithProgress(client, progress.DeploymentID, &response)
+		} else {
+			err = client.Call("kamal-proxy.Deploy", c.args, &response)
+		}
+
+		if err != nil && c.showDiagnostics {
+			c.reportDiagnostics(client, progress.DeploymentID)
+		}
+		if err == nil {
+			reportDeployResult(c.args.Service, response.Result)
+		}
+		return err
 	})
 }
 
+func (c *deployCommand) deployWithProgress(client *rpc.Client, deploymentID int, response *server.DeployResponse) error {
+	progressArgs := server.DeployProgressArgs{Service: c.args.Service, DeploymentID: deploymentID}
+
+	call := client.Go("kamal-proxy.Deploy", c.args, response, nil)
+
+	ticker := time.NewTicker(deployProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-call.Done:
+			c.reportProgress(client, &progressArgs)
+			return call.Error
+		case <-ticker.C:
+			c.reportProgress(client, &progressArgs)
+		}
+	}
+}
+
+func (c *deployCommand) reportProgress(client *rpc.Client, args *server.DeployProgressArgs) {
+	progress, err := c.fetchProgress(client, *args)
+	if err != nil || progress.Target == "" {
+		return
+	}
+
+	for _, result := range progress.Results {
+		if result.Success {
+			fmt.Fprintf(os.Stderr, "Health check for %s succeeded (%s)\n", progress.Target, formatLatency(result.Latency))
+		} else {
+			fmt.Fprintf(os.Stderr, "Health check for %s failed: %s (%s)\n", progress.Target, result.Error, formatLatency(result.Latency))
+		}
+	}
+	args.Since = progress.Next
+}
+
+func (c *deployCommand) reportDiagnostics(client *rpc.Client, deploymentID int) {
+	var response server.DeployDiagnosticsResponse
+	args := server.DeployDiagnosticsArgs{Service: c.args.Service, DeploymentID: deploymentID, HealthChecks: c.diagnosticsHealthChecks}
+
+	err := client.Call("kamal-proxy.DeployDiagnostics", args, &response)
+	if err != nil || response.Diagnostics == nil {
+		return
+	}
+
+	if c.diagnosticsFormat == "json" {
+		json.NewEncoder(os.Stderr).Encode(response.Diagnostics)
+		return
+	}
+
+	d := response.Diagnostics
+	fmt.Fprintf(os.Stderr, "Diagnostics for %s:\n", d.Target)
+
+	if len(d.Endpoints) > 0 {
+		fmt.Fprintf(os.Stderr, "  Healthy addresses: %s\n", strings.Join(d.Endpoints, ", "))
+	}
+
+	fmt.Fprintf(os.Stderr, "  Recent health checks:\n")
+	for _, result := range d.HealthChecks {
+		outcome := "ok"
+		if !result.Success {
+			outcome = "failed: " + result.Error
+		}
+		fmt.Fprintf(os.Stderr, "    %s %s (%s)\n", result.Time.Format(time.TimeOnly), outcome, formatLatency(result.Latency))
+	}
+
+	request := d.TestRequest
+	if request.Error != "" {
+		fmt.Fprintf(os.Stderr, "  Test request: GET %s failed: %s (%s)\n", request.URL, request.Error, formatLatency(request.Latency))
+	} else {
+		fmt.Fprintf(os.Stderr, "  Test request: GET %s returned %d (%s)\n", request.URL, request.StatusCode, formatLatency(request.Latency))
+		if body := strings.TrimSpace(request.Body); body != "" {
+			fmt.Fprintf(os.Stderr, "    %s\n", strings.ReplaceAll(body, "\n", "\n    "))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "  In-flight requests: %d", d.Connections.TargetInflight)
+	if d.Connections.ActiveTarget != "" {
+		fmt.Fprintf(os.Stderr, " (%d on active target %s)", d.Connections.ActiveInflight, d.Connections.ActiveTarget)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+func (c *deployCommand) fetchProgress(client *rpc.Client, args server.DeployProgressArgs) (server.DeployProgress, error) {
+	var response server.DeployProgressResponse
+	err := client.Call("kamal-proxy.DeployProgress", args, &response)
+	return response.Progress, err
+}
+
 func (c *deployCommand) preRun(cmd *cobra.Command, args []string) error {
-	if cmd.Flags().Changed("max-request-body") && !cmd.Flags().Changed("buffer-requests") {
+	flags := cmd.Flags()
+	if c.template != "" && c.preserveOptions {
+		return fmt.Errorf("template and preserve-options can't be used together")
+	}
+
+	if c.template != "" {
+		templated, err := c.applyTemplate(flags)
+		if err != nil {
+			return err
+		}
+
+		*c = *templated
+		flags = templated.cmd.Flags()
+	}
+
+	if c.preserveOptions {
+		preserved, err := c.applyDeployedOptions(flags, args[0])
+		if err != nil {
+			return err
+		}
+
+		*c = *preserved
+		flags = preserved.cmd.Flags()
+	}
+
+	if flags.Changed("max-request-body") && !c.args.TargetOptions.BufferRequests {
 		return fmt.Errorf("max-request-body can only be set when request buffering is enabled")
 	}
 
-	if cmd.Flags().Changed("max-response-body") && !cmd.Flags().Changed("buffer-responses") {
+	if flags.Changed("max-response-body") && !c.args.TargetOptions.BufferResponses {
 		return fmt.Errorf("max-response-body can only be set when response buffering is enabled")
 	}
 
-	if cmd.Flags().Changed("tls") && !cmd.Flags().Changed("host") {
+	if flags.Changed("generate-etags") && !c.args.TargetOptions.BufferResponses {
+		return fmt.Errorf("generate-etags can only be set when response buffering is enabled")
+	}
+
+	if flags.Changed("serve-ranges") && !c.args.TargetOptions.BufferResponses {
+		return fmt.Errorf("serve-ranges can only be set when response buffering is enabled")
+	}
+
+	if c.args.ServiceOptions.Pool != "" && c.args.TargetOptions.Discovery != "" {
+		return fmt.Errorf("pool and discovery can't be used together")
+	}
+
+	if flags.Changed("tls") && len(c.args.Hosts) == 0 {
 		return fmt.Errorf("host must be set when using TLS")
 	}
 
-	if !cmd.Flags().Changed("forward-headers") {
+	if c.args.ServiceOptions.ACMEStaging && (!c.args.ServiceOptions.TLSEnabled || c.args.ServiceOptions.TLSCertificatePath != "") {
+		return fmt.Errorf("acme-staging can only be set when using automatic TLS")
+	}
+
+	if (flags.Changed("signed-path") || flags.Changed("signature-header") || flags.Changed("signature-param") || flags.Changed("signature-max-skew")) && c.args.ServiceOptions.SignatureSecret == "" {
+		return fmt.Errorf("signature options can only be set when signature-secret is set")
+	}
+
+	oidcFlags := []string{"oidc-issuer", "oidc-client-id", "oidc-client-secret"}
+	oidcFlagsChanged := 0
+	for _, name := range oidcFlags {
+		if flags.Changed(name) {
+			oidcFlagsChanged++
+		}
+	}
+	if oidcFlagsChanged > 0 && oidcFlagsChanged < len(oidcFlags) {
+		return fmt.Errorf("oidc-issuer, oidc-client-id and oidc-client-secret must be set together")
+	}
+
+	if (flags.Changed("priority") || flags.Changed("shed-retry-after")) && c.args.TargetOptions.ShedLowAt == 0 && c.args.TargetOptions.ShedNormalAt == 0 {
+		return fmt.Errorf("load shedding options can only be set when shed-low-at or shed-normal-at is set")
+	}
+
+	if flags.Changed("static-target-path") && c.args.TargetOptions.StaticDirectory == "" {
+		return fmt.Errorf("static-target-path can only be set when static-directory is set")
+	}
+
+	if flags.Changed("request-header-limit-action") && c.args.TargetOptions.MaxRequestHeaderSize == 0 && c.args.TargetOptions.MaxRequestHeaderCount == 0 {
+		return fmt.Errorf("request-header-limit-action can only be set when max-request-header-size or max-request-header-count is set")
+	}
+
+	if (flags.Changed("fair-share-min-inflight") || flags.Changed("fair-share-key")) && c.args.TargetOptions.FairShare == 0 {
+		return fmt.Errorf("fair share options can only be set when fair-share is set")
+	}
+
+	if (flags.Changed("slo-latency-target") || flags.Changed("slo-window") || flags.Changed("slo-protect-budget")) && c.args.ServiceOptions.SLOAvailability == 0 && c.args.ServiceOptions.SLOLatency == 0 {
+		return fmt.Errorf("SLO options can only be set when slo-availability or slo-latency is set")
+	}
+
+	if (flags.Changed("schedule-timezone") || flags.Changed("schedule-closed") || flags.Changed("schedule-message")) && len(c.args.ServiceOptions.Schedule) == 0 {
+		return fmt.Errorf("schedule options can only be set when a schedule is given")
+	}
+
+	if (flags.Changed("external-auth-timeout") || flags.Changed("external-auth-cache-duration") || flags.Changed("external-auth-response-header") || flags.Changed("external-auth-cache-key-header")) && c.args.ServiceOptions.ExternalAuthURL == "" {
+		return fmt.Errorf("external authorization options can only be set when external-auth-url is set")
+	}
+
+	if (flags.Changed("plugin-config") || flags.Changed("plugin-timeout")) && len(c.args.ServiceOptions.Plugins) == 0 {
+		return fmt.Errorf("plugin options can only be set when a plugin is given")
+	}
+
+	if c.diagnosticsFormat != "text" && c.diagnosticsFormat != "json" {
+		return fmt.Errorf("diagnostics-format must be either text or json")
+	}
+
+	if !flags.Changed("forward-headers") {
 		c.args.TargetOptions.ForwardHeaders = !c.args.ServiceOptions.TLSEnabled
 	}
 
 	return nil
 }
+
+// applyTemplate returns a deploy command with the options from the template,
+// and any given on the command line. Options from the command line replace
+// the template's, except for those that can be given more than once, which
+// are added to them.
+func (c *deployCommand) applyTemplate(flags *pflag.FlagSet) (*deployCommand, error) {
+	var response server.TemplateGetResponse
+	err := withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		return client.Call("kamal-proxy.TemplateGet", server.TemplateGetArgs{Name: c.template}, &response)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to load template %s: %w", c.template, err)
+	}
+
+	templated := newDeployCommand()
+	err = templated.cmd.ParseFlags(response.Args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", c.template, err)
+	}
+
+	templatedFlags := templated.cmd.Flags()
+	flags.Visit(func(flag *pflag.Flag) {
+		if err == nil {
+			err = applyFlag(templatedFlags, flag)
+		}
+	})
+
+	return templated, err
+}
+
+// applyDeployedOptions starts from the options that the service is deployed
+// with, rather than the defaults, so that only the options that are given
+// are changed. A service that hasn't been deployed yet starts from the
+// defaults as usual.
+func (c *deployCommand) applyDeployedOptions(flags *pflag.FlagSet, service string) (*deployCommand, error) {
+	deployed, found, err := fetchDeployedOptions(service)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return c, nil
+	}
+
+	preserved := newDeployCommand()
+	preserved.args.Hosts = deployed.Hosts
+	preserved.args.ServiceOptions = deployed.Options
+	preserved.args.TargetOptions = deployed.TargetOptions
+
+	// The expected version is that of the deployed target, so it only applies
+	// to this deploy if it's given again.
+	preserved.args.TargetOptions.ExpectedVersion = ""
+
+	preservedFlags := preserved.cmd.Flags()
+	flags.Visit(func(flag *pflag.Flag) {
+		if err == nil {
+			err = replaceFlag(preservedFlags, flag)
+		}
+	})
+
+	return preserved, err
+}
+
+// replaceFlag sets a flag to the value of another, replacing its value
+// rather than adding to it.
+func replaceFlag(flags *pflag.FlagSet, flag *pflag.Flag) error {
+	target := flags.Lookup(flag.Name)
+
+	if value, ok := flag.Value.(pflag.SliceValue); ok {
+		target.Changed = true
+		return target.Value.(pflag.SliceValue).Replace(value.GetSlice())
+	}
+
+	if flag.Value.Type() == "stringToString" {
+		// Maps are formatted as [name=value,...], but parsed without brackets.
+		return flags.Set(flag.Name, strings.Trim(flag.Value.String(), "[]"))
+	}
+
+	return flags.Set(flag.Name, flag.Value.String())
+}
+
+func applyFlag(flags *pflag.FlagSet, flag *pflag.Flag) error {
+	target := flags.Lookup(flag.Name)
+
+	switch value := flag.Value.(type) {
+	case pflag.SliceValue:
+		for _, item := range value.GetSlice() {
+			err := target.Value.(pflag.SliceValue).Append(item)
+			if err != nil {
+				return err
+			}
+		}
+		target.Changed = true
+		return nil
+	}
+
+	if flag.Value.Type() == "stringToString" {
+		// Maps are formatted as [name=value,...], but parsed without brackets.
+		return flags.Set(flag.Name, strings.Trim(flag.Value.String(), "[]"))
+	}
+
+	return flags.Set(flag.Name, flag.Value.String())
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"net/rpc"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type healthLogCommand struct {
+	cmd  *cobra.Command
+	args server.HealthLogArgs
+}
+
+func newHealthLogCommand() *healthLogCommand {
+	healthLogCommand := &healthLogCommand{}
+	healthLogCommand.cmd = &cobra.Command{
+		Use:       "health-log <service>",
+		Short:     "Show recent health check results for a service's targets",
+		RunE:      healthLogCommand.run,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"service"},
+	}
+
+	return healthLogCommand
+}
+
+func (c *healthLogCommand) run(cmd *cobra.Command, args []string) error {
+	c.args.Service = args[0]
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.HealthLogResponse
+
+		err := client.Call("kamal-proxy.HealthLog", c.args, &response)
+		if err != nil {
+			return err
+		}
+
+		c.displayResponse(response)
+		return nil
+	})
+}
+
+func (c *healthLogCommand) displayResponse(response server.HealthLogResponse) {
+	for i, target := range response.Targets {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%s)\n", target.Target, target.Role)
+
+		table := NewTable()
+		table.AddRow([]string{"Time", "Result", "Status", "Latency", "Error"})
+
+		for _, result := range target.Results {
+			outcome := "failed"
+			if result.Success {
+				outcome = "ok"
+			}
+
+			status := ""
+			if result.StatusCode != 0 {
+				status = strconv.Itoa(result.StatusCode)
+			}
+
+			table.AddRow([]string{
+				result.Time.Format(time.TimeOnly),
+				outcome,
+				status,
+				formatLatency(result.Latency),
+				result.Error,
+			})
+		}
+
+		table.Print()
+	}
+}
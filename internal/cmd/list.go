@@ -1,9 +1,15 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"maps"
 	"net/rpc"
+	"os"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -11,7 +17,8 @@ import (
 )
 
 type listCommand struct {
-	cmd *cobra.Command
+	cmd    *cobra.Command
+	format string
 }
 
 func newListCommand() *listCommand {
@@ -20,13 +27,23 @@ func newListCommand() *listCommand {
 		Use:     "list",
 		Short:   "List the services currently running",
 		RunE:    listCommand.run,
+		PreRunE: listCommand.preRun,
 		Args:    cobra.NoArgs,
 		Aliases: []string{"ls"},
 	}
 
+	listCommand.cmd.Flags().StringVar(&listCommand.format, "format", "text", "Output format (text or json)")
+
 	return listCommand
 }
 
+func (c *listCommand) preRun(cmd *cobra.Command, args []string) error {
+	if c.format != "text" && c.format != "json" {
+		return fmt.Errorf("unknown format %q (must be text or json)", c.format)
+	}
+	return nil
+}
+
 func (c *listCommand) run(cmd *cobra.Command, args []string) error {
 	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
 		var response server.ListResponse
@@ -36,6 +53,10 @@ func (c *listCommand) run(cmd *cobra.Command, args []string) error {
 			return err
 		}
 
+		if c.format == "json" {
+			return json.NewEncoder(os.Stdout).Encode(response)
+		}
+
 		c.displayResponse(response)
 		return nil
 	})
@@ -43,18 +64,56 @@ func (c *listCommand) run(cmd *cobra.Command, args []string) error {
 
 func (c *listCommand) displayResponse(response server.ListResponse) {
 	table := NewTable()
-	table.AddRow([]string{"Service", "Host", "Target", "State", "TLS"})
+	table.AddRow([]string{"Service", "Host", "Target", "State", "TLS", "Upgraded", "Labels"})
 
 	sortedKeys := slices.Sorted(maps.Keys(response.Targets))
 	for _, name := range sortedKeys {
 		service := response.Targets[name]
 		tls := "no"
-		if service.TLS {
+		if service.ACMEStaging {
+			tls = "staging"
+		} else if service.TLS {
 			tls = "yes"
 		}
 
-		table.AddRow([]string{name, service.Host, service.Target, service.State, tls})
+		table.AddRow([]string{name, service.Host, service.Target, c.formatState(service), tls, strconv.FormatInt(service.UpgradedConnections, 10), c.formatLabels(service.Labels)})
 	}
 
 	table.Print()
 }
+
+func (c *listCommand) formatState(service server.ServiceDescription) string {
+	state := service.State
+	if pause := service.Pause; pause != nil {
+		state += " ("
+		if pause.Maintenance {
+			state += "maintenance, "
+		}
+		state += fmt.Sprintf("for %s", time.Since(pause.Since).Round(time.Second))
+		if pause.HeldRequests > 0 {
+			state += fmt.Sprintf(", %d held, %s of %s timeout left", pause.HeldRequests, pause.TimeoutRemaining.Round(time.Second), pause.Timeout)
+		} else if pause.Timeout > 0 {
+			state += fmt.Sprintf(", timeout %s", pause.Timeout)
+		}
+		state += fmt.Sprintf(", drain timeout %s)", pause.DrainTimeout)
+	}
+
+	lock := service.DeployLock
+	if lock == nil {
+		return state
+	}
+
+	state = fmt.Sprintf("%s (deploying %s for %s", state, lock.Target, time.Since(lock.Since).Round(time.Second))
+	if lock.Queued > 0 {
+		state += fmt.Sprintf(", %d queued", lock.Queued)
+	}
+	return state + ")"
+}
+
+func (c *listCommand) formatLabels(labels map[string]string) string {
+	pairs := []string{}
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, name+"="+labels[name])
+	}
+	return strings.Join(pairs, ",")
+}
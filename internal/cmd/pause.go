@@ -11,13 +11,14 @@ import (
 type pauseCommand struct {
 	cmd  *cobra.Command
 	args server.PauseArgs
+	yes  bool
 }
 
 func newPauseCommand() *pauseCommand {
 	pauseCommand := &pauseCommand{}
 	pauseCommand.cmd = &cobra.Command{
 		Use:       "pause <service>",
-		Short:     "Pause a service",
+		Short:     "Pause a service (or all services matching a pattern, such as review-*)",
 		RunE:      pauseCommand.run,
 		Args:      cobra.ExactArgs(1),
 		ValidArgs: []string{"service"},
@@ -25,6 +26,9 @@ func newPauseCommand() *pauseCommand {
 
 	pauseCommand.cmd.Flags().DurationVar(&pauseCommand.args.DrainTimeout, "drain-timeout", server.DefaultDrainTimeout, "How long to allow in-flight requests to complete")
 	pauseCommand.cmd.Flags().DurationVar(&pauseCommand.args.PauseTimeout, "max-pause", server.DefaultPauseTimeout, "How long to enqueue requests before shedding load")
+	pauseCommand.cmd.Flags().StringSliceVar(&pauseCommand.args.ExceptPaths, "except-path", nil, "Continue to proxy requests for paths matching this pattern (such as /webhooks/*) while paused (may be specified multiple times)")
+	pauseCommand.cmd.Flags().BoolVar(&pauseCommand.args.ReadOnly, "read-only", false, "Continue to proxy reads (GET, HEAD, OPTIONS and TRACE requests), and only hold writes")
+	pauseCommand.cmd.Flags().BoolVarP(&pauseCommand.yes, "yes", "y", false, "Don't ask for confirmation when pausing more than one service")
 
 	return pauseCommand
 }
@@ -35,6 +39,10 @@ func (c *pauseCommand) run(cmd *cobra.Command, args []string) error {
 	c.args.Service = args[0]
 
 	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
-		return client.Call("kamal-proxy.Pause", c.args, &response)
+		return forEachService(client, c.args.Service, false, c.yes, "Pause", func(name string) error {
+			args := c.args
+			args.Service = name
+			return client.Call("kamal-proxy.Pause", args, &response)
+		})
 	})
 }
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type pauseAllCommand struct {
+	cmd  *cobra.Command
+	args server.HostMaintenanceArgs
+	yes  bool
+}
+
+func newPauseAllCommand() *pauseAllCommand {
+	pauseAllCommand := &pauseAllCommand{}
+	pauseAllCommand.cmd = &cobra.Command{
+		Use:   "pause-all",
+		Short: "Pause (or stop) every running service for host maintenance, until resume-all",
+		RunE:  pauseAllCommand.run,
+		Args:  cobra.NoArgs,
+	}
+
+	pauseAllCommand.cmd.Flags().DurationVar(&pauseAllCommand.args.DrainTimeout, "drain-timeout", server.DefaultDrainTimeout, "How long to allow in-flight requests to complete")
+	pauseAllCommand.cmd.Flags().DurationVar(&pauseAllCommand.args.PauseTimeout, "max-pause", server.DefaultPauseTimeout, "How long to enqueue requests before shedding load")
+	pauseAllCommand.cmd.Flags().BoolVar(&pauseAllCommand.args.Stop, "stop", false, "Stop the services instead of pausing them")
+	pauseAllCommand.cmd.Flags().StringVar(&pauseAllCommand.args.Message, "message", server.DefaultStopMessage, "Message to display to clients while stopped")
+	pauseAllCommand.cmd.Flags().StringSliceVar(&pauseAllCommand.args.Except, "except", nil, "Leave services matching this name or pattern (such as review-*) running (may be specified multiple times)")
+	pauseAllCommand.cmd.Flags().BoolVarP(&pauseAllCommand.yes, "yes", "y", false, "Don't ask for confirmation")
+
+	return pauseAllCommand
+}
+
+func (c *pauseAllCommand) run(cmd *cobra.Command, args []string) error {
+	action, done := "Pause", "Paused"
+	if c.args.Stop {
+		action, done = "Stop", "Stopped"
+	}
+	if !c.yes && !confirm(fmt.Sprintf("%s every running service?", action)) {
+		return errors.New("cancelled")
+	}
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.HostMaintenanceResponse
+
+		err := client.Call("kamal-proxy.PauseAll", c.args, &response)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s %d service(s): %s\n", done, len(response.Services), strings.Join(response.Services, ", "))
+		return nil
+	})
+}
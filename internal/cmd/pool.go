@@ -0,0 +1,21 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type poolCommand struct {
+	cmd *cobra.Command
+}
+
+func newPoolCommand() *poolCommand {
+	poolCommand := &poolCommand{}
+	poolCommand.cmd = &cobra.Command{
+		Use:   "pool",
+		Short: "Manage target pools shared by services",
+	}
+
+	poolCommand.cmd.AddCommand(newPoolAddCommand().cmd)
+	poolCommand.cmd.AddCommand(newPoolRemoveCommand().cmd)
+	poolCommand.cmd.AddCommand(newPoolListCommand().cmd)
+
+	return poolCommand
+}
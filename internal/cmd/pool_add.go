@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"net/rpc"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+type poolAddCommand struct {
+	cmd  *cobra.Command
+	args server.PoolAddArgs
+}
+
+func newPoolAddCommand() *poolAddCommand {
+	poolAddCommand := &poolAddCommand{}
+	poolAddCommand.cmd = &cobra.Command{
+		Use:   "add <pool> <address>...",
+		Short: "Add targets to a pool, creating it if needed",
+		RunE:  poolAddCommand.run,
+		Args:  cobra.MinimumNArgs(2),
+	}
+
+	return poolAddCommand
+}
+
+func (c *poolAddCommand) run(cmd *cobra.Command, args []string) error {
+	c.args.Pool = args[0]
+	c.args.Addresses = args[1:]
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response bool
+		return client.Call("kamal-proxy.PoolAdd", c.args, &response)
+	})
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"maps"
+	"net/rpc"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type poolListCommand struct {
+	cmd *cobra.Command
+}
+
+func newPoolListCommand() *poolListCommand {
+	poolListCommand := &poolListCommand{}
+	poolListCommand.cmd = &cobra.Command{
+		Use:     "list",
+		Short:   "List target pools",
+		RunE:    poolListCommand.run,
+		Args:    cobra.NoArgs,
+		Aliases: []string{"ls"},
+	}
+
+	return poolListCommand
+}
+
+func (c *poolListCommand) run(cmd *cobra.Command, args []string) error {
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.PoolListResponse
+
+		err := client.Call("kamal-proxy.PoolList", true, &response)
+		if err != nil {
+			return err
+		}
+
+		table := NewTable()
+		table.AddRow([]string{"Pool", "Targets"})
+		for _, name := range slices.Sorted(maps.Keys(response.Pools)) {
+			table.AddRow([]string{name, strings.Join(response.Pools[name], ",")})
+		}
+		table.Print()
+
+		return nil
+	})
+}
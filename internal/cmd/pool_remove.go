@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"net/rpc"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+type poolRemoveCommand struct {
+	cmd  *cobra.Command
+	args server.PoolRemoveArgs
+}
+
+func newPoolRemoveCommand() *poolRemoveCommand {
+	poolRemoveCommand := &poolRemoveCommand{}
+	poolRemoveCommand.cmd = &cobra.Command{
+		Use:     "remove <pool> [<address>...]",
+		Short:   "Remove targets from a pool, or the whole pool if none are given",
+		RunE:    poolRemoveCommand.run,
+		Args:    cobra.MinimumNArgs(1),
+		Aliases: []string{"rm"},
+	}
+
+	return poolRemoveCommand
+}
+
+func (c *poolRemoveCommand) run(cmd *cobra.Command, args []string) error {
+	c.args.Pool = args[0]
+	c.args.Addresses = args[1:]
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response bool
+		return client.Call("kamal-proxy.PoolRemove", c.args, &response)
+	})
+}
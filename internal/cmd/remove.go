@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"fmt"
 	"net/rpc"
 
 	"github.com/spf13/cobra"
@@ -11,28 +12,48 @@ import (
 type removeCommand struct {
 	cmd  *cobra.Command
 	args server.RemoveArgs
+	all  bool
+	yes  bool
 }
 
 func newRemoveCommand() *removeCommand {
 	removeCommand := &removeCommand{}
 	removeCommand.cmd = &cobra.Command{
 		Use:       "remove <service>",
-		Short:     "Remove the service",
+		Short:     "Remove the service (or all services matching a pattern, such as review-*)",
+		PreRunE:   removeCommand.preRun,
 		RunE:      removeCommand.run,
-		Args:      cobra.ExactArgs(1),
+		Args:      cobra.MaximumNArgs(1),
 		ValidArgs: []string{"service"},
 		Aliases:   []string{"rm"},
 	}
 
+	removeCommand.cmd.Flags().BoolVar(&removeCommand.all, "all", false, "Remove all services")
+	removeCommand.cmd.Flags().BoolVarP(&removeCommand.yes, "yes", "y", false, "Don't ask for confirmation when removing more than one service")
+	removeCommand.cmd.Flags().BoolVar(&removeCommand.args.PurgeCertificates, "purge-certs", false, "Also delete the cached certificates of hosts that are no longer served")
+
 	return removeCommand
 }
 
+func (c *removeCommand) preRun(cmd *cobra.Command, args []string) error {
+	if c.all == (len(args) == 1) {
+		return fmt.Errorf("specify either a service or --all")
+	}
+	return nil
+}
+
 func (c *removeCommand) run(cmd *cobra.Command, args []string) error {
 	var response bool
 
-	c.args.Service = args[0]
+	if len(args) == 1 {
+		c.args.Service = args[0]
+	}
 
 	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
-		return client.Call("kamal-proxy.Remove", c.args, &response)
+		return forEachService(client, c.args.Service, c.all, c.yes, "Remove", func(name string) error {
+			args := c.args
+			args.Service = name
+			return client.Call("kamal-proxy.Remove", args, &response)
+		})
 	})
 }
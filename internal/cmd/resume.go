@@ -11,18 +11,22 @@ import (
 type resumeCommand struct {
 	cmd  *cobra.Command
 	args server.ResumeArgs
+	yes  bool
 }
 
 func newResumeCommand() *resumeCommand {
 	resumeCommand := &resumeCommand{}
 	resumeCommand.cmd = &cobra.Command{
 		Use:       "resume <service>",
-		Short:     "Resume a service",
+		Short:     "Resume a service (or all services matching a pattern, such as review-*)",
 		RunE:      resumeCommand.run,
 		Args:      cobra.ExactArgs(1),
 		ValidArgs: []string{"service"},
 	}
 
+	resumeCommand.cmd.Flags().IntVar(&resumeCommand.args.ReleaseConcurrency, "release-concurrency", 0, "Let requests that were held while paused through at most this many at a time (0 for all at once)")
+	resumeCommand.cmd.Flags().BoolVarP(&resumeCommand.yes, "yes", "y", false, "Don't ask for confirmation when resuming more than one service")
+
 	return resumeCommand
 }
 
@@ -32,6 +36,10 @@ func (c *resumeCommand) run(cmd *cobra.Command, args []string) error {
 	c.args.Service = args[0]
 
 	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
-		return client.Call("kamal-proxy.Resume", c.args, &response)
+		return forEachService(client, c.args.Service, false, c.yes, "Resume", func(name string) error {
+			args := c.args
+			args.Service = name
+			return client.Call("kamal-proxy.Resume", args, &response)
+		})
 	})
 }
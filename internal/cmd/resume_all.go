@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"net/rpc"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type resumeAllCommand struct {
+	cmd  *cobra.Command
+	args server.ResumeAllArgs
+}
+
+func newResumeAllCommand() *resumeAllCommand {
+	resumeAllCommand := &resumeAllCommand{}
+	resumeAllCommand.cmd = &cobra.Command{
+		Use:   "resume-all",
+		Short: "Resume the services that were paused or stopped by pause-all",
+		RunE:  resumeAllCommand.run,
+		Args:  cobra.NoArgs,
+	}
+
+	resumeAllCommand.cmd.Flags().IntVar(&resumeAllCommand.args.ReleaseConcurrency, "release-concurrency", 0, "Let requests that were held while paused through at most this many at a time (0 for all at once)")
+
+	return resumeAllCommand
+}
+
+func (c *resumeAllCommand) run(cmd *cobra.Command, args []string) error {
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.HostMaintenanceResponse
+
+		err := client.Call("kamal-proxy.ResumeAll", c.args, &response)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Resumed %d service(s): %s\n", len(response.Services), strings.Join(response.Services, ", "))
+		return nil
+	})
+}
@@ -19,15 +19,30 @@ var rootCmd = &cobra.Command{
 
 func Execute() {
 	rootCmd.CompletionOptions.HiddenDefaultCmd = true
+	rootCmd.PersistentFlags().StringVar(&globalConfig.CommandSocketPath, "socket-path", getEnvString("SOCKET_PATH", ""), "Path of the command socket (defaults to kamal-proxy.sock in $XDG_RUNTIME_DIR, or the temp directory)")
 
 	rootCmd.AddCommand(newRunCommand().cmd)
 	rootCmd.AddCommand(newDeployCommand().cmd)
+	rootCmd.AddCommand(newSetOptionCommand().cmd)
+	rootCmd.AddCommand(newUnsetOptionCommand().cmd)
+	rootCmd.AddCommand(newTemplateCommand().cmd)
+	rootCmd.AddCommand(newCertCommand().cmd)
 	rootCmd.AddCommand(newRemoveCommand().cmd)
 	rootCmd.AddCommand(newPauseCommand().cmd)
 	rootCmd.AddCommand(newStopCommand().cmd)
 	rootCmd.AddCommand(newResumeCommand().cmd)
+	rootCmd.AddCommand(newPauseAllCommand().cmd)
+	rootCmd.AddCommand(newResumeAllCommand().cmd)
 	rootCmd.AddCommand(newListCommand().cmd)
+	rootCmd.AddCommand(newTopCommand().cmd)
+	rootCmd.AddCommand(newHealthLogCommand().cmd)
 	rootCmd.AddCommand(newRolloutCommand().cmd)
+	rootCmd.AddCommand(newTenantCommand().cmd)
+	rootCmd.AddCommand(newPoolCommand().cmd)
+	rootCmd.AddCommand(newChaosCommand().cmd)
+	rootCmd.AddCommand(newStateCommand().cmd)
+	rootCmd.AddCommand(newValidateCommand().cmd)
+	rootCmd.AddCommand(newDebugCommand().cmd)
 
 	err := rootCmd.Execute()
 	if err != nil {
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	"github.com/spf13/cobra"
@@ -14,6 +16,10 @@ import (
 type runCommand struct {
 	cmd              *cobra.Command
 	debugLogsEnabled bool
+	ignoreState      bool
+	proxyBufferSize  int
+	socketMode       string
+	tcpNoDelay       bool
 }
 
 func newRunCommand() *runCommand {
@@ -25,8 +31,41 @@ func newRunCommand() *runCommand {
 	}
 
 	runCommand.cmd.Flags().BoolVar(&runCommand.debugLogsEnabled, "debug", getEnvBool("DEBUG", false), "Include debugging logs")
+	runCommand.cmd.Flags().StringVar(&globalConfig.Bind, "bind", getEnvString("BIND", ""), "Address to listen on, such as 127.0.0.1 or :: (empty for all addresses)")
+	runCommand.cmd.Flags().StringVar(&globalConfig.ListenFamily, "listen-ip-family", getEnvString("LISTEN_IP_FAMILY", server.IPFamilyAny), "IP family to listen with: any, ipv4 or ipv6 (IPv6 listeners don't accept IPv4 connections)")
+	runCommand.cmd.Flags().IntVar(&globalConfig.ReusePortListeners, "reuse-port-listeners", getEnvInt("REUSE_PORT_LISTENERS", 0), "Number of sockets to open for each of the HTTP and HTTPS ports with SO_REUSEPORT, each with its own accept loop (-1 for one per CPU; 0 for a single socket)")
+	runCommand.cmd.Flags().BoolVar(&runCommand.tcpNoDelay, "tcp-nodelay", getEnvBool("TCP_NODELAY", true), "Send small writes to clients straight away (TCP_NODELAY), rather than combining them into fewer packets")
+	runCommand.cmd.Flags().DurationVar(&globalConfig.TCPKeepAlive, "tcp-keepalive", 0, "Interval between TCP keep-alive probes on idle client connections (0 for the default of 15s; negative to disable)")
+	runCommand.cmd.Flags().DurationVar(&globalConfig.TCPDeferAccept, "tcp-defer-accept", 0, "Only accept connections once the client has sent data, or this long has passed (TCP_DEFER_ACCEPT; 0 to disable)")
+	runCommand.cmd.Flags().IntVar(&globalConfig.TCPBacklog, "tcp-backlog", getEnvInt("TCP_BACKLOG", 0), "Length of the queue of connections waiting to be accepted (0 for the system maximum)")
 	runCommand.cmd.Flags().IntVar(&globalConfig.HttpPort, "http-port", getEnvInt("HTTP_PORT", server.DefaultHttpPort), "Port to serve HTTP traffic on")
+	runCommand.cmd.Flags().StringVar(&globalConfig.HTTPMode, "http-mode", getEnvString("HTTP_MODE", server.DefaultHTTPMode), "How to use the HTTP port: full, redirect (only ACME challenges and redirects to HTTPS) or off")
+	runCommand.cmd.Flags().BoolVar(&globalConfig.StrictHTTP, "strict-http", getEnvBool("STRICT_HTTP", false), "Reject ambiguous HTTP/1 requests, such as those with folded headers or more than one Content-Length")
 	runCommand.cmd.Flags().IntVar(&globalConfig.HttpsPort, "https-port", getEnvInt("HTTPS_PORT", server.DefaultHttpsPort), "Port to serve HTTPS traffic on")
+	runCommand.cmd.Flags().StringVar(&globalConfig.DockerSocketPath, "docker-socket", getEnvString("DOCKER_SOCKET", ""), "Docker socket to watch for containers labeled with "+server.DockerServiceLabel+" (empty to disable)")
+	runCommand.cmd.Flags().DurationVar(&globalConfig.DockerResyncInterval, "docker-resync-interval", server.DefaultDockerResyncInterval, "Interval between full resyncs of Docker containers")
+	runCommand.cmd.Flags().IntVar(&globalConfig.MetricsPort, "metrics-port", getEnvInt("METRICS_PORT", 0), "Port to serve Prometheus metrics on (0 to disable)")
+	runCommand.cmd.Flags().IntVar(&globalConfig.DebugPort, "debug-port", getEnvInt("DEBUG_PORT", 0), "Port to serve pprof profiles and goroutine dumps on, which should not be publicly reachable (0 to disable)")
+	runCommand.cmd.Flags().StringVar(&globalConfig.DebugBind, "debug-bind", getEnvString("DEBUG_BIND", server.DefaultDebugBind), "Address for the debug port to listen on")
+	runCommand.cmd.Flags().StringVar(&globalConfig.MetricsPushURL, "metrics-push-url", getEnvString("METRICS_PUSH_URL", ""), "Push metrics to a StatsD agent (statsd:// or dogstatsd://) or OTLP/HTTP collector (http:// or https://)")
+	runCommand.cmd.Flags().DurationVar(&globalConfig.MetricsPushInterval, "metrics-push-interval", server.DefaultMetricsPushInterval, "Interval between metrics pushes")
+	runCommand.cmd.Flags().DurationVar(&globalConfig.StateBackupInterval, "state-backup-interval", server.DefaultStateBackupInterval, "Interval between backups of the state (0 to disable)")
+	runCommand.cmd.Flags().IntVar(&globalConfig.StateBackupRetention, "state-backups", getEnvInt("STATE_BACKUPS", server.DefaultStateBackupRetention), "Number of state backups to keep (0 to disable)")
+	runCommand.cmd.Flags().IntVar(&runCommand.proxyBufferSize, "proxy-buffer-size", getEnvInt("PROXY_BUFFER_SIZE", int(server.DefaultProxyBufferSize)), "Size in bytes of the buffers used to copy request and response bodies")
+	runCommand.cmd.Flags().IntVar(&globalConfig.SocketReadBufferSize, "socket-read-buffer-size", getEnvInt("SOCKET_READ_BUFFER_SIZE", 0), "Size in bytes of the kernel receive buffer for client connections (0 for the system default)")
+	runCommand.cmd.Flags().IntVar(&globalConfig.SocketWriteBufferSize, "socket-write-buffer-size", getEnvInt("SOCKET_WRITE_BUFFER_SIZE", 0), "Size in bytes of the kernel send buffer for client connections (0 for the system default)")
+	runCommand.cmd.Flags().IntVar(&globalConfig.AccessLogBufferSize, "access-log-buffer-size", getEnvInt("ACCESS_LOG_BUFFER_SIZE", 0), "Bytes of access log lines to buffer between writes, dropping lines when it's full (0 to write each line as it's logged)")
+	runCommand.cmd.Flags().DurationVar(&globalConfig.AccessLogFlushInterval, "access-log-flush-interval", server.DefaultAccessLogFlushInterval, "Interval between writes of buffered access log lines")
+	runCommand.cmd.Flags().StringVar(&globalConfig.ErrorReportingDSN, "error-reporting-dsn", getEnvString("ERROR_REPORTING_DSN", ""), "Sentry DSN to report panics and proxy errors to (empty to disable)")
+	runCommand.cmd.Flags().StringVar(&globalConfig.DNSFailoverURL, "dns-failover-url", getEnvString("DNS_FAILOVER_URL", ""), "DNS provider for failing services over to their standby host (cloudflare://<token>@<zone-id>, or an http(s) webhook; empty to disable)")
+	runCommand.cmd.Flags().DurationVar(&globalConfig.DNSFailoverInterval, "dns-failover-interval", server.DefaultDNSFailoverInterval, "Interval between health checks of services with a failover address")
+	runCommand.cmd.Flags().StringVar(&globalConfig.HALeaseDir, "ha-lease-dir", getEnvString("HA_LEASE_DIR", ""), "Directory shared with another proxy, for running as an active-standby pair (empty to disable)")
+	runCommand.cmd.Flags().StringVar(&globalConfig.HANodeID, "ha-node-id", getEnvString("HA_NODE_ID", ""), "Name of this proxy in the HA lease (defaults to the hostname)")
+	runCommand.cmd.Flags().DurationVar(&globalConfig.HALeaseDuration, "ha-lease-duration", server.DefaultHALeaseDuration, "How long the active proxy's lease lasts without being renewed")
+	runCommand.cmd.Flags().StringVar(&globalConfig.HAHealthPath, "ha-health-path", server.DefaultHAHealthPath, "Path that answers with 200 on the active proxy and 503 on the standby")
+	runCommand.cmd.Flags().StringVar(&globalConfig.CommandSocketGroup, "socket-group", getEnvString("SOCKET_GROUP", ""), "Group (name or ID) to give the command socket, so that its members can run commands")
+	runCommand.cmd.Flags().StringVar(&runCommand.socketMode, "socket-mode", getEnvString("SOCKET_MODE", ""), "File mode to give the command socket, in octal, such as 0660 (empty to leave it as created)")
+	runCommand.cmd.Flags().BoolVar(&runCommand.ignoreState, "ignore-state", getEnvBool("IGNORE_STATE", false), "Start without restoring the saved state (it will be replaced by the next change)")
 
 	return runCommand
 }
@@ -34,8 +73,30 @@ func newRunCommand() *runCommand {
 func (c *runCommand) run(cmd *cobra.Command, args []string) error {
 	c.setLogger()
 
+	if c.socketMode != "" {
+		mode, err := strconv.ParseUint(c.socketMode, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("invalid socket mode %q (must be octal, such as 0660)", c.socketMode)
+		}
+		globalConfig.CommandSocketMode = os.FileMode(mode)
+	}
+
+	globalConfig.TCPDelay = !c.tcpNoDelay
+
+	// Set before restoring the state, so that restored targets use it too.
+	if c.proxyBufferSize > 0 {
+		server.SetProxyBufferSize(int64(c.proxyBufferSize))
+	}
+
 	router := server.NewRouter(globalConfig.StatePath())
-	router.RestoreLastSavedState()
+	if c.ignoreState {
+		slog.Warn("Ignoring saved state", "path", globalConfig.StatePath())
+	} else {
+		err := router.RestoreLastSavedState()
+		if err != nil {
+			return fmt.Errorf("unable to restore state from %s: %w (use --ignore-state to start without it)", globalConfig.StatePath(), err)
+		}
+	}
 
 	s := server.NewServer(&globalConfig, router)
 	err := s.Start()
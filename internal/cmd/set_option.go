@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"net/rpc"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type setOptionCommand struct {
+	cmd  *cobra.Command
+	args server.DeployArgs
+}
+
+func newSetOptionCommand() *setOptionCommand {
+	setOptionCommand := &setOptionCommand{}
+	setOptionCommand.cmd = &cobra.Command{
+		Use:   "set-option <service> <option> <value>",
+		Short: "Change one of a service's options, named as in the state (such as health_check_config.path), keeping the others",
+		RunE:  setOptionCommand.run,
+		Args:  cobra.ExactArgs(3),
+	}
+
+	setOptionCommand.cmd.Flags().DurationVar(&setOptionCommand.args.DeployTimeout, "deploy-timeout", server.DefaultDeployTimeout, "Maximum time to wait for the target to become healthy, if its options change")
+	setOptionCommand.cmd.Flags().DurationVar(&setOptionCommand.args.DrainTimeout, "drain-timeout", server.DefaultDrainTimeout, "Maximum time to allow existing connections to drain, if the target's options change")
+
+	return setOptionCommand
+}
+
+func (c *setOptionCommand) run(cmd *cobra.Command, args []string) error {
+	service, name, value := args[0], args[1], args[2]
+
+	return updateDeployedOptions(service, c.args, func(deployed *server.DeployedOptions) error {
+		return deployed.SetOption(name, value)
+	})
+}
+
+// updateDeployedOptions deploys a service again with its current options,
+// after they've been changed by update. Targets are only deployed again when
+// their own options change.
+func updateDeployedOptions(service string, args server.DeployArgs, update func(deployed *server.DeployedOptions) error) error {
+	deployed, found, err := fetchDeployedOptions(service)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s: %w", service, server.ErrorServiceNotFound)
+	}
+
+	err = update(&deployed)
+	if err != nil {
+		return err
+	}
+
+	args.Service = service
+	args.TargetURL = deployed.Target
+	args.Hosts = deployed.Hosts
+	args.ServiceOptions = deployed.Options
+	args.TargetOptions = deployed.TargetOptions
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.DeployResponse
+		err := client.Call("kamal-proxy.Deploy", args, &response)
+		if err != nil {
+			return err
+		}
+
+		reportDeployResult(service, response.Result)
+		return nil
+	})
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type stateCommand struct {
+	cmd *cobra.Command
+}
+
+func newStateCommand() *stateCommand {
+	stateCommand := &stateCommand{}
+	stateCommand.cmd = &cobra.Command{
+		Use:   "state",
+		Short: "Export, import and restore the proxy's services",
+	}
+
+	stateCommand.cmd.AddCommand(newStateExportCommand().cmd)
+	stateCommand.cmd.AddCommand(newStateImportCommand().cmd)
+	stateCommand.cmd.AddCommand(newStateBackupsCommand().cmd)
+	stateCommand.cmd.AddCommand(newStateRestoreCommand().cmd)
+
+	return stateCommand
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"net/rpc"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type stateBackupsCommand struct {
+	cmd *cobra.Command
+}
+
+func newStateBackupsCommand() *stateBackupsCommand {
+	stateBackupsCommand := &stateBackupsCommand{}
+	stateBackupsCommand.cmd = &cobra.Command{
+		Use:   "backups",
+		Short: "List the state backups that can be restored",
+		RunE:  stateBackupsCommand.run,
+		Args:  cobra.NoArgs,
+	}
+
+	return stateBackupsCommand
+}
+
+func (c *stateBackupsCommand) run(cmd *cobra.Command, args []string) error {
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.StateBackupsResponse
+
+		err := client.Call("kamal-proxy.StateBackups", true, &response)
+		if err != nil {
+			return err
+		}
+
+		for _, timestamp := range response.Backups {
+			fmt.Println(timestamp)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"net/rpc"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type stateExportCommand struct {
+	cmd    *cobra.Command
+	output string
+}
+
+func newStateExportCommand() *stateExportCommand {
+	stateExportCommand := &stateExportCommand{}
+	stateExportCommand.cmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export a snapshot of all services and their options as JSON",
+		RunE:  stateExportCommand.run,
+		Args:  cobra.NoArgs,
+	}
+
+	stateExportCommand.cmd.Flags().StringVarP(&stateExportCommand.output, "output", "o", "", "File to write the snapshot to (defaults to standard output)")
+
+	return stateExportCommand
+}
+
+func (c *stateExportCommand) run(cmd *cobra.Command, args []string) error {
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.StateExportResponse
+
+		err := client.Call("kamal-proxy.StateExport", true, &response)
+		if err != nil {
+			return err
+		}
+
+		data := append(response.Data, '\n')
+		if c.output == "" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		return os.WriteFile(c.output, data, 0600)
+	})
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"io"
+	"net/rpc"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type stateImportCommand struct {
+	cmd  *cobra.Command
+	args server.StateImportArgs
+}
+
+func newStateImportCommand() *stateImportCommand {
+	stateImportCommand := &stateImportCommand{}
+	stateImportCommand.cmd = &cobra.Command{
+		Use:   "import [file]",
+		Short: "Deploy the services from a snapshot made by export",
+		Long: "Deploy the services from a snapshot made by export, reading it from standard input if no file is given.\n" +
+			"The snapshot is checked before any services are deployed, and each target must pass its health checks before it receives traffic.",
+		RunE: stateImportCommand.run,
+		Args: cobra.MaximumNArgs(1),
+	}
+
+	stateImportCommand.cmd.Flags().DurationVar(&stateImportCommand.args.DeployTimeout, "deploy-timeout", server.DefaultDeployTimeout, "Maximum time to wait for each target to become healthy")
+	stateImportCommand.cmd.Flags().DurationVar(&stateImportCommand.args.DrainTimeout, "drain-timeout", server.DefaultDrainTimeout, "Maximum time to allow existing connections to drain before replacing targets")
+
+	return stateImportCommand
+}
+
+func (c *stateImportCommand) run(cmd *cobra.Command, args []string) error {
+	var err error
+	if len(args) == 1 {
+		c.args.Data, err = os.ReadFile(args[0])
+	} else {
+		c.args.Data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response bool
+		return client.Call("kamal-proxy.StateImport", c.args, &response)
+	})
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"net/rpc"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type stateRestoreCommand struct {
+	cmd  *cobra.Command
+	args server.StateRestoreArgs
+}
+
+func newStateRestoreCommand() *stateRestoreCommand {
+	stateRestoreCommand := &stateRestoreCommand{}
+	stateRestoreCommand.cmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Return the services to a state backup",
+		Long: "Return the services to a state backup. The services in the backup are deployed as with import,\n" +
+			"and once they are all healthy, any services that are not in the backup are removed.",
+		RunE: stateRestoreCommand.run,
+		Args: cobra.NoArgs,
+	}
+
+	stateRestoreCommand.cmd.Flags().StringVar(&stateRestoreCommand.args.From, "from", "", "Timestamp of the backup to restore (see state backups)")
+	stateRestoreCommand.cmd.Flags().DurationVar(&stateRestoreCommand.args.DeployTimeout, "deploy-timeout", server.DefaultDeployTimeout, "Maximum time to wait for each target to become healthy")
+	stateRestoreCommand.cmd.Flags().DurationVar(&stateRestoreCommand.args.DrainTimeout, "drain-timeout", server.DefaultDrainTimeout, "Maximum time to allow existing connections to drain before replacing targets")
+	stateRestoreCommand.cmd.MarkFlagRequired("from")
+
+	return stateRestoreCommand
+}
+
+func (c *stateRestoreCommand) run(cmd *cobra.Command, args []string) error {
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response bool
+		return client.Call("kamal-proxy.StateRestore", c.args, &response)
+	})
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type templateCommand struct {
+	cmd *cobra.Command
+}
+
+func newTemplateCommand() *templateCommand {
+	templateCommand := &templateCommand{}
+	templateCommand.cmd = &cobra.Command{
+		Use:   "template",
+		Short: "Manage templates of deploy options",
+	}
+
+	templateCommand.cmd.AddCommand(newTemplateCreateCommand().cmd)
+	templateCommand.cmd.AddCommand(newTemplateRemoveCommand().cmd)
+	templateCommand.cmd.AddCommand(newTemplateListCommand().cmd)
+
+	return templateCommand
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"net/rpc"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type templateCreateCommand struct {
+	cmd  *cobra.Command
+	args server.TemplateCreateArgs
+}
+
+func newTemplateCreateCommand() *templateCreateCommand {
+	templateCreateCommand := &templateCreateCommand{}
+	templateCreateCommand.cmd = &cobra.Command{
+		Use:   "create <name> [deploy flags]",
+		Short: "Create or replace a template of deploy options",
+		Long: "Create or replace a template of deploy options, which deploys can use with --template <name>.\n" +
+			"The template accepts any of the flags that deploy does.",
+		Example:            "  kamal-proxy template create web --tls --log-request-header X-Request-Start",
+		RunE:               templateCreateCommand.run,
+		Args:               cobra.MinimumNArgs(1),
+		DisableFlagParsing: true,
+	}
+
+	return templateCreateCommand
+}
+
+func (c *templateCreateCommand) run(cmd *cobra.Command, args []string) error {
+	if args[0] == "-h" || args[0] == "--help" {
+		return cmd.Help()
+	}
+
+	c.args.Name = args[0]
+	c.args.Args = args[1:]
+
+	// Ensure the options are valid before storing them.
+	deploy := newDeployCommand()
+	err := deploy.cmd.ParseFlags(c.args.Args)
+	if err != nil {
+		return err
+	}
+	if deploy.cmd.Flags().NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", deploy.cmd.Flags().Args())
+	}
+	if slices.ContainsFunc(c.args.Args, isTemplateFlag) {
+		return fmt.Errorf("templates can't refer to other templates")
+	}
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response bool
+		return client.Call("kamal-proxy.TemplateCreate", c.args, &response)
+	})
+}
+
+func isTemplateFlag(arg string) bool {
+	return arg == "--template" || strings.HasPrefix(arg, "--template=")
+}
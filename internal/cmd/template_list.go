@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"maps"
+	"net/rpc"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type templateListCommand struct {
+	cmd *cobra.Command
+}
+
+func newTemplateListCommand() *templateListCommand {
+	templateListCommand := &templateListCommand{}
+	templateListCommand.cmd = &cobra.Command{
+		Use:     "list",
+		Short:   "List templates",
+		RunE:    templateListCommand.run,
+		Args:    cobra.NoArgs,
+		Aliases: []string{"ls"},
+	}
+
+	return templateListCommand
+}
+
+func (c *templateListCommand) run(cmd *cobra.Command, args []string) error {
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.TemplateListResponse
+
+		err := client.Call("kamal-proxy.TemplateList", true, &response)
+		if err != nil {
+			return err
+		}
+
+		table := NewTable()
+		table.AddRow([]string{"Template", "Options"})
+		for _, name := range slices.Sorted(maps.Keys(response.Templates)) {
+			table.AddRow([]string{name, strings.Join(response.Templates[name], " ")})
+		}
+		table.Print()
+
+		return nil
+	})
+}
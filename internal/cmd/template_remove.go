@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"net/rpc"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type templateRemoveCommand struct {
+	cmd  *cobra.Command
+	args server.TemplateRemoveArgs
+}
+
+func newTemplateRemoveCommand() *templateRemoveCommand {
+	templateRemoveCommand := &templateRemoveCommand{}
+	templateRemoveCommand.cmd = &cobra.Command{
+		Use:     "remove <name>",
+		Short:   "Remove a template",
+		RunE:    templateRemoveCommand.run,
+		Args:    cobra.ExactArgs(1),
+		Aliases: []string{"rm"},
+	}
+
+	return templateRemoveCommand
+}
+
+func (c *templateRemoveCommand) run(cmd *cobra.Command, args []string) error {
+	var response bool
+
+	c.args.Name = args[0]
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		return client.Call("kamal-proxy.TemplateRemove", c.args, &response)
+	})
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+type tenantCommand struct {
+	cmd *cobra.Command
+}
+
+func newTenantCommand() *tenantCommand {
+	tenantCommand := &tenantCommand{}
+	tenantCommand.cmd = &cobra.Command{
+		Use:   "tenant",
+		Short: "Manage the targets of individual tenants",
+	}
+
+	tenantCommand.cmd.AddCommand(newTenantSetCommand().cmd)
+	tenantCommand.cmd.AddCommand(newTenantRemoveCommand().cmd)
+	tenantCommand.cmd.AddCommand(newTenantListCommand().cmd)
+
+	return tenantCommand
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"maps"
+	"net/rpc"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type tenantListCommand struct {
+	cmd  *cobra.Command
+	args server.TenantListArgs
+}
+
+func newTenantListCommand() *tenantListCommand {
+	tenantListCommand := &tenantListCommand{}
+	tenantListCommand.cmd = &cobra.Command{
+		Use:       "list <service>",
+		Short:     "List the tenants that have their own target",
+		RunE:      tenantListCommand.run,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"service"},
+		Aliases:   []string{"ls"},
+	}
+
+	return tenantListCommand
+}
+
+func (c *tenantListCommand) run(cmd *cobra.Command, args []string) error {
+	c.args.Service = args[0]
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response server.TenantListResponse
+
+		err := client.Call("kamal-proxy.TenantList", c.args, &response)
+		if err != nil {
+			return err
+		}
+
+		table := NewTable()
+		table.AddRow([]string{"Tenant", "Target"})
+		for _, tenant := range slices.Sorted(maps.Keys(response.Tenants)) {
+			table.AddRow([]string{tenant, response.Tenants[tenant]})
+		}
+		table.Print()
+
+		return nil
+	})
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"net/rpc"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+type tenantRemoveCommand struct {
+	cmd  *cobra.Command
+	args server.TenantRemoveArgs
+}
+
+func newTenantRemoveCommand() *tenantRemoveCommand {
+	tenantRemoveCommand := &tenantRemoveCommand{}
+	tenantRemoveCommand.cmd = &cobra.Command{
+		Use:       "remove <service> <tenant>",
+		Short:     "Send a tenant's requests back to the service's target",
+		RunE:      tenantRemoveCommand.run,
+		Args:      cobra.ExactArgs(2),
+		ValidArgs: []string{"service", "tenant"},
+		Aliases:   []string{"rm"},
+	}
+
+	tenantRemoveCommand.cmd.Flags().DurationVar(&tenantRemoveCommand.args.DrainTimeout, "drain-timeout", server.DefaultDrainTimeout, "Maximum time to allow existing connections to drain before removing the tenant's target")
+
+	return tenantRemoveCommand
+}
+
+func (c *tenantRemoveCommand) run(cmd *cobra.Command, args []string) error {
+	c.args.Service = args[0]
+	c.args.Tenant = args[1]
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response bool
+		return client.Call("kamal-proxy.TenantRemove", c.args, &response)
+	})
+}
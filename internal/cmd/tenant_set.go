@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"net/rpc"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+	"github.com/spf13/cobra"
+)
+
+type tenantSetCommand struct {
+	cmd  *cobra.Command
+	args server.TenantSetArgs
+}
+
+func newTenantSetCommand() *tenantSetCommand {
+	tenantSetCommand := &tenantSetCommand{}
+	tenantSetCommand.cmd = &cobra.Command{
+		Use:       "set <service> <tenant>",
+		Short:     "Deploy a target for a tenant's requests",
+		RunE:      tenantSetCommand.run,
+		Args:      cobra.ExactArgs(2),
+		ValidArgs: []string{"service", "tenant"},
+	}
+
+	tenantSetCommand.cmd.Flags().StringVar(&tenantSetCommand.args.TargetURL, "target", "", "Target host to deploy")
+	tenantSetCommand.cmd.Flags().DurationVar(&tenantSetCommand.args.DeployTimeout, "deploy-timeout", server.DefaultDeployTimeout, "Maximum time to wait for the new target to become healthy")
+	tenantSetCommand.cmd.Flags().DurationVar(&tenantSetCommand.args.DrainTimeout, "drain-timeout", server.DefaultDrainTimeout, "Maximum time to allow existing connections to drain before removing the tenant's old target")
+
+	tenantSetCommand.cmd.MarkFlagRequired("target")
+
+	return tenantSetCommand
+}
+
+func (c *tenantSetCommand) run(cmd *cobra.Command, args []string) error {
+	c.args.Service = args[0]
+	c.args.Tenant = args[1]
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		var response bool
+		return client.Call("kamal-proxy.TenantSet", c.args, &response)
+	})
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type topCommand struct {
+	cmd      *cobra.Command
+	args     server.TopArgs
+	interval time.Duration
+	once     bool
+}
+
+func newTopCommand() *topCommand {
+	topCommand := &topCommand{}
+	topCommand.cmd = &cobra.Command{
+		Use:   "top [service]",
+		Short: "Show live request statistics for services",
+		RunE:  topCommand.run,
+		Args:  cobra.MaximumNArgs(1),
+	}
+
+	topCommand.cmd.Flags().DurationVar(&topCommand.interval, "interval", 2*time.Second, "How often to refresh the statistics")
+	topCommand.cmd.Flags().BoolVar(&topCommand.once, "once", false, "Print the statistics once and exit")
+
+	return topCommand
+}
+
+func (c *topCommand) run(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		c.args.Service = args[0]
+	}
+
+	return withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		if c.once {
+			return c.refresh(client)
+		}
+
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			fmt.Print("\033[H\033[2J")
+			err := c.refresh(client)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-ch:
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (c *topCommand) refresh(client *rpc.Client) error {
+	var response server.TopResponse
+
+	err := client.Call("kamal-proxy.Top", c.args, &response)
+	if err != nil {
+		return err
+	}
+
+	c.displayResponse(response)
+	return nil
+}
+
+func (c *topCommand) displayResponse(response server.TopResponse) {
+	table := NewTable()
+	table.AddRow([]string{"Service", "RPS", "p50", "p95", "p99", "1xx", "2xx", "3xx", "4xx", "5xx", "Conns", "In", "Out", "Budget", "Top paths"})
+
+	sortedKeys := slices.Sorted(maps.Keys(response.Services))
+	for _, name := range sortedKeys {
+		stats := response.Services[name]
+
+		paths := []string{}
+		for _, path := range stats.TopPaths {
+			paths = append(paths, fmt.Sprintf("%s (%d)", path.Path, path.Count))
+		}
+
+		row := []string{
+			name,
+			fmt.Sprintf("%.1f", stats.RPS),
+			formatLatency(stats.P50),
+			formatLatency(stats.P95),
+			formatLatency(stats.P99),
+		}
+		for _, count := range stats.StatusClasses {
+			row = append(row, fmt.Sprintf("%d", count))
+		}
+		row = append(row,
+			fmt.Sprintf("%d", stats.Transfer.ActiveConnections),
+			formatBytes(stats.Transfer.BytesIn),
+			formatBytes(stats.Transfer.BytesOut),
+			formatBudget(stats.SLO),
+			strings.Join(paths, ", "),
+		)
+
+		table.AddRow(row)
+	}
+
+	table.Print()
+	fmt.Printf("\nStatistics cover the last %s; In and Out are totals since the proxy started\n", server.ServiceStatsWindow)
+	fmt.Printf("Budget is the smallest error budget remaining over each service's SLO window\n")
+}
+
+func formatBudget(slo *server.SLOSnapshot) string {
+	if slo == nil {
+		return "-"
+	}
+
+	remaining := 1.0
+	for _, objective := range []*server.SLOObjectiveSnapshot{slo.Availability, slo.Latency} {
+		if objective != nil {
+			remaining = min(remaining, objective.BudgetRemaining)
+		}
+	}
+	return fmt.Sprintf("%.0f%%", remaining*100)
+}
+
+func formatLatency(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(100 * time.Microsecond).String()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
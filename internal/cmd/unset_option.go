@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type unsetOptionCommand struct {
+	cmd  *cobra.Command
+	args server.DeployArgs
+}
+
+func newUnsetOptionCommand() *unsetOptionCommand {
+	unsetOptionCommand := &unsetOptionCommand{}
+	unsetOptionCommand.cmd = &cobra.Command{
+		Use:   "unset-option <service> <option>...",
+		Short: "Return some of a service's options to their defaults, keeping the others",
+		RunE:  unsetOptionCommand.run,
+		Args:  cobra.MinimumNArgs(2),
+	}
+
+	unsetOptionCommand.cmd.Flags().DurationVar(&unsetOptionCommand.args.DeployTimeout, "deploy-timeout", server.DefaultDeployTimeout, "Maximum time to wait for the target to become healthy, if its options change")
+	unsetOptionCommand.cmd.Flags().DurationVar(&unsetOptionCommand.args.DrainTimeout, "drain-timeout", server.DefaultDrainTimeout, "Maximum time to allow existing connections to drain, if the target's options change")
+
+	return unsetOptionCommand
+}
+
+func (c *unsetOptionCommand) run(cmd *cobra.Command, args []string) error {
+	service, names := args[0], args[1:]
+
+	// The defaults are those that a deploy would use.
+	defaults := newDeployCommand().args
+	defaultOptions := server.DeployedOptions{
+		Hosts:         defaults.Hosts,
+		Options:       defaults.ServiceOptions,
+		TargetOptions: defaults.TargetOptions,
+	}
+
+	return updateDeployedOptions(service, c.args, func(deployed *server.DeployedOptions) error {
+		for _, name := range names {
+			err := deployed.UnsetOption(name, defaultOptions)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -1,9 +1,19 @@
 package cmd
 
 import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
 	"net/rpc"
 	"os"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
 )
 
 const (
@@ -19,6 +29,71 @@ func withRPCClient(socketPath string, fn func(client *rpc.Client) error) error {
 	return fn(client)
 }
 
+// forEachService calls fn for the named service or, when the name is a glob
+// pattern (such as review-*) or all is set, for every matching service. Bulk
+// operations are confirmed first, unless yes is set.
+func forEachService(client *rpc.Client, name string, all bool, yes bool, action string, fn func(name string) error) error {
+	if !all && !strings.ContainsAny(name, "*?[") {
+		return fn(name)
+	}
+
+	pattern := name
+	if all {
+		pattern = "*"
+	}
+
+	names, err := matchingServices(client, pattern)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no services match %s", pattern)
+	}
+
+	if !yes && !confirm(fmt.Sprintf("%s %d service(s): %s?", action, len(names), strings.Join(names, ", "))) {
+		return errors.New("cancelled")
+	}
+
+	var errs []error
+	for _, name := range names {
+		err := fn(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		} else {
+			fmt.Printf("%s: done\n", name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func matchingServices(client *rpc.Client, pattern string) ([]string, error) {
+	var response server.ListResponse
+	err := client.Call("kamal-proxy.List", true, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, name := range slices.Sorted(maps.Keys(response.Targets)) {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func confirm(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 func findEnv(key string) (string, bool) {
 	value, ok := os.LookupEnv(ENV_PREFIX + key)
 	if ok {
@@ -33,6 +108,15 @@ func findEnv(key string) (string, bool) {
 	return "", false
 }
 
+func getEnvString(key string, defaultValue string) string {
+	value, ok := findEnv(key)
+	if !ok {
+		return defaultValue
+	}
+
+	return value
+}
+
 func getEnvInt(key string, defaultValue int) int {
 	value, ok := findEnv(key)
 	if !ok {
@@ -60,3 +144,31 @@ func getEnvBool(key string, defaultValue bool) bool {
 
 	return boolValue
 }
+
+// fetchDeployedOptions gets the target, hosts and options that a service is
+// deployed with, reporting whether it has been deployed.
+func fetchDeployedOptions(service string) (server.DeployedOptions, bool, error) {
+	var response server.DeployedOptionsResponse
+	err := withRPCClient(globalConfig.SocketPath(), func(client *rpc.Client) error {
+		return client.Call("kamal-proxy.DeployedOptions", server.DeployedOptionsArgs{Service: service}, &response)
+	})
+	if err != nil && err.Error() == server.ErrorServiceNotFound.Error() {
+		return server.DeployedOptions{}, false, nil
+	}
+
+	return response.Deployed, err == nil, err
+}
+
+func reportDeployResult(service string, result server.DeployResult) {
+	if result.Unchanged {
+		fmt.Printf("No changes to deploy for %s (use --force to deploy anyway)\n", service)
+		return
+	}
+
+	if len(result.Changes) > 0 {
+		fmt.Printf("Changed options for %s:\n", service)
+		for _, change := range result.Changes {
+			fmt.Printf("  %s: %s -> %s\n", change.Option, cmp.Or(change.From, "(unset)"), cmp.Or(change.To, "(unset)"))
+		}
+	}
+}
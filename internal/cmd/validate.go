@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/basecamp/kamal-proxy/internal/server"
+)
+
+type validateCommand struct {
+	cmd        *cobra.Command
+	configPath string
+	format     string
+	skipDNS    bool
+	addresses  []string
+	strict     bool
+}
+
+func newValidateCommand() *validateCommand {
+	validateCommand := &validateCommand{}
+	validateCommand.cmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Check a config of services for problems",
+		Long: "Check a config of services for problems, exiting with an error if any are found.\n" +
+			"The config is a list of services in the same form as the output of state export, written as YAML or JSON.",
+		RunE:    validateCommand.run,
+		PreRunE: validateCommand.preRun,
+		Args:    cobra.NoArgs,
+	}
+
+	validateCommand.cmd.Flags().StringVar(&validateCommand.configPath, "config", "", "Config file to check")
+	validateCommand.cmd.Flags().StringVar(&validateCommand.format, "format", "text", "Format of the findings (text or json)")
+	validateCommand.cmd.Flags().BoolVar(&validateCommand.skipDNS, "skip-dns", false, "Don't check that TLS hosts resolve to this host")
+	validateCommand.cmd.Flags().StringSliceVar(&validateCommand.addresses, "address", nil, "Address that TLS hosts should resolve to (defaults to the addresses of this host)")
+	validateCommand.cmd.Flags().BoolVar(&validateCommand.strict, "strict", false, "Fail on warnings as well as errors")
+	validateCommand.cmd.MarkFlagRequired("config")
+
+	return validateCommand
+}
+
+func (c *validateCommand) preRun(cmd *cobra.Command, args []string) error {
+	if c.format != "text" && c.format != "json" {
+		return fmt.Errorf("unknown format %q (must be text or json)", c.format)
+	}
+	return nil
+}
+
+func (c *validateCommand) run(cmd *cobra.Command, args []string) error {
+	data, err := os.ReadFile(c.configPath)
+	if err != nil {
+		return err
+	}
+
+	options := server.ConfigValidationOptions{CheckDNS: !c.skipDNS, LocalAddresses: c.addresses}
+	if options.CheckDNS && len(options.LocalAddresses) == 0 {
+		options.LocalAddresses, err = localAddresses()
+		if err != nil {
+			return err
+		}
+	}
+
+	findings, err := server.ValidateConfig(data, options)
+	if err != nil {
+		return err
+	}
+
+	err = c.print(findings)
+	if err != nil {
+		return err
+	}
+
+	errorCount, warningCount := 0, 0
+	for _, finding := range findings {
+		if finding.Severity == server.ConfigFindingError {
+			errorCount++
+		} else {
+			warningCount++
+		}
+	}
+
+	if errorCount > 0 || (c.strict && warningCount > 0) {
+		return fmt.Errorf("%s is not valid: %d errors, %d warnings", c.configPath, errorCount, warningCount)
+	}
+	return nil
+}
+
+func (c *validateCommand) print(findings []server.ConfigFinding) error {
+	if c.format == "json" {
+		return json.NewEncoder(os.Stdout).Encode(findings)
+	}
+
+	for _, finding := range findings {
+		service := finding.Service
+		if service == "" {
+			service = "-"
+		}
+		fmt.Printf("%s: %s: %s: %s\n", finding.Severity, service, finding.Check, finding.Message)
+	}
+	return nil
+}
+
+func localAddresses() ([]string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			addresses = append(addresses, ipnet.IP.String())
+		}
+	}
+	return addresses, nil
+}
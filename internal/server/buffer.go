@@ -7,11 +7,22 @@ import (
 	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
+// maxPooledBufferCapacity is the largest memory buffer that is kept for
+// reuse. Larger ones are left for the garbage collector, so that a few large
+// requests don't leave the pool holding on to a lot of memory.
+const maxPooledBufferCapacity = DefaultMaxMemoryBufferSize
+
+var bufferPool = sync.Pool{
+	New: func() any { return &Buffer{} },
+}
+
 var (
 	ErrMaximumSizeExceeded = errors.New("maximum size exceeded")
 	ErrWriteAfterRead      = errors.New("write after read")
+	ErrNotReplayable       = errors.New("buffer is not replayable")
 )
 
 type Buffer struct {
@@ -25,17 +36,21 @@ type Buffer struct {
 	overflowed       bool
 	reader           io.Reader
 	closeOnce        sync.Once
+
+	// Buffers come from a pool, and go back to it once they have been both
+	// closed and released, and all of the readers from NewReader have been
+	// closed. Until then, something may still be reading their memory.
+	refs     atomic.Int32
+	released atomic.Bool
 }
 
 func NewBufferedReadCloser(r io.ReadCloser, maxBytes, maxMemBytes int64) (io.ReadCloser, error) {
-	buf := &Buffer{
-		maxBytes:    maxBytes,
-		maxMemBytes: maxMemBytes,
-	}
+	buf := NewBufferedWriteCloser(maxBytes, maxMemBytes)
 
 	_, err := io.Copy(buf, r)
 	if err != nil {
 		buf.Close()
+		buf.Release()
 		return nil, err
 	}
 
@@ -43,10 +58,11 @@ func NewBufferedReadCloser(r io.ReadCloser, maxBytes, maxMemBytes int64) (io.Rea
 }
 
 func NewBufferedWriteCloser(maxBytes, maxMemBytes int64) *Buffer {
-	return &Buffer{
-		maxBytes:    maxBytes,
-		maxMemBytes: maxMemBytes,
-	}
+	b := bufferPool.Get().(*Buffer)
+	b.maxBytes = maxBytes
+	b.maxMemBytes = maxMemBytes
+	b.refs.Store(2)
+	return b
 }
 
 func (b *Buffer) Write(p []byte) (int, error) {
@@ -90,24 +106,96 @@ func (b *Buffer) Read(p []byte) (n int, err error) {
 	return b.reader.Read(p)
 }
 
+// Replayable reports whether the buffer's content can be read again using
+// NewReader, which is only possible when it was small enough to be held in
+// memory.
+func (b *Buffer) Replayable() bool {
+	return b.diskBuffer == nil && !b.overflowed
+}
+
+// NewReader returns a reader over the whole content of a replayable buffer,
+// which is independent of any other reads from it.
+func (b *Buffer) NewReader() (io.ReadCloser, error) {
+	if !b.Replayable() {
+		return nil, ErrNotReplayable
+	}
+
+	b.refs.Add(1)
+	return &bufferReader{Reader: bytes.NewReader(b.memoryBuffer.Bytes()), buffer: b}, nil
+}
+
+// Size returns the length of the buffer's content.
+func (b *Buffer) Size() int64 {
+	return b.memBytesWritten + b.diskBytesWritten
+}
+
+// ReadAt reads the buffer's content from any offset, whether it's held in
+// memory or has been spilled to disk, independently of any other reads.
+func (b *Buffer) ReadAt(p []byte, off int64) (int, error) {
+	memory := b.memoryBuffer.Bytes()
+
+	n := 0
+	if off < int64(len(memory)) {
+		n = copy(p, memory[off:])
+	}
+	if n == len(p) {
+		return n, nil
+	}
+	if b.diskBuffer == nil {
+		return n, io.EOF
+	}
+
+	diskRead, err := b.diskBuffer.ReadAt(p[n:], off+int64(n)-int64(len(memory)))
+	return n + diskRead, err
+}
+
 func (b *Buffer) Overflowed() bool {
 	return b.overflowed
 }
 
+// Send writes the buffer's content to w. Anything that was spilled to disk is
+// given to w as the file itself, so that when w can read from it directly, it
+// can be sent with sendfile rather than being copied through memory.
 func (b *Buffer) Send(w io.Writer) error {
-	b.setReader()
-	_, err := io.Copy(w, b.reader)
+	if b.reader != nil || b.diskBuffer == nil {
+		b.setReader()
+		_, err := io.Copy(w, b.reader)
+		return err
+	}
+
+	_, err := w.Write(b.memoryBuffer.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = b.diskBuffer.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	b.reader = b.diskBuffer
+
+	_, err = readFrom(w, b.diskBuffer)
 	return err
 }
 
 func (b *Buffer) Close() error {
 	b.closeOnce.Do(func() {
 		b.discardSpill()
+		b.unref()
 	})
 
 	return nil
 }
 
+// Release is called by the buffer's owner once it has finished with it, so
+// that it can be reused after it has been closed. Buffers that are never
+// released are simply left for the garbage collector.
+func (b *Buffer) Release() {
+	if b.released.CompareAndSwap(false, true) {
+		b.unref()
+	}
+}
+
 func (b *Buffer) writeToMemory(p []byte) (int, error) {
 	n, err := b.memoryBuffer.Write(p)
 	b.memBytesWritten += int64(n)
@@ -124,13 +212,30 @@ func (b *Buffer) setReader() {
 	if b.reader == nil {
 		if b.diskBuffer != nil {
 			b.diskBuffer.Seek(0, 0)
-			b.reader = io.MultiReader(&b.memoryBuffer, b.diskBuffer)
+			b.reader = io.MultiReader(bytes.NewReader(b.memoryBuffer.Bytes()), b.diskBuffer)
 		} else {
-			b.reader = &b.memoryBuffer
+			b.reader = bytes.NewReader(b.memoryBuffer.Bytes())
 		}
 	}
 }
 
+func (b *Buffer) unref() {
+	if b.refs.Add(-1) == 0 {
+		b.reset()
+		bufferPool.Put(b)
+	}
+}
+
+func (b *Buffer) reset() {
+	memoryBuffer := b.memoryBuffer
+	memoryBuffer.Reset()
+	if int64(memoryBuffer.Cap()) > maxPooledBufferCapacity {
+		memoryBuffer = bytes.Buffer{}
+	}
+
+	*b = Buffer{memoryBuffer: memoryBuffer}
+}
+
 func (b *Buffer) createSpill() error {
 	f, err := os.CreateTemp("", "proxy-buffer-")
 	if err != nil {
@@ -155,3 +260,16 @@ func (b *Buffer) discardSpill() {
 		}
 	}
 }
+
+// bufferReader is a reader over a replayable buffer's memory, which keeps the
+// buffer from being reused until it is closed.
+type bufferReader struct {
+	*bytes.Reader
+	buffer    *Buffer
+	closeOnce sync.Once
+}
+
+func (r *bufferReader) Close() error {
+	r.closeOnce.Do(r.buffer.unref)
+	return nil
+}
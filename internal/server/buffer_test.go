@@ -1,7 +1,9 @@
 package server
 
 import (
+	"bytes"
 	"io"
+	"os"
 	"strings"
 	"testing"
 
@@ -91,3 +93,112 @@ func TestBufferedWriteCloser_NothingWritten(t *testing.T) {
 
 	assert.Empty(t, result.String())
 }
+
+func TestBufferedWriteCloser_SendsSpillAsFile(t *testing.T) {
+	bwc := NewBufferedWriteCloser(0, 5)
+	defer bwc.Close()
+
+	_, err := bwc.Write([]byte("Hello, World!"))
+	require.NoError(t, err)
+
+	w := &testReaderFromWriter{}
+	require.NoError(t, bwc.Send(w))
+
+	assert.Equal(t, "Hello, World!", w.String())
+	assert.IsType(t, &os.File{}, w.source)
+}
+
+func TestBufferedReadCloser_Replay(t *testing.T) {
+	brc, err := NewBufferedReadCloser(io.NopCloser(strings.NewReader("Hello, World!")), 0, 1024)
+	require.NoError(t, err)
+	buffer := brc.(*Buffer)
+
+	require.True(t, buffer.Replayable())
+
+	result, err := io.ReadAll(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, World!", string(result))
+
+	replay, err := buffer.NewReader()
+	require.NoError(t, err)
+	result, err = io.ReadAll(replay)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, World!", string(result))
+
+	brc, err = NewBufferedReadCloser(io.NopCloser(strings.NewReader("Hello, World!")), 0, 5)
+	require.NoError(t, err)
+	defer brc.Close()
+
+	assert.False(t, brc.(*Buffer).Replayable())
+	_, err = brc.(*Buffer).NewReader()
+	assert.Equal(t, ErrNotReplayable, err)
+}
+
+func TestBuffer_ReadAtAcrossSpill(t *testing.T) {
+	bwc := NewBufferedWriteCloser(0, 5)
+	defer bwc.Close()
+
+	_, err := bwc.Write([]byte("Hello, World!"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(13), bwc.Size())
+
+	read := func(offset, length int64) string {
+		data, err := io.ReadAll(io.NewSectionReader(bwc, offset, length))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	assert.Equal(t, "Hello", read(0, 5))
+	assert.Equal(t, "lo, Wo", read(3, 6))
+	assert.Equal(t, "World", read(7, 5))
+	assert.Equal(t, "Hello, World!", read(0, 100))
+}
+
+func TestBuffer_ReusedOnlyWhenNothingIsReadingIt(t *testing.T) {
+	brc, err := NewBufferedReadCloser(io.NopCloser(strings.NewReader("Hello, World!")), 0, 1024)
+	require.NoError(t, err)
+	buffer := brc.(*Buffer)
+
+	replay, err := buffer.NewReader()
+	require.NoError(t, err)
+
+	buffer.Close()
+	buffer.Release()
+	buffer.Release()
+	assert.Equal(t, int32(1), buffer.refs.Load())
+
+	result, err := io.ReadAll(replay)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, World!", string(result))
+
+	replay.Close()
+	replay.Close()
+	assert.Equal(t, int32(0), buffer.refs.Load())
+	assert.Zero(t, buffer.memoryBuffer.Len())
+}
+
+func BenchmarkBuffer(b *testing.B) {
+	body := bytes.Repeat([]byte("a"), 64*1024)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		brc, err := NewBufferedReadCloser(io.NopCloser(bytes.NewReader(body)), 0, DefaultMaxMemoryBufferSize)
+		require.NoError(b, err)
+
+		io.Copy(io.Discard, brc)
+		brc.Close()
+		brc.(*Buffer).Release()
+	}
+}
+
+// Helpers
+
+type testReaderFromWriter struct {
+	strings.Builder
+	source io.Reader
+}
+
+func (w *testReaderFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.source = src
+	return io.Copy(&w.Builder, src)
+}
@@ -3,11 +3,19 @@ package server
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-var ErrorUnableToLoadCertificate = errors.New("unable to load certificate")
+var (
+	ErrorUnableToLoadCertificate = errors.New("unable to load certificate")
+	ErrorCertificateInUse        = errors.New("host is still served by a service")
+	ErrorInvalidCertificateHost  = errors.New("invalid host name")
+)
 
 type CertManager interface {
 	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
@@ -38,3 +46,49 @@ func (m *StaticCertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certifica
 func (m *StaticCertManager) HTTPHandler(handler http.Handler) http.Handler {
 	return handler
 }
+
+// deleteCachedCertificate removes the certificates obtained for a host from
+// an ACME cache directory, reporting whether there were any. Other ACME data,
+// like the account key, is shared by every host, and is kept.
+func deleteCachedCertificate(dir, host string) (bool, error) {
+	if host == "" || strings.ContainsAny(host, `/\`) || strings.HasPrefix(host, ".") {
+		return false, fmt.Errorf("%w: %q", ErrorInvalidCertificateHost, host)
+	}
+
+	deleted := false
+	for _, name := range []string{host, host + "+rsa"} {
+		err := os.Remove(filepath.Join(dir, name))
+		if err == nil {
+			deleted = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteAllCachedCertificates removes a host's certificates from each of the
+// ACME caches under cachePath, one for each ACME directory that's been used.
+func deleteAllCachedCertificates(cachePath, host string) (bool, error) {
+	entries, err := os.ReadDir(cachePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	deleted := false
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		found, err := deleteCachedCertificate(filepath.Join(cachePath, entry.Name()), host)
+		if err != nil {
+			return deleted, err
+		}
+		deleted = deleted || found
+	}
+	return deleted, nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultChaosDuration = 5 * time.Minute
+)
+
+var (
+	ErrorInvalidChaosSettings = errors.New("chaos requires a percentage between 1 and 100, and a latency or error status to inject")
+	ErrorInvalidChaosDuration = errors.New("chaos duration must be positive")
+)
+
+// ChaosController injects latency and/or error responses into a percentage of
+// a service's requests, until it expires.
+type ChaosController struct {
+	Percentage  int           `json:"percentage"`
+	Latency     time.Duration `json:"latency"`
+	ErrorStatus int           `json:"error_status"`
+	ExpiresAt   time.Time     `json:"expires_at"`
+}
+
+func NewChaosController(percentage int, latency time.Duration, errorStatus int, duration time.Duration) (*ChaosController, error) {
+	if percentage < 1 || percentage > 100 || (latency <= 0 && errorStatus == 0) {
+		return nil, ErrorInvalidChaosSettings
+	}
+	if errorStatus != 0 && (errorStatus < 400 || errorStatus > 599) {
+		return nil, ErrorInvalidChaosSettings
+	}
+	if duration <= 0 {
+		return nil, ErrorInvalidChaosDuration
+	}
+
+	return &ChaosController{
+		Percentage:  percentage,
+		Latency:     latency,
+		ErrorStatus: errorStatus,
+		ExpiresAt:   time.Now().Add(duration),
+	}, nil
+}
+
+func (c *ChaosController) Expired() bool {
+	return time.Now().After(c.ExpiresAt)
+}
+
+// Inject applies the chaos settings to a request. It returns true when the
+// request has been answered with an injected error, and should not be
+// proxied.
+func (c *ChaosController) Inject(w http.ResponseWriter, r *http.Request) bool {
+	if c.Expired() || rand.IntN(100) >= c.Percentage {
+		return false
+	}
+
+	if c.Latency > 0 {
+		select {
+		case <-time.After(c.Latency):
+		case <-r.Context().Done():
+			return false
+		}
+	}
+
+	if c.ErrorStatus != 0 {
+		SetErrorResponse(w, r, c.ErrorStatus, nil)
+		return true
+	}
+
+	return false
+}
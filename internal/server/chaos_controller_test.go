@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaosController_InjectsErrors(t *testing.T) {
+	cc, err := NewChaosController(100, 0, http.StatusServiceUnavailable, time.Minute)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	assert.True(t, cc.Inject(w, httptest.NewRequest(http.MethodGet, "/", nil)))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+}
+
+func TestChaosController_InjectsLatency(t *testing.T) {
+	cc, err := NewChaosController(100, 20*time.Millisecond, 0, time.Minute)
+	require.NoError(t, err)
+
+	started := time.Now()
+	assert.False(t, cc.Inject(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil)))
+	assert.GreaterOrEqual(t, time.Since(started), 20*time.Millisecond)
+}
+
+func TestChaosController_Expires(t *testing.T) {
+	cc, err := NewChaosController(100, 0, http.StatusInternalServerError, time.Minute)
+	require.NoError(t, err)
+	cc.ExpiresAt = time.Now().Add(-time.Second)
+
+	assert.True(t, cc.Expired())
+	assert.False(t, cc.Inject(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil)))
+}
+
+func TestChaosController_InvalidSettings(t *testing.T) {
+	_, err := NewChaosController(0, time.Second, 0, time.Minute)
+	assert.ErrorIs(t, err, ErrorInvalidChaosSettings)
+
+	_, err = NewChaosController(10, 0, 0, time.Minute)
+	assert.ErrorIs(t, err, ErrorInvalidChaosSettings)
+
+	_, err = NewChaosController(10, 0, http.StatusOK, time.Minute)
+	assert.ErrorIs(t, err, ErrorInvalidChaosSettings)
+}
+
+func TestChaosController_InvalidDuration(t *testing.T) {
+	_, err := NewChaosController(10, time.Second, 0, 0)
+	assert.ErrorIs(t, err, ErrorInvalidChaosDuration)
+
+	_, err = NewChaosController(10, time.Second, 0, -time.Minute)
+	assert.ErrorIs(t, err, ErrorInvalidChaosDuration)
+
+	router := testRouter(t)
+	_, target := testBackend(t, "first", http.StatusOK)
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	handler := NewCommandHandler(router, nil, nil)
+	err = handler.ChaosStart(ChaosStartArgs{Service: "service1", Percentage: 10, Latency: time.Second}, nil)
+	assert.ErrorIs(t, err, ErrorInvalidChaosDuration)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+type clientConnectionContextKey struct{}
+
+// clientConnection follows a connection from a client, so that each service
+// it sends requests to counts it once, for as long as it stays open, however
+// many requests it carries.
+type clientConnection struct {
+	lock     sync.Mutex
+	services []*ServiceTransferStats
+	closed   bool
+}
+
+func clientConnectionFromContext(ctx context.Context) *clientConnection {
+	connection, _ := ctx.Value(clientConnectionContextKey{}).(*clientConnection)
+	return connection
+}
+
+// Attach counts the connection against a service, the first time that it
+// sends the service a request.
+func (c *clientConnection) Attach(transfer *ServiceTransferStats) {
+	if c == nil || transfer == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed || slices.Contains(c.services, transfer) {
+		return
+	}
+	c.services = append(c.services, transfer)
+	transfer.ConnectionOpened()
+}
+
+// Close ends the connection for every service that it was counted against.
+func (c *clientConnection) Close() {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, transfer := range c.services {
+		transfer.ConnectionClosed()
+	}
+}
+
+// clientConnectionTracker gives each connection accepted by an HTTP server
+// a clientConnection, available from the context of its requests, and closes
+// it when the connection closes. Hijacked connections, such as WebSockets,
+// are closed when the hijacker closes them instead.
+type clientConnectionTracker struct {
+	connections sync.Map
+}
+
+func (t *clientConnectionTracker) Track(server *http.Server) {
+	server.ConnContext = t.connContext
+	server.ConnState = t.connState
+}
+
+// Private
+
+func (t *clientConnectionTracker) connContext(ctx context.Context, conn net.Conn) context.Context {
+	connection := &clientConnection{}
+	t.connections.Store(conn, connection)
+	return context.WithValue(ctx, clientConnectionContextKey{}, connection)
+}
+
+func (t *clientConnectionTracker) connState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateHijacked:
+		t.connections.Delete(conn)
+	case http.StateClosed:
+		if connection, ok := t.connections.LoadAndDelete(conn); ok {
+			connection.(*clientConnection).Close()
+		}
+	}
+}
@@ -14,6 +14,8 @@ var registered sync.Once
 type CommandHandler struct {
 	rpcListener net.Listener
 	router      *Router
+	templates   *TemplateStore
+	backups     *StateBackups
 }
 
 type DeployArgs struct {
@@ -22,14 +24,22 @@ type DeployArgs struct {
 	Hosts          []string
 	DeployTimeout  time.Duration
 	DrainTimeout   time.Duration
+	QueueTimeout   time.Duration
+	Force          bool
 	ServiceOptions ServiceOptions
 	TargetOptions  TargetOptions
 }
 
+type DeployResponse struct {
+	Result DeployResult `json:"result"`
+}
+
 type PauseArgs struct {
 	Service      string
 	DrainTimeout time.Duration
 	PauseTimeout time.Duration
+	ExceptPaths  []string
+	ReadOnly     bool
 }
 
 type StopArgs struct {
@@ -39,11 +49,13 @@ type StopArgs struct {
 }
 
 type ResumeArgs struct {
-	Service string
+	Service            string
+	ReleaseConcurrency int
 }
 
 type RemoveArgs struct {
-	Service string
+	Service           string
+	PurgeCertificates bool
 }
 
 type RolloutDeployArgs struct {
@@ -63,13 +75,157 @@ type RolloutStopArgs struct {
 	Service string
 }
 
+type TenantSetArgs struct {
+	Service       string
+	Tenant        string
+	TargetURL     string
+	DeployTimeout time.Duration
+	DrainTimeout  time.Duration
+}
+
+type TenantRemoveArgs struct {
+	Service      string
+	Tenant       string
+	DrainTimeout time.Duration
+}
+
+type TenantListArgs struct {
+	Service string
+}
+
+type TenantListResponse struct {
+	Tenants map[string]string `json:"tenants"`
+}
+
+type PoolAddArgs struct {
+	Pool      string
+	Addresses []string
+}
+
+type PoolRemoveArgs struct {
+	Pool      string
+	Addresses []string
+}
+
+type PoolListResponse struct {
+	Pools TargetPools `json:"pools"`
+}
+
+type ChaosStartArgs struct {
+	Service     string
+	Percentage  int
+	Latency     time.Duration
+	ErrorStatus int
+	Duration    time.Duration
+}
+
+type ChaosStopArgs struct {
+	Service string
+}
+
 type ListResponse struct {
 	Targets ServiceDescriptionMap `json:"services"`
 }
 
-func NewCommandHandler(router *Router) *CommandHandler {
+type HealthLogArgs struct {
+	Service string
+}
+
+type HealthLogResponse struct {
+	Targets []TargetHealthLog `json:"targets"`
+}
+
+type DeployProgressArgs struct {
+	Service      string
+	DeploymentID int
+	Since        int
+}
+
+type DeployProgressResponse struct {
+	Progress DeployProgress `json:"progress"`
+}
+
+type DeployDiagnosticsArgs struct {
+	Service      string
+	DeploymentID int
+	HealthChecks int
+}
+
+type DeployDiagnosticsResponse struct {
+	Diagnostics *DeployDiagnostics `json:"diagnostics"`
+}
+
+type TemplateCreateArgs struct {
+	Name string
+	Args []string
+}
+
+type TemplateGetArgs struct {
+	Name string
+}
+
+type TemplateGetResponse struct {
+	Args []string `json:"args"`
+}
+
+type TemplateRemoveArgs struct {
+	Name string
+}
+
+type TemplateListResponse struct {
+	Templates map[string][]string `json:"templates"`
+}
+
+type StateExportResponse struct {
+	Data []byte `json:"data"`
+}
+
+type StateImportArgs struct {
+	Data          []byte
+	DeployTimeout time.Duration
+	DrainTimeout  time.Duration
+}
+
+type StateBackupsResponse struct {
+	Backups []string `json:"backups"`
+}
+
+type StateRestoreArgs struct {
+	From          string
+	DeployTimeout time.Duration
+	DrainTimeout  time.Duration
+}
+
+type DebugDumpResponse struct {
+	Profiles map[string][]byte `json:"profiles"`
+}
+
+type DeployedOptionsArgs struct {
+	Service string
+}
+
+type DeployedOptionsResponse struct {
+	Deployed DeployedOptions `json:"deployed"`
+}
+
+type CertDeleteArgs struct {
+	Host      string
+	CachePath string
+}
+
+type TopArgs struct {
+	Service string
+}
+
+type TopResponse struct {
+	Services map[string]ServiceStatsSnapshot `json:"services"`
+}
+
+func NewCommandHandler(router *Router, templates *TemplateStore, backups *StateBackups) *CommandHandler {
 	return &CommandHandler{
-		router: router,
+		router:    router,
+		templates: templates,
+		backups:   backups,
 	}
 }
 
@@ -113,12 +269,20 @@ func (h *CommandHandler) Close() error {
 	return h.rpcListener.Close()
 }
 
-func (h *CommandHandler) Deploy(args DeployArgs, reply *bool) error {
-	return h.router.SetServiceTarget(args.Service, args.Hosts, args.TargetURL, args.ServiceOptions, args.TargetOptions, args.DeployTimeout, args.DrainTimeout)
+func (h *CommandHandler) Deploy(args DeployArgs, reply *DeployResponse) error {
+	result, err := h.router.DeployServiceTarget(args.Service, args.Hosts, args.TargetURL, args.ServiceOptions, args.TargetOptions, args.DeployTimeout, args.DrainTimeout, args.QueueTimeout, args.Force)
+	reply.Result = result
+	return err
+}
+
+func (h *CommandHandler) DeployedOptions(args DeployedOptionsArgs, reply *DeployedOptionsResponse) error {
+	deployed, err := h.router.DeployedOptions(args.Service)
+	reply.Deployed = deployed
+	return err
 }
 
 func (h *CommandHandler) Pause(args PauseArgs, reply *bool) error {
-	return h.router.PauseService(args.Service, args.DrainTimeout, args.PauseTimeout)
+	return h.router.PauseService(args.Service, args.DrainTimeout, args.PauseTimeout, args.ExceptPaths, args.ReadOnly)
 }
 
 func (h *CommandHandler) Stop(args StopArgs, reply *bool) error {
@@ -126,10 +290,25 @@ func (h *CommandHandler) Stop(args StopArgs, reply *bool) error {
 }
 
 func (h *CommandHandler) Resume(args ResumeArgs, reply *bool) error {
-	return h.router.ResumeService(args.Service)
+	return h.router.ResumeService(args.Service, args.ReleaseConcurrency)
+}
+
+func (h *CommandHandler) PauseAll(args HostMaintenanceArgs, reply *HostMaintenanceResponse) error {
+	services, err := h.router.PauseAll(args)
+	reply.Services = services
+	return err
+}
+
+func (h *CommandHandler) ResumeAll(args ResumeAllArgs, reply *HostMaintenanceResponse) error {
+	services, err := h.router.ResumeAll(args.ReleaseConcurrency)
+	reply.Services = services
+	return err
 }
 
 func (h *CommandHandler) Remove(args RemoveArgs, reply *bool) error {
+	if args.PurgeCertificates {
+		return h.router.RemoveServiceAndCertificates(args.Service)
+	}
 	return h.router.RemoveService(args.Service)
 }
 
@@ -139,6 +318,93 @@ func (h *CommandHandler) List(args bool, reply *ListResponse) error {
 	return nil
 }
 
+func (h *CommandHandler) HealthLog(args HealthLogArgs, reply *HealthLogResponse) error {
+	targets, err := h.router.HealthLog(args.Service)
+	reply.Targets = targets
+
+	return err
+}
+
+func (h *CommandHandler) DeployProgress(args DeployProgressArgs, reply *DeployProgressResponse) error {
+	reply.Progress = h.router.DeployProgress(args.Service, args.DeploymentID, args.Since)
+
+	return nil
+}
+
+func (h *CommandHandler) DeployDiagnostics(args DeployDiagnosticsArgs, reply *DeployDiagnosticsResponse) error {
+	reply.Diagnostics = h.router.DeployDiagnostics(args.Service, args.DeploymentID, args.HealthChecks)
+
+	return nil
+}
+
+func (h *CommandHandler) TemplateCreate(args TemplateCreateArgs, reply *bool) error {
+	return h.templates.Set(args.Name, args.Args)
+}
+
+func (h *CommandHandler) TemplateGet(args TemplateGetArgs, reply *TemplateGetResponse) error {
+	templateArgs, err := h.templates.Get(args.Name)
+	reply.Args = templateArgs
+
+	return err
+}
+
+func (h *CommandHandler) TemplateRemove(args TemplateRemoveArgs, reply *bool) error {
+	return h.templates.Remove(args.Name)
+}
+
+func (h *CommandHandler) CertDelete(args CertDeleteArgs, reply *bool) error {
+	deleted, err := h.router.DeleteCertificates(args.Host, args.CachePath)
+	*reply = deleted
+	return err
+}
+
+func (h *CommandHandler) TemplateList(args bool, reply *TemplateListResponse) error {
+	reply.Templates = h.templates.List()
+
+	return nil
+}
+
+func (h *CommandHandler) StateExport(args bool, reply *StateExportResponse) error {
+	data, err := h.router.ExportState()
+	reply.Data = data
+
+	return err
+}
+
+func (h *CommandHandler) StateImport(args StateImportArgs, reply *bool) error {
+	return h.router.ImportState(args.Data, args.DeployTimeout, args.DrainTimeout)
+}
+
+func (h *CommandHandler) StateBackups(args bool, reply *StateBackupsResponse) error {
+	backups, err := h.backups.List()
+	reply.Backups = backups
+
+	return err
+}
+
+func (h *CommandHandler) StateRestore(args StateRestoreArgs, reply *bool) error {
+	data, err := h.backups.Read(args.From)
+	if err != nil {
+		return err
+	}
+
+	return h.router.RestoreState(data, args.DeployTimeout, args.DrainTimeout)
+}
+
+func (h *CommandHandler) DebugDump(args bool, reply *DebugDumpResponse) error {
+	profiles, err := DebugProfiles()
+	reply.Profiles = profiles
+
+	return err
+}
+
+func (h *CommandHandler) Top(args TopArgs, reply *TopResponse) error {
+	services, err := h.router.ServiceStats(args.Service)
+	reply.Services = services
+
+	return err
+}
+
 func (h *CommandHandler) RolloutDeploy(args RolloutDeployArgs, reply *bool) error {
 	return h.router.SetRolloutTarget(args.Service, args.TargetURL, args.DeployTimeout, args.DrainTimeout)
 }
@@ -150,3 +416,42 @@ func (h *CommandHandler) RolloutSet(args RolloutSetArgs, reply *bool) error {
 func (h *CommandHandler) RolloutStop(args RolloutStopArgs, reply *bool) error {
 	return h.router.StopRollout(args.Service)
 }
+
+func (h *CommandHandler) TenantSet(args TenantSetArgs, reply *bool) error {
+	return h.router.SetTenantTarget(args.Service, args.Tenant, args.TargetURL, args.DeployTimeout, args.DrainTimeout)
+}
+
+func (h *CommandHandler) TenantRemove(args TenantRemoveArgs, reply *bool) error {
+	return h.router.RemoveTenant(args.Service, args.Tenant, args.DrainTimeout)
+}
+
+func (h *CommandHandler) TenantList(args TenantListArgs, reply *TenantListResponse) error {
+	tenants, err := h.router.TenantTargets(args.Service)
+	if err != nil {
+		return err
+	}
+
+	reply.Tenants = tenants
+	return nil
+}
+
+func (h *CommandHandler) PoolAdd(args PoolAddArgs, reply *bool) error {
+	return h.router.AddPoolTargets(args.Pool, args.Addresses)
+}
+
+func (h *CommandHandler) PoolRemove(args PoolRemoveArgs, reply *bool) error {
+	return h.router.RemovePoolTargets(args.Pool, args.Addresses)
+}
+
+func (h *CommandHandler) PoolList(args bool, reply *PoolListResponse) error {
+	reply.Pools = h.router.Pools()
+	return nil
+}
+
+func (h *CommandHandler) ChaosStart(args ChaosStartArgs, reply *bool) error {
+	return h.router.SetChaos(args.Service, args.Percentage, args.Latency, args.ErrorStatus, args.Duration)
+}
+
+func (h *CommandHandler) ChaosStop(args ChaosStopArgs, reply *bool) error {
+	return h.router.StopChaos(args.Service)
+}
@@ -2,32 +2,110 @@ package server
 
 import (
 	"cmp"
+	"errors"
 	"os"
 	"path"
 	"syscall"
+	"time"
 )
 
 const (
 	DefaultHttpPort  = 80
 	DefaultHttpsPort = 443
+
+	HTTPModeFull     = "full"
+	HTTPModeRedirect = "redirect"
+	HTTPModeOff      = "off"
+	DefaultHTTPMode  = HTTPModeFull
+)
+
+var (
+	ErrorUnknownHTTPMode           = errors.New("unknown HTTP mode (must be full, redirect or off)")
+	ErrorUnknownCommandSocketGroup = errors.New("unknown command socket group")
 )
 
 type Config struct {
-	Bind      string
-	HttpPort  int
-	HttpsPort int
+	Bind        string
+	HttpPort    int
+	HttpsPort   int
+	HTTPMode    string
+	StrictHTTP  bool
+	MetricsPort int
+	DebugPort   int
+
+	// DebugBind is the address that the debug port listens on, which is
+	// separate from Bind so that profiles aren't exposed publicly by default.
+	DebugBind string
+
+	// ListenFamily limits listeners to IPv4 or IPv6. IPv6 listeners are made
+	// v6-only, rather than also accepting IPv4-mapped connections.
+	ListenFamily string
+
+	// ReusePortListeners opens this many sockets for each of the HTTP and
+	// HTTPS ports, sharing them with SO_REUSEPORT. See listenReusePort.
+	ReusePortListeners int
+
+	// TCP options for the HTTP and HTTPS listeners. See TCPTuningListener,
+	// tcpListenSocketOptions and setListenBacklog. Zero values leave the
+	// defaults.
+	TCPDelay       bool
+	TCPKeepAlive   time.Duration
+	TCPDeferAccept time.Duration
+	TCPBacklog     int
+
+	MetricsPushURL      string
+	MetricsPushInterval time.Duration
+
+	DockerSocketPath     string
+	DockerResyncInterval time.Duration
+
+	StateBackupInterval  time.Duration
+	StateBackupRetention int
+
+	SocketReadBufferSize  int
+	SocketWriteBufferSize int
+
+	AccessLogBufferSize    int
+	AccessLogFlushInterval time.Duration
+
+	ErrorReportingDSN string
+
+	DNSFailoverURL      string
+	DNSFailoverInterval time.Duration
+
+	// With an HALeaseDir shared with another proxy, only one of them is
+	// active at a time. See HALease.
+	HALeaseDir      string
+	HANodeID        string
+	HALeaseDuration time.Duration
+	HAHealthPath    string
+
+	// The command socket is only usable by its owner unless it's given a
+	// group and mode that let others connect to it. A zero mode leaves it as
+	// it's created.
+	CommandSocketPath  string
+	CommandSocketGroup string
+	CommandSocketMode  os.FileMode
 
 	AlternateConfigDir string
 }
 
 func (c Config) SocketPath() string {
-	return path.Join(c.runtimeDirectory(), "kamal-proxy.sock")
+	return cmp.Or(c.CommandSocketPath, path.Join(c.runtimeDirectory(), "kamal-proxy.sock"))
 }
 
 func (c Config) StatePath() string {
 	return path.Join(c.dataDirectory(), "kamal-proxy.state")
 }
 
+func (c Config) StateBackupsPath() string {
+	return path.Join(c.dataDirectory(), "kamal-proxy.state.backups")
+}
+
+func (c Config) TemplatesPath() string {
+	return path.Join(c.dataDirectory(), "kamal-proxy.templates")
+}
+
 func (c Config) CertificatePath() string {
 	return path.Join(c.dataDirectory(), "certs")
 }
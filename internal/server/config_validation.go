@@ -0,0 +1,394 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ConfigFindingError   = "error"
+	ConfigFindingWarning = "warning"
+)
+
+var ErrorInvalidConfig = errors.New("unable to read config")
+
+// ConfigFinding is a problem found when validating a config. Errors would
+// prevent the service from being deployed, or from working once it is;
+// warnings are likely, but not certain, to be mistakes.
+type ConfigFinding struct {
+	Service  string `json:"service,omitempty"`
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Message  string `json:"message"`
+}
+
+type ConfigValidationOptions struct {
+	// CheckDNS enables checking that the hosts of TLS services resolve to one
+	// of LocalAddresses, since automatic TLS will fail for any that don't.
+	CheckDNS       bool
+	LocalAddresses []string
+	LookupHost     func(host string) ([]string, error)
+}
+
+type config struct {
+	Services []marshalledService `json:"services"`
+}
+
+// ValidateConfig checks a declarative list of services. The config has the
+// same form as the output of `state export`, and may be written as either
+// YAML or JSON. An error is only returned if the config can't be read.
+func ValidateConfig(data []byte, options ConfigValidationOptions) ([]ConfigFinding, error) {
+	services, err := readConfigServices(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorInvalidConfig, err)
+	}
+
+	v := &configValidator{options: options, findings: []ConfigFinding{}}
+	v.validate(services)
+
+	return v.findings, nil
+}
+
+// Private
+
+func readConfigServices(data []byte) ([]marshalledService, error) {
+	encoded := data
+	if !json.Valid(data) {
+		var document any
+		err := yaml.Unmarshal(data, &document)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded, err = json.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var envelope stateFile
+	if json.Unmarshal(encoded, &envelope) == nil && envelope.Checksum != "" {
+		return decodeStateDescriptions(encoded)
+	}
+
+	var c config
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Services, nil
+}
+
+type configValidator struct {
+	options  ConfigValidationOptions
+	findings []ConfigFinding
+}
+
+func (v *configValidator) validate(services []marshalledService) {
+	names := map[string]bool{}
+	hostServices := HostServiceMap{}
+
+	for _, ms := range services {
+		if ms.Name == "" {
+			v.add("", ConfigFindingError, "name", "service has no name")
+		} else if names[ms.Name] {
+			v.add(ms.Name, ConfigFindingError, "name", "service is defined more than once")
+		}
+		names[ms.Name] = true
+
+		conflict := hostServices.CheckHostAvailability(ms.Name, routedHosts(ms.Hosts, ms.Options))
+		if conflict != nil {
+			v.add(ms.Name, ConfigFindingError, "hosts", fmt.Sprintf("hosts collide with service %q", conflict.name))
+		} else {
+			service := &Service{name: ms.Name, hosts: ms.Hosts, options: ms.Options}
+			maps.Copy(hostServices, ServiceMap{ms.Name: service}.HostServices())
+		}
+
+		if err := validateRedirectHosts(ms.Hosts, ms.Options.RedirectHosts); err != nil {
+			v.add(ms.Name, ConfigFindingError, "hosts", err.Error())
+		}
+
+		if err := validateFailover(ms.Hosts, ms.Options); err != nil {
+			v.add(ms.Name, ConfigFindingError, "failover", err.Error())
+		}
+
+		if _, err := SecurityHeaders(ms.Options.SecurityHeaders, ms.Options.ContentSecurityPolicy, ms.Options.SecurityHeaderOverrides); err != nil {
+			v.add(ms.Name, ConfigFindingError, "security_headers", err.Error())
+		}
+
+		if slo := ms.Options.sloConfig(); slo.Enabled() {
+			if err := slo.Validate(); err != nil {
+				v.add(ms.Name, ConfigFindingError, "slo", err.Error())
+			}
+		}
+
+		if err := ms.Options.traceSamplingConfig().Validate(); err != nil {
+			v.add(ms.Name, ConfigFindingError, "trace_sampling", err.Error())
+		}
+
+		if err := ms.Options.webSocketPolicy().Validate(); err != nil {
+			v.add(ms.Name, ConfigFindingError, "websocket_policy", err.Error())
+		}
+
+		if ms.Options.Pool != "" {
+			if err := ValidatePoolName(ms.Options.Pool); err != nil {
+				v.add(ms.Name, ConfigFindingError, "pool", err.Error())
+			}
+		}
+
+		if _, err := ms.Options.middlewareChain(); err != nil {
+			v.add(ms.Name, ConfigFindingError, "middleware", err.Error())
+		}
+
+		if len(ms.Options.Schedule) > 0 {
+			if _, err := ParseSchedule(ms.Options.Schedule, ms.Options.ScheduleTimezone); err != nil {
+				v.add(ms.Name, ConfigFindingError, "schedule", err.Error())
+			}
+		}
+
+		if ms.Options.ExternalAuthURL != "" {
+			if _, err := NewExternalAuthorizer(ExternalAuthConfig{URL: ms.Options.ExternalAuthURL}); err != nil {
+				v.add(ms.Name, ConfigFindingError, "external_auth", err.Error())
+			}
+		}
+
+		v.validateOIDC(ms)
+		v.validateTarget(ms)
+		v.validateTLS(ms)
+		v.validatePaths(ms)
+		v.validateLimits(ms)
+	}
+}
+
+func (v *configValidator) validateTarget(ms marshalledService) {
+	if ms.ActiveTarget == "" {
+		v.add(ms.Name, ConfigFindingError, "target", "service has no target")
+	}
+
+	for _, target := range []string{ms.ActiveTarget, ms.RolloutTarget} {
+		if target == "" {
+			continue
+		}
+		if _, err := parseTargetURL(target); err != nil {
+			v.add(ms.Name, ConfigFindingError, "target", err.Error())
+		}
+	}
+
+	if err := ms.TargetOptions.HealthCheckConfig.Validate(); err != nil {
+		v.add(ms.Name, ConfigFindingError, "health_check", err.Error())
+	}
+
+	if _, err := ParseResponseRewriteRules(ms.TargetOptions.ResponseRewrites); err != nil {
+		v.add(ms.Name, ConfigFindingError, "response_rewrites", err.Error())
+	}
+
+	if _, err := ParseResponseValidationRules(ms.TargetOptions.ResponseValidations); err != nil {
+		v.add(ms.Name, ConfigFindingError, "response_validations", err.Error())
+	}
+
+	if err := ValidateResponseValidationAction(ms.TargetOptions.ResponseValidationAction); err != nil {
+		v.add(ms.Name, ConfigFindingError, "response_validations", err.Error())
+	}
+
+	if _, err := ParsePriorityRules(ms.TargetOptions.PriorityRules); err != nil {
+		v.add(ms.Name, ConfigFindingError, "priority_rules", err.Error())
+	}
+
+	if err := ms.TargetOptions.requestHeaderLimits().Validate(); err != nil {
+		v.add(ms.Name, ConfigFindingError, "request_header_limits", err.Error())
+	}
+
+	if _, err := parseDirectoryCredentials(ms.TargetOptions.DirectoryCredentials); err != nil {
+		v.add(ms.Name, ConfigFindingError, "directory_credentials", err.Error())
+	}
+
+	if err := ValidateBalanceStrategy(ms.TargetOptions.Balance, ms.TargetOptions.BalanceLoadPath); err != nil {
+		v.add(ms.Name, ConfigFindingError, "balance", err.Error())
+	}
+
+	if err := ValidateTargetProtocol(ms.TargetOptions.TargetProtocol); err != nil {
+		v.add(ms.Name, ConfigFindingError, "target_protocol", err.Error())
+	}
+
+	if err := ValidateTargetIPFamily(ms.TargetOptions.IPFamily); err != nil {
+		v.add(ms.Name, ConfigFindingError, "ip_family", err.Error())
+	}
+
+	if err := ValidateSmokeTestPaths(ms.TargetOptions.SmokeTestPaths); err != nil {
+		v.add(ms.Name, ConfigFindingError, "smoke_test_paths", err.Error())
+	}
+
+	if _, err := ParseHealthCheckAccess(ms.TargetOptions.BlockHealthCheckPath, ms.TargetOptions.HealthCheckAllowedCIDRs); err != nil {
+		v.add(ms.Name, ConfigFindingError, "health_check_allowed_cidrs", err.Error())
+	}
+
+	if err := ValidateVersionPath(ms.TargetOptions.VersionPath); err != nil {
+		v.add(ms.Name, ConfigFindingError, "version_path", err.Error())
+	}
+}
+
+func (v *configValidator) validateTLS(ms marshalledService) {
+	options := ms.Options
+	if !options.TLSEnabled {
+		return
+	}
+
+	if (options.TLSCertificatePath == "") != (options.TLSPrivateKeyPath == "") {
+		v.add(ms.Name, ConfigFindingError, "tls", "a TLS certificate and private key must be given together")
+		return
+	}
+	if options.TLSCertificatePath != "" {
+		return
+	}
+
+	switch options.ACMEChallenge {
+	case "", ACMEChallengeAny, ACMEChallengeTLSALPN:
+	default:
+		v.add(ms.Name, ConfigFindingError, "tls", ErrorUnknownACMEChallenge.Error())
+	}
+
+	if len(ms.Hosts) == 0 {
+		v.add(ms.Name, ConfigFindingError, "tls", "automatic TLS requires at least one host")
+	}
+
+	for _, host := range slices.Concat(ms.Hosts, ms.Options.redirectSources()) {
+		if strings.Contains(host, "*") {
+			v.add(ms.Name, ConfigFindingError, "tls", fmt.Sprintf("%s: %s", host, ErrorAutomaticTLSDoesNotSupportWildcards))
+			continue
+		}
+		if v.options.CheckDNS {
+			v.validateHostResolves(ms.Name, host)
+		}
+	}
+}
+
+func (v *configValidator) validateOIDC(ms marshalledService) {
+	options := ms.Options
+	if options.OIDCIssuer == "" && options.OIDCClientID == "" && options.OIDCClientSecret == "" {
+		return
+	}
+
+	if options.OIDCIssuer == "" || options.OIDCClientID == "" || options.OIDCClientSecret == "" {
+		v.add(ms.Name, ConfigFindingError, "oidc", ErrorOIDCIncomplete.Error())
+		return
+	}
+
+	if !options.TLSEnabled {
+		v.add(ms.Name, ConfigFindingWarning, "oidc", "OIDC is enabled without TLS, so session cookies will be sent unencrypted")
+	}
+}
+
+func (v *configValidator) validateHostResolves(service, host string) {
+	lookup := v.options.LookupHost
+	if lookup == nil {
+		lookup = net.LookupHost
+	}
+
+	addresses, err := lookup(host)
+	if err != nil {
+		v.add(service, ConfigFindingWarning, "dns", fmt.Sprintf("%s does not resolve: %s", host, err))
+		return
+	}
+
+	for _, address := range addresses {
+		if slices.ContainsFunc(v.options.LocalAddresses, func(local string) bool { return sameIP(local, address) }) {
+			return
+		}
+	}
+
+	v.add(service, ConfigFindingWarning, "dns", fmt.Sprintf("%s resolves to %s, which is not this host, so a certificate can't be obtained for it", host, strings.Join(addresses, ", ")))
+}
+
+func (v *configValidator) validatePaths(ms marshalledService) {
+	v.validatePath(ms.Name, "tls_certificate_path", ms.Options.TLSCertificatePath, false)
+	v.validatePath(ms.Name, "tls_private_key_path", ms.Options.TLSPrivateKeyPath, false)
+	v.validatePath(ms.Name, "error_page_path", ms.Options.ErrorPagePath, true)
+	v.validatePath(ms.Name, "timeout_page_path", ms.TargetOptions.TimeoutPagePath, false)
+	v.validatePath(ms.Name, "static_directory", ms.TargetOptions.StaticDirectory, true)
+
+	if err := ValidateWellKnownFiles(ms.Options.WellKnownFiles); err != nil {
+		v.add(ms.Name, ConfigFindingError, "well_known_files", err.Error())
+	}
+	for _, requestPath := range slices.Sorted(maps.Keys(ms.Options.WellKnownFiles)) {
+		v.validatePath(ms.Name, "well_known_files", ms.Options.WellKnownFiles[requestPath], false)
+	}
+
+	for _, spec := range ms.Options.Plugins {
+		path, _, err := ParsePluginSpec(spec)
+		if err != nil {
+			v.add(ms.Name, ConfigFindingError, "plugins", err.Error())
+			continue
+		}
+		v.validatePath(ms.Name, "plugins", path, false)
+	}
+}
+
+func (v *configValidator) validatePath(service, option, path string, wantDir bool) {
+	if path == "" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		v.add(service, ConfigFindingError, "paths", fmt.Sprintf("%s: %s", option, err))
+	case wantDir && !info.IsDir():
+		v.add(service, ConfigFindingError, "paths", fmt.Sprintf("%s: %s is not a directory", option, path))
+	case !wantDir && info.IsDir():
+		v.add(service, ConfigFindingError, "paths", fmt.Sprintf("%s: %s is a directory", option, path))
+	}
+}
+
+func (v *configValidator) validateLimits(ms marshalledService) {
+	to := ms.TargetOptions
+
+	nonNegative := map[string]int64{
+		"max_memory_buffer_size":   to.MaxMemoryBufferSize,
+		"max_request_body_size":    to.MaxRequestBodySize,
+		"max_response_body_size":   to.MaxResponseBodySize,
+		"response_timeout":         int64(to.ResponseTimeout),
+		"upgraded_idle_timeout":    int64(to.UpgradedIdleTimeout),
+		"retries":                  int64(to.Retries),
+		"prewarm_connections":      int64(to.PrewarmConnections),
+		"shed_low_at":              int64(to.ShedLowAt),
+		"shed_normal_at":           int64(to.ShedNormalAt),
+		"fair_share_min_inflight":  int64(to.FairShareMinInflight),
+		"max_request_header_size":  to.MaxRequestHeaderSize,
+		"max_request_header_count": int64(to.MaxRequestHeaderCount),
+		"ttl":                      int64(ms.Options.TTL),
+	}
+	for _, option := range slices.Sorted(maps.Keys(nonNegative)) {
+		if nonNegative[option] < 0 {
+			v.add(ms.Name, ConfigFindingError, "limits", fmt.Sprintf("%s must not be negative", option))
+		}
+	}
+
+	if to.FairShare < 0 || to.FairShare > 100 {
+		v.add(ms.Name, ConfigFindingError, "limits", "fair_share must be a percentage between 0 and 100")
+	}
+
+	if to.MaxMemoryBufferSize > 0 && to.MaxRequestBodySize > 0 && to.MaxMemoryBufferSize > to.MaxRequestBodySize {
+		v.add(ms.Name, ConfigFindingWarning, "limits", "max_memory_buffer_size is larger than max_request_body_size, so it will never be reached by requests")
+	}
+}
+
+func (v *configValidator) add(service, severity, check, message string) {
+	v.findings = append(v.findings, ConfigFinding{Service: service, Severity: severity, Check: check, Message: message})
+}
+
+func sameIP(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	return ipA != nil && ipB != nil && ipA.Equal(ipB)
+}
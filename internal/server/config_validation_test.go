@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig_ValidConfig(t *testing.T) {
+	findings, err := ValidateConfig([]byte(`
+services:
+  - name: web
+    hosts: [app.example.com]
+    active_target: web-1:3000
+    options:
+      tls_enabled: true
+  - name: api
+    hosts: [api.example.com]
+    active_target: api-1:3000
+`), testConfigValidationOptions("203.0.113.1"))
+
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+}
+
+func TestValidateConfig_CollidingHostsAndNames(t *testing.T) {
+	findings, err := ValidateConfig([]byte(`
+services:
+  - name: web
+    hosts: [app.example.com]
+    active_target: web-1:3000
+  - name: web
+    hosts: [app.example.com]
+    active_target: web-2:3000
+  - name: other
+    active_target: ""
+`), testConfigValidationOptions("203.0.113.1"))
+
+	require.NoError(t, err)
+	assert.Equal(t, []ConfigFinding{
+		{Service: "web", Severity: ConfigFindingError, Check: "name", Message: "service is defined more than once"},
+		{Service: "other", Severity: ConfigFindingError, Check: "target", Message: "service has no target"},
+	}, findings)
+}
+
+func TestValidateConfig_CollidingHosts(t *testing.T) {
+	findings, err := ValidateConfig([]byte(`
+services:
+  - name: web
+    hosts: [app.example.com]
+    active_target: web-1:3000
+  - name: api
+    hosts: [api.example.com, app.example.com]
+    active_target: api-1:3000
+`), testConfigValidationOptions("203.0.113.1"))
+
+	require.NoError(t, err)
+	assert.Equal(t, []ConfigFinding{
+		{Service: "api", Severity: ConfigFindingError, Check: "hosts", Message: `hosts collide with service "web"`},
+	}, findings)
+}
+
+func TestValidateConfig_TLSHostsMustResolveToThisHost(t *testing.T) {
+	findings, err := ValidateConfig([]byte(`
+services:
+  - name: web
+    hosts: [app.example.com, elsewhere.example.com, missing.example.com, "*.example.com"]
+    active_target: web-1:3000
+    options:
+      tls_enabled: true
+      acme_challenge: dns-01
+`), testConfigValidationOptions("203.0.113.1"))
+
+	require.NoError(t, err)
+	require.Len(t, findings, 4)
+	assert.Equal(t, ErrorUnknownACMEChallenge.Error(), findings[0].Message)
+	findings = findings[1:]
+	assert.Equal(t, ConfigFindingWarning, findings[0].Severity)
+	assert.Contains(t, findings[0].Message, "elsewhere.example.com resolves to 198.51.100.1")
+	assert.Contains(t, findings[1].Message, "missing.example.com does not resolve")
+	assert.Equal(t, ConfigFindingError, findings[2].Severity)
+	assert.Contains(t, findings[2].Message, ErrorAutomaticTLSDoesNotSupportWildcards.Error())
+}
+
+func TestValidateConfig_PathsAndLimits(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "timeout.html")
+	require.NoError(t, os.WriteFile(page, []byte("timeout"), 0600))
+
+	findings, err := ValidateConfig([]byte(`
+services:
+  - name: web
+    active_target: web-1:3000
+    options:
+      error_page_path: `+page+`
+      tls_enabled: true
+      tls_certificate_path: /does/not/exist.pem
+      tls_private_key_path: `+dir+`
+      plugins: [`+dir+`/missing.wasm, "`+page+`:after_response"]
+    target_options:
+      timeout_page_path: `+page+`
+      max_request_body_size: 1000
+      max_memory_buffer_size: 2000
+      max_response_body_size: -1
+`), testConfigValidationOptions("203.0.113.1"))
+
+	require.NoError(t, err)
+
+	messages := []string{}
+	for _, finding := range findings {
+		messages = append(messages, finding.Check+": "+finding.Message)
+	}
+	assert.Len(t, messages, 7)
+	assert.Contains(t, messages[0], "paths: tls_certificate_path")
+	assert.Equal(t, "paths: tls_private_key_path: "+dir+" is a directory", messages[1])
+	assert.Equal(t, "paths: error_page_path: "+page+" is not a directory", messages[2])
+	assert.Contains(t, messages[3], "paths: plugins: stat "+dir+"/missing.wasm")
+	assert.Equal(t, "plugins: invalid plugin: "+page+`:after_response: unknown hook "after_response"`, messages[4])
+	assert.Equal(t, "limits: max_response_body_size must not be negative", messages[5])
+	assert.Equal(t, "limits: max_memory_buffer_size is larger than max_request_body_size, so it will never be reached by requests", messages[6])
+}
+
+func TestValidateConfig_OIDC(t *testing.T) {
+	findings, err := ValidateConfig([]byte(`
+services:
+  - name: admin
+    hosts: [admin.example.com]
+    active_target: admin-1:3000
+    options:
+      oidc_issuer: https://accounts.example.com
+      oidc_client_id: admin
+      oidc_client_secret: secret
+  - name: tools
+    hosts: [tools.example.com]
+    active_target: tools-1:3000
+    options:
+      oidc_issuer: https://accounts.example.com
+`), testConfigValidationOptions("203.0.113.1"))
+
+	require.NoError(t, err)
+	assert.Equal(t, []ConfigFinding{
+		{Service: "admin", Severity: ConfigFindingWarning, Check: "oidc", Message: "OIDC is enabled without TLS, so session cookies will be sent unencrypted"},
+		{Service: "tools", Severity: ConfigFindingError, Check: "oidc", Message: ErrorOIDCIncomplete.Error()},
+	}, findings)
+}
+
+func TestValidateConfig_AcceptsExportedState(t *testing.T) {
+	service := testCreateService(t, []string{"example.com"}, defaultServiceOptions, defaultTargetOptions)
+	data, err := encodeState([]*Service{service})
+	require.NoError(t, err)
+
+	findings, err := ValidateConfig(data, ConfigValidationOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+
+	_, err = ValidateConfig(append(data[:len(data)-2:len(data)-2], []byte(`x"}`)...), ConfigValidationOptions{})
+	assert.ErrorIs(t, err, ErrorInvalidConfig)
+}
+
+func TestValidateConfig_UnreadableConfig(t *testing.T) {
+	_, err := ValidateConfig([]byte("services: [name: web"), ConfigValidationOptions{})
+	assert.ErrorIs(t, err, ErrorInvalidConfig)
+
+	_, err = ValidateConfig([]byte("services:\n  - name: web\n    tls: true\n"), ConfigValidationOptions{})
+	assert.ErrorIs(t, err, ErrorInvalidConfig)
+}
+
+func testConfigValidationOptions(localAddress string) ConfigValidationOptions {
+	return ConfigValidationOptions{
+		CheckDNS:       true,
+		LocalAddresses: []string{localAddress},
+		LookupHost: func(host string) ([]string, error) {
+			switch host {
+			case "missing.example.com":
+				return nil, errors.New("no such host")
+			case "elsewhere.example.com":
+				return []string{"198.51.100.1"}, nil
+			default:
+				return []string{"203.0.113.1"}, nil
+			}
+		},
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/pprof"
+	rpprof "runtime/pprof"
+)
+
+// DefaultDebugBind keeps the debug port on the loopback interface, unless
+// another address is chosen for it.
+const DefaultDebugBind = "127.0.0.1"
+
+// debugProfiles are written by DebugProfiles, as the name of the profile and
+// the pprof debug level to write it with. Level 0 is the binary format read
+// by `go tool pprof`; level 2 is a readable dump of every goroutine's stack.
+var debugProfiles = []struct {
+	file    string
+	profile string
+	debug   int
+}{
+	{"heap.pb.gz", "heap", 0},
+	{"allocs.pb.gz", "allocs", 0},
+	{"goroutine.pb.gz", "goroutine", 0},
+	{"goroutines.txt", "goroutine", 2},
+}
+
+// DebugHandler serves the runtime's profiles and goroutine dumps, for
+// diagnosing problems like leaks in a running proxy. It should only be
+// reachable by operators, since the profiles reveal a lot about the proxy and
+// can be expensive to collect. The command line isn't served, as it can
+// include secrets such as the tokens in provider URLs.
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// DebugProfiles collects a snapshot of the heap and goroutine profiles, by
+// the name of the file that each should be saved as.
+func DebugProfiles() (map[string][]byte, error) {
+	profiles := map[string][]byte{}
+	for _, p := range debugProfiles {
+		var buf bytes.Buffer
+		err := rpprof.Lookup(p.profile).WriteTo(&buf, p.debug)
+		if err != nil {
+			return nil, err
+		}
+		profiles[p.file] = buf.Bytes()
+	}
+	return profiles, nil
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugProfiles(t *testing.T) {
+	profiles, err := DebugProfiles()
+	require.NoError(t, err)
+
+	assert.Len(t, profiles, 4)
+	assert.NotEmpty(t, profiles["heap.pb.gz"])
+	assert.NotEmpty(t, profiles["goroutine.pb.gz"])
+	assert.Contains(t, string(profiles["goroutines.txt"]), "TestDebugProfiles")
+}
+
+func TestDebugHandler(t *testing.T) {
+	handler := DebugHandler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "goroutine")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/goroutine?debug=2", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "TestDebugHandler")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
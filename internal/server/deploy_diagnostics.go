@@ -0,0 +1,114 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultDiagnosticHealthChecks = 10
+
+	diagnosticBodyLimit = 1024
+)
+
+// DeployDiagnostics describes the state of a target that failed to become
+// healthy during a deployment, to help explain why it failed.
+type DeployDiagnostics struct {
+	Target       string                `json:"target"`
+	Endpoints    []string              `json:"endpoints,omitempty"`
+	HealthChecks []HealthCheckResult   `json:"health_checks"`
+	TestRequest  DiagnosticRequest     `json:"test_request"`
+	Connections  DiagnosticConnections `json:"connections"`
+}
+
+type DiagnosticRequest struct {
+	URL        string            `json:"url"`
+	StatusCode int               `json:"status_code,omitempty"`
+	Latency    time.Duration     `json:"latency"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+type DiagnosticConnections struct {
+	ActiveTarget   string `json:"active_target,omitempty"`
+	ActiveInflight int    `json:"active_inflight"`
+	TargetInflight int    `json:"target_inflight"`
+}
+
+func newDeployDiagnostics(target *Target, active *Target) *DeployDiagnostics {
+	diagnostics := &DeployDiagnostics{
+		Target:       target.Target(),
+		Endpoints:    target.Endpoints(),
+		HealthChecks: target.HealthCheckHistory(),
+		TestRequest:  target.diagnosticRequest(),
+		Connections:  DiagnosticConnections{TargetInflight: target.inflightCount()},
+	}
+
+	if active != nil {
+		diagnostics.Connections.ActiveTarget = active.Target()
+		diagnostics.Connections.ActiveInflight = active.inflightCount()
+	}
+
+	return diagnostics
+}
+
+// WithLastHealthChecks returns a copy of the diagnostics that includes only
+// the most recent count health checks.
+func (d DeployDiagnostics) WithLastHealthChecks(count int) DeployDiagnostics {
+	if count >= 0 && len(d.HealthChecks) > count {
+		d.HealthChecks = d.HealthChecks[len(d.HealthChecks)-count:]
+	}
+	return d
+}
+
+// Private
+
+func (t *Target) diagnosticRequest() DiagnosticRequest {
+	endpoint := *t.targetURL
+	endpoint.Host = t.endpointHost()
+	endpoint = *endpoint.JoinPath(t.options.HealthCheckConfig.Path)
+
+	result := DiagnosticRequest{URL: endpoint.String()}
+
+	req, err := http.NewRequest(http.MethodGet, result.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Host = t.targetURL.Host
+	req.Header.Set("User-Agent", healthCheckUserAgent)
+
+	client := &http.Client{Transport: t.transport, Timeout: t.options.HealthCheckConfig.Timeout}
+
+	started := time.Now()
+	resp, err := client.Do(req)
+	result.Latency = time.Since(started)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, diagnosticBodyLimit))
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	result.StatusCode = resp.StatusCode
+	result.Body = string(body)
+	result.Headers = map[string]string{}
+	for name := range resp.Header {
+		result.Headers[name] = resp.Header.Get(name)
+	}
+
+	return result
+}
+
+func (t *Target) inflightCount() int {
+	t.inflightLock.Lock()
+	defer t.inflightLock.Unlock()
+
+	return len(t.inflight)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployDiagnostics_WithLastHealthChecks(t *testing.T) {
+	diagnostics := DeployDiagnostics{HealthChecks: []HealthCheckResult{{StatusCode: 1}, {StatusCode: 2}, {StatusCode: 3}}}
+
+	assert.Equal(t, []int{2, 3}, testHealthCheckStatuses(diagnostics.WithLastHealthChecks(2).HealthChecks))
+	assert.Equal(t, []int{1, 2, 3}, testHealthCheckStatuses(diagnostics.WithLastHealthChecks(5).HealthChecks))
+	assert.Empty(t, diagnostics.WithLastHealthChecks(0).HealthChecks)
+	assert.Len(t, diagnostics.HealthChecks, 3)
+}
+
+func TestDeployDiagnostics_TestRequest(t *testing.T) {
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reason", "migrations pending")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("database is not ready"))
+	})
+
+	diagnostics := newDeployDiagnostics(target, nil)
+
+	assert.Equal(t, "http://"+target.Target()+"/up", diagnostics.TestRequest.URL)
+	assert.Equal(t, http.StatusServiceUnavailable, diagnostics.TestRequest.StatusCode)
+	assert.Equal(t, "migrations pending", diagnostics.TestRequest.Headers["X-Reason"])
+	assert.Equal(t, "database is not ready", diagnostics.TestRequest.Body)
+	assert.Empty(t, diagnostics.TestRequest.Error)
+	assert.Empty(t, diagnostics.Connections.ActiveTarget)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DeployLockStatus describes a deploy that's holding a service's lock, and
+// how many others are queued behind it.
+type DeployLockStatus struct {
+	Target string    `json:"target"`
+	Since  time.Time `json:"since"`
+	Queued int       `json:"queued"`
+}
+
+// DeployLocks serializes the deploys of each service, so that two that race,
+// such as when CI retries a deploy, can't both switch the service's target.
+// A deploy that finds the lock taken can either fail straight away, or queue
+// for it for a while.
+type DeployLocks struct {
+	lock  sync.Mutex
+	locks map[string]*deployLock
+}
+
+type deployLock struct {
+	sem    chan struct{}
+	users  int
+	held   bool
+	target string
+	since  time.Time
+}
+
+func NewDeployLocks() *DeployLocks {
+	return &DeployLocks{
+		locks: map[string]*deployLock{},
+	}
+}
+
+// Acquire takes the lock for a service's deploy, waiting up to queueTimeout
+// for any deploy that holds it to finish. The returned function releases it.
+func (l *DeployLocks) Acquire(name, target string, queueTimeout time.Duration) (func(), error) {
+	lock := l.use(name)
+
+	if !l.wait(lock, queueTimeout) {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		err := fmt.Errorf("%w for %s", ErrorDeployInProgress, name)
+		if lock.held {
+			err = fmt.Errorf("%w for %s: deploying %s since %s", ErrorDeployInProgress, name, lock.target, lock.since.Format(time.RFC3339))
+		}
+		l.release(name, lock)
+		return nil, err
+	}
+
+	l.lock.Lock()
+	lock.held = true
+	lock.target = target
+	lock.since = time.Now()
+	l.lock.Unlock()
+
+	return func() {
+		l.lock.Lock()
+		lock.held = false
+		l.release(name, lock)
+		l.lock.Unlock()
+
+		<-lock.sem
+	}, nil
+}
+
+// Status describes the deploy holding a service's lock, or returns nil if
+// there isn't one.
+func (l *DeployLocks) Status(name string) *DeployLockStatus {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	lock := l.locks[name]
+	if lock == nil || !lock.held {
+		return nil
+	}
+
+	return &DeployLockStatus{
+		Target: lock.target,
+		Since:  lock.since,
+		Queued: lock.users - 1,
+	}
+}
+
+// Private
+
+func (l *DeployLocks) use(name string) *deployLock {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	lock := l.locks[name]
+	if lock == nil {
+		lock = &deployLock{sem: make(chan struct{}, 1)}
+		l.locks[name] = lock
+	}
+	lock.users++
+
+	return lock
+}
+
+func (l *DeployLocks) wait(lock *deployLock, queueTimeout time.Duration) bool {
+	select {
+	case lock.sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	if queueTimeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case lock.sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// release stops a deploy using the lock, and forgets the lock once nothing
+// else is. It must be called with l.lock held.
+func (l *DeployLocks) release(name string, lock *deployLock) {
+	lock.users--
+	if lock.users == 0 {
+		delete(l.locks, name)
+	}
+}
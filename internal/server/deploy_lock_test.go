@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployLocks_FailFast(t *testing.T) {
+	locks := NewDeployLocks()
+
+	unlock, err := locks.Acquire("app", "web-1:3000", 0)
+	require.NoError(t, err)
+
+	_, err = locks.Acquire("app", "web-2:3000", 0)
+	assert.ErrorIs(t, err, ErrorDeployInProgress)
+	assert.Contains(t, err.Error(), "web-1:3000")
+
+	other, err := locks.Acquire("other", "web-3:3000", 0)
+	require.NoError(t, err)
+	other()
+
+	unlock()
+
+	unlock, err = locks.Acquire("app", "web-2:3000", 0)
+	require.NoError(t, err)
+	unlock()
+
+	assert.Empty(t, locks.locks)
+}
+
+func TestDeployLocks_Queue(t *testing.T) {
+	locks := NewDeployLocks()
+
+	unlock, err := locks.Acquire("app", "web-1:3000", 0)
+	require.NoError(t, err)
+
+	acquired := make(chan error)
+	go func() {
+		unlock, err := locks.Acquire("app", "web-2:3000", time.Second)
+		if err == nil {
+			unlock()
+		}
+		acquired <- err
+	}()
+
+	require.Eventually(t, func() bool {
+		status := locks.Status("app")
+		return status != nil && status.Queued == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "web-1:3000", locks.Status("app").Target)
+
+	unlock()
+	require.NoError(t, <-acquired)
+	assert.Nil(t, locks.Status("app"))
+}
+
+func TestDeployLocks_QueueTimeout(t *testing.T) {
+	locks := NewDeployLocks()
+
+	unlock, err := locks.Acquire("app", "web-1:3000", 0)
+	require.NoError(t, err)
+	defer unlock()
+
+	_, err = locks.Acquire("app", "web-2:3000", 10*time.Millisecond)
+	assert.ErrorIs(t, err, ErrorDeployInProgress)
+	assert.Equal(t, 0, locks.Status("app").Queued)
+}
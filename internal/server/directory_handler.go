@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const directoryAuthRealm = "kamal-proxy"
+
+var (
+	ErrorInvalidDirectoryCredentials = errors.New("directory credentials must be given as user:password")
+	ErrorInvalidDirectoryTarget      = errors.New("directory targets must be given as file:// followed by an absolute path")
+)
+
+type DirectoryConfig struct {
+	Listing       bool
+	Credentials   []string
+	SPA           bool
+	Precompressed bool
+}
+
+// DirectoryHandler serves the files in a local directory, for a target given
+// as a file:// URL rather than a host, or for a target's static assets.
+// Files are served with support for conditional and range requests, and
+// directories with their index.html, or with a listing of their contents
+// when that's enabled. Files and directories whose names start with a dot
+// are never served. When any credentials are given, requests must use basic
+// auth with one of them.
+//
+// In SPA mode, the paths of a single-page app's routes, which have no file
+// extension and don't exist in the directory, are served the root
+// index.html, so that the app can handle them.
+//
+// With Precompressed, files that have .br or .gz variants alongside them are
+// served from those, to clients that accept them. See servePrecompressed.
+type DirectoryHandler struct {
+	root        string
+	config      DirectoryConfig
+	credentials map[string]string
+	fileServer  http.Handler
+}
+
+func NewDirectoryHandler(root string, config DirectoryConfig) (*DirectoryHandler, error) {
+	credentials, err := parseDirectoryCredentials(config.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DirectoryHandler{
+		root:        root,
+		config:      config,
+		credentials: credentials,
+		fileServer:  http.FileServer(http.Dir(root)),
+	}, nil
+}
+
+// Serves reports whether the handler has something to serve for a path,
+// either from the directory, or as an SPA route.
+func (h *DirectoryHandler) Serves(urlPath string) bool {
+	return h.exists(urlPath) || h.isSPARoute(urlPath)
+}
+
+func (h *DirectoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		SetErrorResponse(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", directoryAuthRealm))
+		SetErrorResponse(w, r, http.StatusUnauthorized, nil)
+		return
+	}
+
+	if h.exists(r.URL.Path) {
+		if h.config.Precompressed && servePrecompressed(w, r, h.precompressedName(r.URL.Path)) {
+			return
+		}
+		h.fileServer.ServeHTTP(w, r)
+		return
+	}
+
+	if h.isSPARoute(r.URL.Path) {
+		index := filepath.Join(h.root, "index.html")
+		if h.config.Precompressed && servePrecompressed(w, r, index) {
+			return
+		}
+		http.ServeFile(w, r, index)
+		return
+	}
+
+	SetErrorResponse(w, r, http.StatusNotFound, nil)
+}
+
+// Private
+
+func (h *DirectoryHandler) authorized(r *http.Request) bool {
+	if len(h.credentials) == 0 {
+		return true
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	expected, found := h.credentials[user]
+	matches := subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+	return found && matches
+}
+
+// exists reports whether the path should be served, which it shouldn't be if
+// it's missing, hidden, or a directory that would need a listing that we
+// don't allow.
+func (h *DirectoryHandler) exists(urlPath string) bool {
+	if h.hidden(urlPath) {
+		return false
+	}
+
+	name := h.filename(urlPath)
+	info, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+
+	if info.IsDir() && !h.config.Listing {
+		_, err := os.Stat(filepath.Join(name, "index.html"))
+		return err == nil
+	}
+	return true
+}
+
+func (h *DirectoryHandler) filename(urlPath string) string {
+	return filepath.Join(h.root, filepath.FromSlash(path.Clean("/"+urlPath)))
+}
+
+// precompressedName is the file that a request is served, for looking up its
+// variants. That's a directory's index.html, when the file server would serve
+// it, rather than redirecting to add or remove a trailing slash.
+func (h *DirectoryHandler) precompressedName(urlPath string) string {
+	name := h.filename(urlPath)
+	if strings.HasSuffix(urlPath, "/") {
+		return filepath.Join(name, "index.html")
+	}
+	if strings.HasSuffix(urlPath, "/index.html") {
+		return ""
+	}
+	return name
+}
+
+func (h *DirectoryHandler) isSPARoute(urlPath string) bool {
+	if !h.config.SPA || path.Ext(urlPath) != "" || h.hidden(urlPath) {
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(h.root, "index.html"))
+	return err == nil
+}
+
+func (h *DirectoryHandler) hidden(urlPath string) bool {
+	for _, segment := range strings.Split(path.Clean("/"+urlPath), "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDirectoryCredentials(credentials []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, credential := range credentials {
+		user, password, ok := strings.Cut(credential, ":")
+		if !ok || user == "" || password == "" {
+			return nil, ErrorInvalidDirectoryCredentials
+		}
+		result[user] = password
+	}
+	return result, nil
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectoryHandler_ServesFiles(t *testing.T) {
+	handler, err := NewDirectoryHandler(testDirectory(t), DirectoryConfig{})
+	require.NoError(t, err)
+
+	w := testDirectoryRequest(handler, http.MethodGet, "/builds/app.tar.gz", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+
+	w = testDirectoryRequest(handler, http.MethodGet, "/builds/app.tar.gz", map[string]string{"Range": "bytes=2-4"})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "234", w.Body.String())
+
+	w = testDirectoryRequest(handler, http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "home", w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, testDirectoryRequest(handler, http.MethodGet, "/missing", nil).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, testDirectoryRequest(handler, http.MethodPost, "/builds/app.tar.gz", nil).Code)
+}
+
+func TestDirectoryHandler_Listing(t *testing.T) {
+	dir := testDirectory(t)
+
+	handler, err := NewDirectoryHandler(dir, DirectoryConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, testDirectoryRequest(handler, http.MethodGet, "/builds/", nil).Code)
+
+	handler, err = NewDirectoryHandler(dir, DirectoryConfig{Listing: true})
+	require.NoError(t, err)
+	w := testDirectoryRequest(handler, http.MethodGet, "/builds/", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "app.tar.gz")
+}
+
+func TestDirectoryHandler_HidesDotFiles(t *testing.T) {
+	handler, err := NewDirectoryHandler(testDirectory(t), DirectoryConfig{Listing: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusNotFound, testDirectoryRequest(handler, http.MethodGet, "/.env", nil).Code)
+	assert.Equal(t, http.StatusNotFound, testDirectoryRequest(handler, http.MethodGet, "/.git/config", nil).Code)
+	assert.Equal(t, http.StatusNotFound, testDirectoryRequest(handler, http.MethodGet, "/builds/../.env", nil).Code)
+}
+
+func TestDirectoryHandler_SPA(t *testing.T) {
+	dir := testDirectory(t)
+
+	handler, err := NewDirectoryHandler(dir, DirectoryConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, testDirectoryRequest(handler, http.MethodGet, "/dashboard/settings", nil).Code)
+
+	handler, err = NewDirectoryHandler(dir, DirectoryConfig{SPA: true})
+	require.NoError(t, err)
+
+	w := testDirectoryRequest(handler, http.MethodGet, "/dashboard/settings", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "home", w.Body.String())
+
+	w = testDirectoryRequest(handler, http.MethodGet, "/builds/app.tar.gz", nil)
+	assert.Equal(t, "0123456789", w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, testDirectoryRequest(handler, http.MethodGet, "/missing.js", nil).Code)
+	assert.Equal(t, http.StatusNotFound, testDirectoryRequest(handler, http.MethodGet, "/.git/hooks", nil).Code)
+}
+
+func TestDirectoryHandler_BasicAuth(t *testing.T) {
+	handler, err := NewDirectoryHandler(testDirectory(t), DirectoryConfig{Credentials: []string{"deploy:s3cret"}})
+	require.NoError(t, err)
+
+	w := testDirectoryRequest(handler, http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("deploy", "wrong")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req.SetBasicAuth("deploy", "s3cret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err = NewDirectoryHandler(testDirectory(t), DirectoryConfig{Credentials: []string{"deploy"}})
+	assert.ErrorIs(t, err, ErrorInvalidDirectoryCredentials)
+}
+
+func TestTarget_ServesDirectory(t *testing.T) {
+	dir := testDirectory(t)
+
+	target, err := NewTarget("file://"+dir, defaultTargetOptions)
+	require.NoError(t, err)
+	assert.Equal(t, "file://"+dir, target.Target())
+
+	w := httptest.NewRecorder()
+	testServeRequestWithTarget(t, target, w, httptest.NewRequest(http.MethodGet, "/builds/app.tar.gz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+
+	_, err = NewHealthCheckProbe(defaultHealthCheckConfig, target.targetURL).Probe(context.Background())
+	assert.NoError(t, err)
+
+	_, err = NewTarget("file://relative/path", defaultTargetOptions)
+	assert.ErrorIs(t, err, ErrorInvalidDirectoryTarget)
+}
+
+func TestDirectoryHealthCheckProbe(t *testing.T) {
+	dir := testDirectory(t)
+
+	_, err := (&directoryHealthCheckProbe{root: filepath.Join(dir, "missing")}).Probe(context.Background())
+	assert.Error(t, err)
+
+	_, err = (&directoryHealthCheckProbe{root: filepath.Join(dir, "index.html")}).Probe(context.Background())
+	assert.ErrorIs(t, err, ErrorHealthCheckNotADirectory)
+}
+
+func TestDirectoryHandler_PrecompressedVariants(t *testing.T) {
+	dir := testDirectory(t)
+	for name, content := range map[string]string{"app.js": "plain", "app.js.br": "brotli", "app.js.gz": "gzipped", "index.html.gz": "home gzipped"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	handler, err := NewDirectoryHandler(dir, DirectoryConfig{Precompressed: true})
+	require.NoError(t, err)
+
+	check := func(path, acceptEncoding, body, contentEncoding string) {
+		w := testDirectoryRequest(handler, http.MethodGet, path, map[string]string{"Accept-Encoding": acceptEncoding})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, body, w.Body.String(), acceptEncoding)
+		assert.Equal(t, contentEncoding, w.Header().Get("Content-Encoding"), acceptEncoding)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	}
+
+	check("/app.js", "gzip, deflate, br", "brotli", "br")
+	check("/app.js", "gzip", "gzipped", "gzip")
+	check("/app.js", "br;q=0, *", "gzipped", "gzip")
+	check("/app.js", "", "plain", "")
+	check("/", "gzip", "home gzipped", "gzip")
+
+	w := testDirectoryRequest(handler, http.MethodGet, "/app.js", map[string]string{"Accept-Encoding": "br"})
+	assert.Contains(t, w.Header().Get("Content-Type"), "javascript")
+
+	w = testDirectoryRequest(handler, http.MethodGet, "/builds/app.tar.gz", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, "0123456789", w.Body.String())
+	assert.Empty(t, w.Header().Get("Vary"))
+
+	handler, err = NewDirectoryHandler(dir, DirectoryConfig{})
+	require.NoError(t, err)
+	w = testDirectoryRequest(handler, http.MethodGet, "/app.js", map[string]string{"Accept-Encoding": "br"})
+	assert.Equal(t, "plain", w.Body.String())
+}
+
+// Helpers
+
+func testDirectory(t *testing.T) string {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"index.html":        "home",
+		"builds/app.tar.gz": "0123456789",
+		".env":              "SECRET=1",
+		".git/config":       "[core]",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	return dir
+}
+
+func testDirectoryRequest(handler http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
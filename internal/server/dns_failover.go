@@ -0,0 +1,361 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDNSFailoverInterval = 10 * time.Second
+	DefaultFailoverAfter       = 30 * time.Second
+
+	dnsFailoverTimeout   = 10 * time.Second
+	cloudflareAPIURL     = "https://api.cloudflare.com/client/v4"
+	cloudflareTokenEnvar = "CLOUDFLARE_API_TOKEN"
+)
+
+var (
+	ErrorUnsupportedDNSFailoverURL = errors.New("DNS failover URL must use the cloudflare, http or https scheme")
+	ErrorFailoverRequiresRecord    = errors.New("failover needs a DNS record to update (give one, or a host that isn't a wildcard)")
+	ErrorDNSUpdateFailed           = errors.New("unable to update DNS record")
+)
+
+// DNSProvider points a DNS record at an address. Addresses can be IP
+// addresses, or hostnames for providers that support CNAME records.
+type DNSProvider interface {
+	UpdateRecord(ctx context.Context, service, name, address string) error
+}
+
+// NewDNSProvider creates a provider for the given URL, which takes one of the
+// following forms:
+//
+//	cloudflare://<api-token>@<zone-id>
+//	https://hooks.example.com/dns-failover
+//
+// The Cloudflare token can be left out of the URL, and given in the
+// CLOUDFLARE_API_TOKEN environment variable instead. An http or https URL is
+// a webhook, which is sent each update to make itself.
+func NewDNSProvider(rawURL string) (DNSProvider, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "cloudflare":
+		token := u.User.Username()
+		if token == "" {
+			token = os.Getenv(cloudflareTokenEnvar)
+		}
+		if u.Host == "" || token == "" {
+			return nil, fmt.Errorf("%w: cloudflare needs an API token and zone ID", ErrorUnsupportedDNSFailoverURL)
+		}
+		return &cloudflareDNSProvider{apiURL: cloudflareAPIURL, token: token, zoneID: u.Host}, nil
+
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%w: %s", ErrorUnsupportedDNSFailoverURL, rawURL)
+		}
+		return &webhookDNSProvider{url: u.String()}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrorUnsupportedDNSFailoverURL, rawURL)
+	}
+}
+
+// FailoverAnnouncer watches the services that have a failover address, and
+// points their DNS records at it once all of their targets have been down for
+// the service's FailoverAfter. It's a crude form of failover between hosts,
+// for when there's nothing else in front of the proxy to do it: the records
+// aren't pointed back again when the targets recover, as the standby may be
+// serving requests by then.
+type FailoverAnnouncer struct {
+	router   *Router
+	provider DNSProvider
+	states   map[string]*failoverState
+	lock     sync.Mutex
+}
+
+type failoverState struct {
+	downSince time.Time
+	announced bool
+}
+
+func NewFailoverAnnouncer(router *Router, provider DNSProvider) *FailoverAnnouncer {
+	return &FailoverAnnouncer{
+		router:   router,
+		provider: provider,
+		states:   map[string]*failoverState{},
+	}
+}
+
+// Check health checks each service with a failover address, and announces
+// the failover of any that have been down for long enough.
+func (a *FailoverAnnouncer) Check(ctx context.Context, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	services := a.router.failoverServices()
+
+	checked := map[string]*failoverState{}
+	for _, service := range services {
+		state := a.states[service.name]
+		if state == nil {
+			state = &failoverState{}
+		}
+		checked[service.name] = state
+
+		a.check(ctx, service, state, now)
+	}
+	a.states = checked
+}
+
+// Private
+
+func (a *FailoverAnnouncer) check(ctx context.Context, service *Service, state *failoverState, now time.Time) {
+	if service.pauseController.GetState() != PauseStateRunning {
+		// A paused or stopped service is down on purpose.
+		state.downSince = time.Time{}
+		return
+	}
+
+	target := service.ActiveTarget()
+	if target != nil && target.CheckHealth(ctx) {
+		if state.announced {
+			slog.Info("Service recovered after DNS failover; its DNS records still point at the standby", "service", service.name)
+		}
+		*state = failoverState{}
+		return
+	}
+
+	if state.downSince.IsZero() {
+		slog.Warn("All targets for service are down", "service", service.name)
+		state.downSince = now
+	}
+
+	if state.announced || now.Sub(state.downSince) < service.options.failoverAfter() {
+		return
+	}
+
+	records := failoverRecords(service.hosts, service.options)
+	failed := false
+	for _, record := range records {
+		ctx, cancel := context.WithTimeout(ctx, dnsFailoverTimeout)
+		err := a.provider.UpdateRecord(ctx, service.name, record, service.options.FailoverAddress)
+		cancel()
+
+		if err != nil {
+			failed = true
+			slog.Error("Unable to update DNS record for failover", "service", service.name, "record", record, "error", err)
+			reportError("dns-failover:"+record, ErrorReport{
+				Kind:    "dns-failover",
+				Message: fmt.Sprintf("Unable to update DNS record %s for failover: %s", record, err),
+				Tags:    map[string]string{"service": service.name, "host": record},
+			})
+			continue
+		}
+
+		slog.Warn("Pointed DNS record at standby", "service", service.name, "record", record, "address", service.options.FailoverAddress)
+	}
+
+	// Records that failed to update are retried on the next check.
+	state.announced = !failed
+}
+
+func (so ServiceOptions) failoverAfter() time.Duration {
+	if so.FailoverAfter > 0 {
+		return so.FailoverAfter
+	}
+	return DefaultFailoverAfter
+}
+
+// failoverRecords are the DNS records that are pointed at the standby: either
+// the one that's given, or the service's hosts.
+func failoverRecords(hosts []string, options ServiceOptions) []string {
+	if options.FailoverRecord != "" {
+		return []string{options.FailoverRecord}
+	}
+
+	records := []string{}
+	for _, host := range hosts {
+		if !strings.Contains(host, "*") {
+			records = append(records, host)
+		}
+	}
+	return records
+}
+
+func validateFailover(hosts []string, options ServiceOptions) error {
+	if options.FailoverAddress != "" && len(failoverRecords(hosts, options)) == 0 {
+		return ErrorFailoverRequiresRecord
+	}
+	return nil
+}
+
+// dnsRecordType is the type of record that points at an address.
+func dnsRecordType(address string) string {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return "CNAME"
+	case ip.To4() != nil:
+		return "A"
+	default:
+		return "AAAA"
+	}
+}
+
+// Cloudflare
+
+type cloudflareDNSProvider struct {
+	apiURL string
+	token  string
+	zoneID string
+}
+
+type cloudflareDNSRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+type cloudflareResponse struct {
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// UpdateRecord changes the record with the given name, or creates it if there
+// isn't one. The record is given a short TTL, so that it can be pointed back
+// again without much delay. A name can have several address records, such as
+// both A and AAAA, or one for each of a pool of servers; the first is changed,
+// and the rest are deleted once it has been, so that none of them still point
+// at the old address.
+func (p *cloudflareDNSProvider) UpdateRecord(ctx context.Context, service, name, address string) error {
+	var existing []cloudflareDNSRecord
+	err := p.call(ctx, http.MethodGet, "/zones/"+p.zoneID+"/dns_records?name="+url.QueryEscape(name), nil, &existing)
+	if err != nil {
+		return err
+	}
+
+	existing = slices.DeleteFunc(existing, func(r cloudflareDNSRecord) bool {
+		return r.Type != "A" && r.Type != "AAAA" && r.Type != "CNAME"
+	})
+
+	record := cloudflareDNSRecord{Type: dnsRecordType(address), Name: name, Content: address, TTL: 60}
+	if len(existing) == 0 {
+		return p.call(ctx, http.MethodPost, "/zones/"+p.zoneID+"/dns_records", record, nil)
+	}
+
+	err = p.call(ctx, http.MethodPut, "/zones/"+p.zoneID+"/dns_records/"+existing[0].ID, record, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range existing[1:] {
+		err = p.call(ctx, http.MethodDelete, "/zones/"+p.zoneID+"/dns_records/"+r.ID, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *cloudflareDNSProvider) call(ctx context.Context, method, path string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response cloudflareResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return fmt.Errorf("%w: unexpected response from Cloudflare (%d)", ErrorDNSUpdateFailed, resp.StatusCode)
+	}
+	if !response.Success {
+		messages := []string{}
+		for _, e := range response.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("%w: %s", ErrorDNSUpdateFailed, strings.Join(messages, "; "))
+	}
+
+	if result != nil {
+		return json.Unmarshal(response.Result, result)
+	}
+	return nil
+}
+
+// Webhook
+
+type webhookDNSProvider struct {
+	url string
+}
+
+type webhookDNSUpdate struct {
+	Service string `json:"service"`
+	Record  string `json:"record"`
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// UpdateRecord posts the update to the webhook as JSON. Any 2xx response
+// means it's been made.
+func (p *webhookDNSProvider) UpdateRecord(ctx context.Context, service, name, address string) error {
+	data, err := json.Marshal(webhookDNSUpdate{Service: service, Record: name, Type: dnsRecordType(address), Address: address})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: webhook responded with %d", ErrorDNSUpdateFailed, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailoverAnnouncer_AnnouncesAfterTargetsAreDownForLongEnough(t *testing.T) {
+	router := testRouter(t)
+	backend, target := testBackend(t, "first", http.StatusOK)
+
+	options := ServiceOptions{FailoverAddress: "203.0.113.10", FailoverAfter: time.Minute}
+	_, err := router.DeployServiceTarget("service1", []string{"example.com", "*.example.com"}, target, options, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout, 0, false)
+	require.NoError(t, err)
+
+	provider := &testDNSProvider{}
+	announcer := NewFailoverAnnouncer(router, provider)
+	now := time.Now()
+
+	announcer.Check(context.Background(), now)
+	assert.Empty(t, provider.updates)
+
+	backend.Close()
+
+	announcer.Check(context.Background(), now.Add(time.Second))
+	announcer.Check(context.Background(), now.Add(30*time.Second))
+	assert.Empty(t, provider.updates)
+
+	announcer.Check(context.Background(), now.Add(2*time.Minute))
+	assert.Equal(t, []string{"service1 example.com 203.0.113.10"}, provider.updates)
+
+	announcer.Check(context.Background(), now.Add(3*time.Minute))
+	assert.Len(t, provider.updates, 1)
+}
+
+func TestFailoverAnnouncer_IgnoresPausedServices(t *testing.T) {
+	router := testRouter(t)
+	backend, target := testBackend(t, "first", http.StatusOK)
+
+	options := ServiceOptions{FailoverAddress: "standby.example.com", FailoverRecord: "app.example.com"}
+	_, err := router.DeployServiceTarget("service1", defaultEmptyHosts, target, options, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout, 0, false)
+	require.NoError(t, err)
+	require.NoError(t, router.StopService("service1", DefaultDrainTimeout, ""))
+	backend.Close()
+
+	provider := &testDNSProvider{}
+	announcer := NewFailoverAnnouncer(router, provider)
+	now := time.Now()
+
+	announcer.Check(context.Background(), now)
+	announcer.Check(context.Background(), now.Add(time.Hour))
+	assert.Empty(t, provider.updates)
+}
+
+func TestFailover_RequiresRecord(t *testing.T) {
+	_, err := NewService("test", []string{"*.example.com"}, ServiceOptions{FailoverAddress: "203.0.113.10"})
+	assert.ErrorIs(t, err, ErrorFailoverRequiresRecord)
+
+	_, err = NewService("test", []string{"*.example.com"}, ServiceOptions{FailoverAddress: "203.0.113.10", FailoverRecord: "app.example.com"})
+	assert.NoError(t, err)
+}
+
+func TestDNSProvider_Cloudflare(t *testing.T) {
+	var requests []string
+	var body cloudflareDNSRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"success":true,"result":[{"id":"txt","type":"TXT","name":"app.example.com","content":"hi"},{"id":"rec1","type":"A","name":"app.example.com","content":"192.0.2.1"},{"id":"rec2","type":"AAAA","name":"app.example.com","content":"2001:db8::1"},{"id":"rec3","type":"A","name":"app.example.com","content":"192.0.2.2"}]}`))
+			return
+		}
+		if r.Method == http.MethodDelete {
+			w.Write([]byte(`{"success":true,"result":{}}`))
+			return
+		}
+
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Write([]byte(`{"success":true,"result":{}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := NewDNSProvider("cloudflare://token@zone1")
+	require.NoError(t, err)
+	provider.(*cloudflareDNSProvider).apiURL = server.URL
+
+	require.NoError(t, provider.UpdateRecord(context.Background(), "service1", "app.example.com", "2001:db8::10"))
+	assert.Equal(t, []string{
+		"GET /zones/zone1/dns_records?name=app.example.com",
+		"PUT /zones/zone1/dns_records/rec1",
+		"DELETE /zones/zone1/dns_records/rec2",
+		"DELETE /zones/zone1/dns_records/rec3",
+	}, requests)
+	assert.Equal(t, cloudflareDNSRecord{Type: "AAAA", Name: "app.example.com", Content: "2001:db8::10", TTL: 60}, body)
+}
+
+func TestDNSProvider_CloudflareError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"success":false,"errors":[{"message":"Authentication error"}]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	provider := &cloudflareDNSProvider{apiURL: server.URL, token: "token", zoneID: "zone1"}
+	err := provider.UpdateRecord(context.Background(), "service1", "app.example.com", "203.0.113.10")
+	assert.ErrorIs(t, err, ErrorDNSUpdateFailed)
+	assert.Contains(t, err.Error(), "Authentication error")
+}
+
+func TestDNSProvider_Webhook(t *testing.T) {
+	var update webhookDNSUpdate
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := NewDNSProvider(server.URL + "/failover")
+	require.NoError(t, err)
+
+	require.NoError(t, provider.UpdateRecord(context.Background(), "service1", "app.example.com", "standby.example.com"))
+	assert.Equal(t, webhookDNSUpdate{Service: "service1", Record: "app.example.com", Type: "CNAME", Address: "standby.example.com"}, update)
+
+	status = http.StatusInternalServerError
+	err = provider.UpdateRecord(context.Background(), "service1", "app.example.com", "standby.example.com")
+	assert.ErrorIs(t, err, ErrorDNSUpdateFailed)
+}
+
+func TestDNSProvider_InvalidURL(t *testing.T) {
+	for _, rawURL := range []string{"route53://zone", "cloudflare://zone1", "https://"} {
+		t.Setenv(cloudflareTokenEnvar, "")
+		_, err := NewDNSProvider(rawURL)
+		assert.ErrorIs(t, err, ErrorUnsupportedDNSFailoverURL, rawURL)
+	}
+}
+
+// Helpers
+
+type testDNSProvider struct {
+	updates []string
+	lock    sync.Mutex
+}
+
+func (p *testDNSProvider) UpdateRecord(ctx context.Context, service, name, address string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.updates = append(p.updates, service+" "+name+" "+address)
+	return nil
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+const (
+	DockerServiceLabel = "kamal-proxy.service"
+	DockerPortLabel    = "kamal-proxy.port"
+	DockerNetworkLabel = "kamal-proxy.network"
+
+	DefaultDockerSocketPath     = "/var/run/docker.sock"
+	DefaultDockerResyncInterval = 30 * time.Second
+
+	dockerDefaultPort  = "80"
+	dockerRetryBackoff = 5 * time.Second
+)
+
+type DockerProviderConsumer interface {
+	SetDiscoveredEndpoints(endpoints map[string][]string)
+}
+
+// DockerProvider watches the Docker daemon for running containers that are
+// labeled with the service they belong to, and reports their addresses to
+// its consumer. It resyncs whenever a container starts or stops, and
+// periodically in case any events were missed.
+type DockerProvider struct {
+	consumer       DockerProviderConsumer
+	client         *http.Client
+	resyncInterval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type dockerContainer struct {
+	ID              string            `json:"Id"`
+	Labels          map[string]string `json:"Labels"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+func NewDockerProvider(consumer DockerProviderConsumer, socketPath string, resyncInterval time.Duration) *DockerProvider {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &DockerProvider{
+		consumer:       consumer,
+		resyncInterval: resyncInterval,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (p *DockerProvider) Start() {
+	changes := make(chan bool, 1)
+
+	go p.watchEvents(changes)
+	go p.run(changes)
+}
+
+func (p *DockerProvider) Close() {
+	p.cancel()
+}
+
+// Private
+
+func (p *DockerProvider) run(changes chan bool) {
+	ticker := time.NewTicker(p.resyncInterval)
+	defer ticker.Stop()
+
+	p.sync()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.sync()
+		case <-changes:
+			p.sync()
+		}
+	}
+}
+
+func (p *DockerProvider) sync() {
+	endpoints, err := p.discoverEndpoints()
+	if err != nil {
+		slog.Error("Docker: unable to list containers", "error", err)
+		return
+	}
+
+	p.consumer.SetDiscoveredEndpoints(endpoints)
+}
+
+func (p *DockerProvider) discoverEndpoints() (map[string][]string, error) {
+	filters, _ := json.Marshal(map[string][]string{
+		"label":  {DockerServiceLabel},
+		"status": {"running"},
+	})
+
+	var containers []dockerContainer
+	err := p.get("/containers/json?filters="+url.QueryEscape(string(filters)), &containers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string][]string{}
+	for _, container := range containers {
+		service := container.Labels[DockerServiceLabel]
+		address := container.address()
+		if service == "" || address == "" {
+			continue
+		}
+
+		result[service] = append(result[service], address)
+	}
+
+	for _, addresses := range result {
+		slices.Sort(addresses)
+	}
+
+	return result, nil
+}
+
+func (p *DockerProvider) watchEvents(changes chan bool) {
+	filters, _ := json.Marshal(map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "stop", "die", "pause", "unpause"},
+		"label": {DockerServiceLabel},
+	})
+
+	for {
+		err := p.streamEvents("/events?filters="+url.QueryEscape(string(filters)), changes)
+		if p.ctx.Err() != nil {
+			return
+		}
+
+		slog.Warn("Docker: event stream interrupted", "error", err)
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(dockerRetryBackoff):
+		}
+	}
+}
+
+func (p *DockerProvider) streamEvents(path string, changes chan bool) error {
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event map[string]any
+		err := decoder.Decode(&event)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case changes <- true:
+		default: // A sync is already pending
+		}
+	}
+}
+
+func (p *DockerProvider) get(path string, result any) error {
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (c dockerContainer) address() string {
+	port := c.Labels[DockerPortLabel]
+	if port == "" {
+		port = dockerDefaultPort
+	}
+
+	networks := c.NetworkSettings.Networks
+	if name := c.Labels[DockerNetworkLabel]; name != "" {
+		if network, ok := networks[name]; ok && network.IPAddress != "" {
+			return net.JoinHostPort(network.IPAddress, port)
+		}
+		return ""
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(networks)) {
+		if ip := networks[name].IPAddress; ip != "" {
+			return net.JoinHostPort(ip, port)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerProvider_ReportsLabeledContainers(t *testing.T) {
+	consumer := &testDockerConsumer{}
+	socketPath := testDockerDaemon(t, `[
+		{"Id": "1", "Labels": {"kamal-proxy.service": "web", "kamal-proxy.port": "3000"},
+		 "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.3"}}}},
+		{"Id": "2", "Labels": {"kamal-proxy.service": "web", "kamal-proxy.port": "3000"},
+		 "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.2"}}}},
+		{"Id": "3", "Labels": {"kamal-proxy.service": "api", "kamal-proxy.network": "private"},
+		 "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.4"}, "private": {"IPAddress": "10.0.0.4"}}}},
+		{"Id": "4", "Labels": {"kamal-proxy.service": "worker"},
+		 "NetworkSettings": {"Networks": {}}}
+	]`)
+
+	provider := NewDockerProvider(consumer, socketPath, time.Hour)
+	provider.Start()
+	defer provider.Close()
+
+	require.Eventually(t, func() bool { return consumer.Endpoints() != nil }, time.Second, time.Millisecond*10)
+
+	assert.Equal(t, map[string][]string{
+		"web": {"172.17.0.2:3000", "172.17.0.3:3000"},
+		"api": {"10.0.0.4:80"},
+	}, consumer.Endpoints())
+}
+
+func TestDockerProvider_ResyncsOnEvents(t *testing.T) {
+	consumer := &testDockerConsumer{}
+	socketPath := testDockerDaemon(t, `[]`)
+
+	provider := NewDockerProvider(consumer, socketPath, time.Hour)
+	provider.Start()
+	defer provider.Close()
+
+	require.Eventually(t, func() bool { return consumer.SyncCount() >= 2 }, time.Second, time.Millisecond*10)
+}
+
+// Helpers
+
+type testDockerConsumer struct {
+	lock      sync.Mutex
+	endpoints map[string][]string
+	syncs     int
+}
+
+func (c *testDockerConsumer) SetDiscoveredEndpoints(endpoints map[string][]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.endpoints = endpoints
+	c.syncs++
+}
+
+func (c *testDockerConsumer) Endpoints() map[string][]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.endpoints
+}
+
+func (c *testDockerConsumer) SyncCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.syncs
+}
+
+func testDockerDaemon(t *testing.T, containers string) string {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.URL.Query().Get("filters"), DockerServiceLabel)
+		w.Write([]byte(containers))
+	})
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Type": "container", "Action": "start"}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return socketPath
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// drainCollector reports the targets that are currently draining, and how
+// many requests each of them still has in flight, when metrics are gathered.
+// A drain that never reaches zero points to requests that are stuck.
+type drainCollector struct {
+	targets  *prometheus.Desc
+	inflight *prometheus.Desc
+
+	draining map[*Target]int
+	lock     sync.Mutex
+}
+
+func newDrainCollector() *drainCollector {
+	return &drainCollector{
+		targets:  prometheus.NewDesc(metricsNamespace+"_draining_targets", "Number of targets currently draining", nil, nil),
+		inflight: prometheus.NewDesc(metricsNamespace+"_draining_inflight_requests", "Number of requests still in flight to a draining target", []string{"target"}, nil),
+		draining: map[*Target]int{},
+	}
+}
+
+func (c *drainCollector) Begin(target *Target) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.draining[target]++
+}
+
+func (c *drainCollector) End(target *Target) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.draining[target]--
+	if c.draining[target] <= 0 {
+		delete(c.draining, target)
+	}
+}
+
+func (c *drainCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.targets
+	ch <- c.inflight
+}
+
+func (c *drainCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(c.targets, prometheus.GaugeValue, float64(len(c.draining)))
+
+	inflight := map[string]int{}
+	for target := range c.draining {
+		inflight[target.Target()] += target.inflightCount()
+	}
+	for name, count := range inflight {
+		ch <- prometheus.MustNewConstMetric(c.inflight, prometheus.GaugeValue, float64(count), name)
+	}
+}
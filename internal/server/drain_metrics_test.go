@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainCollector(t *testing.T) {
+	collector := newDrainCollector()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
+	req, err := target.StartRequest(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+
+	collector.Begin(target)
+
+	families := testGather(t, registry)
+	require.Len(t, families, 2)
+	assert.Equal(t, "kamal_proxy_draining_inflight_requests", families[0].GetName())
+	assert.Equal(t, 1.0, families[0].GetMetric()[0].GetGauge().GetValue())
+	assert.Equal(t, "kamal_proxy_draining_targets", families[1].GetName())
+	assert.Equal(t, 1.0, families[1].GetMetric()[0].GetGauge().GetValue())
+
+	target.endInflightRequest(req)
+	collector.End(target)
+
+	families = testGather(t, registry)
+	require.Len(t, families, 1)
+	assert.Equal(t, 0.0, families[0].GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestTarget_DrainIsTracked(t *testing.T) {
+	started := make(chan bool)
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+
+	go testServeRequestWithTarget(t, target, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	drained := make(chan bool)
+	go func() {
+		target.Drain(100 * time.Millisecond)
+		close(drained)
+	}()
+
+	require.Eventually(t, func() bool { return testIsDraining(target) }, time.Second, time.Millisecond)
+	<-drained
+	assert.False(t, testIsDraining(target))
+}
+
+// Helpers
+
+func testIsDraining(target *Target) bool {
+	drainMetrics.lock.Lock()
+	defer drainMetrics.lock.Unlock()
+
+	_, ok := drainMetrics.draining[target]
+	return ok
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	errorReportTimeout          = 5 * time.Second
+	errorReportQueueSize        = 100
+	errorReportThrottleInterval = time.Minute
+)
+
+var ErrorInvalidErrorReportingDSN = errors.New("error reporting DSN must be given as http(s)://<key>@<host>/<project>")
+
+// ErrorReport describes a problem in the proxy. Tags are used to group and
+// filter reports, such as by service; Extra holds details, like stack traces,
+// that are only shown with the report.
+type ErrorReport struct {
+	Kind    string
+	Message string
+	Tags    map[string]string
+	Extra   map[string]string
+}
+
+// ErrorReporter sends reports to an error tracker, so that problems in the
+// proxy show up alongside those in the applications behind it. Reports are
+// sent in the background, and dropped if the tracker can't keep up, so
+// reporting never holds up requests.
+type ErrorReporter interface {
+	Report(report ErrorReport)
+	Close()
+}
+
+// NewErrorReporter creates a reporter for a Sentry DSN, which is also
+// accepted by other trackers that implement Sentry's protocol.
+func NewErrorReporter(dsn string) (ErrorReporter, error) {
+	return newSentryReporter(dsn)
+}
+
+// errorReporting holds the reporter used by the parts of the proxy that
+// don't have one of their own, such as targets and certificate managers.
+// Their errors tend to repeat, so each kind of error is reported at most once
+// per errorReportThrottleInterval.
+var errorReporting struct {
+	sync.Mutex
+	reporter ErrorReporter
+	reported map[string]time.Time
+}
+
+// SetErrorReporter changes where proxy errors are reported; nil stops
+// reporting them.
+func SetErrorReporter(reporter ErrorReporter) {
+	errorReporting.Lock()
+	defer errorReporting.Unlock()
+
+	errorReporting.reporter = reporter
+	errorReporting.reported = map[string]time.Time{}
+}
+
+// reportError sends a report, unless one with the same key was sent
+// recently.
+func reportError(key string, report ErrorReport) {
+	errorReporting.Lock()
+	defer errorReporting.Unlock()
+
+	if errorReporting.reporter == nil {
+		return
+	}
+
+	now := time.Now()
+	if last, ok := errorReporting.reported[key]; ok && now.Sub(last) < errorReportThrottleInterval {
+		return
+	}
+
+	for k, last := range errorReporting.reported {
+		if now.Sub(last) >= errorReportThrottleInterval {
+			delete(errorReporting.reported, k)
+		}
+	}
+	errorReporting.reported[key] = now
+
+	errorReporting.reporter.Report(report)
+}
+
+// Sentry
+
+type sentryReporter struct {
+	endpoint   string
+	auth       string
+	serverName string
+	client     *http.Client
+	queue      chan sentryEvent
+	done       chan struct{}
+}
+
+type sentryEvent struct {
+	EventID    string            `json:"event_id"`
+	Timestamp  string            `json:"timestamp"`
+	Level      string            `json:"level"`
+	Platform   string            `json:"platform"`
+	Logger     string            `json:"logger"`
+	ServerName string            `json:"server_name,omitempty"`
+	Message    string            `json:"message"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Extra      map[string]string `json:"extra,omitempty"`
+	Exception  *sentryException  `json:"exception,omitempty"`
+}
+
+type sentryException struct {
+	Values []sentryExceptionValue `json:"values"`
+}
+
+type sentryExceptionValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func newSentryReporter(dsn string) (*sentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidErrorReportingDSN, dsn)
+	}
+
+	path, project, found := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if !found {
+		path, project = "", path
+	} else {
+		path, project = "/"+path, strings.TrimSuffix(project, "/")
+	}
+	if project == "" || strings.Contains(project, "/") {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidErrorReportingDSN, dsn)
+	}
+
+	auth := "Sentry sentry_version=7, sentry_client=kamal-proxy, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+
+	serverName, _ := os.Hostname()
+
+	r := &sentryReporter{
+		endpoint:   fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path, project),
+		auth:       auth,
+		serverName: serverName,
+		client:     &http.Client{Timeout: errorReportTimeout},
+		queue:      make(chan sentryEvent, errorReportQueueSize),
+		done:       make(chan struct{}),
+	}
+
+	go r.run()
+	return r, nil
+}
+
+func (r *sentryReporter) Report(report ErrorReport) {
+	event := sentryEvent{
+		EventID:    strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		Level:      "error",
+		Platform:   "go",
+		Logger:     "kamal-proxy",
+		ServerName: r.serverName,
+		Message:    report.Message,
+		Tags:       report.Tags,
+		Extra:      report.Extra,
+	}
+	if report.Kind != "" {
+		event.Exception = &sentryException{Values: []sentryExceptionValue{{Type: report.Kind, Value: report.Message}}}
+	}
+
+	select {
+	case r.queue <- event:
+	default:
+		slog.Warn("Dropping error report, as the queue is full", "message", report.Message)
+	}
+}
+
+// Close sends any reports that are still queued, and stops the reporter.
+func (r *sentryReporter) Close() {
+	close(r.queue)
+	<-r.done
+}
+
+// Private
+
+func (r *sentryReporter) run() {
+	defer close(r.done)
+
+	for event := range r.queue {
+		err := r.send(event)
+		if err != nil {
+			slog.Warn("Unable to send error report", "endpoint", r.endpoint, "error", err)
+		}
+	}
+}
+
+func (r *sentryReporter) send(event sentryEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), errorReportTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorReporter_SendsSentryEvents(t *testing.T) {
+	events := make(chan sentryEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sentry/api/42/store/", r.URL.Path)
+		assert.Contains(t, r.Header.Get("X-Sentry-Auth"), "sentry_key=public")
+
+		var event sentryEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	t.Cleanup(server.Close)
+
+	reporter, err := NewErrorReporter(strings.Replace(server.URL, "http://", "http://public@", 1) + "/sentry/42")
+	require.NoError(t, err)
+
+	reporter.Report(ErrorReport{Kind: "panic", Message: "boom", Tags: map[string]string{"service": "web"}})
+	reporter.Close()
+
+	event := <-events
+	assert.Equal(t, "boom", event.Message)
+	assert.Equal(t, "error", event.Level)
+	assert.Equal(t, "web", event.Tags["service"])
+	assert.Equal(t, "panic", event.Exception.Values[0].Type)
+	assert.Len(t, event.EventID, 32)
+}
+
+func TestErrorReporter_InvalidDSN(t *testing.T) {
+	for _, dsn := range []string{"sentry.example.com/1", "https://sentry.example.com/1", "https://key@sentry.example.com", "ftp://key@sentry.example.com/1"} {
+		_, err := NewErrorReporter(dsn)
+		assert.ErrorIs(t, err, ErrorInvalidErrorReportingDSN, dsn)
+	}
+}
+
+func TestErrorReporter_ThrottlesRepeatedErrors(t *testing.T) {
+	reporter := testSetErrorReporter(t)
+
+	reportError("upstream:web", ErrorReport{Message: "first"})
+	reportError("upstream:web", ErrorReport{Message: "second"})
+	reportError("upstream:api", ErrorReport{Message: "third"})
+
+	reports := reporter.Reports()
+	require.Len(t, reports, 2)
+	assert.Equal(t, "first", reports[0].Message)
+	assert.Equal(t, "third", reports[1].Message)
+}
+
+func TestErrorReporter_ReportsUpstreamFailures(t *testing.T) {
+	reporter := testSetErrorReporter(t)
+
+	backend := httptest.NewServer(http.NotFoundHandler())
+	target, err := NewTarget(backend.Listener.Addr().String(), defaultTargetOptions)
+	require.NoError(t, err)
+	backend.Close()
+
+	w := httptest.NewRecorder()
+	testServeRequestWithTarget(t, target, w, httptest.NewRequest(http.MethodGet, "/orders", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	reports := reporter.Reports()
+	require.Len(t, reports, 1)
+	assert.Equal(t, "upstream", reports[0].Kind)
+	assert.Equal(t, target.Target(), reports[0].Tags["target"])
+	assert.Equal(t, "/orders", reports[0].Extra["path"])
+}
+
+func TestErrorReporter_ReportsStateErrors(t *testing.T) {
+	reporter := testSetErrorReporter(t)
+
+	blocker := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(blocker, nil, 0644))
+
+	router := NewRouter(filepath.Join(blocker, "state.json"))
+	assert.Error(t, router.saveStateSnapshot())
+
+	reports := reporter.Reports()
+	require.Len(t, reports, 1)
+	assert.Equal(t, "state", reports[0].Kind)
+	assert.Contains(t, reports[0].Message, "Unable to save state")
+}
+
+// Helpers
+
+func testSetErrorReporter(t *testing.T) *testErrorReporter {
+	reporter := &testErrorReporter{}
+	SetErrorReporter(reporter)
+	t.Cleanup(func() { SetErrorReporter(nil) })
+	return reporter
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultExternalAuthTimeout = 5 * time.Second
+
+	maxExternalAuthBodySize     = 64 * KB
+	maxExternalAuthCacheEntries = 10_000
+)
+
+var ErrorInvalidExternalAuthURL = errors.New("external authorization URL must be an absolute http or https URL")
+
+// Headers that describe a connection rather than a request, and so aren't
+// passed between the request and the authorization service.
+var externalAuthHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection",
+	"TE", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length",
+}
+
+type ExternalAuthConfig struct {
+	URL             string
+	Timeout         time.Duration
+	CacheDuration   time.Duration
+	ResponseHeaders []string
+	CacheKeyHeaders []string
+}
+
+// ExternalAuthorizer asks an external service whether each request should be
+// allowed, in the style of Envoy's ext_authz. The service is sent a GET with
+// the original request's headers, and details of the request in the
+// X-Forwarded-Method, X-Forwarded-Proto, X-Forwarded-Host, X-Forwarded-Uri and
+// X-Forwarded-For headers.
+//
+// A 200 response allows the request, and any of the ResponseHeaders it
+// contains are added to it. Any other response is sent to the client in place
+// of the target's, so the service can redirect to a sign in page, for
+// example.
+//
+// Decisions can be cached for CacheDuration, keyed by the request's method,
+// host and URI, the client's IP address, and its Authorization and Cookie
+// headers, along with any CacheKeyHeaders. A service whose decisions depend
+// on other headers must have them added to CacheKeyHeaders, or its decisions
+// would be shared with clients that didn't send them.
+type ExternalAuthorizer struct {
+	config ExternalAuthConfig
+	client *http.Client
+
+	cache     map[[sha256.Size]byte]*externalAuthDecision
+	cacheLock sync.Mutex
+}
+
+type externalAuthDecision struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	expiresAt  time.Time
+}
+
+func NewExternalAuthorizer(config ExternalAuthConfig) (*ExternalAuthorizer, error) {
+	authURL, err := url.Parse(config.URL)
+	if err != nil || (authURL.Scheme != "http" && authURL.Scheme != "https") || authURL.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidExternalAuthURL, config.URL)
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultExternalAuthTimeout
+	}
+
+	client := &http.Client{
+		Timeout: config.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Redirects are for the client to follow, such as to sign in.
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return &ExternalAuthorizer{
+		config: config,
+		client: client,
+		cache:  map[[sha256.Size]byte]*externalAuthDecision{},
+	}, nil
+}
+
+// Authorize returns true if it has responded to the request itself, because
+// the request was denied or couldn't be checked. Otherwise the request should
+// be proxied.
+func (a *ExternalAuthorizer) Authorize(w http.ResponseWriter, r *http.Request) bool {
+	// Only the authorization service may set these, so that the target can
+	// trust them.
+	for _, name := range a.config.ResponseHeaders {
+		r.Header.Del(name)
+	}
+
+	decision, err := a.decide(r)
+	if err != nil {
+		slog.Error("Unable to authorize request", "url", a.config.URL, "path", r.URL.Path, "error", err)
+		SetErrorResponse(w, r, http.StatusBadGateway, nil)
+		return true
+	}
+
+	if decision.statusCode == http.StatusOK {
+		for _, name := range a.config.ResponseHeaders {
+			if values := decision.header.Values(name); len(values) > 0 {
+				r.Header[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		return false
+	}
+
+	slog.Debug("Request denied by authorization service", "path", r.URL.Path, "status", decision.statusCode)
+
+	for name, values := range decision.header {
+		w.Header()[name] = values
+	}
+	for _, name := range externalAuthHopHeaders {
+		w.Header().Del(name)
+	}
+
+	if len(decision.body) == 0 {
+		SetErrorResponse(w, r, decision.statusCode, nil)
+		return true
+	}
+
+	w.WriteHeader(decision.statusCode)
+	w.Write(decision.body)
+	return true
+}
+
+// Private
+
+func (a *ExternalAuthorizer) decide(r *http.Request) (*externalAuthDecision, error) {
+	if a.config.CacheDuration <= 0 {
+		return a.ask(r)
+	}
+
+	key := a.cacheKey(r)
+	if decision := a.cached(key); decision != nil {
+		return decision, nil
+	}
+
+	decision, err := a.ask(r)
+	if err != nil {
+		return nil, err
+	}
+
+	decision.expiresAt = time.Now().Add(a.config.CacheDuration)
+	a.store(key, decision)
+	return decision, nil
+}
+
+func (a *ExternalAuthorizer) ask(r *http.Request) (*externalAuthDecision, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, a.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = r.Header.Clone()
+	for _, name := range externalAuthHopHeaders {
+		req.Header.Del(name)
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", proto)
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	req.Header.Set("X-Forwarded-For", externalAuthClientIP(r))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxExternalAuthBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &externalAuthDecision{statusCode: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+func (a *ExternalAuthorizer) cacheKey(r *http.Request) [sha256.Size]byte {
+	parts := []string{r.Method, r.Host, r.URL.RequestURI(), externalAuthClientIP(r), r.Header.Get("Authorization")}
+	parts = append(parts, r.Header.Values("Cookie")...)
+	for _, name := range a.config.CacheKeyHeaders {
+		parts = append(parts, http.CanonicalHeaderKey(name))
+		parts = append(parts, r.Header.Values(name)...)
+	}
+
+	hasher := sha256.New()
+	for _, part := range parts {
+		io.WriteString(hasher, part+"\x00")
+	}
+
+	var key [sha256.Size]byte
+	hasher.Sum(key[:0])
+	return key
+}
+
+func externalAuthClientIP(r *http.Request) string {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return clientIP
+}
+
+func (a *ExternalAuthorizer) cached(key [sha256.Size]byte) *externalAuthDecision {
+	a.cacheLock.Lock()
+	defer a.cacheLock.Unlock()
+
+	decision, ok := a.cache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(decision.expiresAt) {
+		delete(a.cache, key)
+		return nil
+	}
+	return decision
+}
+
+func (a *ExternalAuthorizer) store(key [sha256.Size]byte, decision *externalAuthDecision) {
+	a.cacheLock.Lock()
+	defer a.cacheLock.Unlock()
+
+	if len(a.cache) >= maxExternalAuthCacheEntries {
+		now := time.Now()
+		for k, d := range a.cache {
+			if now.After(d.expiresAt) {
+				delete(a.cache, k)
+			}
+		}
+		if len(a.cache) >= maxExternalAuthCacheEntries {
+			clear(a.cache)
+		}
+	}
+
+	a.cache[key] = decision
+}
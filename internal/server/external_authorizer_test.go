@@ -0,0 +1,154 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalAuthorizer_AllowsRequests(t *testing.T) {
+	var authRequest *http.Request
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authRequest = r
+		w.Header().Set("X-User-Id", "42")
+		w.Header().Set("X-Other", "ignored")
+	}))
+	defer authServer.Close()
+
+	authorizer, err := NewExternalAuthorizer(ExternalAuthConfig{URL: authServer.URL + "/check", ResponseHeaders: []string{"X-User-Id"}})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "http://app.example.com/orders?page=2", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-User-Id", "spoofed")
+
+	w := httptest.NewRecorder()
+	assert.False(t, authorizer.Authorize(w, req))
+
+	assert.Equal(t, "42", req.Header.Get("X-User-Id"))
+	assert.Empty(t, req.Header.Get("X-Other"))
+
+	assert.Equal(t, "GET", authRequest.Method)
+	assert.Equal(t, "/check", authRequest.URL.Path)
+	assert.Equal(t, "Bearer token", authRequest.Header.Get("Authorization"))
+	assert.Empty(t, authRequest.Header.Get("X-User-Id"))
+	assert.Equal(t, "POST", authRequest.Header.Get("X-Forwarded-Method"))
+	assert.Equal(t, "http", authRequest.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "app.example.com", authRequest.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "/orders?page=2", authRequest.Header.Get("X-Forwarded-Uri"))
+	assert.Equal(t, "192.0.2.1", authRequest.Header.Get("X-Forwarded-For"))
+}
+
+func TestExternalAuthorizer_DeniesRequests(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			http.Redirect(w, r, "https://login.example.com/", http.StatusFound)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer authServer.Close()
+
+	authorizer, err := NewExternalAuthorizer(ExternalAuthConfig{URL: authServer.URL})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	assert.True(t, authorizer.Authorize(w, httptest.NewRequest("GET", "http://app.example.com/", nil)))
+	assert.Equal(t, http.StatusFound, w.Result().StatusCode)
+	assert.Equal(t, "https://login.example.com/", w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), "Found")
+
+	req := httptest.NewRequest("GET", "http://app.example.com/", nil)
+	req.Header.Set("Authorization", "Bearer expired")
+
+	w = httptest.NewRecorder()
+	assert.True(t, authorizer.Authorize(w, req))
+	assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+}
+
+func TestExternalAuthorizer_Unavailable(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	authServer.Close()
+
+	authorizer, err := NewExternalAuthorizer(ExternalAuthConfig{URL: authServer.URL})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	assert.True(t, authorizer.Authorize(w, httptest.NewRequest("GET", "http://app.example.com/", nil)))
+	assert.Equal(t, http.StatusBadGateway, w.Result().StatusCode)
+}
+
+func TestExternalAuthorizer_CachesDecisions(t *testing.T) {
+	var calls atomic.Int32
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("Authorization") != "Bearer valid" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer authServer.Close()
+
+	authorizer, err := NewExternalAuthorizer(ExternalAuthConfig{URL: authServer.URL, CacheDuration: time.Minute})
+	require.NoError(t, err)
+
+	authorize := func(token string) int {
+		req := httptest.NewRequest("GET", "http://app.example.com/", nil)
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		authorizer.Authorize(w, req)
+		return w.Result().StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, authorize("Bearer valid"))
+	assert.Equal(t, http.StatusOK, authorize("Bearer valid"))
+	assert.Equal(t, int32(1), calls.Load())
+
+	assert.Equal(t, http.StatusForbidden, authorize("Bearer other"))
+	assert.Equal(t, http.StatusForbidden, authorize("Bearer other"))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestExternalAuthorizer_CacheKeyIncludesClientAndKeyHeaders(t *testing.T) {
+	var calls atomic.Int32
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("X-Api-Key") != "valid" || r.Header.Get("X-Forwarded-For") != "192.0.2.1" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer authServer.Close()
+
+	authorizer, err := NewExternalAuthorizer(ExternalAuthConfig{URL: authServer.URL, CacheDuration: time.Minute, CacheKeyHeaders: []string{"x-api-key"}})
+	require.NoError(t, err)
+
+	authorize := func(remoteAddr, key string) int {
+		req := httptest.NewRequest("GET", "http://app.example.com/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		authorizer.Authorize(w, req)
+		return w.Result().StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, authorize("192.0.2.1:1234", "valid"))
+	assert.Equal(t, http.StatusOK, authorize("192.0.2.1:5678", "valid"))
+	assert.Equal(t, int32(1), calls.Load())
+
+	assert.Equal(t, http.StatusForbidden, authorize("192.0.2.1:1234", "invalid"))
+	assert.Equal(t, http.StatusForbidden, authorize("198.51.100.1:1234", "valid"))
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestExternalAuthorizer_InvalidURL(t *testing.T) {
+	for _, url := range []string{"", "auth:9000", "ftp://auth.example.com/"} {
+		_, err := NewExternalAuthorizer(ExternalAuthConfig{URL: url})
+		assert.ErrorIs(t, err, ErrorInvalidExternalAuthURL, url)
+	}
+}
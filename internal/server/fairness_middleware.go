@@ -0,0 +1,105 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const (
+	DefaultFairShareMinInflight = 10
+
+	fairShareRetryAfter = "1"
+)
+
+type FairnessConfig struct {
+	Share       int
+	MinInflight int
+	Key         string
+}
+
+// FairnessMiddleware stops any one client from taking more than its share of
+// a target's concurrency. Clients are identified by the Key header, such as an
+// API key, or by their IP address. While fewer than MinInflight requests are
+// in flight there is capacity to spare, and nothing is throttled; after that,
+// a client may hold at most Share percent of the requests in flight, and
+// further requests from it are rejected with a 429. Unlike a rate limit,
+// this adapts to how busy the target is, rather than to a fixed number.
+type FairnessMiddleware struct {
+	config  FairnessConfig
+	next    http.Handler
+	clients map[string]int
+	total   int
+	lock    sync.Mutex
+}
+
+func WithFairnessMiddleware(config FairnessConfig, next http.Handler) http.Handler {
+	if config.MinInflight <= 0 {
+		config.MinInflight = DefaultFairShareMinInflight
+	}
+	config.Key = http.CanonicalHeaderKey(config.Key)
+
+	return &FairnessMiddleware{
+		config:  config,
+		next:    next,
+		clients: map[string]int{},
+	}
+}
+
+func (h *FairnessMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := h.clientKey(r)
+	if !h.begin(client) {
+		service := LoggingRequestContext(r).Service
+		slog.Debug("Throttling client over its fair share", "service", service, "path", r.URL.Path)
+		throttledRequestsCounter.WithLabelValues(service).Inc()
+
+		w.Header().Set("Retry-After", fairShareRetryAfter)
+		SetErrorResponse(w, r, http.StatusTooManyRequests, nil)
+		return
+	}
+	defer h.end(client)
+
+	h.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (h *FairnessMiddleware) begin(client string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	busy := h.total >= h.config.MinInflight
+	if busy && (h.clients[client]+1)*100 > h.config.Share*(h.total+1) {
+		return false
+	}
+
+	h.clients[client]++
+	h.total++
+	return true
+}
+
+func (h *FairnessMiddleware) end(client string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.total--
+	h.clients[client]--
+	if h.clients[client] <= 0 {
+		delete(h.clients, client)
+	}
+}
+
+func (h *FairnessMiddleware) clientKey(r *http.Request) string {
+	if h.config.Key != "" {
+		if key := r.Header.Get(h.config.Key); key != "" {
+			return "key:" + key
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return "ip:" + ip
+}
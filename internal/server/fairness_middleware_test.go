@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFairnessMiddleware_LimitsClientsToTheirShareWhenBusy(t *testing.T) {
+	h := WithFairnessMiddleware(FairnessConfig{Share: 50, MinInflight: 4}, http.NotFoundHandler()).(*FairnessMiddleware)
+
+	for range 4 {
+		assert.True(t, h.begin("a"))
+	}
+	assert.False(t, h.begin("a"))
+
+	for range 4 {
+		assert.True(t, h.begin("b"))
+	}
+	assert.False(t, h.begin("b"))
+
+	for range 3 {
+		h.end("a")
+	}
+	assert.False(t, h.begin("b"))
+	assert.True(t, h.begin("a"))
+}
+
+func TestFairnessMiddleware_NotBusy(t *testing.T) {
+	h := WithFairnessMiddleware(FairnessConfig{Share: 10}, http.NotFoundHandler()).(*FairnessMiddleware)
+
+	for range DefaultFairShareMinInflight {
+		assert.True(t, h.begin("a"))
+	}
+	assert.False(t, h.begin("a"))
+
+	for range DefaultFairShareMinInflight {
+		h.end("a")
+	}
+	assert.Empty(t, h.clients)
+	assert.True(t, h.begin("a"))
+}
+
+func TestFairnessMiddleware_ClientKey(t *testing.T) {
+	h := WithFairnessMiddleware(FairnessConfig{Share: 50, Key: "x-api-key"}, http.NotFoundHandler()).(*FairnessMiddleware)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, "ip:10.0.0.1", h.clientKey(req))
+
+	req.Header.Set("X-Api-Key", "secret")
+	assert.Equal(t, "key:secret", h.clientKey(req))
+}
+
+func TestFairnessMiddleware_RejectsWithTooManyRequests(t *testing.T) {
+	h := WithFairnessMiddleware(FairnessConfig{Share: 50, MinInflight: 1}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	fm := h.(*FairnessMiddleware)
+	fm.begin("ip:192.0.2.1")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Result().StatusCode)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	req.RemoteAddr = "192.0.2.2:1234"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, 1, fm.total)
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHALeaseDuration = 15 * time.Second
+	DefaultHAHealthPath    = "/kamal-proxy/ha"
+
+	haLeaseFilename = "lease.json"
+	haStateFilename = "kamal-proxy.state"
+)
+
+// haLeaseRecord is the lease as it's kept in the shared directory.
+type haLeaseRecord struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HALease coordinates a pair of proxies, so that only one of them is active
+// at a time. They share a directory, such as on a network filesystem, that
+// holds a lease naming the active proxy. The active proxy keeps renewing the
+// lease, and the standby takes it over once it expires.
+//
+// The active proxy also keeps a copy of its state in the directory, which
+// the standby restores, so that it's ready to take over with the same
+// services. An upstream load balancer finds out which proxy to send requests
+// to from WithHAHealthMiddleware.
+type HALease struct {
+	router   *Router
+	dir      string
+	nodeID   string
+	duration time.Duration
+
+	lock        sync.Mutex
+	active      bool
+	sharedState []byte
+}
+
+func NewHALease(router *Router, dir, nodeID string, duration time.Duration) *HALease {
+	return &HALease{
+		router:   router,
+		dir:      dir,
+		nodeID:   nodeID,
+		duration: duration,
+	}
+}
+
+// Active reports whether this proxy holds the lease.
+func (l *HALease) Active() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.active
+}
+
+// Renew takes or renews the lease, if it's free or already ours, and then
+// shares or restores the state, depending on whether we're active.
+func (l *HALease) Renew(now time.Time) {
+	active := l.acquire(now)
+
+	l.lock.Lock()
+	changed := active != l.active
+	l.active = active
+	l.lock.Unlock()
+
+	if changed {
+		if active {
+			slog.Warn("HA lease acquired; this proxy is now active", "node", l.nodeID)
+		} else {
+			slog.Warn("HA lease lost; this proxy is now on standby", "node", l.nodeID)
+		}
+	}
+
+	if active {
+		l.shareState()
+	} else {
+		l.restoreSharedState()
+	}
+}
+
+// Release gives up the lease, if we hold it, so that the standby can take
+// over without waiting for it to expire.
+func (l *HALease) Release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.active {
+		return
+	}
+	l.active = false
+
+	record, err := l.readLease()
+	if err != nil || record.Holder != l.nodeID {
+		return
+	}
+
+	err = l.writeLease(haLeaseRecord{Holder: l.nodeID})
+	if err != nil {
+		slog.Error("Unable to release HA lease", "path", l.leasePath(), "error", err)
+		return
+	}
+	slog.Info("HA lease released", "node", l.nodeID)
+}
+
+// Private
+
+func (l *HALease) acquire(now time.Time) bool {
+	record, err := l.readLease()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Without being able to tell who holds the lease, it's safer to stand
+		// by than to risk both proxies being active.
+		slog.Error("Unable to read HA lease", "path", l.leasePath(), "error", err)
+		return false
+	}
+
+	if record.Holder != l.nodeID && now.Before(record.ExpiresAt) {
+		return false
+	}
+
+	err = l.writeLease(haLeaseRecord{Holder: l.nodeID, ExpiresAt: now.Add(l.duration)})
+	if err != nil {
+		slog.Error("Unable to write HA lease", "path", l.leasePath(), "error", err)
+		return false
+	}
+
+	// Both proxies may have seen the lease expire, and written their own. The
+	// last write wins, so check that it was ours.
+	record, err = l.readLease()
+	return err == nil && record.Holder == l.nodeID
+}
+
+func (l *HALease) shareState() {
+	data, err := l.router.ExportState()
+	if err != nil {
+		slog.Error("Unable to export state for HA standby", "error", err)
+		return
+	}
+
+	if bytes.Equal(data, l.sharedState) {
+		return
+	}
+
+	err = writeFileAtomically(l.statePath(), data)
+	if err != nil {
+		slog.Error("Unable to share state with HA standby", "path", l.statePath(), "error", err)
+		return
+	}
+	l.sharedState = data
+}
+
+func (l *HALease) restoreSharedState() {
+	data, err := os.ReadFile(l.statePath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Unable to read shared HA state", "path", l.statePath(), "error", err)
+		}
+		return
+	}
+
+	if bytes.Equal(data, l.sharedState) {
+		return
+	}
+
+	slog.Info("Restoring state shared by the active proxy", "path", l.statePath())
+	err = l.router.RestoreState(data, DefaultDeployTimeout, DefaultDrainTimeout)
+	if err != nil {
+		slog.Error("Unable to restore shared HA state", "path", l.statePath(), "error", err)
+		return
+	}
+	l.sharedState = data
+}
+
+func (l *HALease) readLease() (haLeaseRecord, error) {
+	var record haLeaseRecord
+
+	data, err := os.ReadFile(l.leasePath())
+	if err != nil {
+		return record, err
+	}
+
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+func (l *HALease) writeLease(record haLeaseRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(l.leasePath(), data)
+}
+
+func (l *HALease) leasePath() string {
+	return filepath.Join(l.dir, haLeaseFilename)
+}
+
+func (l *HALease) statePath() string {
+	return filepath.Join(l.dir, haStateFilename)
+}
+
+// WithHAHealthMiddleware answers health checks from an upstream load
+// balancer, on the given path, with a 200 while this proxy is active, and a
+// 503 while it's on standby.
+func WithHAHealthMiddleware(lease *HALease, path string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "no-store")
+		if lease.Active() {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("active\n"))
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("standby\n"))
+		}
+	})
+}
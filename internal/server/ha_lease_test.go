@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHALease_OnlyOneProxyIsActive(t *testing.T) {
+	dir := t.TempDir()
+	first := NewHALease(testRouter(t), dir, "first", time.Minute)
+	second := NewHALease(testRouter(t), dir, "second", time.Minute)
+	now := time.Now()
+
+	first.Renew(now)
+	second.Renew(now)
+	assert.True(t, first.Active())
+	assert.False(t, second.Active())
+
+	first.Renew(now.Add(30 * time.Second))
+	second.Renew(now.Add(75 * time.Second))
+	assert.False(t, second.Active())
+
+	second.Renew(now.Add(2 * time.Minute))
+	assert.True(t, second.Active())
+
+	first.Renew(now.Add(2 * time.Minute))
+	assert.False(t, first.Active())
+}
+
+func TestHALease_ReleaseLetsStandbyTakeOver(t *testing.T) {
+	dir := t.TempDir()
+	first := NewHALease(testRouter(t), dir, "first", time.Minute)
+	second := NewHALease(testRouter(t), dir, "second", time.Minute)
+	now := time.Now()
+
+	first.Renew(now)
+	first.Release()
+	assert.False(t, first.Active())
+
+	second.Renew(now.Add(time.Second))
+	assert.True(t, second.Active())
+}
+
+func TestHALease_StandbyRestoresSharedState(t *testing.T) {
+	dir := t.TempDir()
+	activeRouter := testRouter(t)
+	standbyRouter := testRouter(t)
+	active := NewHALease(activeRouter, dir, "first", time.Minute)
+	standby := NewHALease(standbyRouter, dir, "second", time.Minute)
+
+	_, target := testBackend(t, "first", http.StatusOK)
+	_, err := activeRouter.DeployServiceTarget("service1", defaultEmptyHosts, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout, 0, false)
+	require.NoError(t, err)
+
+	now := time.Now()
+	active.Renew(now)
+	standby.Renew(now)
+
+	statusCode, body := sendGETRequest(standbyRouter, "http://example.com/")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "first", body)
+}
+
+func TestHALease_HealthMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	first := NewHALease(testRouter(t), dir, "first", time.Minute)
+	second := NewHALease(testRouter(t), dir, "second", time.Minute)
+	first.Renew(time.Now())
+	second.Renew(time.Now())
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	check := func(lease *HALease, path string) int {
+		w := httptest.NewRecorder()
+		WithHAHealthMiddleware(lease, DefaultHAHealthPath, next).ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Result().StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, check(first, DefaultHAHealthPath))
+	assert.Equal(t, http.StatusServiceUnavailable, check(second, DefaultHAHealthPath))
+	assert.Equal(t, http.StatusTeapot, check(second, "/"))
+}
@@ -3,11 +3,7 @@ package server
 import (
 	"context"
 	"errors"
-	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
-	"net/url"
 	"time"
 )
 
@@ -21,22 +17,30 @@ var (
 )
 
 type HealthCheckConsumer interface {
-	HealthCheckCompleted(success bool)
+	HealthCheckCompleted(result HealthCheckResult)
+}
+
+type HealthCheckResult struct {
+	Time       time.Time     `json:"time"`
+	Latency    time.Duration `json:"latency"`
+	Success    bool          `json:"success"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
 }
 
 type HealthCheck struct {
 	consumer HealthCheckConsumer
-	endpoint *url.URL
+	probe    HealthCheckProbe
 	interval time.Duration
 	timeout  time.Duration
 
 	shutdown chan (bool)
 }
 
-func NewHealthCheck(consumer HealthCheckConsumer, endpoint *url.URL, interval time.Duration, timeout time.Duration) *HealthCheck {
+func NewHealthCheck(consumer HealthCheckConsumer, probe HealthCheckProbe, interval time.Duration, timeout time.Duration) *HealthCheck {
 	hc := &HealthCheck{
 		consumer: consumer,
-		endpoint: endpoint,
+		probe:    probe,
 		interval: interval,
 		timeout:  timeout,
 
@@ -76,48 +80,40 @@ func (hc *HealthCheck) run() {
 }
 
 func (hc *HealthCheck) check() {
+	started := time.Now()
+	result := HealthCheckResult{Time: started}
+
 	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hc.endpoint.String(), nil)
-	if err != nil {
-		hc.reportResult(false, err)
-		return
-	}
+	statusCode, err := hc.probe.Probe(ctx)
+	result.Latency = time.Since(started)
+	result.StatusCode = statusCode
 
-	req.Header.Set("User-Agent", healthCheckUserAgent)
-
-	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
 			err = ErrorHealthCheckRequestTimedOut
 		}
-		hc.reportResult(false, err)
-		return
-	}
-	defer resp.Body.Close()
-
-	_, _ = io.Copy(io.Discard, resp.Body)
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		hc.reportResult(false, fmt.Errorf("%w (%d)", ErrorHealthCheckUnexpectedStatus, resp.StatusCode))
+		hc.reportResult(result, err)
 		return
 	}
 
-	hc.reportResult(true, nil)
+	result.Success = true
+	hc.reportResult(result, nil)
 }
 
-func (hc *HealthCheck) reportResult(success bool, err error) {
+func (hc *HealthCheck) reportResult(result HealthCheckResult, err error) {
 	select {
 	case <-hc.shutdown:
 		return // Ignore late results after close
 	default:
-		if success {
+		if result.Success {
 			slog.Info("Healthcheck succeeded")
 		} else {
+			result.Error = err.Error()
 			slog.Info("Healthcheck failed", "error", err)
 		}
 
-		hc.consumer.HealthCheckCompleted(success)
+		hc.consumer.HealthCheckCompleted(result)
 	}
 }
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+var ErrorInvalidHealthCheckCIDR = errors.New("health check allowed addresses must be IP addresses or CIDR ranges, such as 10.0.0.0/8")
+
+// HealthCheckAccess decides whether requests for a target's health check
+// path that arrive through the proxy are passed on to it. When Blocked, they
+// are only passed on from the Allowed ranges. The proxy's own health checks
+// go straight to the target, so they're never affected.
+//
+// Requests are matched by the address they were received from, rather than
+// by any forwarding headers, which clients could set themselves.
+type HealthCheckAccess struct {
+	Blocked bool
+	Allowed []netip.Prefix
+}
+
+// ParseHealthCheckAccess blocks the health check path when asked to, or when
+// there are allowed ranges to restrict it to. Ranges may be given as CIDRs,
+// or as single addresses.
+func ParseHealthCheckAccess(block bool, allowed []string) (HealthCheckAccess, error) {
+	access := HealthCheckAccess{Blocked: block || len(allowed) > 0}
+
+	for _, value := range allowed {
+		prefix, err := parseAddressOrPrefix(value)
+		if err != nil {
+			return HealthCheckAccess{}, fmt.Errorf("%w: %s", ErrorInvalidHealthCheckCIDR, value)
+		}
+		access.Allowed = append(access.Allowed, prefix)
+	}
+	return access, nil
+}
+
+// isHealthCheckPath reports whether a request path reaches the health check.
+// Paths are cleaned first, since apps will generally route them the same
+// way, and any path below the health check, or with an extension added to
+// it, such as /up/ or /up.json, is included too.
+func isHealthCheckPath(requestPath, healthCheckPath string) bool {
+	requestPath = path.Clean("/" + requestPath)
+	healthCheckPath = path.Clean("/" + healthCheckPath)
+
+	if healthCheckPath == "/" {
+		return requestPath == "/"
+	}
+
+	rest, found := strings.CutPrefix(requestPath, healthCheckPath)
+	return found && (rest == "" || strings.HasPrefix(rest, "/") || strings.HasPrefix(rest, "."))
+}
+
+func (a HealthCheckAccess) Allows(r *http.Request) bool {
+	if !a.Blocked {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range a.Allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Private
+
+func parseAddressOrPrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheckAccess_Allows(t *testing.T) {
+	requestFrom := func(remoteAddr string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/up", nil)
+		req.RemoteAddr = remoteAddr
+		return req
+	}
+
+	access, err := ParseHealthCheckAccess(false, nil)
+	require.NoError(t, err)
+	assert.True(t, access.Allows(requestFrom("203.0.113.1:1234")))
+
+	access, err = ParseHealthCheckAccess(true, nil)
+	require.NoError(t, err)
+	assert.False(t, access.Allows(requestFrom("10.0.0.1:1234")))
+
+	access, err = ParseHealthCheckAccess(false, []string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"})
+	require.NoError(t, err)
+	assert.True(t, access.Allows(requestFrom("10.1.2.3:1234")))
+	assert.True(t, access.Allows(requestFrom("[::ffff:10.1.2.3]:1234")))
+	assert.True(t, access.Allows(requestFrom("192.168.1.5:1234")))
+	assert.True(t, access.Allows(requestFrom("[fd12::1]:1234")))
+	assert.False(t, access.Allows(requestFrom("192.168.1.6:1234")))
+	assert.False(t, access.Allows(requestFrom("203.0.113.1:1234")))
+	assert.False(t, access.Allows(requestFrom("not-an-address")))
+
+	_, err = ParseHealthCheckAccess(true, []string{"10.0.0.0/33"})
+	assert.ErrorIs(t, err, ErrorInvalidHealthCheckCIDR)
+	_, err = ParseHealthCheckAccess(true, []string{"internal"})
+	assert.ErrorIs(t, err, ErrorInvalidHealthCheckCIDR)
+}
+
+func TestHealthCheckAccess_IsHealthCheckPath(t *testing.T) {
+	for _, requestPath := range []string{"/up", "/up/", "//up", "/./up", "/up.json", "/up/db", "/other/../up"} {
+		assert.True(t, isHealthCheckPath(requestPath, "/up"), requestPath)
+	}
+	for _, requestPath := range []string{"/", "/upload", "/other", "/other/up"} {
+		assert.False(t, isHealthCheckPath(requestPath, "/up"), requestPath)
+	}
+
+	assert.True(t, isHealthCheckPath("/health/", "/health/"))
+	assert.True(t, isHealthCheckPath("/health", "/health/"))
+	assert.True(t, isHealthCheckPath("/", "/"))
+	assert.False(t, isHealthCheckPath("/other", "/"))
+}
+
+func TestRouter_BlockHealthCheckPath(t *testing.T) {
+	router := testRouter(t)
+	_, target := testBackend(t, "first", http.StatusOK)
+
+	targetOptions := defaultTargetOptions
+	targetOptions.HealthCheckAllowedCIDRs = []string{"10.0.0.0/8"}
+	require.NoError(t, router.SetServiceTarget("service1", []string{"example.com"}, target, defaultServiceOptions, targetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	sendFrom := func(remoteAddr, path string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		req.RemoteAddr = remoteAddr
+		statusCode, _ := sendRequest(router, req)
+		return statusCode
+	}
+
+	assert.Equal(t, http.StatusNotFound, sendFrom("203.0.113.1:1234", "/up"))
+	assert.Equal(t, http.StatusNotFound, sendFrom("203.0.113.1:1234", "/up/"))
+	assert.Equal(t, http.StatusNotFound, sendFrom("203.0.113.1:1234", "/up.json"))
+	assert.Equal(t, http.StatusOK, sendFrom("10.0.0.1:1234", "/up"))
+	assert.Equal(t, http.StatusOK, sendFrom("10.0.0.1:1234", "/up.json"))
+	assert.Equal(t, http.StatusOK, sendFrom("203.0.113.1:1234", "/other"))
+	assert.Equal(t, http.StatusOK, sendFrom("203.0.113.1:1234", "/upload"))
+
+	// Blocked even while paused, when the proxy would otherwise answer itself
+	require.NoError(t, router.PauseService("service1", DefaultDrainTimeout, DefaultPauseTimeout, nil, false))
+	assert.Equal(t, http.StatusNotFound, sendFrom("203.0.113.1:1234", "/up"))
+	assert.Equal(t, http.StatusOK, sendFrom("10.0.0.1:1234", "/up"))
+}
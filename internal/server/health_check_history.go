@@ -0,0 +1,65 @@
+package server
+
+import "sync"
+
+const HealthCheckHistorySize = 100
+
+// HealthCheckHistory keeps the most recent health check results for a target,
+// oldest first.
+type HealthCheckHistory struct {
+	results []HealthCheckResult
+	next    int
+	full    bool
+	count   int
+	lock    sync.Mutex
+}
+
+func NewHealthCheckHistory(size int) *HealthCheckHistory {
+	return &HealthCheckHistory{
+		results: make([]HealthCheckResult, size),
+	}
+}
+
+func (h *HealthCheckHistory) Add(result HealthCheckResult) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.results[h.next] = result
+	h.count++
+	h.next = (h.next + 1) % len(h.results)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *HealthCheckHistory) Results() []HealthCheckResult {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.orderedResults()
+}
+
+// ResultsSince returns the results that were added after the first since
+// results (or as many of them as are still held), along with the number to
+// use as since to continue from where they end.
+func (h *HealthCheckHistory) ResultsSince(since int) ([]HealthCheckResult, int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	results := h.orderedResults()
+	skip := max(0, since-(h.count-len(results)))
+	if skip > len(results) {
+		skip = len(results)
+	}
+
+	return results[skip:], h.count
+}
+
+// Private
+
+func (h *HealthCheckHistory) orderedResults() []HealthCheckResult {
+	if !h.full {
+		return append([]HealthCheckResult{}, h.results[:h.next]...)
+	}
+	return append(append([]HealthCheckResult{}, h.results[h.next:]...), h.results[:h.next]...)
+}
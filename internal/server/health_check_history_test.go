@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckHistory(t *testing.T) {
+	history := NewHealthCheckHistory(3)
+	assert.Empty(t, history.Results())
+
+	history.Add(HealthCheckResult{StatusCode: 1})
+	history.Add(HealthCheckResult{StatusCode: 2})
+	assert.Equal(t, []int{1, 2}, testHealthCheckStatuses(history.Results()))
+
+	history.Add(HealthCheckResult{StatusCode: 3})
+	history.Add(HealthCheckResult{StatusCode: 4})
+	history.Add(HealthCheckResult{StatusCode: 5})
+	assert.Equal(t, []int{3, 4, 5}, testHealthCheckStatuses(history.Results()))
+}
+
+func TestHealthCheckHistory_ResultsSince(t *testing.T) {
+	history := NewHealthCheckHistory(3)
+
+	results, next := history.ResultsSince(0)
+	assert.Empty(t, results)
+	assert.Equal(t, 0, next)
+
+	history.Add(HealthCheckResult{StatusCode: 1})
+	history.Add(HealthCheckResult{StatusCode: 2})
+
+	results, next = history.ResultsSince(next)
+	assert.Equal(t, []int{1, 2}, testHealthCheckStatuses(results))
+	assert.Equal(t, 2, next)
+
+	history.Add(HealthCheckResult{StatusCode: 3})
+	history.Add(HealthCheckResult{StatusCode: 4})
+
+	results, next = history.ResultsSince(next)
+	assert.Equal(t, []int{3, 4}, testHealthCheckStatuses(results))
+	assert.Equal(t, 4, next)
+
+	history.Add(HealthCheckResult{StatusCode: 5})
+	history.Add(HealthCheckResult{StatusCode: 6})
+	history.Add(HealthCheckResult{StatusCode: 7})
+	history.Add(HealthCheckResult{StatusCode: 8})
+
+	results, next = history.ResultsSince(next)
+	assert.Equal(t, []int{6, 7, 8}, testHealthCheckStatuses(results))
+	assert.Equal(t, 8, next)
+
+	results, _ = history.ResultsSince(next)
+	assert.Empty(t, results)
+}
+
+// Helpers
+
+func testHealthCheckStatuses(results []HealthCheckResult) []int {
+	statuses := []int{}
+	for _, result := range results {
+		statuses = append(statuses, result.StatusCode)
+	}
+	return statuses
+}
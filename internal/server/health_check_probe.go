@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	HealthCheckTypeHTTP = "http"
+	HealthCheckTypeTCP  = "tcp"
+	HealthCheckTypeExec = "exec"
+
+	healthCheckCommandWaitDelay = time.Second
+	healthCheckOutputLimit      = 256
+)
+
+var (
+	ErrorHealthCheckCommandFailed   = errors.New("Command failed")
+	ErrorUnknownHealthCheckType     = errors.New("unknown health check type")
+	ErrorHealthCheckCommandRequired = errors.New("health check command is required for exec health checks")
+	ErrorHealthCheckNotADirectory   = errors.New("not a directory")
+)
+
+// HealthCheckProbe performs a single check of whether a target is healthy,
+// returning an error if it's not. HTTP probes also return the status code they
+// received.
+type HealthCheckProbe interface {
+	Probe(ctx context.Context) (int, error)
+}
+
+// NewHealthCheckProbe returns the kind of probe that the config asks for,
+// checking the target at the given URL.
+func NewHealthCheckProbe(config HealthCheckConfig, targetURL *url.URL) HealthCheckProbe {
+	if targetURL.Scheme == "file" {
+		return &directoryHealthCheckProbe{root: targetURL.Path}
+	}
+
+	switch config.Type {
+	case HealthCheckTypeTCP:
+		return &tcpHealthCheckProbe{address: targetURL.Host}
+	case HealthCheckTypeExec:
+		return &execHealthCheckProbe{command: config.Command, address: targetURL.Host}
+	default:
+		return &httpHealthCheckProbe{endpoint: targetURL.JoinPath(config.Path)}
+	}
+}
+
+func (c HealthCheckConfig) Validate() error {
+	switch c.Type {
+	case "", HealthCheckTypeHTTP, HealthCheckTypeTCP:
+		return nil
+	case HealthCheckTypeExec:
+		if c.Command == "" {
+			return ErrorHealthCheckCommandRequired
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrorUnknownHealthCheckType, c.Type)
+	}
+}
+
+type httpHealthCheckProbe struct {
+	endpoint *url.URL
+}
+
+func (p *httpHealthCheckProbe) Probe(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("User-Agent", healthCheckUserAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%w (%d)", ErrorHealthCheckUnexpectedStatus, resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+type tcpHealthCheckProbe struct {
+	address string
+}
+
+func (p *tcpHealthCheckProbe) Probe(ctx context.Context) (int, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, conn.Close()
+}
+
+// execHealthCheckProbe runs a shell command, which succeeds if it exits with
+// a zero status. The address of the target being checked is available to the
+// command in KAMAL_PROXY_TARGET.
+type execHealthCheckProbe struct {
+	command string
+	address string
+}
+
+func (p *execHealthCheckProbe) Probe(ctx context.Context) (int, error) {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", p.command)
+	cmd.Env = append(os.Environ(), "KAMAL_PROXY_TARGET="+p.address)
+	cmd.WaitDelay = healthCheckCommandWaitDelay
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	if err != nil {
+		detail := strings.TrimSpace(string(output))
+		if len(detail) > healthCheckOutputLimit {
+			detail = detail[:healthCheckOutputLimit]
+		}
+		if detail == "" {
+			detail = err.Error()
+		}
+		return 0, fmt.Errorf("%w: %s", ErrorHealthCheckCommandFailed, detail)
+	}
+
+	return 0, nil
+}
+
+// directoryHealthCheckProbe checks that the directory served by a directory
+// target is present.
+type directoryHealthCheckProbe struct {
+	root string
+}
+
+func (p *directoryHealthCheckProbe) Probe(ctx context.Context) (int, error) {
+	info, err := os.Stat(p.root)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return 0, fmt.Errorf("%w: %s", ErrorHealthCheckNotADirectory, p.root)
+	}
+	return 0, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheckProbe_HTTP(t *testing.T) {
+	_, healthy := testBackend(t, "ok", http.StatusOK)
+	_, unhealthy := testBackend(t, "not ok", http.StatusServiceUnavailable)
+
+	statusCode, err := testProbe(t, HealthCheckConfig{Path: "/up"}, healthy)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	statusCode, err = testProbe(t, HealthCheckConfig{Type: HealthCheckTypeHTTP, Path: "/up"}, unhealthy)
+	assert.ErrorIs(t, err, ErrorHealthCheckUnexpectedStatus)
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+}
+
+func TestHealthCheckProbe_TCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+
+	_, err = testProbe(t, HealthCheckConfig{Type: HealthCheckTypeTCP}, address)
+	require.NoError(t, err)
+
+	l.Close()
+
+	_, err = testProbe(t, HealthCheckConfig{Type: HealthCheckTypeTCP}, address)
+	require.Error(t, err)
+}
+
+func TestHealthCheckProbe_Exec(t *testing.T) {
+	_, err := testProbe(t, HealthCheckConfig{Type: HealthCheckTypeExec, Command: `test "$KAMAL_PROXY_TARGET" = "app:3000"`}, "app:3000")
+	require.NoError(t, err)
+
+	_, err = testProbe(t, HealthCheckConfig{Type: HealthCheckTypeExec, Command: "echo database unavailable; exit 1"}, "app:3000")
+	assert.ErrorIs(t, err, ErrorHealthCheckCommandFailed)
+	assert.ErrorContains(t, err, "database unavailable")
+
+	probe := NewHealthCheckProbe(HealthCheckConfig{Type: HealthCheckTypeExec, Command: "sleep 10"}, &url.URL{Scheme: "http", Host: "app:3000"})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	_, err = probe.Probe(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestHealthCheckConfig_Validate(t *testing.T) {
+	assert.NoError(t, HealthCheckConfig{}.Validate())
+	assert.NoError(t, HealthCheckConfig{Type: HealthCheckTypeTCP}.Validate())
+	assert.NoError(t, HealthCheckConfig{Type: HealthCheckTypeExec, Command: "true"}.Validate())
+
+	assert.ErrorIs(t, HealthCheckConfig{Type: HealthCheckTypeExec}.Validate(), ErrorHealthCheckCommandRequired)
+	assert.ErrorIs(t, HealthCheckConfig{Type: "grpc"}.Validate(), ErrorUnknownHealthCheckType)
+}
+
+func TestTarget_BecomesHealthyWithTCPHealthCheck(t *testing.T) {
+	_, backend := testBackend(t, "ok", http.StatusNotFound)
+
+	options := defaultTargetOptions
+	options.HealthCheckConfig.Type = HealthCheckTypeTCP
+
+	target, err := NewTarget(backend, options)
+	require.NoError(t, err)
+	assert.True(t, target.WaitUntilHealthy(time.Second))
+}
+
+// Helpers
+
+func testProbe(t *testing.T, config HealthCheckConfig, address string) (int, error) {
+	t.Helper()
+
+	probe := NewHealthCheckProbe(config, &url.URL{Scheme: "http", Host: address})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return probe.Probe(ctx)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HostMaintenanceArgs describe how to take every service out of service at
+// once, such as before rebooting the host. Services that match one of the
+// Except patterns (such as review-*) are left alone.
+type HostMaintenanceArgs struct {
+	DrainTimeout time.Duration
+	PauseTimeout time.Duration
+	Stop         bool
+	Message      string
+	Except       []string
+}
+
+// ResumeAllArgs describe how to bring the services back. See
+// Service.Resume for ReleaseConcurrency.
+type ResumeAllArgs struct {
+	ReleaseConcurrency int
+}
+
+type HostMaintenanceResponse struct {
+	Services []string `json:"services"`
+}
+
+// PauseAll pauses, or stops, every running service for host maintenance, and
+// returns the names of those it changed. Services that were already paused
+// or stopped are left as they are, so that ResumeAll puts everything back
+// the way it was. The services are drained together, rather than in turn.
+func (r *Router) PauseAll(args HostMaintenanceArgs) ([]string, error) {
+	defer r.saveStateSnapshot()
+
+	for _, pattern := range args.Except {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	services := []*Service{}
+	for _, service := range r.allServices() {
+		if service.pauseController.GetState() == PauseStateRunning && !matchesAny(args.Except, service.name) {
+			services = append(services, service)
+		}
+	}
+
+	slog.Info("Pausing all services for maintenance", "stop", args.Stop, "count", len(services), "except", args.Except)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(services))
+	for i, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var err error
+			if args.Stop {
+				err = service.Stop(args.DrainTimeout, args.Message)
+			} else {
+				err = service.Pause(args.DrainTimeout, args.PauseTimeout, nil, false)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", service.name, err)
+				return
+			}
+			service.pauseController.MarkMaintenance()
+		}()
+	}
+	wg.Wait()
+
+	names := []string{}
+	for i, service := range services {
+		if errs[i] == nil {
+			names = append(names, service.name)
+		}
+	}
+	slices.Sort(names)
+
+	return names, errors.Join(errs...)
+}
+
+// ResumeAll resumes the services that were paused or stopped by PauseAll,
+// and returns their names.
+func (r *Router) ResumeAll(releaseConcurrency int) ([]string, error) {
+	defer r.saveStateSnapshot()
+
+	names := []string{}
+	var errs []error
+	for _, service := range r.allServices() {
+		if !service.pauseController.InMaintenance() {
+			continue
+		}
+
+		err := service.Resume(releaseConcurrency)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", service.name, err))
+			continue
+		}
+		names = append(names, service.name)
+	}
+	slices.Sort(names)
+
+	slog.Info("Resumed all services after maintenance", "services", names)
+	return names, errors.Join(errs...)
+}
+
+// Private
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_PauseAllAndResumeAll(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	router := NewRouter(statePath)
+
+	for _, name := range []string{"app", "api", "review-1", "paused"} {
+		_, target := testBackend(t, name, 200)
+		require.NoError(t, router.SetServiceTarget(name, []string{name + ".example.com"}, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	}
+	require.NoError(t, router.PauseService("paused", DefaultDrainTimeout, DefaultPauseTimeout, nil, false))
+
+	stopped, err := router.PauseAll(HostMaintenanceArgs{DrainTimeout: DefaultDrainTimeout, Stop: true, Message: "Back soon", Except: []string{"review-*"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "app"}, stopped)
+
+	services := router.ListActiveServices()
+	assert.Equal(t, "stopped", services["app"].State)
+	assert.True(t, services["app"].Pause.Maintenance)
+	assert.Equal(t, "running", services["review-1"].State)
+	assert.False(t, services["paused"].Pause.Maintenance)
+
+	// Maintenance survives a restart
+	router = NewRouter(statePath)
+	require.NoError(t, router.RestoreLastSavedState())
+
+	resumed, err := router.ResumeAll(0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "app"}, resumed)
+
+	services = router.ListActiveServices()
+	assert.Equal(t, "running", services["app"].State)
+	assert.Equal(t, "running", services["api"].State)
+	assert.Equal(t, "paused", services["paused"].State)
+
+	_, err = router.PauseAll(HostMaintenanceArgs{Except: []string{"["}})
+	assert.Error(t, err)
+}
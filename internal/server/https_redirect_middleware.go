@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const acmeChallengePathPrefix = "/.well-known/acme-challenge/"
+
+// HTTPSRedirectMiddleware redirects every plain HTTP request to HTTPS, except
+// for ACME challenges, which are passed on so that certificates can still be
+// obtained using HTTP-01.
+type HTTPSRedirectMiddleware struct {
+	next http.Handler
+}
+
+func WithHTTPSRedirectMiddleware(next http.Handler) http.Handler {
+	return &HTTPSRedirectMiddleware{
+		next: next,
+	}
+}
+
+func (h *HTTPSRedirectMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS != nil || isACMEChallengeRequest(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Connection", "close")
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+func isACMEChallengeRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, acmeChallengePathPrefix)
+}
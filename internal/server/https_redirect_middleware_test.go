@@ -0,0 +1,34 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSRedirectMiddleware(t *testing.T) {
+	handler := WithHTTPSRedirectMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	serve := func(r *http.Request) *http.Response {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	resp := serve(httptest.NewRequest(http.MethodGet, "http://example.com:8080/path?q=1", nil))
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "https://example.com/path?q=1", resp.Header.Get("Location"))
+
+	resp = serve(httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token", nil))
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/path", nil)
+	req.TLS = &tls.ConnectionState{}
+	resp = serve(req)
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	idempotencyKeySeparator  = "\x00"
+)
+
+// IdempotencyMiddleware de-duplicates concurrent requests that carry the same
+// Idempotency-Key. Only the first is sent to the target; any duplicates that
+// arrive while it's in progress wait for it to finish, and are sent a copy of
+// its response. This protects endpoints like payments from being run twice
+// when a client retries a request that is slow to respond.
+//
+// Responses are shared only if they fit in maxMemBytes. Duplicates of
+// requests with larger responses, or that upgraded the connection, are
+// rejected with 409 Conflict instead.
+type IdempotencyMiddleware struct {
+	maxMemBytes int64
+	next        http.Handler
+
+	inflight     map[string]*idempotentRequest
+	inflightLock sync.Mutex
+}
+
+type idempotentRequest struct {
+	done       chan struct{}
+	shareable  bool
+	statusCode int
+	header     http.Header
+	body       *Buffer
+}
+
+func WithIdempotencyMiddleware(maxMemBytes int64, next http.Handler) http.Handler {
+	return &IdempotencyMiddleware{
+		maxMemBytes: maxMemBytes,
+		next:        next,
+		inflight:    map[string]*idempotentRequest{},
+	}
+}
+
+func (h *IdempotencyMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+	if idempotencyKey == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	key := r.Method + idempotencyKeySeparator + r.Host + idempotencyKeySeparator + r.URL.Path + idempotencyKeySeparator + idempotencyKey
+	original, duplicate := h.claim(key)
+
+	if duplicate {
+		h.waitAndReplay(w, r, original)
+		return
+	}
+
+	h.serveOriginal(w, r, key, original)
+}
+
+// Private
+
+func (h *IdempotencyMiddleware) claim(key string) (*idempotentRequest, bool) {
+	h.inflightLock.Lock()
+	defer h.inflightLock.Unlock()
+
+	if original, ok := h.inflight[key]; ok {
+		return original, true
+	}
+
+	original := &idempotentRequest{done: make(chan struct{})}
+	h.inflight[key] = original
+	return original, false
+}
+
+func (h *IdempotencyMiddleware) serveOriginal(w http.ResponseWriter, r *http.Request, key string, original *idempotentRequest) {
+	body := NewBufferedWriteCloser(h.maxMemBytes, h.maxMemBytes)
+	recorder := &idempotencyResponseWriter{ResponseWriter: w, request: original, body: body}
+
+	defer func() {
+		h.inflightLock.Lock()
+		delete(h.inflight, key)
+		h.inflightLock.Unlock()
+
+		// A panic means the response was abandoned part way through.
+		abandoned := recover()
+
+		original.body = body
+		original.shareable = abandoned == nil && !recorder.hijacked && !body.Overflowed() && body.Replayable()
+		if original.header == nil {
+			original.header = w.Header().Clone()
+			original.statusCode = http.StatusOK
+		}
+		close(original.done)
+
+		if abandoned != nil {
+			panic(abandoned)
+		}
+	}()
+
+	// Keep the original running if its client goes away, since a retry may be
+	// waiting to share its response. Cancellations for other reasons, like the
+	// target draining or timing out, still apply.
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(r.Context()))
+	defer cancel(nil)
+	stop := context.AfterFunc(r.Context(), func() {
+		if cause := context.Cause(r.Context()); !errors.Is(cause, context.Canceled) {
+			cancel(cause)
+		}
+	})
+	defer stop()
+
+	h.next.ServeHTTP(recorder, r.WithContext(ctx))
+}
+
+func (h *IdempotencyMiddleware) waitAndReplay(w http.ResponseWriter, r *http.Request, original *idempotentRequest) {
+	select {
+	case <-original.done:
+	case <-r.Context().Done():
+		return
+	}
+
+	if !original.shareable {
+		SetErrorResponse(w, r, http.StatusConflict, nil)
+		return
+	}
+
+	body, err := original.body.NewReader()
+	if err != nil {
+		SetErrorResponse(w, r, http.StatusConflict, nil)
+		return
+	}
+	defer body.Close()
+
+	slog.Debug("Sharing response with duplicate request", "path", r.URL.Path, "idempotency_key", r.Header.Get(IdempotencyKeyHeader))
+
+	maps.Copy(w.Header(), original.header)
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(original.statusCode)
+	io.Copy(w, body)
+}
+
+// idempotencyResponseWriter passes the original response through to its
+// client, while keeping a copy that can be shared with any duplicates.
+type idempotencyResponseWriter struct {
+	http.ResponseWriter
+	request   *idempotentRequest
+	body      *Buffer
+	hijacked  bool
+	clientErr error
+}
+
+func (w *idempotencyResponseWriter) WriteHeader(statusCode int) {
+	if !isInformationalStatus(statusCode) && w.request.header == nil {
+		w.request.statusCode = statusCode
+		w.request.header = w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *idempotencyResponseWriter) Write(data []byte) (int, error) {
+	if w.request.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// Once the copy is too large to share, there's no need to keep adding to
+	// it; the buffer remembers that it overflowed.
+	if !w.body.Overflowed() {
+		w.body.Write(data)
+	}
+
+	// If the client has gone, keep reading the response for as long as it
+	// could still be shared.
+	if w.clientErr == nil {
+		n, err := w.ResponseWriter.Write(data)
+		if err == nil {
+			return n, nil
+		}
+		w.clientErr = err
+	}
+
+	if w.body.Overflowed() {
+		return 0, w.clientErr
+	}
+	return len(data), nil
+}
+
+func (w *idempotencyResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	w.hijacked = true
+	return hijacker.Hijack()
+}
+
+func (w *idempotencyResponseWriter) Flush() {
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
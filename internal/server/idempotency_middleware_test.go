@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddleware_DuplicatesShareTheResponse(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := WithIdempotencyMiddleware(DefaultMaxMemoryBufferSize, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"payment":1}`))
+	}))
+
+	responses := testSendConcurrentIdempotentRequests(handler, release, "key-1", "key-1", "key-1")
+
+	assert.Equal(t, int32(1), calls.Load())
+	replayed := 0
+	for _, w := range responses {
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, `{"payment":1}`, w.Body.String())
+		if w.Header().Get(IdempotentReplayedHeader) == "true" {
+			replayed++
+		}
+	}
+	assert.Equal(t, 2, replayed)
+}
+
+func TestIdempotencyMiddleware_DifferentKeysAreNotShared(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := WithIdempotencyMiddleware(DefaultMaxMemoryBufferSize, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+
+	testSendConcurrentIdempotentRequests(handler, release, "key-1", "key-2", "")
+
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestIdempotencyMiddleware_LargeResponsesAreNotShared(t *testing.T) {
+	release := make(chan struct{})
+	handler := WithIdempotencyMiddleware(10, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(strings.Repeat("a", 20)))
+	}))
+
+	responses := testSendConcurrentIdempotentRequests(handler, release, "key-1", "key-1")
+
+	statuses := []int{responses[0].Code, responses[1].Code}
+	assert.ElementsMatch(t, []int{http.StatusOK, http.StatusConflict}, statuses)
+}
+
+func TestIdempotencyMiddleware_OriginalContinuesWhenItsClientGoesAway(t *testing.T) {
+	release := make(chan struct{})
+	var originalErr error
+	handler := WithIdempotencyMiddleware(DefaultMaxMemoryBufferSize, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		originalErr = r.Context().Err()
+		_, err := w.Write([]byte("done"))
+		assert.NoError(t, err)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	original := httptest.NewRequest("POST", "/payments", nil).WithContext(ctx)
+	original.Header.Set(IdempotencyKeyHeader, "key-1")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handler.ServeHTTP(&failingResponseWriter{ResponseRecorder: httptest.NewRecorder()}, original)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	duplicate := httptest.NewRequest("POST", "/payments", nil)
+	duplicate.Header.Set(IdempotencyKeyHeader, "key-1")
+	w := httptest.NewRecorder()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handler.ServeHTTP(w, duplicate)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	close(release)
+	wg.Wait()
+
+	assert.NoError(t, originalErr)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "done", w.Body.String())
+}
+
+// Helpers
+
+// testSendConcurrentIdempotentRequests sends a request for each key, waiting
+// until they are all in progress before releasing the handler.
+func testSendConcurrentIdempotentRequests(handler http.Handler, release chan struct{}, keys ...string) []*httptest.ResponseRecorder {
+	responses := []*httptest.ResponseRecorder{}
+	var wg sync.WaitGroup
+
+	for _, key := range keys {
+		w := httptest.NewRecorder()
+		responses = append(responses, w)
+
+		r := httptest.NewRequest("POST", "/payments", nil)
+		if key != "" {
+			r.Header.Set(IdempotencyKeyHeader, key)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(w, r)
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+
+	return responses
+}
+
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *failingResponseWriter) Write(data []byte) (int, error) {
+	return 0, errors.New("client went away")
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+// InformationalResponseMiddleware discards any 1xx informational responses,
+// such as 103 Early Hints, that a target sends ahead of its final response.
+type InformationalResponseMiddleware struct {
+	next http.Handler
+}
+
+func WithInformationalResponseMiddleware(next http.Handler) http.Handler {
+	return &InformationalResponseMiddleware{
+		next: next,
+	}
+}
+
+func (h *InformationalResponseMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.next.ServeHTTP(&informationalResponseWriter{w}, r)
+}
+
+type informationalResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *informationalResponseWriter) WriteHeader(statusCode int) {
+	if isInformationalStatus(statusCode) {
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *informationalResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	return readFrom(w.ResponseWriter, src)
+}
+
+func (w *informationalResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ResponseWriter does not implement http.Hijacker")
+	}
+	return hijacker.Hijack()
+}
+
+func (w *informationalResponseWriter) Flush() {
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+// isInformationalStatus reports whether a status is sent ahead of the final
+// response. 101 Switching Protocols is excluded, as it is itself final.
+func isInformationalStatus(statusCode int) bool {
+	return statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInformationalResponseMiddleware_BlocksEarlyHints(t *testing.T) {
+	handler := WithInformationalResponseMiddleware(http.HandlerFunc(testEarlyHintsHandler))
+
+	statuses, resp := testRequestCollectingInformationalResponses(t, handler)
+
+	assert.Empty(t, statuses)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestInformationalResponseMiddleware_ForwardsEarlyHintsThroughBufferedTarget(t *testing.T) {
+	target := testTarget(t, testEarlyHintsHandler)
+	target.options.BufferResponses = true
+	target.proxyHandler = WithResponseBufferMiddleware(DefaultMaxMemoryBufferSize, DefaultMaxResponseBodySize, target.createProxyHandler())
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testServeRequestWithTarget(t, target, w, r)
+	})
+
+	statuses, resp := testRequestCollectingInformationalResponses(t, handler)
+
+	assert.Equal(t, []int{http.StatusEarlyHints}, statuses)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Link"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+}
+
+// Helpers
+
+func testEarlyHintsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+	w.WriteHeader(http.StatusEarlyHints)
+	w.Header().Del("Link")
+
+	w.Write([]byte("ok"))
+}
+
+func testRequestCollectingInformationalResponses(t *testing.T, handler http.Handler) ([]int, *http.Response) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	statuses := []int{}
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			statuses = append(statuses, code)
+			assert.Equal(t, "</style.css>; rel=preload; as=style", header.Get("Link"))
+			return nil
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return statuses, resp
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const (
+	IPFamilyAny        = "any"
+	IPFamilyIPv4       = "ipv4"
+	IPFamilyIPv6       = "ipv6"
+	IPFamilyPreferIPv4 = "prefer-ipv4"
+	IPFamilyPreferIPv6 = "prefer-ipv6"
+
+	DefaultHappyEyeballsDelay = 300 * time.Millisecond
+)
+
+var ErrorUnknownIPFamily = errors.New("unknown IP family")
+
+// ValidateListenIPFamily checks the IP family that listeners are bound with:
+// any (both IPv4 and IPv6, where the address allows), ipv4 or ipv6 only.
+func ValidateListenIPFamily(family string) error {
+	switch family {
+	case "", IPFamilyAny, IPFamilyIPv4, IPFamilyIPv6:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrorUnknownIPFamily, family)
+	}
+}
+
+// ValidateTargetIPFamily checks the IP family that targets are connected to
+// with. As well as those for listeners, a family can be preferred, while
+// still falling back to the other.
+func ValidateTargetIPFamily(family string) error {
+	switch family {
+	case "", IPFamilyAny, IPFamilyIPv4, IPFamilyIPv6, IPFamilyPreferIPv4, IPFamilyPreferIPv6:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrorUnknownIPFamily, family)
+	}
+}
+
+// listenIPFamily listens on a TCP address with only the given IP family. An
+// IPv6 listener is made v6-only, so that it doesn't also accept IPv4
+// connections as IPv4-mapped addresses.
+func listenIPFamily(family, addr string) (net.Listener, error) {
+	return listenWithSocketOptions(family, addr, nil)
+}
+
+// listenWithSocketOptions listens as listenIPFamily does, setting any other
+// socket options before the socket is bound.
+func listenWithSocketOptions(family, addr string, setOptions func(fd int) error) (net.Listener, error) {
+	network := "tcp"
+	switch family {
+	case IPFamilyIPv4:
+		network = "tcp4"
+	case IPFamilyIPv6:
+		network = "tcp6"
+	}
+
+	config := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var err error
+		controlErr := c.Control(func(fd uintptr) {
+			if family == IPFamilyIPv6 {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 1)
+			}
+			if err == nil && setOptions != nil {
+				err = setOptions(int(fd))
+			}
+		})
+		return cmp.Or(controlErr, err)
+	}}
+	return config.Listen(context.Background(), network, addr)
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// newTargetDialer returns a dial function that connects to targets with the
+// given IP family. When one family is preferred, the other is tried after
+// fallbackDelay, or as soon as the preferred one fails, and whichever
+// connects first is used (Happy Eyeballs, RFC 8305). A negative
+// fallbackDelay only tries the other family once the preferred one fails.
+func newTargetDialer(family string, fallbackDelay, timeout time.Duration) dialFunc {
+	if fallbackDelay == 0 {
+		fallbackDelay = DefaultHappyEyeballsDelay
+	}
+	dialer := &net.Dialer{Timeout: timeout, FallbackDelay: fallbackDelay}
+
+	switch family {
+	case IPFamilyIPv4:
+		return restrictDialNetwork(dialer, "tcp4")
+	case IPFamilyIPv6:
+		return restrictDialNetwork(dialer, "tcp6")
+	case IPFamilyPreferIPv4:
+		return happyEyeballsDialer(dialer, "tcp4", "tcp6", fallbackDelay)
+	case IPFamilyPreferIPv6:
+		return happyEyeballsDialer(dialer, "tcp6", "tcp4", fallbackDelay)
+	default:
+		return dialer.DialContext
+	}
+}
+
+// normalizeClientAddr returns an IP address in its usual form, with any
+// IPv4-mapped IPv6 address given as plain IPv4, so that clients are logged
+// the same way whichever family they connected over.
+func normalizeClientAddr(addr string) string {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return addr
+	}
+	return ip.Unmap().String()
+}
+
+// Private
+
+func restrictDialNetwork(dialer *net.Dialer, restricted string) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			network = restricted
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+func happyEyeballsDialer(dialer *net.Dialer, primary, fallback string, fallbackDelay time.Duration) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network != "tcp" {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return dialHappyEyeballs(ctx, dialer, primary, fallback, addr, fallbackDelay)
+	}
+}
+
+func dialHappyEyeballs(ctx context.Context, dialer *net.Dialer, primary, fallback, addr string, fallbackDelay time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, 2)
+	dial := func(network string) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		results <- dialResult{conn, err}
+	}
+
+	go dial(primary)
+	pending := 1
+	fallbackStarted := false
+	startFallback := func() {
+		if !fallbackStarted {
+			fallbackStarted = true
+			pending++
+			go dial(fallback)
+		}
+	}
+
+	var fallbackTimer <-chan time.Time
+	if fallbackDelay > 0 {
+		timer := time.NewTimer(fallbackDelay)
+		defer timer.Stop()
+		fallbackTimer = timer.C
+	}
+
+	var errs []error
+	for {
+		select {
+		case <-fallbackTimer:
+			startFallback()
+
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				if pending > 0 {
+					go closeLosingConn(results)
+				}
+				return result.conn, nil
+			}
+
+			errs = append(errs, result.err)
+			startFallback()
+			if pending == 0 {
+				return nil, errors.Join(errs...)
+			}
+		}
+	}
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// closeLosingConn closes the connection of a dial that lost the race, if it
+// manages to connect before it's cancelled.
+func closeLosingConn(results <-chan dialResult) {
+	result := <-results
+	if result.conn != nil {
+		result.conn.Close()
+	}
+}
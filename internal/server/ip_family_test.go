@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPFamily_IPv6ListenerIsV6Only(t *testing.T) {
+	l, err := listenIPFamily(IPFamilyIPv6, "[::]:0")
+	if err != nil {
+		t.Skip("IPv6 is not available:", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("::1", port), time.Second)
+	require.NoError(t, err)
+	conn.Close()
+
+	_, err = net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), time.Second)
+	assert.Error(t, err)
+}
+
+func TestIPFamily_IPv4Listener(t *testing.T) {
+	l, err := listenIPFamily(IPFamilyIPv4, ":0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	assert.NotNil(t, l.Addr().(*net.TCPAddr).IP.To4())
+}
+
+func TestIPFamily_TargetDialerRestrictsFamily(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	ctx := context.Background()
+
+	conn, err := newTargetDialer(IPFamilyIPv4, 0, time.Second)(ctx, "tcp", l.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+
+	_, err = newTargetDialer(IPFamilyIPv6, 0, time.Second)(ctx, "tcp", l.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestIPFamily_TargetDialerFallsBackToOtherFamily(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	for _, delay := range []time.Duration{time.Hour, -1} {
+		conn, err := newTargetDialer(IPFamilyPreferIPv6, delay, time.Second)(context.Background(), "tcp", l.Addr().String())
+		require.NoError(t, err)
+		assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+		conn.Close()
+	}
+}
+
+func TestIPFamily_TargetDialerReportsBothFailures(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	l.Close()
+
+	_, err = newTargetDialer(IPFamilyPreferIPv4, 0, time.Second)(context.Background(), "tcp", address)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection refused")
+	assert.Contains(t, err.Error(), "no suitable address")
+}
+
+func TestIPFamily_Validation(t *testing.T) {
+	_, err := NewTarget("localhost:3000", TargetOptions{IPFamily: "ipv5"})
+	assert.ErrorIs(t, err, ErrorUnknownIPFamily)
+
+	assert.NoError(t, ValidateListenIPFamily(IPFamilyIPv6))
+	assert.ErrorIs(t, ValidateListenIPFamily(IPFamilyPreferIPv6), ErrorUnknownIPFamily)
+}
+
+func TestIPFamily_NormalizeClientAddr(t *testing.T) {
+	assert.Equal(t, "192.0.2.1", normalizeClientAddr("::ffff:192.0.2.1"))
+	assert.Equal(t, "2001:db8::1", normalizeClientAddr("2001:0db8:0:0::1"))
+	assert.Equal(t, "192.0.2.1", normalizeClientAddr("192.0.2.1"))
+	assert.Equal(t, "@", normalizeClientAddr("@"))
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PriorityCritical = "critical"
+	PriorityNormal   = "normal"
+	PriorityLow      = "low"
+
+	DefaultShedRetryAfter = 5 * time.Second
+)
+
+var ErrorInvalidPriorityRule = errors.New("invalid priority rule")
+
+// PriorityRule sets the priority of any request that matches it, for deciding
+// which requests to shed when a target is overloaded. Rules are written as
+// `<priority>:<matcher>`, where the priority is critical, normal or low, and
+// the matcher is a RequestMatcher. Requests that match no rule are normal.
+type PriorityRule struct {
+	Priority string
+	RequestMatcher
+}
+
+type PriorityRules []PriorityRule
+
+func ParsePriorityRules(rules []string) (PriorityRules, error) {
+	result := PriorityRules{}
+	for _, rule := range rules {
+		parsed, err := ParsePriorityRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, parsed)
+	}
+	return result, nil
+}
+
+func ParsePriorityRule(rule string) (PriorityRule, error) {
+	priority, matcher, ok := strings.Cut(rule, ":")
+	if !ok || !slices.Contains([]string{PriorityCritical, PriorityNormal, PriorityLow}, priority) {
+		return PriorityRule{}, fmt.Errorf("%w: %s", ErrorInvalidPriorityRule, rule)
+	}
+
+	requestMatcher, ok := ParseRequestMatcher(matcher)
+	if !ok {
+		return PriorityRule{}, fmt.Errorf("%w: %s", ErrorInvalidPriorityRule, rule)
+	}
+
+	return PriorityRule{Priority: priority, RequestMatcher: requestMatcher}, nil
+}
+
+// Priority returns the priority of a request, from the first rule it matches.
+func (rules PriorityRules) Priority(req *http.Request) string {
+	for _, rule := range rules {
+		if rule.Matches(req) {
+			return rule.Priority
+		}
+	}
+	return PriorityNormal
+}
+
+type LoadSheddingConfig struct {
+	Rules      PriorityRules
+	LowAt      int
+	NormalAt   int
+	RetryAfter time.Duration
+}
+
+// LoadSheddingMiddleware protects an overloaded target by rejecting its
+// lowest priority requests, with a 503 and a Retry-After header. Low priority
+// requests are shed once LowAt other requests are already in flight, and
+// normal priority requests too once NormalAt are. Critical requests are never
+// shed.
+type LoadSheddingMiddleware struct {
+	config   LoadSheddingConfig
+	inflight func() int
+	next     http.Handler
+}
+
+func WithLoadSheddingMiddleware(config LoadSheddingConfig, inflight func() int, next http.Handler) http.Handler {
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = DefaultShedRetryAfter
+	}
+
+	return &LoadSheddingMiddleware{
+		config:   config,
+		inflight: inflight,
+		next:     next,
+	}
+}
+
+func (h *LoadSheddingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	priority := h.config.Rules.Priority(r)
+	if priority != PriorityCritical {
+		inflight := h.inflight()
+		if h.shouldShed(priority, inflight) {
+			service := LoggingRequestContext(r).Service
+			slog.Debug("Shedding request", "service", service, "path", r.URL.Path, "priority", priority, "inflight", inflight)
+			shedRequestsCounter.WithLabelValues(service, priority).Inc()
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.config.RetryAfter.Seconds()))))
+			SetErrorResponse(w, r, http.StatusServiceUnavailable, nil)
+			return
+		}
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (h *LoadSheddingMiddleware) shouldShed(priority string, inflight int) bool {
+	if h.config.NormalAt > 0 && inflight >= h.config.NormalAt {
+		return true
+	}
+	return priority == PriorityLow && h.config.LowAt > 0 && inflight >= h.config.LowAt
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityRules(t *testing.T) {
+	rules, err := ParsePriorityRules([]string{"critical:path=/checkout/*", "low:path=/reports/*", "low:header=X-Batch"})
+	require.NoError(t, err)
+
+	priority := func(path string, header ...string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		if len(header) > 0 {
+			req.Header.Set(header[0], header[1])
+		}
+		return rules.Priority(req)
+	}
+
+	assert.Equal(t, PriorityCritical, priority("/checkout/pay"))
+	assert.Equal(t, PriorityLow, priority("/reports/daily"))
+	assert.Equal(t, PriorityLow, priority("/", "X-Batch", "1"))
+	assert.Equal(t, PriorityNormal, priority("/"))
+}
+
+func TestPriorityRules_Invalid(t *testing.T) {
+	for _, rule := range []string{"", "low", "urgent:path=/", "low:cookie=x", "low:path="} {
+		_, err := ParsePriorityRule(rule)
+		assert.ErrorIs(t, err, ErrorInvalidPriorityRule, rule)
+	}
+}
+
+func TestLoadSheddingMiddleware(t *testing.T) {
+	rules, err := ParsePriorityRules([]string{"critical:path=/checkout", "low:path=/reports"})
+	require.NoError(t, err)
+
+	inflight := 0
+	handler := WithLoadSheddingMiddleware(LoadSheddingConfig{Rules: rules, LowAt: 5, NormalAt: 10, RetryAfter: 1500 * time.Millisecond}, func() int { return inflight }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	statuses := func() []int {
+		result := []int{}
+		for _, path := range []string{"/checkout", "/", "/reports"} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			result = append(result, w.Result().StatusCode)
+		}
+		return result
+	}
+
+	inflight = 4
+	assert.Equal(t, []int{200, 200, 200}, statuses())
+
+	inflight = 5
+	assert.Equal(t, []int{200, 200, 503}, statuses())
+
+	inflight = 10
+	assert.Equal(t, []int{200, 503, 503}, statuses())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
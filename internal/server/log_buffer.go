@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const DefaultAccessLogFlushInterval = time.Second
+
+// BufferedLogWriter collects log lines in memory and writes them to its
+// destination in batches, either periodically or once half of the buffer is
+// full, which saves a write for every line when there are many of them.
+//
+// Writes never wait for the destination. While a batch is being written, new
+// lines are collected in a second buffer, and any that don't fit are dropped
+// and counted, rather than holding up the requests being logged.
+type BufferedLogWriter struct {
+	dest          io.Writer
+	size          int
+	flushInterval time.Duration
+
+	lock    sync.Mutex
+	buffer  *bytes.Buffer
+	spare   *bytes.Buffer
+	pending chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func NewBufferedLogWriter(dest io.Writer, size int, flushInterval time.Duration) *BufferedLogWriter {
+	w := &BufferedLogWriter{
+		dest:          dest,
+		size:          size,
+		flushInterval: flushInterval,
+
+		buffer:  bytes.NewBuffer(make([]byte, 0, size)),
+		spare:   bytes.NewBuffer(make([]byte, 0, size)),
+		pending: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go w.run()
+	return w
+}
+
+func (w *BufferedLogWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.buffer.Len()+len(p) > w.size {
+		droppedLogLinesCounter.WithLabelValues().Inc()
+		return len(p), nil
+	}
+
+	w.buffer.Write(p)
+	if w.buffer.Len() >= w.size/2 {
+		select {
+		case w.pending <- struct{}{}:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close writes any lines that are still buffered, and stops flushing.
+func (w *BufferedLogWriter) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+		<-w.stopped
+	})
+	return nil
+}
+
+// Private
+
+func (w *BufferedLogWriter) run() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			w.flush()
+			return
+		case <-ticker.C:
+			w.flush()
+		case <-w.pending:
+			w.flush()
+		}
+	}
+}
+
+func (w *BufferedLogWriter) flush() {
+	w.lock.Lock()
+	batch := w.buffer
+	w.buffer, w.spare = w.spare, batch
+	w.lock.Unlock()
+
+	if batch.Len() == 0 {
+		return
+	}
+
+	_, err := w.dest.Write(batch.Bytes())
+	if err != nil {
+		slog.Error("Unable to write access log", "error", err)
+	}
+	batch.Reset()
+}
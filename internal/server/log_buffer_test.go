@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferedLogWriter_FlushesPeriodically(t *testing.T) {
+	dest := &testLogDestination{}
+	w := NewBufferedLogWriter(dest, 1024, 100*time.Millisecond)
+	t.Cleanup(func() { w.Close() })
+
+	w.Write([]byte("one\n"))
+	w.Write([]byte("two\n"))
+	assert.Empty(t, dest.String())
+
+	require.Eventually(t, func() bool { return dest.String() == "one\ntwo\n" }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, dest.Writes())
+}
+
+func TestBufferedLogWriter_FlushesWhenHalfFull(t *testing.T) {
+	dest := &testLogDestination{}
+	w := NewBufferedLogWriter(dest, 16, time.Hour)
+	t.Cleanup(func() { w.Close() })
+
+	w.Write([]byte("1234567\n"))
+	require.Eventually(t, func() bool { return dest.String() == "1234567\n" }, time.Second, time.Millisecond)
+}
+
+func TestBufferedLogWriter_DropsLinesWhenFull(t *testing.T) {
+	dest := &testLogDestination{}
+	w := NewBufferedLogWriter(dest, 8, time.Hour)
+
+	dropped := testutil.ToFloat64(droppedLogLinesCounter.WithLabelValues())
+	w.Write([]byte("0123456789\n"))
+	assert.Equal(t, dropped+1, testutil.ToFloat64(droppedLogLinesCounter.WithLabelValues()))
+
+	w.Write([]byte("abc\n"))
+	w.Close()
+	assert.Equal(t, "abc\n", dest.String())
+}
+
+func TestBufferedLogWriter_CloseFlushes(t *testing.T) {
+	dest := &testLogDestination{}
+	w := NewBufferedLogWriter(dest, 1024, time.Hour)
+
+	logger := slog.New(slog.NewJSONHandler(w, nil))
+	logger.Info("Request", "path", "/")
+	assert.Empty(t, dest.String())
+
+	w.Close()
+	assert.Contains(t, dest.String(), `"path":"/"`)
+	assert.NoError(t, w.Close())
+}
+
+// Helpers
+
+type testLogDestination struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+	writes int
+}
+
+func (d *testLogDestination) Write(p []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.writes++
+	return d.buffer.Write(p)
+}
+
+func (d *testLogDestination) String() string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.buffer.String()
+}
+
+func (d *testLogDestination) Writes() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.writes
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var ErrorInvalidLogTagRule = errors.New("invalid log tag rule")
+
+// LogTagRule attaches a static tag to the log line of any request that
+// matches it. Rules are written as `<name>=<value>:<matcher>`, where the
+// matcher is a RequestMatcher.
+type LogTagRule struct {
+	Name  string
+	Value string
+	RequestMatcher
+}
+
+type LogTagRules []LogTagRule
+
+// RequestMatcher selects requests, and is written as one of:
+//
+//	path=<pattern>                 the request path matches the pattern
+//	header=<name>                  the request has the header
+//	header=<name>=<pattern>        the request header value matches the pattern
+//
+// Patterns may use `*` to match any sequence of characters.
+type RequestMatcher struct {
+	Path   *regexp.Regexp
+	Header string
+	Match  *regexp.Regexp
+}
+
+func ParseLogTagRules(rules []string) (LogTagRules, error) {
+	result := LogTagRules{}
+	for _, rule := range rules {
+		parsed, err := ParseLogTagRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, parsed)
+	}
+	return result, nil
+}
+
+func ParseLogTagRule(rule string) (LogTagRule, error) {
+	tag, matcher, ok := strings.Cut(rule, ":")
+	if !ok {
+		return LogTagRule{}, fmt.Errorf("%w: %s", ErrorInvalidLogTagRule, rule)
+	}
+
+	name, value, ok := strings.Cut(tag, "=")
+	if !ok || name == "" {
+		return LogTagRule{}, fmt.Errorf("%w: %s", ErrorInvalidLogTagRule, rule)
+	}
+
+	requestMatcher, ok := ParseRequestMatcher(matcher)
+	if !ok {
+		return LogTagRule{}, fmt.Errorf("%w: %s", ErrorInvalidLogTagRule, rule)
+	}
+
+	return LogTagRule{Name: name, Value: value, RequestMatcher: requestMatcher}, nil
+}
+
+func ParseRequestMatcher(matcher string) (RequestMatcher, bool) {
+	result := RequestMatcher{}
+
+	kind, pattern, _ := strings.Cut(matcher, "=")
+	switch kind {
+	case "path":
+		if pattern == "" {
+			return RequestMatcher{}, false
+		}
+		result.Path = globToRegexp(pattern)
+
+	case "header":
+		header, valuePattern, hasValue := strings.Cut(pattern, "=")
+		if header == "" {
+			return RequestMatcher{}, false
+		}
+		result.Header = http.CanonicalHeaderKey(header)
+		if hasValue {
+			result.Match = globToRegexp(valuePattern)
+		}
+
+	default:
+		return RequestMatcher{}, false
+	}
+
+	return result, true
+}
+
+func (m RequestMatcher) Matches(req *http.Request) bool {
+	if m.Path != nil {
+		return m.Path.MatchString(req.URL.Path)
+	}
+
+	values, ok := req.Header[m.Header]
+	if !ok {
+		return false
+	}
+	if m.Match == nil {
+		return true
+	}
+
+	for _, value := range values {
+		if m.Match.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags returns the tags for a request. When more than one rule sets the same
+// tag, the first matching rule wins.
+func (rules LogTagRules) Tags(req *http.Request) map[string]string {
+	var tags map[string]string
+	for _, rule := range rules {
+		if _, set := tags[rule.Name]; set {
+			continue
+		}
+		if rule.Matches(req) {
+			if tags == nil {
+				tags = map[string]string{}
+			}
+			tags[rule.Name] = rule.Value
+		}
+	}
+	return tags
+}
+
+// Private
+
+func globToRegexp(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	return regexp.MustCompile("^" + strings.ReplaceAll(quoted, `\*`, ".*") + "$")
+}
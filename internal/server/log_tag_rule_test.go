@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogTagRules_Tags(t *testing.T) {
+	rules, err := ParseLogTagRules([]string{
+		"area=api:path=/api/*",
+		"area=assets:path=/assets/*",
+		"area=other:path=*",
+		"client=mobile:header=User-Agent=*Mobile*",
+		"traced=yes:header=x-trace-id",
+	})
+	require.NoError(t, err)
+
+	tagsFor := func(path string, headers map[string]string) map[string]string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return rules.Tags(req)
+	}
+
+	assert.Equal(t, map[string]string{"area": "api"}, tagsFor("/api/v1/users", nil))
+	assert.Equal(t, map[string]string{"area": "assets"}, tagsFor("/assets/app.js", nil))
+	assert.Equal(t, map[string]string{"area": "other"}, tagsFor("/", nil))
+	assert.Equal(t, map[string]string{"area": "api", "client": "mobile"}, tagsFor("/api/", map[string]string{"User-Agent": "Robot Mobile/1"}))
+	assert.Equal(t, map[string]string{"area": "other", "traced": "yes"}, tagsFor("/page", map[string]string{"X-Trace-ID": "1"}))
+}
+
+func TestLogTagRules_InvalidRules(t *testing.T) {
+	for _, rule := range []string{"area=api", "=api:path=/api", "area=api:path=", "area=api:header=", "area=api:query=a"} {
+		_, err := ParseLogTagRule(rule)
+		assert.ErrorIs(t, err, ErrorInvalidLogTagRule, rule)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"io"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 )
@@ -18,10 +22,23 @@ var (
 )
 
 type loggingRequestContext struct {
-	Service         string
-	Target          string
-	RequestHeaders  []string
-	ResponseHeaders []string
+	Service            string
+	Target             string
+	RequestHeaders     []string
+	ResponseHeaders    []string
+	QueryParams        []string
+	Tags               map[string]string
+	Labels             map[string]string
+	DeployID           string
+	PreviousTarget     string
+	DeployUntil        time.Time
+	Stats              *ServiceStats
+	SLO                *SLOTracker
+	Transfer           *ServiceTransferStats
+	UpstreamTimeout    string
+	ClientDisconnected bool
+	RequestStart       time.Time
+	QueueTime          time.Duration
 }
 
 type LoggingMiddleware struct {
@@ -56,9 +73,25 @@ func (h *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	r = r.WithContext(ctx)
 
 	started := time.Now()
+	defer func() {
+		// Requests can be aborted with a panic when the client goes away
+		// partway through the response; they should still be logged.
+		aborted := recover()
+		if isClientDisconnected(r, aborted) {
+			loggingRequestContext.ClientDisconnected = true
+		}
+
+		h.logRequest(writer, r, &loggingRequestContext, time.Since(started))
+
+		if aborted != nil {
+			panic(aborted)
+		}
+	}()
+
 	h.next.ServeHTTP(writer, r)
-	elapsed := time.Since(started)
+}
 
+func (h *LoggingMiddleware) logRequest(writer *loggerResponseWriter, r *http.Request, loggingRequestContext *loggingRequestContext, elapsed time.Duration) {
 	port := h.httpPort
 	scheme := "http"
 	if r.TLS != nil {
@@ -71,6 +104,7 @@ func (h *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		clientAddr = r.RemoteAddr
 		clientPort = ""
 	}
+	clientAddr = normalizeClientAddr(clientAddr)
 
 	remoteAddr := r.Header.Get("X-Forwarded-For")
 	if remoteAddr == "" {
@@ -97,25 +131,106 @@ func (h *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		slog.String("user_agent", r.Header.Get("User-Agent")),
 		slog.String("proto", r.Proto),
 		slog.String("scheme", scheme),
-		slog.String("query", r.URL.RawQuery),
+		slog.String("query", h.loggableQuery(r.URL.RawQuery, loggingRequestContext.QueryParams)),
 	}
 
 	attrs = append(attrs, h.retrieveCustomHeaders(loggingRequestContext.RequestHeaders, r.Header, "req")...)
 	attrs = append(attrs, h.retrieveCustomHeaders(loggingRequestContext.ResponseHeaders, writer.Header(), "resp")...)
+	attrs = append(attrs, h.retrieveQueryParams(loggingRequestContext.QueryParams, r.URL.RawQuery)...)
+	attrs = append(attrs, h.retrieveGroup("tags", loggingRequestContext.Tags)...)
+	attrs = append(attrs, h.retrieveGroup("labels", loggingRequestContext.Labels)...)
+
+	if loggingRequestContext.DeployID != "" {
+		attrs = append(attrs,
+			slog.String("deploy_id", loggingRequestContext.DeployID),
+			slog.String("previous_target", loggingRequestContext.PreviousTarget))
+	}
+
+	if loggingRequestContext.UpstreamTimeout != "" {
+		attrs = append(attrs, slog.String("upstream_timeout", loggingRequestContext.UpstreamTimeout))
+	}
+
+	if !loggingRequestContext.RequestStart.IsZero() {
+		attrs = append(attrs,
+			slog.Int64("request_start", loggingRequestContext.RequestStart.UnixMilli()),
+			slog.Int64("queue_time", loggingRequestContext.QueueTime.Nanoseconds()))
+	}
+
+	if loggingRequestContext.ClientDisconnected {
+		attrs = append(attrs, slog.Bool("client_disconnected", true))
+	}
 
 	h.logger.LogAttrs(context.TODO(), slog.LevelInfo, "Request", attrs...)
+
+	if loggingRequestContext.Stats != nil {
+		loggingRequestContext.Stats.Record(r.URL.Path, writer.statusCode, elapsed)
+	}
+	if loggingRequestContext.SLO != nil {
+		loggingRequestContext.SLO.Record(writer.statusCode, elapsed)
+	}
 }
 
+// retrieveCustomHeaders returns the headers to log. Each is logged under its
+// name with the prefix, such as resp_x_runtime, unless it's given as
+// Header=field, in which case it's logged as field.
 func (h *LoggingMiddleware) retrieveCustomHeaders(headerNames []string, header http.Header, prefix string) []slog.Attr {
 	attrs := []slog.Attr{}
 	for _, headerName := range headerNames {
-		name := prefix + "_" + strings.ReplaceAll(strings.ToLower(headerName), "-", "_")
+		headerName, name, found := strings.Cut(headerName, "=")
+		if !found || name == "" {
+			name = prefix + "_" + strings.ReplaceAll(strings.ToLower(headerName), "-", "_")
+		}
 		value := strings.Join(header[headerName], ",")
 		attrs = append(attrs, slog.String(name, value))
 	}
 	return attrs
 }
 
+// loggableQuery returns the query string to log. When an allowlist of query
+// params is in use, any param not on the list is scrubbed from the result.
+func (h *LoggingMiddleware) loggableQuery(rawQuery string, allowedParams []string) string {
+	if len(allowedParams) == 0 {
+		return rawQuery
+	}
+
+	values, _ := url.ParseQuery(rawQuery)
+	allowed := url.Values{}
+	for _, param := range allowedParams {
+		if value, ok := values[param]; ok {
+			allowed[param] = value
+		}
+	}
+
+	return allowed.Encode()
+}
+
+func (h *LoggingMiddleware) retrieveQueryParams(paramNames []string, rawQuery string) []slog.Attr {
+	attrs := []slog.Attr{}
+	if len(paramNames) == 0 {
+		return attrs
+	}
+
+	values, _ := url.ParseQuery(rawQuery)
+	for _, paramName := range paramNames {
+		name := "query_" + strings.ReplaceAll(strings.ToLower(paramName), "-", "_")
+		value := strings.Join(values[paramName], ",")
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return attrs
+}
+
+func (h *LoggingMiddleware) retrieveGroup(group string, values map[string]string) []slog.Attr {
+	if len(values) == 0 {
+		return nil
+	}
+
+	attrs := []any{}
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		attrs = append(attrs, slog.String(name, values[name]))
+	}
+	return []slog.Attr{slog.Group(group, attrs...)}
+}
+
 type loggerResponseWriter struct {
 	http.ResponseWriter
 	statusCode   int
@@ -128,7 +243,9 @@ func newLoggerResponseWriter(w http.ResponseWriter) *loggerResponseWriter {
 
 // WriteHeader is used to capture the status code
 func (r *loggerResponseWriter) WriteHeader(statusCode int) {
-	r.statusCode = statusCode
+	if !isInformationalStatus(statusCode) {
+		r.statusCode = statusCode
+	}
 	r.ResponseWriter.WriteHeader(statusCode)
 }
 
@@ -139,6 +256,12 @@ func (r *loggerResponseWriter) Write(b []byte) (int, error) {
 	return bytesWritten, err
 }
 
+func (r *loggerResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	bytesWritten, err := readFrom(r.ResponseWriter, src)
+	r.bytesWritten += bytesWritten
+	return bytesWritten, err
+}
+
 func (r *loggerResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hijacker, ok := r.ResponseWriter.(http.Hijacker)
 	if !ok {
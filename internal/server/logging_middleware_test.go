@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -98,3 +99,127 @@ func TestMiddleware_LoggingMiddleware(t *testing.T) {
 	assert.Equal(t, "HTTP/1.1", logline.Proto)
 	assert.Equal(t, "http", logline.Scheme)
 }
+
+func TestMiddleware_LoggingMiddlewareWithResponseHeaderFields(t *testing.T) {
+	options := defaultTargetOptions
+	options.LogResponseHeaders = []string{"x-runtime=runtime", "X-Cache-Status"}
+	target := testTargetWithOptions(t, options, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Runtime", "0.042")
+		w.Header().Set("X-Cache-Status", "HIT")
+	})
+
+	out := &strings.Builder{}
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+	middleware := WithLoggingMiddleware(logger, 80, 443, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testServeRequestWithTarget(t, target, w, r)
+	}))
+
+	middleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	logline := map[string]any{}
+	require.NoError(t, json.NewDecoder(strings.NewReader(out.String())).Decode(&logline))
+
+	assert.Equal(t, "0.042", logline["runtime"])
+	assert.Equal(t, "HIT", logline["resp_x_cache_status"])
+	assert.NotContains(t, logline, "resp_x_runtime")
+}
+
+func TestMiddleware_LoggingMiddlewareWithQueryParamAllowlist(t *testing.T) {
+	out := &strings.Builder{}
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LoggingRequestContext(r).QueryParams = []string{"page", "utm-source"}
+	})
+
+	middleware := WithLoggingMiddleware(logger, 80, 443, handler)
+
+	req := httptest.NewRequest("GET", "http://app.example.com/somepath?page=2&token=secret&utm-source=mail", nil)
+	middleware.ServeHTTP(httptest.NewRecorder(), req)
+
+	logline := struct {
+		Query          string `json:"query"`
+		QueryPage      string `json:"query_page"`
+		QueryUTMSource string `json:"query_utm_source"`
+	}{}
+
+	err := json.NewDecoder(strings.NewReader(out.String())).Decode(&logline)
+	require.NoError(t, err)
+
+	assert.Equal(t, "page=2&utm-source=mail", logline.Query)
+	assert.Equal(t, "2", logline.QueryPage)
+	assert.Equal(t, "mail", logline.QueryUTMSource)
+	assert.NotContains(t, out.String(), "secret")
+}
+
+func TestMiddleware_LoggingMiddlewareWithTags(t *testing.T) {
+	out := &strings.Builder{}
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LoggingRequestContext(r).Tags = map[string]string{"area": "api", "client": "mobile"}
+	})
+
+	middleware := WithLoggingMiddleware(logger, 80, 443, handler)
+	middleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://app.example.com/api", nil))
+
+	logline := struct {
+		Tags map[string]string `json:"tags"`
+	}{}
+
+	err := json.NewDecoder(strings.NewReader(out.String())).Decode(&logline)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"area": "api", "client": "mobile"}, logline.Tags)
+}
+
+func TestMiddleware_LoggingMiddlewareWithTargetLabels(t *testing.T) {
+	out := &strings.Builder{}
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
+	target.options.Labels = map[string]string{"git_sha": "abc123", "image": "app:v2"}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testServeRequestWithTarget(t, target, w, r)
+	})
+
+	middleware := WithLoggingMiddleware(logger, 80, 443, handler)
+	middleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://app.example.com/", nil))
+
+	logline := struct {
+		Labels map[string]string `json:"labels"`
+	}{}
+
+	err := json.NewDecoder(strings.NewReader(out.String())).Decode(&logline)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"git_sha": "abc123", "image": "app:v2"}, logline.Labels)
+}
+
+func TestMiddleware_LoggingMiddlewareWithClientDisconnect(t *testing.T) {
+	out := &strings.Builder{}
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic(http.ErrAbortHandler)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "http://app.example.com/", nil).WithContext(ctx)
+
+	middleware := WithLoggingMiddleware(logger, 80, 443, handler)
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+	})
+
+	logline := struct {
+		Status             int  `json:"status"`
+		ClientDisconnected bool `json:"client_disconnected"`
+	}{}
+
+	err := json.NewDecoder(strings.NewReader(out.String())).Decode(&logline)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, logline.Status)
+	assert.True(t, logline.ClientDisconnected)
+}
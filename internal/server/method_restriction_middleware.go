@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type MethodRestrictionMiddleware struct {
+	allowed []string
+	denied  []string
+	next    http.Handler
+}
+
+func WithMethodRestrictionMiddleware(allowed, denied []string, next http.Handler) http.Handler {
+	return &MethodRestrictionMiddleware{
+		allowed: canonicalizeMethods(allowed),
+		denied:  canonicalizeMethods(denied),
+		next:    next,
+	}
+}
+
+func (h *MethodRestrictionMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.methodPermitted(r.Method) {
+		if len(h.allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(h.allowed, ", "))
+		}
+		SetErrorResponse(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (h *MethodRestrictionMiddleware) methodPermitted(method string) bool {
+	if slices.Contains(h.denied, method) {
+		return false
+	}
+
+	return len(h.allowed) == 0 || slices.Contains(h.allowed, method)
+}
+
+func canonicalizeMethods(methods []string) []string {
+	result := []string{}
+	for _, method := range methods {
+		result = append(result, strings.ToUpper(method))
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMethodRestrictionMiddleware(t *testing.T) {
+	check := func(allowed, denied []string, method string) (int, string) {
+		handler := WithMethodRestrictionMiddleware(allowed, denied, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/", nil))
+		return w.Result().StatusCode, w.Header().Get("Allow")
+	}
+
+	t.Run("no restrictions", func(t *testing.T) {
+		status, _ := check(nil, nil, "TRACE")
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("allowed methods", func(t *testing.T) {
+		status, _ := check([]string{"get", "POST"}, nil, http.MethodGet)
+		assert.Equal(t, http.StatusOK, status)
+
+		status, allow := check([]string{"get", "POST"}, nil, http.MethodDelete)
+		assert.Equal(t, http.StatusMethodNotAllowed, status)
+		assert.Equal(t, "GET, POST", allow)
+	})
+
+	t.Run("denied methods", func(t *testing.T) {
+		status, _ := check(nil, []string{"trace", "TRACK"}, http.MethodDelete)
+		assert.Equal(t, http.StatusOK, status)
+
+		status, allow := check(nil, []string{"trace", "TRACK"}, "TRACK")
+		assert.Equal(t, http.StatusMethodNotAllowed, status)
+		assert.Empty(t, allow)
+	})
+}
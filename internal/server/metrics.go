@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "kamal_proxy"
+
+// bodySizeBuckets go from 256 bytes to 64MB, in steps of 4x.
+var bodySizeBuckets = prometheus.ExponentialBuckets(256, 4, 10)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	requestsCounter          = newCounterVec("http_requests_total", "Number of HTTP requests handled", "service", "method", "status")
+	requestDurationSeconds   = newHistogramVec("http_request_duration_seconds", "Time taken to handle HTTP requests", prometheus.DefBuckets, "service")
+	requestSizeBytes         = newHistogramVec("http_request_size_bytes", "Size of HTTP request bodies received from clients", bodySizeBuckets, "service")
+	responseSizeBytes        = newHistogramVec("http_response_size_bytes", "Size of HTTP response bodies sent to clients", bodySizeBuckets, "service")
+	deployRequestsCounter    = newCounterVec("deploy_requests_total", "Number of HTTP requests handled shortly after a target switch, by deployment", "service", "deploy_id", "previous_target", "status")
+	clientDisconnectsCounter = newCounterVec("client_disconnects_total", "Number of requests where the client disconnected before the response was complete", "service")
+	shedRequestsCounter      = newCounterVec("shed_requests_total", "Number of requests rejected to protect an overloaded target, by priority", "service", "priority")
+	throttledRequestsCounter = newCounterVec("throttled_requests_total", "Number of requests rejected because their client was using more than its fair share of a target", "service")
+	upstreamTimeoutsCounter  = newCounterVec("upstream_timeouts_total", "Number of requests that timed out waiting for the target, by kind of timeout", "service", "kind")
+	invalidResponsesCounter  = newCounterVec("invalid_responses_total", "Number of responses from targets that failed a response validation rule, by kind of rule", "service", "kind")
+	panicsCounter            = newCounterVec("panics_total", "Number of requests where handling the request panicked", "service")
+	droppedLogLinesCounter   = newCounterVec("dropped_log_lines_total", "Number of access log lines dropped because the log buffer was full")
+
+	upstreamConnectionsCounter  = newCounterVec("upstream_connections_total", "Number of connections used for requests to targets, by whether an idle connection was reused", "target", "reused")
+	upstreamDialDurationSeconds = newHistogramVec("upstream_dial_duration_seconds", "Time taken to open new connections to targets", prometheus.DefBuckets, "target")
+	upstreamIdlePoolFullCounter = newCounterVec("upstream_idle_pool_full_total", "Number of connections to targets closed after a request because the pool of idle connections was full", "target")
+
+	tlsHandshakesCounter        = newCounterVec("tls_handshakes_total", "Number of completed TLS handshakes, by host, version, cipher suite, negotiated protocol and whether a session was resumed", "host", "version", "cipher", "protocol", "resumed")
+	tlsHandshakeFailuresCounter = newCounterVec("tls_handshake_failures_total", "Number of TLS handshakes that failed, by host and reason", "host", "reason")
+	tlsHandshakeDurationSeconds = newHistogramVec("tls_handshake_duration_seconds", "Time taken to complete TLS handshakes, from accepting the connection", prometheus.DefBuckets, "host")
+
+	serviceBytesInCounter         = newCounterVec("service_received_bytes_total", "Number of bytes received from clients, including request bodies and upgraded connections", "service")
+	serviceBytesOutCounter        = newCounterVec("service_sent_bytes_total", "Number of bytes sent to clients, including response bodies and upgraded connections", "service")
+	serviceConnectionsCounter     = newCounterVec("service_connections_total", "Number of client connections that have sent requests to the service", "service")
+	serviceActiveConnectionsGauge = newGaugeVec("service_active_connections", "Number of open client connections that have sent requests to the service", "service")
+
+	websocketConnectionsGauge  = newGaugeVec("websocket_connections", "Number of WebSocket connections currently open, for services with a WebSocket policy", "service")
+	websocketRejectionsCounter = newCounterVec("websocket_rejections_total", "Number of WebSocket upgrades rejected by a service's WebSocket policy, by reason", "service", "reason")
+
+	sloMetrics   = newSLOCollector()
+	drainMetrics = newDrainCollector()
+)
+
+func init() {
+	metricsRegistry.MustRegister(collectors.NewGoCollector())
+	metricsRegistry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	metricsRegistry.MustRegister(sloMetrics)
+	metricsRegistry.MustRegister(drainMetrics)
+}
+
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// PushMetrics sends the current value of every metric to exporter.
+func PushMetrics(ctx context.Context, exporter MetricsExporter, now time.Time) error {
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		return err
+	}
+
+	return exporter.Export(ctx, families, now)
+}
+
+// Private
+
+func deleteDeployMetrics(service, deployID string) {
+	deployRequestsCounter.DeletePartialMatch(prometheus.Labels{"service": service, "deploy_id": deployID})
+}
+
+// deleteServiceMetrics removes the series of a service that has been
+// removed, so that they aren't exported forever.
+func deleteServiceMetrics(service string) {
+	labels := prometheus.Labels{"service": service}
+	for _, vec := range []*prometheus.MetricVec{
+		requestsCounter.MetricVec, requestDurationSeconds.MetricVec, requestSizeBytes.MetricVec, responseSizeBytes.MetricVec,
+		deployRequestsCounter.MetricVec, clientDisconnectsCounter.MetricVec, shedRequestsCounter.MetricVec,
+		throttledRequestsCounter.MetricVec, upstreamTimeoutsCounter.MetricVec, invalidResponsesCounter.MetricVec, panicsCounter.MetricVec,
+		serviceBytesInCounter.MetricVec, serviceBytesOutCounter.MetricVec, serviceConnectionsCounter.MetricVec, serviceActiveConnectionsGauge.MetricVec,
+		websocketConnectionsGauge.MetricVec, websocketRejectionsCounter.MetricVec,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// deleteTargetMetrics removes the series of a target that has been retired.
+func deleteTargetMetrics(target string) {
+	labels := prometheus.Labels{"target": target}
+	for _, vec := range []*prometheus.MetricVec{
+		upstreamConnectionsCounter.MetricVec, upstreamDialDurationSeconds.MetricVec, upstreamIdlePoolFullCounter.MetricVec,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+func newCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: metricsNamespace, Name: name, Help: help}, labels)
+	metricsRegistry.MustRegister(counter)
+	return counter
+}
+
+func newGaugeVec(name, help string, labels ...string) *prometheus.GaugeVec {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: metricsNamespace, Name: name, Help: help}, labels)
+	metricsRegistry.MustRegister(gauge)
+	return gauge
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: metricsNamespace, Name: name, Help: help, Buckets: buckets}, labels)
+	metricsRegistry.MustRegister(histogram)
+	return histogram
+}
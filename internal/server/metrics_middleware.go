@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type MetricsMiddleware struct {
+	next http.Handler
+}
+
+func WithMetricsMiddleware(next http.Handler) http.Handler {
+	return &MetricsMiddleware{
+		next: next,
+	}
+}
+
+func (h *MetricsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestContext := LoggingRequestContext(r)
+	writer := newMetricsResponseWriter(w, requestContext)
+	writer.connection = clientConnectionFromContext(r.Context())
+
+	var body *transferCountingBody
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &transferCountingBody{ReadCloser: r.Body, requestContext: requestContext}
+		r.Body = body
+	}
+
+	started := time.Now()
+	defer func() {
+		aborted := recover()
+		h.recordRequest(writer, r, body, time.Since(started), isClientDisconnected(r, aborted))
+
+		if aborted != nil {
+			panic(aborted)
+		}
+	}()
+
+	h.next.ServeHTTP(writer, r)
+}
+
+func (h *MetricsMiddleware) recordRequest(writer *metricsResponseWriter, r *http.Request, body *transferCountingBody, elapsed time.Duration, clientDisconnected bool) {
+	requestContext := LoggingRequestContext(r)
+	service := requestContext.Service
+	status := strconv.Itoa(writer.statusCode)
+
+	requestsCounter.WithLabelValues(service, methodLabel(r.Method), status).Inc()
+	requestDurationSeconds.WithLabelValues(service).Observe(elapsed.Seconds())
+
+	// Upgraded connections have no bodies; what's sent over them is counted
+	// in the service's transfer totals instead.
+	if writer.statusCode != http.StatusSwitchingProtocols {
+		var requestSize int64
+		if body != nil {
+			requestSize = body.bytesRead.Load()
+		}
+		requestSizeBytes.WithLabelValues(service).Observe(float64(requestSize))
+		responseSizeBytes.WithLabelValues(service).Observe(float64(writer.bytesWritten))
+	}
+
+	if requestContext.DeployID != "" && time.Now().Before(requestContext.DeployUntil) {
+		deployRequestsCounter.WithLabelValues(service, requestContext.DeployID, requestContext.PreviousTarget, status).Inc()
+	}
+
+	if clientDisconnected {
+		clientDisconnectsCounter.WithLabelValues(service).Inc()
+	}
+}
+
+// methodLabel returns the method to record a request under. Clients can send
+// any token as a method, so everything but the standard methods is counted
+// as "other", to keep the number of series bounded.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// isClientDisconnected reports whether the client went away before the
+// request finished, either while we were waiting on the target, or while the
+// response was being sent (in which case the handler aborts with a panic).
+func isClientDisconnected(r *http.Request, aborted any) bool {
+	if aborted != nil && aborted != http.ErrAbortHandler {
+		return false
+	}
+	return LoggingRequestContext(r).ClientDisconnected || r.Context().Err() != nil
+}
+
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	statusCode     int
+	bytesWritten   int64
+	requestContext *loggingRequestContext
+	connection     *clientConnection
+}
+
+func newMetricsResponseWriter(w http.ResponseWriter, requestContext *loggingRequestContext) *metricsResponseWriter {
+	return &metricsResponseWriter{ResponseWriter: w, statusCode: http.StatusOK, requestContext: requestContext}
+}
+
+func (r *metricsResponseWriter) WriteHeader(statusCode int) {
+	if !isInformationalStatus(statusCode) {
+		r.statusCode = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *metricsResponseWriter) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.requestContext.Transfer.RecordBytesOut(n)
+	r.bytesWritten += int64(n)
+	return n, err
+}
+
+func (r *metricsResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	n, err := readFrom(r.ResponseWriter, src)
+	r.requestContext.Transfer.RecordBytesOut(int(n))
+	r.bytesWritten += n
+	return n, err
+}
+
+func (r *metricsResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ResponseWriter does not implement http.Hijacker")
+	}
+
+	con, rw, err := hijacker.Hijack()
+	if err == nil {
+		r.statusCode = http.StatusSwitchingProtocols
+		con = &transferCountingConn{Conn: con, transfer: r.requestContext.Transfer, connection: r.connection}
+	}
+	return con, rw, err
+}
+
+func (r *metricsResponseWriter) Flush() {
+	flusher, ok := r.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsMiddleware_CountsRequests(t *testing.T) {
+	handler := WithMetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	before := testutil.ToFloat64(requestsCounter.WithLabelValues("", http.MethodGet, "418"))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	after := testutil.ToFloat64(requestsCounter.WithLabelValues("", http.MethodGet, "418"))
+
+	assert.Equal(t, 1.0, after-before)
+}
+
+func TestMetricsMiddleware_CountsNonStandardMethodsAsOther(t *testing.T) {
+	handler := WithMetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	before := testutil.ToFloat64(requestsCounter.WithLabelValues("", "other", "418"))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("X-RANDOM-1234", "/", nil))
+	after := testutil.ToFloat64(requestsCounter.WithLabelValues("", "other", "418"))
+
+	assert.Equal(t, 2.0, after-before)
+	assert.Zero(t, requestsCounter.DeletePartialMatch(prometheus.Labels{"method": "PROPFIND"}))
+}
+
+func TestMetricsMiddleware_CountsClientDisconnects(t *testing.T) {
+	handler := WithMetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+	before := testutil.ToFloat64(clientDisconnectsCounter.WithLabelValues(""))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	})
+	after := testutil.ToFloat64(clientDisconnectsCounter.WithLabelValues(""))
+
+	assert.Equal(t, 1.0, after-before)
+}
+
+func TestMetricsMiddleware_RecordsBodySizes(t *testing.T) {
+	handler := WithMetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LoggingRequestContext(r).Service = "sizes"
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(strings.Repeat("a", 2000)))
+	}))
+	handler = WithLoggingMiddleware(slog.New(slog.NewTextHandler(io.Discard, nil)), 80, 443, handler)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	requests := testHistogram(t, requestSizeBytes.WithLabelValues("sizes"))
+	assert.Equal(t, uint64(2), requests.GetSampleCount())
+	assert.Equal(t, 5.0, requests.GetSampleSum())
+
+	responses := testHistogram(t, responseSizeBytes.WithLabelValues("sizes"))
+	assert.Equal(t, uint64(2), responses.GetSampleCount())
+	assert.Equal(t, 4000.0, responses.GetSampleSum())
+}
+
+// Helpers
+
+func testHistogram(t *testing.T, observer prometheus.Observer) *dto.Histogram {
+	metric := &dto.Metric{}
+	require.NoError(t, observer.(prometheus.Histogram).Write(metric))
+	return metric.GetHistogram()
+}
@@ -0,0 +1,455 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	DefaultMetricsPushInterval = 10 * time.Second
+
+	metricsPushTimeout     = 5 * time.Second
+	statsdMaxPacketSize    = 1432
+	otlpDefaultMetricsPath = "/v1/metrics"
+)
+
+var ErrorUnsupportedMetricsPushURL = errors.New("metrics push URL must use the statsd, dogstatsd, http or https scheme")
+
+// MetricsExporter sends a snapshot of the metrics to a system that doesn't
+// scrape them itself.
+type MetricsExporter interface {
+	Export(ctx context.Context, families []*dto.MetricFamily, now time.Time) error
+}
+
+// NewMetricsExporter creates an exporter for the given URL. The statsd and
+// dogstatsd schemes send to a StatsD agent over UDP, while http and https
+// URLs are treated as an OTLP/HTTP collector endpoint.
+func NewMetricsExporter(rawURL string) (MetricsExporter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "statsd", "dogstatsd":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%w: %s", ErrorUnsupportedMetricsPushURL, rawURL)
+		}
+		return newStatsdExporter(u.Host, u.Scheme == "dogstatsd")
+
+	case "http", "https":
+		if u.Path == "" || u.Path == "/" {
+			u.Path = otlpDefaultMetricsPath
+		}
+		return newOTLPExporter(u.String()), nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrorUnsupportedMetricsPushURL, rawURL)
+	}
+}
+
+// StatsD
+
+var statsdInvalidCharacters = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+
+// statsdExporter sends each metric as a StatsD line. Counters are sent as the
+// change since the previous export, and histograms and summaries as the
+// change in their count and sum. DogStatsD receives the labels as tags; for
+// plain StatsD they're added to the metric name.
+type statsdExporter struct {
+	conn     net.Conn
+	withTags bool
+	previous map[string]float64
+}
+
+func newStatsdExporter(address string, withTags bool) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsdExporter{
+		conn:     conn,
+		withTags: withTags,
+		previous: map[string]float64{},
+	}, nil
+}
+
+func (e *statsdExporter) Export(ctx context.Context, families []*dto.MetricFamily, now time.Time) error {
+	var lines []string
+
+	for _, family := range families {
+		name := family.GetName()
+
+		for _, metric := range family.GetMetric() {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = e.appendCount(lines, name, metric.GetLabel(), metric.GetCounter().GetValue())
+
+			case dto.MetricType_GAUGE:
+				lines = append(lines, e.line(name, metric.GetLabel(), metric.GetGauge().GetValue(), "g"))
+
+			case dto.MetricType_UNTYPED:
+				lines = append(lines, e.line(name, metric.GetLabel(), metric.GetUntyped().GetValue(), "g"))
+
+			case dto.MetricType_HISTOGRAM:
+				h := metric.GetHistogram()
+				lines = e.appendCount(lines, name+"_count", metric.GetLabel(), float64(h.GetSampleCount()))
+				lines = e.appendCount(lines, name+"_sum", metric.GetLabel(), h.GetSampleSum())
+
+			case dto.MetricType_SUMMARY:
+				s := metric.GetSummary()
+				lines = e.appendCount(lines, name+"_count", metric.GetLabel(), float64(s.GetSampleCount()))
+				lines = e.appendCount(lines, name+"_sum", metric.GetLabel(), s.GetSampleSum())
+			}
+		}
+	}
+
+	return e.send(lines)
+}
+
+func (e *statsdExporter) appendCount(lines []string, name string, labels []*dto.LabelPair, value float64) []string {
+	key := name + "{" + labelsKey(labels) + "}"
+
+	delta := value - e.previous[key]
+	if delta < 0 {
+		// The counter was reset, so everything it holds is new.
+		delta = value
+	}
+	e.previous[key] = value
+
+	if delta == 0 {
+		return lines
+	}
+	return append(lines, e.line(name, labels, delta, "c"))
+}
+
+func (e *statsdExporter) line(name string, labels []*dto.LabelPair, value float64, kind string) string {
+	var b strings.Builder
+	b.WriteString(name)
+
+	if !e.withTags {
+		for _, label := range labels {
+			b.WriteString(".")
+			b.WriteString(statsdNameSegment(label.GetValue()))
+		}
+	}
+
+	b.WriteString(":")
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteString("|")
+	b.WriteString(kind)
+
+	if e.withTags && len(labels) > 0 {
+		b.WriteString("|#")
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(label.GetName())
+			b.WriteString(":")
+			b.WriteString(strings.NewReplacer(",", "_", "|", "_").Replace(label.GetValue()))
+		}
+	}
+
+	return b.String()
+}
+
+func (e *statsdExporter) send(lines []string) error {
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := e.conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacketSize {
+			err := flush()
+			if err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteString("\n")
+		}
+		packet.WriteString(line)
+	}
+
+	return flush()
+}
+
+func statsdNameSegment(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return statsdInvalidCharacters.ReplaceAllString(value, "_")
+}
+
+func labelsKey(labels []*dto.LabelPair) string {
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = label.GetName() + "=" + strconv.Quote(label.GetValue())
+	}
+	return strings.Join(parts, ",")
+}
+
+// OTLP
+
+// otlpExporter sends the metrics to an OpenTelemetry collector using the
+// JSON encoding of OTLP/HTTP. All values are cumulative since the proxy
+// started.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+	started  time.Time
+}
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	Count             string         `json:"count"`
+	Sum               float64        `json:"sum"`
+	BucketCounts      []string       `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
+
+type otlpQuantileValue struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes        []otlpKeyValue      `json:"attributes,omitempty"`
+	StartTimeUnixNano string              `json:"startTimeUnixNano"`
+	TimeUnixNano      string              `json:"timeUnixNano"`
+	Count             string              `json:"count"`
+	Sum               float64             `json:"sum"`
+	QuantileValues    []otlpQuantileValue `json:"quantileValues"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+const otlpAggregationTemporalityCumulative = 2
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+	return &otlpExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: metricsPushTimeout},
+		started:  time.Now(),
+	}
+}
+
+func (e *otlpExporter) Export(ctx context.Context, families []*dto.MetricFamily, now time.Time) error {
+	body, err := json.Marshal(e.request(families, now))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *otlpExporter) request(families []*dto.MetricFamily, now time.Time) otlpMetricsRequest {
+	startTime := strconv.FormatInt(e.started.UnixNano(), 10)
+	nowTime := strconv.FormatInt(now.UnixNano(), 10)
+
+	scope := otlpScopeMetrics{Metrics: []otlpMetric{}}
+	scope.Scope.Name = "kamal-proxy"
+
+	for _, family := range families {
+		metric := otlpMetric{Name: family.GetName(), Description: family.GetHelp()}
+
+		for _, m := range family.GetMetric() {
+			attributes := otlpAttributes(m.GetLabel())
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				if metric.Sum == nil {
+					metric.Sum = &otlpSum{AggregationTemporality: otlpAggregationTemporalityCumulative, IsMonotonic: true}
+				}
+				metric.Sum.DataPoints = append(metric.Sum.DataPoints, otlpNumberDataPoint{attributes, startTime, nowTime, m.GetCounter().GetValue()})
+
+			case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+				if metric.Gauge == nil {
+					metric.Gauge = &otlpGauge{}
+				}
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpNumberDataPoint{attributes, startTime, nowTime, value})
+
+			case dto.MetricType_HISTOGRAM:
+				if metric.Histogram == nil {
+					metric.Histogram = &otlpHistogram{AggregationTemporality: otlpAggregationTemporalityCumulative}
+				}
+				metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, otlpHistogramPoint(m.GetHistogram(), attributes, startTime, nowTime))
+
+			case dto.MetricType_SUMMARY:
+				if metric.Summary == nil {
+					metric.Summary = &otlpSummary{}
+				}
+				metric.Summary.DataPoints = append(metric.Summary.DataPoints, otlpSummaryPoint(m.GetSummary(), attributes, startTime, nowTime))
+
+			default:
+				continue
+			}
+		}
+
+		if metric.Sum != nil || metric.Gauge != nil || metric.Histogram != nil || metric.Summary != nil {
+			scope.Metrics = append(scope.Metrics, metric)
+		}
+	}
+
+	serviceName := otlpKeyValue{Key: "service.name"}
+	serviceName.Value.StringValue = "kamal-proxy"
+
+	resource := otlpResourceMetrics{ScopeMetrics: []otlpScopeMetrics{scope}}
+	resource.Resource.Attributes = []otlpKeyValue{serviceName}
+
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{resource}}
+}
+
+// otlpHistogramPoint converts the cumulative Prometheus buckets into the
+// per-bucket counts that OTLP expects, with a final bucket for the values
+// above the highest bound.
+func otlpHistogramPoint(h *dto.Histogram, attributes []otlpKeyValue, startTime, nowTime string) otlpHistogramDataPoint {
+	point := otlpHistogramDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: startTime,
+		TimeUnixNano:      nowTime,
+		Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:               h.GetSampleSum(),
+		BucketCounts:      []string{},
+		ExplicitBounds:    []float64{},
+	}
+
+	var previous uint64
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(bucket.GetCumulativeCount()-previous, 10))
+		previous = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+
+	return point
+}
+
+func otlpSummaryPoint(s *dto.Summary, attributes []otlpKeyValue, startTime, nowTime string) otlpSummaryDataPoint {
+	point := otlpSummaryDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: startTime,
+		TimeUnixNano:      nowTime,
+		Count:             strconv.FormatUint(s.GetSampleCount(), 10),
+		Sum:               s.GetSampleSum(),
+		QuantileValues:    []otlpQuantileValue{},
+	}
+
+	for _, q := range s.GetQuantile() {
+		point.QuantileValues = append(point.QuantileValues, otlpQuantileValue{q.GetQuantile(), q.GetValue()})
+	}
+
+	return point
+}
+
+func otlpAttributes(labels []*dto.LabelPair) []otlpKeyValue {
+	attributes := make([]otlpKeyValue, len(labels))
+	for i, label := range labels {
+		attributes[i].Key = label.GetName()
+		attributes[i].Value.StringValue = label.GetValue()
+	}
+	return attributes
+}
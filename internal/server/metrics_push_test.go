@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsExporter_DogStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	exporter, err := NewMetricsExporter("dogstatsd://" + conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	registry, counter, gauge := testMetricsRegistry()
+	counter.WithLabelValues("web").Add(3)
+	gauge.Set(7)
+
+	require.NoError(t, exporter.Export(context.Background(), testGather(t, registry), time.Now()))
+	assert.ElementsMatch(t, []string{"test_requests_total:3|c|#service:web", "test_connections:7|g"}, testReadStatsdLines(t, conn))
+
+	counter.WithLabelValues("web").Add(2)
+	require.NoError(t, exporter.Export(context.Background(), testGather(t, registry), time.Now()))
+	assert.ElementsMatch(t, []string{"test_requests_total:2|c|#service:web", "test_connections:7|g"}, testReadStatsdLines(t, conn))
+}
+
+func TestMetricsExporter_StatsDAddsLabelsToName(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	exporter, err := NewMetricsExporter("statsd://" + conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	registry, counter, _ := testMetricsRegistry()
+	counter.WithLabelValues("my.app").Inc()
+	counter.WithLabelValues("").Inc()
+
+	require.NoError(t, exporter.Export(context.Background(), testGather(t, registry), time.Now()))
+	assert.ElementsMatch(t, []string{"test_requests_total.my_app:1|c", "test_requests_total.none:1|c", "test_connections:0|g"}, testReadStatsdLines(t, conn))
+}
+
+func TestMetricsExporter_OTLP(t *testing.T) {
+	var received otlpMetricsRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	t.Cleanup(server.Close)
+
+	exporter, err := NewMetricsExporter(server.URL)
+	require.NoError(t, err)
+
+	registry, counter, _ := testMetricsRegistry()
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Buckets: []float64{0.1, 1}})
+	registry.MustRegister(histogram)
+
+	counter.WithLabelValues("web").Add(3)
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	require.NoError(t, exporter.Export(context.Background(), testGather(t, registry), time.Now()))
+
+	metrics := map[string]otlpMetric{}
+	for _, m := range received.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	requests := metrics["test_requests_total"].Sum
+	require.NotNil(t, requests)
+	assert.True(t, requests.IsMonotonic)
+	assert.Equal(t, 3.0, requests.DataPoints[0].AsDouble)
+	assert.Equal(t, "service", requests.DataPoints[0].Attributes[0].Key)
+	assert.Equal(t, "web", requests.DataPoints[0].Attributes[0].Value.StringValue)
+
+	duration := metrics["test_duration_seconds"].Histogram
+	require.NotNil(t, duration)
+	assert.Equal(t, "3", duration.DataPoints[0].Count)
+	assert.Equal(t, []float64{0.1, 1}, duration.DataPoints[0].ExplicitBounds)
+	assert.Equal(t, []string{"1", "1", "1"}, duration.DataPoints[0].BucketCounts)
+
+	assert.NotNil(t, metrics["test_connections"].Gauge)
+}
+
+func TestMetricsExporter_OTLPReportsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	exporter, err := NewMetricsExporter(server.URL + "/custom")
+	require.NoError(t, err)
+
+	registry, _, _ := testMetricsRegistry()
+	err = exporter.Export(context.Background(), testGather(t, registry), time.Now())
+	assert.ErrorContains(t, err, "503")
+}
+
+func TestMetricsExporter_UnsupportedURL(t *testing.T) {
+	_, err := NewMetricsExporter("ftp://example.com")
+	assert.ErrorIs(t, err, ErrorUnsupportedMetricsPushURL)
+
+	_, err = NewMetricsExporter("statsd://")
+	assert.ErrorIs(t, err, ErrorUnsupportedMetricsPushURL)
+}
+
+func testMetricsRegistry() (*prometheus.Registry, *prometheus.CounterVec, prometheus.Gauge) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total"}, []string{"service"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_connections"})
+	registry.MustRegister(counter, gauge)
+
+	return registry, counter, gauge
+}
+
+func testGather(t *testing.T, registry *prometheus.Registry) []*dto.MetricFamily {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	return families
+}
+
+func testReadStatsdLines(t *testing.T, conn net.PacketConn) []string {
+	buf := make([]byte, statsdMaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	return strings.Split(string(buf[:n]), "\n")
+}
@@ -0,0 +1,502 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OIDCCallbackPath = "/.kamal-proxy/oidc/callback"
+	OIDCSignOutPath  = "/.kamal-proxy/oidc/sign-out"
+
+	DefaultOIDCSessionDuration = 24 * time.Hour
+
+	oidcSessionCookieName = "kamal_proxy_oidc_session"
+	oidcLoginCookieName   = "kamal_proxy_oidc_login"
+	oidcLoginDuration     = 10 * time.Minute
+	oidcRequestTimeout    = 10 * time.Second
+)
+
+var DefaultOIDCScopes = []string{"openid", "email", "profile"}
+
+var (
+	ErrorOIDCIncomplete            = errors.New("OIDC requires an issuer, client ID and client secret")
+	ErrorOIDCDiscoveryFailed       = errors.New("unable to discover OIDC provider")
+	ErrorOIDCTokenExchangeFailed   = errors.New("unable to exchange OIDC authorization code")
+	ErrorOIDCInvalidIDToken        = errors.New("invalid OIDC ID token")
+	ErrorOIDCEmailDomainNotAllowed = errors.New("email domain is not allowed")
+)
+
+// The identity of a signed in user is passed to the target in these headers.
+// Any values sent by the client are removed, so that they can be trusted.
+const (
+	OIDCUserHeader              = "X-Forwarded-User"
+	OIDCEmailHeader             = "X-Forwarded-Email"
+	OIDCPreferredUsernameHeader = "X-Forwarded-Preferred-Username"
+)
+
+type OIDCConfig struct {
+	Issuer              string
+	ClientID            string
+	ClientSecret        string
+	Scopes              []string
+	AllowedEmailDomains []string
+	SessionDuration     time.Duration
+}
+
+// OIDCAuthenticator requires that requests come from a user who has signed in
+// with an OpenID Connect provider. Browsers are sent to the provider to sign
+// in, and once they have, the proxy keeps them signed in with a cookie.
+type OIDCAuthenticator struct {
+	config OIDCConfig
+	key    []byte
+	client *http.Client
+
+	provider     *oidcProvider
+	providerLock sync.Mutex
+}
+
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+type oidcSession struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	ExpiresAt         int64  `json:"exp"`
+}
+
+type oidcLogin struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	ReturnTo  string `json:"return_to"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type oidcIDTokenClaims struct {
+	Issuer            string       `json:"iss"`
+	Subject           string       `json:"sub"`
+	Audience          oidcAudience `json:"aud"`
+	ExpiresAt         int64        `json:"exp"`
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	EmailVerified     *bool        `json:"email_verified"`
+	PreferredUsername string       `json:"preferred_username"`
+}
+
+// oidcAudience may be given as either a single string or a list of them.
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = oidcAudience{single}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(data, &list)
+	*a = list
+	return err
+}
+
+func NewOIDCAuthenticator(config OIDCConfig) (*OIDCAuthenticator, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.ClientSecret == "" {
+		return nil, ErrorOIDCIncomplete
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultOIDCScopes
+	}
+	if config.SessionDuration <= 0 {
+		config.SessionDuration = DefaultOIDCSessionDuration
+	}
+
+	// Sessions are signed with a key derived from the client secret, so that
+	// they remain valid across restarts and deployments.
+	key := sha256.Sum256([]byte("kamal-proxy oidc session\x00" + config.ClientID + "\x00" + config.ClientSecret))
+
+	return &OIDCAuthenticator{
+		config: config,
+		key:    key[:],
+		client: &http.Client{Timeout: oidcRequestTimeout},
+	}, nil
+}
+
+// Authenticate returns true if it has responded to the request itself, either
+// to complete signing in or because the user has not yet signed in. Otherwise
+// the user's identity is added to the request, which should be proxied.
+func (a *OIDCAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) bool {
+	r.Header.Del(OIDCUserHeader)
+	r.Header.Del(OIDCEmailHeader)
+	r.Header.Del(OIDCPreferredUsernameHeader)
+
+	switch r.URL.Path {
+	case OIDCCallbackPath:
+		a.handleCallback(w, r)
+		return true
+
+	case OIDCSignOutPath:
+		a.clearCookie(w, r, oidcSessionCookieName)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return true
+	}
+
+	var session oidcSession
+	if a.readCookie(r, oidcSessionCookieName, &session) && time.Now().Unix() < session.ExpiresAt {
+		a.forwardIdentity(r, session)
+		return false
+	}
+
+	if !a.isBrowserNavigation(r) {
+		SetErrorResponse(w, r, http.StatusUnauthorized, nil)
+		return true
+	}
+
+	a.startLogin(w, r)
+	return true
+}
+
+// Private
+
+func (a *OIDCAuthenticator) startLogin(w http.ResponseWriter, r *http.Request) {
+	provider, err := a.discover()
+	if err != nil {
+		slog.Error("Unable to start OIDC sign in", "issuer", a.config.Issuer, "error", err)
+		SetErrorResponse(w, r, http.StatusBadGateway, nil)
+		return
+	}
+
+	login := oidcLogin{
+		State:     randomToken(),
+		Nonce:     randomToken(),
+		Verifier:  randomToken(),
+		ReturnTo:  r.URL.RequestURI(),
+		ExpiresAt: time.Now().Add(oidcLoginDuration).Unix(),
+	}
+	a.writeCookie(w, r, oidcLoginCookieName, login, oidcLoginDuration)
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {a.config.ClientID},
+		"redirect_uri":          {a.callbackURL(r)},
+		"scope":                 {strings.Join(a.config.Scopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	http.Redirect(w, r, appendQuery(provider.AuthorizationEndpoint, query), http.StatusFound)
+}
+
+func (a *OIDCAuthenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
+	var login oidcLogin
+	if !a.readCookie(r, oidcLoginCookieName, &login) || time.Now().Unix() >= login.ExpiresAt {
+		SetErrorResponse(w, r, http.StatusBadRequest, nil)
+		return
+	}
+	a.clearCookie(w, r, oidcLoginCookieName)
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 {
+		SetErrorResponse(w, r, http.StatusBadRequest, nil)
+		return
+	}
+
+	if query.Get("error") != "" {
+		slog.Info("OIDC sign in was not completed", "issuer", a.config.Issuer, "error", query.Get("error"))
+		SetErrorResponse(w, r, http.StatusForbidden, nil)
+		return
+	}
+
+	claims, err := a.exchangeCode(r, query.Get("code"), login)
+	if err != nil {
+		slog.Warn("Unable to complete OIDC sign in", "issuer", a.config.Issuer, "error", err)
+		SetErrorResponse(w, r, http.StatusForbidden, nil)
+		return
+	}
+
+	session := oidcSession{
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		PreferredUsername: claims.PreferredUsername,
+		ExpiresAt:         time.Now().Add(a.config.SessionDuration).Unix(),
+	}
+	a.writeCookie(w, r, oidcSessionCookieName, session, a.config.SessionDuration)
+
+	http.Redirect(w, r, safeReturnPath(login.ReturnTo), http.StatusFound)
+}
+
+func (a *OIDCAuthenticator) exchangeCode(r *http.Request, code string, login oidcLogin) (*oidcIDTokenClaims, error) {
+	provider, err := a.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.callbackURL(r)},
+		"code_verifier": {login.Verifier},
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorOIDCTokenExchangeFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: token endpoint returned %d", ErrorOIDCTokenExchangeFailed, resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorOIDCTokenExchangeFailed, err)
+	}
+
+	return a.validateIDToken(provider, tokens.IDToken, login.Nonce)
+}
+
+// validateIDToken checks the claims of an ID token. Its signature is not
+// checked, since the token was received directly from the provider's token
+// endpoint over TLS, which the OIDC spec allows in place of a signature. This
+// is why discover only accepts token endpoints that use https.
+func (a *OIDCAuthenticator) validateIDToken(provider *oidcProvider, token string, nonce string) (*oidcIDTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrorOIDCInvalidIDToken)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorOIDCInvalidIDToken, err)
+	}
+
+	var claims oidcIDTokenClaims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorOIDCInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != provider.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrorOIDCInvalidIDToken, claims.Issuer)
+	case !slices.Contains(claims.Audience, a.config.ClientID):
+		return nil, fmt.Errorf("%w: token is for another client", ErrorOIDCInvalidIDToken)
+	case time.Now().Unix() >= claims.ExpiresAt:
+		return nil, fmt.Errorf("%w: token has expired", ErrorOIDCInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrorOIDCInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: token has no subject", ErrorOIDCInvalidIDToken)
+	}
+
+	if !a.emailAllowed(claims) {
+		return nil, fmt.Errorf("%w: %s", ErrorOIDCEmailDomainNotAllowed, claims.Email)
+	}
+
+	return &claims, nil
+}
+
+func (a *OIDCAuthenticator) emailAllowed(claims oidcIDTokenClaims) bool {
+	if len(a.config.AllowedEmailDomains) == 0 {
+		return true
+	}
+
+	// Some providers let users set any email address, so the domain can only
+	// be trusted if the provider says that it has verified the address.
+	if claims.EmailVerified == nil || !*claims.EmailVerified {
+		return false
+	}
+
+	_, domain, found := strings.Cut(claims.Email, "@")
+	if !found {
+		return false
+	}
+
+	return slices.ContainsFunc(a.config.AllowedEmailDomains, func(allowed string) bool {
+		return strings.EqualFold(domain, allowed)
+	})
+}
+
+func (a *OIDCAuthenticator) discover() (*oidcProvider, error) {
+	a.providerLock.Lock()
+	defer a.providerLock.Unlock()
+
+	if a.provider != nil {
+		return a.provider, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(a.config.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := a.client.Get(discoveryURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorOIDCDiscoveryFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s returned %d", ErrorOIDCDiscoveryFailed, discoveryURL, resp.StatusCode)
+	}
+
+	var provider oidcProvider
+	err = json.NewDecoder(resp.Body).Decode(&provider)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorOIDCDiscoveryFailed, err)
+	}
+
+	if strings.TrimSuffix(provider.Issuer, "/") != strings.TrimSuffix(a.config.Issuer, "/") {
+		return nil, fmt.Errorf("%w: provider reports issuer %q", ErrorOIDCDiscoveryFailed, provider.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" {
+		return nil, fmt.Errorf("%w: provider has no authorization or token endpoint", ErrorOIDCDiscoveryFailed)
+	}
+	if tokenURL, err := url.Parse(provider.TokenEndpoint); err != nil || tokenURL.Scheme != "https" || tokenURL.Host == "" {
+		return nil, fmt.Errorf("%w: token endpoint %q does not use https", ErrorOIDCDiscoveryFailed, provider.TokenEndpoint)
+	}
+
+	a.provider = &provider
+	return a.provider, nil
+}
+
+func (a *OIDCAuthenticator) forwardIdentity(r *http.Request, session oidcSession) {
+	r.Header.Set(OIDCUserHeader, session.Subject)
+	if session.Email != "" {
+		r.Header.Set(OIDCEmailHeader, session.Email)
+	}
+	if session.PreferredUsername != "" {
+		r.Header.Set(OIDCPreferredUsernameHeader, session.PreferredUsername)
+	}
+
+	// The proxy's own cookies are of no use to the target.
+	cookies := slices.DeleteFunc(r.Cookies(), func(c *http.Cookie) bool {
+		return c.Name == oidcSessionCookieName || c.Name == oidcLoginCookieName
+	})
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+}
+
+func (a *OIDCAuthenticator) isBrowserNavigation(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func (a *OIDCAuthenticator) callbackURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + OIDCCallbackPath
+}
+
+func (a *OIDCAuthenticator) writeCookie(w http.ResponseWriter, r *http.Request, name string, value any, maxAge time.Duration) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		slog.Error("Unable to encode OIDC cookie", "name", name, "error", err)
+		return
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    encoded + "." + a.sign(encoded),
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *OIDCAuthenticator) readCookie(r *http.Request, name string, value any) bool {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+
+	encoded, signature, found := strings.Cut(cookie.Value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(a.sign(encoded))) {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(payload, value) == nil
+}
+
+func (a *OIDCAuthenticator) clearCookie(w http.ResponseWriter, r *http.Request, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *OIDCAuthenticator) sign(value string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func appendQuery(endpoint string, query url.Values) string {
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
+	return endpoint + separator + query.Encode()
+}
+
+// safeReturnPath only allows returning to a path on the same host, so that the
+// sign in flow can't be used to redirect to another site.
+func safeReturnPath(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return "/"
+	}
+	return returnTo
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCAuthenticator_SignsInAndForwardsIdentity(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	authenticator := provider.authenticator(t, OIDCConfig{})
+
+	session := provider.signIn(t, authenticator, "/reports?month=june")
+
+	r := httptest.NewRequest("GET", "http://app.example.com/reports", nil)
+	r.AddCookie(session)
+	r.AddCookie(&http.Cookie{Name: "app_session", Value: "123"})
+	r.Header.Set(OIDCEmailHeader, "spoofed@example.com")
+
+	w := httptest.NewRecorder()
+	require.False(t, authenticator.Authenticate(w, r))
+
+	assert.Equal(t, "user-1", r.Header.Get(OIDCUserHeader))
+	assert.Equal(t, "kevin@example.com", r.Header.Get(OIDCEmailHeader))
+	assert.Equal(t, "kevin", r.Header.Get(OIDCPreferredUsernameHeader))
+	assert.Equal(t, "app_session=123", r.Header.Get("Cookie"))
+}
+
+func TestOIDCAuthenticator_RejectsRequestsWithoutSession(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	authenticator := provider.authenticator(t, OIDCConfig{})
+
+	r := httptest.NewRequest("POST", "http://app.example.com/api", nil)
+	r.Header.Set(OIDCUserHeader, "spoofed")
+	w := httptest.NewRecorder()
+
+	require.True(t, authenticator.Authenticate(w, r))
+	assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	assert.Empty(t, r.Header.Get(OIDCUserHeader))
+}
+
+func TestOIDCAuthenticator_RejectsTamperedSession(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	authenticator := provider.authenticator(t, OIDCConfig{})
+
+	session := provider.signIn(t, authenticator, "/")
+	payload, _ := json.Marshal(oidcSession{Subject: "admin", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	_, signature, _ := strings.Cut(session.Value, ".")
+	session.Value = base64.RawURLEncoding.EncodeToString(payload) + "." + signature
+
+	r := httptest.NewRequest("GET", "http://app.example.com/", nil)
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+
+	require.True(t, authenticator.Authenticate(w, r))
+	assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+}
+
+func TestOIDCAuthenticator_RejectsCallbackWithWrongState(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	authenticator := provider.authenticator(t, OIDCConfig{})
+
+	login, _ := provider.startLogin(t, authenticator, "/")
+
+	r := httptest.NewRequest("GET", "http://app.example.com"+OIDCCallbackPath+"?code=abc&state=wrong", nil)
+	r.AddCookie(login)
+	w := httptest.NewRecorder()
+
+	require.True(t, authenticator.Authenticate(w, r))
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestOIDCAuthenticator_AllowedEmailDomains(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+
+	allowed := provider.authenticator(t, OIDCConfig{AllowedEmailDomains: []string{"EXAMPLE.com"}})
+	provider.signIn(t, allowed, "/")
+
+	denied := provider.authenticator(t, OIDCConfig{AllowedEmailDomains: []string{"example.org"}})
+	w := provider.completeLogin(t, denied, "/")
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+	assert.Nil(t, findCookie(w.Result().Cookies(), oidcSessionCookieName))
+
+	provider.emailVerified = false
+	w = provider.completeLogin(t, allowed, "/")
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	provider.emailVerified = true
+	provider.omitEmailVerified = true
+	w = provider.completeLogin(t, allowed, "/")
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	// Without domain restrictions, the claim isn't needed
+	provider.signIn(t, provider.authenticator(t, OIDCConfig{}), "/")
+}
+
+func TestOIDCAuthenticator_RejectsTokenForAnotherClient(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	provider.audience = "another-client"
+	authenticator := provider.authenticator(t, OIDCConfig{})
+
+	w := provider.completeLogin(t, authenticator, "/")
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+}
+
+func TestOIDCAuthenticator_SignOut(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	authenticator := provider.authenticator(t, OIDCConfig{})
+
+	r := httptest.NewRequest("GET", "http://app.example.com"+OIDCSignOutPath, nil)
+	w := httptest.NewRecorder()
+
+	require.True(t, authenticator.Authenticate(w, r))
+	assert.Equal(t, http.StatusFound, w.Result().StatusCode)
+
+	cookie := findCookie(w.Result().Cookies(), oidcSessionCookieName)
+	require.NotNil(t, cookie)
+	assert.Equal(t, -1, cookie.MaxAge)
+}
+
+func TestOIDCAuthenticator_ReportsUnreachableProvider(t *testing.T) {
+	authenticator, err := NewOIDCAuthenticator(OIDCConfig{Issuer: "http://127.0.0.1:1", ClientID: "app", ClientSecret: "secret"})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "http://app.example.com/", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+
+	require.True(t, authenticator.Authenticate(w, r))
+	assert.Equal(t, http.StatusBadGateway, w.Result().StatusCode)
+}
+
+func TestOIDCAuthenticator_RejectsInsecureTokenEndpoint(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	provider.tokenEndpoint = "http://" + provider.server.Listener.Addr().String() + "/token"
+	authenticator := provider.authenticator(t, OIDCConfig{})
+
+	r := httptest.NewRequest("GET", "http://app.example.com/", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+
+	require.True(t, authenticator.Authenticate(w, r))
+	assert.Equal(t, http.StatusBadGateway, w.Result().StatusCode)
+
+	_, err := authenticator.discover()
+	assert.ErrorIs(t, err, ErrorOIDCDiscoveryFailed)
+	assert.ErrorContains(t, err, "does not use https")
+}
+
+func TestOIDCAuthenticator_RequiresCompleteConfig(t *testing.T) {
+	_, err := NewOIDCAuthenticator(OIDCConfig{Issuer: "https://accounts.example.com", ClientID: "app"})
+	assert.ErrorIs(t, err, ErrorOIDCIncomplete)
+}
+
+func TestOIDCAuthenticator_SafeReturnPath(t *testing.T) {
+	assert.Equal(t, "/reports?month=june", safeReturnPath("/reports?month=june"))
+	assert.Equal(t, "/", safeReturnPath("//evil.example.com/"))
+	assert.Equal(t, "/", safeReturnPath("/\\evil.example.com/"))
+	assert.Equal(t, "/", safeReturnPath("https://evil.example.com/"))
+}
+
+// Helpers
+
+type testOIDCProvider struct {
+	server        *httptest.Server
+	tokenEndpoint string
+	audience      string
+	emailVerified bool
+	nonces        map[string]string
+	challenges    map[string]string
+
+	omitEmailVerified bool
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	p := &testOIDCProvider{audience: "app", emailVerified: true, nonces: map[string]string{}, challenges: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		tokenEndpoint := p.tokenEndpoint
+		if tokenEndpoint == "" {
+			tokenEndpoint = p.server.URL + "/token"
+		}
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         tokenEndpoint,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		code := r.PostFormValue("code")
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+		if clientID != "app" || clientSecret != "secret" || p.challenges[code] != base64.RawURLEncoding.EncodeToString(verifier[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fields := map[string]any{
+			"iss":                p.server.URL,
+			"sub":                "user-1",
+			"aud":                p.audience,
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"nonce":              p.nonces[code],
+			"email":              "kevin@example.com",
+			"email_verified":     p.emailVerified,
+			"preferred_username": "kevin",
+		}
+		if p.omitEmailVerified {
+			delete(fields, "email_verified")
+		}
+		claims, _ := json.Marshal(fields)
+		idToken := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(claims) + ".c2lnbmF0dXJl"
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "token"})
+	})
+
+	p.server = httptest.NewTLSServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *testOIDCProvider) authenticator(t *testing.T, config OIDCConfig) *OIDCAuthenticator {
+	config.Issuer = p.server.URL
+	config.ClientID = "app"
+	config.ClientSecret = "secret"
+
+	authenticator, err := NewOIDCAuthenticator(config)
+	require.NoError(t, err)
+
+	authenticator.client = p.server.Client()
+	authenticator.client.Timeout = oidcRequestTimeout
+
+	return authenticator
+}
+
+// startLogin makes a browser request without a session, and returns the login
+// cookie and the query sent to the provider's authorization endpoint.
+func (p *testOIDCProvider) startLogin(t *testing.T, authenticator *OIDCAuthenticator, path string) (*http.Cookie, url.Values) {
+	r := httptest.NewRequest("GET", "http://app.example.com"+path, nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w := httptest.NewRecorder()
+
+	require.True(t, authenticator.Authenticate(w, r))
+	require.Equal(t, http.StatusFound, w.Result().StatusCode)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, p.server.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+
+	query := location.Query()
+	assert.Equal(t, "http://app.example.com"+OIDCCallbackPath, query.Get("redirect_uri"))
+	assert.Equal(t, "openid email profile", query.Get("scope"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	login := findCookie(w.Result().Cookies(), oidcLoginCookieName)
+	require.NotNil(t, login)
+
+	return login, query
+}
+
+func (p *testOIDCProvider) completeLogin(t *testing.T, authenticator *OIDCAuthenticator, path string) *httptest.ResponseRecorder {
+	login, query := p.startLogin(t, authenticator, path)
+
+	code := "code-" + query.Get("state")
+	p.nonces[code] = query.Get("nonce")
+	p.challenges[code] = query.Get("code_challenge")
+
+	callback := url.Values{"code": {code}, "state": {query.Get("state")}}
+	r := httptest.NewRequest("GET", "http://app.example.com"+OIDCCallbackPath+"?"+callback.Encode(), nil)
+	r.AddCookie(login)
+	w := httptest.NewRecorder()
+
+	require.True(t, authenticator.Authenticate(w, r))
+	return w
+}
+
+func (p *testOIDCProvider) signIn(t *testing.T, authenticator *OIDCAuthenticator, path string) *http.Cookie {
+	w := p.completeLogin(t, authenticator, path)
+	require.Equal(t, http.StatusFound, w.Result().StatusCode)
+	assert.Equal(t, path, w.Header().Get("Location"))
+
+	session := findCookie(w.Result().Cookies(), oidcSessionCookieName)
+	require.NotNil(t, session)
+	assert.True(t, session.HttpOnly)
+
+	return session
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const redactedOptionValue = "[redacted]"
+
+// OptionChange is an option that a deploy changes, named as it is in the
+// saved state. Values are given as JSON, and are empty when the option is
+// unset. Those of secrets are redacted.
+type OptionChange struct {
+	Option string `json:"option"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// diffDeployOptions lists the options that differ between a service's current
+// deployment and a new one, so that options that are dropped by accident can
+// be spotted. Options of nested settings, like the health check, are listed
+// individually.
+func diffDeployOptions(oldHosts, newHosts []string, oldOptions, newOptions ServiceOptions, oldTargetOptions, newTargetOptions TargetOptions) []OptionChange {
+	from := flattenOptions(map[string]any{"hosts": oldHosts}, oldOptions, oldTargetOptions)
+	to := flattenOptions(map[string]any{"hosts": newHosts}, newOptions, newTargetOptions)
+
+	changes := []OptionChange{}
+	for _, name := range slices.Sorted(maps.Keys(mergeKeys(from, to))) {
+		if from[name] == to[name] {
+			continue
+		}
+
+		change := OptionChange{Option: name, From: from[name], To: to[name]}
+		if secretOption(name) {
+			change.From = redactOptionValue(change.From)
+			change.To = redactOptionValue(change.To)
+		}
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// flattenOptions encodes each of the options in values as JSON, keyed by its
+// dotted path. Unset options are left out.
+func flattenOptions(values ...any) map[string]string {
+	result := map[string]string{}
+	for _, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		flattenOptionValue("", data, result)
+	}
+	return result
+}
+
+func flattenOptionValue(prefix string, data json.RawMessage, result map[string]string) {
+	var fields map[string]json.RawMessage
+	if bytes.HasPrefix(data, []byte("{")) && json.Unmarshal(data, &fields) == nil {
+		for name, value := range fields {
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			flattenOptionValue(name, value, result)
+		}
+		return
+	}
+
+	switch string(data) {
+	case "null", `""`, "0", "false", "[]":
+		return
+	}
+	result[prefix] = string(data)
+}
+
+func mergeKeys(a, b map[string]string) map[string]string {
+	result := maps.Clone(a)
+	maps.Copy(result, b)
+	return result
+}
+
+func secretOption(name string) bool {
+	return strings.Contains(name, "secret") || strings.Contains(name, "credentials") || strings.Contains(name, "password")
+}
+
+func redactOptionValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedOptionValue
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrorUnknownOption = errors.New("unknown option")
+
+// DeployedOptions are the target, hosts and options that a service is
+// deployed with, so that some of them can be changed without having to give
+// all the others again.
+type DeployedOptions struct {
+	Target        string         `json:"target"`
+	Hosts         []string       `json:"hosts"`
+	Options       ServiceOptions `json:"options"`
+	TargetOptions TargetOptions  `json:"target_options"`
+}
+
+// SetOption changes an option, named as it is in the saved state, such as
+// buffer_requests or health_check_config.path. The value is given as JSON,
+// or for convenience, as a duration like 30s, or a bare string.
+func (d *DeployedOptions) SetOption(name string, value string) error {
+	candidates := []json.RawMessage{json.RawMessage(value)}
+	if duration, err := time.ParseDuration(value); err == nil {
+		candidates = append(candidates, json.RawMessage(strconv.FormatInt(int64(duration), 10)))
+	}
+	if quoted, err := json.Marshal(value); err == nil {
+		candidates = append(candidates, quoted)
+	}
+
+	var err error
+	for _, candidate := range candidates {
+		if !json.Valid(candidate) {
+			continue
+		}
+
+		err = d.updateOption(name, func(fields map[string]any, key string) {
+			fields[key] = candidate
+		})
+		if err == nil || errors.Is(err, ErrorUnknownOption) {
+			return err
+		}
+	}
+	return fmt.Errorf("invalid value for %s: %w", name, err)
+}
+
+// UnsetOption returns an option to the value that it has in defaults.
+func (d *DeployedOptions) UnsetOption(name string, defaults DeployedOptions) error {
+	if name != "hosts" && !knownOption(reflect.TypeFor[ServiceOptions](), name) && !knownOption(reflect.TypeFor[TargetOptions](), name) {
+		return fmt.Errorf("%w: %s", ErrorUnknownOption, name)
+	}
+
+	value, found, err := defaults.option(name)
+	if err != nil {
+		return err
+	}
+
+	return d.updateOption(name, func(fields map[string]any, key string) {
+		if found {
+			fields[key] = value
+		} else {
+			delete(fields, key)
+		}
+	})
+}
+
+// Private
+
+func (d *DeployedOptions) updateOption(name string, update func(fields map[string]any, key string)) error {
+	if name == "hosts" {
+		var hosts []string
+		fields := map[string]any{}
+		update(fields, "hosts")
+		err := remarshal(fields["hosts"], &hosts)
+		if err != nil {
+			return err
+		}
+		d.Hosts = hosts
+		return nil
+	}
+
+	err := updateOptionGroup(&d.Options, name, update)
+	if isUnknownFieldError(err) {
+		err = updateOptionGroup(&d.TargetOptions, name, update)
+	}
+	if isUnknownFieldError(err) {
+		return fmt.Errorf("%w: %s", ErrorUnknownOption, name)
+	}
+	return err
+}
+
+// updateOptionGroup updates an option in either the service or the target
+// options, failing with an unknown field error if it isn't one of them.
+func updateOptionGroup[T any](options *T, name string, update func(fields map[string]any, key string)) error {
+	var fields map[string]any
+	err := remarshal(*options, &fields)
+	if err != nil {
+		return err
+	}
+
+	parent, key := optionParent(fields, name)
+	update(parent, key)
+
+	var updated T
+	err = strictRemarshal(fields, &updated)
+	if err != nil {
+		return err
+	}
+
+	*options = updated
+	return nil
+}
+
+func (d DeployedOptions) option(name string) (any, bool, error) {
+	var fields map[string]any
+	err := remarshal(d, &fields)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if name == "hosts" {
+		return fields["hosts"], true, nil
+	}
+
+	for _, group := range []string{"options", "target_options"} {
+		parent, key := optionParent(fields[group].(map[string]any), name)
+		if value, ok := parent[key]; ok {
+			return value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// knownOption reports whether a dotted option name is one of the fields of
+// options, or of the structs or maps that they contain.
+func knownOption(options reflect.Type, name string) bool {
+	path := strings.Split(name, ".")
+	for i, key := range path {
+		if options.Kind() == reflect.Pointer {
+			options = options.Elem()
+		}
+
+		switch options.Kind() {
+		case reflect.Map:
+			return i == len(path)-1
+		case reflect.Struct:
+			field, ok := optionField(options, key)
+			if !ok {
+				return false
+			}
+			options = field.Type
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func optionField(options reflect.Type, key string) (reflect.StructField, bool) {
+	for i := range options.NumField() {
+		field := options.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// optionParent finds the fields that hold a dotted option name, creating
+// any that are missing along the way.
+func optionParent(fields map[string]any, name string) (map[string]any, string) {
+	path := strings.Split(name, ".")
+	for _, key := range path[:len(path)-1] {
+		child, ok := fields[key].(map[string]any)
+		if !ok {
+			child = map[string]any{}
+			fields[key] = child
+		}
+		fields = child
+	}
+	return fields, path[len(path)-1]
+}
+
+// remarshal copies a value by encoding it, keeping numbers exact when
+// they're decoded as generic values.
+func remarshal(from any, to any) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(to)
+}
+
+func strictRemarshal(from any, to any) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(to)
+}
+
+func isUnknownFieldError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "json: unknown field")
+}
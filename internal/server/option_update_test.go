@@ -0,0 +1,64 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployedOptions_SetOption(t *testing.T) {
+	deployed := DeployedOptions{
+		Target:        "web:3000",
+		Hosts:         []string{"example.com"},
+		Options:       ServiceOptions{TLSEnabled: true, AllowedMethods: []string{"GET"}},
+		TargetOptions: defaultTargetOptions,
+	}
+
+	require.NoError(t, deployed.SetOption("buffer_requests", "true"))
+	require.NoError(t, deployed.SetOption("response_timeout", "45s"))
+	require.NoError(t, deployed.SetOption("health_check_config.path", "/health"))
+	require.NoError(t, deployed.SetOption("error_page_path", "123"))
+	require.NoError(t, deployed.SetOption("hosts", `["example.com","www.example.com"]`))
+
+	assert.True(t, deployed.TargetOptions.BufferRequests)
+	assert.Equal(t, 45*time.Second, deployed.TargetOptions.ResponseTimeout)
+	assert.Equal(t, "/health", deployed.TargetOptions.HealthCheckConfig.Path)
+	assert.Equal(t, defaultTargetOptions.HealthCheckConfig.Interval, deployed.TargetOptions.HealthCheckConfig.Interval)
+	assert.Equal(t, "123", deployed.Options.ErrorPagePath)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, deployed.Hosts)
+
+	assert.True(t, deployed.Options.TLSEnabled)
+	assert.Equal(t, []string{"GET"}, deployed.Options.AllowedMethods)
+
+	assert.ErrorIs(t, deployed.SetOption("no_such_option", "true"), ErrorUnknownOption)
+	assert.ErrorIs(t, deployed.SetOption("health_check_config.nope", "1"), ErrorUnknownOption)
+	assert.Error(t, deployed.SetOption("tls_enabled", `"sometimes"`))
+	assert.True(t, deployed.Options.TLSEnabled)
+}
+
+func TestDeployedOptions_UnsetOption(t *testing.T) {
+	defaults := DeployedOptions{Options: defaultServiceOptions, TargetOptions: defaultTargetOptions}
+
+	deployed := DeployedOptions{
+		Hosts:         []string{"example.com"},
+		Options:       ServiceOptions{TLSEnabled: true, AllowedMethods: []string{"GET"}},
+		TargetOptions: defaultTargetOptions,
+	}
+	deployed.TargetOptions.HealthCheckConfig.Path = "/health"
+	deployed.TargetOptions.Labels = map[string]string{"role": "web"}
+
+	require.NoError(t, deployed.UnsetOption("allowed_methods", defaults))
+	require.NoError(t, deployed.UnsetOption("health_check_config.path", defaults))
+	require.NoError(t, deployed.UnsetOption("labels", defaults))
+	require.NoError(t, deployed.UnsetOption("hosts", defaults))
+
+	assert.Empty(t, deployed.Options.AllowedMethods)
+	assert.True(t, deployed.Options.TLSEnabled)
+	assert.Equal(t, defaultTargetOptions.HealthCheckConfig.Path, deployed.TargetOptions.HealthCheckConfig.Path)
+	assert.Empty(t, deployed.TargetOptions.Labels)
+	assert.Empty(t, deployed.Hosts)
+
+	assert.ErrorIs(t, deployed.UnsetOption("no_such_option", defaults), ErrorUnknownOption)
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"regexp"
 	"sync"
 	"time"
 )
@@ -39,9 +42,44 @@ type PauseController struct {
 	State       PauseState    `json:"state"`
 	StopMessage string        `json:"stop_message"`
 	FailAfter   time.Duration `json:"fail_after"`
+	ExceptPaths []string      `json:"except_paths,omitempty"`
+	ReadOnly    bool          `json:"read_only,omitempty"`
 
-	lock         sync.RWMutex
-	pauseChannel chan bool
+	// Since is when the service was paused or stopped, and DrainTimeout is how
+	// long its requests in flight were given to finish when it was.
+	Since        time.Time     `json:"since,omitempty"`
+	DrainTimeout time.Duration `json:"drain_timeout,omitempty"`
+
+	// Maintenance is set when the service was paused or stopped along with
+	// every other, to be resumed along with them. See Router.PauseAll.
+	Maintenance bool `json:"maintenance,omitempty"`
+
+	lock           sync.RWMutex
+	pauseChannel   chan bool
+	exceptPatterns []*regexp.Regexp
+	releases       *releaseQueue
+
+	// held tracks when each request that's waiting for the pause to end began
+	// waiting, since each fails FailAfter from then.
+	heldLock sync.Mutex
+	held     map[*heldRequest]struct{}
+}
+
+type heldRequest struct {
+	since time.Time
+}
+
+// PauseStatus describes why a paused or stopped service is holding or
+// refusing its requests. Each held request fails once it has waited for
+// Timeout, so TimeoutRemaining is how long the one that has been held the
+// longest has left; it's only set while there are HeldRequests.
+type PauseStatus struct {
+	Since            time.Time     `json:"since"`
+	Timeout          time.Duration `json:"timeout,omitempty"`
+	TimeoutRemaining time.Duration `json:"timeout_remaining,omitempty"`
+	DrainTimeout     time.Duration `json:"drain_timeout"`
+	Maintenance      bool          `json:"maintenance,omitempty"`
+	HeldRequests     int           `json:"held_requests,omitempty"`
 }
 
 func NewPauseController() *PauseController {
@@ -49,21 +87,28 @@ func NewPauseController() *PauseController {
 }
 
 func (p *PauseController) UnmarshalJSON(data []byte) error {
-	type alias *PauseController // Avoid infinite recursion when we call Unmarshal
-	err := json.Unmarshal(data, alias(p))
+	type alias PauseController // Avoid infinite recursion when we call Unmarshal
+	err := json.Unmarshal(data, (*alias)(p))
 	if err != nil {
 		return err
 	}
 
-	switch p.State {
+	// Apply the saved state to a running controller, so that a pause is set up
+	// to be waited on and resumed in the same way as any other.
+	since, drainTimeout, maintenance := p.Since, p.DrainTimeout, p.Maintenance
+	state := p.State
+	p.State = PauseStateRunning
+
+	switch state {
 	case PauseStateRunning:
 		p.Resume()
 	case PauseStatePaused:
-		p.Pause(p.FailAfter)
+		p.Pause(p.FailAfter, p.ExceptPaths, p.ReadOnly)
 	case PauseStateStopped:
 		p.Stop(p.StopMessage)
 	}
 
+	p.Since, p.DrainTimeout, p.Maintenance = since, drainTimeout, maintenance
 	return nil
 }
 
@@ -74,6 +119,54 @@ func (p *PauseController) GetState() PauseState {
 	return p.State
 }
 
+// Status describes the pause or stop, or returns nil when the service is
+// running.
+func (p *PauseController) Status() *PauseStatus {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.State == PauseStateRunning {
+		return nil
+	}
+
+	status := &PauseStatus{Since: p.Since, DrainTimeout: p.DrainTimeout, Maintenance: p.Maintenance}
+	if p.State == PauseStatePaused {
+		status.Timeout = p.FailAfter
+
+		oldest, count := p.oldestHeld()
+		if count > 0 {
+			status.HeldRequests = count
+			status.TimeoutRemaining = max(p.FailAfter-time.Since(oldest), 0)
+		}
+	}
+	return status
+}
+
+// SetDrainTimeout records how long requests in flight were given to finish
+// when the service was paused or stopped.
+func (p *PauseController) SetDrainTimeout(drainTimeout time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.DrainTimeout = drainTimeout
+}
+
+// MarkMaintenance records that the current pause or stop is for host
+// maintenance. Any other change of state clears it.
+func (p *PauseController) MarkMaintenance() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.Maintenance = p.State != PauseStateRunning
+}
+
+func (p *PauseController) InMaintenance() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.Maintenance
+}
+
 func (p *PauseController) GetStopMessage() string {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
@@ -81,55 +174,173 @@ func (p *PauseController) GetStopMessage() string {
 	return p.StopMessage
 }
 
+// IsExempt reports whether the request should continue to be proxied while
+// paused, either because its path is exempt, or because it's a read and the
+// pause is read-only.
+func (p *PauseController) IsExempt(r *http.Request) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.State != PauseStatePaused {
+		return false
+	}
+
+	if p.ReadOnly && isSafeMethod(r.Method) {
+		return true
+	}
+
+	for _, pattern := range p.exceptPatterns {
+		if pattern.MatchString(r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *PauseController) Stop(message string) error {
 	p.setState(PauseStateStopped, message)
 	return nil
 }
 
-func (p *PauseController) Pause(failAfter time.Duration) error {
+func (p *PauseController) Pause(failAfter time.Duration, exceptPaths []string, readOnly bool) error {
+	patterns := []*regexp.Regexp{}
+	for _, path := range exceptPaths {
+		patterns = append(patterns, globToRegexp(path))
+	}
+
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
 	if p.State != PauseStatePaused {
 		p.pauseChannel = make(chan bool)
+		p.Since = time.Now()
 	}
 
 	p.State = PauseStatePaused
 	p.StopMessage = ""
+	p.Maintenance = false
 	p.FailAfter = failAfter
+	p.ExceptPaths = exceptPaths
+	p.ReadOnly = readOnly
+	p.exceptPatterns = patterns
 	return nil
 }
 
 func (p *PauseController) Resume() error {
+	return p.ResumeReleasing(0)
+}
+
+// ResumeReleasing resumes, letting the requests that were held during the
+// pause through to the target at most releaseConcurrency at a time, with the
+// most important first. With a releaseConcurrency of 0, they're all let
+// through at once.
+func (p *PauseController) ResumeReleasing(releaseConcurrency int) error {
+	p.lock.Lock()
+	if releaseConcurrency > 0 && p.State == PauseStatePaused {
+		p.releases = newReleaseQueue(releaseConcurrency)
+	} else {
+		p.releases = nil
+	}
+	p.lock.Unlock()
+
 	p.setState(PauseStateRunning, "")
 	return nil
 }
 
 func (p *PauseController) Wait() (PauseWaitAction, string) {
+	action, message, _ := p.wait()
+	return action, message
+}
+
+// Hold waits in the same way as Wait. If the request was held by a pause,
+// and is let through on resume, it then waits for its turn to be released,
+// according to its priority, unless ctx is done first. The returned function
+// must be called once the request has finished.
+func (p *PauseController) Hold(ctx context.Context, priority string) (PauseWaitAction, string, func()) {
+	action, message, held := p.wait()
+	if !held || action != PauseWaitActionProceed {
+		return action, message, func() {}
+	}
+
+	p.lock.RLock()
+	releases := p.releases
+	p.lock.RUnlock()
+
+	if releases == nil {
+		return action, message, func() {}
+	}
+
+	// A request whose client has gone away is let through without waiting,
+	// and fails straight away when it's sent to the target.
+	release, err := releases.Acquire(ctx, priority)
+	if err != nil {
+		return action, message, func() {}
+	}
+	return action, message, release
+}
+
+// Private
+
+func (p *PauseController) wait() (action PauseWaitAction, message string, held bool) {
 	state, stopMessage, pauseChannel, failChannel := p.getWaitState()
 
 	switch state {
 	case PauseStateRunning:
-		return PauseWaitActionProceed, ""
+		return PauseWaitActionProceed, "", false
 
 	case PauseStateStopped:
-		return PauseWaitActionStopped, stopMessage
+		return PauseWaitActionStopped, stopMessage, false
 
 	default:
+		defer p.hold()()
+
 		select {
 		case <-pauseChannel:
 			switch p.GetState() {
 			case PauseStateStopped:
-				return PauseWaitActionStopped, p.GetStopMessage()
+				return PauseWaitActionStopped, p.GetStopMessage(), true
 			default:
-				return PauseWaitActionProceed, ""
+				return PauseWaitActionProceed, "", true
 			}
 		case <-failChannel:
-			return PauseWaitActionTimedOut, ""
+			return PauseWaitActionTimedOut, "", true
 		}
 	}
 }
 
+// hold records that a request is being held, until the returned function is
+// called.
+func (p *PauseController) hold() func() {
+	request := &heldRequest{since: time.Now()}
+
+	p.heldLock.Lock()
+	defer p.heldLock.Unlock()
+
+	if p.held == nil {
+		p.held = map[*heldRequest]struct{}{}
+	}
+	p.held[request] = struct{}{}
+
+	return func() {
+		p.heldLock.Lock()
+		defer p.heldLock.Unlock()
+		delete(p.held, request)
+	}
+}
+
+func (p *PauseController) oldestHeld() (time.Time, int) {
+	p.heldLock.Lock()
+	defer p.heldLock.Unlock()
+
+	var oldest time.Time
+	for request := range p.held {
+		if oldest.IsZero() || request.since.Before(oldest) {
+			oldest = request.since
+		}
+	}
+	return oldest, len(p.held)
+}
+
 func (p *PauseController) getWaitState() (PauseState, string, chan bool, <-chan time.Time) {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
@@ -148,7 +359,25 @@ func (p *PauseController) setState(newState PauseState, message string) {
 	if p.State != newState && p.State == PauseStatePaused {
 		close(p.pauseChannel)
 	}
+	if p.State != newState {
+		p.Since = time.Now()
+	}
+	if newState == PauseStateRunning {
+		p.DrainTimeout = 0
+	}
 
 	p.StopMessage = message
 	p.State = newState
+	p.Maintenance = false
+	p.ExceptPaths = nil
+	p.ReadOnly = false
+	p.exceptPatterns = nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
 }
@@ -1,7 +1,12 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -22,7 +27,7 @@ func TestPauseController_WaitBlocksWhenPaused(t *testing.T) {
 	p := NewPauseController()
 	var wg sync.WaitGroup
 
-	require.NoError(t, p.Pause(time.Second))
+	require.NoError(t, p.Pause(time.Second, nil, false))
 	assert.Equal(t, PauseStatePaused, p.GetState())
 
 	wg.Add(1)
@@ -40,7 +45,7 @@ func TestPauseController_WaitBlocksWhenPaused(t *testing.T) {
 func TestPauseController_PausedWaitsCanTimeout(t *testing.T) {
 	p := NewPauseController()
 
-	require.NoError(t, p.Pause(time.Millisecond))
+	require.NoError(t, p.Pause(time.Millisecond, nil, false))
 	assert.Equal(t, PauseStatePaused, p.GetState())
 
 	action, message := p.Wait()
@@ -63,7 +68,7 @@ func TestPauseController_StoppingPausedRequestsFailsThemImmediately(t *testing.T
 	p := NewPauseController()
 	var wg sync.WaitGroup
 
-	require.NoError(t, p.Pause(time.Second))
+	require.NoError(t, p.Pause(time.Second, nil, false))
 	assert.Equal(t, PauseStatePaused, p.GetState())
 
 	wg.Add(1)
@@ -77,3 +82,152 @@ func TestPauseController_StoppingPausedRequestsFailsThemImmediately(t *testing.T
 	assert.Equal(t, "Back in 15 mins!", message)
 	wg.Wait()
 }
+
+func TestPauseController_RestoresStateFromJSON(t *testing.T) {
+	p := NewPauseController()
+	require.NoError(t, p.Stop("Back soon"))
+
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+
+	var restored PauseController
+	require.NoError(t, json.Unmarshal(data, &restored))
+
+	assert.Equal(t, PauseStateStopped, restored.GetState())
+	assert.Equal(t, "Back soon", restored.GetStopMessage())
+}
+
+func TestPauseController_ExemptPaths(t *testing.T) {
+	p := NewPauseController()
+
+	require.NoError(t, p.Pause(time.Second, []string{"/webhooks/*", "/ping"}, false))
+	assert.True(t, p.IsExempt(httptest.NewRequest(http.MethodPost, "/webhooks/stripe", nil)))
+	assert.True(t, p.IsExempt(httptest.NewRequest(http.MethodPost, "/ping", nil)))
+	assert.False(t, p.IsExempt(httptest.NewRequest(http.MethodPost, "/ping/other", nil)))
+	assert.False(t, p.IsExempt(httptest.NewRequest(http.MethodPost, "/", nil)))
+
+	require.NoError(t, p.Resume())
+	assert.False(t, p.IsExempt(httptest.NewRequest(http.MethodPost, "/webhooks/stripe", nil)))
+	assert.Empty(t, p.ExceptPaths)
+}
+
+func TestPauseController_ExemptPathsAreRestoredFromState(t *testing.T) {
+	p := NewPauseController()
+	require.NoError(t, p.Pause(time.Second, []string{"/webhooks/*"}, false))
+
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+
+	var restored PauseController
+	require.NoError(t, json.Unmarshal(data, &restored))
+
+	assert.Equal(t, PauseStatePaused, restored.GetState())
+	assert.True(t, restored.IsExempt(httptest.NewRequest(http.MethodPost, "/webhooks/stripe", nil)))
+	assert.False(t, restored.IsExempt(httptest.NewRequest(http.MethodPost, "/", nil)))
+}
+
+func TestPauseController_Status(t *testing.T) {
+	p := NewPauseController()
+	assert.Nil(t, p.Status())
+
+	require.NoError(t, p.Pause(time.Minute, nil, false))
+	p.SetDrainTimeout(time.Second * 30)
+
+	status := p.Status()
+	require.NotNil(t, status)
+	assert.WithinDuration(t, time.Now(), status.Since, time.Second)
+	assert.Equal(t, time.Minute, status.Timeout)
+	assert.Zero(t, status.TimeoutRemaining)
+	assert.Zero(t, status.HeldRequests)
+	assert.Equal(t, time.Second*30, status.DrainTimeout)
+
+	// The status survives a restart, and the pause can still be resumed
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+
+	var restored PauseController
+	require.NoError(t, json.Unmarshal(data, &restored))
+	assert.Equal(t, status.Since.Unix(), restored.Status().Since.Unix())
+	assert.Equal(t, time.Second*30, restored.Status().DrainTimeout)
+
+	require.NoError(t, restored.Resume())
+	assert.Nil(t, restored.Status())
+
+	require.NoError(t, p.Stop("maintenance"))
+	assert.Zero(t, p.Status().Timeout)
+}
+
+func TestPauseController_StatusReportsOldestHeldRequest(t *testing.T) {
+	p := NewPauseController()
+	require.NoError(t, p.Pause(time.Minute, nil, false))
+	t.Cleanup(func() { p.Resume() })
+
+	// Requests are given the full timeout from when they arrive, however long
+	// the service has been paused for.
+	time.Sleep(50 * time.Millisecond)
+	go p.Wait()
+	require.Eventually(t, func() bool { return p.Status().HeldRequests == 1 }, time.Second, time.Millisecond)
+	assert.InDelta(t, time.Minute, p.Status().TimeoutRemaining, float64(30*time.Millisecond))
+
+	time.Sleep(50 * time.Millisecond)
+	go p.Wait()
+	require.Eventually(t, func() bool { return p.Status().HeldRequests == 2 }, time.Second, time.Millisecond)
+	assert.Less(t, p.Status().TimeoutRemaining, time.Minute-40*time.Millisecond)
+
+	require.NoError(t, p.Resume())
+	require.NoError(t, p.Pause(time.Minute, nil, false))
+	assert.Eventually(t, func() bool { return p.Status().HeldRequests == 0 }, time.Second, time.Millisecond)
+	assert.Zero(t, p.Status().TimeoutRemaining)
+}
+
+func TestPauseController_ReadOnly(t *testing.T) {
+	p := NewPauseController()
+
+	require.NoError(t, p.Pause(time.Second, nil, true))
+	assert.True(t, p.IsExempt(httptest.NewRequest(http.MethodGet, "/", nil)))
+	assert.True(t, p.IsExempt(httptest.NewRequest(http.MethodHead, "/", nil)))
+	assert.False(t, p.IsExempt(httptest.NewRequest(http.MethodPost, "/", nil)))
+	assert.False(t, p.IsExempt(httptest.NewRequest(http.MethodDelete, "/", nil)))
+
+	require.NoError(t, p.Pause(time.Second, nil, false))
+	assert.False(t, p.IsExempt(httptest.NewRequest(http.MethodGet, "/", nil)))
+}
+
+func TestPauseController_ResumeReleasingLimitsHeldRequests(t *testing.T) {
+	p := NewPauseController()
+	require.NoError(t, p.Pause(time.Second, nil, false))
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			action, _, release := p.Hold(context.Background(), PriorityNormal)
+			defer release()
+			assert.Equal(t, PauseWaitActionProceed, action)
+
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				seen := maxRunning.Load()
+				if current <= seen || maxRunning.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, p.ResumeReleasing(2))
+	wg.Wait()
+
+	assert.Equal(t, int32(2), maxRunning.Load())
+
+	// Requests that arrive after the resume weren't held, so they aren't queued
+	action, _, release := p.Hold(context.Background(), PriorityLow)
+	assert.Equal(t, PauseWaitActionProceed, action)
+	release()
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	PluginHookRequestReceived = "request_received"
+	PluginHookBeforeUpstream  = "before_upstream"
+	PluginHookBeforeResponse  = "before_response"
+
+	DefaultPluginTimeout = time.Second
+
+	PluginMemoryLimit   = 64 * MB
+	PluginMaxOutputSize = 1 * MB
+
+	wasmPageSize = 64 * KB
+)
+
+var PluginHooks = []string{PluginHookRequestReceived, PluginHookBeforeUpstream, PluginHookBeforeResponse}
+
+var (
+	ErrorInvalidPlugin      = errors.New("invalid plugin")
+	ErrorPluginFailed       = errors.New("plugin failed")
+	ErrorPluginOutputTooBig = errors.New("plugin output too large")
+	ErrorPluginClosed       = errors.New("plugin closed")
+)
+
+// Plugins are WebAssembly modules, built for WASI (such as with
+// GOOS=wasip1), that can inspect and change requests as they pass through a
+// service. Each plugin is given as `<path>[:<hook>,...]`; when no hooks are
+// listed, it runs for all of them:
+//
+//	request_received   when the service receives a request
+//	before_upstream    after the request is authenticated, just before it's
+//	                   sent to the target
+//	before_response    when the response's headers are about to be sent
+//
+// For each hook, the plugin is started with a PluginEvent, as JSON, on its
+// standard input. It may write a PluginResult, as JSON, to its standard
+// output to change headers or to respond to the request itself; writing
+// nothing lets the request continue unchanged. A plugin that fails, or takes
+// longer than its timeout, causes the request to fail with a 500.
+type Plugin struct {
+	Path     string
+	Hooks    []string
+	compiled *compiledPlugin
+	closed   sync.Once
+}
+
+type PluginEvent struct {
+	Hook     string            `json:"hook"`
+	Service  string            `json:"service"`
+	Target   string            `json:"target,omitempty"`
+	Config   map[string]string `json:"config,omitempty"`
+	Request  PluginRequest     `json:"request"`
+	Response *PluginResponse   `json:"response,omitempty"`
+}
+
+type PluginRequest struct {
+	Method     string      `json:"method"`
+	Host       string      `json:"host"`
+	Path       string      `json:"path"`
+	Query      string      `json:"query,omitempty"`
+	RemoteAddr string      `json:"remote_addr"`
+	Headers    http.Header `json:"headers"`
+}
+
+type PluginResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+}
+
+type PluginResult struct {
+	SetRequestHeaders     map[string]string `json:"set_request_headers,omitempty"`
+	RemoveRequestHeaders  []string          `json:"remove_request_headers,omitempty"`
+	SetResponseHeaders    map[string]string `json:"set_response_headers,omitempty"`
+	RemoveResponseHeaders []string          `json:"remove_response_headers,omitempty"`
+	Respond               *PluginReply      `json:"respond,omitempty"`
+}
+
+// PluginReply is a response sent by a plugin in place of the target's.
+type PluginReply struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+func LoadPlugin(spec string) (*Plugin, error) {
+	path, hooks, err := ParsePluginSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := compilePlugin(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrorInvalidPlugin, path, err)
+	}
+
+	return &Plugin{Path: path, Hooks: hooks, compiled: compiled}, nil
+}
+
+// ParsePluginSpec returns the path of a plugin, and the hooks it runs for.
+func ParsePluginSpec(spec string) (string, []string, error) {
+	path, hookList, ok := strings.Cut(spec, ":")
+	if path == "" {
+		return "", nil, fmt.Errorf("%w: %s", ErrorInvalidPlugin, spec)
+	}
+	if !ok {
+		return path, PluginHooks, nil
+	}
+
+	hooks := strings.Split(hookList, ",")
+	for _, hook := range hooks {
+		if !slices.Contains(PluginHooks, hook) {
+			return "", nil, fmt.Errorf("%w: %s: unknown hook %q", ErrorInvalidPlugin, spec, hook)
+		}
+	}
+	return path, hooks, nil
+}
+
+// Run starts the plugin for a single event, and returns what it asked for.
+func (p *Plugin) Run(ctx context.Context, event []byte, timeout time.Duration) (PluginResult, error) {
+	if !p.compiled.retain() {
+		return PluginResult{}, fmt.Errorf("%w: %s", ErrorPluginClosed, p.Path)
+	}
+	defer p.compiled.release()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: PluginMaxOutputSize}
+	stderr := &limitedBuffer{limit: PluginMaxOutputSize}
+
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(filepath.Base(p.Path)).
+		WithStdin(bytes.NewReader(event)).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+
+	module, err := pluginRuntime().InstantiateModule(ctx, p.compiled.module, config)
+	if module != nil {
+		module.Close(ctx)
+	}
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		err = nil
+	}
+	if err != nil {
+		return PluginResult{}, fmt.Errorf("%w: %s: %w", ErrorPluginFailed, p.Path, err)
+	}
+
+	if stderr.Len() > 0 {
+		slog.Info("Plugin output", "plugin", p.Path, "output", strings.TrimSpace(stderr.String()))
+	}
+
+	var result PluginResult
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return result, nil
+	}
+
+	err = json.Unmarshal(stdout.Bytes(), &result)
+	if err != nil {
+		return PluginResult{}, fmt.Errorf("%w: %s: %w", ErrorPluginFailed, p.Path, err)
+	}
+
+	return result, nil
+}
+
+func (p *Plugin) RunsFor(hook string) bool {
+	return slices.Contains(p.Hooks, hook)
+}
+
+// Close releases the plugin's compiled module, which is freed once no other
+// plugin is using it. Runs that are in progress are allowed to finish.
+func (p *Plugin) Close() {
+	p.closed.Do(p.compiled.release)
+}
+
+// Private
+
+var pluginRuntime = sync.OnceValue(func() wazero.Runtime {
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(PluginMemoryLimit / wasmPageSize))
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	return runtime
+})
+
+var (
+	compiledPlugins     = map[[sha256.Size]byte]*compiledPlugin{}
+	compiledPluginsLock sync.Mutex
+)
+
+// compiledPlugin is a compiled module shared by every plugin loaded from the
+// same code, so that redeploying a service with the same plugins doesn't
+// compile them again. It counts the plugins using it, along with any runs in
+// progress, and is closed once there are none.
+type compiledPlugin struct {
+	key    [sha256.Size]byte
+	module wazero.CompiledModule
+	refs   int
+}
+
+// compilePlugin compiles the module at path, or shares the one already
+// compiled from the same code. The caller must release it when done.
+func compilePlugin(path string) (*compiledPlugin, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	compiledPluginsLock.Lock()
+	defer compiledPluginsLock.Unlock()
+
+	key := sha256.Sum256(code)
+	if compiled, ok := compiledPlugins[key]; ok {
+		compiled.refs++
+		return compiled, nil
+	}
+
+	module, err := pluginRuntime().CompileModule(context.Background(), code)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := &compiledPlugin{key: key, module: module, refs: 1}
+	compiledPlugins[key] = compiled
+	return compiled, nil
+}
+
+// retain adds a reference to the module, unless it has already been closed.
+func (c *compiledPlugin) retain() bool {
+	compiledPluginsLock.Lock()
+	defer compiledPluginsLock.Unlock()
+
+	if c.refs == 0 {
+		return false
+	}
+	c.refs++
+	return true
+}
+
+func (c *compiledPlugin) release() {
+	compiledPluginsLock.Lock()
+	defer compiledPluginsLock.Unlock()
+
+	c.refs--
+	if c.refs > 0 {
+		return
+	}
+
+	delete(compiledPlugins, c.key)
+	c.module.Close(context.Background())
+}
+
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+func (b *limitedBuffer) Write(data []byte) (int, error) {
+	if int64(b.Len()+len(data)) > b.limit {
+		return 0, ErrorPluginOutputTooBig
+	}
+	return b.Buffer.Write(data)
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// PluginHost runs a service's plugins at each of the points where they hook
+// into its requests. Plugins run in the order they were given, and each sees
+// any changes made by those before it. The first to respond ends the
+// request.
+type PluginHost struct {
+	service string
+	plugins []*Plugin
+	config  map[string]string
+	timeout time.Duration
+}
+
+func NewPluginHost(service string, specs []string, config map[string]string, timeout time.Duration) (*PluginHost, error) {
+	if timeout <= 0 {
+		timeout = DefaultPluginTimeout
+	}
+
+	h := &PluginHost{
+		service: service,
+		plugins: []*Plugin{},
+		config:  config,
+		timeout: timeout,
+	}
+
+	for _, spec := range specs {
+		plugin, err := LoadPlugin(spec)
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+		h.plugins = append(h.plugins, plugin)
+	}
+
+	return h, nil
+}
+
+// Close releases the host's plugins, once they're no longer needed because
+// the service has been removed or given other plugins.
+func (h *PluginHost) Close() {
+	if h == nil {
+		return
+	}
+	for _, plugin := range h.plugins {
+		plugin.Close()
+	}
+}
+
+// RequestReceived runs the request_received hook, and returns true if a
+// plugin has responded to the request.
+func (h *PluginHost) RequestReceived(w http.ResponseWriter, r *http.Request) bool {
+	return h.runRequestHook(PluginHookRequestReceived, w, r, "")
+}
+
+// BeforeUpstream runs the before_upstream hook, and returns true if a plugin
+// has responded to the request.
+func (h *PluginHost) BeforeUpstream(w http.ResponseWriter, r *http.Request, target string) bool {
+	return h.runRequestHook(PluginHookBeforeUpstream, w, r, target)
+}
+
+// WrapResponseWriter arranges for the before_response hook to run when the
+// target's response is about to be sent.
+func (h *PluginHost) WrapResponseWriter(w http.ResponseWriter, r *http.Request, target string) http.ResponseWriter {
+	if !h.hasHook(PluginHookBeforeResponse) {
+		return w
+	}
+
+	return &pluginResponseWriter{ResponseWriter: w, host: h, request: r, target: target}
+}
+
+// Private
+
+func (h *PluginHost) hasHook(hook string) bool {
+	for _, plugin := range h.plugins {
+		if plugin.RunsFor(hook) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *PluginHost) runRequestHook(hook string, w http.ResponseWriter, r *http.Request, target string) bool {
+	for _, plugin := range h.plugins {
+		if !plugin.RunsFor(hook) {
+			continue
+		}
+
+		result, err := h.run(plugin, hook, r, target, nil)
+		if err != nil {
+			SetErrorResponse(w, r, http.StatusInternalServerError, nil)
+			return true
+		}
+
+		for _, name := range result.RemoveRequestHeaders {
+			r.Header.Del(name)
+		}
+		for name, value := range result.SetRequestHeaders {
+			r.Header.Set(name, value)
+		}
+		h.changeResponseHeaders(w.Header(), result)
+
+		if result.Respond != nil {
+			h.reply(w, result.Respond)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *PluginHost) run(plugin *Plugin, hook string, r *http.Request, target string, response *PluginResponse) (PluginResult, error) {
+	event, err := json.Marshal(PluginEvent{
+		Hook:    hook,
+		Service: h.service,
+		Target:  target,
+		Config:  h.config,
+		Request: PluginRequest{
+			Method:     r.Method,
+			Host:       r.Host,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			RemoteAddr: r.RemoteAddr,
+			Headers:    r.Header,
+		},
+		Response: response,
+	})
+	if err != nil {
+		return PluginResult{}, err
+	}
+
+	result, err := plugin.Run(r.Context(), event, h.timeout)
+	if err != nil {
+		slog.Error("Plugin failed", "service", h.service, "hook", hook, "path", r.URL.Path, "error", err)
+	}
+	return result, err
+}
+
+func (h *PluginHost) changeResponseHeaders(header http.Header, result PluginResult) {
+	for _, name := range result.RemoveResponseHeaders {
+		header.Del(name)
+	}
+	for name, value := range result.SetResponseHeaders {
+		header.Set(name, value)
+	}
+}
+
+func (h *PluginHost) reply(w http.ResponseWriter, reply *PluginReply) {
+	for name, value := range reply.Headers {
+		w.Header().Set(name, value)
+	}
+
+	statusCode := reply.Status
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	w.WriteHeader(statusCode)
+	io.WriteString(w, reply.Body)
+}
+
+// pluginResponseWriter runs the before_response hook once the response's
+// status and headers are known. If a plugin replaces the response, the rest
+// of the target's response is discarded.
+type pluginResponseWriter struct {
+	http.ResponseWriter
+	host    *PluginHost
+	request *http.Request
+	target  string
+
+	headerWritten bool
+	replaced      bool
+}
+
+func (w *pluginResponseWriter) WriteHeader(statusCode int) {
+	if isInformationalStatus(statusCode) || statusCode == http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+
+	for _, plugin := range w.host.plugins {
+		if !plugin.RunsFor(PluginHookBeforeResponse) {
+			continue
+		}
+
+		response := &PluginResponse{Status: statusCode, Headers: w.Header()}
+		result, err := w.host.run(plugin, PluginHookBeforeResponse, w.request, w.target, response)
+		if err != nil {
+			w.replace()
+			SetErrorResponse(w.ResponseWriter, w.request, http.StatusInternalServerError, nil)
+			return
+		}
+
+		w.host.changeResponseHeaders(w.Header(), result)
+
+		if result.Respond != nil {
+			w.replace()
+			w.host.reply(w.ResponseWriter, result.Respond)
+			return
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *pluginResponseWriter) Write(data []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.replaced {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *pluginResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
+
+func (w *pluginResponseWriter) Flush() {
+	if w.replaced {
+		return
+	}
+
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+// replace discards the headers of the target's response, so that none of
+// them are sent with the one that takes its place.
+func (w *pluginResponseWriter) replace() {
+	w.replaced = true
+	clear(w.Header())
+}
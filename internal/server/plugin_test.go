@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePluginSpec(t *testing.T) {
+	path, hooks, err := ParsePluginSpec("/plugins/auth.wasm")
+	require.NoError(t, err)
+	assert.Equal(t, "/plugins/auth.wasm", path)
+	assert.Equal(t, PluginHooks, hooks)
+
+	path, hooks, err = ParsePluginSpec("/plugins/auth.wasm:request_received,before_response")
+	require.NoError(t, err)
+	assert.Equal(t, "/plugins/auth.wasm", path)
+	assert.Equal(t, []string{PluginHookRequestReceived, PluginHookBeforeResponse}, hooks)
+
+	_, _, err = ParsePluginSpec("/plugins/auth.wasm:after_response")
+	assert.ErrorIs(t, err, ErrorInvalidPlugin)
+
+	_, _, err = ParsePluginSpec(":request_received")
+	assert.ErrorIs(t, err, ErrorInvalidPlugin)
+}
+
+func TestPlugin_Run(t *testing.T) {
+	plugin, err := LoadPlugin(testPluginWritingOutput(t, `{"set_request_headers":{"X-Plugin":"yes"}}`))
+	require.NoError(t, err)
+
+	result, err := plugin.Run(context.Background(), []byte(`{}`), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"X-Plugin": "yes"}, result.SetRequestHeaders)
+	assert.Nil(t, result.Respond)
+}
+
+func TestPlugin_RunWithoutOutput(t *testing.T) {
+	plugin, err := LoadPlugin(testPluginWritingOutput(t, ""))
+	require.NoError(t, err)
+
+	result, err := plugin.Run(context.Background(), []byte(`{}`), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, PluginResult{}, result)
+}
+
+func TestPlugin_RunFailures(t *testing.T) {
+	t.Run("trap", func(t *testing.T) {
+		plugin, err := LoadPlugin(testPlugin(t, []byte{0x00}, nil))
+		require.NoError(t, err)
+
+		_, err = plugin.Run(context.Background(), []byte(`{}`), time.Second)
+		assert.ErrorIs(t, err, ErrorPluginFailed)
+	})
+
+	t.Run("invalid output", func(t *testing.T) {
+		plugin, err := LoadPlugin(testPluginWritingOutput(t, "not json"))
+		require.NoError(t, err)
+
+		_, err = plugin.Run(context.Background(), []byte(`{}`), time.Second)
+		assert.ErrorIs(t, err, ErrorPluginFailed)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		// loop (br 0) end
+		plugin, err := LoadPlugin(testPlugin(t, []byte{0x03, 0x40, 0x0c, 0x00, 0x0b}, nil))
+		require.NoError(t, err)
+
+		started := time.Now()
+		_, err = plugin.Run(context.Background(), []byte(`{}`), 50*time.Millisecond)
+		assert.ErrorIs(t, err, ErrorPluginFailed)
+		assert.Less(t, time.Since(started), 5*time.Second)
+	})
+}
+
+func TestPlugin_CloseReleasesSharedModule(t *testing.T) {
+	path := testPluginWritingOutput(t, `{"set_request_headers":{"X-Shared":"yes"}}`)
+
+	first, err := LoadPlugin(path)
+	require.NoError(t, err)
+	second, err := LoadPlugin(path)
+	require.NoError(t, err)
+	assert.Same(t, first.compiled, second.compiled)
+
+	first.Close()
+	first.Close()
+	assert.Contains(t, testCompiledPlugins(), first.compiled.key)
+
+	_, err = second.Run(context.Background(), []byte(`{}`), time.Second)
+	require.NoError(t, err)
+
+	second.Close()
+	assert.NotContains(t, testCompiledPlugins(), first.compiled.key)
+
+	_, err = second.Run(context.Background(), []byte(`{}`), time.Second)
+	assert.ErrorIs(t, err, ErrorPluginClosed)
+}
+
+func TestLoadPlugin_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	require.NoError(t, os.WriteFile(path, []byte("not wasm"), 0644))
+
+	_, err := LoadPlugin(path)
+	assert.ErrorIs(t, err, ErrorInvalidPlugin)
+
+	_, err = LoadPlugin(filepath.Join(t.TempDir(), "missing.wasm"))
+	assert.ErrorIs(t, err, ErrorInvalidPlugin)
+}
+
+func testCompiledPlugins() map[[sha256.Size]byte]*compiledPlugin {
+	compiledPluginsLock.Lock()
+	defer compiledPluginsLock.Unlock()
+
+	return maps.Clone(compiledPlugins)
+}
+
+// testPluginWritingOutput returns the path of a plugin that writes output to
+// its standard output, whatever its input.
+func testPluginWritingOutput(t *testing.T, output string) string {
+	var code []byte
+	code = append(code, 0x41, 0x00, 0x41, 0x10, 0x36, 0x02, 0x00) // store the output's address, 16, at 0
+	code = append(code, 0x41, 0x04, 0x41)                         // and its length at 4
+	code = append(code, testSLEB128(len(output))...)
+	code = append(code, 0x36, 0x02, 0x00)
+	code = append(code, 0x41, 0x01, 0x41, 0x00, 0x41, 0x01, 0x41, 0x08, 0x10, 0x00, 0x1a) // fd_write(1, 0, 1, 8)
+
+	return testPlugin(t, code, []byte(output))
+}
+
+// testPlugin returns the path of a minimal WASI module, whose _start function
+// runs code, and which can call fd_write as function 0. Its memory starts with
+// data at offset 16.
+func testPlugin(t *testing.T, code []byte, data []byte) string {
+	section := func(id byte, contents ...[]byte) []byte {
+		var body []byte
+		for _, c := range contents {
+			body = append(body, c...)
+		}
+		return append(append([]byte{id}, testULEB128(len(body))...), body...)
+	}
+	name := func(s string) []byte {
+		return append(testULEB128(len(s)), s...)
+	}
+
+	body := append([]byte{0x00}, code...)
+	body = append(body, 0x0b)
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(0x01, []byte{0x02, 0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, 0x60, 0x00, 0x00})...)
+	module = append(module, section(0x02, []byte{0x01}, name("wasi_snapshot_preview1"), name("fd_write"), []byte{0x00, 0x00})...)
+	module = append(module, section(0x03, []byte{0x01, 0x01})...)
+	module = append(module, section(0x05, []byte{0x01, 0x00, 0x01})...)
+	module = append(module, section(0x07, []byte{0x02}, name("memory"), []byte{0x02, 0x00}, name("_start"), []byte{0x00, 0x01})...)
+	module = append(module, section(0x0a, []byte{0x01}, testULEB128(len(body)), body)...)
+	module = append(module, section(0x0b, []byte{0x01, 0x00, 0x41, 0x10, 0x0b}, testULEB128(len(data)), data)...)
+
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	require.NoError(t, os.WriteFile(path, module, 0644))
+	return path
+}
+
+func testULEB128(n int) []byte {
+	var result []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(result, b)
+		}
+		result = append(result, b|0x80)
+	}
+}
+
+func testSLEB128(n int) []byte {
+	var result []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if (n == 0 && b&0x40 == 0) || (n == -1 && b&0x40 != 0) {
+			return append(result, b)
+		}
+		result = append(result, b|0x80)
+	}
+}
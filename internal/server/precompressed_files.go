@@ -0,0 +1,94 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// precompressedEncodings are the encodings that files can be pre-compressed
+// with, in the order we prefer them, along with the file extension of each.
+var precompressedEncodings = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// servePrecompressed serves a pre-compressed variant of a file, such as
+// app.js.br for app.js, when there is one in an encoding that the client
+// accepts. Responses for files that have variants vary by Accept-Encoding,
+// including those that are sent uncompressed. It reports whether it served
+// the request.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, name string) bool {
+	info, err := os.Stat(name)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	hasVariants := false
+	for _, variant := range precompressedEncodings {
+		file, err := os.Open(name + variant.extension)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+
+		variantInfo, err := file.Stat()
+		if err != nil || variantInfo.IsDir() {
+			continue
+		}
+
+		hasVariants = true
+		if !acceptsEncoding(r.Header.Get("Accept-Encoding"), variant.encoding) {
+			continue
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", variant.encoding)
+		http.ServeContent(w, r, name, variantInfo.ModTime(), file)
+		return true
+	}
+
+	if hasVariants {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	return false
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows the given
+// encoding, either by name or with a wildcard, and without a q of 0.
+func acceptsEncoding(header, encoding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				q = parsed
+			}
+		}
+
+		if name == encoding {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
@@ -1,6 +1,24 @@
 package server
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// proxyBufferSize is the size of the buffers that bodies are copied between
+// clients and targets with. It's the same for every target, and can be set
+// when the proxy starts.
+var proxyBufferSize atomic.Int64
+
+func init() {
+	proxyBufferSize.Store(DefaultProxyBufferSize)
+}
+
+// SetProxyBufferSize changes the size of the buffers used by targets created
+// from now on.
+func SetProxyBufferSize(size int64) {
+	proxyBufferSize.Store(size)
+}
 
 func NewBufferPool(bufferSize int64) *BufferPool {
 	return &BufferPool{
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// RecoveryMiddleware turns a panic while handling a request into a 500, so
+// that a bug in one request doesn't silently drop its connection. The panic
+// is logged, along with its stack trace and what we know of the request, and
+// sent to the error reporter when there is one.
+//
+// Panics with http.ErrAbortHandler are deliberate, and are passed on. So are
+// those after the response has started, since it can't be replaced by an
+// error, and the connection must be closed instead.
+type RecoveryMiddleware struct {
+	reporter ErrorReporter
+	next     http.Handler
+}
+
+func WithRecoveryMiddleware(reporter ErrorReporter, next http.Handler) http.Handler {
+	return &RecoveryMiddleware{
+		reporter: reporter,
+		next:     next,
+	}
+}
+
+func (h *RecoveryMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writer := &recoveryResponseWriter{ResponseWriter: w}
+
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		if recovered == http.ErrAbortHandler {
+			panic(recovered)
+		}
+
+		h.report(r, recovered, debug.Stack())
+
+		if writer.started {
+			panic(http.ErrAbortHandler)
+		}
+		SetErrorResponse(w, r, http.StatusInternalServerError, nil)
+	}()
+
+	h.next.ServeHTTP(writer, r)
+}
+
+// Private
+
+func (h *RecoveryMiddleware) report(r *http.Request, recovered any, stack []byte) {
+	service := LoggingRequestContext(r).Service
+	requestID := r.Header.Get(requestIDHeader)
+	message := fmt.Sprint(recovered)
+
+	panicsCounter.WithLabelValues(service).Inc()
+	slog.Error("Recovered from panic", "service", service, "method", r.Method, "path", r.URL.Path, "request_id", requestID, "panic", message, "stack", string(stack))
+
+	if h.reporter != nil {
+		h.reporter.Report(ErrorReport{
+			Kind:    "panic",
+			Message: message,
+			Tags:    map[string]string{"service": service, "target": LoggingRequestContext(r).Target},
+			Extra:   map[string]string{"method": r.Method, "path": r.URL.Path, "request_id": requestID, "stack": string(stack)},
+		})
+	}
+}
+
+// recoveryResponseWriter notes whether the response has started, after
+// which it's too late to send an error in its place.
+type recoveryResponseWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *recoveryResponseWriter) WriteHeader(statusCode int) {
+	if !isInformationalStatus(statusCode) {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recoveryResponseWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recoveryResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.started = true
+	return readFrom(w.ResponseWriter, src)
+}
+
+func (w *recoveryResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ResponseWriter does not implement http.Hijacker")
+	}
+
+	w.started = true
+	return hijacker.Hijack()
+}
+
+func (w *recoveryResponseWriter) Flush() {
+	w.started = true
+
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
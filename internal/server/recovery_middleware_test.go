@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoveryMiddleware_RespondsWithError(t *testing.T) {
+	reporter := &testErrorReporter{}
+	handler := WithRecoveryMiddleware(reporter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	before := testutil.ToFloat64(panicsCounter.WithLabelValues(""))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set(requestIDHeader, "abc123")
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(panicsCounter.WithLabelValues("")))
+
+	reports := reporter.Reports()
+	require.Len(t, reports, 1)
+	assert.Equal(t, "boom", reports[0].Message)
+	assert.Equal(t, "abc123", reports[0].Extra["request_id"])
+	assert.Contains(t, reports[0].Extra["stack"], "recovery_middleware_test.go")
+}
+
+func TestRecoveryMiddleware_AbortsStartedResponses(t *testing.T) {
+	handler := WithRecoveryMiddleware(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("boom")
+	}))
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
+
+func TestRecoveryMiddleware_PassesOnAborts(t *testing.T) {
+	reporter := &testErrorReporter{}
+	handler := WithRecoveryMiddleware(reporter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	assert.Empty(t, reporter.Reports())
+}
+
+// Helpers
+
+type testErrorReporter struct {
+	sync.Mutex
+	reports []ErrorReport
+}
+
+func (r *testErrorReporter) Report(report ErrorReport) {
+	r.Lock()
+	defer r.Unlock()
+	r.reports = append(r.reports, report)
+}
+
+func (r *testErrorReporter) Close() {}
+
+func (r *testErrorReporter) Reports() []ErrorReport {
+	r.Lock()
+	defer r.Unlock()
+	return slices.Clone(r.reports)
+}
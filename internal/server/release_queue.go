@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// releaseQueue lets the requests that were held during a pause through to
+// the target a few at a time when it resumes, so that a freshly resumed
+// target isn't hit by all of them at once. Once the limit is reached, each
+// request waits for another to finish. Waiting requests are let through in
+// order of their priority, and then in the order they arrived.
+type releaseQueue struct {
+	concurrency int
+
+	lock    sync.Mutex
+	running int
+	waiting map[string][]chan struct{}
+}
+
+var releasePriorities = []string{PriorityCritical, PriorityNormal, PriorityLow}
+
+func newReleaseQueue(concurrency int) *releaseQueue {
+	return &releaseQueue{
+		concurrency: concurrency,
+		waiting:     map[string][]chan struct{}{},
+	}
+}
+
+// Acquire waits until the request can be let through, and returns a
+// function to call once it has finished. If ctx is done first, such as when
+// the client goes away, the request gives up its place, and the context's
+// error is returned.
+func (q *releaseQueue) Acquire(ctx context.Context, priority string) (func(), error) {
+	q.lock.Lock()
+	if q.running < q.concurrency && q.waitingCount() == 0 {
+		q.running++
+		q.lock.Unlock()
+		return q.release, nil
+	}
+
+	ready := make(chan struct{})
+	q.waiting[priority] = append(q.waiting[priority], ready)
+	q.lock.Unlock()
+
+	select {
+	case <-ready:
+		return q.release, nil
+	case <-ctx.Done():
+		q.abandon(priority, ready)
+		return nil, ctx.Err()
+	}
+}
+
+// Private
+
+// release hands the finished request's place to the next one waiting, if
+// there is one.
+func (q *releaseQueue) release() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, priority := range releasePriorities {
+		if waiting := q.waiting[priority]; len(waiting) > 0 {
+			q.waiting[priority] = waiting[1:]
+			close(waiting[0])
+			return
+		}
+	}
+	q.running--
+}
+
+// abandon removes a waiting request from the queue. If it was let through
+// in the meantime, its place is handed on instead.
+func (q *releaseQueue) abandon(priority string, ready chan struct{}) {
+	q.lock.Lock()
+	index := slices.Index(q.waiting[priority], ready)
+	if index >= 0 {
+		q.waiting[priority] = slices.Delete(q.waiting[priority], index, index+1)
+	}
+	q.lock.Unlock()
+
+	if index < 0 {
+		q.release()
+	}
+}
+
+func (q *releaseQueue) waitingCount() int {
+	count := 0
+	for _, waiting := range q.waiting {
+		count += len(waiting)
+	}
+	return count
+}
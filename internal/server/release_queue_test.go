@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseQueue_LimitsConcurrency(t *testing.T) {
+	q := newReleaseQueue(2)
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			release, err := q.Acquire(context.Background(), PriorityNormal)
+			require.NoError(t, err)
+			defer release()
+
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				seen := maxRunning.Load()
+				if current <= seen || maxRunning.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), maxRunning.Load())
+}
+
+func TestReleaseQueue_ReleasesInPriorityOrder(t *testing.T) {
+	q := newReleaseQueue(1)
+	release, err := q.Acquire(context.Background(), PriorityNormal)
+	require.NoError(t, err)
+
+	waiting := func() int {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return q.waitingCount()
+	}
+
+	order := make(chan string, 4)
+	for i, priority := range []string{PriorityLow, PriorityNormal, PriorityCritical, PriorityNormal} {
+		go func() {
+			done, _ := q.Acquire(context.Background(), priority)
+			order <- priority
+			done()
+		}()
+		assert.Eventually(t, func() bool { return waiting() == i+1 }, time.Second, time.Millisecond)
+	}
+
+	assert.Empty(t, order)
+	release()
+
+	assert.Equal(t, PriorityCritical, <-order)
+	assert.Equal(t, PriorityNormal, <-order)
+	assert.Equal(t, PriorityNormal, <-order)
+	assert.Equal(t, PriorityLow, <-order)
+}
+
+func TestReleaseQueue_CancelledRequestsGiveUpTheirPlace(t *testing.T) {
+	q := newReleaseQueue(1)
+	release, err := q.Acquire(context.Background(), PriorityNormal)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := make(chan error)
+	go func() {
+		_, err := q.Acquire(ctx, PriorityNormal)
+		abandoned <- err
+	}()
+
+	assert.Eventually(t, func() bool {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return q.waitingCount() == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-abandoned, context.Canceled)
+
+	q.lock.Lock()
+	assert.Zero(t, q.waitingCount())
+	q.lock.Unlock()
+
+	release()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	next, err := q.Acquire(ctx, PriorityNormal)
+	require.NoError(t, err)
+	next()
+
+	assert.Zero(t, q.running)
+}
@@ -3,23 +3,46 @@ package server
 import (
 	"log/slog"
 	"net/http"
+	"regexp"
 )
 
 type RequestBufferMiddleware struct {
-	maxMemBytes int64
-	maxBytes    int64
-	next        http.Handler
+	maxMemBytes     int64
+	maxBytes        int64
+	unbufferedPaths []*regexp.Regexp
+	next            http.Handler
 }
 
-func WithRequestBufferMiddleware(maxMemBytes, maxBytes int64, next http.Handler) http.Handler {
+// WithRequestBufferMiddleware buffers request bodies before passing them on.
+// Requests for any of the unbufferedPaths, which are glob patterns, are
+// streamed instead, and are not subject to the size limit.
+func WithRequestBufferMiddleware(maxMemBytes, maxBytes int64, unbufferedPaths []string, next http.Handler) http.Handler {
+	patterns := []*regexp.Regexp{}
+	for _, path := range unbufferedPaths {
+		patterns = append(patterns, globToRegexp(path))
+	}
+
 	return &RequestBufferMiddleware{
-		maxMemBytes: maxMemBytes,
-		maxBytes:    maxBytes,
-		next:        next,
+		maxMemBytes:     maxMemBytes,
+		maxBytes:        maxBytes,
+		unbufferedPaths: patterns,
+		next:            next,
 	}
 }
 
 func (h *RequestBufferMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.isUnbufferedPath(r.URL.Path) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if h.maxBytes > 0 && r.ContentLength > h.maxBytes {
+		// Reject before reading any of the body, so that clients waiting on a
+		// 100 Continue don't send it at all.
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
 	requestBuffer, err := NewBufferedReadCloser(r.Body, h.maxBytes, h.maxMemBytes)
 	if err != nil {
 		if err == ErrMaximumSizeExceeded {
@@ -30,7 +53,23 @@ func (h *RequestBufferMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
+	defer requestBuffer.(*Buffer).Release()
+	defer requestBuffer.Close()
 
 	r.Body = requestBuffer
+
+	// We already have the whole body, so there's no need for the target to
+	// tell us whether to send it.
+	r.Header.Del("Expect")
+
 	h.next.ServeHTTP(w, r)
 }
+
+func (h *RequestBufferMiddleware) isUnbufferedPath(path string) bool {
+	for _, pattern := range h.unbufferedPaths {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
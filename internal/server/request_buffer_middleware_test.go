@@ -1,17 +1,21 @@
 package server
 
 import (
+	"bytes"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRequestBufferMiddleware(t *testing.T) {
 	sendRequest := func(requestBody, responseBody string) *httptest.ResponseRecorder {
-		middleware := WithRequestBufferMiddleware(4, 8, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		middleware := WithRequestBufferMiddleware(4, 8, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(responseBody))
 		}))
 
@@ -35,3 +39,88 @@ func TestRequestBufferMiddleware(t *testing.T) {
 		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Result().StatusCode)
 	})
 }
+
+func TestRequestBufferMiddleware_RemovesSpilledBodyOfUnsentRequest(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	// The handler answers without sending the request on, so nothing else
+	// closes its body.
+	middleware := WithRequestBufferMiddleware(8, 1024, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://app.example.com/", strings.NewReader(strings.Repeat("a", 100)))
+	w := httptest.NewRecorder()
+	middleware.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	spills, err := filepath.Glob(filepath.Join(tmpDir, "proxy-buffer-*"))
+	require.NoError(t, err)
+	assert.Empty(t, spills)
+}
+
+func TestRequestBufferMiddleware_RejectsLargeRequestsBeforeContinuing(t *testing.T) {
+	middleware := WithRequestBufferMiddleware(4, 8, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	server := httptest.NewServer(middleware)
+	t.Cleanup(server.Close)
+
+	_, reader := testSendExpectContinueRequest(t, server.Listener.Addr().String(), "/", 100)
+	assert.Equal(t, "HTTP/1.1 413 Request Entity Too Large", testReadStatusLine(t, reader))
+}
+
+func TestRequestBufferMiddleware_DoesNotForwardExpectContinue(t *testing.T) {
+	middleware := WithRequestBufferMiddleware(4, 8, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Expect"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://app.example.com/", strings.NewReader("hello"))
+	req.Header.Set("Expect", "100-continue")
+	middleware.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestRequestBufferMiddleware_StreamsUnbufferedPaths(t *testing.T) {
+	middleware := WithRequestBufferMiddleware(4, 8, []string{"/uploads/*"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, buffered := r.Body.(*Buffer)
+		if buffered {
+			w.Write([]byte("buffered"))
+		} else {
+			w.Write([]byte("streamed"))
+		}
+	}))
+
+	sendRequest := func(path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://app.example.com"+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+		return w
+	}
+
+	w := sendRequest("/uploads/video", "this request body is much too large")
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "streamed", w.Body.String())
+
+	w = sendRequest("/other", "hello")
+	assert.Equal(t, "buffered", w.Body.String())
+
+	w = sendRequest("/other", "this request body is much too large")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Result().StatusCode)
+}
+
+func BenchmarkRequestBufferMiddleware(b *testing.B) {
+	body := bytes.Repeat([]byte("a"), 64*1024)
+	middleware := WithRequestBufferMiddleware(DefaultMaxMemoryBufferSize, DefaultMaxRequestBodySize, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	}))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "http://app.example.com/somepath", bytes.NewReader(body))
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
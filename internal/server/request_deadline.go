@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ErrorRequestDeadlineExceeded = errors.New("request deadline exceeded")
+
+// startRequestDeadline decides how long the target has to respond to a
+// request. That's the target's response timeout, or less when the client
+// asks for a shorter one in the ClientTimeoutHeader; a client can't extend
+// it. Since the transport only enforces the response timeout, a shorter
+// client timeout is enforced by cancelling the request, unless the response
+// headers arrive first.
+func (t *Target) startRequestDeadline(req *http.Request, inflightRequest *inflightRequest) {
+	timeout := t.options.ResponseTimeout
+	clientTimeout, ok := t.clientTimeout(req)
+	shortened := ok && (timeout <= 0 || clientTimeout < timeout)
+	if shortened {
+		timeout = clientTimeout
+	}
+
+	if timeout <= 0 {
+		return
+	}
+
+	inflightRequest.deadline = time.Now().Add(timeout)
+	if shortened {
+		inflightRequest.deadlineTimer = time.AfterFunc(timeout, func() {
+			inflightRequest.cancel(ErrorRequestDeadlineExceeded)
+		})
+	}
+}
+
+// setDeadlineHeader tells the target how many milliseconds it has left to
+// respond before we give up on the request, so that it can abandon work
+// whose response would never be seen. Any value set by the client is
+// replaced.
+func (t *Target) setDeadlineHeader(in, out *http.Request) {
+	out.Header.Del(t.options.DeadlineHeader)
+
+	inflightRequest := t.getInflightRequest(in)
+	if inflightRequest == nil || inflightRequest.deadline.IsZero() {
+		return
+	}
+
+	remaining := max(time.Until(inflightRequest.deadline), 0)
+	out.Header.Set(t.options.DeadlineHeader, strconv.FormatInt(remaining.Milliseconds(), 10))
+}
+
+func (t *Target) clientTimeout(req *http.Request) (time.Duration, bool) {
+	if t.options.ClientTimeoutHeader == "" {
+		return 0, false
+	}
+	return parseClientTimeout(req.Header.Get(t.options.ClientTimeoutHeader))
+}
+
+func (t *Target) isRequestDeadlineExceeded(r *http.Request) bool {
+	return errors.Is(context.Cause(r.Context()), ErrorRequestDeadlineExceeded)
+}
+
+// parseClientTimeout reads a client's timeout, given either in milliseconds,
+// or as a duration such as "2.5s".
+func parseClientTimeout(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	return timeout, timeout > 0
+}
+
+func (r *inflightRequest) stopDeadline() {
+	if r.deadlineTimer != nil {
+		r.deadlineTimer.Stop()
+	}
+}
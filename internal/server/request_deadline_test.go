@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestDeadline_SendsRemainingTimeToTarget(t *testing.T) {
+	var deadline string
+	targetOptions := defaultTargetOptions
+	targetOptions.ResponseTimeout = 10 * time.Second
+	targetOptions.DeadlineHeader = "X-Request-Deadline"
+	target := testTargetWithOptions(t, targetOptions, func(w http.ResponseWriter, r *http.Request) {
+		deadline = r.Header.Get("X-Request-Deadline")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Deadline", "999999")
+	target.SendRequest(httptest.NewRecorder(), testStartRequest(t, target, req))
+
+	ms, err := strconv.Atoi(deadline)
+	require.NoError(t, err)
+	assert.InDelta(t, 10000, ms, 1000)
+}
+
+func TestRequestDeadline_ClientTimeoutShortensDeadline(t *testing.T) {
+	var deadline string
+	targetOptions := defaultTargetOptions
+	targetOptions.ResponseTimeout = 10 * time.Second
+	targetOptions.DeadlineHeader = "X-Request-Deadline"
+	targetOptions.ClientTimeoutHeader = "X-Request-Timeout"
+	target := testTargetWithOptions(t, targetOptions, func(w http.ResponseWriter, r *http.Request) {
+		deadline = r.Header.Get("X-Request-Deadline")
+	})
+
+	send := func(timeout string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-Timeout", timeout)
+		target.SendRequest(httptest.NewRecorder(), testStartRequest(t, target, req))
+
+		ms, err := strconv.Atoi(deadline)
+		require.NoError(t, err)
+		return ms
+	}
+
+	assert.InDelta(t, 2000, send("2000"), 500)
+	assert.InDelta(t, 2500, send("2.5s"), 500)
+	assert.InDelta(t, 10000, send("1h"), 1000)
+	assert.InDelta(t, 10000, send("soon"), 1000)
+}
+
+func TestRequestDeadline_ClientTimeoutIsEnforced(t *testing.T) {
+	targetOptions := defaultTargetOptions
+	targetOptions.ClientTimeoutHeader = "X-Request-Timeout"
+	target := testTargetWithOptions(t, targetOptions, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Timeout", "50")
+	w := httptest.NewRecorder()
+	target.SendRequest(w, testStartRequest(t, target, req))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Result().StatusCode)
+}
+
+func TestRequestDeadline_ClientTimeoutStopsOnceResponseStarts(t *testing.T) {
+	targetOptions := defaultTargetOptions
+	targetOptions.ClientTimeoutHeader = "X-Request-Timeout"
+	target := testTargetWithOptions(t, targetOptions, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Timeout", "50")
+	w := httptest.NewRecorder()
+	target.SendRequest(w, testStartRequest(t, target, req))
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "done", w.Body.String())
+}
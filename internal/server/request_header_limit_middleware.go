@@ -0,0 +1,126 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+const (
+	HeaderLimitReject = "reject"
+	HeaderLimitTrim   = "trim"
+)
+
+var ErrorUnknownHeaderLimitAction = errors.New("unknown header limit action")
+
+// headerLimitProtected are never trimmed, as the target can't make sense of
+// the request without them.
+var headerLimitProtected = []string{"Authorization", "Content-Encoding", "Content-Type"}
+
+type RequestHeaderLimits struct {
+	MaxSize  int64
+	MaxCount int
+	Action   string
+}
+
+func (l RequestHeaderLimits) Validate() error {
+	switch l.Action {
+	case "", HeaderLimitReject, HeaderLimitTrim:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrorUnknownHeaderLimitAction, l.Action)
+	}
+}
+
+// RequestHeaderLimitMiddleware stops requests with too many, or too large,
+// headers from reaching the target, since some application servers fail
+// badly when given them. The size of a header is that of its name and value
+// as they would be sent, and each value of a repeated header counts towards
+// the limits. Requests over a limit are either rejected with a 431, or have
+// their largest headers removed until they fit. Requests that still don't fit
+// without their protected headers are rejected.
+type RequestHeaderLimitMiddleware struct {
+	limits RequestHeaderLimits
+	next   http.Handler
+}
+
+func WithRequestHeaderLimitMiddleware(limits RequestHeaderLimits, next http.Handler) http.Handler {
+	return &RequestHeaderLimitMiddleware{
+		limits: limits,
+		next:   next,
+	}
+}
+
+func (h *RequestHeaderLimitMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.exceeded(r.Header) && h.limits.Action == HeaderLimitTrim {
+		removed := h.trim(r.Header)
+		slog.Info("Removed request headers over the limit", "path", r.URL.Path, "headers", removed)
+	}
+
+	if h.exceeded(r.Header) {
+		slog.Info("Rejecting request with headers over the limit", "path", r.URL.Path, "size", requestHeaderSize(r.Header), "count", requestHeaderCount(r.Header))
+		SetErrorResponse(w, r, http.StatusRequestHeaderFieldsTooLarge, nil)
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (h *RequestHeaderLimitMiddleware) exceeded(header http.Header) bool {
+	return (h.limits.MaxSize > 0 && requestHeaderSize(header) > h.limits.MaxSize) ||
+		(h.limits.MaxCount > 0 && requestHeaderCount(header) > h.limits.MaxCount)
+}
+
+func (h *RequestHeaderLimitMiddleware) trim(header http.Header) []string {
+	names := slices.DeleteFunc(slices.Collect(maps.Keys(header)), func(name string) bool {
+		return slices.Contains(headerLimitProtected, name)
+	})
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(
+			cmp.Compare(headerFieldSize(b, header[b]), headerFieldSize(a, header[a])),
+			cmp.Compare(a, b),
+		)
+	})
+
+	removed := []string{}
+	for _, name := range names {
+		if !h.exceeded(header) {
+			break
+		}
+		header.Del(name)
+		removed = append(removed, name)
+	}
+	return removed
+}
+
+func requestHeaderSize(header http.Header) int64 {
+	var size int64
+	for name, values := range header {
+		size += headerFieldSize(name, values)
+	}
+	return size
+}
+
+func requestHeaderCount(header http.Header) int {
+	count := 0
+	for _, values := range header {
+		count += len(values)
+	}
+	return count
+}
+
+// headerFieldSize is the size of a header as written on the wire, including
+// the separator and line ending of each value.
+func headerFieldSize(name string, values []string) int64 {
+	var size int64
+	for _, value := range values {
+		size += int64(len(name) + len(": ") + len(value) + len("\r\n"))
+	}
+	return size
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestHeaderLimitMiddleware_Reject(t *testing.T) {
+	handler := WithRequestHeaderLimitMiddleware(RequestHeaderLimits{MaxSize: 100, MaxCount: 3}, testHeaderEchoHandler())
+
+	assert.Equal(t, http.StatusOK, testHeaderLimitRequest(handler, "X-One", "1", "X-Two", "2").Code)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, testHeaderLimitRequest(handler, "X-One", "1", "X-Two", "2", "X-Three", "3", "X-Four", "4").Code)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, testHeaderLimitRequest(handler, "Cookie", strings.Repeat("a", 100)).Code)
+}
+
+func TestRequestHeaderLimitMiddleware_TrimRemovesLargestHeaders(t *testing.T) {
+	handler := WithRequestHeaderLimitMiddleware(RequestHeaderLimits{MaxSize: 100, Action: HeaderLimitTrim}, testHeaderEchoHandler())
+
+	w := testHeaderLimitRequest(handler, "Cookie", strings.Repeat("a", 100), "X-Small", "1", "Content-Type", "text/plain")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Content-Type,X-Small", w.Body.String())
+}
+
+func TestRequestHeaderLimitMiddleware_TrimCount(t *testing.T) {
+	handler := WithRequestHeaderLimitMiddleware(RequestHeaderLimits{MaxCount: 2, Action: HeaderLimitTrim}, testHeaderEchoHandler())
+
+	w := testHeaderLimitRequest(handler, "X-A", "1", "X-Bb", "22", "X-Ccc", "333")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "X-A,X-Bb", w.Body.String())
+}
+
+func TestRequestHeaderLimitMiddleware_TrimKeepsProtectedHeaders(t *testing.T) {
+	handler := WithRequestHeaderLimitMiddleware(RequestHeaderLimits{MaxSize: 50, Action: HeaderLimitTrim}, testHeaderEchoHandler())
+
+	w := testHeaderLimitRequest(handler, "Authorization", "Bearer "+strings.Repeat("a", 100))
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, w.Code)
+}
+
+func TestRequestHeaderLimits_Validate(t *testing.T) {
+	assert.NoError(t, RequestHeaderLimits{Action: HeaderLimitTrim}.Validate())
+	assert.ErrorIs(t, RequestHeaderLimits{Action: "truncate"}.Validate(), ErrorUnknownHeaderLimitAction)
+}
+
+// Helpers
+
+func testHeaderEchoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := []string{}
+		for name := range r.Header {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		w.Write([]byte(strings.Join(names, ",")))
+	})
+}
+
+func testHeaderLimitRequest(handler http.Handler, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := 0; i < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
@@ -3,11 +3,13 @@ package server
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
 const (
 	requestStartHeader = "X-Request-Start"
+	queueTimeHeader    = "X-Queue-Time"
 )
 
 type RequestStartMiddleware struct {
@@ -26,3 +28,44 @@ func (h *RequestStartMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Reques
 	}
 	h.next.ServeHTTP(w, r)
 }
+
+// setRequestTimingHeaders replaces the X-Request-Start header of a request
+// that's being sent to a target with one in the t=<microseconds> format that
+// APMs such as New Relic and Scout expect, and adds X-Queue-Time with the
+// milliseconds that have passed since it started. The same values are
+// recorded in the access log.
+func setRequestTimingHeaders(in, out *http.Request) {
+	start, ok := parseRequestStart(in.Header.Get(requestStartHeader))
+	if !ok {
+		return
+	}
+	queueTime := max(time.Since(start), 0)
+
+	out.Header.Set(requestStartHeader, "t="+strconv.FormatInt(start.UnixMicro(), 10))
+	out.Header.Set(queueTimeHeader, strconv.FormatInt(queueTime.Milliseconds(), 10))
+
+	LoggingRequestContext(in).RequestStart = start
+	LoggingRequestContext(in).QueueTime = queueTime
+}
+
+// parseRequestStart reads an X-Request-Start header, which is set by us in
+// milliseconds, but may have been set by a load balancer in front of us in
+// seconds, milliseconds, microseconds or nanoseconds, with or without a "t="
+// prefix. The unit is inferred from the size of the value.
+func parseRequestStart(value string) (time.Time, bool) {
+	timestamp, err := strconv.ParseFloat(strings.TrimPrefix(value, "t="), 64)
+	if err != nil || timestamp <= 0 {
+		return time.Time{}, false
+	}
+
+	switch {
+	case timestamp > 1e17:
+		return time.Unix(0, int64(timestamp)), true
+	case timestamp > 1e14:
+		return time.UnixMicro(int64(timestamp)), true
+	case timestamp > 1e11:
+		return time.UnixMicro(int64(timestamp * 1e3)), true
+	default:
+		return time.UnixMicro(int64(timestamp * 1e6)), true
+	}
+}
@@ -1,13 +1,17 @@
 package server
 
 import (
+	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRequestStartMiddleware_AddsUnixMilliWhenNotPresent(t *testing.T) {
@@ -38,3 +42,50 @@ func TestRequestStartMiddleware_PreservesExistingHeaderWhenPresent(t *testing.T)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func TestRequestStartMiddleware_TimingHeadersSentToTarget(t *testing.T) {
+	var requestStart, queueTime string
+	options := defaultTargetOptions
+	options.RequestTimingHeaders = true
+	target := testTargetWithOptions(t, options, func(w http.ResponseWriter, r *http.Request) {
+		requestStart = r.Header.Get("X-Request-Start")
+		queueTime = r.Header.Get("X-Queue-Time")
+	})
+
+	out := &strings.Builder{}
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+	handler := WithRequestStartMiddleware(WithLoggingMiddleware(logger, 80, 443, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testServeRequestWithTarget(t, target, w, r)
+	})))
+
+	started := time.Now().Add(-250 * time.Millisecond)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(requestStartHeader, strconv.FormatInt(started.UnixMilli(), 10))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "t="+strconv.FormatInt(started.UnixMilli()*1000, 10), requestStart)
+	queueMillis, err := strconv.Atoi(queueTime)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, queueMillis, 250)
+
+	var logline struct {
+		RequestStart int64 `json:"request_start"`
+		QueueTime    int64 `json:"queue_time"`
+	}
+	require.NoError(t, json.NewDecoder(strings.NewReader(out.String())).Decode(&logline))
+	assert.Equal(t, started.UnixMilli(), logline.RequestStart)
+	assert.GreaterOrEqual(t, logline.QueueTime, (250 * time.Millisecond).Nanoseconds())
+}
+
+func TestRequestStartMiddleware_ParsesUnitsOfExistingHeaders(t *testing.T) {
+	expected := time.UnixMilli(1700000000123)
+
+	for _, value := range []string{"1700000000123", "t=1700000000123", "t=1700000000.123", "t=1700000000123000", "1700000000123000000"} {
+		start, ok := parseRequestStart(value)
+		require.True(t, ok, value)
+		assert.WithinDuration(t, expected, start, time.Millisecond, value)
+	}
+
+	_, ok := parseRequestStart("soon")
+	assert.False(t, ok)
+}
@@ -2,33 +2,85 @@ package server
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
 	"log/slog"
+	"mime"
 	"net"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 )
 
+var DefaultETagContentTypes = []string{"text/html", "application/json"}
+
+// ConditionalResponseConfig chooses which requests for buffered responses
+// are answered by the proxy, from the buffer, rather than with the whole
+// response. See WithConditionalResponseBufferMiddleware.
+type ConditionalResponseConfig struct {
+	ETagContentTypes []string
+	Ranges           bool
+}
+
+func (c ConditionalResponseConfig) Enabled() bool {
+	return len(c.ETagContentTypes) > 0 || c.Ranges
+}
+
 type ResponseBufferMiddleware struct {
 	maxMemBytes int64
 	maxBytes    int64
 	next        http.Handler
+
+	conditional ConditionalResponseConfig
 }
 
 func WithResponseBufferMiddleware(maxMemBytes, maxBytes int64, next http.Handler) http.Handler {
+	return WithConditionalResponseBufferMiddleware(maxMemBytes, maxBytes, ConditionalResponseConfig{}, next)
+}
+
+// WithConditionalResponseBufferMiddleware buffers responses, and since it
+// has the whole of each one before it's sent, can also answer conditional
+// and range requests for them.
+//
+// Successful responses to GET requests, of one of the ETagContentTypes, are
+// given a strong ETag when the target didn't set one. Requests whose
+// If-None-Match or If-Modified-Since matches the response's validators,
+// whether from the target or generated, get a 304 instead.
+//
+// With Ranges, Range requests that the target answered in full are given
+// just the parts they asked for, and the rest of the body is discarded.
+func WithConditionalResponseBufferMiddleware(maxMemBytes, maxBytes int64, conditional ConditionalResponseConfig, next http.Handler) http.Handler {
 	return &ResponseBufferMiddleware{
 		maxMemBytes: maxMemBytes,
 		maxBytes:    maxBytes,
 		next:        next,
+		conditional: conditional,
 	}
 }
 
 func (h *ResponseBufferMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	responseBuffer := NewBufferedWriteCloser(h.maxBytes, h.maxMemBytes)
 	responseWriter := &bufferedResponseWriter{ResponseWriter: w, statusCode: http.StatusOK, buffer: responseBuffer}
+	defer responseBuffer.Release()
 	defer responseBuffer.Close()
 
+	if h.conditional.Enabled() {
+		responseWriter.request = r
+		responseWriter.conditional = h.conditional
+	}
+
 	h.next.ServeHTTP(responseWriter, r)
 
+	if responseWriter.answerConditionalRequest() {
+		return
+	}
+	if responseWriter.answerRangeRequest() {
+		return
+	}
+
 	err := responseWriter.Send()
 	if err != nil {
 		if err == ErrMaximumSizeExceeded {
@@ -49,6 +101,10 @@ type bufferedResponseWriter struct {
 	hijacked      bool
 	headerWritten bool
 	bypass        bool
+
+	request     *http.Request
+	conditional ConditionalResponseConfig
+	etagHash    hash.Hash
 }
 
 func (w *bufferedResponseWriter) Send() error {
@@ -72,12 +128,21 @@ func (w *bufferedResponseWriter) Header() http.Header {
 }
 
 func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if isInformationalStatus(statusCode) {
+		// Informational responses aren't part of the response we're buffering,
+		// so they can be passed along straight away.
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
 	if !w.headerWritten {
 		w.statusCode = statusCode
 		w.headerWritten = true
 
 		if w.ShouldSwitchToUnbuffered() {
 			w.SwitchToUnbuffered()
+		} else if w.shouldGenerateETag() {
+			w.etagHash = sha256.New()
 		}
 	}
 }
@@ -97,6 +162,13 @@ func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
 		return w.ResponseWriter.Write(data)
 	}
 
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.etagHash != nil {
+		w.etagHash.Write(data)
+	}
+
 	n, err := w.buffer.Write(data)
 	if err == ErrMaximumSizeExceeded {
 		// Returning an error here will cause the ReverseProxy to panic. If the
@@ -125,3 +197,115 @@ func (w *bufferedResponseWriter) Flush() {
 		}
 	}
 }
+
+// Private
+
+func (w *bufferedResponseWriter) shouldGenerateETag() bool {
+	if len(w.conditional.ETagContentTypes) == 0 || w.request.Method != http.MethodGet || w.statusCode != http.StatusOK {
+		return false
+	}
+	if w.Header().Get("ETag") != "" {
+		return false
+	}
+
+	contentType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	return slices.Contains(w.conditional.ETagContentTypes, contentType)
+}
+
+// answerConditionalRequest adds any generated ETag to the response, and then
+// replaces the response with a 304 if the request's validators match it.
+func (w *bufferedResponseWriter) answerConditionalRequest() bool {
+	if len(w.conditional.ETagContentTypes) == 0 || w.bypass || w.hijacked || w.buffer.Overflowed() {
+		return false
+	}
+
+	if w.etagHash != nil {
+		w.Header().Set("ETag", `"`+hex.EncodeToString(w.etagHash.Sum(nil)[:16])+`"`)
+	}
+
+	if !w.notModified() {
+		return false
+	}
+
+	header := w.Header()
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+		header.Del(name)
+	}
+	w.ResponseWriter.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// answerRangeRequest sends only the requested parts of a full response to a
+// Range request. http.ServeContent takes care of If-Range, multiple ranges,
+// and ranges that can't be satisfied.
+func (w *bufferedResponseWriter) answerRangeRequest() bool {
+	if !w.rangeable() {
+		return false
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	if w.request.Header.Get("Range") == "" {
+		return false
+	}
+
+	// The Content-Length is set for whatever ServeContent sends.
+	w.Header().Del("Content-Length")
+	http.ServeContent(w.ResponseWriter, w.request, "", time.Time{}, io.NewSectionReader(w.buffer, 0, w.buffer.Size()))
+	return true
+}
+
+func (w *bufferedResponseWriter) rangeable() bool {
+	if !w.conditional.Ranges || w.bypass || w.hijacked || w.buffer.Overflowed() {
+		return false
+	}
+	if w.request.Method != http.MethodGet || w.statusCode != http.StatusOK {
+		return false
+	}
+
+	// Ranges of an encoded body aren't what most clients expect, and its
+	// length couldn't be given for them.
+	return w.Header().Get("Content-Encoding") == ""
+}
+
+func (w *bufferedResponseWriter) notModified() bool {
+	r := w.request
+	if w.statusCode != http.StatusOK || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := w.Header().Get("ETag")
+		return etag != "" && etagMatches(ifNoneMatch, etag)
+	}
+
+	lastModified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.Truncate(time.Second).After(ifModifiedSince)
+}
+
+// etagMatches compares an If-None-Match header with an ETag, using the weak
+// comparison that RFC 9110 calls for with If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// readFrom copies src to w, using w's own ReadFrom when it has one. Response
+// writers that pass ReadFrom on to the one they wrap let the server send
+// files with sendfile, so they should use this rather than io.Copy, which
+// would hide the file from it.
+func readFrom(w io.Writer, src io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(struct{ io.Writer }{w}, src)
+}
@@ -1,12 +1,17 @@
 package server
 
 import (
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestResponseBufferMiddleware(t *testing.T) {
@@ -36,6 +41,28 @@ func TestResponseBufferMiddleware(t *testing.T) {
 	})
 }
 
+func TestResponseBufferMiddleware_SendsSpilledResponses(t *testing.T) {
+	body := strings.Repeat("0123456789", 100_000)
+
+	var handler http.Handler = WithResponseBufferMiddleware(1024, 0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write([]byte(body))
+	}))
+	handler = WithLoggingMiddleware(slog.New(slog.NewTextHandler(io.Discard, nil)), 0, 0, WithMetricsMiddleware(handler))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	received, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, body, string(received))
+}
+
 func TestResponseBufferMiddleware_BufferedResponsesIgnoreFlushes(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/somepath", nil)
 	rec := httptest.NewRecorder()
@@ -81,3 +108,214 @@ func TestResponseBufferMiddleware_SSEResponsesBypassBufferAndAreFlushable(t *tes
 	checkContentType("text/event-stream; charset=utf-8", true)
 	checkContentType("text/plain", false)
 }
+
+func TestResponseBufferMiddleware_PreservesTrailers(t *testing.T) {
+	check := func(contentType string, http2 bool) {
+		target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+
+			w.Write([]byte("body"))
+
+			w.Header().Set("Grpc-Status", "0")
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", "done")
+		})
+		target.proxyHandler = WithResponseBufferMiddleware(1024, 1024, target.createProxyHandler())
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			testServeRequestWithTarget(t, target, w, r)
+		}))
+		if http2 {
+			server.EnableHTTP2 = true
+			server.StartTLS()
+		} else {
+			server.Start()
+		}
+		defer server.Close()
+
+		resp, err := server.Client().Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http2, resp.ProtoMajor == 2)
+		assert.Empty(t, resp.Header.Get("Grpc-Status"))
+		assert.Contains(t, resp.Trailer, "Grpc-Status")
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "body", string(body))
+
+		assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+		assert.Equal(t, "done", resp.Trailer.Get("Grpc-Message"))
+	}
+
+	check("application/grpc-web", false)
+	check("application/grpc-web", true)
+	check("text/event-stream", false)
+	check("text/event-stream", true)
+}
+
+func TestResponseBufferMiddleware_GeneratesETags(t *testing.T) {
+	middleware := WithConditionalResponseBufferMiddleware(1024, 1024, ConditionalResponseConfig{ETagContentTypes: DefaultETagContentTypes}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.Header().Set("Content-Length", "2")
+		w.Write([]byte("ok"))
+	}))
+
+	send := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("/?type=application/json", "")
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	w = send("/?type=application/json", `"other", `+etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Empty(t, w.Header().Get("Content-Length"))
+
+	w = send("/?type=application/json", `"other"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send("/?type=image/png", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+}
+
+func TestResponseBufferMiddleware_HonoursTargetValidators(t *testing.T) {
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	middleware := WithConditionalResponseBufferMiddleware(1024, 1024, ConditionalResponseConfig{ETagContentTypes: DefaultETagContentTypes}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/etag" {
+			w.Header().Set("ETag", `W/"v1"`)
+		} else {
+			w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		}
+		w.Write([]byte("page"))
+	}))
+
+	send := func(path, header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNotModified, send("/etag", "If-None-Match", `"v1"`))
+	assert.Equal(t, http.StatusOK, send("/etag", "If-None-Match", `"v2"`))
+	assert.Equal(t, http.StatusNotModified, send("/modified", "If-Modified-Since", lastModified.Format(http.TimeFormat)))
+	assert.Equal(t, http.StatusOK, send("/modified", "If-Modified-Since", lastModified.Add(-time.Hour).Format(http.TimeFormat)))
+}
+
+func TestResponseBufferMiddleware_ConditionalRequestsNeedEnabling(t *testing.T) {
+	middleware := WithResponseBufferMiddleware(1024, 1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("page"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	w := httptest.NewRecorder()
+	middleware.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "page", w.Body.String())
+}
+
+func TestResponseBufferMiddleware_ServesRanges(t *testing.T) {
+	body := strings.Repeat("0123456789", 200)
+	for _, maxMemBytes := range []int64{4096, 100} {
+		middleware := WithConditionalResponseBufferMiddleware(maxMemBytes, 0, ConditionalResponseConfig{Ranges: true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(body))
+		}))
+
+		send := func(headers map[string]string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/video.mp4", nil)
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			middleware.ServeHTTP(w, req)
+			return w
+		}
+
+		w := send(map[string]string{"Range": "bytes=95-104"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "5678901234", w.Body.String())
+		assert.Equal(t, "10", w.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes 95-104/2000", w.Header().Get("Content-Range"))
+		assert.Equal(t, "video/mp4", w.Header().Get("Content-Type"))
+
+		w = send(map[string]string{"Range": "bytes=1990-"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "0123456789", w.Body.String())
+
+		w = send(map[string]string{"Range": "bytes=5-9", "If-Range": `"v2"`})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, body, w.Body.String())
+
+		w = send(map[string]string{"Range": "bytes=5000-"})
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+
+		w = send(nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, body, w.Body.String())
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	}
+}
+
+func TestResponseBufferMiddleware_PassesTargetRangeResponses(t *testing.T) {
+	middleware := WithConditionalResponseBufferMiddleware(1024, 1024, ConditionalResponseConfig{Ranges: true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-1/10")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("01"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=0-1")
+	w := httptest.NewRecorder()
+	middleware.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "01", w.Body.String())
+	assert.Equal(t, "bytes 0-1/10", w.Header().Get("Content-Range"))
+}
+
+func BenchmarkResponseBufferMiddleware(b *testing.B) {
+	body := []byte(strings.Repeat("a", 64*1024))
+	middleware := WithResponseBufferMiddleware(DefaultMaxMemoryBufferSize, DefaultMaxResponseBodySize, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com/somepath", nil)
+		middleware.ServeHTTP(&testDiscardResponseWriter{header: http.Header{}}, req)
+	}
+}
+
+// Helpers
+
+type testDiscardResponseWriter struct {
+	header http.Header
+}
+
+func (w *testDiscardResponseWriter) Header() http.Header         { return w.header }
+func (w *testDiscardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *testDiscardResponseWriter) WriteHeader(int)             {}
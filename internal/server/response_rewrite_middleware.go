@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// ResponseRewriteMiddleware applies rewrite rules to responses from the
+// target. Bodies are rewritten only when their content type is one of the
+// given types, and they fit within maxBytes; larger bodies are passed on
+// unchanged.
+type ResponseRewriteMiddleware struct {
+	rules        ResponseRewriteRules
+	contentTypes []string
+	maxBytes     int64
+	next         http.Handler
+}
+
+func WithResponseRewriteMiddleware(rules ResponseRewriteRules, contentTypes []string, maxBytes int64, next http.Handler) http.Handler {
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultResponseRewriteContentTypes
+	}
+
+	return &ResponseRewriteMiddleware{
+		rules:        rules,
+		contentTypes: contentTypes,
+		maxBytes:     maxBytes,
+		next:         next,
+	}
+}
+
+func (h *ResponseRewriteMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.rules.HasBodyRules() {
+		// Compressed bodies can't be rewritten, so ask the target not to
+		// compress them.
+		r.Header.Del("Accept-Encoding")
+	}
+
+	rw := &rewriteResponseWriter{ResponseWriter: w, middleware: h, request: r}
+	h.next.ServeHTTP(rw, r)
+	rw.finish()
+}
+
+type rewriteResponseWriter struct {
+	http.ResponseWriter
+	middleware *ResponseRewriteMiddleware
+	request    *http.Request
+
+	headerWritten bool
+	buffering     bool
+	statusCode    int
+	contentLength string
+	body          bytes.Buffer
+}
+
+func (w *rewriteResponseWriter) WriteHeader(statusCode int) {
+	if isInformationalStatus(statusCode) || statusCode == http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+
+	if location := w.Header().Get("Location"); location != "" {
+		w.Header().Set("Location", w.middleware.rules.RewriteLocation(location))
+	}
+
+	if w.shouldRewriteBody(statusCode) {
+		w.buffering = true
+		w.statusCode = statusCode
+		w.contentLength = w.Header().Get("Content-Length")
+		w.Header().Del("Content-Length")
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *rewriteResponseWriter) Write(data []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.buffering {
+		return w.ResponseWriter.Write(data)
+	}
+
+	if int64(w.body.Len()+len(data)) > w.middleware.maxBytes {
+		slog.Debug("Response too large to rewrite", "path", w.request.URL.Path)
+		err := w.stopBuffering()
+		if err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(data)
+	}
+
+	return w.body.Write(data)
+}
+
+func (w *rewriteResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
+
+func (w *rewriteResponseWriter) Flush() {
+	if w.buffering {
+		return
+	}
+
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+// Private
+
+func (w *rewriteResponseWriter) shouldRewriteBody(statusCode int) bool {
+	if !w.middleware.rules.HasBodyRules() || w.request.Method == http.MethodHead {
+		return false
+	}
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		return false
+	}
+	if w.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	return slices.Contains(w.middleware.contentTypes, contentType)
+}
+
+// stopBuffering sends what has been buffered so far unchanged, so that the
+// rest of the body can be passed straight through.
+func (w *rewriteResponseWriter) stopBuffering() error {
+	w.buffering = false
+	if w.contentLength != "" {
+		w.Header().Set("Content-Length", w.contentLength)
+	}
+	w.ResponseWriter.WriteHeader(w.statusCode)
+
+	_, err := w.ResponseWriter.Write(w.body.Bytes())
+	w.body = bytes.Buffer{}
+	return err
+}
+
+func (w *rewriteResponseWriter) finish() {
+	if !w.buffering {
+		return
+	}
+
+	body := w.middleware.rules.RewriteBody(w.body.Bytes())
+	if !bytes.Equal(body, w.body.Bytes()) {
+		// The target's validator describes the body before it was changed.
+		w.Header().Del("ETag")
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	w.ResponseWriter.Write(body)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseRewriteMiddleware_RewritesHTMLBodies(t *testing.T) {
+	var acceptEncoding string
+	handler := testResponseRewriteHandler(t, 1024, func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", "38")
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte(`<a href="http://web-1:3000/">home</a>`))
+		w.Write([]byte("\n"))
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	body := "<a href=\"https://app.example.com/\">home</a>\n"
+	assert.Empty(t, acceptEncoding)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, body, w.Body.String())
+	assert.Equal(t, strconv.Itoa(len(body)), w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Header().Get("ETag"))
+}
+
+func TestResponseRewriteMiddleware_LeavesOtherContentUnchanged(t *testing.T) {
+	send := func(contentType, contentEncoding string) string {
+		handler := testResponseRewriteHandler(t, 1024, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			if contentEncoding != "" {
+				w.Header().Set("Content-Encoding", contentEncoding)
+			}
+			w.Write([]byte("http://web-1:3000/"))
+		})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "https://app.example.com/", send("text/html", ""))
+	assert.Equal(t, "http://web-1:3000/", send("application/json", ""))
+	assert.Equal(t, "http://web-1:3000/", send("text/html", "br"))
+}
+
+func TestResponseRewriteMiddleware_PassesLargeBodiesThroughUnchanged(t *testing.T) {
+	original := strings.Repeat("http://web-1:3000/ ", 10)
+	handler := testResponseRewriteHandler(t, 50, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusCreated)
+		for i := range 10 {
+			w.Write([]byte(original[i*19 : (i+1)*19]))
+		}
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, original, w.Body.String())
+}
+
+func TestResponseRewriteMiddleware_RewritesRedirects(t *testing.T) {
+	handler := testResponseRewriteHandler(t, 1024, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://web-1:3000/login", http.StatusFound)
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://app.example.com/login", w.Header().Get("Location"))
+}
+
+func TestTarget_RewritesResponses(t *testing.T) {
+	options := defaultTargetOptions
+	options.MaxMemoryBufferSize = DefaultMaxMemoryBufferSize
+	options.ResponseRewrites = []string{"body:Hello=Goodbye"}
+
+	target := testTargetWithOptions(t, options, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>Hello</p>"))
+	})
+
+	w := httptest.NewRecorder()
+	testServeRequestWithTarget(t, target, w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, "<p>Goodbye</p>", w.Body.String())
+}
+
+// Helpers
+
+func testResponseRewriteHandler(t *testing.T, maxBytes int64, next http.HandlerFunc) http.Handler {
+	rules, err := ParseResponseRewriteRules([]string{
+		"body:http://web-1:3000=https://app.example.com",
+		"location:http://web-1:3000/=https://app.example.com/",
+	})
+	require.NoError(t, err)
+
+	return WithResponseRewriteMiddleware(rules, nil, maxBytes, next)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrorInvalidResponseRewriteRule = errors.New("invalid response rewrite rule")
+
+var DefaultResponseRewriteContentTypes = []string{"text/html"}
+
+// ResponseRewriteRule changes part of a response from the target. Rules are
+// written as `<kind>:<old>=<new>`, where the kind is one of:
+//
+//	body           replace each occurrence of the string in the body
+//	body-regexp    replace each match of the regular expression in the body,
+//	               where the replacement may refer to groups as $1
+//	location       replace the start of a redirect's Location header
+//
+// The old value is everything before the first `=`, so a regular expression
+// that needs one should write it as `\x3d`.
+type ResponseRewriteRule struct {
+	Kind        string
+	Old         string
+	New         string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+type ResponseRewriteRules []ResponseRewriteRule
+
+const (
+	ResponseRewriteBody       = "body"
+	ResponseRewriteBodyRegexp = "body-regexp"
+	ResponseRewriteLocation   = "location"
+)
+
+func ParseResponseRewriteRules(rules []string) (ResponseRewriteRules, error) {
+	result := ResponseRewriteRules{}
+	for _, rule := range rules {
+		parsed, err := ParseResponseRewriteRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, parsed)
+	}
+	return result, nil
+}
+
+func ParseResponseRewriteRule(rule string) (ResponseRewriteRule, error) {
+	kind, change, ok := strings.Cut(rule, ":")
+	if !ok {
+		return ResponseRewriteRule{}, fmt.Errorf("%w: %s", ErrorInvalidResponseRewriteRule, rule)
+	}
+
+	old, replacement, ok := strings.Cut(change, "=")
+	if !ok || old == "" {
+		return ResponseRewriteRule{}, fmt.Errorf("%w: %s", ErrorInvalidResponseRewriteRule, rule)
+	}
+
+	result := ResponseRewriteRule{Kind: kind, Old: old, New: replacement}
+
+	switch kind {
+	case ResponseRewriteBody:
+		result.Pattern = regexp.MustCompile(regexp.QuoteMeta(old))
+		result.Replacement = strings.ReplaceAll(replacement, "$", "$$")
+
+	case ResponseRewriteBodyRegexp:
+		pattern, err := regexp.Compile(old)
+		if err != nil {
+			return ResponseRewriteRule{}, fmt.Errorf("%w: %s: %w", ErrorInvalidResponseRewriteRule, rule, err)
+		}
+		result.Pattern = pattern
+		result.Replacement = replacement
+
+	case ResponseRewriteLocation:
+
+	default:
+		return ResponseRewriteRule{}, fmt.Errorf("%w: %s", ErrorInvalidResponseRewriteRule, rule)
+	}
+
+	return result, nil
+}
+
+func (r ResponseRewriteRules) HasBodyRules() bool {
+	for _, rule := range r {
+		if rule.Pattern != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r ResponseRewriteRules) RewriteBody(body []byte) []byte {
+	for _, rule := range r {
+		if rule.Pattern != nil {
+			body = rule.Pattern.ReplaceAll(body, []byte(rule.Replacement))
+		}
+	}
+	return body
+}
+
+func (r ResponseRewriteRules) RewriteLocation(location string) string {
+	for _, rule := range r {
+		if rule.Kind == ResponseRewriteLocation && strings.HasPrefix(location, rule.Old) {
+			return rule.New + strings.TrimPrefix(location, rule.Old)
+		}
+	}
+	return location
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseRewriteRule_Parse(t *testing.T) {
+	rule, err := ParseResponseRewriteRule("body:http://web-1:3000=https://app.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, ResponseRewriteBody, rule.Kind)
+	assert.Equal(t, "http://web-1:3000", rule.Old)
+	assert.Equal(t, "https://app.example.com", rule.New)
+
+	rule, err = ParseResponseRewriteRule("location:http://web-1:3000/=/")
+	require.NoError(t, err)
+	assert.Equal(t, ResponseRewriteLocation, rule.Kind)
+
+	_, err = ParseResponseRewriteRule("body-regexp:(unclosed=x")
+	assert.ErrorIs(t, err, ErrorInvalidResponseRewriteRule)
+
+	for _, invalid := range []string{"body", "body:nothing", "body:=new", "header:a=b"} {
+		_, err := ParseResponseRewriteRule(invalid)
+		assert.ErrorIs(t, err, ErrorInvalidResponseRewriteRule, invalid)
+	}
+}
+
+func TestResponseRewriteRules_RewriteBody(t *testing.T) {
+	rules, err := ParseResponseRewriteRules([]string{
+		"body:http://web-1:3000=https://app.example.com",
+		`body-regexp:data-price\x3d"(\d+)"=data-cost="$$$1"`,
+		"body:$price=$cost",
+	})
+	require.NoError(t, err)
+
+	body := rules.RewriteBody([]byte(`<a href="http://web-1:3000/a" data-price="5">$price</a>`))
+	assert.Equal(t, `<a href="https://app.example.com/a" data-cost="$5">$cost</a>`, string(body))
+}
+
+func TestResponseRewriteRules_RewriteLocation(t *testing.T) {
+	rules, err := ParseResponseRewriteRules([]string{"location:http://web-1:3000/=https://app.example.com/"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://app.example.com/login", rules.RewriteLocation("http://web-1:3000/login"))
+	assert.Equal(t, "/login", rules.RewriteLocation("/login"))
+	assert.False(t, rules.HasBodyRules())
+}
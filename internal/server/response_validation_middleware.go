@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+var ErrorResponseFailedValidation = errors.New("response failed validation")
+
+// ResponseValidationMiddleware checks responses from the target against a
+// set of rules, to catch misconfigured targets at the edge. Violations are
+// logged and counted, and depending on the action, the response is also
+// fixed where possible, or replaced with a 502.
+//
+// A body without a Content-Length can only be found to be too large once
+// part of it has been sent. When rejecting, the rest of the response is then
+// abandoned.
+type ResponseValidationMiddleware struct {
+	rules  ResponseValidationRules
+	action string
+	next   http.Handler
+}
+
+func WithResponseValidationMiddleware(rules ResponseValidationRules, action string, next http.Handler) http.Handler {
+	if action == "" {
+		action = ResponseValidationActionLog
+	}
+
+	return &ResponseValidationMiddleware{
+		rules:  rules,
+		action: action,
+		next:   next,
+	}
+}
+
+func (h *ResponseValidationMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vw := &validatingResponseWriter{ResponseWriter: w, middleware: h, request: r, maxSize: h.rules.MaxSize()}
+	h.next.ServeHTTP(vw, r)
+}
+
+type validatingResponseWriter struct {
+	http.ResponseWriter
+	middleware *ResponseValidationMiddleware
+	request    *http.Request
+	maxSize    int64
+
+	headerWritten bool
+	rejected      bool
+	oversized     bool
+	written       int64
+}
+
+func (w *validatingResponseWriter) WriteHeader(statusCode int) {
+	if isInformationalStatus(statusCode) || statusCode == http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+
+	violations := w.middleware.rules.Violations(statusCode, w.Header())
+	for _, violation := range violations {
+		w.report(violation)
+		if violation.Kind == ResponseValidationMaxSize {
+			w.oversized = true
+		}
+	}
+
+	if len(violations) > 0 && w.middleware.action == ResponseValidationActionReject {
+		w.reject()
+		return
+	}
+
+	if w.middleware.action == ResponseValidationActionFix {
+		for _, violation := range violations {
+			if violation.fix != nil {
+				violation.fix(w.Header())
+			}
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *validatingResponseWriter) Write(data []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.rejected {
+		return len(data), nil
+	}
+
+	w.written += int64(len(data))
+	if w.maxSize >= 0 && w.written > w.maxSize && !w.oversized {
+		w.oversized = true
+		w.report(responseViolation{Kind: ResponseValidationMaxSize, Message: "body is larger than the maximum size"})
+
+		if w.middleware.action == ResponseValidationActionReject {
+			return 0, ErrorResponseFailedValidation
+		}
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *validatingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
+
+func (w *validatingResponseWriter) Flush() {
+	if w.rejected {
+		return
+	}
+
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+// Private
+
+func (w *validatingResponseWriter) report(violation responseViolation) {
+	service := LoggingRequestContext(w.request).Service
+	invalidResponsesCounter.WithLabelValues(service, violation.Kind).Inc()
+	slog.Warn("Response from target failed validation", "service", service, "path", w.request.URL.Path, "rule", violation.Kind, "violation", violation.Message, "action", w.middleware.action)
+}
+
+// reject replaces the target's response with an error, dropping its headers
+// so that none of them, such as cookies, reach the client.
+func (w *validatingResponseWriter) reject() {
+	w.rejected = true
+	clear(w.Header())
+	SetErrorResponse(w.ResponseWriter, w.request, http.StatusBadGateway, nil)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseValidationMiddleware_LogsViolations(t *testing.T) {
+	handler := testResponseValidationHandler(t, ResponseValidationActionLog, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "png", w.Body.String())
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+}
+
+func TestResponseValidationMiddleware_FixesViolations(t *testing.T) {
+	handler := testResponseValidationHandler(t, ResponseValidationActionFix, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "png", w.Body.String())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+}
+
+func TestResponseValidationMiddleware_RejectsViolations(t *testing.T) {
+	handler := testResponseValidationHandler(t, ResponseValidationActionReject, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte("<p>hello</p>"))
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+	assert.NotContains(t, w.Body.String(), "hello")
+}
+
+func TestResponseValidationMiddleware_PassesValidResponses(t *testing.T) {
+	handler := testResponseValidationHandler(t, ResponseValidationActionReject, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", "public")
+		w.Write([]byte("<p>hello</p>"))
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<p>hello</p>", w.Body.String())
+}
+
+func TestResponseValidationMiddleware_RejectsOversizedStreamingBodies(t *testing.T) {
+	var writeErr error
+	handler := testResponseValidationHandler(t, ResponseValidationActionReject, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", "public")
+		w.Write([]byte(strings.Repeat("a", 10)))
+		_, writeErr = w.Write([]byte(strings.Repeat("a", 10)))
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.ErrorIs(t, writeErr, ErrorResponseFailedValidation)
+	assert.Equal(t, strings.Repeat("a", 10), w.Body.String())
+}
+
+// Helpers
+
+func testResponseValidationHandler(t *testing.T, action string, handler http.HandlerFunc) http.Handler {
+	rules, err := ParseResponseValidationRules([]string{"header:Cache-Control=no-store", "content-type:text/html", "max-size:15"})
+	require.NoError(t, err)
+
+	return WithResponseValidationMiddleware(rules, action, handler)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorInvalidResponseValidationRule   = errors.New("invalid response validation rule")
+	ErrorUnknownResponseValidationAction = errors.New("unknown response validation action")
+)
+
+const (
+	ResponseValidationHeader      = "header"
+	ResponseValidationMaxSize     = "max-size"
+	ResponseValidationContentType = "content-type"
+
+	ResponseValidationActionLog    = "log"
+	ResponseValidationActionFix    = "fix"
+	ResponseValidationActionReject = "reject"
+)
+
+// ResponseValidationRule is something that responses from the target are
+// expected to satisfy. Rules are written as `<kind>:<value>`, where the kind
+// is one of:
+//
+//	header         the response must include the header, written as
+//	               <name>[=<value>], where the value is what the header is
+//	               set to when fixing a response that lacks it
+//	max-size       the body must be no larger than this many bytes
+//	content-type   the response must have this content type, which may be a
+//	               wildcard such as text/*; when given more than once, any of
+//	               them is allowed
+type ResponseValidationRule struct {
+	Kind  string
+	Name  string
+	Value string
+	Size  int64
+}
+
+type ResponseValidationRules []ResponseValidationRule
+
+// responseViolation is a way in which a response fails a rule, with the
+// change to its headers that would satisfy the rule, if there is one.
+type responseViolation struct {
+	Kind    string
+	Message string
+	fix     func(http.Header)
+}
+
+func ValidateResponseValidationAction(action string) error {
+	switch action {
+	case "", ResponseValidationActionLog, ResponseValidationActionFix, ResponseValidationActionReject:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrorUnknownResponseValidationAction, action)
+	}
+}
+
+func ParseResponseValidationRules(rules []string) (ResponseValidationRules, error) {
+	result := ResponseValidationRules{}
+	for _, rule := range rules {
+		parsed, err := ParseResponseValidationRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, parsed)
+	}
+	return result, nil
+}
+
+func ParseResponseValidationRule(rule string) (ResponseValidationRule, error) {
+	kind, value, ok := strings.Cut(rule, ":")
+	if !ok || value == "" {
+		return ResponseValidationRule{}, fmt.Errorf("%w: %s", ErrorInvalidResponseValidationRule, rule)
+	}
+
+	result := ResponseValidationRule{Kind: kind}
+
+	switch kind {
+	case ResponseValidationHeader:
+		name, headerValue, _ := strings.Cut(value, "=")
+		if name == "" {
+			return ResponseValidationRule{}, fmt.Errorf("%w: %s", ErrorInvalidResponseValidationRule, rule)
+		}
+		result.Name = http.CanonicalHeaderKey(name)
+		result.Value = headerValue
+
+	case ResponseValidationMaxSize:
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 {
+			return ResponseValidationRule{}, fmt.Errorf("%w: %s", ErrorInvalidResponseValidationRule, rule)
+		}
+		result.Size = size
+
+	case ResponseValidationContentType:
+		if !strings.Contains(value, "/") {
+			return ResponseValidationRule{}, fmt.Errorf("%w: %s", ErrorInvalidResponseValidationRule, rule)
+		}
+		result.Name = strings.ToLower(value)
+
+	default:
+		return ResponseValidationRule{}, fmt.Errorf("%w: %s", ErrorInvalidResponseValidationRule, rule)
+	}
+
+	return result, nil
+}
+
+// MaxSize returns the smallest of the max-size rules, or -1 if there are
+// none.
+func (r ResponseValidationRules) MaxSize() int64 {
+	maxSize := int64(-1)
+	for _, rule := range r {
+		if rule.Kind == ResponseValidationMaxSize && (maxSize < 0 || rule.Size < maxSize) {
+			maxSize = rule.Size
+		}
+	}
+	return maxSize
+}
+
+// Violations checks the status and headers of a response against the rules.
+// Sizes are checked against the Content-Length, when the target gives one.
+func (r ResponseValidationRules) Violations(statusCode int, header http.Header) []responseViolation {
+	var violations []responseViolation
+
+	for _, rule := range r {
+		if rule.Kind == ResponseValidationHeader && header.Get(rule.Name) == "" {
+			violation := responseViolation{Kind: rule.Kind, Message: "missing header " + rule.Name}
+			if rule.Value != "" {
+				violation.fix = rule.setHeader
+			}
+			violations = append(violations, violation)
+		}
+	}
+
+	maxSize := r.MaxSize()
+	if contentLength, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && maxSize >= 0 && contentLength > maxSize {
+		violations = append(violations, responseViolation{
+			Kind:    ResponseValidationMaxSize,
+			Message: fmt.Sprintf("body of %d bytes is larger than %d", contentLength, maxSize),
+		})
+	}
+
+	if responseHasBody(statusCode) && !r.allowsContentType(header.Get("Content-Type")) {
+		violations = append(violations, responseViolation{
+			Kind:    ResponseValidationContentType,
+			Message: fmt.Sprintf("content type %q is not allowed", header.Get("Content-Type")),
+			fix: func(header http.Header) {
+				// A body of the wrong type is safest treated as an opaque
+				// download, rather than anything a browser might render.
+				header.Set("Content-Type", "application/octet-stream")
+				header.Set("X-Content-Type-Options", "nosniff")
+			},
+		})
+	}
+
+	return violations
+}
+
+// Private
+
+func (rule ResponseValidationRule) setHeader(header http.Header) {
+	header.Set(rule.Name, rule.Value)
+}
+
+func (r ResponseValidationRules) allowsContentType(value string) bool {
+	contentType, _, _ := mime.ParseMediaType(value)
+
+	restricted := false
+	for _, rule := range r {
+		if rule.Kind != ResponseValidationContentType {
+			continue
+		}
+		restricted = true
+
+		prefix, wildcard := strings.CutSuffix(rule.Name, "*")
+		if contentType == rule.Name || (wildcard && contentType != "" && strings.HasPrefix(contentType, prefix)) {
+			return true
+		}
+	}
+	return !restricted
+}
+
+func responseHasBody(statusCode int) bool {
+	return statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseValidationRule_Parse(t *testing.T) {
+	rule, err := ParseResponseValidationRule("header:cache-control=no-store")
+	require.NoError(t, err)
+	assert.Equal(t, ResponseValidationHeader, rule.Kind)
+	assert.Equal(t, "Cache-Control", rule.Name)
+	assert.Equal(t, "no-store", rule.Value)
+
+	rule, err = ParseResponseValidationRule("max-size:1024")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), rule.Size)
+
+	rule, err = ParseResponseValidationRule("content-type:Text/*")
+	require.NoError(t, err)
+	assert.Equal(t, "text/*", rule.Name)
+
+	for _, invalid := range []string{"header", "header:", "header:=x", "max-size:big", "max-size:-1", "content-type:html", "status:200"} {
+		_, err := ParseResponseValidationRule(invalid)
+		assert.ErrorIs(t, err, ErrorInvalidResponseValidationRule, invalid)
+	}
+}
+
+func TestResponseValidationRules_Violations(t *testing.T) {
+	rules, err := ParseResponseValidationRules([]string{
+		"header:Cache-Control=no-store",
+		"header:X-Version",
+		"max-size:100",
+		"content-type:application/json",
+		"content-type:text/*",
+	})
+	require.NoError(t, err)
+
+	kinds := func(statusCode int, header http.Header) []string {
+		var result []string
+		for _, violation := range rules.Violations(statusCode, header) {
+			result = append(result, violation.Kind+" "+violation.Message)
+		}
+		return result
+	}
+
+	valid := http.Header{"Cache-Control": {"private"}, "X-Version": {"1"}, "Content-Type": {"text/html; charset=utf-8"}, "Content-Length": {"100"}}
+	assert.Empty(t, kinds(http.StatusOK, valid))
+
+	assert.Equal(t, []string{
+		"header missing header Cache-Control",
+		"header missing header X-Version",
+		"max-size body of 101 bytes is larger than 100",
+		`content-type content type "image/png" is not allowed`,
+	}, kinds(http.StatusOK, http.Header{"Content-Type": {"image/png"}, "Content-Length": {"101"}}))
+
+	assert.Equal(t, []string{
+		"header missing header Cache-Control",
+		"header missing header X-Version",
+	}, kinds(http.StatusNotModified, http.Header{}))
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReusePortListenersPerCPU opens a listener for each CPU.
+const ReusePortListenersPerCPU = -1
+
+// listenReusePort opens count sockets on the same address with SO_REUSEPORT,
+// so that the kernel spreads new connections between them, and each can be
+// given its own accept loop. That avoids all connections being accepted
+// through a single socket, which can limit throughput, and add to tail
+// latency, on hosts with very high connection rates.
+//
+// A count of zero or one opens a single socket, as usual. Any other socket
+// options are set on each of them before they're bound.
+func listenReusePort(family, addr string, count int, setOptions func(fd int) error) ([]net.Listener, error) {
+	if count == ReusePortListenersPerCPU {
+		count = runtime.NumCPU()
+	}
+	if count <= 1 {
+		l, err := listenWithSocketOptions(family, addr, setOptions)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+
+	setReusePort := func(fd int) error {
+		err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		if err == nil && setOptions != nil {
+			err = setOptions(fd)
+		}
+		return err
+	}
+
+	listeners := []net.Listener{}
+	for range count {
+		l, err := listenWithSocketOptions(family, addr, setReusePort)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+
+		// When the port is chosen by the kernel, the rest need to share the
+		// one that it chose for the first.
+		addr = l.Addr().String()
+	}
+
+	return listeners, nil
+}
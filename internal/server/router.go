@@ -2,16 +2,19 @@ package server
 
 import (
 	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/crypto/acme/autocert"
 )
 
 var (
@@ -20,6 +23,8 @@ var (
 	ErrorHostInUse                   = errors.New("host settings conflict with another service")
 	ErrorNoServerName                = errors.New("no server name provided")
 	ErrorUnknownServerName           = errors.New("unknown server name")
+	ErrorInvalidImport               = errors.New("invalid import")
+	ErrorDeployInProgress            = errors.New("deploy in progress")
 )
 
 type (
@@ -30,11 +35,7 @@ type (
 func (m ServiceMap) HostServices() HostServiceMap {
 	hostServices := HostServiceMap{}
 	for _, service := range m {
-		if len(service.hosts) == 0 {
-			hostServices[""] = service
-			continue
-		}
-		for _, host := range service.hosts {
+		for _, host := range routedHosts(service.hosts, service.options) {
 			hostServices[host] = service
 		}
 	}
@@ -73,31 +74,77 @@ func (m HostServiceMap) ServiceForHost(host string) *Service {
 }
 
 type Router struct {
-	statePath    string
-	services     ServiceMap
-	hostServices HostServiceMap
-	serviceLock  sync.RWMutex
+	statePath           string
+	services            ServiceMap
+	hostServices        HostServiceMap
+	discoveredEndpoints map[string][]string
+	pools               TargetPools
+	deployments         map[string]*deployment
+	deploymentCount     int
+	deployLocks         *DeployLocks
+	serviceLock         sync.RWMutex
 }
 
 type ServiceDescription struct {
-	Host   string `json:"host"`
-	TLS    bool   `json:"tls"`
-	Target string `json:"target"`
-	State  string `json:"state"`
+	Host        string            `json:"host"`
+	TLS         bool              `json:"tls"`
+	ACMEStaging bool              `json:"acme_staging,omitempty"`
+	Target      string            `json:"target"`
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	DeployLock  *DeployLockStatus `json:"deploy_lock,omitempty"`
+
+	// UpgradedConnections counts the connections to the target that have been
+	// upgraded, such as WebSockets, and are still open.
+	UpgradedConnections int64 `json:"upgraded_connections"`
+
+	// Pause describes the pause or stop of a service that isn't running.
+	Pause *PauseStatus `json:"pause,omitempty"`
 }
 
 type ServiceDescriptionMap map[string]ServiceDescription
 
+// deployment tracks the most recent target deployed to a service, so that its
+// progress can be followed, and its health checks inspected if it fails.
+type deployment struct {
+	id          int
+	target      *Target
+	diagnostics *DeployDiagnostics
+}
+
+type DeployProgress struct {
+	DeploymentID int                 `json:"deployment_id"`
+	Target       string              `json:"target"`
+	Results      []HealthCheckResult `json:"results"`
+	Next         int                 `json:"next"`
+}
+
+// DeployResult describes what a deploy changed.
+type DeployResult struct {
+	Unchanged bool           `json:"unchanged"`
+	Changes   []OptionChange `json:"changes,omitempty"`
+}
+
+type TargetHealthLog struct {
+	Role    string              `json:"role"`
+	Target  string              `json:"target"`
+	Results []HealthCheckResult `json:"results"`
+}
+
 func NewRouter(statePath string) *Router {
 	return &Router{
-		statePath:    statePath,
-		services:     ServiceMap{},
-		hostServices: HostServiceMap{},
+		statePath:           statePath,
+		services:            ServiceMap{},
+		hostServices:        HostServiceMap{},
+		discoveredEndpoints: map[string][]string{},
+		pools:               TargetPools{},
+		deployments:         map[string]*deployment{},
+		deployLocks:         NewDeployLocks(),
 	}
 }
 
 func (r *Router) RestoreLastSavedState() error {
-	f, err := os.Open(r.statePath)
+	data, err := os.ReadFile(r.statePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			slog.Info("No previous state to restore", "path", r.statePath)
@@ -106,10 +153,14 @@ func (r *Router) RestoreLastSavedState() error {
 		slog.Error("Failed to restore saved state", "path", r.statePath, "error", err)
 		return err
 	}
-	defer f.Close()
 
-	var services []*Service
-	err = json.NewDecoder(f).Decode(&services)
+	services, err := decodeState(data)
+	if err != nil {
+		slog.Error("Failed to decode saved state", "path", r.statePath, "error", err)
+		return err
+	}
+
+	pools, err := decodeStatePools(data)
 	if err != nil {
 		slog.Error("Failed to decode saved state", "path", r.statePath, "error", err)
 		return err
@@ -122,6 +173,11 @@ func (r *Router) RestoreLastSavedState() error {
 		}
 
 		r.hostServices = r.services.HostServices()
+
+		r.pools = pools
+		for name := range r.services {
+			r.applyEndpoints(name)
+		}
 		return nil
 	})
 
@@ -129,6 +185,82 @@ func (r *Router) RestoreLastSavedState() error {
 	return nil
 }
 
+// ExportState returns a snapshot of all services and their options, in the
+// same form as the state file.
+func (r *Router) ExportState() ([]byte, error) {
+	return encodeStateWithPools(r.allServices(), r.Pools())
+}
+
+// ImportState deploys the services from a snapshot made by ExportState. The
+// snapshot is checked in full before any of its services are deployed, and
+// each target must pass its health checks before it receives traffic.
+func (r *Router) ImportState(data []byte, deployTimeout time.Duration, drainTimeout time.Duration) error {
+	services, err := decodeStateDescriptions(data)
+	if err != nil {
+		return err
+	}
+
+	err = r.validateImport(services)
+	if err != nil {
+		return err
+	}
+
+	pools, err := decodeStatePools(data)
+	if err != nil {
+		return err
+	}
+	for name, addresses := range pools {
+		err = r.SetPool(name, addresses)
+		if err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for _, ms := range services {
+		err := r.importService(ms, deployTimeout, drainTimeout)
+		if err != nil {
+			slog.Error("Unable to import service", "service", ms.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", ms.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// RestoreState returns the services to the snapshot in data: those in the
+// snapshot are deployed as with ImportState, and once they're all healthy,
+// any services that aren't in it are removed.
+func (r *Router) RestoreState(data []byte, deployTimeout time.Duration, drainTimeout time.Duration) error {
+	services, err := decodeStateDescriptions(data)
+	if err != nil {
+		return err
+	}
+
+	err = r.ImportState(data, deployTimeout, drainTimeout)
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{}
+	for _, ms := range services {
+		keep[ms.Name] = true
+	}
+
+	for _, service := range r.allServices() {
+		if !keep[service.name] {
+			slog.Info("Removing service that is not in restored state", "service", service.name)
+
+			err := r.RemoveService(service.name)
+			if err != nil && !errors.Is(err, ErrorServiceNotFound) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	service := r.serviceForRequest(req)
 	if service == nil {
@@ -143,22 +275,72 @@ func (r *Router) SetServiceTarget(name string, hosts []string, targetURL string,
 	options ServiceOptions, targetOptions TargetOptions,
 	deployTimeout time.Duration, drainTimeout time.Duration,
 ) error {
-	defer r.saveStateSnapshot()
-
-	slog.Info("Deploying", "service", name, "hosts", hosts, "target", targetURL, "tls", options.TLSEnabled)
+	_, err := r.DeployServiceTarget(name, hosts, targetURL, options, targetOptions, deployTimeout, drainTimeout, 0, true)
+	return err
+}
 
-	target, err := r.deployNewTargetWithOptions(targetURL, targetOptions, deployTimeout)
+// DeployServiceTarget deploys a target like SetServiceTarget, but if another
+// deploy of the service is in progress, waits up to queueTimeout for it to
+// finish first, rather than failing straight away. Unless forced, a deploy
+// that wouldn't change anything succeeds without deploying the target again,
+// and reports that it didn't, and one that only changes the service's hosts
+// or options updates them without deploying the target again. The options
+// that it changed are logged and reported.
+func (r *Router) DeployServiceTarget(name string, hosts []string, targetURL string,
+	options ServiceOptions, targetOptions TargetOptions,
+	deployTimeout time.Duration, drainTimeout time.Duration, queueTimeout time.Duration, force bool,
+) (DeployResult, error) {
+	unlock, err := r.deployLocks.Acquire(name, targetURL, queueTimeout)
 	if err != nil {
-		return err
+		return DeployResult{}, err
 	}
+	defer unlock()
 
-	err = r.setActiveTarget(name, hosts, target, options, drainTimeout)
+	if !force && r.serviceUnchanged(name, hosts, targetURL, options, targetOptions) {
+		slog.Info("Skipping deploy with no changes", "service", name, "target", targetURL)
+		return DeployResult{Unchanged: true}, nil
+	}
+
+	var changes []OptionChange
+	var service *Service
+	r.withReadLock(func() error {
+		service = r.services[name]
+		if service != nil {
+			changes = service.OptionChanges(hosts, options, targetOptions)
+		}
+		return nil
+	})
+
+	if !force && service != nil && service.TargetUnchanged(targetURL, targetOptions) {
+		err = r.updateServiceOptions(name, hosts, options)
+	} else {
+		err = r.deployServiceTarget(name, hosts, targetURL, options, targetOptions, deployTimeout, drainTimeout)
+	}
 	if err != nil {
-		return err
+		return DeployResult{}, err
 	}
 
-	slog.Info("Deployed", "service", name, "hosts", hosts, "target", targetURL)
-	return nil
+	if len(changes) > 0 {
+		slog.Info("Deploy changed options", "service", name, "changes", changes)
+	}
+	return DeployResult{Changes: changes}, nil
+}
+
+// DeployedOptions returns the target, hosts and options that a service is
+// deployed with.
+func (r *Router) DeployedOptions(name string) (DeployedOptions, error) {
+	var deployed DeployedOptions
+	err := r.withReadLock(func() error {
+		service := r.services[name]
+		if service == nil {
+			return ErrorServiceNotFound
+		}
+
+		deployed = service.DeployedOptions()
+		return nil
+	})
+
+	return deployed, err
 }
 
 func (r *Router) SetRolloutTarget(name string, targetURL string, deployTimeout time.Duration, drainTimeout time.Duration) error {
@@ -170,9 +352,16 @@ func (r *Router) SetRolloutTarget(name string, targetURL string, deployTimeout t
 	if service == nil {
 		return ErrorServiceNotFound
 	}
+
+	unlock, err := r.deployLocks.Acquire(name, targetURL, 0)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	targetOptions := service.ActiveTarget().options
+	targetOptions.ExpectedVersion = "" // That of the active target, not this one
 
-	target, err := r.deployNewTargetWithOptions(targetURL, targetOptions, deployTimeout)
+	target, err := r.deployNewTargetWithOptions(name, service.hosts, service.options.TLSEnabled, targetURL, targetOptions, deployTimeout)
 	if err != nil {
 		return err
 	}
@@ -205,17 +394,137 @@ func (r *Router) StopRollout(name string) error {
 	return service.StopRollout()
 }
 
+func (r *Router) SetTenantTarget(name string, tenant string, targetURL string, deployTimeout time.Duration, drainTimeout time.Duration) error {
+	defer r.saveStateSnapshot()
+
+	tenant = strings.ToLower(strings.TrimSpace(tenant))
+	if tenant == "" {
+		return ErrorInvalidTenant
+	}
+
+	service := r.serviceForName(name)
+	if service == nil {
+		return ErrorServiceNotFound
+	}
+	if !service.options.tenantRouting().Enabled() {
+		return ErrorTenantRoutingNotConfigured
+	}
+
+	slog.Info("Deploying for tenant", "service", name, "tenant", tenant, "target", targetURL)
+
+	unlock, err := r.deployLocks.Acquire(name, targetURL, 0)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	targetOptions := service.ActiveTarget().options
+	targetOptions.ExpectedVersion = "" // That of the active target, not this one
+
+	target, err := r.deployNewTargetWithOptions(name, service.hosts, service.options.TLSEnabled, targetURL, targetOptions, deployTimeout)
+	if err != nil {
+		return err
+	}
+
+	service.SetTenantTarget(tenant, target, drainTimeout)
+
+	slog.Info("Deployed for tenant", "service", name, "tenant", tenant, "target", targetURL)
+	return nil
+}
+
+func (r *Router) RemoveTenant(name string, tenant string, drainTimeout time.Duration) error {
+	defer r.saveStateSnapshot()
+
+	service := r.serviceForName(name)
+	if service == nil {
+		return ErrorServiceNotFound
+	}
+
+	return service.RemoveTenant(strings.ToLower(strings.TrimSpace(tenant)), drainTimeout)
+}
+
+func (r *Router) TenantTargets(name string) (map[string]string, error) {
+	service := r.serviceForName(name)
+	if service == nil {
+		return nil, ErrorServiceNotFound
+	}
+
+	return service.TenantTargets(), nil
+}
+
+// AddPoolTargets adds addresses to a pool, creating it if needed. Services
+// that use the pool start sending requests to each new address once it
+// passes a health check.
+func (r *Router) AddPoolTargets(pool string, addresses []string) error {
+	defer r.saveStateSnapshot()
+
+	return r.updatePool(pool, func(pools TargetPools) error {
+		return pools.Add(pool, addresses)
+	})
+}
+
+// RemovePoolTargets removes addresses from a pool, or the whole pool if no
+// addresses are given.
+func (r *Router) RemovePoolTargets(pool string, addresses []string) error {
+	defer r.saveStateSnapshot()
+
+	return r.updatePool(pool, func(pools TargetPools) error {
+		return pools.Remove(pool, addresses)
+	})
+}
+
+// SetPool replaces the addresses in a pool.
+func (r *Router) SetPool(pool string, addresses []string) error {
+	return r.updatePool(pool, func(pools TargetPools) error {
+		delete(pools, pool)
+		return pools.Add(pool, addresses)
+	})
+}
+
+func (r *Router) Pools() TargetPools {
+	r.serviceLock.RLock()
+	defer r.serviceLock.RUnlock()
+
+	result := TargetPools{}
+	for name, addresses := range r.pools {
+		result[name] = slices.Clone(addresses)
+	}
+	return result
+}
+
+func (r *Router) SetChaos(name string, percentage int, latency time.Duration, errorStatus int, duration time.Duration) error {
+	defer r.saveStateSnapshot()
+
+	service := r.serviceForName(name)
+	if service == nil {
+		return ErrorServiceNotFound
+	}
+
+	return service.SetChaos(percentage, latency, errorStatus, duration)
+}
+
+func (r *Router) StopChaos(name string) error {
+	defer r.saveStateSnapshot()
+
+	service := r.serviceForName(name)
+	if service == nil {
+		return ErrorServiceNotFound
+	}
+
+	return service.StopChaos()
+}
+
 func (r *Router) RemoveService(name string) error {
 	defer r.saveStateSnapshot()
 
+	var service *Service
 	err := r.withWriteLock(func() error {
-		service := r.services[name]
+		service = r.services[name]
 		if service == nil {
 			return ErrorServiceNotFound
 		}
 
-		service.SetTarget(TargetSlotActive, nil, DefaultDrainTimeout)
 		delete(r.services, service.name)
+		delete(r.deployments, service.name)
 		r.hostServices = r.services.HostServices()
 
 		return nil
@@ -224,10 +533,99 @@ func (r *Router) RemoveService(name string) error {
 		return err
 	}
 
+	// Draining can take a while, so it's done once the service is no longer
+	// routed to, without holding up the rest of the router.
+	retireTargets(service.detachTargets(), DefaultDrainTimeout)
+	service.closePlugins()
+
+	// The service's metrics are kept until its requests have drained, unless
+	// another service with the same name has been added in the meantime.
+	r.withReadLock(func() error {
+		if r.services[name] == nil {
+			sloMetrics.Track(name, nil)
+			deleteServiceMetrics(name)
+		}
+		return nil
+	})
+
 	return nil
 }
 
-func (r *Router) PauseService(name string, drainTimeout time.Duration, pauseTimeout time.Duration) error {
+// RemoveServiceAndCertificates removes a service, along with any
+// certificates that were obtained for its hosts, so that they don't stay in
+// the cache forever. Those for hosts that another service has taken over are
+// kept.
+func (r *Router) RemoveServiceAndCertificates(name string) error {
+	service := r.serviceForName(name)
+	if service == nil {
+		return ErrorServiceNotFound
+	}
+
+	err := r.RemoveService(name)
+	if err != nil {
+		return err
+	}
+
+	err = service.removeCertificates(r.hostInUse)
+	if err != nil {
+		return fmt.Errorf("service removed, but unable to remove its certificates: %w", err)
+	}
+	return nil
+}
+
+// DeleteCertificates removes the certificates obtained for a host, from the
+// ACME caches under cachePath, reporting whether there were any. Hosts that
+// are still served can't have their certificates deleted.
+func (r *Router) DeleteCertificates(host, cachePath string) (bool, error) {
+	if r.hostInUse(host) {
+		return false, fmt.Errorf("%w: %s", ErrorCertificateInUse, host)
+	}
+
+	return deleteAllCachedCertificates(cachePath, host)
+}
+
+// RemoveExpiredServices removes any services whose TTL has passed.
+func (r *Router) RemoveExpiredServices(now time.Time) {
+	expired := []*Service{}
+	r.withReadLock(func() error {
+		for _, service := range r.services {
+			if service.Expired(now) {
+				expired = append(expired, service)
+			}
+		}
+		return nil
+	})
+
+	for _, service := range expired {
+		slog.Info("Removing expired service", "service", service.name)
+
+		var err error
+		if service.options.TTLRemoveCertificates {
+			err = r.RemoveServiceAndCertificates(service.name)
+		} else {
+			err = r.RemoveService(service.name)
+		}
+		if err != nil {
+			slog.Error("Unable to remove expired service", "service", service.name, "error", err)
+		}
+	}
+}
+
+// failoverServices are the services that have a failover address.
+func (r *Router) failoverServices() []*Service {
+	services := []*Service{}
+	r.withReadLock(func() error {
+		for _, service := range r.services {
+			if service.options.FailoverAddress != "" {
+				services = append(services, service)
+			}
+		}
+		return nil
+	})
+	return services
+}
+
+func (r *Router) PauseService(name string, drainTimeout time.Duration, pauseTimeout time.Duration, exceptPaths []string, readOnly bool) error {
 	defer r.saveStateSnapshot()
 
 	service := r.serviceForName(name)
@@ -235,7 +633,7 @@ func (r *Router) PauseService(name string, drainTimeout time.Duration, pauseTime
 		return ErrorServiceNotFound
 	}
 
-	return service.Pause(drainTimeout, pauseTimeout)
+	return service.Pause(drainTimeout, pauseTimeout, exceptPaths, readOnly)
 }
 
 func (r *Router) StopService(name string, drainTimeout time.Duration, message string) error {
@@ -249,7 +647,7 @@ func (r *Router) StopService(name string, drainTimeout time.Duration, message st
 	return service.Stop(drainTimeout, message)
 }
 
-func (r *Router) ResumeService(name string) error {
+func (r *Router) ResumeService(name string, releaseConcurrency int) error {
 	defer r.saveStateSnapshot()
 
 	service := r.serviceForName(name)
@@ -257,7 +655,7 @@ func (r *Router) ResumeService(name string) error {
 		return ErrorServiceNotFound
 	}
 
-	return service.Resume()
+	return service.Resume(releaseConcurrency)
 }
 
 func (r *Router) ListActiveServices() ServiceDescriptionMap {
@@ -271,10 +669,16 @@ func (r *Router) ListActiveServices() ServiceDescriptionMap {
 			}
 			if service.active != nil {
 				result[name] = ServiceDescription{
-					Host:   host,
-					Target: service.active.Target(),
-					TLS:    service.options.TLSEnabled,
-					State:  service.pauseController.GetState().String(),
+					Host:        host,
+					Target:      service.active.Target(),
+					TLS:         service.options.TLSEnabled,
+					ACMEStaging: service.options.UsesACMEStaging(),
+					State:       service.pauseController.GetState().String(),
+					Labels:      service.active.Labels(),
+					DeployLock:  r.deployLocks.Status(name),
+
+					UpgradedConnections: service.active.upgradedCount(),
+					Pause:               service.pauseController.Status(),
 				}
 			}
 		}
@@ -284,6 +688,155 @@ func (r *Router) ListActiveServices() ServiceDescriptionMap {
 	return result
 }
 
+func (r *Router) HealthLog(name string) ([]TargetHealthLog, error) {
+	result := []TargetHealthLog{}
+
+	err := r.withReadLock(func() error {
+		service := r.services[name]
+		deployment := r.deployments[name]
+		if service == nil && deployment == nil {
+			return ErrorServiceNotFound
+		}
+
+		seen := map[*Target]bool{}
+		add := func(role string, target *Target) {
+			if target != nil && !seen[target] {
+				seen[target] = true
+				result = append(result, TargetHealthLog{Role: role, Target: target.Target(), Results: target.HealthCheckHistory()})
+			}
+		}
+
+		if service != nil {
+			add("active", service.ActiveTarget())
+			add("rollout", service.RolloutTarget())
+		}
+		if deployment != nil {
+			add("deploy", deployment.target)
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// DeployProgress reports the health checks of the most recent deployment to a
+// service, starting from the result numbered since. Deployments with an ID no
+// greater than after are ignored, which allows callers to wait for a
+// deployment that they are about to start.
+func (r *Router) DeployProgress(name string, after int, since int) DeployProgress {
+	var progress DeployProgress
+
+	r.withReadLock(func() error {
+		progress.DeploymentID = r.deploymentCount
+
+		deployment := r.deployments[name]
+		if deployment == nil || deployment.id <= after {
+			return nil
+		}
+
+		progress.DeploymentID = deployment.id
+		progress.Target = deployment.target.Target()
+		progress.Results, progress.Next = deployment.target.healthCheckHistory.ResultsSince(since)
+		return nil
+	})
+
+	return progress
+}
+
+// DeployDiagnostics returns the diagnostics gathered when the most recent
+// deployment to a service failed, if that deployment has an ID greater than
+// after. It returns nil if there's no such deployment, or it didn't fail.
+func (r *Router) DeployDiagnostics(name string, after int, healthChecks int) *DeployDiagnostics {
+	var diagnostics *DeployDiagnostics
+
+	r.withReadLock(func() error {
+		deployment := r.deployments[name]
+		if deployment != nil && deployment.id > after && deployment.diagnostics != nil {
+			d := deployment.diagnostics.WithLastHealthChecks(healthChecks)
+			diagnostics = &d
+		}
+		return nil
+	})
+
+	return diagnostics
+}
+
+func (r *Router) ServiceStats(name string) (map[string]ServiceStatsSnapshot, error) {
+	result := map[string]ServiceStatsSnapshot{}
+
+	err := r.withReadLock(func() error {
+		if name != "" {
+			service := r.services[name]
+			if service == nil {
+				return ErrorServiceNotFound
+			}
+			result[name] = service.Stats()
+			return nil
+		}
+
+		for name, service := range r.services {
+			result[name] = service.Stats()
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// SetDiscoveredEndpoints updates the addresses that each service's requests
+// are balanced across, as reported by a provider such as Docker. Services
+// that are not listed go back to using their deployed target directly.
+func (r *Router) SetDiscoveredEndpoints(endpoints map[string][]string) {
+	r.withWriteLock(func() error {
+		for name := range r.discoveredEndpoints {
+			if _, ok := endpoints[name]; !ok {
+				r.applyDiscoveredEndpoints(name, nil)
+			}
+		}
+		for name, addresses := range endpoints {
+			r.applyDiscoveredEndpoints(name, addresses)
+		}
+
+		r.discoveredEndpoints = endpoints
+		return nil
+	})
+}
+
+// LenientHTTPHost reports whether the service for a host allows requests that
+// fail the strict HTTP checks.
+func (r *Router) LenientHTTPHost(host string) bool {
+	service := r.serviceForHost(host)
+	return service != nil && service.options.LenientHTTP
+}
+
+// HTTP2DisabledHost reports whether the service for a host doesn't allow its
+// clients to use HTTP/2.
+func (r *Router) HTTP2DisabledHost(host string) bool {
+	service := r.serviceForHost(host)
+	return service != nil && service.options.DisableHTTP2
+}
+
+// HostLabel names a host for metrics: as itself if it's one of a service's
+// hosts, or as the wildcard host that it matches. Any other host, which
+// could be anything a client sends, is named "other".
+func (r *Router) HostLabel(host string) string {
+	r.serviceLock.RLock()
+	defer r.serviceLock.RUnlock()
+
+	if _, ok := r.hostServices[host]; ok && host != "" {
+		return host
+	}
+
+	sep := strings.Index(host, ".")
+	if sep > 0 {
+		if _, ok := r.hostServices["*"+host[sep:]]; ok {
+			return "*" + host[sep:]
+		}
+	}
+
+	return "other"
+}
+
 func (r *Router) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	host := hello.ServerName
 	if host == "" {
@@ -302,43 +855,247 @@ func (r *Router) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, e
 		return nil, ErrorUnknownServerName
 	}
 
-	return service.certManager.GetCertificate(hello)
+	cert, err := service.certManager.GetCertificate(hello)
+	if err != nil {
+		if _, automatic := service.certManager.(*autocert.Manager); automatic {
+			reportError("tls:"+host, ErrorReport{
+				Kind:    "tls",
+				Message: fmt.Sprintf("Unable to obtain certificate for %s: %s", host, err),
+				Tags:    map[string]string{"service": service.name, "host": host},
+			})
+		}
+	}
+	return cert, err
 }
 
 // Private
 
-func (r *Router) deployNewTargetWithOptions(targetURL string, targetOptions TargetOptions, deployTimeout time.Duration) (*Target, error) {
+func (r *Router) deployServiceTarget(name string, hosts []string, targetURL string,
+	options ServiceOptions, targetOptions TargetOptions,
+	deployTimeout time.Duration, drainTimeout time.Duration,
+) error {
+	defer r.saveStateSnapshot()
+
+	slog.Info("Deploying", "service", name, "hosts", hosts, "target", targetURL, "tls", options.TLSEnabled)
+
+	target, err := r.deployNewTargetWithOptions(name, hosts, options.TLSEnabled, targetURL, targetOptions, deployTimeout)
+	if err != nil {
+		return err
+	}
+
+	err = r.setActiveTarget(name, hosts, target, options, drainTimeout)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Deployed", "service", name, "hosts", hosts, "target", targetURL)
+	return nil
+}
+
+func (r *Router) updateServiceOptions(name string, hosts []string, options ServiceOptions) error {
+	defer r.saveStateSnapshot()
+
+	slog.Info("Updating options", "service", name, "hosts", hosts)
+
+	err := r.withWriteLock(func() error {
+		conflict := r.hostServices.CheckHostAvailability(name, routedHosts(hosts, options))
+		if conflict != nil {
+			slog.Error("Host settings conflict with another service", "service", conflict.name)
+			return ErrorHostInUse
+		}
+
+		err := r.services[name].UpdateOptions(hosts, options)
+		if err != nil {
+			return err
+		}
+
+		r.hostServices = r.services.HostServices()
+		r.applyEndpoints(name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Updated options", "service", name, "hosts", hosts)
+	return nil
+}
+
+// serviceUnchanged reports whether a deploy would leave a service as it is.
+// Since the deploy would restart the service's time to live, it's restarted
+// here instead.
+func (r *Router) serviceUnchanged(name string, hosts []string, targetURL string, options ServiceOptions, targetOptions TargetOptions) bool {
+	unchanged := false
+	r.withWriteLock(func() error {
+		service := r.services[name]
+		if service != nil && service.Unchanged(hosts, targetURL, options, targetOptions) {
+			service.restartTTL()
+			unchanged = true
+		}
+		return nil
+	})
+
+	if unchanged {
+		r.saveStateSnapshot()
+	}
+	return unchanged
+}
+
+// deployNewTargetWithOptions starts a target and waits for it to become
+// healthy, and to pass its version check and smoke tests, which are sent as
+// if for the given hosts.
+func (r *Router) deployNewTargetWithOptions(name string, hosts []string, tlsEnabled bool, targetURL string, targetOptions TargetOptions, deployTimeout time.Duration) (*Target, error) {
 	target, err := NewTarget(targetURL, targetOptions)
 	if err != nil {
 		return nil, err
 	}
 
+	// Keep hold of the target while it's deploying, and afterwards, so that its
+	// progress can be followed, and its health checks inspected if it fails.
+	r.withWriteLock(func() error {
+		r.deploymentCount++
+		r.deployments[name] = &deployment{id: r.deploymentCount, target: target}
+		return nil
+	})
+
 	becameHealthy := target.WaitUntilHealthy(deployTimeout)
 	if !becameHealthy {
+		r.recordDeployDiagnostics(name, target)
+		target.StopResolving()
 		slog.Info("Target failed to become healthy", "target", targetURL)
 		return nil, fmt.Errorf("%w (%s)", ErrorTargetFailedToBecomeHealthy, deployTimeout)
 	}
 
+	err = target.VerifyVersion(smokeTestHost(hosts), tlsEnabled)
+	if err == nil {
+		err = target.RunSmokeTests(smokeTestHost(hosts), tlsEnabled)
+	}
+	if err != nil {
+		target.StopResolving()
+		return nil, err
+	}
+
+	target.PrewarmConnections()
+
 	return target, nil
 }
 
-func (r *Router) saveStateSnapshot() error {
+func (r *Router) recordDeployDiagnostics(name string, target *Target) {
+	var active *Target
+	service := r.serviceForName(name)
+	if service != nil {
+		active = service.ActiveTarget()
+	}
+
+	diagnostics := newDeployDiagnostics(target, active)
+
+	r.withWriteLock(func() error {
+		deployment := r.deployments[name]
+		if deployment != nil && deployment.target == target {
+			deployment.diagnostics = diagnostics
+		}
+		return nil
+	})
+}
+
+func (r *Router) validateImport(services []marshalledService) error {
+	hostServices := HostServiceMap{}
+	for _, ms := range services {
+		if ms.Name == "" || ms.ActiveTarget == "" {
+			return fmt.Errorf("%w: each service must have a name and a target", ErrorInvalidImport)
+		}
+
+		conflict := hostServices.CheckHostAvailability(ms.Name, routedHosts(ms.Hosts, ms.Options))
+		if conflict != nil {
+			return fmt.Errorf("%w: %s and %s", ErrorHostInUse, conflict.name, ms.Name)
+		}
+
+		service := &Service{name: ms.Name, hosts: ms.Hosts, options: ms.Options}
+		maps.Copy(hostServices, ServiceMap{ms.Name: service}.HostServices())
+
+		_, err := parseTargetURL(ms.ActiveTarget)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ms.Name, err)
+		}
+
+		for tenant, target := range ms.TenantTargets {
+			_, err = parseTargetURL(target)
+			if err != nil {
+				return fmt.Errorf("%s: tenant %s: %w", ms.Name, tenant, err)
+			}
+		}
+
+		err = ms.TargetOptions.HealthCheckConfig.Validate()
+		if err != nil {
+			return fmt.Errorf("%s: %w", ms.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Router) importService(ms marshalledService, deployTimeout time.Duration, drainTimeout time.Duration) error {
+	err := r.SetServiceTarget(ms.Name, ms.Hosts, ms.ActiveTarget, ms.Options, ms.TargetOptions, deployTimeout, drainTimeout)
+	if err != nil {
+		return err
+	}
+
+	if ms.RolloutTarget != "" {
+		err = r.SetRolloutTarget(ms.Name, ms.RolloutTarget, deployTimeout, drainTimeout)
+		if err != nil {
+			return err
+		}
+
+		if ms.RolloutController != nil {
+			err = r.SetRolloutSplit(ms.Name, ms.RolloutController.Percentage, ms.RolloutController.Allowlist)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, tenant := range slices.Sorted(maps.Keys(ms.TenantTargets)) {
+		err = r.SetTenantTarget(ms.Name, tenant, ms.TenantTargets[tenant], deployTimeout, drainTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ms.PauseController != nil {
+		switch ms.PauseController.GetState() {
+		case PauseStatePaused:
+			err = r.PauseService(ms.Name, drainTimeout, ms.PauseController.FailAfter, ms.PauseController.ExceptPaths, ms.PauseController.ReadOnly)
+		case PauseStateStopped:
+			err = r.StopService(ms.Name, drainTimeout, ms.PauseController.GetStopMessage())
+		}
+	}
+
+	return err
+}
+
+func (r *Router) allServices() []*Service {
 	services := []*Service{}
 	r.withReadLock(func() error {
-		for _, service := range r.services {
-			services = append(services, service)
+		for _, name := range slices.Sorted(maps.Keys(r.services)) {
+			services = append(services, r.services[name])
 		}
 		return nil
 	})
+	return services
+}
 
-	f, err := os.Create(r.statePath)
+func (r *Router) saveStateSnapshot() error {
+	data, err := encodeStateWithPools(r.allServices(), r.Pools())
 	if err != nil {
+		slog.Error("Unable to save state", "error", err, "path", r.statePath)
+		reportStateError("save", r.statePath, err)
 		return err
 	}
 
-	err = json.NewEncoder(f).Encode(services)
+	err = writeFileAtomically(r.statePath, data)
 	if err != nil {
 		slog.Error("Unable to save state", "error", err, "path", r.statePath)
+		reportStateError("save", r.statePath, err)
 		return err
 	}
 
@@ -366,7 +1123,7 @@ func (r *Router) setActiveTarget(name string, hosts []string, target *Target, op
 	r.serviceLock.Lock()
 	defer r.serviceLock.Unlock()
 
-	conflict := r.hostServices.CheckHostAvailability(name, hosts)
+	conflict := r.hostServices.CheckHostAvailability(name, routedHosts(hosts, options))
 	if conflict != nil {
 		slog.Error("Host settings conflict with another service", "service", conflict.name)
 		return ErrorHostInUse
@@ -387,10 +1144,45 @@ func (r *Router) setActiveTarget(name string, hosts []string, target *Target, op
 	r.hostServices = r.services.HostServices()
 
 	service.SetTarget(TargetSlotActive, target, drainTimeout)
+	r.applyEndpoints(name)
 
 	return nil
 }
 
+func (r *Router) applyDiscoveredEndpoints(name string, endpoints []string) {
+	service := r.services[name]
+	if service == nil || service.ActiveTarget() == nil || service.options.Pool != "" {
+		return
+	}
+
+	slog.Info("Updating discovered endpoints", "service", name, "endpoints", endpoints)
+	service.ActiveTarget().SetEndpoints(endpoints)
+}
+
+// applyEndpoints points a service's active target at the addresses of its
+// pool, if it uses one, or otherwise at those that were discovered for it.
+func (r *Router) applyEndpoints(name string) {
+	service := r.services[name]
+	if service == nil || service.ActiveTarget() == nil {
+		return
+	}
+
+	if pool := service.options.Pool; pool != "" {
+		slog.Info("Updating pool endpoints", "service", name, "pool", pool, "endpoints", r.pools[pool])
+		service.ActiveTarget().SetEndpoints(r.pools[pool])
+	} else {
+		service.ActiveTarget().SetEndpoints(r.discoveredEndpoints[name])
+	}
+}
+
+func (r *Router) hostInUse(host string) bool {
+	r.serviceLock.RLock()
+	defer r.serviceLock.RUnlock()
+
+	_, ok := r.hostServices[host]
+	return ok
+}
+
 func (r *Router) serviceForName(name string) *Service {
 	r.serviceLock.RLock()
 	defer r.serviceLock.RUnlock()
@@ -398,6 +1190,24 @@ func (r *Router) serviceForName(name string) *Service {
 	return r.services[name]
 }
 
+func (r *Router) updatePool(pool string, update func(TargetPools) error) error {
+	return r.withWriteLock(func() error {
+		pools := maps.Clone(r.pools)
+		err := update(pools)
+		if err != nil {
+			return err
+		}
+		r.pools = pools
+
+		for name, service := range r.services {
+			if service.options.Pool == pool {
+				r.applyEndpoints(name)
+			}
+		}
+		return nil
+	})
+}
+
 func (r *Router) withReadLock(fn func() error) error {
 	r.serviceLock.RLock()
 	defer r.serviceLock.RUnlock()
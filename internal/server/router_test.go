@@ -1,13 +1,20 @@
 package server
 
 import (
+	"bytes"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -203,7 +210,7 @@ func TestRouter_UpdatingPauseStateIndependentlyOfDeployments(t *testing.T) {
 	_, target := testBackend(t, "first", http.StatusOK)
 
 	require.NoError(t, router.SetServiceTarget("service1", []string{"dummy.example.com"}, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
-	router.PauseService("service1", time.Second, time.Millisecond*10)
+	router.PauseService("service1", time.Second, time.Millisecond*10, nil, false)
 
 	statusCode, _ := sendRequest(router, httptest.NewRequest(http.MethodPost, "http://dummy.example.com", strings.NewReader("Something longer than 10")))
 	assert.Equal(t, http.StatusGatewayTimeout, statusCode)
@@ -213,7 +220,7 @@ func TestRouter_UpdatingPauseStateIndependentlyOfDeployments(t *testing.T) {
 	statusCode, _ = sendRequest(router, httptest.NewRequest(http.MethodPost, "http://dummy.example.com", strings.NewReader("Something longer than 10")))
 	assert.Equal(t, http.StatusGatewayTimeout, statusCode)
 
-	router.ResumeService("service1")
+	router.ResumeService("service1", 0)
 
 	statusCode, _ = sendRequest(router, httptest.NewRequest(http.MethodPost, "http://dummy.example.com", strings.NewReader("Something longer than 10")))
 	assert.Equal(t, http.StatusOK, statusCode)
@@ -382,6 +389,420 @@ func TestRouter_EnablingRollout(t *testing.T) {
 	checkResponse("first")
 }
 
+func TestRouter_InjectingChaos(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetChaos("service1", 100, 0, http.StatusServiceUnavailable, time.Minute))
+
+	statusCode, _ := sendGETRequest(router, "http://example.com/")
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+
+	require.NoError(t, router.StopChaos("service1"))
+
+	statusCode, body := sendGETRequest(router, "http://example.com/")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "first", body)
+
+	assert.Equal(t, ErrorServiceNotFound, router.SetChaos("other", 100, 0, http.StatusServiceUnavailable, time.Minute))
+}
+
+func TestRouter_DiscoveredEndpoints(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackend(t, "second", http.StatusOK)
+
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	router.SetDiscoveredEndpoints(map[string][]string{"service1": {second}})
+	require.Eventually(t, func() bool {
+		_, body := sendGETRequest(router, "http://example.com/")
+		return body == "second"
+	}, time.Second, time.Millisecond*10)
+
+	router.SetDiscoveredEndpoints(map[string][]string{})
+	_, body := sendGETRequest(router, "http://example.com/")
+	assert.Equal(t, "first", body)
+}
+
+func TestRouter_DiscoveredEndpointsGoOnBeingHealthChecked(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+
+	var failing atomic.Bool
+	_, second := testBackendWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == DefaultHealthCheckPath && failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("second"))
+	})
+
+	targetOptions := defaultTargetOptions
+	targetOptions.HealthCheckConfig.Interval = 50 * time.Millisecond
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, first, defaultServiceOptions, targetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	router.SetDiscoveredEndpoints(map[string][]string{"service1": {second}})
+	require.Eventually(t, func() bool {
+		_, body := sendGETRequest(router, "http://example.com/")
+		return body == "second"
+	}, time.Second, time.Millisecond*10)
+
+	failing.Store(true)
+	require.Eventually(t, func() bool {
+		_, body := sendGETRequest(router, "http://example.com/")
+		return body == "first"
+	}, time.Second, time.Millisecond*10)
+
+	failing.Store(false)
+	require.Eventually(t, func() bool {
+		_, body := sendGETRequest(router, "http://example.com/")
+		return body == "second"
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestRouter_HealthLog(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, failing := testBackend(t, "failing", http.StatusServiceUnavailable)
+
+	_, err := router.HealthLog("service1")
+	assert.Equal(t, ErrorServiceNotFound, err)
+
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	err = router.SetServiceTarget("service1", defaultEmptyHosts, failing, defaultServiceOptions, defaultTargetOptions, time.Millisecond*200, DefaultDrainTimeout)
+	require.ErrorIs(t, err, ErrorTargetFailedToBecomeHealthy)
+
+	log, err := router.HealthLog("service1")
+	require.NoError(t, err)
+	require.Len(t, log, 2)
+
+	assert.Equal(t, "active", log[0].Role)
+	assert.Equal(t, first, log[0].Target)
+	assert.True(t, log[0].Results[0].Success)
+
+	assert.Equal(t, "deploy", log[1].Role)
+	assert.Equal(t, failing, log[1].Target)
+	assert.False(t, log[1].Results[0].Success)
+	assert.Equal(t, http.StatusServiceUnavailable, log[1].Results[0].StatusCode)
+	assert.Contains(t, log[1].Results[0].Error, "Unexpected status")
+}
+
+func TestRouter_DeployProgress(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, failing := testBackend(t, "failing", http.StatusServiceUnavailable)
+
+	progress := router.DeployProgress("service1", 0, 0)
+	assert.Equal(t, 0, progress.DeploymentID)
+	assert.Empty(t, progress.Target)
+
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	progress = router.DeployProgress("service1", 0, 0)
+	assert.Equal(t, 1, progress.DeploymentID)
+	assert.Equal(t, first, progress.Target)
+	require.NotEmpty(t, progress.Results)
+	assert.True(t, progress.Results[0].Success)
+
+	after := progress.DeploymentID
+	progress = router.DeployProgress("service1", after, 0)
+	assert.Equal(t, after, progress.DeploymentID)
+	assert.Empty(t, progress.Target)
+
+	err := router.SetServiceTarget("service1", defaultEmptyHosts, failing, defaultServiceOptions, defaultTargetOptions, time.Millisecond*200, DefaultDrainTimeout)
+	require.ErrorIs(t, err, ErrorTargetFailedToBecomeHealthy)
+
+	progress = router.DeployProgress("service1", after, 0)
+	assert.Equal(t, 2, progress.DeploymentID)
+	assert.Equal(t, failing, progress.Target)
+	require.NotEmpty(t, progress.Results)
+	assert.Equal(t, http.StatusServiceUnavailable, progress.Results[0].StatusCode)
+
+	progress = router.DeployProgress("service1", after, progress.Next)
+	assert.Empty(t, progress.Results)
+}
+
+func TestRouter_DeployDiagnostics(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, failing := testBackend(t, "not ready", http.StatusServiceUnavailable)
+
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	assert.Nil(t, router.DeployDiagnostics("service1", 0, DefaultDiagnosticHealthChecks))
+
+	err := router.SetServiceTarget("service1", defaultEmptyHosts, failing, defaultServiceOptions, defaultTargetOptions, time.Millisecond*200, DefaultDrainTimeout)
+	require.ErrorIs(t, err, ErrorTargetFailedToBecomeHealthy)
+
+	diagnostics := router.DeployDiagnostics("service1", 0, 1)
+	require.NotNil(t, diagnostics)
+	assert.Equal(t, failing, diagnostics.Target)
+	require.Len(t, diagnostics.HealthChecks, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, diagnostics.HealthChecks[0].StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, diagnostics.TestRequest.StatusCode)
+	assert.Equal(t, "not ready", diagnostics.TestRequest.Body)
+	assert.Equal(t, first, diagnostics.Connections.ActiveTarget)
+
+	assert.Nil(t, router.DeployDiagnostics("service1", 2, DefaultDiagnosticHealthChecks))
+}
+
+func TestRouter_ConcurrentDeploysAreSerialized(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackend(t, "second", http.StatusOK)
+
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	unlock, err := router.deployLocks.Acquire("service1", first, 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, first, router.ListActiveServices()["service1"].DeployLock.Target)
+
+	err = router.SetServiceTarget("service1", defaultEmptyHosts, second, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout)
+	assert.ErrorIs(t, err, ErrorDeployInProgress)
+	assert.ErrorIs(t, router.SetRolloutTarget("service1", second, DefaultDeployTimeout, DefaultDrainTimeout), ErrorDeployInProgress)
+
+	deployed := make(chan error)
+	go func() {
+		_, err := router.DeployServiceTarget("service1", defaultEmptyHosts, second, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout, time.Second, false)
+		deployed <- err
+	}()
+
+	require.Eventually(t, func() bool {
+		return router.deployLocks.Status("service1").Queued == 1
+	}, time.Second, time.Millisecond)
+	unlock()
+	require.NoError(t, <-deployed)
+
+	_, body := sendGETRequest(router, "http://example.com/")
+	assert.Equal(t, "second", body)
+	assert.Nil(t, router.ListActiveServices()["service1"].DeployLock)
+}
+
+func TestRouter_DeployWithNoChangesIsSkipped(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackend(t, "second", http.StatusOK)
+
+	hosts := []string{"example.com"}
+	options := ServiceOptions{TTL: time.Hour}
+	deploy := func(target string, options ServiceOptions, force bool) bool {
+		targetOptions := defaultTargetOptions
+		targetOptions.LogRequestHeaders = []string{"x-custom"}
+
+		result, err := router.DeployServiceTarget("service1", hosts, target, options, targetOptions, DefaultDeployTimeout, DefaultDrainTimeout, 0, force)
+		require.NoError(t, err)
+		return !result.Unchanged
+	}
+
+	assert.True(t, deploy(first, options, false))
+	active := router.serviceForName("service1").ActiveTarget()
+
+	assert.False(t, deploy(first, options, false))
+	assert.Same(t, active, router.serviceForName("service1").ActiveTarget())
+
+	assert.True(t, deploy(first, options, true))
+	assert.NotSame(t, active, router.serviceForName("service1").ActiveTarget())
+
+	options.TTL = 2 * time.Hour
+	assert.True(t, deploy(first, options, false))
+
+	assert.True(t, deploy(second, options, false))
+	_, body := sendGETRequest(router, "http://example.com/")
+	assert.Equal(t, "second", body)
+}
+
+func TestRouter_DeployReportsChangedOptions(t *testing.T) {
+	router := testRouter(t)
+	_, target := testBackend(t, "first", http.StatusOK)
+
+	deploy := func(hosts []string, options ServiceOptions, targetOptions TargetOptions) []OptionChange {
+		result, err := router.DeployServiceTarget("service1", hosts, target, options, targetOptions, DefaultDeployTimeout, DefaultDrainTimeout, 0, true)
+		require.NoError(t, err)
+		return result.Changes
+	}
+
+	targetOptions := defaultTargetOptions
+	targetOptions.BufferRequests = true
+	targetOptions.LogRequestHeaders = []string{"X-Custom"}
+	options := ServiceOptions{SignatureSecret: "s3cret", AllowedMethods: []string{"GET"}}
+	assert.Empty(t, deploy([]string{"example.com"}, options, targetOptions))
+
+	changed := defaultTargetOptions
+	changed.HealthCheckConfig.Path = "/health"
+	changed.LogRequestHeaders = []string{"x-custom"}
+	changes := deploy([]string{"example.com", "www.example.com"}, ServiceOptions{SignatureSecret: "other"}, changed)
+
+	assert.Equal(t, []OptionChange{
+		{Option: "allowed_methods", From: `["GET"]`},
+		{Option: "buffer_requests", From: "true"},
+		{Option: "health_check_config.path", From: `"/up"`, To: `"/health"`},
+		{Option: "hosts", From: `["example.com"]`, To: `["example.com","www.example.com"]`},
+		{Option: "signature_secret", From: "[redacted]", To: "[redacted]"},
+	}, changes)
+}
+
+func TestRouter_DeployUpdatesServiceOptionsInPlace(t *testing.T) {
+	router := testRouter(t)
+	_, target := testBackend(t, "first", http.StatusOK)
+
+	require.NoError(t, router.SetServiceTarget("service1", []string{"example.com"}, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	active := router.serviceForName("service1").ActiveTarget()
+
+	deployed, err := router.DeployedOptions("service1")
+	require.NoError(t, err)
+	require.NoError(t, deployed.SetOption("hosts", `["example.com","other.example.com"]`))
+
+	result, err := router.DeployServiceTarget("service1", deployed.Hosts, deployed.Target, deployed.Options, deployed.TargetOptions, DefaultDeployTimeout, DefaultDrainTimeout, 0, false)
+	require.NoError(t, err)
+	assert.Equal(t, []OptionChange{{Option: "hosts", From: `["example.com"]`, To: `["example.com","other.example.com"]`}}, result.Changes)
+	assert.Same(t, active, router.serviceForName("service1").ActiveTarget())
+
+	statusCode, body := sendGETRequest(router, "http://other.example.com/")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "first", body)
+
+	_, err = router.DeployedOptions("missing")
+	assert.ErrorIs(t, err, ErrorServiceNotFound)
+}
+
+func TestRouter_RemoveExpiredServices(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackend(t, "second", http.StatusOK)
+
+	require.NoError(t, router.SetServiceTarget("review", []string{"review.example.com"}, first, ServiceOptions{TTL: time.Hour}, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetServiceTarget("main", []string{"main.example.com"}, second, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	router.RemoveExpiredServices(time.Now())
+	assert.Len(t, router.ListActiveServices(), 2)
+
+	router.RemoveExpiredServices(time.Now().Add(time.Hour * 2))
+	services := router.ListActiveServices()
+	assert.Len(t, services, 1)
+	assert.Contains(t, services, "main")
+
+	statusCode, _ := sendGETRequest(router, "http://review.example.com/")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestRouter_RemoveServiceReleasesPlugins(t *testing.T) {
+	router := testRouter(t)
+	_, target := testBackend(t, "first", http.StatusOK)
+
+	first := testPluginWritingOutput(t, `{"set_request_headers":{"X-Plugin":"first"}}`)
+	second := testPluginWritingOutput(t, `{"set_request_headers":{"X-Plugin":"second"}}`)
+
+	require.NoError(t, router.SetServiceTarget("service", defaultEmptyHosts, target, ServiceOptions{Plugins: []string{first}}, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	firstKey := router.serviceForName("service").plugins.plugins[0].compiled.key
+	assert.Contains(t, testCompiledPlugins(), firstKey)
+
+	require.NoError(t, router.SetServiceTarget("service", defaultEmptyHosts, target, ServiceOptions{Plugins: []string{second}}, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	secondKey := router.serviceForName("service").plugins.plugins[0].compiled.key
+	assert.NotContains(t, testCompiledPlugins(), firstKey)
+	assert.Contains(t, testCompiledPlugins(), secondKey)
+
+	require.NoError(t, router.RemoveService("service"))
+	assert.NotContains(t, testCompiledPlugins(), secondKey)
+}
+
+func TestRouter_RemoveServiceRetiresRolloutTarget(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackend(t, "second", http.StatusOK)
+
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetRolloutTarget("service1", second, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	rollout := router.serviceForName("service1").RolloutTarget()
+	rollout.SetEndpoints([]string{"third.internal:3000"})
+
+	require.NoError(t, router.RemoveService("service1"))
+
+	rollout.endpoints.lock.RLock()
+	defer rollout.endpoints.lock.RUnlock()
+	assert.Empty(t, rollout.endpoints.endpoints, "rollout target should have stopped resolving")
+}
+
+func TestRouter_RemoveServiceDeletesItsMetrics(t *testing.T) {
+	router := testRouter(t)
+	_, target := testBackend(t, "first", http.StatusOK)
+	handler := WithLoggingMiddleware(slog.New(slog.NewTextHandler(io.Discard, nil)), 80, 443, WithMetricsMiddleware(router))
+
+	require.NoError(t, router.SetServiceTarget("metrics-service", defaultEmptyHosts, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(requestsCounter.WithLabelValues("metrics-service", http.MethodGet, "200")))
+
+	require.NoError(t, router.RemoveService("metrics-service"))
+
+	labels := prometheus.Labels{"service": "metrics-service"}
+	assert.Zero(t, requestsCounter.DeletePartialMatch(labels))
+	assert.Zero(t, requestDurationSeconds.DeletePartialMatch(labels))
+	assert.Zero(t, serviceConnectionsCounter.DeletePartialMatch(labels))
+	assert.Zero(t, serviceBytesOutCounter.DeletePartialMatch(labels))
+}
+
+func TestRouter_RemoveServiceAndCertificates(t *testing.T) {
+	router := testRouter(t)
+	_, target := testBackend(t, "first", http.StatusOK)
+
+	options := ServiceOptions{TLSEnabled: true, ACMECachePath: t.TempDir()}
+	require.NoError(t, router.SetServiceTarget("first", []string{"first.example.com"}, target, options, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetServiceTarget("second", []string{"second.example.com"}, target, options, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	cacheDir := options.ScopedCachePath()
+	require.NoError(t, os.MkdirAll(cacheDir, 0700))
+	for _, name := range []string{"first.example.com", "first.example.com+rsa", "second.example.com"} {
+		require.NoError(t, os.WriteFile(filepath.Join(cacheDir, name), []byte("cert"), 0600))
+	}
+
+	require.NoError(t, router.RemoveServiceAndCertificates("first"))
+	assert.NotContains(t, router.ListActiveServices(), "first")
+
+	assert.NoFileExists(t, filepath.Join(cacheDir, "first.example.com"))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "first.example.com+rsa"))
+	assert.FileExists(t, filepath.Join(cacheDir, "second.example.com"))
+
+	assert.ErrorIs(t, router.RemoveServiceAndCertificates("first"), ErrorServiceNotFound)
+}
+
+func TestRouter_DeleteCertificates(t *testing.T) {
+	router := testRouter(t)
+	_, target := testBackend(t, "first", http.StatusOK)
+	require.NoError(t, router.SetServiceTarget("service", []string{"served.example.com"}, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	cachePath := t.TempDir()
+	for _, dir := range []string{"production", "staging"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(cachePath, dir), 0700))
+		for _, name := range []string{"gone.example.com", "served.example.com", "acme_account+key"} {
+			require.NoError(t, os.WriteFile(filepath.Join(cachePath, dir, name), []byte("data"), 0600))
+		}
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(cachePath, "staging", "gone.example.com+rsa"), []byte("cert"), 0600))
+
+	deleted, err := router.DeleteCertificates("gone.example.com", cachePath)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	for _, dir := range []string{"production", "staging"} {
+		assert.NoFileExists(t, filepath.Join(cachePath, dir, "gone.example.com"))
+		assert.FileExists(t, filepath.Join(cachePath, dir, "acme_account+key"))
+	}
+	assert.NoFileExists(t, filepath.Join(cachePath, "staging", "gone.example.com+rsa"))
+
+	deleted, err = router.DeleteCertificates("gone.example.com", cachePath)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	_, err = router.DeleteCertificates("served.example.com", cachePath)
+	assert.ErrorIs(t, err, ErrorCertificateInUse)
+	assert.FileExists(t, filepath.Join(cachePath, "production", "served.example.com"))
+
+	_, err = router.DeleteCertificates("../production", cachePath)
+	assert.ErrorIs(t, err, ErrorInvalidCertificateHost)
+}
+
 func TestRouter_RestoreLastSavedState(t *testing.T) {
 	statePath := filepath.Join(t.TempDir(), "state.json")
 
@@ -410,6 +831,152 @@ func TestRouter_RestoreLastSavedState(t *testing.T) {
 	assert.Equal(t, http.StatusMovedPermanently, statusCode)
 }
 
+func TestRouter_RestoreServiceWithWebSocketPolicy(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	_, backend := testBackend(t, "first", http.StatusOK)
+
+	router := NewRouter(statePath)
+	require.NoError(t, router.SetServiceTarget("default", defaultEmptyHosts, backend, ServiceOptions{MaxWebSocketConnections: 10}, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	router = NewRouter(statePath)
+	require.NoError(t, router.RestoreLastSavedState())
+
+	req := httptest.NewRequest(http.MethodGet, "http://something.example.com/cable", nil)
+	req.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestRouter_ExportAndImportState(t *testing.T) {
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackend(t, "second", http.StatusOK)
+
+	router := testRouter(t)
+	require.NoError(t, router.SetServiceTarget("first", []string{"first.example.com"}, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetServiceTarget("second", []string{"second.example.com"}, second, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.PauseService("second", DefaultDrainTimeout, DefaultPauseTimeout, nil, false))
+
+	data, err := router.ExportState()
+	require.NoError(t, err)
+
+	imported := testRouter(t)
+	require.NoError(t, imported.ImportState(data, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	statusCode, body := sendGETRequest(imported, "http://first.example.com/")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "first", body)
+
+	services := imported.ListActiveServices()
+	assert.Equal(t, "running", services["first"].State)
+	assert.Equal(t, "paused", services["second"].State)
+	assert.Nil(t, services["first"].Pause)
+	require.NotNil(t, services["second"].Pause)
+	assert.Equal(t, DefaultPauseTimeout, services["second"].Pause.Timeout)
+}
+
+func TestRouter_ImportStateWithUnhealthyTarget(t *testing.T) {
+	_, first := testBackend(t, "first", http.StatusOK)
+	server, second := testBackend(t, "second", http.StatusOK)
+
+	router := testRouter(t)
+	require.NoError(t, router.SetServiceTarget("first", []string{"first.example.com"}, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetServiceTarget("second", []string{"second.example.com"}, second, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	data, err := router.ExportState()
+	require.NoError(t, err)
+	server.Close()
+
+	imported := testRouter(t)
+	err = imported.ImportState(data, time.Millisecond*20, DefaultDrainTimeout)
+	assert.ErrorIs(t, err, ErrorTargetFailedToBecomeHealthy)
+
+	statusCode, body := sendGETRequest(imported, "http://first.example.com/")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "first", body)
+
+	statusCode, _ = sendGETRequest(imported, "http://second.example.com/")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestRouter_ImportStateIsValidatedBeforeDeploying(t *testing.T) {
+	first := testCreateService(t, []string{"example.com"}, defaultServiceOptions, defaultTargetOptions)
+	second := testCreateService(t, []string{"example.com"}, defaultServiceOptions, defaultTargetOptions)
+	first.name = "first"
+	second.name = "second"
+
+	data, err := encodeState([]*Service{first, second})
+	require.NoError(t, err)
+
+	router := testRouter(t)
+	err = router.ImportState(data, DefaultDeployTimeout, DefaultDrainTimeout)
+	assert.ErrorIs(t, err, ErrorHostInUse)
+	assert.Empty(t, router.ListActiveServices())
+
+	data, err = encodeState([]*Service{first})
+	require.NoError(t, err)
+
+	tampered := bytes.Replace(data, []byte("example.com"), []byte("other.example.com"), 1)
+	err = router.ImportState(tampered, DefaultDeployTimeout, DefaultDrainTimeout)
+	assert.ErrorIs(t, err, ErrorStateChecksumMismatch)
+	assert.Empty(t, router.ListActiveServices())
+}
+
+func TestRouter_RestoreStateRemovesServicesThatWereAdded(t *testing.T) {
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackend(t, "second", http.StatusOK)
+
+	router := testRouter(t)
+	require.NoError(t, router.SetServiceTarget("first", []string{"first.example.com"}, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	data, err := router.ExportState()
+	require.NoError(t, err)
+
+	require.NoError(t, router.SetServiceTarget("second", []string{"second.example.com"}, second, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.RemoveService("first"))
+
+	require.NoError(t, router.RestoreState(data, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	statusCode, body := sendGETRequest(router, "http://first.example.com/")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "first", body)
+
+	statusCode, _ = sendGETRequest(router, "http://second.example.com/")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestRouter_RedirectHostsAreRoutedAndReserved(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackend(t, "second", http.StatusOK)
+
+	options := ServiceOptions{RedirectHosts: map[string]string{"www.example.com": "example.com"}}
+	require.NoError(t, router.SetServiceTarget("first", []string{"example.com"}, first, options, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	statusCode, _ := sendGETRequest(router, "http://www.example.com/")
+	assert.Equal(t, http.StatusMovedPermanently, statusCode)
+
+	err := router.SetServiceTarget("second", []string{"www.example.com"}, second, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout)
+	assert.ErrorIs(t, err, ErrorHostInUse)
+}
+
+func TestRouter_TargetLabelsArePersisted(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	_, first := testBackend(t, "first", http.StatusOK)
+
+	targetOptions := defaultTargetOptions
+	targetOptions.Labels = map[string]string{"build": "42"}
+
+	router := NewRouter(statePath)
+	require.NoError(t, router.SetServiceTarget("default", defaultEmptyHosts, first, defaultServiceOptions, targetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	assert.Equal(t, map[string]string{"build": "42"}, router.ListActiveServices()["default"].Labels)
+
+	router = NewRouter(statePath)
+	require.NoError(t, router.RestoreLastSavedState())
+	assert.Equal(t, map[string]string{"build": "42"}, router.ListActiveServices()["default"].Labels)
+}
+
 func TestHostServiceMap_ServiceForHost(t *testing.T) {
 	hsm := HostServiceMap{
 		"example.com":     &Service{name: "1"},
@@ -460,6 +1027,19 @@ func BenchmarkHostServiceMap_WilcardRouting(b *testing.B) {
 	})
 }
 
+func TestRouter_HostLabel(t *testing.T) {
+	router := testRouter(t)
+	_, target := testBackend(t, "ok", http.StatusOK)
+
+	require.NoError(t, router.SetServiceTarget("default", defaultEmptyHosts, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetServiceTarget("app", []string{"app.example.com", "*.tenants.example.com"}, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	assert.Equal(t, "app.example.com", router.HostLabel("app.example.com"))
+	assert.Equal(t, "*.tenants.example.com", router.HostLabel("acme.tenants.example.com"))
+	assert.Equal(t, "other", router.HostLabel("random.example.org"))
+	assert.Equal(t, "other", router.HostLabel(""))
+}
+
 // Helpers
 
 func testRouter(t *testing.T) *Router {
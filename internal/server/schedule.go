@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// The container image doesn't include a time zone database, so embed one
+	// to make sure that schedules' time zones can always be found.
+	_ "time/tzdata"
+)
+
+const (
+	ScheduleClosedMaintenance = "maintenance"
+	ScheduleClosedDisable     = "disable"
+
+	DefaultScheduleClosed = ScheduleClosedMaintenance
+)
+
+var (
+	ErrorInvalidSchedule         = errors.New("invalid schedule")
+	ErrorInvalidScheduleTimezone = errors.New("invalid schedule timezone")
+	ErrorUnknownScheduleClosed   = errors.New("unknown schedule closed action (must be maintenance or disable)")
+)
+
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Schedule is the set of hours during which a service is open. Each window is
+// written as `[<days>] <start>-<end>`, such as `mon-fri 08:00-18:00`. Days may
+// be a range, a comma-separated list, or both, as in `mon-wed,fri`; without
+// them, the window applies to every day. A window whose end is before its
+// start runs past midnight into the following day.
+type Schedule struct {
+	windows  []scheduleWindow
+	location *time.Location
+}
+
+type scheduleWindow struct {
+	days       [7]bool
+	start, end time.Duration
+}
+
+func ParseSchedule(windows []string, timezone string) (*Schedule, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidScheduleTimezone, timezone)
+	}
+
+	schedule := &Schedule{location: location}
+	for _, window := range windows {
+		parsed, err := parseScheduleWindow(window)
+		if err != nil {
+			return nil, err
+		}
+		schedule.windows = append(schedule.windows, parsed)
+	}
+
+	return schedule, nil
+}
+
+func (s *Schedule) IsOpen(now time.Time) bool {
+	now = now.In(s.location)
+	weekday := int(now.Weekday())
+	yesterday := (weekday + 6) % 7
+	sinceMidnight := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+
+	for _, window := range s.windows {
+		if window.start < window.end {
+			if window.days[weekday] && sinceMidnight >= window.start && sinceMidnight < window.end {
+				return true
+			}
+			continue
+		}
+
+		// Windows that run past midnight are open from their start on the
+		// days they list, until their end on the day after.
+		if window.days[weekday] && sinceMidnight >= window.start {
+			return true
+		}
+		if window.days[yesterday] && sinceMidnight < window.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Private
+
+func parseScheduleWindow(window string) (scheduleWindow, error) {
+	result := scheduleWindow{}
+	fields := strings.Fields(strings.ToLower(window))
+
+	var hours string
+	switch len(fields) {
+	case 1:
+		hours = fields[0]
+		for i := range result.days {
+			result.days[i] = true
+		}
+
+	case 2:
+		hours = fields[1]
+		for _, part := range strings.Split(fields[0], ",") {
+			from, to, isRange := strings.Cut(part, "-")
+			if !isRange {
+				to = from
+			}
+
+			first, last := scheduleDayIndex(from), scheduleDayIndex(to)
+			if first < 0 || last < 0 {
+				return scheduleWindow{}, fmt.Errorf("%w: %s", ErrorInvalidSchedule, window)
+			}
+			for day := first; ; day = (day + 1) % 7 {
+				result.days[day] = true
+				if day == last {
+					break
+				}
+			}
+		}
+
+	default:
+		return scheduleWindow{}, fmt.Errorf("%w: %s", ErrorInvalidSchedule, window)
+	}
+
+	start, end, ok := strings.Cut(hours, "-")
+	if !ok {
+		return scheduleWindow{}, fmt.Errorf("%w: %s", ErrorInvalidSchedule, window)
+	}
+
+	var err1, err2 error
+	result.start, err1 = parseScheduleTime(start)
+	result.end, err2 = parseScheduleTime(end)
+	if err1 != nil || err2 != nil || result.start == result.end {
+		return scheduleWindow{}, fmt.Errorf("%w: %s", ErrorInvalidSchedule, window)
+	}
+
+	return result, nil
+}
+
+func parseScheduleTime(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func scheduleDayIndex(day string) int {
+	for i, name := range scheduleDays {
+		if day == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule_IsOpen(t *testing.T) {
+	schedule, err := ParseSchedule([]string{"mon-fri 08:00-18:00", "sat 10:00-14:00"}, "UTC")
+	require.NoError(t, err)
+
+	// 2024-06-03 is a Monday
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC)))
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 7, 17, 59, 59, 0, time.UTC)))
+	assert.False(t, schedule.IsOpen(time.Date(2024, 6, 7, 18, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.IsOpen(time.Date(2024, 6, 3, 7, 59, 0, 0, time.UTC)))
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.IsOpen(time.Date(2024, 6, 8, 15, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.IsOpen(time.Date(2024, 6, 9, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestSchedule_IsOpenPastMidnight(t *testing.T) {
+	schedule, err := ParseSchedule([]string{"fri,sat 22:00-02:00"}, "UTC")
+	require.NoError(t, err)
+
+	assert.False(t, schedule.IsOpen(time.Date(2024, 6, 7, 1, 0, 0, 0, time.UTC)))
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 7, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 8, 1, 0, 0, 0, time.UTC)))
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 9, 1, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.IsOpen(time.Date(2024, 6, 9, 2, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.IsOpen(time.Date(2024, 6, 9, 23, 0, 0, 0, time.UTC)))
+}
+
+func TestSchedule_IsOpenInTimezone(t *testing.T) {
+	schedule, err := ParseSchedule([]string{"09:00-17:00"}, "America/New_York")
+	require.NoError(t, err)
+
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 3, 13, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.IsOpen(time.Date(2024, 6, 3, 22, 0, 0, 0, time.UTC)))
+}
+
+func TestSchedule_DayRangesWrapAroundTheWeek(t *testing.T) {
+	schedule, err := ParseSchedule([]string{"sat-mon 00:00-24:00"}, "")
+	require.NoError(t, err)
+
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)))
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 9, 23, 59, 0, 0, time.UTC)))
+	assert.True(t, schedule.IsOpen(time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.IsOpen(time.Date(2024, 6, 11, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestSchedule_Invalid(t *testing.T) {
+	for _, window := range []string{"", "mon-fri", "weekdays 08:00-18:00", "08:00", "08:00-08:00", "25:00-26:00", "mon fri 08:00-18:00"} {
+		_, err := ParseSchedule([]string{window}, "UTC")
+		assert.ErrorIs(t, err, ErrorInvalidSchedule, window)
+	}
+
+	_, err := ParseSchedule([]string{"08:00-18:00"}, "Mars/Olympus_Mons")
+	assert.ErrorIs(t, err, ErrorInvalidScheduleTimezone)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityHeaders_Presets(t *testing.T) {
+	headers, err := SecurityHeaders(SecurityHeadersStrict, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "DENY", headers.Get("X-Frame-Options"))
+	assert.Equal(t, DefaultStrictContentSecurityPolicy, headers.Get("Content-Security-Policy"))
+
+	headers, err = SecurityHeaders(SecurityHeadersRelaxed, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "SAMEORIGIN", headers.Get("X-Frame-Options"))
+	assert.Empty(t, headers.Get("Content-Security-Policy"))
+
+	_, err = SecurityHeaders("paranoid", "", nil)
+	assert.ErrorIs(t, err, ErrorUnknownSecurityHeadersPreset)
+}
+
+func TestSecurityHeaders_Overrides(t *testing.T) {
+	headers, err := SecurityHeaders(SecurityHeadersStrict, "default-src https:", map[string]string{
+		"X-Frame-Options":              "SAMEORIGIN",
+		"Referrer-Policy":              "",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"x-content-type-options":       "nosniff",
+		"Permissions-Policy":           "",
+		"Cross-Origin-Resource-Policy": "same-site",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "default-src https:", headers.Get("Content-Security-Policy"))
+	assert.Equal(t, "SAMEORIGIN", headers.Get("X-Frame-Options"))
+	assert.Equal(t, "same-origin", headers.Get("Cross-Origin-Opener-Policy"))
+	assert.Equal(t, "same-site", headers.Get("Cross-Origin-Resource-Policy"))
+	assert.Equal(t, "nosniff", headers.Get("X-Content-Type-Options"))
+	assert.NotContains(t, headers, "Referrer-Policy")
+	assert.NotContains(t, headers, "Permissions-Policy")
+}
+
+func TestSecurityHeaders_OnlyContentSecurityPolicy(t *testing.T) {
+	headers, err := SecurityHeaders("", "default-src 'self'", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.Header{"Content-Security-Policy": {"default-src 'self'"}}, headers)
+}
+
+func TestSecurityHeadersMiddleware_AddsHeaders(t *testing.T) {
+	handler := testSecurityHeadersHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	r.TLS = &tls.ConnectionState{}
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "max-age=63072000; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+}
+
+func TestSecurityHeadersMiddleware_OmitsHSTSWithoutTLS(t *testing.T) {
+	handler := testSecurityHeadersHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+}
+
+func TestSecurityHeadersMiddleware_KeepsHeadersSetByTheTarget(t *testing.T) {
+	handler := testSecurityHeadersHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Header().Set("Content-Security-Policy", "default-src *")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+
+	assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "default-src *", w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+}
+
+func TestSecurityHeadersMiddleware_NotAddedToInformationalResponses(t *testing.T) {
+	var earlyHintsFrameOptions string
+	handler := testSecurityHeadersHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusEarlyHints)
+		earlyHintsFrameOptions = w.Header().Get("X-Frame-Options")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+
+	assert.Empty(t, earlyHintsFrameOptions)
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+}
+
+// Helpers
+
+func testSecurityHeadersHandler(t *testing.T, next http.HandlerFunc) http.Handler {
+	t.Helper()
+
+	headers, err := SecurityHeaders(SecurityHeadersStrict, "", nil)
+	require.NoError(t, err)
+
+	return WithSecurityHeadersMiddleware(headers, next)
+}
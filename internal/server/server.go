@@ -1,6 +1,7 @@
 package server
 
 import (
+	"cmp"
 	"context"
 	"crypto/tls"
 	"fmt"
@@ -8,6 +9,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/user"
+	"strconv"
 	"time"
 
 	"golang.org/x/crypto/acme"
@@ -18,17 +21,34 @@ import (
 const (
 	ACMEStagingDirectoryURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
 
-	shutdownTimeout = 10 * time.Second
+	shutdownTimeout       = 10 * time.Second
+	serviceExpiryInterval = 10 * time.Second
 )
 
 type Server struct {
 	config         *Config
 	router         *Router
-	httpListener   net.Listener
-	httpsListener  net.Listener
+	httpListeners  []net.Listener
+	httpsListeners []net.Listener
 	httpServer     *http.Server
 	httpsServer    *http.Server
+	metricsServer  *http.Server
+	debugServer    *http.Server
+	dockerProvider *DockerProvider
 	commandHandler *CommandHandler
+	backups        *StateBackups
+	stopExpiry     context.CancelFunc
+	stopBackups    context.CancelFunc
+	stopPush       context.CancelFunc
+	stopFailover   context.CancelFunc
+	stopHALease    context.CancelFunc
+	haLease        *HALease
+	accessLog      *BufferedLogWriter
+	accessLogger   *slog.Logger
+	errorReporter  ErrorReporter
+
+	// connections counts each client connection once per service it uses.
+	connections clientConnectionTracker
 }
 
 func NewServer(config *Config, router *Router) *Server {
@@ -39,7 +59,37 @@ func NewServer(config *Config, router *Router) *Server {
 }
 
 func (s *Server) Start() error {
-	err := s.startHTTPServers()
+	s.startAccessLog()
+	s.startHALease()
+
+	err := s.startErrorReporter()
+	if err != nil {
+		return err
+	}
+
+	err = s.startHTTPServers()
+	if err != nil {
+		return err
+	}
+
+	err = s.startMetricsServer()
+	if err != nil {
+		return err
+	}
+
+	err = s.startDebugServer()
+	if err != nil {
+		return err
+	}
+
+	err = s.startMetricsPush()
+	if err != nil {
+		return err
+	}
+
+	s.startStateBackups()
+
+	err = s.startDNSFailover()
 	if err != nil {
 		return err
 	}
@@ -49,6 +99,9 @@ func (s *Server) Start() error {
 		return err
 	}
 
+	s.startDockerProvider()
+	s.startServiceExpiry()
+
 	slog.Info("Server started", "http", s.HttpPort(), "https", s.HttpsPort())
 	return nil
 }
@@ -58,73 +111,517 @@ func (s *Server) Stop() {
 	defer cancel()
 
 	s.commandHandler.Close()
-	s.httpServer.Shutdown(ctx)
+	s.stopExpiry()
+	s.stopBackups()
+	s.stopPush()
+	s.stopFailover()
+	s.stopHALease()
+	if s.dockerProvider != nil {
+		s.dockerProvider.Close()
+	}
+	if s.httpServer != nil {
+		s.httpServer.Shutdown(ctx)
+	}
+	if s.metricsServer != nil {
+		s.metricsServer.Shutdown(ctx)
+	}
+	if s.debugServer != nil {
+		s.debugServer.Shutdown(ctx)
+	}
+	if s.haLease != nil {
+		s.haLease.Release()
+	}
+	if s.accessLog != nil {
+		s.accessLog.Close()
+	}
+	if s.errorReporter != nil {
+		SetErrorReporter(nil)
+		s.errorReporter.Close()
+	}
 
 	slog.Info("Server stopped")
 }
 
 func (s *Server) HttpPort() int {
-	return s.httpListener.Addr().(*net.TCPAddr).Port
+	if len(s.httpListeners) == 0 {
+		return 0
+	}
+	return s.httpListeners[0].Addr().(*net.TCPAddr).Port
 }
 
 func (s *Server) HttpsPort() int {
-	return s.httpsListener.Addr().(*net.TCPAddr).Port
+	return s.httpsListeners[0].Addr().(*net.TCPAddr).Port
 }
 
 // Private
 
 func (s *Server) startHTTPServers() error {
-	httpAddr := fmt.Sprintf("%s:%d", s.config.Bind, s.config.HttpPort)
-	httpsAddr := fmt.Sprintf("%s:%d", s.config.Bind, s.config.HttpsPort)
+	err := ValidateListenIPFamily(s.config.ListenFamily)
+	if err != nil {
+		return err
+	}
+
+	httpAddr := net.JoinHostPort(s.config.Bind, strconv.Itoa(s.config.HttpPort))
+	httpsAddr := net.JoinHostPort(s.config.Bind, strconv.Itoa(s.config.HttpsPort))
+
+	handler := s.buildHandler(false)
+
+	switch cmp.Or(s.config.HTTPMode, DefaultHTTPMode) {
+	case HTTPModeFull:
+		err := s.listenHTTP(httpAddr, handler)
+		if err != nil {
+			return err
+		}
+	case HTTPModeRedirect:
+		err := s.listenHTTP(httpAddr, s.buildHandler(true))
+		if err != nil {
+			return err
+		}
+	case HTTPModeOff:
+		slog.Info("HTTP listener disabled")
+	default:
+		return ErrorUnknownHTTPMode
+	}
+
+	listeners, err := s.listenForRequests(httpsAddr)
+	if err != nil {
+		return err
+	}
+	tlsConfig := s.tlsConfig()
+	for _, l := range listeners {
+		l = NewSocketBufferListener(l, s.config.SocketReadBufferSize, s.config.SocketWriteBufferSize)
+		l = NewTLSListener(l, tlsConfig, s.router.HostLabel)
+		if s.config.StrictHTTP {
+			l = NewStrictHTTPListener(l, s.router.LenientHTTPHost)
+		}
+		s.httpsListeners = append(s.httpsListeners, l)
+	}
+	s.httpsServer = &http.Server{
+		Addr:      httpsAddr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	s.connections.Track(s.httpsServer)
+	if s.config.StrictHTTP {
+		trackConn := s.httpsServer.ConnContext
+		s.httpsServer.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+			return trackConn(StrictHTTPConnContext(ctx, conn), conn)
+		}
+		s.httpsServer.Handler = WithStrictHTTPTLSMiddleware(handler)
+	}
+
+	// Each listener has its own accept loop.
+	for _, l := range s.httpListeners {
+		go s.httpServer.Serve(l)
+	}
+	for _, l := range s.httpsListeners {
+		go s.httpsServer.Serve(l)
+	}
+
+	return nil
+}
+
+// tlsConfig offers HTTP/2 to clients, except those connecting to a service
+// that has disabled it, which are only offered HTTP/1.1.
+func (s *Server) tlsConfig() *tls.Config {
+	http1Config := &tls.Config{
+		NextProtos:     []string{"http/1.1", acme.ALPNProto},
+		GetCertificate: s.router.GetCertificate,
+	}
 
-	handler := s.buildHandler()
+	return &tls.Config{
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+		GetCertificate: s.router.GetCertificate,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if s.router.HTTP2DisabledHost(hello.ServerName) {
+				return http1Config, nil
+			}
+			return nil, nil
+		},
+	}
+}
 
-	l, err := net.Listen("tcp", httpAddr)
+func (s *Server) listenHTTP(addr string, handler http.Handler) error {
+	listeners, err := s.listenForRequests(addr)
 	if err != nil {
 		return err
 	}
-	s.httpListener = l
+
+	for _, l := range listeners {
+		l = NewSocketBufferListener(l, s.config.SocketReadBufferSize, s.config.SocketWriteBufferSize)
+		if s.config.StrictHTTP {
+			l = NewStrictHTTPListener(l, s.router.LenientHTTPHost)
+		}
+		s.httpListeners = append(s.httpListeners, l)
+	}
+
 	s.httpServer = &http.Server{
-		Addr:    httpAddr,
+		Addr:    addr,
 		Handler: handler,
 	}
+	s.connections.Track(s.httpServer)
+	return nil
+}
+
+// startAccessLog sets up the logger for requests. Unless they're buffered,
+// they're logged along with everything else.
+func (s *Server) startAccessLog() {
+	s.accessLogger = slog.Default()
+	if s.config.AccessLogBufferSize <= 0 {
+		return
+	}
+
+	interval := cmp.Or(s.config.AccessLogFlushInterval, DefaultAccessLogFlushInterval)
+	s.accessLog = NewBufferedLogWriter(os.Stdout, s.config.AccessLogBufferSize, interval)
+	s.accessLogger = slog.New(slog.NewJSONHandler(s.accessLog, nil))
+
+	slog.Info("Access log buffering enabled", "size", s.config.AccessLogBufferSize, "flush_interval", interval)
+}
+
+func (s *Server) startErrorReporter() error {
+	if s.config.ErrorReportingDSN == "" {
+		return nil
+	}
 
-	l, err = net.Listen("tcp", httpsAddr)
+	reporter, err := NewErrorReporter(s.config.ErrorReportingDSN)
 	if err != nil {
 		return err
 	}
-	s.httpsListener = l
-	s.httpsServer = &http.Server{
-		Addr:    httpsAddr,
-		Handler: handler,
-		TLSConfig: &tls.Config{
-			NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
-			GetCertificate: s.router.GetCertificate,
-		},
+	s.errorReporter = reporter
+	SetErrorReporter(reporter)
+
+	slog.Info("Error reporting enabled")
+	return nil
+}
+
+func (s *Server) startServiceExpiry() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopExpiry = cancel
+
+	go func() {
+		ticker := time.NewTicker(serviceExpiryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.router.RemoveExpiredServices(now)
+			}
+		}
+	}()
+}
+
+func (s *Server) startStateBackups() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopBackups = cancel
+	s.backups = NewStateBackups(s.config.StateBackupsPath(), s.config.StateBackupRetention)
+
+	if s.config.StateBackupInterval <= 0 || s.config.StateBackupRetention <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.config.StateBackupInterval)
+		defer ticker.Stop()
+
+		s.backupState(time.Now())
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.backupState(now)
+			}
+		}
+	}()
+}
+
+func (s *Server) backupState(now time.Time) {
+	data, err := s.router.ExportState()
+	if err != nil {
+		slog.Error("Unable to back up state", "error", err)
+		reportStateError("back up", s.config.StateBackupsPath(), err)
+		return
+	}
+
+	timestamp, err := s.backups.Save(data, now)
+	if err != nil {
+		slog.Error("Unable to back up state", "path", s.config.StateBackupsPath(), "error", err)
+		reportStateError("back up", s.config.StateBackupsPath(), err)
+		return
+	}
+	if timestamp != "" {
+		slog.Info("Backed up state", "timestamp", timestamp)
 	}
+}
 
-	go s.httpServer.Serve(s.httpListener)
-	go s.httpsServer.ServeTLS(s.httpsListener, "", "")
+func (s *Server) startMetricsServer() error {
+	if s.config.MetricsPort == 0 {
+		return nil
+	}
 
+	addr := net.JoinHostPort(s.config.Bind, strconv.Itoa(s.config.MetricsPort))
+	l, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", MetricsHandler())
+
+	s.metricsServer = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go s.metricsServer.Serve(l)
+
+	slog.Info("Metrics enabled", "address", addr)
+	return nil
+}
+
+func (s *Server) startDebugServer() error {
+	if s.config.DebugPort == 0 {
+		return nil
+	}
+
+	addr := net.JoinHostPort(cmp.Or(s.config.DebugBind, DefaultDebugBind), strconv.Itoa(s.config.DebugPort))
+	l, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	s.debugServer = &http.Server{
+		Addr:    addr,
+		Handler: DebugHandler(),
+	}
+
+	go s.debugServer.Serve(l)
+
+	slog.Info("Debug endpoint enabled", "address", addr)
+	return nil
+}
+
+func (s *Server) startMetricsPush() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopPush = cancel
+
+	if s.config.MetricsPushURL == "" {
+		return nil
+	}
+
+	exporter, err := NewMetricsExporter(s.config.MetricsPushURL)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(cmp.Or(s.config.MetricsPushInterval, DefaultMetricsPushInterval))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				pushCtx, cancel := context.WithTimeout(ctx, metricsPushTimeout)
+				err := PushMetrics(pushCtx, exporter, now)
+				cancel()
+				if err != nil {
+					slog.Error("Unable to push metrics", "url", s.config.MetricsPushURL, "error", err)
+				}
+			}
+		}
+	}()
+
+	slog.Info("Metrics push enabled", "url", s.config.MetricsPushURL)
+	return nil
+}
+
+func (s *Server) startDNSFailover() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopFailover = cancel
+
+	if s.config.DNSFailoverURL == "" {
+		return nil
+	}
+
+	provider, err := NewDNSProvider(s.config.DNSFailoverURL)
+	if err != nil {
+		return err
+	}
+	announcer := NewFailoverAnnouncer(s.router, provider)
+
+	go func() {
+		ticker := time.NewTicker(cmp.Or(s.config.DNSFailoverInterval, DefaultDNSFailoverInterval))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				announcer.Check(ctx, now)
+			}
+		}
+	}()
+
+	slog.Info("DNS failover enabled")
 	return nil
 }
 
+func (s *Server) startHALease() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopHALease = cancel
+
+	if s.config.HALeaseDir == "" {
+		return
+	}
+
+	nodeID := s.config.HANodeID
+	if nodeID == "" {
+		nodeID, _ = os.Hostname()
+	}
+	duration := cmp.Or(s.config.HALeaseDuration, DefaultHALeaseDuration)
+
+	s.haLease = NewHALease(s.router, s.config.HALeaseDir, nodeID, duration)
+
+	go func() {
+		s.haLease.Renew(time.Now())
+
+		ticker := time.NewTicker(duration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.haLease.Renew(now)
+			}
+		}
+	}()
+
+	slog.Info("HA mode enabled", "node", nodeID, "dir", s.config.HALeaseDir)
+}
+
+func (s *Server) startDockerProvider() {
+	if s.config.DockerSocketPath == "" {
+		return
+	}
+
+	s.dockerProvider = NewDockerProvider(s.router, s.config.DockerSocketPath, s.config.DockerResyncInterval)
+	s.dockerProvider.Start()
+
+	slog.Info("Docker provider enabled", "socket", s.config.DockerSocketPath)
+}
+
 func (s *Server) startCommandHandler() error {
-	s.commandHandler = NewCommandHandler(s.router)
+	templates := NewTemplateStore(s.config.TemplatesPath())
+	templates.Load()
+
+	s.commandHandler = NewCommandHandler(s.router, templates, s.backups)
 	_ = os.Remove(s.config.SocketPath())
 
-	return s.commandHandler.Start(s.config.SocketPath())
+	err := s.commandHandler.Start(s.config.SocketPath())
+	if err != nil {
+		return err
+	}
+
+	err = s.setCommandSocketPermissions()
+	if err != nil {
+		s.commandHandler.Close()
+		return err
+	}
+
+	return nil
 }
 
-func (s *Server) buildHandler() http.Handler {
+func (s *Server) setCommandSocketPermissions() error {
+	path := s.config.SocketPath()
+
+	if s.config.CommandSocketGroup != "" {
+		gid, err := lookupGroupID(s.config.CommandSocketGroup)
+		if err != nil {
+			return err
+		}
+
+		err = os.Chown(path, -1, gid)
+		if err != nil {
+			return fmt.Errorf("unable to set command socket group: %w", err)
+		}
+	}
+
+	if s.config.CommandSocketMode != 0 {
+		err := os.Chmod(path, s.config.CommandSocketMode)
+		if err != nil {
+			return fmt.Errorf("unable to set command socket mode: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) buildHandler(redirectToHTTPS bool) http.Handler {
 	var handler http.Handler
 
 	// Note: handlers are executed in the inverse order.
 	handler = s.router
+	if redirectToHTTPS {
+		handler = WithHTTPSRedirectMiddleware(handler)
+	}
+	if s.haLease != nil {
+		handler = WithHAHealthMiddleware(s.haLease, cmp.Or(s.config.HAHealthPath, DefaultHAHealthPath), handler)
+	}
+	handler = WithRecoveryMiddleware(s.errorReporter, handler)
 	handler, _ = WithErrorPageMiddleware(pages.DefaultErrorPages, true, handler)
-	handler = WithLoggingMiddleware(slog.Default(), s.config.HttpPort, s.config.HttpsPort, handler)
+	handler = WithMetricsMiddleware(handler)
+	handler = WithLoggingMiddleware(s.accessLogger, s.config.HttpPort, s.config.HttpsPort, handler)
 	handler = WithRequestIDMiddleware(handler)
 	handler = WithRequestStartMiddleware(handler)
 
 	return handler
 }
+
+// listen opens a TCP listener with the configured IP family.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	return listenIPFamily(s.config.ListenFamily, addr)
+}
+
+// listenForRequests opens the listeners for the HTTP or HTTPS port: one, or
+// as many as are configured to share it with SO_REUSEPORT. Each is given the
+// configured TCP options.
+func (s *Server) listenForRequests(addr string) ([]net.Listener, error) {
+	listeners, err := listenReusePort(s.config.ListenFamily, addr, s.config.ReusePortListeners, tcpListenSocketOptions(s.config.TCPDeferAccept))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, l := range listeners {
+		err := setListenBacklog(l, s.config.TCPBacklog)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners[i] = NewTCPTuningListener(l, s.config.TCPDelay, s.config.TCPKeepAlive)
+	}
+
+	return listeners, nil
+}
+
+// lookupGroupID finds a group by its name or ID.
+func lookupGroupID(group string) (int, error) {
+	found, err := user.LookupGroup(group)
+	if err != nil {
+		found, err = user.LookupGroupId(group)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrorUnknownCommandSocketGroup, group)
+	}
+
+	return strconv.Atoi(found.Gid)
+}
@@ -1,8 +1,18 @@
 package server
 
 import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -19,10 +29,161 @@ func TestServer_Deploying(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func TestServer_TracksTransferByService(t *testing.T) {
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("received " + string(body)))
+	})
+	server, addr := testServer(t)
+
+	testDeployTarget(t, target, server)
+
+	client := &http.Client{Transport: &http.Transport{}}
+
+	var body []byte
+	for range 2 {
+		resp, err := client.Post(addr, "text/plain", strings.NewReader("hello"))
+		require.NoError(t, err)
+		body, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "received hello", string(body))
+	}
+
+	stats, err := server.router.ServiceStats("")
+	require.NoError(t, err)
+
+	transfer := stats[""].Transfer
+	assert.Equal(t, int64(10), transfer.BytesIn)
+	assert.Equal(t, int64(2*len(body)), transfer.BytesOut)
+	assert.Equal(t, int64(1), transfer.TotalConnections, "both requests share a connection")
+	assert.Equal(t, int64(1), transfer.ActiveConnections)
+
+	client.CloseIdleConnections()
+	require.Eventually(t, func() bool {
+		stats, _ := server.router.ServiceStats("")
+		return stats[""].Transfer.ActiveConnections == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_HTTPModes(t *testing.T) {
+	start := func(mode string) (*Server, error) {
+		config := &Config{Bind: "127.0.0.1", HTTPMode: mode, AlternateConfigDir: shortTmpDir(t)}
+		server := NewServer(config, NewRouter(config.StatePath()))
+		err := server.Start()
+		if err == nil {
+			t.Cleanup(server.Stop)
+		}
+		return server, err
+	}
+
+	server, err := start(HTTPModeRedirect)
+	require.NoError(t, err)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%d/path", server.HttpPort()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "https://localhost/path", resp.Header.Get("Location"))
+
+	server, err = start(HTTPModeOff)
+	require.NoError(t, err)
+	assert.Equal(t, 0, server.HttpPort())
+	assert.NotZero(t, server.HttpsPort())
+
+	_, err = start("sometimes")
+	assert.ErrorIs(t, err, ErrorUnknownHTTPMode)
+}
+
+func TestServer_CommandSocketPermissions(t *testing.T) {
+	start := func(group string) (*Config, error) {
+		config := &Config{
+			Bind:               "127.0.0.1",
+			AlternateConfigDir: shortTmpDir(t),
+			CommandSocketGroup: group,
+			CommandSocketMode:  0660,
+		}
+		config.CommandSocketPath = filepath.Join(config.AlternateConfigDir, "proxy.sock")
+
+		server := NewServer(config, NewRouter(config.StatePath()))
+		err := server.Start()
+		if err == nil {
+			t.Cleanup(server.Stop)
+		}
+		return config, err
+	}
+
+	gid := os.Getgid()
+	config, err := start(strconv.Itoa(gid))
+	require.NoError(t, err)
+
+	info, err := os.Stat(config.SocketPath())
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+	assert.Equal(t, uint32(gid), info.Sys().(*syscall.Stat_t).Gid)
+
+	_, err = start("no-such-group-here")
+	assert.ErrorIs(t, err, ErrorUnknownCommandSocketGroup)
+}
+
+func TestServer_ReusePortListeners(t *testing.T) {
+	config := &Config{
+		Bind:               "127.0.0.1",
+		AlternateConfigDir: shortTmpDir(t),
+		ReusePortListeners: 3,
+	}
+	server := NewServer(config, NewRouter(config.StatePath()))
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Stop)
+
+	require.Len(t, server.httpListeners, 3)
+	require.Len(t, server.httpsListeners, 3)
+	for _, l := range server.httpListeners {
+		assert.Equal(t, server.HttpPort(), l.Addr().(*net.TCPAddr).Port)
+	}
+
+	testDeployTarget(t, testTarget(t, func(w http.ResponseWriter, r *http.Request) {}), server)
+
+	for range 10 {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d", server.HttpPort()))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestServer_DisablingHTTP2ForAService(t *testing.T) {
+	server, _ := testServer(t)
+	_, target := testBackend(t, "ok", http.StatusOK)
+
+	options := defaultServiceOptions
+	options.DisableHTTP2 = true
+	require.NoError(t, server.router.SetServiceTarget("legacy", []string{"legacy.example.com"}, target, options, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, server.router.SetServiceTarget("app", []string{"app.example.com"}, target, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	config := server.tlsConfig()
+	protocols := func(host string) []string {
+		clientConfig, err := config.GetConfigForClient(&tls.ClientHelloInfo{ServerName: host})
+		require.NoError(t, err)
+		if clientConfig == nil {
+			return config.NextProtos
+		}
+		return clientConfig.NextProtos
+	}
+
+	assert.NotContains(t, protocols("legacy.example.com"), "h2")
+	assert.Contains(t, protocols("legacy.example.com"), "http/1.1")
+	assert.Contains(t, protocols("app.example.com"), "h2")
+	assert.Contains(t, protocols("unknown.example.com"), "h2")
+}
+
 // Helpers
 
 func testDeployTarget(t *testing.T, target *Target, server *Server) {
-	var result bool
+	var result DeployResponse
 	err := server.commandHandler.Deploy(DeployArgs{
 		TargetURL:      target.Target(),
 		DeployTimeout:  DefaultDeployTimeout,
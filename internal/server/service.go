@@ -1,19 +1,25 @@
 package server
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -34,8 +40,9 @@ const (
 	DefaultHealthCheckInterval = time.Second
 	DefaultHealthCheckTimeout  = time.Second * 5
 
-	MaxIdleConnsPerHost = 100
-	ProxyBufferSize     = 32 * KB
+	MaxIdleConnsPerHost    = 100
+	DefaultProxyBufferSize = 32 * KB
+	ExpectContinueTimeout  = time.Second
 
 	DefaultTargetTimeout       = time.Second * 30
 	DefaultMaxMemoryBufferSize = 1 * MB
@@ -43,12 +50,18 @@ const (
 	DefaultMaxResponseBodySize = 0
 
 	DefaultStopMessage = ""
+
+	ACMEChallengeAny     = "any"
+	ACMEChallengeTLSALPN = "tls-alpn-01"
+	DefaultACMEChallenge = ACMEChallengeAny
 )
 
 var (
 	ErrorRolloutTargetNotSet                 = errors.New("rollout target not set")
 	ErrorUnableToLoadErrorPages              = errors.New("unable to load error pages")
 	ErrorAutomaticTLSDoesNotSupportWildcards = errors.New("automatic TLS does not support wildcards")
+	ErrorUnknownACMEChallenge                = errors.New("unknown ACME challenge (must be any or tls-alpn-01)")
+	ErrorInvalidRedirectHost                 = errors.New("redirect hosts must redirect another host to one of the service's hosts")
 )
 
 type TargetSlot int
@@ -59,18 +72,187 @@ const (
 )
 
 type HealthCheckConfig struct {
+	Type     string        `json:"type,omitempty"`
 	Path     string        `json:"path"`
+	Command  string        `json:"command,omitempty"`
 	Interval time.Duration `json:"interval"`
 	Timeout  time.Duration `json:"timeout"`
 }
 
 type ServiceOptions struct {
-	TLSEnabled         bool   `json:"tls_enabled"`
-	TLSCertificatePath string `json:"tls_certificate_path"`
-	TLSPrivateKeyPath  string `json:"tls_private_key_path"`
-	ACMEDirectory      string `json:"acme_directory"`
-	ACMECachePath      string `json:"acme_cache_path"`
-	ErrorPagePath      string `json:"error_page_path"`
+	TLSEnabled         bool     `json:"tls_enabled"`
+	TLSCertificatePath string   `json:"tls_certificate_path"`
+	TLSPrivateKeyPath  string   `json:"tls_private_key_path"`
+	ACMEDirectory      string   `json:"acme_directory"`
+	ACMECachePath      string   `json:"acme_cache_path"`
+	ACMEChallenge      string   `json:"acme_challenge,omitempty"`
+	ErrorPagePath      string   `json:"error_page_path"`
+	AllowedMethods     []string `json:"allowed_methods"`
+	DeniedMethods      []string `json:"denied_methods"`
+
+	// ACMEStaging obtains certificates from Let's Encrypt's staging
+	// environment, which browsers don't trust, so that TLS can be tried out
+	// without running into the production rate limits. Staging certificates
+	// are cached separately, so they're never served in place of real ones.
+	ACMEStaging bool `json:"acme_staging,omitempty"`
+
+	// RedirectHosts maps extra hosts to the service's host that they should
+	// be permanently redirected to, such as from www.example.com to
+	// example.com.
+	RedirectHosts map[string]string `json:"redirect_hosts,omitempty"`
+
+	// SecurityHeaders adds a preset (strict or relaxed) of security headers to
+	// responses that don't already have them. Individual headers can be
+	// replaced, or removed by overriding them with an empty value.
+	SecurityHeaders         string            `json:"security_headers,omitempty"`
+	ContentSecurityPolicy   string            `json:"content_security_policy,omitempty"`
+	SecurityHeaderOverrides map[string]string `json:"security_header_overrides,omitempty"`
+
+	// SignatureSecret requires requests for the SignedPaths (or all paths, if
+	// none are given) to be signed with this secret. Requests without a valid
+	// signature are rejected before they reach the target.
+	SignatureSecret  string        `json:"signature_secret,omitempty"`
+	SignatureHeader  string        `json:"signature_header,omitempty"`
+	SignatureParam   string        `json:"signature_param,omitempty"`
+	SignatureMaxSkew time.Duration `json:"signature_max_skew,omitempty"`
+	SignedPaths      []string      `json:"signed_paths,omitempty"`
+
+	// OIDCIssuer requires users to sign in with an OpenID Connect provider
+	// before their requests reach the service. Their identity is passed to the
+	// target in the X-Forwarded-User and X-Forwarded-Email headers.
+	OIDCIssuer              string        `json:"oidc_issuer,omitempty"`
+	OIDCClientID            string        `json:"oidc_client_id,omitempty"`
+	OIDCClientSecret        string        `json:"oidc_client_secret,omitempty"`
+	OIDCScopes              []string      `json:"oidc_scopes,omitempty"`
+	OIDCAllowedEmailDomains []string      `json:"oidc_allowed_email_domains,omitempty"`
+	OIDCSessionDuration     time.Duration `json:"oidc_session_duration,omitempty"`
+
+	// SLOAvailability and SLOLatency are the service's objectives, tracked
+	// over SLOWindow. With SLOProtectBudget, non-essential features such as
+	// chaos injection are turned off while an error budget is exhausted.
+	SLOAvailability  float64       `json:"slo_availability,omitempty"`
+	SLOLatency       time.Duration `json:"slo_latency,omitempty"`
+	SLOLatencyTarget float64       `json:"slo_latency_target,omitempty"`
+	SLOWindow        time.Duration `json:"slo_window,omitempty"`
+	SLOProtectBudget bool          `json:"slo_protect_budget,omitempty"`
+
+	// Schedule limits the hours during which the service is open, in its
+	// ScheduleTimezone. Outside of them, requests are answered with the
+	// maintenance page and ScheduleMessage, or, when ScheduleClosed is
+	// "disable", as though the service didn't exist.
+	Schedule         []string `json:"schedule,omitempty"`
+	ScheduleTimezone string   `json:"schedule_timezone,omitempty"`
+	ScheduleClosed   string   `json:"schedule_closed,omitempty"`
+	ScheduleMessage  string   `json:"schedule_message,omitempty"`
+
+	// ExternalAuthURL is a service that is asked whether each request should
+	// be allowed. See ExternalAuthorizer for how it's called.
+	ExternalAuthURL             string        `json:"external_auth_url,omitempty"`
+	ExternalAuthTimeout         time.Duration `json:"external_auth_timeout,omitempty"`
+	ExternalAuthCacheDuration   time.Duration `json:"external_auth_cache_duration,omitempty"`
+	ExternalAuthResponseHeaders []string      `json:"external_auth_response_headers,omitempty"`
+	ExternalAuthCacheKeyHeaders []string      `json:"external_auth_cache_key_headers,omitempty"`
+
+	// Plugins are WebAssembly modules that run as requests pass through the
+	// service, and are given its PluginConfig. See Plugin for how they work.
+	Plugins       []string          `json:"plugins,omitempty"`
+	PluginConfig  map[string]string `json:"plugin_config,omitempty"`
+	PluginTimeout time.Duration     `json:"plugin_timeout,omitempty"`
+
+	// LenientHTTP allows the service's requests through when the proxy is
+	// checking for ambiguous requests, for the sake of legacy clients that
+	// send them. See StrictHTTPListener.
+	LenientHTTP bool `json:"lenient_http,omitempty"`
+
+	// PauseACMEChallenges holds ACME HTTP-01 challenge requests for the
+	// target while the service is paused or stopped, like any other request.
+	// By default they're passed on, so that the target can renew its
+	// certificates during a maintenance window.
+	PauseACMEChallenges bool `json:"pause_acme_challenges,omitempty"`
+
+	// DisableHTTP2 stops HTTP/2 being negotiated with the service's clients,
+	// which use HTTP/1.1 instead, for the sake of those with broken HTTP/2
+	// support.
+	DisableHTTP2 bool `json:"disable_http2,omitempty"`
+
+	// TraceSampleRate is the share, between 0 and 1, of the service's requests
+	// that are traced, when their caller hasn't already decided. Requests with
+	// TraceForceHeader are always traced. See TraceSamplingMiddleware.
+	TraceSampleRate  float64 `json:"trace_sample_rate,omitempty"`
+	TraceForceHeader string  `json:"trace_force_header,omitempty"`
+
+	// MiddlewareOrder changes the order of the service's middleware, from the
+	// outermost in. Any that aren't given follow in their default order. Those
+	// in DisabledMiddleware are skipped, even when their options are set. See
+	// MiddlewareChain.
+	MiddlewareOrder    []string `json:"middleware_order,omitempty"`
+	DisabledMiddleware []string `json:"disabled_middleware,omitempty"`
+
+	// FailoverAddress is a standby host that the service's DNS record (its
+	// FailoverRecord, or else its hosts) is pointed at when all of its targets
+	// have been down for FailoverAfter. See FailoverAnnouncer.
+	FailoverAddress string        `json:"failover_address,omitempty"`
+	FailoverRecord  string        `json:"failover_record,omitempty"`
+	FailoverAfter   time.Duration `json:"failover_after,omitempty"`
+
+	// WellKnownFiles are served in place of the target's response for their
+	// request paths, such as /robots.txt. BlockIndexing asks crawlers not to
+	// index the service at all. See WellKnownMiddleware.
+	WellKnownFiles map[string]string `json:"well_known_files,omitempty"`
+	BlockIndexing  bool              `json:"block_indexing,omitempty"`
+
+	// WebSocketOrigins, WebSocketSubprotocols and MaxWebSocketConnections
+	// limit the WebSockets that clients can open. See WebSocketPolicy.
+	WebSocketOrigins        []string `json:"websocket_origins,omitempty"`
+	WebSocketSubprotocols   []string `json:"websocket_subprotocols,omitempty"`
+	MaxWebSocketConnections int      `json:"max_websocket_connections,omitempty"`
+
+	// TenantHeader and TenantSubdomain identify the tenant that each request
+	// belongs to, for tenants that have their own target. See TenantRouting.
+	TenantHeader    string `json:"tenant_header,omitempty"`
+	TenantSubdomain bool   `json:"tenant_subdomain,omitempty"`
+
+	// Pool names the target pool whose addresses the service's requests are
+	// balanced across, in place of any discovered for it. See TargetPools.
+	Pool string `json:"pool,omitempty"`
+
+	DeployAnnotationPeriod      time.Duration `json:"deploy_annotation_period"`
+	BlockInformationalResponses bool          `json:"block_informational_responses"`
+
+	TTL                   time.Duration `json:"ttl"`
+	TTLRemoveCertificates bool          `json:"ttl_remove_certificates"`
+}
+
+func (so ServiceOptions) traceSamplingConfig() TraceSamplingConfig {
+	return TraceSamplingConfig{
+		SampleRate:  so.TraceSampleRate,
+		ForceHeader: so.TraceForceHeader,
+	}
+}
+
+func (so ServiceOptions) tenantRouting() TenantRouting {
+	return TenantRouting{Header: so.TenantHeader, Subdomain: so.TenantSubdomain}
+}
+
+func (so ServiceOptions) webSocketPolicy() WebSocketPolicy {
+	return WebSocketPolicy{
+		AllowedOrigins: so.WebSocketOrigins,
+		Subprotocols:   so.WebSocketSubprotocols,
+		MaxConnections: so.MaxWebSocketConnections,
+	}
+}
+
+func (so ServiceOptions) sloConfig() SLOConfig {
+	return SLOConfig{
+		Availability:  so.SLOAvailability,
+		Latency:       so.SLOLatency,
+		LatencyTarget: so.SLOLatencyTarget,
+		Window:        so.SLOWindow,
+	}
+}
+
+func (so ServiceOptions) redirectSources() []string {
+	return slices.Sorted(maps.Keys(so.RedirectHosts))
 }
 
 func (so ServiceOptions) ScopedCachePath() string {
@@ -79,6 +261,10 @@ func (so ServiceOptions) ScopedCachePath() string {
 	// certificates between deployments when the settings are the same, but
 	// provision new certificates when they change.
 
+	if so.acmeStaging() {
+		return path.Join(so.ACMECachePath, "staging")
+	}
+
 	hasher := sha256.New()
 	hasher.Write([]byte(so.ACMEDirectory))
 	hash := hex.EncodeToString(hasher.Sum(nil))
@@ -86,6 +272,23 @@ func (so ServiceOptions) ScopedCachePath() string {
 	return path.Join(so.ACMECachePath, hash)
 }
 
+// UsesACMEStaging reports whether the service's certificates come from the
+// Let's Encrypt staging environment.
+func (so ServiceOptions) UsesACMEStaging() bool {
+	return so.TLSEnabled && so.TLSCertificatePath == "" && so.acmeStaging()
+}
+
+func (so ServiceOptions) acmeStaging() bool {
+	return so.ACMEStaging || so.ACMEDirectory == ACMEStagingDirectoryURL
+}
+
+func (so ServiceOptions) acmeDirectory() string {
+	if so.ACMEStaging {
+		return ACMEStagingDirectoryURL
+	}
+	return so.ACMEDirectory
+}
+
 type Service struct {
 	name    string
 	hosts   []string
@@ -93,18 +296,42 @@ type Service struct {
 
 	active     *Target
 	rollout    *Target
+	tenants    map[string]*Target
 	targetLock sync.RWMutex
 
 	pauseController   *PauseController
 	rolloutController *RolloutController
+	chaosController   *ChaosController
 	certManager       CertManager
+	oidc              *OIDCAuthenticator
+	externalAuth      *ExternalAuthorizer
+	schedule          *Schedule
+	slo               *SLOTracker
+	plugins           *PluginHost
 	middleware        http.Handler
+	stats             *ServiceStats
+	transfer          *ServiceTransferStats
+	websockets        *WebSocketConnections
+	cutover           atomic.Pointer[deployCutover]
+	expiresAt         time.Time
+}
+
+// deployCutover describes the most recent switch of a service's active target,
+// so that the requests which follow it can be attributed to that deployment.
+type deployCutover struct {
+	ID             string
+	PreviousTarget string
+	Until          time.Time
 }
 
 func NewService(name string, hosts []string, options ServiceOptions) (*Service, error) {
 	service := &Service{
 		name:            name,
 		pauseController: NewPauseController(),
+		stats:           NewServiceStats(),
+		transfer:        NewServiceTransferStats(name),
+		websockets:      &WebSocketConnections{},
+		tenants:         map[string]*Target{},
 	}
 
 	err := service.initialize(hosts, options)
@@ -119,6 +346,41 @@ func (s *Service) UpdateOptions(hosts []string, options ServiceOptions) error {
 	return s.initialize(hosts, options)
 }
 
+// Unchanged reports whether deploying a target with these hosts and options
+// would leave the service as it is. Options are compared as they're saved in
+// the state.
+func (s *Service) Unchanged(hosts []string, targetURL string, options ServiceOptions, targetOptions TargetOptions) bool {
+	return slices.Equal(s.hosts, hosts) && sameJSON(s.options, options) && s.TargetUnchanged(targetURL, targetOptions)
+}
+
+// TargetUnchanged reports whether the service's active target is the one
+// given, with the same options, so that it doesn't need to be deployed again.
+func (s *Service) TargetUnchanged(targetURL string, targetOptions TargetOptions) bool {
+	active := s.ActiveTarget()
+	return active != nil && active.Target() == targetURL && sameJSON(active.options, targetOptions.canonicalized())
+}
+
+func (s *Service) DeployedOptions() DeployedOptions {
+	active := s.ActiveTarget()
+	return DeployedOptions{
+		Target:        active.Target(),
+		Hosts:         s.hosts,
+		Options:       s.options,
+		TargetOptions: active.options,
+	}
+}
+
+// OptionChanges lists the options that deploying a target with these hosts
+// and options would change.
+func (s *Service) OptionChanges(hosts []string, options ServiceOptions, targetOptions TargetOptions) []OptionChange {
+	active := s.ActiveTarget()
+	if active == nil {
+		return nil
+	}
+
+	return diffDeployOptions(s.hosts, hosts, s.options, options, active.options, targetOptions.canonicalized())
+}
+
 func (s *Service) ActiveTarget() *Target {
 	s.targetLock.RLock()
 	defer s.targetLock.RUnlock()
@@ -138,7 +400,9 @@ func (s *Service) ClaimTarget(req *http.Request) (*Target, *http.Request, error)
 	defer s.targetLock.RUnlock()
 
 	target := s.active
-	if s.rollout != nil && s.rolloutController != nil && s.rolloutController.RequestUsesRolloutGroup(req) {
+	if tenantTarget := s.tenantTarget(req); tenantTarget != nil {
+		target = tenantTarget
+	} else if s.rollout != nil && s.rolloutController != nil && s.rolloutController.RequestUsesRolloutGroup(req) {
 		slog.Debug("Using rollout target for request", "service", s.name, "path", req.URL.Path)
 		target = s.rollout
 	}
@@ -158,6 +422,10 @@ func (s *Service) SetTarget(slot TargetSlot, target *Target, drainTimeout time.D
 		replaced = s.active
 		s.active = target
 
+		if replaced != nil && target != nil {
+			s.recordCutover(replaced)
+		}
+
 	case TargetSlotRollout:
 		replaced = s.rollout
 		s.rollout = target
@@ -166,7 +434,47 @@ func (s *Service) SetTarget(slot TargetSlot, target *Target, drainTimeout time.D
 	if replaced != nil {
 		replaced.StopHealthChecks()
 		replaced.Drain(drainTimeout)
+		replaced.StopResolving()
+	}
+}
+
+// detachTargets takes all of the service's targets out of it, as the service
+// is removed, and returns them so that they can be retired.
+func (s *Service) detachTargets() []*Target {
+	s.targetLock.Lock()
+	defer s.targetLock.Unlock()
+
+	targets := []*Target{}
+	for _, target := range []*Target{s.active, s.rollout} {
+		if target != nil {
+			targets = append(targets, target)
+		}
+	}
+	for tenant, target := range s.tenants {
+		slog.Info("Removed tenant target", "service", s.name, "tenant", tenant)
+		targets = append(targets, target)
+	}
+
+	s.active = nil
+	s.rollout = nil
+	s.tenants = map[string]*Target{}
+
+	return targets
+}
+
+// retireTargets stops the targets, draining them all at the same time.
+func retireTargets(targets []*Target, drainTimeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			target.StopHealthChecks()
+			target.Drain(drainTimeout)
+			target.StopResolving()
+		}()
 	}
+	wg.Wait()
 }
 
 func (s *Service) SetRolloutSplit(percentage int, allowlist []string) error {
@@ -191,6 +499,39 @@ func (s *Service) StopRollout() error {
 	return nil
 }
 
+func (s *Service) SetChaos(percentage int, latency time.Duration, errorStatus int, duration time.Duration) error {
+	chaosController, err := NewChaosController(percentage, latency, errorStatus, duration)
+	if err != nil {
+		return err
+	}
+
+	s.targetLock.Lock()
+	defer s.targetLock.Unlock()
+
+	s.chaosController = chaosController
+	slog.Info("Started chaos", "service", s.name, "percentage", percentage, "latency", latency, "error_status", errorStatus, "expires_at", chaosController.ExpiresAt)
+	return nil
+}
+
+func (s *Service) StopChaos() error {
+	s.targetLock.Lock()
+	defer s.targetLock.Unlock()
+
+	s.chaosController = nil
+	slog.Info("Stopped chaos", "service", s.name)
+	return nil
+}
+
+func (s *Service) Stats() ServiceStatsSnapshot {
+	snapshot := s.stats.Snapshot()
+	snapshot.Transfer = s.transfer.Snapshot()
+	if s.slo != nil {
+		slo := s.slo.Snapshot()
+		snapshot.SLO = &slo
+	}
+	return snapshot
+}
+
 func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.middleware.ServeHTTP(w, r)
 }
@@ -204,6 +545,10 @@ type marshalledService struct {
 	TargetOptions     TargetOptions      `json:"target_options"`
 	PauseController   *PauseController   `json:"pause_controller"`
 	RolloutController *RolloutController `json:"rollout_controller"`
+	ChaosController   *ChaosController   `json:"chaos_controller"`
+	ExpiresAt         time.Time          `json:"expires_at"`
+
+	TenantTargets map[string]string `json:"tenant_targets,omitempty"`
 }
 
 func (s *Service) MarshalJSON() ([]byte, error) {
@@ -223,6 +568,10 @@ func (s *Service) MarshalJSON() ([]byte, error) {
 		TargetOptions:     targetOptions,
 		PauseController:   s.pauseController,
 		RolloutController: s.rolloutController,
+		ChaosController:   s.chaosController,
+		ExpiresAt:         s.expiresAt,
+
+		TenantTargets: s.TenantTargets(),
 	})
 }
 
@@ -236,71 +585,191 @@ func (s *Service) UnmarshalJSON(data []byte) error {
 	s.name = ms.Name
 	s.pauseController = ms.PauseController
 	s.rolloutController = ms.RolloutController
+	s.chaosController = ms.ChaosController
+	s.stats = NewServiceStats()
+	s.transfer = NewServiceTransferStats(ms.Name)
+	s.websockets = &WebSocketConnections{}
 
 	s.initialize(ms.Hosts, ms.Options)
+	s.expiresAt = ms.ExpiresAt
 	s.restoreSavedTarget(TargetSlotActive, ms.ActiveTarget, ms.TargetOptions)
 	s.restoreSavedTarget(TargetSlotRollout, ms.RolloutTarget, ms.TargetOptions)
+	s.restoreTenantTargets(ms.TenantTargets, ms.TargetOptions)
 
 	return nil
 }
 
+// Expired reports whether the service has outlived the TTL it was deployed
+// with.
+func (s *Service) Expired(now time.Time) bool {
+	return !s.expiresAt.IsZero() && now.After(s.expiresAt)
+}
+
+// RemoveCertificates deletes any certificates that were obtained for the
+// service's hosts from the ACME cache. Certificates that were provided to us
+// are left alone.
+func (s *Service) RemoveCertificates() error {
+	return s.removeCertificates(func(string) bool { return false })
+}
+
 func (s *Service) Stop(drainTimeout time.Duration, message string) error {
 	err := s.pauseController.Stop(message)
 	if err != nil {
 		return err
 	}
+	s.pauseController.SetDrainTimeout(drainTimeout)
 
 	slog.Info("Service stopped", "service", s.name)
 
-	s.ActiveTarget().Drain(drainTimeout)
+	s.drainTargets(func(target *Target) { target.Drain(drainTimeout) })
 	slog.Info("Service drained", "service", s.name)
 	return nil
 }
 
-func (s *Service) Pause(drainTimeout time.Duration, pauseTimeout time.Duration) error {
-	err := s.pauseController.Pause(pauseTimeout)
+func (s *Service) Pause(drainTimeout time.Duration, pauseTimeout time.Duration, exceptPaths []string, readOnly bool) error {
+	err := s.pauseController.Pause(pauseTimeout, exceptPaths, readOnly)
 	if err != nil {
 		return err
 	}
+	s.pauseController.SetDrainTimeout(drainTimeout)
 
-	slog.Info("Service paused", "service", s.name)
+	slog.Info("Service paused", "service", s.name, "read_only", readOnly)
 
-	s.ActiveTarget().Drain(drainTimeout)
+	if readOnly {
+		s.drainTargets(func(target *Target) { target.DrainWrites(drainTimeout) })
+	} else {
+		s.drainTargets(func(target *Target) { target.Drain(drainTimeout) })
+	}
 	slog.Info("Service drained", "service", s.name)
 	return nil
 }
 
-func (s *Service) Resume() error {
-	err := s.pauseController.Resume()
+// Resume lets requests through to the target again. Those that were held
+// while paused are let through at most releaseConcurrency at a time, so as
+// not to overwhelm the target, or all at once when it's 0.
+func (s *Service) Resume(releaseConcurrency int) error {
+	err := s.pauseController.ResumeReleasing(releaseConcurrency)
 	if err != nil {
 		return err
 	}
 
-	slog.Info("Service resumed", "service", s.name)
+	slog.Info("Service resumed", "service", s.name, "release_concurrency", releaseConcurrency)
 	return nil
 }
 
 // Private
 
+// drainTargets drains the active target and those of any tenants, at the same
+// time, so that pausing or stopping takes no longer than draining one target.
+func (s *Service) drainTargets(drain func(target *Target)) {
+	var wg sync.WaitGroup
+	for _, target := range append([]*Target{s.ActiveTarget()}, s.tenantTargetList()...) {
+		if target == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			drain(target)
+		}()
+	}
+	wg.Wait()
+}
+
+// removeCertificates removes the service's certificates, except for those of
+// the hosts that should be kept.
+func (s *Service) removeCertificates(keep func(host string) bool) error {
+	if !s.options.TLSEnabled || s.options.TLSCertificatePath != "" {
+		return nil
+	}
+
+	for _, host := range slices.Concat(s.hosts, s.options.redirectSources()) {
+		if keep(host) {
+			continue
+		}
+
+		_, err := deleteCachedCertificate(s.options.ScopedCachePath(), host)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (s *Service) initialize(hosts []string, options ServiceOptions) error {
-	certManager, err := s.createCertManager(hosts, options)
+	err := validateRedirectHosts(hosts, options.RedirectHosts)
+	if err != nil {
+		return err
+	}
+
+	err = validateFailover(hosts, options)
+	if err != nil {
+		return err
+	}
+
+	certManager, err := s.createCertManager(slices.Concat(hosts, options.redirectSources()), options)
+	if err != nil {
+		return err
+	}
+
+	oidc, err := s.createOIDCAuthenticator(options)
+	if err != nil {
+		return err
+	}
+
+	slo, err := s.createSLOTracker(options)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := s.createSchedule(options)
+	if err != nil {
+		return err
+	}
+
+	externalAuth, err := s.createExternalAuthorizer(options)
+	if err != nil {
+		return err
+	}
+
+	plugins, err := s.createPluginHost(options)
 	if err != nil {
 		return err
 	}
 
 	middleware, err := s.createMiddleware(options, certManager)
 	if err != nil {
+		plugins.Close()
 		return err
 	}
 
 	s.hosts = hosts
 	s.options = options
 	s.certManager = certManager
+	s.oidc = oidc
+	s.externalAuth = externalAuth
+	s.schedule = schedule
+	s.slo = slo
+	sloMetrics.Track(s.name, slo)
+	s.plugins.Close()
+	s.plugins = plugins
 	s.middleware = middleware
 
+	s.restartTTL()
+
 	return nil
 }
 
+// restartTTL restarts the service's time to live, as each deployment does.
+func (s *Service) restartTTL() {
+	s.expiresAt = time.Time{}
+	if s.options.TTL > 0 {
+		s.expiresAt = time.Now().Add(s.options.TTL)
+	}
+}
+
 func (s *Service) createCertManager(hosts []string, options ServiceOptions) (CertManager, error) {
 	if !options.TLSEnabled {
 		return nil, nil
@@ -310,6 +779,12 @@ func (s *Service) createCertManager(hosts []string, options ServiceOptions) (Cer
 		return NewStaticCertManager(options.TLSCertificatePath, options.TLSPrivateKeyPath)
 	}
 
+	switch options.ACMEChallenge {
+	case "", ACMEChallengeAny, ACMEChallengeTLSALPN:
+	default:
+		return nil, ErrorUnknownACMEChallenge
+	}
+
 	// Ensure we're not trying to use Let's Encrypt to fetch a wildcard domain,
 	// as that is not supported with the challenge types that we use.
 	for _, host := range hosts {
@@ -322,34 +797,205 @@ func (s *Service) createCertManager(hosts []string, options ServiceOptions) (Cer
 		Prompt:     autocert.AcceptTOS,
 		Cache:      autocert.DirCache(options.ScopedCachePath()),
 		HostPolicy: autocert.HostWhitelist(hosts...),
-		Client:     &acme.Client{DirectoryURL: options.ACMEDirectory},
+		Client:     &acme.Client{DirectoryURL: options.acmeDirectory()},
 	}, nil
 }
 
-func (s *Service) createMiddleware(options ServiceOptions, certManager CertManager) (http.Handler, error) {
-	var err error
-	var handler http.Handler = http.HandlerFunc(s.serviceRequestWithTarget)
+func (s *Service) createOIDCAuthenticator(options ServiceOptions) (*OIDCAuthenticator, error) {
+	if options.OIDCIssuer == "" && options.OIDCClientID == "" && options.OIDCClientSecret == "" {
+		return nil, nil
+	}
 
-	if options.ErrorPagePath != "" {
-		slog.Debug("Using custom error pages", "service", s.name, "path", options.ErrorPagePath)
-		errorPageFS := os.DirFS(options.ErrorPagePath)
-		handler, err = WithErrorPageMiddleware(errorPageFS, false, handler)
-		if err != nil {
-			slog.Error("Unable to parse custom error pages", "service", s.name, "path", options.ErrorPagePath, "error", err)
-			return nil, ErrorUnableToLoadErrorPages
-		}
+	return NewOIDCAuthenticator(OIDCConfig{
+		Issuer:              options.OIDCIssuer,
+		ClientID:            options.OIDCClientID,
+		ClientSecret:        options.OIDCClientSecret,
+		Scopes:              options.OIDCScopes,
+		AllowedEmailDomains: options.OIDCAllowedEmailDomains,
+		SessionDuration:     options.OIDCSessionDuration,
+	})
+}
+
+func (s *Service) createSLOTracker(options ServiceOptions) (*SLOTracker, error) {
+	config := options.sloConfig()
+	if !config.Enabled() {
+		return nil, nil
+	}
+
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep measuring across deployments, unless the objectives have changed.
+	if s.slo != nil && s.options.sloConfig() == config {
+		return s.slo, nil
+	}
+	return NewSLOTracker(config), nil
+}
+
+func (s *Service) createSchedule(options ServiceOptions) (*Schedule, error) {
+	if len(options.Schedule) == 0 {
+		return nil, nil
+	}
+
+	switch options.ScheduleClosed {
+	case "", ScheduleClosedMaintenance, ScheduleClosedDisable:
+	default:
+		return nil, ErrorUnknownScheduleClosed
+	}
+
+	return ParseSchedule(options.Schedule, options.ScheduleTimezone)
+}
+
+func (s *Service) createExternalAuthorizer(options ServiceOptions) (*ExternalAuthorizer, error) {
+	if options.ExternalAuthURL == "" {
+		return nil, nil
+	}
+
+	return NewExternalAuthorizer(ExternalAuthConfig{
+		URL:             options.ExternalAuthURL,
+		Timeout:         options.ExternalAuthTimeout,
+		CacheDuration:   options.ExternalAuthCacheDuration,
+		ResponseHeaders: options.ExternalAuthResponseHeaders,
+		CacheKeyHeaders: options.ExternalAuthCacheKeyHeaders,
+	})
+}
+
+func (s *Service) createPluginHost(options ServiceOptions) (*PluginHost, error) {
+	if len(options.Plugins) == 0 {
+		return nil, nil
+	}
+
+	return NewPluginHost(s.name, options.Plugins, options.PluginConfig, options.PluginTimeout)
+}
+
+// closePlugins releases the service's plugins once it has been removed.
+func (s *Service) closePlugins() {
+	s.plugins.Close()
+}
+
+func (s *Service) createMiddleware(options ServiceOptions, certManager CertManager) (http.Handler, error) {
+	chain, err := options.middlewareChain()
+	if err != nil {
+		return nil, err
 	}
 
-	if certManager != nil {
-		slog.Debug("Using ACME handler", "service", s.name)
-		handler = certManager.HTTPHandler(handler)
+	wrappers := map[string]middlewareWrapper{
+		MiddlewareInformationalResponses: func(handler http.Handler) (http.Handler, error) {
+			if options.BlockInformationalResponses {
+				handler = WithInformationalResponseMiddleware(handler)
+			}
+			return handler, nil
+		},
+
+		MiddlewareWebSocketPolicy: func(handler http.Handler) (http.Handler, error) {
+			if policy := options.webSocketPolicy(); policy.Enabled() {
+				err := policy.Validate()
+				if err != nil {
+					return nil, err
+				}
+				handler = WithWebSocketPolicyMiddleware(policy, s.websockets, s.name, handler)
+			}
+			return handler, nil
+		},
+
+		MiddlewareMethodRestriction: func(handler http.Handler) (http.Handler, error) {
+			if len(options.AllowedMethods) > 0 || len(options.DeniedMethods) > 0 {
+				handler = WithMethodRestrictionMiddleware(options.AllowedMethods, options.DeniedMethods, handler)
+			}
+			return handler, nil
+		},
+
+		MiddlewareWellKnown: func(handler http.Handler) (http.Handler, error) {
+			if len(options.WellKnownFiles) > 0 || options.BlockIndexing {
+				return WithWellKnownMiddleware(options.WellKnownFiles, options.BlockIndexing, handler)
+			}
+			return handler, nil
+		},
+
+		MiddlewareSignature: func(handler http.Handler) (http.Handler, error) {
+			if options.SignatureSecret != "" {
+				handler = WithSignatureMiddleware(SignatureConfig{
+					Secret:  options.SignatureSecret,
+					Header:  options.SignatureHeader,
+					Param:   options.SignatureParam,
+					MaxSkew: options.SignatureMaxSkew,
+					Paths:   options.SignedPaths,
+				}, handler)
+			}
+			return handler, nil
+		},
+
+		MiddlewareErrorPages: func(handler http.Handler) (http.Handler, error) {
+			if options.ErrorPagePath == "" {
+				return handler, nil
+			}
+
+			slog.Debug("Using custom error pages", "service", s.name, "path", options.ErrorPagePath)
+			errorPageFS := os.DirFS(options.ErrorPagePath)
+			handler, err := WithErrorPageMiddleware(errorPageFS, false, handler)
+			if err != nil {
+				slog.Error("Unable to parse custom error pages", "service", s.name, "path", options.ErrorPagePath, "error", err)
+				return nil, ErrorUnableToLoadErrorPages
+			}
+			return handler, nil
+		},
+
+		MiddlewareSecurityHeaders: func(handler http.Handler) (http.Handler, error) {
+			securityHeaders, err := SecurityHeaders(options.SecurityHeaders, options.ContentSecurityPolicy, options.SecurityHeaderOverrides)
+			if err != nil {
+				return nil, err
+			}
+			if len(securityHeaders) > 0 {
+				handler = WithSecurityHeadersMiddleware(securityHeaders, handler)
+			}
+			return handler, nil
+		},
+
+		MiddlewareTraceSampling: func(handler http.Handler) (http.Handler, error) {
+			if tracing := options.traceSamplingConfig(); tracing.Enabled() {
+				err := tracing.Validate()
+				if err != nil {
+					return nil, err
+				}
+				handler = WithTraceSamplingMiddleware(tracing, handler)
+			}
+			return handler, nil
+		},
+
+		// The HTTP-01 challenge is answered on the HTTP listener. Without it,
+		// certificates are obtained using only TLS-ALPN-01 on the HTTPS listener.
+		MiddlewareACME: func(handler http.Handler) (http.Handler, error) {
+			if certManager != nil && options.ACMEChallenge != ACMEChallengeTLSALPN {
+				slog.Debug("Using ACME handler", "service", s.name)
+				handler = certManager.HTTPHandler(handler)
+			}
+			return handler, nil
+		},
 	}
 
-	return handler, nil
+	return wrapMiddleware(http.HandlerFunc(s.serviceRequestWithTarget), chain, wrappers)
 }
 
 func (s *Service) serviceRequestWithTarget(w http.ResponseWriter, r *http.Request) {
 	LoggingRequestContext(r).Service = s.name
+	LoggingRequestContext(r).Stats = s.stats
+	LoggingRequestContext(r).SLO = s.slo
+	LoggingRequestContext(r).Transfer = s.transfer
+	clientConnectionFromContext(r.Context()).Attach(s.transfer)
+	s.annotateCutover(r)
+
+	// Responses we make ourselves are logged too, so the query allowlist has
+	// to be in place before any of them; SendRequest sets it again for the
+	// target that the request is eventually sent to.
+	if active := s.ActiveTarget(); active != nil {
+		LoggingRequestContext(r).QueryParams = active.options.LogQueryParams
+	}
+
+	if s.redirectHost(w, r) {
+		return
+	}
 
 	if s.options.TLSEnabled && r.TLS == nil {
 		s.redirectToHTTPS(w, r)
@@ -361,7 +1007,35 @@ func (s *Service) serviceRequestWithTarget(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if s.handlePausedAndStoppedRequests(w, r) {
+	if !s.ActiveTarget().HealthCheckPathAllowed(r) {
+		slog.Debug("Rejecting health check request", "service", s.name, "remote_addr", r.RemoteAddr)
+		SetErrorResponse(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	if s.plugins != nil && s.plugins.RequestReceived(w, r) {
+		return
+	}
+
+	handled, release := s.handlePausedAndStoppedRequests(w, r)
+	defer release()
+	if handled {
+		return
+	}
+
+	if s.handleClosedRequests(w, r) {
+		return
+	}
+
+	if s.oidc != nil && s.oidc.Authenticate(w, r) {
+		return
+	}
+
+	if s.externalAuth != nil && s.externalAuth.Authorize(w, r) {
+		return
+	}
+
+	if s.injectChaos(w, r) {
 		return
 	}
 
@@ -371,33 +1045,107 @@ func (s *Service) serviceRequestWithTarget(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if s.plugins != nil {
+		if s.plugins.BeforeUpstream(w, req, target.Target()) {
+			target.endInflightRequest(req)
+			return
+		}
+		w = s.plugins.WrapResponseWriter(w, req, target.Target())
+	}
+
 	target.SendRequest(w, req)
 }
 
-func (s *Service) handlePausedAndStoppedRequests(w http.ResponseWriter, r *http.Request) bool {
+// handlePausedAndStoppedRequests reports whether it has answered the request.
+// When it hasn't, the returned function must be called once the request has
+// finished, to let through any others that were held along with it.
+func (s *Service) handlePausedAndStoppedRequests(w http.ResponseWriter, r *http.Request) (bool, func()) {
 	if s.pauseController.GetState() != PauseStateRunning && s.ActiveTarget().IsHealthCheckRequest(r) {
 		// When paused or stopped, return success for any health check
 		// requests from downstream services. Otherwise, they might consider
 		// us as unhealthy while in that state, and remove us from their
 		// pool.
 		w.WriteHeader(http.StatusOK)
-		return true
+		return true, func() {}
+	}
+
+	if s.pauseController.GetState() != PauseStateRunning && isACMEChallengeRequest(r) && !s.options.PauseACMEChallenges {
+		// ACME HTTP-01 challenges must still be answered, or certificates
+		// could fail to renew during a long maintenance window. Those for
+		// certificates we manage have already been answered by the ACME
+		// handler, so any that reach here are for the target's own.
+		return false, func() {}
+	}
+
+	if s.pauseController.IsExempt(r) {
+		return false, func() {}
+	}
+
+	priority := PriorityNormal
+	if s.pauseController.GetState() == PauseStatePaused {
+		priority = s.ActiveTarget().RequestPriority(r)
 	}
 
-	action, message := s.pauseController.Wait()
+	action, message, release := s.pauseController.Hold(r.Context(), priority)
 	switch action {
 	case PauseWaitActionStopped:
 		templateArguments := struct{ Message string }{message}
 		SetErrorResponse(w, r, http.StatusServiceUnavailable, templateArguments)
-		return true
+		return true, func() {}
 
 	case PauseWaitActionTimedOut:
 		slog.Warn("Rejecting request due to expired pause", "service", s.name, "path", r.URL.Path)
 		SetErrorResponse(w, r, http.StatusGatewayTimeout, nil)
+		return true, func() {}
+	}
+
+	// Upgraded connections, such as WebSockets, can stay open indefinitely,
+	// so they take their turn to be released, but don't keep their place.
+	if r.Header.Get("Upgrade") != "" {
+		release()
+		return false, func() {}
+	}
+
+	return false, release
+}
+
+func (s *Service) handleClosedRequests(w http.ResponseWriter, r *http.Request) bool {
+	if s.schedule == nil || s.schedule.IsOpen(time.Now()) {
+		return false
+	}
+
+	if s.options.ScheduleClosed == ScheduleClosedDisable {
+		SetErrorResponse(w, r, http.StatusNotFound, nil)
+		return true
+	}
+
+	if s.ActiveTarget().IsHealthCheckRequest(r) {
+		// As when paused, keep downstream health checks passing, so that the
+		// service isn't removed from their pools while it's closed.
+		w.WriteHeader(http.StatusOK)
 		return true
 	}
 
-	return false
+	templateArguments := struct{ Message string }{s.options.ScheduleMessage}
+	SetErrorResponse(w, r, http.StatusServiceUnavailable, templateArguments)
+	return true
+}
+
+func (s *Service) injectChaos(w http.ResponseWriter, r *http.Request) bool {
+	s.targetLock.RLock()
+	chaosController := s.chaosController
+	s.targetLock.RUnlock()
+
+	if chaosController == nil {
+		return false
+	}
+
+	if s.options.SLOProtectBudget && s.slo != nil && s.slo.Exhausted() {
+		slog.Debug("Skipping chaos injection while error budget is exhausted", "service", s.name)
+		return false
+	}
+
+	return chaosController.Inject(w, r)
 }
 
 func (s *Service) restoreSavedTarget(slot TargetSlot, savedTarget string, options TargetOptions) error {
@@ -425,6 +1173,59 @@ func (s *Service) restoreSavedTarget(slot TargetSlot, savedTarget string, option
 	return nil
 }
 
+func (s *Service) recordCutover(previous *Target) {
+	if s.options.DeployAnnotationPeriod <= 0 {
+		return
+	}
+
+	cutover := &deployCutover{
+		ID:             uuid.New().String(),
+		PreviousTarget: previous.Target(),
+		Until:          time.Now().Add(s.options.DeployAnnotationPeriod),
+	}
+	s.cutover.Store(cutover)
+
+	// Each deployment adds its own metric series. Requests are only counted
+	// against it until the period ends, after which its series are kept for
+	// one more period, so that they can be scraped, and then deleted.
+	time.AfterFunc(2*s.options.DeployAnnotationPeriod, func() {
+		deleteDeployMetrics(s.name, cutover.ID)
+	})
+
+	slog.Info("Target switched", "service", s.name, "deploy_id", cutover.ID, "previous_target", cutover.PreviousTarget)
+}
+
+func (s *Service) annotateCutover(r *http.Request) {
+	cutover := s.cutover.Load()
+	if cutover == nil || time.Now().After(cutover.Until) {
+		return
+	}
+
+	LoggingRequestContext(r).DeployID = cutover.ID
+	LoggingRequestContext(r).PreviousTarget = cutover.PreviousTarget
+	LoggingRequestContext(r).DeployUntil = cutover.Until
+}
+
+func (s *Service) redirectHost(w http.ResponseWriter, r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	to, ok := s.options.RedirectHosts[host]
+	if !ok {
+		return false
+	}
+
+	scheme := "http"
+	if s.options.TLSEnabled || r.TLS != nil {
+		scheme = "https"
+	}
+
+	http.Redirect(w, r, scheme+"://"+to+r.URL.RequestURI(), http.StatusMovedPermanently)
+	return true
+}
+
 func (s *Service) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "close")
 
@@ -436,3 +1237,28 @@ func (s *Service) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
 	url := "https://" + host + r.URL.RequestURI()
 	http.Redirect(w, r, url, http.StatusMovedPermanently)
 }
+
+// routedHosts returns every host that should be routed to a service,
+// including those that are only redirected. A service without hosts is the
+// default, and is routed to by the empty host.
+func routedHosts(hosts []string, options ServiceOptions) []string {
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	return slices.Concat(hosts, options.redirectSources())
+}
+
+func validateRedirectHosts(hosts []string, redirects map[string]string) error {
+	for from, to := range redirects {
+		if from == "" || slices.Contains(hosts, from) || !slices.Contains(hosts, to) {
+			return fmt.Errorf("%w: %s=%s", ErrorInvalidRedirectHost, from, to)
+		}
+	}
+	return nil
+}
+
+func sameJSON(a, b any) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
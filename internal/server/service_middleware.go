@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+const (
+	MiddlewareACME                   = "acme"
+	MiddlewareTraceSampling          = "trace_sampling"
+	MiddlewareSecurityHeaders        = "security_headers"
+	MiddlewareErrorPages             = "error_pages"
+	MiddlewareWellKnown              = "well_known"
+	MiddlewareSignature              = "signature"
+	MiddlewareMethodRestriction      = "method_restriction"
+	MiddlewareWebSocketPolicy        = "websocket_policy"
+	MiddlewareInformationalResponses = "informational_responses"
+)
+
+// DefaultMiddlewareOrder is the order in which a service's middleware
+// handles requests, from the outermost to the one closest to the target.
+var DefaultMiddlewareOrder = []string{
+	MiddlewareACME,
+	MiddlewareTraceSampling,
+	MiddlewareSecurityHeaders,
+	MiddlewareErrorPages,
+	MiddlewareWellKnown,
+	MiddlewareSignature,
+	MiddlewareMethodRestriction,
+	MiddlewareWebSocketPolicy,
+	MiddlewareInformationalResponses,
+}
+
+var (
+	ErrorUnknownMiddleware      = errors.New("unknown middleware")
+	ErrorDuplicateMiddleware    = errors.New("middleware is listed more than once")
+	ErrorIncompatibleMiddleware = errors.New("incompatible middleware configuration")
+)
+
+// middlewareWrapper adds a middleware to a handler. Those that have nothing
+// to do with the service's options return the handler unchanged.
+type middlewareWrapper func(http.Handler) (http.Handler, error)
+
+// MiddlewareChain returns the service middleware that handles requests, from
+// the outermost in. Any that are given in order come first, in that order,
+// followed by the rest in their default order, and any that are disabled are
+// left out.
+func MiddlewareChain(order, disabled []string) ([]string, error) {
+	for _, name := range slices.Concat(order, disabled) {
+		if !slices.Contains(DefaultMiddlewareOrder, name) {
+			return nil, fmt.Errorf("%w: %s", ErrorUnknownMiddleware, name)
+		}
+	}
+
+	for i, name := range order {
+		if slices.Contains(order[:i], name) {
+			return nil, fmt.Errorf("%w: %s", ErrorDuplicateMiddleware, name)
+		}
+		if slices.Contains(disabled, name) {
+			return nil, fmt.Errorf("%w: %s is both ordered and disabled", ErrorIncompatibleMiddleware, name)
+		}
+	}
+
+	chain := slices.Clone(order)
+	for _, name := range DefaultMiddlewareOrder {
+		if !slices.Contains(chain, name) && !slices.Contains(disabled, name) {
+			chain = append(chain, name)
+		}
+	}
+
+	return chain, nil
+}
+
+// Private
+
+func (so ServiceOptions) middlewareChain() ([]string, error) {
+	chain, err := MiddlewareChain(so.MiddlewareOrder, so.DisabledMiddleware)
+	if err != nil {
+		return nil, err
+	}
+
+	// ACME challenge requests carry no signature, and may use methods that
+	// the service doesn't allow, so they have to be answered before either
+	// of those middlewares could reject them.
+	if acme := slices.Index(chain, MiddlewareACME); acme >= 0 && so.answersHTTPChallenges() {
+		for _, name := range []string{MiddlewareSignature, MiddlewareMethodRestriction} {
+			if index := slices.Index(chain, name); index >= 0 && index < acme {
+				return nil, fmt.Errorf("%w: %s must come after %s", ErrorIncompatibleMiddleware, name, MiddlewareACME)
+			}
+		}
+	}
+
+	return chain, nil
+}
+
+func (so ServiceOptions) answersHTTPChallenges() bool {
+	return so.TLSEnabled && so.TLSCertificatePath == "" && so.ACMEChallenge != ACMEChallengeTLSALPN
+}
+
+func wrapMiddleware(handler http.Handler, chain []string, wrappers map[string]middlewareWrapper) (http.Handler, error) {
+	var err error
+	for _, name := range slices.Backward(chain) {
+		handler, err = wrappers[name](handler)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return handler, nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	ServiceStatsWindow = 60 * time.Second
+
+	statsLatencyBucketCount = 48
+	statsLatencyBucketBase  = 100 * time.Microsecond
+	statsMaxPathsPerSlot    = 256
+	statsOtherPaths         = "(other)"
+	statsTopPathCount       = 5
+)
+
+// ServiceStats keeps rolling request statistics for a service, using a ring
+// buffer of one-second slots covering ServiceStatsWindow.
+type ServiceStats struct {
+	slots []statsSlot
+	lock  sync.Mutex
+}
+
+type statsSlot struct {
+	second        int64
+	requests      int64
+	statusClasses [5]int64
+	latencies     [statsLatencyBucketCount]int64
+	paths         map[string]int64
+}
+
+type PathCount struct {
+	Path  string `json:"path"`
+	Count int64  `json:"count"`
+}
+
+type ServiceStatsSnapshot struct {
+	Requests      int64         `json:"requests"`
+	RPS           float64       `json:"rps"`
+	P50           time.Duration `json:"p50"`
+	P95           time.Duration `json:"p95"`
+	P99           time.Duration `json:"p99"`
+	StatusClasses [5]int64      `json:"status_classes"`
+	TopPaths      []PathCount   `json:"top_paths"`
+
+	// Transfer covers everything since the proxy started, rather than just
+	// the recent window.
+	Transfer ServiceTransferSnapshot `json:"transfer"`
+
+	// SLO covers the service's own SLO window, when it has objectives.
+	SLO *SLOSnapshot `json:"slo,omitempty"`
+}
+
+func NewServiceStats() *ServiceStats {
+	return &ServiceStats{
+		slots: make([]statsSlot, int(ServiceStatsWindow/time.Second)),
+	}
+}
+
+func (s *ServiceStats) Record(path string, statusCode int, duration time.Duration) {
+	s.recordAt(time.Now(), path, statusCode, duration)
+}
+
+func (s *ServiceStats) Snapshot() ServiceStatsSnapshot {
+	return s.snapshotAt(time.Now())
+}
+
+// Private
+
+func (s *ServiceStats) recordAt(now time.Time, path string, statusCode int, duration time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	slot := s.slotFor(now.Unix())
+	slot.requests++
+	slot.latencies[statsLatencyBucket(duration)]++
+
+	class := statusCode/100 - 1
+	if class >= 0 && class < len(slot.statusClasses) {
+		slot.statusClasses[class]++
+	}
+
+	if _, ok := slot.paths[path]; !ok && len(slot.paths) >= statsMaxPathsPerSlot {
+		path = statsOtherPaths
+	}
+	slot.paths[path]++
+}
+
+func (s *ServiceStats) snapshotAt(now time.Time) ServiceStatsSnapshot {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := ServiceStatsSnapshot{}
+	latencies := [statsLatencyBucketCount]int64{}
+	paths := map[string]int64{}
+	oldest := now.Unix() - int64(len(s.slots)) + 1
+
+	for _, slot := range s.slots {
+		if slot.second < oldest || slot.second > now.Unix() {
+			continue
+		}
+
+		result.Requests += slot.requests
+		for i, count := range slot.statusClasses {
+			result.StatusClasses[i] += count
+		}
+		for i, count := range slot.latencies {
+			latencies[i] += count
+		}
+		for path, count := range slot.paths {
+			paths[path] += count
+		}
+	}
+
+	result.RPS = float64(result.Requests) / ServiceStatsWindow.Seconds()
+	result.P50 = statsPercentile(latencies, result.Requests, 0.50)
+	result.P95 = statsPercentile(latencies, result.Requests, 0.95)
+	result.P99 = statsPercentile(latencies, result.Requests, 0.99)
+	result.TopPaths = statsTopPaths(paths, statsTopPathCount)
+
+	return result
+}
+
+func (s *ServiceStats) slotFor(second int64) *statsSlot {
+	slot := &s.slots[second%int64(len(s.slots))]
+	if slot.second != second {
+		*slot = statsSlot{second: second, paths: map[string]int64{}}
+	}
+	return slot
+}
+
+// Latency buckets grow by a factor of sqrt(2), starting from
+// statsLatencyBucketBase. Percentiles are reported as the upper bound of the
+// bucket they fall in.
+func statsLatencyBucket(duration time.Duration) int {
+	if duration <= statsLatencyBucketBase {
+		return 0
+	}
+
+	bucket := int(math.Ceil(2 * math.Log2(float64(duration)/float64(statsLatencyBucketBase))))
+	return min(bucket, statsLatencyBucketCount-1)
+}
+
+func statsLatencyBucketUpperBound(bucket int) time.Duration {
+	return time.Duration(float64(statsLatencyBucketBase) * math.Pow(2, float64(bucket)/2))
+}
+
+func statsPercentile(latencies [statsLatencyBucketCount]int64, total int64, percentile float64) time.Duration {
+	if total == 0 {
+		return 0
+	}
+
+	threshold := int64(math.Ceil(float64(total) * percentile))
+	var seen int64
+	for i, count := range latencies {
+		seen += count
+		if seen >= threshold {
+			return statsLatencyBucketUpperBound(i)
+		}
+	}
+	return statsLatencyBucketUpperBound(statsLatencyBucketCount - 1)
+}
+
+func statsTopPaths(paths map[string]int64, limit int) []PathCount {
+	result := []PathCount{}
+	for _, path := range slices.Collect(maps.Keys(paths)) {
+		result = append(result, PathCount{Path: path, Count: paths[path]})
+	}
+
+	slices.SortFunc(result, func(a, b PathCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Path, b.Path))
+	})
+
+	return result[:min(limit, len(result))]
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceStats_Snapshot(t *testing.T) {
+	stats := NewServiceStats()
+	now := time.Now()
+
+	for i := range 100 {
+		stats.recordAt(now, "/", http.StatusOK, time.Duration(i+1)*time.Millisecond)
+	}
+	stats.recordAt(now, "/missing", http.StatusNotFound, time.Millisecond)
+	stats.recordAt(now.Add(-time.Second), "/error", http.StatusInternalServerError, time.Millisecond)
+
+	snapshot := stats.snapshotAt(now)
+
+	assert.Equal(t, int64(102), snapshot.Requests)
+	assert.InDelta(t, 1.7, snapshot.RPS, 0.001)
+	assert.Equal(t, [5]int64{0, 100, 0, 1, 1}, snapshot.StatusClasses)
+	assert.Equal(t, []PathCount{{"/", 100}, {"/error", 1}, {"/missing", 1}}, snapshot.TopPaths)
+
+	assert.InDelta(t, 50*time.Millisecond, snapshot.P50, float64(15*time.Millisecond))
+	assert.InDelta(t, 95*time.Millisecond, snapshot.P95, float64(30*time.Millisecond))
+	assert.GreaterOrEqual(t, snapshot.P99, snapshot.P95)
+}
+
+func TestServiceStats_OldSlotsExpire(t *testing.T) {
+	stats := NewServiceStats()
+	now := time.Now()
+
+	stats.recordAt(now.Add(-ServiceStatsWindow), "/", http.StatusOK, time.Millisecond)
+	stats.recordAt(now, "/", http.StatusOK, time.Millisecond)
+
+	assert.Equal(t, int64(1), stats.snapshotAt(now).Requests)
+	assert.Equal(t, int64(0), stats.snapshotAt(now.Add(ServiceStatsWindow)).Requests)
+}
+
+func TestServiceStats_LimitsDistinctPaths(t *testing.T) {
+	stats := NewServiceStats()
+	now := time.Now()
+
+	for i := range statsMaxPathsPerSlot + 10 {
+		stats.recordAt(now, "/"+string(rune('a'+i%26))+time.Duration(i).String(), http.StatusOK, time.Millisecond)
+	}
+
+	snapshot := stats.snapshotAt(now)
+	assert.Equal(t, PathCount{statsOtherPaths, 10}, snapshot.TopPaths[0])
+}
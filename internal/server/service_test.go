@@ -3,15 +3,22 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 func TestService_ServeRequest(t *testing.T) {
@@ -77,6 +84,64 @@ func TestService_RejectTLSRequestsWhenNotConfigured(t *testing.T) {
 	require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
 }
 
+func TestService_LogQueryParamAllowlistForRejectedRequests(t *testing.T) {
+	targetOptions := defaultTargetOptions
+	targetOptions.LogQueryParams = []string{"page"}
+	service := testCreateService(t, defaultEmptyHosts, defaultServiceOptions, targetOptions)
+
+	out := &strings.Builder{}
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+	middleware := WithLoggingMiddleware(logger, 80, 443, service)
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/?page=2&token=secret", nil)
+	w := httptest.NewRecorder()
+	middleware.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+
+	logline := struct {
+		Query string `json:"query"`
+	}{}
+	require.NoError(t, json.NewDecoder(strings.NewReader(out.String())).Decode(&logline))
+
+	assert.Equal(t, "page=2", logline.Query)
+	assert.NotContains(t, out.String(), "secret")
+}
+
+func TestService_ReleasedUpgradeRequestsDoNotKeepTheirPlace(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, defaultServiceOptions, defaultTargetOptions)
+
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	service.active = testTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- r.URL.Path
+		<-release
+	})
+	t.Cleanup(func() { close(release) })
+
+	require.NoError(t, service.Pause(DefaultDrainTimeout, time.Minute, nil, false))
+
+	for range 2 {
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/socket", nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			service.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, service.Resume(1))
+
+	for range 2 {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("upgrade request was held behind another")
+		}
+	}
+}
+
 func TestService_ReturnSuccessfulHealthCheckWhilePausedOrStopped(t *testing.T) {
 	service := testCreateService(t, defaultEmptyHosts, defaultServiceOptions, defaultTargetOptions)
 
@@ -90,7 +155,7 @@ func TestService_ReturnSuccessfulHealthCheckWhilePausedOrStopped(t *testing.T) {
 	assert.Equal(t, http.StatusOK, checkRequest("/up"))
 	assert.Equal(t, http.StatusOK, checkRequest("/other"))
 
-	service.Pause(time.Second, time.Millisecond)
+	service.Pause(time.Second, time.Millisecond, nil, false)
 	assert.Equal(t, http.StatusOK, checkRequest("/up"))
 	assert.Equal(t, http.StatusGatewayTimeout, checkRequest("/other"))
 
@@ -98,11 +163,49 @@ func TestService_ReturnSuccessfulHealthCheckWhilePausedOrStopped(t *testing.T) {
 	assert.Equal(t, http.StatusOK, checkRequest("/up"))
 	assert.Equal(t, http.StatusServiceUnavailable, checkRequest("/other"))
 
-	service.Resume()
+	service.Resume(0)
 	assert.Equal(t, http.StatusOK, checkRequest("/up"))
 	assert.Equal(t, http.StatusOK, checkRequest("/other"))
 }
 
+func TestService_PauseExemptPathsAreProxied(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, defaultServiceOptions, defaultTargetOptions)
+
+	checkRequest := func(path string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	service.Pause(time.Second, time.Millisecond, []string{"/webhooks/*"}, false)
+	assert.Equal(t, http.StatusOK, checkRequest("/webhooks/stripe"))
+	assert.Equal(t, http.StatusGatewayTimeout, checkRequest("/other"))
+
+	service.Stop(time.Second, DefaultStopMessage)
+	assert.Equal(t, http.StatusServiceUnavailable, checkRequest("/webhooks/stripe"))
+}
+
+func TestService_ReadOnlyPauseProxiesReads(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, defaultServiceOptions, defaultTargetOptions)
+
+	checkRequest := func(method string) int {
+		req := httptest.NewRequest(method, "/other", nil)
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	service.Pause(time.Second, time.Millisecond, nil, true)
+	assert.Equal(t, http.StatusOK, checkRequest(http.MethodGet))
+	assert.Equal(t, http.StatusOK, checkRequest(http.MethodHead))
+	assert.Equal(t, http.StatusGatewayTimeout, checkRequest(http.MethodPost))
+	assert.Equal(t, http.StatusGatewayTimeout, checkRequest(http.MethodDelete))
+
+	service.Resume(0)
+	assert.Equal(t, http.StatusOK, checkRequest(http.MethodPost))
+}
+
 func TestService_MarshallingState(t *testing.T) {
 	targetOptions := TargetOptions{
 		HealthCheckConfig:   HealthCheckConfig{Path: "/health", Interval: 1, Timeout: 2},
@@ -134,6 +237,424 @@ func TestService_MarshallingState(t *testing.T) {
 	assert.Equal(t, []string{"first"}, service2.rolloutController.Allowlist)
 }
 
+func TestService_ExpiryIsPreservedInState(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, ServiceOptions{TTL: time.Hour}, defaultTargetOptions)
+	expiresAt := service.expiresAt
+
+	assert.False(t, service.Expired(time.Now()))
+	assert.True(t, service.Expired(time.Now().Add(time.Hour*2)))
+
+	var buf bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buf).Encode(service))
+
+	var service2 Service
+	require.NoError(t, json.NewDecoder(&buf).Decode(&service2))
+	assert.True(t, expiresAt.Equal(service2.expiresAt))
+}
+
+func TestService_ACMEChallenge(t *testing.T) {
+	challengeStatus := func(challenge string) int {
+		options := ServiceOptions{TLSEnabled: true, ACMECachePath: t.TempDir(), ACMEChallenge: challenge}
+		service := testCreateService(t, []string{"example.com"}, options, defaultTargetOptions)
+
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token", nil)
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	assert.Equal(t, http.StatusNotFound, challengeStatus(ACMEChallengeAny))
+	assert.Equal(t, http.StatusMovedPermanently, challengeStatus(ACMEChallengeTLSALPN))
+
+	_, err := NewService("test", []string{"example.com"}, ServiceOptions{TLSEnabled: true, ACMEChallenge: "dns-01"})
+	assert.ErrorIs(t, err, ErrorUnknownACMEChallenge)
+}
+
+func TestService_ACMEChallengesAnsweredWhilePausedOrStopped(t *testing.T) {
+	checkRequest := func(service *Service, path string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	// Challenges for certificates that we manage are answered by the ACME
+	// handler, which responds with a 404 for unknown tokens.
+	options := ServiceOptions{TLSEnabled: true, ACMECachePath: t.TempDir()}
+	service := testCreateService(t, []string{"example.com"}, options, defaultTargetOptions)
+	service.Stop(time.Second, DefaultStopMessage)
+	assert.Equal(t, http.StatusNotFound, checkRequest(service, "/.well-known/acme-challenge/token"))
+
+	// Others are passed on to the target, unless they're paused too.
+	service = testCreateService(t, []string{"example.com"}, defaultServiceOptions, defaultTargetOptions)
+	service.Pause(time.Second, time.Millisecond, nil, false)
+	assert.Equal(t, http.StatusOK, checkRequest(service, "/.well-known/acme-challenge/token"))
+	assert.Equal(t, http.StatusGatewayTimeout, checkRequest(service, "/other"))
+
+	service.Stop(time.Second, DefaultStopMessage)
+	assert.Equal(t, http.StatusOK, checkRequest(service, "/.well-known/acme-challenge/token"))
+	assert.Equal(t, http.StatusServiceUnavailable, checkRequest(service, "/other"))
+
+	service = testCreateService(t, []string{"example.com"}, ServiceOptions{PauseACMEChallenges: true}, defaultTargetOptions)
+	service.Stop(time.Second, DefaultStopMessage)
+	assert.Equal(t, http.StatusServiceUnavailable, checkRequest(service, "/.well-known/acme-challenge/token"))
+}
+
+func TestService_RedirectHosts(t *testing.T) {
+	options := ServiceOptions{RedirectHosts: map[string]string{"www.example.com": "example.com"}}
+	service := testCreateService(t, []string{"example.com"}, options, defaultTargetOptions)
+
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com:8080/path?q=1", nil)
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusMovedPermanently, w.Result().StatusCode)
+	assert.Equal(t, "http://example.com/path?q=1", w.Result().Header.Get("Location"))
+
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/path", nil)
+	w = httptest.NewRecorder()
+	service.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestService_RedirectHostsGoStraightToHTTPS(t *testing.T) {
+	options := ServiceOptions{TLSEnabled: true, ACMECachePath: t.TempDir(), RedirectHosts: map[string]string{"www.example.com": "example.com"}}
+	service := testCreateService(t, []string{"example.com"}, options, defaultTargetOptions)
+
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/path", nil)
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusMovedPermanently, w.Result().StatusCode)
+	assert.Equal(t, "https://example.com/path", w.Result().Header.Get("Location"))
+}
+
+func TestService_RedirectHostsMustTargetTheServicesHosts(t *testing.T) {
+	for _, redirects := range []map[string]string{
+		{"www.example.com": "other.example.com"},
+		{"example.com": "example.com"},
+		{"": "example.com"},
+	} {
+		_, err := NewService("test", []string{"example.com"}, ServiceOptions{RedirectHosts: redirects})
+		assert.ErrorIs(t, err, ErrorInvalidRedirectHost)
+	}
+}
+
+func TestService_RemoveCertificates(t *testing.T) {
+	options := ServiceOptions{TLSEnabled: true, ACMECachePath: t.TempDir()}
+	service := testCreateService(t, []string{"example.com"}, options, defaultTargetOptions)
+
+	cacheDir := options.ScopedCachePath()
+	require.NoError(t, os.MkdirAll(cacheDir, 0700))
+	for _, name := range []string{"example.com", "example.com+rsa", "other.example.com"} {
+		require.NoError(t, os.WriteFile(filepath.Join(cacheDir, name), []byte("cert"), 0600))
+	}
+
+	require.NoError(t, service.RemoveCertificates())
+
+	assert.NoFileExists(t, filepath.Join(cacheDir, "example.com"))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "example.com+rsa"))
+	assert.FileExists(t, filepath.Join(cacheDir, "other.example.com"))
+}
+
+func TestService_ACMEStaging(t *testing.T) {
+	cachePath := t.TempDir()
+	production := ServiceOptions{TLSEnabled: true, ACMECachePath: cachePath}
+	staging := ServiceOptions{TLSEnabled: true, ACMECachePath: cachePath, ACMEStaging: true}
+	legacy := ServiceOptions{TLSEnabled: true, ACMECachePath: cachePath, ACMEDirectory: ACMEStagingDirectoryURL}
+
+	assert.False(t, production.UsesACMEStaging())
+	assert.True(t, staging.UsesACMEStaging())
+	assert.True(t, legacy.UsesACMEStaging())
+
+	assert.Equal(t, filepath.Join(cachePath, "staging"), staging.ScopedCachePath())
+	assert.Equal(t, staging.ScopedCachePath(), legacy.ScopedCachePath())
+	assert.NotEqual(t, production.ScopedCachePath(), staging.ScopedCachePath())
+
+	service := testCreateService(t, []string{"example.com"}, staging, defaultTargetOptions)
+	manager, ok := service.certManager.(*autocert.Manager)
+	require.True(t, ok)
+	assert.Equal(t, ACMEStagingDirectoryURL, manager.Client.DirectoryURL)
+
+	custom := ServiceOptions{TLSEnabled: true, ACMEStaging: true, TLSCertificatePath: "cert.pem", TLSPrivateKeyPath: "key.pem"}
+	assert.False(t, custom.UsesACMEStaging())
+}
+
+func TestService_AnnotatesRequestsAfterCutover(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, ServiceOptions{DeployAnnotationPeriod: time.Minute}, defaultTargetOptions)
+	previous := service.active.Target()
+
+	sendRequest := func() (string, string) {
+		out := &strings.Builder{}
+		logger := slog.New(slog.NewJSONHandler(out, nil))
+		handler := WithLoggingMiddleware(logger, 80, 443, service)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+		logline := struct {
+			DeployID       string `json:"deploy_id"`
+			PreviousTarget string `json:"previous_target"`
+		}{}
+		require.NoError(t, json.NewDecoder(strings.NewReader(out.String())).Decode(&logline))
+		return logline.DeployID, logline.PreviousTarget
+	}
+
+	deployID, _ := sendRequest()
+	assert.Empty(t, deployID)
+
+	service.SetTarget(TargetSlotActive, testTarget(t, func(w http.ResponseWriter, r *http.Request) {}), DefaultDrainTimeout)
+
+	deployID, previousTarget := sendRequest()
+	assert.NotEmpty(t, deployID)
+	assert.Equal(t, previous, previousTarget)
+
+	service.cutover.Load().Until = time.Now().Add(-time.Second)
+
+	deployID, _ = sendRequest()
+	assert.Empty(t, deployID)
+}
+
+func TestService_DeletesDeployMetricsAfterAnnotationPeriod(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, ServiceOptions{DeployAnnotationPeriod: 100 * time.Millisecond}, defaultTargetOptions)
+	previous := service.active.Target()
+	handler := WithLoggingMiddleware(slog.New(slog.NewTextHandler(io.Discard, nil)), 80, 443, WithMetricsMiddleware(service))
+	series := testutil.CollectAndCount(deployRequestsCounter)
+
+	service.SetTarget(TargetSlotActive, testTarget(t, func(w http.ResponseWriter, r *http.Request) {}), DefaultDrainTimeout)
+	deployID := service.cutover.Load().ID
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	labels := prometheus.Labels{"service": service.name, "deploy_id": deployID, "previous_target": previous, "status": "200"}
+	assert.Equal(t, 1.0, testutil.ToFloat64(deployRequestsCounter.With(labels)))
+	assert.Equal(t, series+1, testutil.CollectAndCount(deployRequestsCounter))
+
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCount(deployRequestsCounter) == series
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestService_DoesNotRecordCutoverWhenTargetIsCleared(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, ServiceOptions{DeployAnnotationPeriod: time.Minute}, defaultTargetOptions)
+
+	service.SetTarget(TargetSlotActive, nil, DefaultDrainTimeout)
+	assert.Nil(t, service.cutover.Load())
+}
+
+func TestService_SecurityHeaders(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, ServiceOptions{SecurityHeaders: SecurityHeadersRelaxed}, defaultTargetOptions)
+
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "strict-origin-when-cross-origin", w.Header().Get("Referrer-Policy"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+
+	_, err := NewService("test", defaultEmptyHosts, ServiceOptions{SecurityHeaders: "paranoid"})
+	assert.ErrorIs(t, err, ErrorUnknownSecurityHeadersPreset)
+}
+
+func TestService_OIDCRequiresSignIn(t *testing.T) {
+	options := ServiceOptions{OIDCIssuer: "https://accounts.example.com", OIDCClientID: "app", OIDCClientSecret: "secret"}
+	service := testCreateService(t, defaultEmptyHosts, options, defaultTargetOptions)
+
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+
+	_, err := NewService("test", defaultEmptyHosts, ServiceOptions{OIDCIssuer: "https://accounts.example.com"})
+	assert.ErrorIs(t, err, ErrorOIDCIncomplete)
+}
+
+func TestService_RequiresSignature(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, ServiceOptions{SignatureSecret: "secret", SignedPaths: []string{"/webhooks/*"}}, defaultTargetOptions)
+
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/webhooks/billing", nil))
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestService_MiddlewareOrderAndDisabling(t *testing.T) {
+	options := ServiceOptions{SecurityHeaders: SecurityHeadersRelaxed, SignatureSecret: "secret"}
+	sendRequest := func(options ServiceOptions) *http.Response {
+		service := testCreateService(t, defaultEmptyHosts, options, defaultTargetOptions)
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+		return w.Result()
+	}
+
+	resp := sendRequest(options)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "SAMEORIGIN", resp.Header.Get("X-Frame-Options"))
+
+	options.MiddlewareOrder = []string{MiddlewareSignature}
+	resp = sendRequest(options)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("X-Frame-Options"))
+
+	options.MiddlewareOrder = nil
+	options.DisabledMiddleware = []string{MiddlewareSignature}
+	resp = sendRequest(options)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "SAMEORIGIN", resp.Header.Get("X-Frame-Options"))
+}
+
+func TestService_InvalidMiddlewareConfiguration(t *testing.T) {
+	_, err := NewService("test", defaultEmptyHosts, ServiceOptions{DisabledMiddleware: []string{"compression"}})
+	assert.ErrorIs(t, err, ErrorUnknownMiddleware)
+
+	_, err = NewService("test", defaultEmptyHosts, ServiceOptions{MiddlewareOrder: []string{MiddlewareSignature, MiddlewareSignature}})
+	assert.ErrorIs(t, err, ErrorDuplicateMiddleware)
+
+	_, err = NewService("test", defaultEmptyHosts, ServiceOptions{MiddlewareOrder: []string{MiddlewareSignature}, DisabledMiddleware: []string{MiddlewareSignature}})
+	assert.ErrorIs(t, err, ErrorIncompatibleMiddleware)
+
+	acmeOptions := ServiceOptions{TLSEnabled: true, ACMECachePath: t.TempDir(), MiddlewareOrder: []string{MiddlewareMethodRestriction, MiddlewareACME}}
+	_, err = NewService("test", []string{"example.com"}, acmeOptions)
+	assert.ErrorIs(t, err, ErrorIncompatibleMiddleware)
+
+	acmeOptions.ACMEChallenge = ACMEChallengeTLSALPN
+	_, err = NewService("test", []string{"example.com"}, acmeOptions)
+	assert.NoError(t, err)
+
+	chain, err := MiddlewareChain([]string{MiddlewareErrorPages}, []string{MiddlewareTraceSampling, MiddlewareACME})
+	require.NoError(t, err)
+	assert.Equal(t, []string{MiddlewareErrorPages, MiddlewareSecurityHeaders, MiddlewareWellKnown, MiddlewareSignature, MiddlewareMethodRestriction, MiddlewareWebSocketPolicy, MiddlewareInformationalResponses}, chain)
+}
+
+func TestService_SLOProtectsErrorBudget(t *testing.T) {
+	service := testCreateService(t, defaultEmptyHosts, ServiceOptions{SLOAvailability: 99, SLOProtectBudget: true}, defaultTargetOptions)
+	require.NoError(t, service.SetChaos(100, 0, http.StatusInternalServerError, time.Minute))
+
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+
+	service.slo.Record(http.StatusInternalServerError, time.Millisecond)
+	require.True(t, service.Stats().SLO.Exhausted)
+
+	w = httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestService_SLOTrackingContinuesAcrossDeployments(t *testing.T) {
+	options := ServiceOptions{SLOAvailability: 99}
+	service := testCreateService(t, defaultEmptyHosts, options, defaultTargetOptions)
+	tracker := service.slo
+
+	require.NoError(t, service.UpdateOptions(defaultEmptyHosts, options))
+	assert.Same(t, tracker, service.slo)
+
+	options.SLOAvailability = 99.9
+	require.NoError(t, service.UpdateOptions(defaultEmptyHosts, options))
+	assert.NotSame(t, tracker, service.slo)
+
+	_, err := NewService("test", defaultEmptyHosts, ServiceOptions{SLOAvailability: 100})
+	assert.ErrorIs(t, err, ErrorInvalidSLO)
+}
+
+func TestService_Schedule(t *testing.T) {
+	now := time.Now().UTC()
+	closedHours := now.Add(2*time.Hour).Format("15:04") + "-" + now.Add(3*time.Hour).Format("15:04")
+
+	t.Run("open", func(t *testing.T) {
+		service := testCreateService(t, defaultEmptyHosts, ServiceOptions{Schedule: []string{"00:00-24:00"}}, defaultTargetOptions)
+
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	})
+
+	t.Run("closed for maintenance", func(t *testing.T) {
+		service := testCreateService(t, defaultEmptyHosts, ServiceOptions{Schedule: []string{closedHours}}, defaultTargetOptions)
+
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+
+		w = httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/up", nil))
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		service := testCreateService(t, defaultEmptyHosts, ServiceOptions{Schedule: []string{closedHours}, ScheduleClosed: ScheduleClosedDisable}, defaultTargetOptions)
+
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+		assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	})
+
+	_, err := NewService("test", defaultEmptyHosts, ServiceOptions{Schedule: []string{"09:00-17:00"}, ScheduleClosed: "hide"})
+	assert.ErrorIs(t, err, ErrorUnknownScheduleClosed)
+}
+
+func TestService_ExternalAuth(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-Uri") != "/public" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer authServer.Close()
+
+	service := testCreateService(t, defaultEmptyHosts, ServiceOptions{ExternalAuthURL: authServer.URL}, defaultTargetOptions)
+
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/private", nil))
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/public", nil))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	_, err := NewService("test", defaultEmptyHosts, ServiceOptions{ExternalAuthURL: "auth.example.com"})
+	assert.ErrorIs(t, err, ErrorInvalidExternalAuthURL)
+}
+
+func TestService_Plugins(t *testing.T) {
+	t.Run("responding to requests", func(t *testing.T) {
+		plugin := testPluginWritingOutput(t, `{"respond":{"status":403,"body":"Denied by plugin"}}`)
+		service := testCreateService(t, defaultEmptyHosts, ServiceOptions{Plugins: []string{plugin + ":before_upstream"}}, defaultTargetOptions)
+
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+
+		assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+		assert.Equal(t, "Denied by plugin", w.Body.String())
+		assert.Empty(t, service.ActiveTarget().inflight)
+	})
+
+	t.Run("changing responses", func(t *testing.T) {
+		plugin := testPluginWritingOutput(t, `{"set_response_headers":{"X-Plugin":"yes"}}`)
+		service := testCreateService(t, defaultEmptyHosts, ServiceOptions{Plugins: []string{plugin + ":before_response"}}, defaultTargetOptions)
+
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+		assert.Equal(t, "yes", w.Header().Get("X-Plugin"))
+	})
+
+	t.Run("failing", func(t *testing.T) {
+		plugin := testPlugin(t, []byte{0x00}, nil)
+		service := testCreateService(t, defaultEmptyHosts, ServiceOptions{Plugins: []string{plugin}}, defaultTargetOptions)
+
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+	})
+
+	_, err := NewService("test", defaultEmptyHosts, ServiceOptions{Plugins: []string{"/missing.wasm"}})
+	assert.ErrorIs(t, err, ErrorInvalidPlugin)
+}
+
 func testCreateService(t *testing.T, hosts []string, options ServiceOptions, targetOptions TargetOptions) *Service {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	t.Cleanup(server.Close)
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ServiceTransferStats keeps cumulative totals of the data and connections
+// that a service has handled since the proxy started, so that the services
+// using the most bandwidth on a shared host can be found.
+type ServiceTransferStats struct {
+	bytesIn           atomic.Int64
+	bytesOut          atomic.Int64
+	activeConnections atomic.Int64
+	totalConnections  atomic.Int64
+
+	bytesInCounter    prometheus.Counter
+	bytesOutCounter   prometheus.Counter
+	connectionsGauge  prometheus.Gauge
+	connectionCounter prometheus.Counter
+}
+
+type ServiceTransferSnapshot struct {
+	BytesIn           int64 `json:"bytes_in"`
+	BytesOut          int64 `json:"bytes_out"`
+	ActiveConnections int64 `json:"active_connections"`
+	TotalConnections  int64 `json:"total_connections"`
+}
+
+func NewServiceTransferStats(service string) *ServiceTransferStats {
+	return &ServiceTransferStats{
+		bytesInCounter:    serviceBytesInCounter.WithLabelValues(service),
+		bytesOutCounter:   serviceBytesOutCounter.WithLabelValues(service),
+		connectionsGauge:  serviceActiveConnectionsGauge.WithLabelValues(service),
+		connectionCounter: serviceConnectionsCounter.WithLabelValues(service),
+	}
+}
+
+func (s *ServiceTransferStats) RecordBytesIn(n int) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.bytesIn.Add(int64(n))
+	s.bytesInCounter.Add(float64(n))
+}
+
+func (s *ServiceTransferStats) RecordBytesOut(n int) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.bytesOut.Add(int64(n))
+	s.bytesOutCounter.Add(float64(n))
+}
+
+// ConnectionOpened records a client connection sending its first request to
+// the service. See clientConnection.
+func (s *ServiceTransferStats) ConnectionOpened() {
+	s.activeConnections.Add(1)
+	s.totalConnections.Add(1)
+	s.connectionsGauge.Inc()
+	s.connectionCounter.Inc()
+}
+
+// ConnectionClosed records a client connection that was counted by
+// ConnectionOpened being closed.
+func (s *ServiceTransferStats) ConnectionClosed() {
+	s.activeConnections.Add(-1)
+	s.connectionsGauge.Dec()
+}
+
+func (s *ServiceTransferStats) Snapshot() ServiceTransferSnapshot {
+	return ServiceTransferSnapshot{
+		BytesIn:           s.bytesIn.Load(),
+		BytesOut:          s.bytesOut.Load(),
+		ActiveConnections: s.activeConnections.Load(),
+		TotalConnections:  s.totalConnections.Load(),
+	}
+}
+
+// transferCountingBody counts the bytes of a request body as they are read,
+// against whichever service ends up handling the request.
+type transferCountingBody struct {
+	io.ReadCloser
+	requestContext *loggingRequestContext
+	bytesRead      atomic.Int64
+}
+
+func (b *transferCountingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.requestContext.Transfer.RecordBytesIn(n)
+	b.bytesRead.Add(int64(n))
+	return n, err
+}
+
+// transferCountingConn counts the bytes sent in each direction over a
+// connection that has been hijacked, such as a WebSocket, and closes its
+// clientConnection once it's closed.
+type transferCountingConn struct {
+	net.Conn
+	transfer   *ServiceTransferStats
+	connection *clientConnection
+}
+
+func (c *transferCountingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.transfer.RecordBytesIn(n)
+	return n, err
+}
+
+func (c *transferCountingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.transfer.RecordBytesOut(n)
+	return n, err
+}
+
+func (c *transferCountingConn) Close() error {
+	err := c.Conn.Close()
+	c.connection.Close()
+	return err
+}
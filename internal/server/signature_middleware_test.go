@@ -0,0 +1,132 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureMiddleware_SignedHeaders(t *testing.T) {
+	handler, received := testSignatureHandler(SignatureConfig{Secret: "secret"})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	send := func(signature, body string) int {
+		r := httptest.NewRequest("POST", "/webhooks/billing?source=stripe", strings.NewReader(body))
+		r.Header.Set("X-Signature", signature)
+		r.Header.Set("X-Signature-Timestamp", timestamp)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result().StatusCode
+	}
+
+	signature := ComputeSignature("secret", timestamp, "POST", "/webhooks/billing?source=stripe", []byte(`{"event":"paid"}`))
+
+	assert.Equal(t, http.StatusOK, send(signature, `{"event":"paid"}`))
+	assert.Equal(t, `{"event":"paid"}`, *received)
+
+	assert.Equal(t, http.StatusOK, send("sha256="+signature, `{"event":"paid"}`))
+	assert.Equal(t, http.StatusForbidden, send(signature, `{"event":"refunded"}`))
+	assert.Equal(t, http.StatusForbidden, send("not-hex", `{"event":"paid"}`))
+}
+
+func TestSignatureMiddleware_SignedURL(t *testing.T) {
+	handler, _ := testSignatureHandler(SignatureConfig{Secret: "secret", Param: "sig"})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	send := func(uri string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", uri, nil))
+		return w.Result().StatusCode
+	}
+
+	signature := ComputeSignature("secret", timestamp, "GET", "/media/1.jpg?size=large&timestamp="+timestamp, nil)
+
+	assert.Equal(t, http.StatusOK, send("/media/1.jpg?size=large&sig="+signature+"&timestamp="+timestamp))
+	assert.Equal(t, http.StatusForbidden, send("/media/2.jpg?size=large&sig="+signature+"&timestamp="+timestamp))
+	assert.Equal(t, http.StatusForbidden, send("/media/1.jpg?size=small&sig="+signature+"&timestamp="+timestamp))
+	assert.Equal(t, http.StatusForbidden, send("/media/1.jpg?size=large"))
+}
+
+func TestSignatureMiddleware_ClockSkew(t *testing.T) {
+	handler, _ := testSignatureHandler(SignatureConfig{Secret: "secret", MaxSkew: time.Minute})
+
+	send := func(at time.Time) int {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Signature", ComputeSignature("secret", timestamp, "GET", "/", nil))
+		r.Header.Set("X-Signature-Timestamp", timestamp)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Result().StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, send(time.Now().Add(-30*time.Second)))
+	assert.Equal(t, http.StatusOK, send(time.Now().Add(30*time.Second)))
+	assert.Equal(t, http.StatusForbidden, send(time.Now().Add(-2*time.Minute)))
+	assert.Equal(t, http.StatusForbidden, send(time.Now().Add(2*time.Minute)))
+}
+
+func TestSignatureMiddleware_OnlySignedPaths(t *testing.T) {
+	handler, _ := testSignatureHandler(SignatureConfig{Secret: "secret", Paths: []string{"/webhooks/*"}})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/billing", strings.NewReader("{}")))
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+}
+
+func TestSignatureMiddleware_LimitsBodySize(t *testing.T) {
+	handler, _ := testSignatureHandler(SignatureConfig{Secret: "secret"})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", int(MaxSignedRequestBodySize)+1)))
+	r.Header.Set("X-Signature", "00")
+	r.Header.Set("X-Signature-Timestamp", timestamp)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Result().StatusCode)
+}
+
+func TestSignatureMiddleware_RemovesSpilledBodyOfRejectedRequest(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	handler, _ := testSignatureHandler(SignatureConfig{Secret: "secret"})
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", int(DefaultMaxMemoryBufferSize)*2)))
+	r.Header.Set("X-Signature", "00")
+	r.Header.Set("X-Signature-Timestamp", timestamp)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	spills, err := filepath.Glob(filepath.Join(tmpDir, "proxy-buffer-*"))
+	require.NoError(t, err)
+	assert.Empty(t, spills)
+}
+
+// Helpers
+
+func testSignatureHandler(config SignatureConfig) (http.Handler, *string) {
+	var received string
+	handler := WithSignatureMiddleware(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}))
+
+	return handler, &received
+}
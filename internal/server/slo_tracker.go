@@ -0,0 +1,220 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	DefaultSLOWindow        = 24 * time.Hour
+	DefaultSLOLatencyTarget = 99.0
+
+	SLOObjectiveAvailability = "availability"
+	SLOObjectiveLatency      = "latency"
+
+	sloSlotCount = 60
+)
+
+var ErrorInvalidSLO = errors.New("SLO targets must be percentages between 0 and 100")
+
+// SLOConfig describes a service's objectives. Availability is the percentage
+// of requests that should succeed (without a 5xx response), and
+// LatencyTarget is the percentage that should be answered within Latency.
+// Both are measured over the rolling Window.
+type SLOConfig struct {
+	Availability  float64
+	Latency       time.Duration
+	LatencyTarget float64
+	Window        time.Duration
+}
+
+func (c SLOConfig) Enabled() bool {
+	return c.Availability > 0 || c.Latency > 0
+}
+
+func (c SLOConfig) Validate() error {
+	if c.Availability < 0 || c.Availability >= 100 || c.LatencyTarget < 0 || c.LatencyTarget >= 100 {
+		return ErrorInvalidSLO
+	}
+	return nil
+}
+
+// SLOTracker measures a service's requests against its objectives, and how
+// quickly they are using up their error budgets. The budget is the share of
+// requests that may fail an objective; a burn rate of 1 uses it up exactly
+// over the window.
+type SLOTracker struct {
+	config       SLOConfig
+	slotDuration time.Duration
+	slots        [sloSlotCount]sloSlot
+	lock         sync.Mutex
+}
+
+type sloSlot struct {
+	index    int64
+	requests int64
+	errors   int64
+	slow     int64
+}
+
+type SLOObjectiveSnapshot struct {
+	Target          float64 `json:"target"`
+	Actual          float64 `json:"actual"`
+	BurnRate        float64 `json:"burn_rate"`
+	BudgetRemaining float64 `json:"budget_remaining"`
+}
+
+type SLOSnapshot struct {
+	Window       time.Duration         `json:"window"`
+	Requests     int64                 `json:"requests"`
+	Availability *SLOObjectiveSnapshot `json:"availability,omitempty"`
+	Latency      *SLOObjectiveSnapshot `json:"latency,omitempty"`
+	Exhausted    bool                  `json:"exhausted"`
+}
+
+func NewSLOTracker(config SLOConfig) *SLOTracker {
+	if config.Window <= 0 {
+		config.Window = DefaultSLOWindow
+	}
+	if config.Latency > 0 && config.LatencyTarget == 0 {
+		config.LatencyTarget = DefaultSLOLatencyTarget
+	}
+
+	return &SLOTracker{
+		config:       config,
+		slotDuration: max(config.Window/sloSlotCount, time.Second),
+	}
+}
+
+func (t *SLOTracker) Record(statusCode int, duration time.Duration) {
+	t.recordAt(time.Now(), statusCode, duration)
+}
+
+func (t *SLOTracker) Snapshot() SLOSnapshot {
+	return t.snapshotAt(time.Now())
+}
+
+// Exhausted reports whether any objective has used up its error budget.
+func (t *SLOTracker) Exhausted() bool {
+	return t.Snapshot().Exhausted
+}
+
+// Private
+
+func (t *SLOTracker) recordAt(now time.Time, statusCode int, duration time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	index := now.UnixNano() / int64(t.slotDuration)
+	slot := &t.slots[index%sloSlotCount]
+	if slot.index != index {
+		*slot = sloSlot{index: index}
+	}
+
+	slot.requests++
+	if statusCode >= 500 {
+		slot.errors++
+	}
+	if t.config.Latency > 0 && duration > t.config.Latency {
+		slot.slow++
+	}
+}
+
+func (t *SLOTracker) snapshotAt(now time.Time) SLOSnapshot {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var requests, errors, slow int64
+	current := now.UnixNano() / int64(t.slotDuration)
+	for _, slot := range t.slots {
+		if slot.index > current-sloSlotCount && slot.index <= current {
+			requests += slot.requests
+			errors += slot.errors
+			slow += slot.slow
+		}
+	}
+
+	result := SLOSnapshot{Window: t.config.Window, Requests: requests}
+	if t.config.Availability > 0 {
+		result.Availability = sloObjective(t.config.Availability, requests, errors)
+		result.Exhausted = result.Exhausted || result.Availability.BudgetRemaining <= 0
+	}
+	if t.config.Latency > 0 {
+		result.Latency = sloObjective(t.config.LatencyTarget, requests, slow)
+		result.Exhausted = result.Exhausted || result.Latency.BudgetRemaining <= 0
+	}
+
+	return result
+}
+
+func sloObjective(target float64, requests, bad int64) *SLOObjectiveSnapshot {
+	result := &SLOObjectiveSnapshot{Target: target, Actual: 100, BudgetRemaining: 1}
+	if requests == 0 {
+		return result
+	}
+
+	badRatio := float64(bad) / float64(requests)
+	result.Actual = 100 * (1 - badRatio)
+	result.BurnRate = badRatio / (1 - target/100)
+	result.BudgetRemaining = 1 - result.BurnRate
+	return result
+}
+
+// sloCollector reports the burn rate and remaining budget of each service's
+// objectives when metrics are gathered, so that they follow the rolling
+// window even when no requests are arriving.
+type sloCollector struct {
+	burnRate        *prometheus.Desc
+	budgetRemaining *prometheus.Desc
+
+	trackers map[string]*SLOTracker
+	lock     sync.Mutex
+}
+
+func newSLOCollector() *sloCollector {
+	return &sloCollector{
+		burnRate:        prometheus.NewDesc(metricsNamespace+"_slo_burn_rate", "Rate at which the service is using its error budget over the SLO window, where 1 uses it exactly", []string{"service", "objective"}, nil),
+		budgetRemaining: prometheus.NewDesc(metricsNamespace+"_slo_error_budget_remaining", "Fraction of the service's error budget remaining over the SLO window", []string{"service", "objective"}, nil),
+		trackers:        map[string]*SLOTracker{},
+	}
+}
+
+func (c *sloCollector) Track(service string, tracker *SLOTracker) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if tracker == nil {
+		delete(c.trackers, service)
+	} else {
+		c.trackers[service] = tracker
+	}
+}
+
+func (c *sloCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.burnRate
+	ch <- c.budgetRemaining
+}
+
+func (c *sloCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for service, tracker := range c.trackers {
+		snapshot := tracker.Snapshot()
+		objectives := map[string]*SLOObjectiveSnapshot{
+			SLOObjectiveAvailability: snapshot.Availability,
+			SLOObjectiveLatency:      snapshot.Latency,
+		}
+
+		for objective, result := range objectives {
+			if result == nil {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(c.burnRate, prometheus.GaugeValue, result.BurnRate, service, objective)
+			ch <- prometheus.MustNewConstMetric(c.budgetRemaining, prometheus.GaugeValue, result.BudgetRemaining, service, objective)
+		}
+	}
+}
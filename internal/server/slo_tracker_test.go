@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSLOTracker_Availability(t *testing.T) {
+	tracker := NewSLOTracker(SLOConfig{Availability: 99, Window: time.Hour})
+	now := time.Now()
+
+	for i := range 1000 {
+		status := http.StatusOK
+		if i < 5 {
+			status = http.StatusServiceUnavailable
+		}
+		tracker.recordAt(now, status, time.Millisecond)
+	}
+
+	snapshot := tracker.snapshotAt(now)
+	assert.Equal(t, int64(1000), snapshot.Requests)
+	assert.Nil(t, snapshot.Latency)
+	require.NotNil(t, snapshot.Availability)
+	assert.InDelta(t, 99.5, snapshot.Availability.Actual, 0.001)
+	assert.InDelta(t, 0.5, snapshot.Availability.BurnRate, 0.001)
+	assert.InDelta(t, 0.5, snapshot.Availability.BudgetRemaining, 0.001)
+	assert.False(t, snapshot.Exhausted)
+
+	for range 10 {
+		tracker.recordAt(now, http.StatusInternalServerError, time.Millisecond)
+	}
+	snapshot = tracker.snapshotAt(now)
+	assert.Greater(t, snapshot.Availability.BurnRate, 1.0)
+	assert.True(t, snapshot.Exhausted)
+}
+
+func TestSLOTracker_Latency(t *testing.T) {
+	tracker := NewSLOTracker(SLOConfig{Latency: 100 * time.Millisecond})
+	now := time.Now()
+
+	tracker.recordAt(now, http.StatusOK, 50*time.Millisecond)
+	tracker.recordAt(now, http.StatusOK, 200*time.Millisecond)
+
+	snapshot := tracker.snapshotAt(now)
+	assert.Nil(t, snapshot.Availability)
+	require.NotNil(t, snapshot.Latency)
+	assert.Equal(t, DefaultSLOLatencyTarget, snapshot.Latency.Target)
+	assert.InDelta(t, 50, snapshot.Latency.Actual, 0.001)
+	assert.InDelta(t, 50, snapshot.Latency.BurnRate, 0.001)
+	assert.True(t, snapshot.Exhausted)
+}
+
+func TestSLOTracker_RollingWindow(t *testing.T) {
+	tracker := NewSLOTracker(SLOConfig{Availability: 99.9, Window: time.Hour})
+	now := time.Now()
+
+	tracker.recordAt(now, http.StatusInternalServerError, time.Millisecond)
+	assert.True(t, tracker.snapshotAt(now).Exhausted)
+	assert.True(t, tracker.snapshotAt(now.Add(59*time.Minute)).Exhausted)
+
+	snapshot := tracker.snapshotAt(now.Add(61 * time.Minute))
+	assert.Equal(t, int64(0), snapshot.Requests)
+	assert.Equal(t, 1.0, snapshot.Availability.BudgetRemaining)
+	assert.False(t, snapshot.Exhausted)
+}
+
+func TestSLOConfig_Validate(t *testing.T) {
+	assert.NoError(t, SLOConfig{Availability: 99.9, LatencyTarget: 95}.Validate())
+	assert.ErrorIs(t, SLOConfig{Availability: 100}.Validate(), ErrorInvalidSLO)
+	assert.ErrorIs(t, SLOConfig{Availability: 99, LatencyTarget: -1}.Validate(), ErrorInvalidSLO)
+}
+
+func TestSLOCollector(t *testing.T) {
+	collector := newSLOCollector()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	tracker := NewSLOTracker(SLOConfig{Availability: 99, Latency: time.Second})
+	tracker.Record(http.StatusInternalServerError, time.Millisecond)
+	collector.Track("web", tracker)
+
+	families := testGather(t, registry)
+	require.Len(t, families, 2)
+	assert.Equal(t, "kamal_proxy_slo_burn_rate", families[0].GetName())
+	require.Len(t, families[0].GetMetric(), 2)
+	assert.Equal(t, "kamal_proxy_slo_error_budget_remaining", families[1].GetName())
+
+	collector.Track("web", nil)
+	assert.Empty(t, testGather(t, registry))
+}
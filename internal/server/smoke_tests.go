@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const checkResponseBodyLimit = 4096
+
+var (
+	ErrorTargetFailedSmokeTest = errors.New("target failed smoke test")
+	ErrorInvalidSmokeTestPath  = errors.New("smoke test paths must be absolute request paths, such as /login")
+)
+
+// ValidateSmokeTestPaths checks that each smoke test is for an absolute
+// request path, which may include a query.
+func ValidateSmokeTestPaths(paths []string) error {
+	for _, smokeTestPath := range paths {
+		requestPath, _, _ := strings.Cut(smokeTestPath, "?")
+		if !strings.HasPrefix(requestPath, "/") || path.Clean(requestPath) != requestPath {
+			return fmt.Errorf("%w: %s", ErrorInvalidSmokeTestPath, smokeTestPath)
+		}
+	}
+	return nil
+}
+
+// RunSmokeTests requests each of the target's smoke test paths, as a client
+// of the given host would, and returns an error unless they all succeed with
+// a 2xx. Redirects aren't followed, so they count as failures. This catches
+// targets that pass their health checks but can't serve their real routes.
+//
+// Directory targets aren't smoke tested.
+func (t *Target) RunSmokeTests(host string, tlsEnabled bool) error {
+	if len(t.options.SmokeTestPaths) == 0 || t.isDirectory() {
+		return nil
+	}
+
+	client := t.checkClient()
+	for _, smokeTestPath := range t.options.SmokeTestPaths {
+		statusCode, _, err := t.requestAsClient(client, smokeTestPath, host, tlsEnabled)
+		if err != nil {
+			slog.Info("Smoke test failed", "target", t.Target(), "path", smokeTestPath, "error", err)
+			return fmt.Errorf("%w: %s: %s", ErrorTargetFailedSmokeTest, smokeTestPath, err)
+		}
+		if statusCode < 200 || statusCode > 299 {
+			slog.Info("Smoke test failed", "target", t.Target(), "path", smokeTestPath, "status", statusCode)
+			return fmt.Errorf("%w: %s returned %d", ErrorTargetFailedSmokeTest, smokeTestPath, statusCode)
+		}
+	}
+
+	slog.Info("Smoke tests passed", "target", t.Target(), "paths", t.options.SmokeTestPaths)
+	return nil
+}
+
+// Private
+
+// checkClient returns a client for the requests that check a new target
+// before it receives traffic. Redirects are returned rather than followed.
+func (t *Target) checkClient() *http.Client {
+	return &http.Client{
+		Transport: t.transport,
+		Timeout:   t.options.ResponseTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// requestAsClient requests a path from the target as a client of the given
+// host would, returning the status and the start of the body.
+func (t *Target) requestAsClient(client *http.Client, requestPath string, host string, tlsEnabled bool) (int, []byte, error) {
+	endpoint := *t.targetURL
+	endpoint.Host = t.endpointHost()
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(endpoint.String(), "/")+requestPath, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if host == "" {
+		host = t.targetURL.Host
+	}
+	proto := "http"
+	if tlsEnabled {
+		proto = "https"
+	}
+
+	req.Host = host
+	req.Header.Set("User-Agent", healthCheckUserAgent)
+	req.Header.Set("X-Forwarded-Host", host)
+	req.Header.Set("X-Forwarded-Proto", proto)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, checkResponseBodyLimit))
+	if err != nil {
+		return 0, nil, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, body, nil
+}
+
+// smokeTestHost chooses the host that smoke tests are sent for, which is the
+// first of a service's hosts that isn't a wildcard.
+func smokeTestHost(hosts []string) string {
+	for _, host := range hosts {
+		if !strings.HasPrefix(host, "*.") {
+			return host
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarget_RunSmokeTests(t *testing.T) {
+	var seen []*http.Request
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r)
+		switch r.URL.Path {
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/moved":
+			http.Redirect(w, r, "/", http.StatusMovedPermanently)
+		}
+	}
+
+	targetOptions := defaultTargetOptions
+	targetOptions.SmokeTestPaths = []string{"/login", "/search?q=test"}
+	target := testTargetWithOptions(t, targetOptions, handler)
+
+	require.NoError(t, target.RunSmokeTests("example.com", true))
+	require.Len(t, seen, 2)
+	assert.Equal(t, "/search", seen[1].URL.Path)
+	assert.Equal(t, "q=test", seen[1].URL.RawQuery)
+	assert.Equal(t, "example.com", seen[1].Host)
+	assert.Equal(t, "https", seen[1].Header.Get("X-Forwarded-Proto"))
+
+	targetOptions.SmokeTestPaths = []string{"/login", "/broken"}
+	target = testTargetWithOptions(t, targetOptions, handler)
+	err := target.RunSmokeTests("example.com", false)
+	assert.ErrorIs(t, err, ErrorTargetFailedSmokeTest)
+	assert.ErrorContains(t, err, "/broken returned 500")
+
+	targetOptions.SmokeTestPaths = []string{"/moved"}
+	target = testTargetWithOptions(t, targetOptions, handler)
+	assert.ErrorIs(t, target.RunSmokeTests("example.com", false), ErrorTargetFailedSmokeTest)
+}
+
+func TestRouter_DeployFailsWhenSmokeTestsFail(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackendWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("second"))
+	})
+
+	require.NoError(t, router.SetServiceTarget("service1", []string{"example.com"}, first, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	targetOptions := defaultTargetOptions
+	targetOptions.SmokeTestPaths = []string{"/login"}
+	err := router.SetServiceTarget("service1", []string{"example.com"}, second, defaultServiceOptions, targetOptions, DefaultDeployTimeout, DefaultDrainTimeout)
+	assert.ErrorIs(t, err, ErrorTargetFailedSmokeTest)
+
+	statusCode, body := sendGETRequest(router, "http://example.com/")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "first", body)
+}
+
+func TestValidateSmokeTestPaths(t *testing.T) {
+	assert.NoError(t, ValidateSmokeTestPaths([]string{"/", "/login", "/search?q=test"}))
+	assert.ErrorIs(t, ValidateSmokeTestPaths([]string{"login"}), ErrorInvalidSmokeTestPath)
+	assert.ErrorIs(t, ValidateSmokeTestPaths([]string{"/a/../b"}), ErrorInvalidSmokeTestPath)
+}
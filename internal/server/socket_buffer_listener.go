@@ -0,0 +1,53 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+)
+
+// SocketBufferListener sets the size of the kernel's send and receive
+// buffers for each connection that it accepts. Larger buffers suit fast
+// transfers over high-latency links, while smaller ones save memory on hosts
+// with many connections. A size of zero leaves the system default.
+type SocketBufferListener struct {
+	net.Listener
+	readBufferSize  int
+	writeBufferSize int
+}
+
+func NewSocketBufferListener(l net.Listener, readBufferSize, writeBufferSize int) net.Listener {
+	if readBufferSize <= 0 && writeBufferSize <= 0 {
+		return l
+	}
+
+	return &SocketBufferListener{
+		Listener:        l,
+		readBufferSize:  readBufferSize,
+		writeBufferSize: writeBufferSize,
+	}
+}
+
+func (l *SocketBufferListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+
+	if l.readBufferSize > 0 {
+		if err := tcpConn.SetReadBuffer(l.readBufferSize); err != nil {
+			slog.Debug("Unable to set socket read buffer size", "size", l.readBufferSize, "error", err)
+		}
+	}
+	if l.writeBufferSize > 0 {
+		if err := tcpConn.SetWriteBuffer(l.writeBufferSize); err != nil {
+			slog.Debug("Unable to set socket write buffer size", "size", l.writeBufferSize, "error", err)
+		}
+	}
+
+	return tcpConn, nil
+}
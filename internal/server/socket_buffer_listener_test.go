@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocketBufferListener_SetsBufferSizes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l = NewSocketBufferListener(l, 64*1024, 128*1024)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	require.True(t, ok, "connections should not be wrapped")
+
+	// Linux doubles the requested sizes, to allow for bookkeeping overhead.
+	assert.GreaterOrEqual(t, testSocketOption(t, tcpConn, syscall.SO_RCVBUF), 64*1024)
+	assert.GreaterOrEqual(t, testSocketOption(t, tcpConn, syscall.SO_SNDBUF), 128*1024)
+}
+
+func TestSocketBufferListener_NotNeededWithoutSizes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	assert.Same(t, l, NewSocketBufferListener(l, 0, 0))
+}
+
+func TestTarget_UsesProxyBufferSize(t *testing.T) {
+	SetProxyBufferSize(4 * 1024)
+	t.Cleanup(func() { SetProxyBufferSize(DefaultProxyBufferSize) })
+
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
+	proxy := target.createProxyHandler().(*httputil.ReverseProxy)
+	assert.Len(t, proxy.BufferPool.Get(), 4*1024)
+}
+
+// Helpers
+
+func testSocketOption(t *testing.T, conn *net.TCPConn, option int) int {
+	raw, err := conn.SyscallConn()
+	require.NoError(t, err)
+
+	var value int
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		value, optErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, option)
+	})
+	require.NoError(t, err)
+	require.NoError(t, optErr)
+	return value
+}
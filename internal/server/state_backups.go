@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StateBackupTimestampFormat = "20060102T150405Z"
+
+	DefaultStateBackupInterval  = time.Hour
+	DefaultStateBackupRetention = 24
+
+	stateBackupExtension = ".state"
+)
+
+var ErrorStateBackupNotFound = errors.New("state backup not found")
+
+// StateBackups keeps timestamped copies of the state, so that the services
+// can be returned to an earlier point if a change goes wrong. Only the most
+// recent backups are kept.
+type StateBackups struct {
+	dir    string
+	retain int
+	lock   sync.Mutex
+}
+
+func NewStateBackups(dir string, retain int) *StateBackups {
+	return &StateBackups{
+		dir:    dir,
+		retain: retain,
+	}
+}
+
+// Save writes a new backup of data, unless it's the same as the most recent
+// one. It returns the timestamp of the backup, or an empty string if nothing
+// was written.
+func (b *StateBackups) Save(data []byte, now time.Time) (string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	timestamps, err := b.list()
+	if err != nil {
+		return "", err
+	}
+
+	if len(timestamps) > 0 {
+		latest, err := os.ReadFile(b.path(timestamps[len(timestamps)-1]))
+		if err == nil && bytes.Equal(latest, data) {
+			return "", nil
+		}
+	}
+
+	err = os.MkdirAll(b.dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	timestamp := now.UTC().Format(StateBackupTimestampFormat)
+	err = writeFileAtomically(b.path(timestamp), data)
+	if err != nil {
+		return "", err
+	}
+
+	timestamps = append(slices.DeleteFunc(timestamps, func(t string) bool { return t == timestamp }), timestamp)
+	for len(timestamps) > b.retain {
+		os.Remove(b.path(timestamps[0]))
+		timestamps = timestamps[1:]
+	}
+
+	return timestamp, nil
+}
+
+// List returns the timestamps of the available backups, oldest first.
+func (b *StateBackups) List() ([]string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.list()
+}
+
+func (b *StateBackups) Read(timestamp string) ([]byte, error) {
+	_, err := time.Parse(StateBackupTimestampFormat, timestamp)
+	if err != nil {
+		return nil, ErrorStateBackupNotFound
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	data, err := os.ReadFile(b.path(timestamp))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrorStateBackupNotFound
+	}
+	return data, err
+}
+
+// Private
+
+func (b *StateBackups) list() ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	timestamps := []string{}
+	for _, entry := range entries {
+		timestamp, ok := strings.CutSuffix(entry.Name(), stateBackupExtension)
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(StateBackupTimestampFormat, timestamp); err == nil {
+			timestamps = append(timestamps, timestamp)
+		}
+	}
+
+	slices.Sort(timestamps)
+	return timestamps, nil
+}
+
+func (b *StateBackups) path(timestamp string) string {
+	return filepath.Join(b.dir, timestamp+stateBackupExtension)
+}
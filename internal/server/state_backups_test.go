@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateBackups_SaveAndRead(t *testing.T) {
+	backups := NewStateBackups(t.TempDir(), 5)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	timestamp, err := backups.Save([]byte("first"), now)
+	require.NoError(t, err)
+	assert.Equal(t, "20240601T120000Z", timestamp)
+
+	data, err := backups.Read(timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+
+	_, err = backups.Read("20240601T130000Z")
+	assert.ErrorIs(t, err, ErrorStateBackupNotFound)
+
+	_, err = backups.Read("../kamal-proxy")
+	assert.ErrorIs(t, err, ErrorStateBackupNotFound)
+}
+
+func TestStateBackups_UnchangedStateIsNotSavedAgain(t *testing.T) {
+	backups := NewStateBackups(t.TempDir(), 5)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err := backups.Save([]byte("first"), now)
+	require.NoError(t, err)
+
+	timestamp, err := backups.Save([]byte("first"), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, timestamp)
+
+	timestamp, err = backups.Save([]byte("second"), now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "20240601T140000Z", timestamp)
+
+	list, err := backups.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240601T120000Z", "20240601T140000Z"}, list)
+}
+
+func TestStateBackups_OnlyTheMostRecentAreKept(t *testing.T) {
+	backups := NewStateBackups(t.TempDir(), 2)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, data := range []string{"first", "second", "third"} {
+		_, err := backups.Save([]byte(data), now.Add(time.Duration(i)*time.Hour))
+		require.NoError(t, err)
+	}
+
+	list, err := backups.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240601T130000Z", "20240601T140000Z"}, list)
+}
+
+func TestStateBackups_ListIsEmptyBeforeFirstBackup(t *testing.T) {
+	backups := NewStateBackups(t.TempDir()+"/missing", 2)
+
+	list, err := backups.List()
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
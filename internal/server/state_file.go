@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// StateVersion is the version of the state file format that we write. Older
+// versions are migrated when they're read.
+//
+// Version 1 was a bare list of services. Version 2 wraps that list with its
+// version and a checksum. Version 3 adds the target pools, which are covered
+// by the checksum along with the services.
+const StateVersion = 3
+
+var (
+	ErrorStateChecksumMismatch = errors.New("state checksum does not match its contents")
+	ErrorStateVersionTooNew    = errors.New("state was saved by a newer version of kamal-proxy")
+	ErrorStateVersionInvalid   = errors.New("state has an invalid version")
+)
+
+type stateFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Services json.RawMessage `json:"services"`
+	Pools    json.RawMessage `json:"pools,omitempty"`
+}
+
+// stateMigrations upgrade a state file from the version they're keyed by to
+// the next one.
+var stateMigrations = map[int]func(stateFile) (stateFile, error){
+	1: func(sf stateFile) (stateFile, error) {
+		sf.Version = 2
+		return sf, nil
+	},
+	2: func(sf stateFile) (stateFile, error) {
+		sf.Version = 3
+		return sf, nil
+	},
+}
+
+func encodeState(services []*Service) ([]byte, error) {
+	return encodeStateWithPools(services, nil)
+}
+
+func encodeStateWithPools(services []*Service, pools map[string][]string) ([]byte, error) {
+	encoded, err := json.Marshal(services)
+	if err != nil {
+		return nil, err
+	}
+
+	var encodedPools json.RawMessage
+	if len(pools) > 0 {
+		encodedPools, err = json.Marshal(pools)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(stateFile{
+		Version:  StateVersion,
+		Checksum: stateChecksum(encoded, encodedPools),
+		Services: encoded,
+		Pools:    encodedPools,
+	})
+}
+
+// decodeStatePools reads the target pools from a state file. Files saved
+// before pools existed have none.
+func decodeStatePools(data []byte) (map[string][]string, error) {
+	sf, err := readState(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pools := map[string][]string{}
+	if len(sf.Pools) > 0 {
+		err = json.Unmarshal(sf.Pools, &pools)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pools, nil
+}
+
+func decodeState(data []byte) ([]*Service, error) {
+	encoded, err := readStateServices(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []*Service
+	err = json.Unmarshal(encoded, &services)
+	if err != nil {
+		return nil, err
+	}
+
+	return services, nil
+}
+
+// decodeStateDescriptions reads the services in a state file without
+// creating them, so that they can be checked before they're used.
+func decodeStateDescriptions(data []byte) ([]marshalledService, error) {
+	encoded, err := readStateServices(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []marshalledService
+	err = json.Unmarshal(encoded, &services)
+	if err != nil {
+		return nil, err
+	}
+
+	return services, nil
+}
+
+func readStateServices(data []byte) (json.RawMessage, error) {
+	sf, err := readState(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return sf.Services, nil
+}
+
+func readState(data []byte) (stateFile, error) {
+	sf, err := readStateFile(data)
+	if err != nil {
+		return sf, err
+	}
+
+	for sf.Version < StateVersion {
+		migrate, ok := stateMigrations[sf.Version]
+		if !ok {
+			return sf, fmt.Errorf("%w: %d", ErrorStateVersionInvalid, sf.Version)
+		}
+
+		sf, err = migrate(sf)
+		if err != nil {
+			return sf, fmt.Errorf("unable to migrate state from version %d: %w", sf.Version, err)
+		}
+	}
+
+	return sf, nil
+}
+
+func readStateFile(data []byte) (stateFile, error) {
+	data = bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(data, []byte("[")) {
+		// Version 1 files have no envelope or checksum.
+		return stateFile{Version: 1, Services: data}, nil
+	}
+
+	var sf stateFile
+	err := json.Unmarshal(data, &sf)
+	if err != nil {
+		return sf, err
+	}
+
+	if sf.Version < 1 {
+		return sf, fmt.Errorf("%w: %d", ErrorStateVersionInvalid, sf.Version)
+	}
+	if sf.Version > StateVersion {
+		return sf, fmt.Errorf("%w (version %d)", ErrorStateVersionTooNew, sf.Version)
+	}
+
+	var compacted, compactedPools bytes.Buffer
+	err = json.Compact(&compacted, sf.Services)
+	if err != nil {
+		return sf, err
+	}
+	if len(sf.Pools) > 0 {
+		err = json.Compact(&compactedPools, sf.Pools)
+		if err != nil {
+			return sf, err
+		}
+	}
+	if stateChecksum(compacted.Bytes(), compactedPools.Bytes()) != sf.Checksum {
+		return sf, ErrorStateChecksumMismatch
+	}
+
+	return sf, nil
+}
+
+func stateChecksum(parts ...[]byte) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeFileAtomically replaces the file at path with data, so that readers
+// never see it partially written.
+func writeFileAtomically(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// reportStateError reports a failure to persist the state, which would
+// otherwise leave the proxy to lose its latest changes on restart.
+func reportStateError(action, path string, err error) {
+	reportError("state:"+action, ErrorReport{
+		Kind:    "state",
+		Message: fmt.Sprintf("Unable to %s state: %s", action, err),
+		Extra:   map[string]string{"path": path},
+	})
+}
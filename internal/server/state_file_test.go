@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState_RoundTrip(t *testing.T) {
+	service := testCreateService(t, []string{"example.com"}, defaultServiceOptions, defaultTargetOptions)
+
+	data, err := encodeState([]*Service{service})
+	require.NoError(t, err)
+
+	var sf stateFile
+	require.NoError(t, json.Unmarshal(data, &sf))
+	assert.Equal(t, StateVersion, sf.Version)
+	assert.NotEmpty(t, sf.Checksum)
+
+	services, err := decodeState(data)
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	assert.Equal(t, "test", services[0].name)
+	assert.Equal(t, service.active.Target(), services[0].active.Target())
+}
+
+func TestState_RoundTripWithPools(t *testing.T) {
+	service := testCreateService(t, []string{"example.com"}, defaultServiceOptions, defaultTargetOptions)
+
+	data, err := encodeStateWithPools([]*Service{service}, TargetPools{"web": {"web-1:3000"}})
+	require.NoError(t, err)
+
+	pools, err := decodeStatePools(data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"web": {"web-1:3000"}}, pools)
+
+	tampered := bytes.Replace(data, []byte("web-1:3000"), []byte("web-2:3000"), 1)
+	_, err = decodeState(tampered)
+	assert.ErrorIs(t, err, ErrorStateChecksumMismatch)
+}
+
+func TestState_MigratesVersion1(t *testing.T) {
+	service := testCreateService(t, []string{"example.com"}, defaultServiceOptions, defaultTargetOptions)
+
+	data, err := json.Marshal([]*Service{service})
+	require.NoError(t, err)
+
+	services, err := decodeState(data)
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	assert.Equal(t, []string{"example.com"}, services[0].hosts)
+}
+
+func TestState_DetectsCorruption(t *testing.T) {
+	service := testCreateService(t, []string{"example.com"}, defaultServiceOptions, defaultTargetOptions)
+
+	data, err := encodeState([]*Service{service})
+	require.NoError(t, err)
+
+	tampered := bytes.Replace(data, []byte("example.com"), []byte("example.org"), 1)
+	_, err = decodeState(tampered)
+	assert.ErrorIs(t, err, ErrorStateChecksumMismatch)
+
+	_, err = decodeState(data[:len(data)/2])
+	assert.Error(t, err)
+}
+
+func TestState_RejectsUnknownVersions(t *testing.T) {
+	_, err := decodeState([]byte(`{"version":99,"checksum":"","services":[]}`))
+	assert.ErrorIs(t, err, ErrorStateVersionTooNew)
+
+	_, err = decodeState([]byte(`{"checksum":"","services":[]}`))
+	assert.ErrorIs(t, err, ErrorStateVersionInvalid)
+}
+
+func TestRouter_RestoreCorruptedStateFails(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(statePath, []byte(`{"version":2,"checksum":"abc","services":[]}`), 0600))
+
+	router := NewRouter(statePath)
+	assert.ErrorIs(t, router.RestoreLastSavedState(), ErrorStateChecksumMismatch)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+)
+
+var DefaultStaticTargetPaths = []string{"/api", "/api/*"}
+
+// StaticMiddleware serves a target's static assets from a local directory,
+// and sends everything else on to the target. Reads of paths that the
+// directory can serve are answered from it, apart from those matching the
+// target paths, which always go to the target. With an SPA directory, this
+// serves an app's routes with its index.html, while its API is proxied.
+type StaticMiddleware struct {
+	directory   *DirectoryHandler
+	targetPaths []*regexp.Regexp
+	next        http.Handler
+}
+
+func WithStaticMiddleware(directory *DirectoryHandler, targetPaths []string, next http.Handler) http.Handler {
+	if len(targetPaths) == 0 {
+		targetPaths = DefaultStaticTargetPaths
+	}
+
+	patterns := []*regexp.Regexp{}
+	for _, path := range targetPaths {
+		patterns = append(patterns, globToRegexp(path))
+	}
+
+	return &StaticMiddleware{
+		directory:   directory,
+		targetPaths: patterns,
+		next:        next,
+	}
+}
+
+func (h *StaticMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.servesStatic(r) {
+		h.directory.ServeHTTP(w, r)
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (h *StaticMiddleware) servesStatic(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if slices.ContainsFunc(h.targetPaths, func(pattern *regexp.Regexp) bool { return pattern.MatchString(r.URL.Path) }) {
+		return false
+	}
+
+	return h.directory.Serves(r.URL.Path)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticMiddleware_ServesAssetsAndProxiesTheRest(t *testing.T) {
+	handler := testStaticMiddleware(t, DirectoryConfig{}, nil)
+
+	assert.Equal(t, "home", testDirectoryRequest(handler, http.MethodGet, "/", nil).Body.String())
+	assert.Equal(t, "0123456789", testDirectoryRequest(handler, http.MethodGet, "/builds/app.tar.gz", nil).Body.String())
+	assert.Equal(t, "target", testDirectoryRequest(handler, http.MethodGet, "/dashboard", nil).Body.String())
+	assert.Equal(t, "target", testDirectoryRequest(handler, http.MethodPost, "/builds/app.tar.gz", nil).Body.String())
+	assert.Equal(t, "target", testDirectoryRequest(handler, http.MethodGet, "/.env", nil).Body.String())
+}
+
+func TestStaticMiddleware_SPA(t *testing.T) {
+	handler := testStaticMiddleware(t, DirectoryConfig{SPA: true}, nil)
+
+	assert.Equal(t, "home", testDirectoryRequest(handler, http.MethodGet, "/dashboard/settings", nil).Body.String())
+	assert.Equal(t, "0123456789", testDirectoryRequest(handler, http.MethodGet, "/builds/app.tar.gz", nil).Body.String())
+	assert.Equal(t, "target", testDirectoryRequest(handler, http.MethodGet, "/missing.js", nil).Body.String())
+	assert.Equal(t, "target", testDirectoryRequest(handler, http.MethodGet, "/api", nil).Body.String())
+	assert.Equal(t, "target", testDirectoryRequest(handler, http.MethodGet, "/api/users", nil).Body.String())
+}
+
+func TestStaticMiddleware_TargetPaths(t *testing.T) {
+	handler := testStaticMiddleware(t, DirectoryConfig{SPA: true}, []string{"/graphql", "/auth/*"})
+
+	assert.Equal(t, "target", testDirectoryRequest(handler, http.MethodGet, "/graphql", nil).Body.String())
+	assert.Equal(t, "target", testDirectoryRequest(handler, http.MethodGet, "/auth/callback", nil).Body.String())
+	assert.Equal(t, "home", testDirectoryRequest(handler, http.MethodGet, "/api/users", nil).Body.String())
+}
+
+func TestTarget_StaticDirectory(t *testing.T) {
+	dir := testDirectory(t)
+
+	options := defaultTargetOptions
+	options.StaticDirectory = dir
+	options.SPA = true
+
+	target := testTargetWithOptions(t, options, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	})
+
+	for path, expected := range map[string]string{"/": "home", "/dashboard": "home", "/api/users": "target"} {
+		w := httptest.NewRecorder()
+		testServeRequestWithTarget(t, target, w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, expected, w.Body.String(), path)
+	}
+}
+
+// Helpers
+
+func testStaticMiddleware(t *testing.T, config DirectoryConfig, targetPaths []string) http.Handler {
+	directory, err := NewDirectoryHandler(testDirectory(t), config)
+	require.NoError(t, err)
+
+	target := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	})
+	return WithStaticMiddleware(directory, targetPaths, target)
+}
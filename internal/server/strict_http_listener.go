@@ -0,0 +1,476 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const strictHTTPReadBufferSize = 4096
+
+// strictHTTPRejection replaces a rejected request. It can't be parsed however
+// much of the request has already been read, so the server responds to it
+// with a 400 and closes the connection.
+var strictHTTPRejection = []byte("\x00\r\n\r\n")
+
+// strictHTTPCloseHeader is added to the head of each lenient request, so that
+// the server closes the connection once it has responded.
+var strictHTTPCloseHeader = []byte("Connection: close\r\n")
+
+type strictHTTPState int
+
+const (
+	strictHTTPStateHead strictHTTPState = iota
+	strictHTTPStateBody
+	strictHTTPStateChunkSize
+	strictHTTPStateChunkData
+	strictHTTPStateChunkDataEnd
+	strictHTTPStateTrailer
+	strictHTTPStatePassthrough
+)
+
+// StrictHTTPListener checks the framing of the HTTP/1 requests on each
+// connection that it accepts, before the server parses them, and rejects
+// those that could be read in more than one way. Different servers resolving
+// them differently is what makes request smuggling possible, and the server
+// would otherwise accept many of them, by merging folded header lines, or
+// by using one of several Content-Length headers.
+//
+// Requests are rejected when they have:
+//   - header lines folded onto the next line (obs-fold)
+//   - more than one Content-Length, or one that isn't a number
+//   - a Transfer-Encoding other than chunked, or one alongside a Content-Length
+//   - control or non-ASCII characters in the request line or headers
+//   - whitespace between a header's name and its colon
+//   - lines that end in a bare LF
+//
+// Requests to a host that allows lenient parsing are passed to the server as
+// they are, except that the connection is closed after them. Since we can't
+// tell where such a request ends, anything following it on the connection
+// would otherwise reach the server unchecked.
+//
+// Over TLS, connections that negotiated HTTP/1 are checked in the same way,
+// while those that negotiated HTTP/2, which frames its requests itself, are
+// passed on as they are. The server only sees that a connection is using TLS
+// when it's a *tls.Conn, so the state of each one that is checked is kept in
+// its context by StrictHTTPConnContext, and put back on its requests by
+// WithStrictHTTPTLSMiddleware.
+type StrictHTTPListener struct {
+	net.Listener
+	lenient func(host string) bool
+}
+
+func NewStrictHTTPListener(l net.Listener, lenient func(host string) bool) *StrictHTTPListener {
+	return &StrictHTTPListener{Listener: l, lenient: lenient}
+}
+
+func (l *StrictHTTPListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return newStrictHTTPConn(conn, l.lenient), nil
+	}
+
+	state := tlsConn.ConnectionState()
+	if state.NegotiatedProtocol != "" && state.NegotiatedProtocol != "http/1.1" {
+		return conn, nil
+	}
+	return &strictHTTPTLSConn{strictHTTPConn: newStrictHTTPConn(conn, l.lenient), state: state}, nil
+}
+
+type strictHTTPTLSStateContextKey struct{}
+
+// StrictHTTPConnContext keeps the TLS state of a checked connection in its
+// context, for WithStrictHTTPTLSMiddleware to find.
+func StrictHTTPConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*strictHTTPTLSConn); ok {
+		return context.WithValue(ctx, strictHTTPTLSStateContextKey{}, tlsConn.state)
+	}
+	return ctx
+}
+
+// WithStrictHTTPTLSMiddleware sets the TLS state of requests that arrived on
+// checked TLS connections, which the server leaves unset.
+func WithStrictHTTPTLSMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			if state, ok := r.Context().Value(strictHTTPTLSStateContextKey{}).(tls.ConnectionState); ok {
+				r.TLS = &state
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// strictHTTPTLSConn is a checked connection that arrived over TLS.
+type strictHTTPTLSConn struct {
+	*strictHTTPConn
+	state tls.ConnectionState
+}
+
+func (c *strictHTTPTLSConn) ConnectionState() tls.ConnectionState {
+	return c.state
+}
+
+type strictHTTPConn struct {
+	net.Conn
+	lenient func(host string) bool
+
+	buf      []byte
+	pending  []byte
+	rejected bool
+	heldCR   bool
+
+	state     strictHTTPState
+	remaining int64
+	head      strictHTTPHead
+	line      []byte
+}
+
+// strictHTTPHead is what we have learned about the head of the request that
+// is being read.
+type strictHTTPHead struct {
+	lines          int
+	size           int
+	host           string
+	contentLengths int
+	contentLength  int64
+	chunked        bool
+	upgrade        bool
+	problem        string
+}
+
+func newStrictHTTPConn(conn net.Conn, lenient func(host string) bool) *strictHTTPConn {
+	return &strictHTTPConn{
+		Conn:    conn,
+		lenient: lenient,
+		buf:     make([]byte, strictHTTPReadBufferSize),
+	}
+}
+
+func (c *strictHTTPConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.rejected {
+			return 0, io.EOF
+		}
+		if c.state == strictHTTPStatePassthrough {
+			return c.Conn.Read(p)
+		}
+
+		// A CR that was held back by scan is read again, ahead of what
+		// follows it.
+		start := 0
+		if c.heldCR {
+			c.buf[0] = '\r'
+			c.heldCR = false
+			start = 1
+		}
+
+		n, err := c.Conn.Read(c.buf[start:])
+		c.pending = c.scan(c.buf[:start+n])
+		if len(c.pending) == 0 && err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// ReadFrom and CloseWrite are passed on to the connection, so that the
+// server can still use them when they're available.
+func (c *strictHTTPConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{c.Conn}, r)
+}
+
+func (c *strictHTTPConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// Private
+
+// scan follows the requests in data, returning what should be passed on to
+// the server: either data itself, or, when a request is rejected, whatever
+// came before it followed by strictHTTPRejection. Lenient requests have
+// strictHTTPCloseHeader added to their head.
+func (c *strictHTTPConn) scan(data []byte) []byte {
+	headStart := -1
+	if c.state == strictHTTPStateHead && len(c.line) == 0 && c.head.lines == 0 {
+		headStart = 0
+	}
+
+	for i := 0; i < len(data); {
+		switch c.state {
+		case strictHTTPStatePassthrough:
+			return data
+
+		case strictHTTPStateBody, strictHTTPStateChunkData:
+			n := min(int64(len(data)-i), c.remaining)
+			i += int(n)
+			c.remaining -= n
+			if c.remaining == 0 {
+				if c.state == strictHTTPStateBody {
+					c.state = strictHTTPStateHead
+					headStart = i
+				} else {
+					c.state = strictHTTPStateChunkDataEnd
+				}
+			}
+
+		default:
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				// A lone CR may start the line that ends the head, which
+				// is held back so that it isn't split from its LF if a
+				// header needs to be added before it.
+				if c.state == strictHTTPStateHead && c.head.lines > 0 && len(c.line) == 0 && len(data)-i == 1 && data[i] == '\r' {
+					c.heldCR = true
+					return data[:i]
+				}
+
+				c.appendLine(data[i:])
+				i = len(data)
+				continue
+			}
+
+			c.appendLine(data[i : i+end+1])
+			i += end + 1
+			line := c.line
+			c.line = c.line[:0]
+
+			if c.state != strictHTTPStateHead {
+				c.chunkLineCompleted(line)
+				if c.state == strictHTTPStateHead {
+					headStart = i
+				}
+				continue
+			}
+
+			if !c.headLineCompleted(line) {
+				continue
+			}
+
+			if c.head.problem != "" {
+				if !c.lenient(c.head.host) {
+					slog.Info("Rejecting ambiguous request", "host", c.head.host, "remote_addr", c.RemoteAddr().String(), "problem", c.head.problem)
+					c.rejected = true
+
+					result := bytes.Clone(data[:max(headStart, 0)])
+					return append(result, strictHTTPRejection...)
+				}
+
+				slog.Debug("Allowing ambiguous request", "host", c.head.host, "remote_addr", c.RemoteAddr().String(), "problem", c.head.problem)
+				c.head = strictHTTPHead{}
+				c.state = strictHTTPStatePassthrough
+
+				headEnd := i - len(line)
+				result := bytes.Clone(data[:headEnd])
+				result = append(result, strictHTTPCloseHeader...)
+				return append(result, data[headEnd:]...)
+			}
+
+			c.headCompleted()
+			if c.state == strictHTTPStateHead {
+				headStart = i
+			}
+		}
+	}
+
+	return data
+}
+
+func (c *strictHTTPConn) appendLine(data []byte) {
+	c.line = append(c.line, data...)
+	if c.state == strictHTTPStateHead {
+		c.head.size += len(data)
+	}
+
+	// Heads and lines that are too large for the server are rejected by it,
+	// so we don't need to keep following them.
+	if c.head.size > http.DefaultMaxHeaderBytes || len(c.line) > http.DefaultMaxHeaderBytes {
+		c.state = strictHTTPStatePassthrough
+	}
+}
+
+// headLineCompleted checks a line of the request head, returning true once
+// the head is complete.
+func (c *strictHTTPConn) headLineCompleted(line []byte) bool {
+	content, ok := bytes.CutSuffix(line, []byte("\r\n"))
+	if !ok {
+		content = bytes.TrimSuffix(line, []byte("\n"))
+		c.addProblem("line ends in a bare LF")
+	}
+
+	if len(content) == 0 {
+		if c.head.lines == 0 {
+			// Empty lines before the request line are allowed.
+			c.head = strictHTTPHead{}
+			return false
+		}
+		if c.head.chunked && c.head.contentLengths > 0 {
+			c.addProblem("both Transfer-Encoding and Content-Length")
+		}
+		return true
+	}
+
+	c.head.lines++
+	if c.head.lines == 1 {
+		c.requestLineCompleted(content)
+	} else {
+		c.headerLineCompleted(content)
+	}
+	return false
+}
+
+func (c *strictHTTPConn) requestLineCompleted(line []byte) {
+	if !strictHTTPValidText(line, false) {
+		c.addProblem("invalid character in request line")
+	}
+
+	method, _, _ := bytes.Cut(line, []byte(" "))
+	if bytes.Equal(method, []byte("CONNECT")) || bytes.Equal(method, []byte("PRI")) {
+		c.head.upgrade = true
+	}
+}
+
+func (c *strictHTTPConn) headerLineCompleted(line []byte) {
+	if line[0] == ' ' || line[0] == '\t' {
+		c.addProblem("folded header line")
+		return
+	}
+
+	name, value, ok := bytes.Cut(line, []byte(":"))
+	if !ok {
+		// The server will reject the request.
+		return
+	}
+	if !strictHTTPValidToken(name) {
+		c.addProblem("invalid header name")
+		return
+	}
+
+	value = bytes.Trim(value, " \t")
+	if !strictHTTPValidText(value, true) {
+		c.addProblem("invalid character in header value")
+	}
+
+	switch http.CanonicalHeaderKey(string(name)) {
+	case "Host":
+		c.head.host = string(value)
+		if host, _, err := net.SplitHostPort(c.head.host); err == nil {
+			c.head.host = host
+		}
+
+	case "Content-Length":
+		c.head.contentLengths++
+		length, err := strconv.ParseInt(string(value), 10, 64)
+		if c.head.contentLengths > 1 {
+			c.addProblem("duplicate Content-Length")
+		} else if err != nil || length < 0 || value[0] == '+' {
+			c.addProblem("invalid Content-Length")
+		}
+		c.head.contentLength = length
+
+	case "Transfer-Encoding":
+		if c.head.chunked || !bytes.EqualFold(value, []byte("chunked")) {
+			c.addProblem("unsupported Transfer-Encoding")
+		}
+		c.head.chunked = true
+
+	case "Upgrade":
+		c.head.upgrade = true
+	}
+}
+
+// headCompleted moves on to the body of the request, if there is one.
+// Requests that may switch protocols are left to the server, along with
+// everything after them.
+func (c *strictHTTPConn) headCompleted() {
+	head := c.head
+	c.head = strictHTTPHead{}
+
+	switch {
+	case head.upgrade:
+		c.state = strictHTTPStatePassthrough
+	case head.chunked:
+		c.state = strictHTTPStateChunkSize
+	case head.contentLength > 0:
+		c.state = strictHTTPStateBody
+		c.remaining = head.contentLength
+	}
+}
+
+func (c *strictHTTPConn) chunkLineCompleted(line []byte) {
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+
+	switch c.state {
+	case strictHTTPStateChunkSize:
+		size, _, _ := bytes.Cut(line, []byte(";"))
+		length, err := strconv.ParseInt(string(bytes.TrimSpace(size)), 16, 64)
+		switch {
+		case err != nil || length < 0:
+			// The server will reject the request.
+			c.state = strictHTTPStatePassthrough
+		case length == 0:
+			c.state = strictHTTPStateTrailer
+		default:
+			c.state = strictHTTPStateChunkData
+			c.remaining = length
+		}
+
+	case strictHTTPStateChunkDataEnd:
+		c.state = strictHTTPStateChunkSize
+		if len(line) > 0 {
+			c.state = strictHTTPStatePassthrough
+		}
+
+	case strictHTTPStateTrailer:
+		if len(line) == 0 {
+			c.state = strictHTTPStateHead
+			c.head = strictHTTPHead{}
+		}
+	}
+}
+
+func (c *strictHTTPConn) addProblem(problem string) {
+	if c.head.problem == "" {
+		c.head.problem = problem
+	}
+}
+
+func strictHTTPValidText(text []byte, allowTab bool) bool {
+	for _, b := range text {
+		if (b < ' ' && !(allowTab && b == '\t')) || b >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func strictHTTPValidToken(token []byte) bool {
+	if len(token) == 0 {
+		return false
+	}
+	for _, b := range token {
+		if b <= ' ' || b >= 0x7f || bytes.IndexByte([]byte(`"(),/:;<=>?@[\]{}`), b) >= 0 {
+			return false
+		}
+	}
+	return true
+}
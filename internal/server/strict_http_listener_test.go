@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrictHTTPListener_AllowsWellFormedRequests(t *testing.T) {
+	addr := testStrictHTTPServer(t)
+
+	conn, reader := testStrictHTTPConnect(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, "200 ", testStrictHTTPResponse(t, reader))
+
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello"+
+		"POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Trailer: 1\r\n\r\n"+
+		"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, "200 hello", testStrictHTTPResponse(t, reader))
+	assert.Equal(t, "200 hello world", testStrictHTTPResponse(t, reader))
+	assert.Equal(t, "200 ", testStrictHTTPResponse(t, reader))
+}
+
+func TestStrictHTTPListener_RejectsAmbiguousRequests(t *testing.T) {
+	addr := testStrictHTTPServer(t)
+
+	requests := map[string]string{
+		"folded header":        "GET / HTTP/1.1\r\nHost: example.com\r\nX-Folded: a\r\n b\r\n\r\n",
+		"duplicate length":     "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 1\r\nContent-Length: 1\r\n\r\na",
+		"chunked and length":   "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		"other encoding":       "POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+		"space before colon":   "POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n",
+		"non-ASCII value":      "GET / HTTP/1.1\r\nHost: example.com\r\nX-Name: caf\xc3\xa9\r\n\r\n",
+		"bare LF":              "GET / HTTP/1.1\nHost: example.com\n\n",
+		"signed length":        "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: +1\r\n\r\na",
+		"control in path":      "GET /\x01 HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"smuggled after valid": "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 2\r\n\r\nokGET / HTTP/1.1\r\nHost: example.com\r\nX-Folded: a\r\n\tb\r\n\r\n",
+	}
+
+	for name, request := range requests {
+		t.Run(name, func(t *testing.T) {
+			conn, reader := testStrictHTTPConnect(t, addr)
+			io.WriteString(conn, request)
+
+			if name == "smuggled after valid" {
+				assert.Equal(t, "200 ok", testStrictHTTPResponse(t, reader))
+			}
+			assert.Equal(t, "400", testStrictHTTPResponse(t, reader)[:3])
+		})
+	}
+}
+
+func TestStrictHTTPListener_RejectsRequestsSplitAcrossReads(t *testing.T) {
+	addr := testStrictHTTPServer(t)
+
+	for _, split := range []string{"GET / HT", "GET / HTTP/1.1\r\nHost: exam", "GET / HTTP/1.1\r\nHost: example.com\r\n", "GET / HTTP/1.1\r\nHost: example.com\r\nX-Folded: a\r"} {
+		t.Run(split, func(t *testing.T) {
+			request := "GET / HTTP/1.1\r\nHost: example.com\r\nX-Folded: a\r\n b\r\n\r\n"
+
+			conn, reader := testStrictHTTPConnect(t, addr)
+			io.WriteString(conn, split)
+			time.Sleep(20 * time.Millisecond)
+			io.WriteString(conn, request[len(split):])
+
+			assert.Equal(t, "400", testStrictHTTPResponse(t, reader)[:3])
+		})
+	}
+}
+
+func TestStrictHTTPListener_AllowsLenientHosts(t *testing.T) {
+	addr := testStrictHTTPServer(t)
+
+	conn, reader := testStrictHTTPConnect(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: legacy.example.com:80\r\nX-Folded: a\r\n b\r\n\r\n")
+	assert.Equal(t, "200 ", testStrictHTTPResponse(t, reader))
+}
+
+func TestStrictHTTPListener_ClosesConnectionAfterLenientRequest(t *testing.T) {
+	addr := testStrictHTTPServer(t)
+
+	smuggled := "GET / HTTP/1.1\r\nHost: example.com\r\nX-Folded: a\r\n\tb\r\n\r\n"
+	requests := map[string][]string{
+		"folded header":   {"GET / HTTP/1.1\r\nHost: legacy.example.com\r\nX-Folded: a\r\n b\r\n\r\n" + smuggled},
+		"bare LF":         {"GET / HTTP/1.1\nHost: legacy.example.com\n\n" + smuggled},
+		"with body":       {"POST / HTTP/1.1\r\nHost: legacy.example.com\r\nContent-Length: 2\r\nX-Folded: a\r\n b\r\n\r\nok" + smuggled},
+		"split ending":    {"GET / HTTP/1.1\r\nHost: legacy.example.com\r\nX-Folded: a\r\n b\r\n\r", "\n" + smuggled},
+		"split before CR": {"GET / HTTP/1.1\r\nHost: legacy.example.com\r\nX-Folded: a\r\n b\r\n", "\r\n" + smuggled},
+	}
+
+	for name, writes := range requests {
+		t.Run(name, func(t *testing.T) {
+			conn, reader := testStrictHTTPConnect(t, addr)
+			for _, write := range writes {
+				io.WriteString(conn, write)
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			expected := "200 "
+			if name == "with body" {
+				expected = "200 ok"
+			}
+			assert.Equal(t, expected, testStrictHTTPResponse(t, reader))
+
+			_, err := reader.ReadByte()
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestStrictHTTPListener_ChecksHTTP1RequestsOverTLS(t *testing.T) {
+	addr := testStrictHTTPTLSServer(t)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, "200 HTTP/1.1 over TLS", testStrictHTTPResponse(t, reader))
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nX-Folded: a\r\n b\r\n\r\n")
+	assert.Equal(t, "400", testStrictHTTPResponse(t, reader)[:3])
+}
+
+func TestStrictHTTPListener_PassesHTTP2ConnectionsThrough(t *testing.T) {
+	addr := testStrictHTTPTLSServer(t)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + addr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2.0 over TLS", string(body))
+}
+
+// Helpers
+
+func testStrictHTTPServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	lenient := func(host string) bool { return host == "legacy.example.com" }
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})}
+	go server.Serve(NewStrictHTTPListener(l, lenient))
+	t.Cleanup(func() { server.Close() })
+
+	return l.Addr().String()
+}
+
+func testStrictHTTPTLSServer(t *testing.T) string {
+	cert, err := tls.X509KeyPair([]byte(certPem), []byte(keyPem))
+	require.NoError(t, err)
+	config := &tls.Config{NextProtos: []string{"h2", "http/1.1"}, Certificates: []tls.Certificate{cert}}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	hostLabel := func(host string) string { return host }
+	lenient := func(host string) bool { return false }
+	server := &http.Server{
+		Handler: WithStrictHTTPTLSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				io.WriteString(w, r.Proto+" over TLS")
+			}
+		})),
+		TLSConfig:   config,
+		ConnContext: StrictHTTPConnContext,
+	}
+	go server.Serve(NewStrictHTTPListener(NewTLSListener(l, config, hostLabel), lenient))
+	t.Cleanup(func() { server.Close() })
+
+	return l.Addr().String()
+}
+
+func testStrictHTTPConnect(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func testStrictHTTPResponse(t *testing.T, reader *bufio.Reader) string {
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.Status
+	}
+	return "200 " + string(body)
+}
@@ -5,13 +5,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,8 +28,9 @@ const (
 )
 
 var (
-	ErrorInvalidHostPattern = errors.New("invalid host pattern")
-	ErrorDraining           = errors.New("target is draining")
+	ErrorInvalidHostPattern      = errors.New("invalid host pattern")
+	ErrorDraining                = errors.New("target is draining")
+	ErrorUnableToLoadTimeoutPage = errors.New("unable to load timeout page")
 
 	hostRegex = regexp.MustCompile(`^(\w[-_.\w+]+)(:\d+)?$`)
 )
@@ -49,6 +58,9 @@ func (ts TargetState) String() string {
 type inflightRequest struct {
 	cancel   context.CancelCauseFunc
 	hijacked bool
+
+	deadline      time.Time
+	deadlineTimer *time.Timer
 }
 
 type inflightMap map[*http.Request]*inflightRequest
@@ -63,29 +75,211 @@ type TargetOptions struct {
 	MaxResponseBodySize int64             `json:"max_response_body_size"`
 	LogRequestHeaders   []string          `json:"log_request_headers"`
 	LogResponseHeaders  []string          `json:"log_response_headers"`
+	LogQueryParams      []string          `json:"log_query_params"`
+	LogTagRules         []string          `json:"log_tag_rules"`
 	ForwardHeaders      bool              `json:"forward_headers"`
+	TimeoutPagePath     string            `json:"timeout_page_path"`
+	PrewarmConnections  int               `json:"prewarm_connections"`
+	DNSRefreshInterval  time.Duration     `json:"dns_refresh_interval"`
+	Discovery           string            `json:"discovery"`
+	DiscoveryInterval   time.Duration     `json:"discovery_interval"`
+	Labels              map[string]string `json:"labels"`
+
+	DrainResponseHeaders   bool     `json:"drain_response_headers"`
+	UnbufferedRequestPaths []string `json:"unbuffered_request_paths"`
+	Retries                int      `json:"retries"`
+	DeduplicateRequests    bool     `json:"deduplicate_requests"`
+
+	// HedgeDelay sends a second copy of a slow read request to another of the
+	// target's endpoints once it has waited this long, using whichever
+	// responds first. Only requests for the HedgePaths are hedged, if given.
+	HedgeDelay time.Duration `json:"hedge_delay,omitempty"`
+	HedgePaths []string      `json:"hedge_paths,omitempty"`
+
+	// ResponseRewrites are rules for changing responses, such as replacing
+	// absolute URLs in HTML when the target is served under another host.
+	// Bodies are only rewritten when they fit in the memory buffer.
+	ResponseRewrites            []string `json:"response_rewrites,omitempty"`
+	ResponseRewriteContentTypes []string `json:"response_rewrite_content_types,omitempty"`
+
+	// ResponseValidations are rules that responses from the target are
+	// expected to satisfy. Violations are logged, and, depending on the
+	// ResponseValidationAction, also fixed or turned into a 502.
+	ResponseValidations      []string `json:"response_validations,omitempty"`
+	ResponseValidationAction string   `json:"response_validation_action,omitempty"`
+
+	// GenerateETags answers conditional requests for buffered responses, and
+	// gives those of the ETagContentTypes an ETag if the target didn't. See
+	// WithConditionalResponseBufferMiddleware.
+	GenerateETags    bool     `json:"generate_etags,omitempty"`
+	ETagContentTypes []string `json:"etag_content_types,omitempty"`
+
+	// ServeRanges answers Range requests from buffered responses, for targets
+	// that send the whole body. See WithConditionalResponseBufferMiddleware.
+	ServeRanges bool `json:"serve_ranges,omitempty"`
+
+	// PriorityRules decide which requests are shed first when too many are
+	// in flight: low priority ones once there are ShedLowAt, and normal ones
+	// too at ShedNormalAt. Critical requests are never shed.
+	PriorityRules  []string      `json:"priority_rules,omitempty"`
+	ShedLowAt      int           `json:"shed_low_at,omitempty"`
+	ShedNormalAt   int           `json:"shed_normal_at,omitempty"`
+	ShedRetryAfter time.Duration `json:"shed_retry_after,omitempty"`
+
+	// FairShare is the percentage of the target's in-flight requests that any
+	// one client may hold, once at least FairShareMinInflight are in flight.
+	// Clients are identified by the FairShareKey header if given, or their IP.
+	FairShare            int    `json:"fair_share,omitempty"`
+	FairShareMinInflight int    `json:"fair_share_min_inflight,omitempty"`
+	FairShareKey         string `json:"fair_share_key,omitempty"`
+
+	// Balance chooses how requests are spread across the target's addresses,
+	// when it has more than one: in turn (round-robin), to whichever has the
+	// fastest recent responses for its requests in flight (least-latency), or
+	// to whichever reports the lowest load from BalanceLoadPath (least-loaded).
+	// With hash, each client sticks to one address, chosen by a hash of the
+	// BalanceHashHeader if given, or their IP.
+	Balance           string `json:"balance,omitempty"`
+	BalanceLoadPath   string `json:"balance_load_path,omitempty"`
+	BalanceHashHeader string `json:"balance_hash_header,omitempty"`
+
+	// TargetProtocol is the protocol that requests are sent to the target
+	// with: HTTP/1.1 (http1, the default), or HTTP/2 without TLS (h2c).
+	TargetProtocol string `json:"target_protocol,omitempty"`
+
+	// IPFamily limits connections to the target to IPv4 or IPv6, or prefers
+	// one while falling back to the other after HappyEyeballsDelay. See
+	// newTargetDialer.
+	IPFamily           string        `json:"ip_family,omitempty"`
+	HappyEyeballsDelay time.Duration `json:"happy_eyeballs_delay,omitempty"`
+
+	// DeadlineHeader, when set, tells the target how many milliseconds it has
+	// left to respond. Clients may ask for a shorter timeout than the
+	// ResponseTimeout with the ClientTimeoutHeader. See startRequestDeadline.
+	DeadlineHeader      string `json:"deadline_header,omitempty"`
+	ClientTimeoutHeader string `json:"client_timeout_header,omitempty"`
+
+	// UpgradedIdleTimeout closes upgraded connections, such as WebSockets,
+	// that have had no traffic in either direction for this long.
+	UpgradedIdleTimeout time.Duration `json:"upgraded_idle_timeout,omitempty"`
+
+	// DirectoryListing and DirectoryCredentials apply to targets that serve
+	// a local directory, given as a file:// URL. See DirectoryHandler.
+	DirectoryListing     bool     `json:"directory_listing,omitempty"`
+	DirectoryCredentials []string `json:"directory_credentials,omitempty"`
+
+	// StaticDirectory serves the target's static assets from a local
+	// directory, sending other requests, and those for StaticTargetPaths, to
+	// the target. With SPA, paths without a file extension that aren't in the
+	// directory are served its index.html. See StaticMiddleware.
+	StaticDirectory   string   `json:"static_directory,omitempty"`
+	StaticTargetPaths []string `json:"static_target_paths,omitempty"`
+	SPA               bool     `json:"spa,omitempty"`
+
+	// Precompressed serves .br and .gz variants of local files, where they
+	// exist, to clients that accept them, for both directory targets and the
+	// StaticDirectory.
+	Precompressed bool `json:"precompressed,omitempty"`
+
+	// MaxRequestHeaderSize and MaxRequestHeaderCount limit the headers that
+	// are forwarded to the target. Requests over them are rejected, or
+	// trimmed, according to RequestHeaderLimitAction.
+	MaxRequestHeaderSize     int64  `json:"max_request_header_size,omitempty"`
+	MaxRequestHeaderCount    int    `json:"max_request_header_count,omitempty"`
+	RequestHeaderLimitAction string `json:"request_header_limit_action,omitempty"`
+
+	// RequestTimingHeaders sends X-Request-Start and X-Queue-Time to the
+	// target, for APMs to measure how long requests waited before reaching
+	// it, and logs the same values.
+	RequestTimingHeaders bool `json:"request_timing_headers,omitempty"`
+
+	// SmokeTestPaths are requested from a new target once it's healthy, and
+	// must all succeed before it receives traffic. See RunSmokeTests.
+	SmokeTestPaths []string `json:"smoke_test_paths,omitempty"`
+
+	// ExpectedVersion, when set, must be returned by the target's VersionPath
+	// before it receives traffic. See VerifyVersion.
+	ExpectedVersion string `json:"expected_version,omitempty"`
+	VersionPath     string `json:"version_path,omitempty"`
+
+	// BlockHealthCheckPath stops requests for the health check path from
+	// reaching the target through the proxy, except from the
+	// HealthCheckAllowedCIDRs. See HealthCheckAccess.
+	BlockHealthCheckPath    bool     `json:"block_health_check_path,omitempty"`
+	HealthCheckAllowedCIDRs []string `json:"health_check_allowed_cidrs,omitempty"`
+}
+
+func (to TargetOptions) requestHeaderLimits() RequestHeaderLimits {
+	return RequestHeaderLimits{
+		MaxSize:  to.MaxRequestHeaderSize,
+		MaxCount: to.MaxRequestHeaderCount,
+		Action:   to.RequestHeaderLimitAction,
+	}
+}
+
+func (to TargetOptions) conditionalResponseConfig() ConditionalResponseConfig {
+	config := ConditionalResponseConfig{Ranges: to.ServeRanges}
+	if to.GenerateETags {
+		config.ETagContentTypes = to.ETagContentTypes
+		if len(config.ETagContentTypes) == 0 {
+			config.ETagContentTypes = DefaultETagContentTypes
+		}
+	}
+	return config
 }
 
 func (to *TargetOptions) canonicalizeLogHeaders() {
 	for i, header := range to.LogRequestHeaders {
-		to.LogRequestHeaders[i] = http.CanonicalHeaderKey(header)
+		to.LogRequestHeaders[i] = canonicalizeLogHeader(header)
 	}
 	for i, header := range to.LogResponseHeaders {
-		to.LogResponseHeaders[i] = http.CanonicalHeaderKey(header)
+		to.LogResponseHeaders[i] = canonicalizeLogHeader(header)
 	}
 }
 
+// canonicalized returns a copy of the options as a target would have them,
+// without changing those it was made from.
+func (to TargetOptions) canonicalized() TargetOptions {
+	to.LogRequestHeaders = slices.Clone(to.LogRequestHeaders)
+	to.LogResponseHeaders = slices.Clone(to.LogResponseHeaders)
+	to.canonicalizeLogHeaders()
+	return to
+}
+
+// canonicalizeLogHeader canonicalizes the name of a header to log, which may
+// be followed by =field to log it under a name of our choosing.
+func canonicalizeLogHeader(header string) string {
+	name, field, found := strings.Cut(header, "=")
+	if !found {
+		return http.CanonicalHeaderKey(name)
+	}
+	return http.CanonicalHeaderKey(name) + "=" + field
+}
+
 type Target struct {
 	targetURL    *url.URL
 	options      TargetOptions
+	logTagRules  LogTagRules
+	timeoutPage  *timeoutPage
+	transport    targetTransport
 	proxyHandler http.Handler
 
 	state        TargetState
 	inflight     inflightMap
 	inflightLock sync.Mutex
 
-	healthcheck   *HealthCheck
-	becameHealthy chan (bool)
+	healthcheck        *HealthCheck
+	healthCheckHistory *HealthCheckHistory
+	becameHealthy      chan (bool)
+
+	resolver  *TargetResolver
+	endpoints *endpointSet
+
+	upgraded atomic.Int64
+
+	priorityRules PriorityRules
+
+	healthCheckAccess HealthCheckAccess
 }
 
 func NewTarget(targetURL string, options TargetOptions) (*Target, error) {
@@ -94,32 +288,179 @@ func NewTarget(targetURL string, options TargetOptions) (*Target, error) {
 		return nil, err
 	}
 
+	err = options.HealthCheckConfig.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateBalanceStrategy(options.Balance, options.BalanceLoadPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateTargetProtocol(options.TargetProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateTargetIPFamily(options.IPFamily)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateSmokeTestPaths(options.SmokeTestPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateVersionPath(options.VersionPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateResponseValidationAction(options.ResponseValidationAction)
+	if err != nil {
+		return nil, err
+	}
+
+	err = options.requestHeaderLimits().Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	options.canonicalizeLogHeaders()
 
+	logTagRules, err := ParseLogTagRules(options.LogTagRules)
+	if err != nil {
+		return nil, err
+	}
+
+	timeoutPage, err := loadTimeoutPage(options.TimeoutPagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	responseRewrites, err := ParseResponseRewriteRules(options.ResponseRewrites)
+	if err != nil {
+		return nil, err
+	}
+
+	responseValidations, err := ParseResponseValidationRules(options.ResponseValidations)
+	if err != nil {
+		return nil, err
+	}
+
+	priorityRules, err := ParsePriorityRules(options.PriorityRules)
+	if err != nil {
+		return nil, err
+	}
+
+	healthCheckAccess, err := ParseHealthCheckAccess(options.BlockHealthCheckPath, options.HealthCheckAllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
 	target := &Target{
-		targetURL: uri,
-		options:   options,
+		targetURL:   uri,
+		options:     options,
+		logTagRules: logTagRules,
+		timeoutPage: timeoutPage,
+
+		priorityRules: priorityRules,
+
+		healthCheckAccess: healthCheckAccess,
 
 		state:    TargetStateAdding,
 		inflight: inflightMap{},
+
+		healthCheckHistory: NewHealthCheckHistory(HealthCheckHistorySize),
 	}
 
+	target.endpoints = newEndpointSet(uri, options.HealthCheckConfig, options.Balance, options.BalanceLoadPath)
 	target.proxyHandler = target.createProxyHandler()
+	if target.isDirectory() {
+		target.proxyHandler, err = NewDirectoryHandler(uri.Path, DirectoryConfig{
+			Listing:       options.DirectoryListing,
+			Credentials:   options.DirectoryCredentials,
+			SPA:           options.SPA,
+			Precompressed: options.Precompressed,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if options.StaticDirectory != "" {
+		static, err := NewDirectoryHandler(options.StaticDirectory, DirectoryConfig{SPA: options.SPA, Precompressed: options.Precompressed})
+		if err != nil {
+			return nil, err
+		}
+		target.proxyHandler = WithStaticMiddleware(static, options.StaticTargetPaths, target.proxyHandler)
+	}
+	discovery, interval, err := target.createDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	if discovery != nil {
+		target.startResolving(discovery, interval)
+	}
 
+	if len(responseValidations) > 0 {
+		target.proxyHandler = WithResponseValidationMiddleware(responseValidations, options.ResponseValidationAction, target.proxyHandler)
+	}
+	if len(responseRewrites) > 0 {
+		target.proxyHandler = WithResponseRewriteMiddleware(responseRewrites, options.ResponseRewriteContentTypes, options.MaxMemoryBufferSize, target.proxyHandler)
+	}
 	if options.BufferResponses {
-		target.proxyHandler = WithResponseBufferMiddleware(options.MaxMemoryBufferSize, options.MaxResponseBodySize, target.proxyHandler)
+		target.proxyHandler = WithConditionalResponseBufferMiddleware(options.MaxMemoryBufferSize, options.MaxResponseBodySize, options.conditionalResponseConfig(), target.proxyHandler)
 	}
 	if options.BufferRequests {
-		target.proxyHandler = WithRequestBufferMiddleware(options.MaxMemoryBufferSize, options.MaxRequestBodySize, target.proxyHandler)
+		target.proxyHandler = WithRequestBufferMiddleware(options.MaxMemoryBufferSize, options.MaxRequestBodySize, options.UnbufferedRequestPaths, target.proxyHandler)
+	}
+	if options.MaxRequestHeaderSize > 0 || options.MaxRequestHeaderCount > 0 {
+		target.proxyHandler = WithRequestHeaderLimitMiddleware(options.requestHeaderLimits(), target.proxyHandler)
+	}
+	if options.DeduplicateRequests {
+		target.proxyHandler = WithIdempotencyMiddleware(options.MaxMemoryBufferSize, target.proxyHandler)
+	}
+	if options.FairShare > 0 {
+		target.proxyHandler = WithFairnessMiddleware(FairnessConfig{
+			Share:       options.FairShare,
+			MinInflight: options.FairShareMinInflight,
+			Key:         options.FairShareKey,
+		}, target.proxyHandler)
+	}
+	if options.ShedLowAt > 0 || options.ShedNormalAt > 0 {
+		target.proxyHandler = WithLoadSheddingMiddleware(LoadSheddingConfig{
+			Rules:      priorityRules,
+			LowAt:      options.ShedLowAt,
+			NormalAt:   options.ShedNormalAt,
+			RetryAfter: options.ShedRetryAfter,
+		}, target.otherInflightCount, target.proxyHandler)
 	}
 
 	return target, nil
 }
 
 func (t *Target) Target() string {
+	if t.isDirectory() {
+		return t.targetURL.String()
+	}
 	return t.targetURL.Host
 }
 
+func (t *Target) HealthCheckHistory() []HealthCheckResult {
+	return t.healthCheckHistory.Results()
+}
+
+func (t *Target) Labels() map[string]string {
+	return t.options.Labels
+}
+
+// RequestPriority returns the priority of a request, according to the
+// target's priority rules.
+func (t *Target) RequestPriority(req *http.Request) string {
+	return t.priorityRules.Priority(req)
+}
+
 func (t *Target) StartRequest(req *http.Request) (*http.Request, error) {
 	t.inflightLock.Lock()
 	defer t.inflightLock.Unlock()
@@ -141,18 +482,34 @@ func (t *Target) SendRequest(w http.ResponseWriter, req *http.Request) {
 	LoggingRequestContext(req).Target = t.Target()
 	LoggingRequestContext(req).RequestHeaders = t.options.LogRequestHeaders
 	LoggingRequestContext(req).ResponseHeaders = t.options.LogResponseHeaders
+	LoggingRequestContext(req).QueryParams = t.options.LogQueryParams
+	LoggingRequestContext(req).Tags = t.logTagRules.Tags(req)
+	LoggingRequestContext(req).Labels = t.options.Labels
 
 	inflightRequest := t.getInflightRequest(req)
 	defer t.endInflightRequest(req)
 
-	tw := newTargetResponseWriter(w, inflightRequest)
+	t.startRequestDeadline(req, inflightRequest)
+	defer inflightRequest.stopDeadline()
+
+	tw := newTargetResponseWriter(w, t, inflightRequest)
 	t.proxyHandler.ServeHTTP(tw, req)
+
+	if tw.upgraded {
+		t.upgraded.Add(-1)
+	}
 }
 
 func (t *Target) IsHealthCheckRequest(r *http.Request) bool {
 	return r.Method == http.MethodGet && r.URL.Path == t.options.HealthCheckConfig.Path
 }
 
+// HealthCheckPathAllowed reports whether a request that arrived through the
+// proxy may be answered, if it's for the health check path.
+func (t *Target) HealthCheckPathAllowed(r *http.Request) bool {
+	return !isHealthCheckPath(r.URL.Path, t.options.HealthCheckConfig.Path) || t.healthCheckAccess.Allows(r)
+}
+
 func (t *Target) Drain(timeout time.Duration) {
 	originalState := t.updateState(TargetStateDraining)
 	if originalState == TargetStateDraining {
@@ -160,6 +517,9 @@ func (t *Target) Drain(timeout time.Duration) {
 	}
 	defer t.updateState(originalState)
 
+	drainMetrics.Begin(t)
+	defer drainMetrics.End(t)
+
 	deadline := time.After(timeout)
 	toCancel := t.pendingRequestsToCancel()
 
@@ -179,16 +539,53 @@ WAIT_FOR_REQUESTS_TO_COMPLETE:
 		}
 	}
 
+	remaining := 0
+	for req := range toCancel {
+		if req.Context().Err() == nil {
+			remaining++
+		}
+	}
+	if remaining > 0 {
+		slog.Error("Drain timed out, closing remaining requests", "target", t.Target(), "timeout", timeout, "remaining", remaining)
+	}
+
 	// Cancel any remaining requests.
 	for _, inflight := range toCancel {
 		inflight.cancel(ErrorDraining)
 	}
 }
 
+// DrainWrites waits for any in-flight requests that may modify data to
+// complete, cancelling those that remain after the timeout. Unlike Drain, it
+// leaves reads alone, and the target continues to accept new requests.
+func (t *Target) DrainWrites(timeout time.Duration) {
+	deadline := time.After(timeout)
+
+	toCancel := inflightMap{}
+	for req, inflight := range t.pendingRequestsToCancel() {
+		if !isSafeMethod(req.Method) {
+			toCancel[req] = inflight
+		}
+	}
+
+WAIT_FOR_REQUESTS_TO_COMPLETE:
+	for req := range toCancel {
+		select {
+		case <-req.Context().Done():
+		case <-deadline:
+			break WAIT_FOR_REQUESTS_TO_COMPLETE
+		}
+	}
+
+	for _, inflight := range toCancel {
+		inflight.cancel(ErrorDraining)
+	}
+}
+
 func (t *Target) BeginHealthChecks() {
 	t.becameHealthy = make(chan bool)
 	t.healthcheck = NewHealthCheck(t,
-		t.targetURL.JoinPath(t.options.HealthCheckConfig.Path),
+		NewHealthCheckProbe(t.options.HealthCheckConfig, t.targetURL),
 		t.options.HealthCheckConfig.Interval,
 		t.options.HealthCheckConfig.Timeout,
 	)
@@ -213,33 +610,138 @@ func (t *Target) WaitUntilHealthy(timeout time.Duration) bool {
 	}
 }
 
+// PrewarmConnections opens the configured number of keep-alive connections
+// to the target, so that the first requests it receives after a deployment
+// don't need to wait for them to be established. Connections are opened by
+// making concurrent requests to the health check path.
+func (t *Target) PrewarmConnections() int {
+	count := min(t.options.PrewarmConnections, MaxIdleConnsPerHost)
+	if count <= 0 || t.isDirectory() {
+		return 0
+	}
+
+	client := &http.Client{Transport: t.transport, Timeout: t.options.HealthCheckConfig.Timeout}
+
+	var wg sync.WaitGroup
+	var opened atomic.Int32
+	for range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			endpoint := *t.targetURL
+			endpoint.Host = t.endpointHost()
+
+			req, err := http.NewRequest(http.MethodGet, endpoint.JoinPath(t.options.HealthCheckConfig.Path).String(), nil)
+			if err != nil {
+				return
+			}
+			req.Host = t.targetURL.Host
+			req.Header.Set("User-Agent", healthCheckUserAgent)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+
+			_, _ = io.Copy(io.Discard, resp.Body)
+			opened.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	slog.Info("Prewarmed connections", "target", t.Target(), "requested", count, "opened", opened.Load())
+	return int(opened.Load())
+}
+
+// SetEndpoints sets the addresses that the target's requests are balanced
+// across. Each address is used once it passes a health check. With no
+// healthy addresses, requests are sent to the target host as given.
+func (t *Target) SetEndpoints(endpoints []string) {
+	removed := t.endpoints.Update(endpoints)
+	if removed {
+		// Connections to addresses that are no longer part of the target
+		// should not be reused.
+		t.transport.CloseIdleConnections()
+	}
+}
+
+func (t *Target) Endpoints() []string {
+	return t.endpoints.Healthy()
+}
+
+// CheckHealth reports whether the target can serve requests: either it has
+// a healthy endpoint, or the target host as given passes a health check.
+func (t *Target) CheckHealth(ctx context.Context) bool {
+	if len(t.endpoints.Healthy()) > 0 {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.options.HealthCheckConfig.Timeout)
+	defer cancel()
+
+	_, err := NewHealthCheckProbe(t.options.HealthCheckConfig, t.targetURL).Probe(ctx)
+	return err == nil
+}
+
+// StopResolving is the last step in retiring a target, so it also removes the
+// target's metrics.
+func (t *Target) StopResolving() {
+	if t.resolver != nil {
+		t.resolver.Close()
+		t.resolver = nil
+	}
+	t.endpoints.Close()
+	deleteTargetMetrics(t.Target())
+}
+
+// TargetResolverConsumer
+
+func (t *Target) EndpointsResolved(endpoints []string) {
+	t.SetEndpoints(endpoints)
+}
+
 // HealthCheckConsumer
 
-func (t *Target) HealthCheckCompleted(success bool) {
+func (t *Target) HealthCheckCompleted(result HealthCheckResult) {
+	t.healthCheckHistory.Add(result)
+
 	t.inflightLock.Lock()
 	defer t.inflightLock.Unlock()
 
-	if success && t.state == TargetStateAdding {
+	if result.Success && t.state == TargetStateAdding {
 		t.state = TargetStateHealthy
 		close(t.becameHealthy)
 	}
 
-	slog.Info("Target health updated", "target", t.Target(), "success", success, "state", t.state.String())
+	slog.Info("Target health updated", "target", t.Target(), "success", result.Success, "state", t.state.String())
 }
 
 // Private
 
 func (t *Target) createProxyHandler() http.Handler {
-	bufferPool := NewBufferPool(ProxyBufferSize)
+	bufferPool := NewBufferPool(proxyBufferSize.Load())
+
+	dial := newTargetDialer(t.options.IPFamily, t.options.HappyEyeballsDelay, t.options.ResponseTimeout)
+	t.transport = newTargetTransport(t.options.TargetProtocol, dial, t.options.ResponseTimeout)
+
+	var transport http.RoundTripper = newConnectionMetricsTransport(t.Target(), t.transport)
+	if t.options.Balance == BalanceLeastLatency || t.options.Balance == BalanceLeastLoaded {
+		transport = newBalancingTransport(t.endpoints, transport)
+	}
+	if t.options.Retries > 0 {
+		transport = newRetryTransport(t, transport, t.options.Retries)
+	}
+	if t.options.HedgeDelay > 0 {
+		transport = newHedgeTransport(t, transport, t.options.HedgeDelay, t.options.HedgePaths)
+	}
 
 	return &httputil.ReverseProxy{
 		BufferPool:   bufferPool,
 		Rewrite:      t.rewrite,
 		ErrorHandler: t.handleProxyError,
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost:   MaxIdleConnsPerHost,
-			ResponseHeaderTimeout: t.options.ResponseTimeout,
-		},
+		Transport:    transport,
 	}
 }
 
@@ -247,8 +749,21 @@ func (t *Target) rewrite(req *httputil.ProxyRequest) {
 	t.forwardHeaders(req)
 
 	req.SetURL(t.targetURL)
+	req.Out.URL.Host = t.endpointHostFor(req.In)
 	req.Out.Host = req.In.Host
 
+	if t.options.RequestTimingHeaders {
+		setRequestTimingHeaders(req.In, req.Out)
+	}
+
+	if t.options.DeadlineHeader != "" {
+		t.setDeadlineHeader(req.In, req.Out)
+	}
+
+	if buffer, ok := req.In.Body.(*Buffer); ok && buffer.Replayable() {
+		req.Out.GetBody = buffer.NewReader
+	}
+
 	// Ensure query params are preserved exactly, including those we could not
 	// parse.
 	//
@@ -275,6 +790,63 @@ func (t *Target) rewrite(req *httputil.ProxyRequest) {
 	req.Out.URL.RawQuery = req.In.URL.RawQuery
 }
 
+func (t *Target) createDiscovery() (Discovery, time.Duration, error) {
+	if t.options.Discovery != "" {
+		discovery, err := ParseDiscovery(t.options.Discovery)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		interval := t.options.DiscoveryInterval
+		if interval <= 0 {
+			interval = DefaultDiscoveryInterval
+		}
+		return discovery, interval, nil
+	}
+
+	if t.isDirectory() || t.options.DNSRefreshInterval <= 0 || net.ParseIP(t.targetURL.Hostname()) != nil {
+		return nil, 0, nil
+	}
+
+	port := t.targetURL.Port()
+	if port == "" {
+		port = "80"
+	}
+
+	discovery := &hostDiscovery{host: t.targetURL.Hostname(), port: port, lookup: net.DefaultResolver.LookupHost}
+	return discovery, t.options.DNSRefreshInterval, nil
+}
+
+func (t *Target) isDirectory() bool {
+	return t.targetURL.Scheme == "file"
+}
+
+func (t *Target) startResolving(discovery Discovery, interval time.Duration) {
+	t.resolver = NewTargetResolver(t, discovery, interval)
+}
+
+func (t *Target) endpointHost() string {
+	endpoint, ok := t.endpoints.Pick()
+	if !ok {
+		return t.targetURL.Host
+	}
+	return endpoint
+}
+
+// endpointHostFor chooses the address for a request, which is decided by the
+// request itself when balancing by hash.
+func (t *Target) endpointHostFor(req *http.Request) string {
+	if t.options.Balance != BalanceHash {
+		return t.endpointHost()
+	}
+
+	endpoint, ok := t.endpoints.PickHashed(balanceHashKey(req, t.options.BalanceHashHeader))
+	if !ok {
+		return t.targetURL.Host
+	}
+	return endpoint
+}
+
 func (t *Target) forwardHeaders(req *httputil.ProxyRequest) {
 	if t.options.ForwardHeaders {
 		req.Out.Header["X-Forwarded-For"] = req.In.Header["X-Forwarded-For"]
@@ -299,13 +871,19 @@ func (t *Target) handleProxyError(w http.ResponseWriter, r *http.Request, err er
 	}
 
 	if t.isGatewayTimeout(err) {
-		SetErrorResponse(w, r, http.StatusGatewayTimeout, nil)
+		t.handleGatewayTimeout(w, r, err)
+		return
+	}
+
+	if t.isRequestDeadlineExceeded(r) {
+		t.handleGatewayTimeout(w, r, err)
 		return
 	}
 
 	if t.isClientCancellation(err) {
 		// The client has disconnected so will not see the response, but we
 		// still want to set it for the sake of the logs.
+		LoggingRequestContext(r).ClientDisconnected = true
 		w.WriteHeader(StatusClientClosedRequest)
 		return
 	}
@@ -317,9 +895,43 @@ func (t *Target) handleProxyError(w http.ResponseWriter, r *http.Request, err er
 	}
 
 	slog.Error("Error while proxying", "target", t.Target(), "path", r.URL.Path, "error", err)
+	t.reportProxyError(r, err)
 	SetErrorResponse(w, r, http.StatusBadGateway, nil)
 }
 
+func (t *Target) reportProxyError(r *http.Request, err error) {
+	service := LoggingRequestContext(r).Service
+	reportError("upstream:"+service+":"+t.Target(), ErrorReport{
+		Kind:    "upstream",
+		Message: fmt.Sprintf("Error while proxying to %s: %s", t.Target(), err),
+		Tags:    map[string]string{"service": service, "target": t.Target()},
+		Extra:   map[string]string{"method": r.Method, "path": r.URL.Path, "request_id": r.Header.Get(requestIDHeader)},
+	})
+}
+
+func (t *Target) handleGatewayTimeout(w http.ResponseWriter, r *http.Request, err error) {
+	kind := "response"
+	if t.isDialError(err) {
+		kind = "dial"
+	}
+
+	LoggingRequestContext(r).UpstreamTimeout = kind
+	upstreamTimeoutsCounter.WithLabelValues(LoggingRequestContext(r).Service, kind).Inc()
+	slog.Info("Timed out waiting for target", "target", t.Target(), "path", r.URL.Path, "kind", kind)
+
+	if t.timeoutPage != nil {
+		t.timeoutPage.write(w)
+		return
+	}
+
+	SetErrorResponse(w, r, http.StatusGatewayTimeout, nil)
+}
+
+func (t *Target) isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
 func (t *Target) isRequestEntityTooLarge(err error) bool {
 	var maxBytesError *http.MaxBytesError
 	return errors.As(err, &maxBytesError)
@@ -351,6 +963,33 @@ func (t *Target) updateState(state TargetState) TargetState {
 	return originalState
 }
 
+// annotateDrainingResponse asks clients to reconnect, when the response is for
+// a request that is still running on a target that's being drained, so that
+// keep-alive connections move to the new target sooner.
+func (t *Target) annotateDrainingResponse(header http.Header) {
+	if !t.options.DrainResponseHeaders {
+		return
+	}
+
+	t.inflightLock.Lock()
+	draining := t.state == TargetStateDraining
+	t.inflightLock.Unlock()
+
+	if draining {
+		header.Set("Connection", "close")
+		header.Set("X-Deploy-In-Progress", "true")
+	}
+}
+
+// otherInflightCount is the number of requests in flight, not counting the
+// one that's asking.
+func (t *Target) otherInflightCount() int {
+	t.inflightLock.Lock()
+	defer t.inflightLock.Unlock()
+
+	return max(len(t.inflight)-1, 0)
+}
+
 func (t *Target) getInflightRequest(req *http.Request) *inflightRequest {
 	t.inflightLock.Lock()
 	defer t.inflightLock.Unlock()
@@ -385,6 +1024,13 @@ func (t *Target) pendingRequestsToCancel() inflightMap {
 }
 
 func parseTargetURL(targetURL string) (*url.URL, error) {
+	if root, ok := strings.CutPrefix(targetURL, "file://"); ok {
+		if !filepath.IsAbs(root) {
+			return nil, fmt.Errorf("%s: %w", targetURL, ErrorInvalidDirectoryTarget)
+		}
+		return &url.URL{Scheme: "file", Path: filepath.Clean(root)}, nil
+	}
+
 	if !hostRegex.MatchString(targetURL) {
 		return nil, fmt.Errorf("%s :%w", targetURL, ErrorInvalidHostPattern)
 	}
@@ -393,13 +1039,73 @@ func parseTargetURL(targetURL string) (*url.URL, error) {
 	return uri, nil
 }
 
+// timeoutPage is a static response body to use when a request times out
+// waiting for the target. Its content type is based on the file extension, so
+// it may be HTML, JSON, or anything else the client expects.
+type timeoutPage struct {
+	contentType string
+	body        []byte
+}
+
+func loadTimeoutPage(path string) (*timeoutPage, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("Unable to load timeout page", "path", path, "error", err)
+		return nil, ErrorUnableToLoadTimeoutPage
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+
+	return &timeoutPage{contentType: contentType, body: body}, nil
+}
+
+func (p *timeoutPage) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", p.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(p.body)))
+	w.WriteHeader(http.StatusGatewayTimeout)
+	w.Write(p.body)
+}
+
 type targetResponseWriter struct {
 	http.ResponseWriter
+	target          *Target
 	inflightRequest *inflightRequest
+	headerWritten   bool
+	upgraded        bool
+}
+
+func newTargetResponseWriter(w http.ResponseWriter, target *Target, inflightRequest *inflightRequest) *targetResponseWriter {
+	return &targetResponseWriter{w, target, inflightRequest, false, false}
 }
 
-func newTargetResponseWriter(w http.ResponseWriter, inflightRequest *inflightRequest) *targetResponseWriter {
-	return &targetResponseWriter{w, inflightRequest}
+func (r *targetResponseWriter) WriteHeader(statusCode int) {
+	if statusCode >= http.StatusOK && !r.headerWritten {
+		r.headerWritten = true
+		r.inflightRequest.stopDeadline()
+		r.target.annotateDrainingResponse(r.Header())
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *targetResponseWriter) Write(data []byte) (int, error) {
+	if !r.headerWritten {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *targetResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !r.headerWritten {
+		r.WriteHeader(http.StatusOK)
+	}
+	return readFrom(r.ResponseWriter, src)
 }
 
 func (r *targetResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
@@ -409,7 +1115,14 @@ func (r *targetResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	}
 
 	r.inflightRequest.hijacked = true
-	return hijacker.Hijack()
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.upgraded = true
+	r.target.upgraded.Add(1)
+	return r.target.wrapUpgraded(conn), rw, nil
 }
 
 func (r *targetResponseWriter) Flush() {
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BalanceRoundRobin   = "round-robin"
+	BalanceLeastLatency = "least-latency"
+	BalanceLeastLoaded  = "least-loaded"
+	BalanceHash         = "hash"
+
+	// balanceLatencyWeight is how much each new response time counts towards
+	// an address's moving average, against those that came before it.
+	balanceLatencyWeight   = 0.2
+	balanceLoadReportLimit = 64
+)
+
+var (
+	ErrorUnknownBalanceStrategy  = errors.New("unknown load balancing strategy")
+	ErrorBalanceLoadPathRequired = errors.New("a load path is required to balance by reported load")
+	ErrorInvalidLoadReport       = errors.New("invalid load report")
+)
+
+func ValidateBalanceStrategy(strategy, loadPath string) error {
+	switch strategy {
+	case "", BalanceRoundRobin, BalanceLeastLatency, BalanceHash:
+		return nil
+	case BalanceLeastLoaded:
+		if loadPath == "" {
+			return ErrorBalanceLoadPathRequired
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrorUnknownBalanceStrategy, strategy)
+	}
+}
+
+// balanceHashKey identifies the client that a request belongs to, for hash
+// balancing: by the value of the given header, or by its IP address when
+// there's no header, or the request doesn't have it.
+func balanceHashKey(r *http.Request, header string) string {
+	if header != "" {
+		if key := r.Header.Get(header); key != "" {
+			return "key:" + key
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return "ip:" + ip
+}
+
+// rendezvousScore ranks an address for a hash key. Each key goes to the
+// address that scores highest for it, so when an address is added or
+// removed, only the keys that it wins, or had won, move.
+func rendezvousScore(key, address string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	hash.Write([]byte{0})
+	hash.Write([]byte(address))
+	return hash.Sum64()
+}
+
+// endpointStats is what we know about how busy one of a target's addresses
+// is: the requests we have in flight to it, a moving average of its response
+// times, and the load it last reported.
+type endpointStats struct {
+	inflight atomic.Int64
+
+	lock         sync.Mutex
+	latency      time.Duration
+	load         float64
+	loadReported bool
+}
+
+func (s *endpointStats) observeLatency(latency time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = time.Duration(balanceLatencyWeight*float64(latency) + (1-balanceLatencyWeight)*float64(s.latency))
+	}
+}
+
+func (s *endpointStats) setLoad(load float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.load = load
+	s.loadReported = true
+}
+
+// score is lower for addresses that should be preferred. Addresses that have
+// no latency or load recorded yet score zero, so that they are tried.
+func (s *endpointStats) score(strategy string) float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch strategy {
+	case BalanceLeastLatency:
+		return s.latency.Seconds() * float64(s.inflight.Load()+1)
+	case BalanceLeastLoaded:
+		return s.load
+	}
+	return 0
+}
+
+// balancingTransport records the response times of, and requests in flight
+// to, each of a target's addresses, for the strategies that balance by them.
+// A request is in flight until its response body is closed.
+type balancingTransport struct {
+	endpoints *endpointSet
+	next      http.RoundTripper
+}
+
+func newBalancingTransport(endpoints *endpointSet, next http.RoundTripper) *balancingTransport {
+	return &balancingTransport{
+		endpoints: endpoints,
+		next:      next,
+	}
+}
+
+func (t *balancingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	stats := t.endpoints.Stats(req.URL.Host)
+	if stats == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	stats.inflight.Add(1)
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	stats.observeLatency(time.Since(started))
+
+	if err != nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		// Upgraded connections keep their body for as long as they're open,
+		// and must not have it wrapped, so they stop counting once upgraded.
+		stats.inflight.Add(-1)
+		return resp, err
+	}
+
+	resp.Body = &balancingResponseBody{ReadCloser: resp.Body, stats: stats}
+	return resp, nil
+}
+
+type balancingResponseBody struct {
+	io.ReadCloser
+	stats *endpointStats
+	once  sync.Once
+}
+
+func (b *balancingResponseBody) Close() error {
+	b.once.Do(func() { b.stats.inflight.Add(-1) })
+	return b.ReadCloser.Close()
+}
+
+// loadPoller asks each healthy address for its load on every health check
+// interval. The load is reported as a number in the response body, such as
+// the CPU load average, where lower numbers are less busy.
+type loadPoller struct {
+	endpoints *endpointSet
+	path      string
+	interval  time.Duration
+	timeout   time.Duration
+	client    *http.Client
+	cancel    context.CancelFunc
+}
+
+func newLoadPoller(endpoints *endpointSet, path string, interval, timeout time.Duration) *loadPoller {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &loadPoller{
+		endpoints: endpoints,
+		path:      path,
+		interval:  interval,
+		timeout:   timeout,
+		client:    &http.Client{},
+		cancel:    cancel,
+	}
+
+	go p.run(ctx)
+	return p
+}
+
+func (p *loadPoller) Close() {
+	p.cancel()
+}
+
+// Private
+
+func (p *loadPoller) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.pollAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *loadPoller) pollAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, address := range p.endpoints.Healthy() {
+		stats := p.endpoints.Stats(address)
+		if stats == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			load, err := p.poll(ctx, address)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Debug("Unable to get load report", "target", p.endpoints.targetURL.Host, "endpoint", address, "error", err)
+				}
+				return
+			}
+			stats.setLoad(load)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *loadPoller) poll(ctx context.Context, address string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	endpoint := *p.endpoints.targetURL
+	endpoint.Host = address
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.JoinPath(p.path).String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Host = p.endpoints.targetURL.Host
+	req.Header.Set("User-Agent", healthCheckUserAgent)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, balanceLoadReportLimit))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: status %d", ErrorInvalidLoadReport, resp.StatusCode)
+	}
+
+	load, err := strconv.ParseFloat(strings.TrimSpace(string(body)), 64)
+	if err != nil || load < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrorInvalidLoadReport, body)
+	}
+	return load, nil
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointSet_RoundRobin(t *testing.T) {
+	set := testEndpointSet(t, BalanceRoundRobin, "a:3000", "b:3000")
+
+	picks := map[string]int{}
+	for range 4 {
+		address, ok := set.Pick()
+		require.True(t, ok)
+		picks[address]++
+	}
+	assert.Equal(t, map[string]int{"a:3000": 2, "b:3000": 2}, picks)
+}
+
+func TestEndpointSet_LeastLatency(t *testing.T) {
+	set := testEndpointSet(t, BalanceLeastLatency, "a:3000", "b:3000")
+	set.Stats("a:3000").observeLatency(100 * time.Millisecond)
+	set.Stats("b:3000").observeLatency(10 * time.Millisecond)
+
+	for range 4 {
+		address, _ := set.Pick()
+		assert.Equal(t, "b:3000", address)
+	}
+
+	// A fast address with enough requests in flight becomes the slower choice.
+	set.Stats("b:3000").inflight.Add(20)
+	address, _ := set.Pick()
+	assert.Equal(t, "a:3000", address)
+}
+
+func TestEndpointSet_LeastLatencyTriesNewAddresses(t *testing.T) {
+	set := testEndpointSet(t, BalanceLeastLatency, "a:3000", "b:3000")
+	set.Stats("a:3000").observeLatency(10 * time.Millisecond)
+
+	address, _ := set.Pick()
+	assert.Equal(t, "b:3000", address)
+}
+
+func TestEndpointSet_LeastLoaded(t *testing.T) {
+	loads := map[string]string{"/a": "0.9\n", "/b": "0.2\n"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(loads[r.URL.Path]))
+	}))
+	t.Cleanup(server.Close)
+
+	serverURL, _ := url.Parse(server.URL)
+	set := testEndpointSet(t, BalanceLeastLoaded, serverURL.Host)
+	set.targetURL = serverURL
+
+	poller := &loadPoller{endpoints: set, path: "/a", timeout: time.Second, client: &http.Client{}}
+	load, err := poller.poll(context.Background(), serverURL.Host)
+	require.NoError(t, err)
+	assert.Equal(t, 0.9, load)
+
+	poller.path = "/missing"
+	_, err = poller.poll(context.Background(), serverURL.Host)
+	assert.ErrorIs(t, err, ErrorInvalidLoadReport)
+
+	poller.path = "/b"
+	poller.pollAll(context.Background())
+	assert.Equal(t, 0.2, set.Stats(serverURL.Host).score(BalanceLeastLoaded))
+}
+
+func TestEndpointSet_LeastLoadedPicksLowestLoad(t *testing.T) {
+	set := testEndpointSet(t, BalanceLeastLoaded, "a:3000", "b:3000", "c:3000")
+	set.Stats("a:3000").setLoad(0.5)
+	set.Stats("b:3000").setLoad(0.1)
+	set.Stats("c:3000").setLoad(0.7)
+
+	address, _ := set.Pick()
+	assert.Equal(t, "b:3000", address)
+}
+
+func TestEndpointSet_HashIsStable(t *testing.T) {
+	set := testEndpointSet(t, BalanceHash, "a:3000", "b:3000", "c:3000")
+
+	picks := map[string]string{}
+	used := map[string]bool{}
+	for i := range 100 {
+		key := fmt.Sprintf("key:tenant-%d", i)
+		address, ok := set.PickHashed(key)
+		require.True(t, ok)
+
+		again, _ := set.PickHashed(key)
+		assert.Equal(t, address, again)
+
+		picks[key] = address
+		used[address] = true
+	}
+	assert.Len(t, used, 3)
+
+	// Only the keys on a removed address move
+	set.Update([]string{"a:3000", "b:3000"})
+	for key, previous := range picks {
+		address, _ := set.PickHashed(key)
+		if previous != "c:3000" {
+			assert.Equal(t, previous, address)
+		} else {
+			assert.NotEqual(t, "c:3000", address)
+		}
+	}
+}
+
+func TestBalanceHashKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	assert.Equal(t, "ip:10.0.0.1", balanceHashKey(req, ""))
+	assert.Equal(t, "ip:10.0.0.1", balanceHashKey(req, "X-Tenant-ID"))
+
+	req.Header.Set("X-Tenant-ID", "acme")
+	assert.Equal(t, "key:acme", balanceHashKey(req, "X-Tenant-ID"))
+}
+
+func TestBalancingTransport_TracksInflightRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	serverURL, _ := url.Parse(server.URL)
+	set := testEndpointSet(t, BalanceLeastLatency, serverURL.Host)
+	transport := newBalancingTransport(set, http.DefaultTransport)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+
+	stats := set.Stats(serverURL.Host)
+	assert.Equal(t, int64(1), stats.inflight.Load())
+	assert.Greater(t, stats.latency, time.Duration(0))
+
+	resp.Body.Close()
+	resp.Body.Close()
+	assert.Equal(t, int64(0), stats.inflight.Load())
+}
+
+func TestValidateBalanceStrategy(t *testing.T) {
+	assert.NoError(t, ValidateBalanceStrategy("", ""))
+	assert.NoError(t, ValidateBalanceStrategy(BalanceLeastLatency, ""))
+	assert.NoError(t, ValidateBalanceStrategy(BalanceLeastLoaded, "/load"))
+	assert.NoError(t, ValidateBalanceStrategy(BalanceHash, ""))
+	assert.ErrorIs(t, ValidateBalanceStrategy(BalanceLeastLoaded, ""), ErrorBalanceLoadPathRequired)
+	assert.ErrorIs(t, ValidateBalanceStrategy("random", ""), ErrorUnknownBalanceStrategy)
+}
+
+// Helpers
+
+func testEndpointSet(t *testing.T, balance string, addresses ...string) *endpointSet {
+	t.Helper()
+
+	set := newEndpointSet(&url.URL{Scheme: "http", Host: "app.internal:3000"}, HealthCheckConfig{}, balance, "")
+	for _, address := range addresses {
+		set.endpoints[address] = &endpoint{set: set, address: address}
+		set.healthy = append(set.healthy, address)
+	}
+	return set
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connectionMetricsTransport records how requests get their connections to
+// the target, to help with tuning its connection pool: whether an idle
+// connection was reused or a new one dialed, how long dials take, and how
+// often a connection is closed rather than kept because the pool of idle
+// connections (MaxIdleConnsPerHost) is already full.
+//
+// The series are looked up as they're used, rather than kept, because a
+// retired target's series are deleted, and a later target may have the same
+// name.
+type connectionMetricsTransport struct {
+	next   http.RoundTripper
+	target string
+}
+
+func newConnectionMetricsTransport(target string, next http.RoundTripper) *connectionMetricsTransport {
+	return &connectionMetricsTransport{
+		next:   next,
+		target: target,
+	}
+}
+
+func (t *connectionMetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Addresses may be dialed in parallel, such as over IPv4 and IPv6.
+	var dialsLock sync.Mutex
+	dialsStarted := map[string]time.Time{}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstreamConnectionsCounter.WithLabelValues(t.target, strconv.FormatBool(info.Reused)).Inc()
+		},
+		ConnectStart: func(network, addr string) {
+			dialsLock.Lock()
+			defer dialsLock.Unlock()
+			dialsStarted[network+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			dialsLock.Lock()
+			defer dialsLock.Unlock()
+			if started, ok := dialsStarted[network+addr]; ok && err == nil {
+				upstreamDialDurationSeconds.WithLabelValues(t.target).Observe(time.Since(started).Seconds())
+			}
+		},
+		PutIdleConn: func(err error) {
+			// The transport doesn't export this error.
+			if err != nil && strings.Contains(err.Error(), "too many idle connections") {
+				upstreamIdlePoolFullCounter.WithLabelValues(t.target).Inc()
+			}
+		},
+	}
+
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionMetricsTransport_RecordsReuse(t *testing.T) {
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
+	name := target.Target()
+
+	for range 3 {
+		w := httptest.NewRecorder()
+		testServeRequestWithTarget(t, target, w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(upstreamConnectionsCounter.WithLabelValues(name, "false")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(upstreamConnectionsCounter.WithLabelValues(name, "true")))
+	assert.Equal(t, uint64(1), testHistogram(t, upstreamDialDurationSeconds.WithLabelValues(name)).GetSampleCount())
+	assert.Equal(t, 0.0, testutil.ToFloat64(upstreamIdlePoolFullCounter.WithLabelValues(name)))
+}
+
+func TestConnectionMetricsTransport_RemovesSeriesOfRetiredTarget(t *testing.T) {
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
+	name := target.Target()
+
+	testServeRequestWithTarget(t, target, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, 1, upstreamConnectionsCounter.DeletePartialMatch(prometheus.Labels{"target": name, "reused": "false"}))
+
+	testServeRequestWithTarget(t, target, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	target.StopResolving()
+
+	assert.Zero(t, upstreamConnectionsCounter.DeletePartialMatch(prometheus.Labels{"target": name}))
+	assert.Zero(t, upstreamDialDurationSeconds.DeletePartialMatch(prometheus.Labels{"target": name}))
+	assert.Zero(t, upstreamIdlePoolFullCounter.DeletePartialMatch(prometheus.Labels{"target": name}))
+}
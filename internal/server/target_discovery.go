@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultDiscoveryInterval = 10 * time.Second
+
+var ErrorInvalidDiscovery = errors.New("invalid discovery source")
+
+type (
+	lookupHostFunc func(ctx context.Context, host string) ([]string, error)
+	lookupSRVFunc  func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+)
+
+// Discovery finds the addresses that a target's requests can be sent to.
+// Discovered addresses are health checked by the proxy before they are used,
+// so a source only needs to report where instances are, not whether they are
+// working.
+type Discovery interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// ParseDiscovery creates a Discovery from a source specification, which
+// takes one of the following forms:
+//
+//	srv:_http._tcp.app.internal
+//	consul://consul.internal:8500/service-name[?tag=...&dc=...]
+//	etcd://etcd.internal:2379/key/prefix/
+func ParseDiscovery(spec string) (Discovery, error) {
+	if name, ok := strings.CutPrefix(spec, "srv:"); ok {
+		if name == "" {
+			return nil, fmt.Errorf("%w: %s", ErrorInvalidDiscovery, spec)
+		}
+		return &srvDiscovery{name: name, lookup: net.DefaultResolver.LookupSRV}, nil
+	}
+
+	uri, err := url.Parse(spec)
+	if err != nil || uri.Host == "" || strings.Trim(uri.Path, "/") == "" {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidDiscovery, spec)
+	}
+
+	switch uri.Scheme {
+	case "consul":
+		return &consulDiscovery{
+			address: uri.Host,
+			service: strings.Trim(uri.Path, "/"),
+			query:   uri.Query(),
+		}, nil
+	case "etcd":
+		return &etcdDiscovery{
+			address: uri.Host,
+			prefix:  uri.Path,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrorInvalidDiscovery, spec)
+}
+
+// hostDiscovery resolves a hostname, using the same port for each address.
+
+type hostDiscovery struct {
+	host   string
+	port   string
+	lookup lookupHostFunc
+}
+
+func (d *hostDiscovery) Discover(ctx context.Context) ([]string, error) {
+	addrs, err := d.lookup(ctx, d.host)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := []string{}
+	for _, addr := range addrs {
+		endpoints = append(endpoints, net.JoinHostPort(addr, d.port))
+	}
+	return endpoints, nil
+}
+
+// srvDiscovery uses DNS SRV records, which include the port of each instance.
+
+type srvDiscovery struct {
+	name   string
+	lookup lookupSRVFunc
+}
+
+func (d *srvDiscovery) Discover(ctx context.Context) ([]string, error) {
+	_, records, err := d.lookup(ctx, "", "", d.name)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := []string{}
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	return endpoints, nil
+}
+
+// consulDiscovery lists the instances of a service in the Consul catalog.
+
+type consulDiscovery struct {
+	address string
+	service string
+	query   url.Values
+}
+
+type consulCatalogEntry struct {
+	Address        string `json:"Address"`
+	ServiceAddress string `json:"ServiceAddress"`
+	ServicePort    int    `json:"ServicePort"`
+}
+
+func (d *consulDiscovery) Discover(ctx context.Context) ([]string, error) {
+	uri := url.URL{
+		Scheme:   "http",
+		Host:     d.address,
+		Path:     "/v1/catalog/service/" + d.service,
+		RawQuery: d.query.Encode(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []consulCatalogEntry
+	err = discoveryRequest(req, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := []string{}
+	for _, entry := range entries {
+		host := entry.ServiceAddress
+		if host == "" {
+			host = entry.Address
+		}
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(entry.ServicePort)))
+	}
+	return endpoints, nil
+}
+
+// etcdDiscovery reads `host:port` values from all the keys under a prefix,
+// using the etcd v3 JSON gateway.
+
+type etcdDiscovery struct {
+	address string
+	prefix  string
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end"`
+}
+
+type etcdRangeResponse struct {
+	KVs []struct {
+		Value []byte `json:"value"`
+	} `json:"kvs"`
+}
+
+func (d *etcdDiscovery) Discover(ctx context.Context) ([]string, error) {
+	body, err := json.Marshal(etcdRangeRequest{
+		Key:      []byte(d.prefix),
+		RangeEnd: etcdPrefixEnd([]byte(d.prefix)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uri := url.URL{Scheme: "http", Host: d.address, Path: "/v3/kv/range"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var response etcdRangeResponse
+	err = discoveryRequest(req, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := []string{}
+	for _, kv := range response.KVs {
+		value := strings.TrimSpace(string(kv.Value))
+		if _, _, err := net.SplitHostPort(value); err == nil {
+			endpoints = append(endpoints, value)
+		}
+	}
+	return endpoints, nil
+}
+
+// Helpers
+
+func discoveryRequest(req *http.Request, result any) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL.Host)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func etcdPrefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0} // All keys
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDiscovery(t *testing.T) {
+	discovery, err := ParseDiscovery("srv:_http._tcp.app.internal")
+	require.NoError(t, err)
+	assert.Equal(t, "_http._tcp.app.internal", discovery.(*srvDiscovery).name)
+
+	discovery, err = ParseDiscovery("consul://consul.internal:8500/web?tag=primary")
+	require.NoError(t, err)
+	assert.Equal(t, "consul.internal:8500", discovery.(*consulDiscovery).address)
+	assert.Equal(t, "web", discovery.(*consulDiscovery).service)
+	assert.Equal(t, "primary", discovery.(*consulDiscovery).query.Get("tag"))
+
+	discovery, err = ParseDiscovery("etcd://etcd.internal:2379/services/web/")
+	require.NoError(t, err)
+	assert.Equal(t, "/services/web/", discovery.(*etcdDiscovery).prefix)
+
+	for _, spec := range []string{"", "srv:", "consul://consul.internal:8500", "etcd:///prefix", "zookeeper://zk/web"} {
+		_, err = ParseDiscovery(spec)
+		assert.ErrorIs(t, err, ErrorInvalidDiscovery, spec)
+	}
+}
+
+func TestSRVDiscovery(t *testing.T) {
+	discovery := &srvDiscovery{
+		name: "_http._tcp.app.internal",
+		lookup: func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+			assert.Equal(t, "_http._tcp.app.internal", name)
+			return "", []*net.SRV{
+				{Target: "web-1.internal.", Port: 3000},
+				{Target: "web-2.internal.", Port: 3001},
+			}, nil
+		},
+	}
+
+	endpoints, err := discovery.Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-1.internal:3000", "web-2.internal:3001"}, endpoints)
+}
+
+func TestConsulDiscovery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/catalog/service/web", r.URL.Path)
+		assert.Equal(t, "primary", r.URL.Query().Get("tag"))
+
+		w.Write([]byte(`[
+			{"Address": "10.0.0.1", "ServiceAddress": "", "ServicePort": 3000},
+			{"Address": "10.0.0.2", "ServiceAddress": "172.17.0.2", "ServicePort": 3001}
+		]`))
+	}))
+	t.Cleanup(server.Close)
+
+	discovery, err := ParseDiscovery("consul://" + server.Listener.Addr().String() + "/web?tag=primary")
+	require.NoError(t, err)
+
+	endpoints, err := discovery.Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:3000", "172.17.0.2:3001"}, endpoints)
+}
+
+func TestEtcdDiscovery(t *testing.T) {
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/kv/range", r.URL.Path)
+
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, encode("/services/web/"), body["key"])
+		assert.Equal(t, encode("/services/web0"), body["range_end"])
+
+		w.Write([]byte(`{"kvs": [
+			{"key": "` + encode("/services/web/1") + `", "value": "` + encode("10.0.0.1:3000") + `"},
+			{"key": "` + encode("/services/web/2") + `", "value": "` + encode("not an address") + `"},
+			{"key": "` + encode("/services/web/3") + `", "value": "` + encode("10.0.0.3:3000\n") + `"}
+		]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	discovery, err := ParseDiscovery("etcd://" + server.Listener.Addr().String() + "/services/web/")
+	require.NoError(t, err)
+
+	endpoints, err := discovery.Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:3000", "10.0.0.3:3000"}, endpoints)
+}
+
+func TestEtcdPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("/b"), etcdPrefixEnd([]byte("/a")))
+	assert.Equal(t, []byte("b"), etcdPrefixEnd([]byte("a\xff")))
+	assert.Equal(t, []byte{0}, etcdPrefixEnd([]byte("\xff")))
+}
+
+func TestTarget_InvalidDiscoveryIsRejected(t *testing.T) {
+	options := defaultTargetOptions
+	options.Discovery = "zookeeper://zk/web"
+
+	_, err := NewTarget("localhost:3000", options)
+	assert.ErrorIs(t, err, ErrorInvalidDiscovery)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"log/slog"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// endpointSet tracks the addresses that a target's requests can be sent to,
+// when the target is backed by more than one. New addresses must pass a
+// health check before they are used, in the same way as a newly deployed
+// target. They go on being checked for as long as they're in the set, and are
+// taken out of use while their checks fail.
+type endpointSet struct {
+	targetURL         *url.URL
+	healthCheckConfig HealthCheckConfig
+	balance           string
+	loadPath          string
+
+	endpoints  map[string]*endpoint
+	healthy    []string
+	next       atomic.Uint32
+	loadPoller *loadPoller
+	lock       sync.RWMutex
+}
+
+type endpoint struct {
+	set         *endpointSet
+	address     string
+	healthcheck *HealthCheck
+	stats       endpointStats
+}
+
+func newEndpointSet(targetURL *url.URL, healthCheckConfig HealthCheckConfig, balance, loadPath string) *endpointSet {
+	return &endpointSet{
+		targetURL:         targetURL,
+		healthCheckConfig: healthCheckConfig,
+		balance:           balance,
+		loadPath:          loadPath,
+		endpoints:         map[string]*endpoint{},
+	}
+}
+
+// Update replaces the set of addresses. It returns true if any addresses
+// were removed.
+func (s *endpointSet) Update(addresses []string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	removed := false
+	for address, ep := range s.endpoints {
+		if !slices.Contains(addresses, address) {
+			ep.stopHealthCheck()
+			delete(s.endpoints, address)
+			removed = true
+		}
+	}
+
+	for _, address := range addresses {
+		if _, ok := s.endpoints[address]; !ok {
+			s.endpoints[address] = s.newEndpoint(address)
+		}
+	}
+
+	s.healthy = slices.DeleteFunc(s.healthy, func(address string) bool {
+		return s.endpoints[address] == nil
+	})
+
+	if len(s.endpoints) == 0 && s.loadPoller != nil {
+		s.loadPoller.Close()
+		s.loadPoller = nil
+	}
+
+	return removed
+}
+
+// Pick chooses the healthy address to use next. Addresses are taken in turn,
+// unless balancing by latency or load, when the one with the lowest score is
+// used; the turn then decides between any that are tied.
+func (s *endpointSet) Pick() (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.healthy) == 0 {
+		return "", false
+	}
+
+	next := int(s.next.Add(1))
+	if s.balance == "" || s.balance == BalanceRoundRobin || s.balance == BalanceHash {
+		return s.healthy[next%len(s.healthy)], true
+	}
+
+	var best *endpoint
+	var bestScore float64
+	for i := range s.healthy {
+		ep := s.endpoints[s.healthy[(next+i)%len(s.healthy)]]
+		score := ep.stats.score(s.balance)
+		if best == nil || score < bestScore || (score == bestScore && ep.stats.inflight.Load() < best.stats.inflight.Load()) {
+			best, bestScore = ep, score
+		}
+	}
+	return best.address, true
+}
+
+// PickHashed chooses the healthy address for a hash key, so that requests
+// with the same key keep going to the same address for as long as it's
+// healthy. See rendezvousScore.
+func (s *endpointSet) PickHashed(key string) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var best string
+	var bestScore uint64
+	for _, address := range s.healthy {
+		score := rendezvousScore(key, address)
+		if best == "" || score > bestScore {
+			best, bestScore = address, score
+		}
+	}
+	return best, best != ""
+}
+
+// Stats returns the balancing statistics for an address, or nil if it's not
+// one of the set's addresses.
+func (s *endpointSet) Stats(address string) *endpointStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ep, ok := s.endpoints[address]
+	if !ok {
+		return nil
+	}
+	return &ep.stats
+}
+
+func (s *endpointSet) Healthy() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return slices.Clone(s.healthy)
+}
+
+func (s *endpointSet) Close() {
+	s.Update(nil)
+}
+
+// Private
+
+func (s *endpointSet) newEndpoint(address string) *endpoint {
+	ep := &endpoint{set: s, address: address}
+
+	checkURL := *s.targetURL
+	checkURL.Host = address
+	ep.healthcheck = NewHealthCheck(ep,
+		NewHealthCheckProbe(s.healthCheckConfig, &checkURL),
+		s.healthCheckConfig.Interval,
+		s.healthCheckConfig.Timeout,
+	)
+
+	return ep
+}
+
+func (s *endpointSet) endpointHealthChanged(ep *endpoint, healthy bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.endpoints[ep.address] != ep || slices.Contains(s.healthy, ep.address) == healthy {
+		return
+	}
+
+	if !healthy {
+		s.healthy = slices.DeleteFunc(s.healthy, func(address string) bool {
+			return address == ep.address
+		})
+
+		slog.Info("Target endpoint became unhealthy", "target", s.targetURL.Host, "endpoint", ep.address)
+		return
+	}
+
+	s.healthy = append(s.healthy, ep.address)
+	slices.Sort(s.healthy)
+
+	if s.balance == BalanceLeastLoaded && s.loadPoller == nil {
+		s.loadPoller = newLoadPoller(s, s.loadPath, s.healthCheckConfig.Interval, s.healthCheckConfig.Timeout)
+	}
+
+	slog.Info("Target endpoint became healthy", "target", s.targetURL.Host, "endpoint", ep.address)
+}
+
+func (ep *endpoint) stopHealthCheck() {
+	if ep.healthcheck != nil {
+		ep.healthcheck.Close()
+		ep.healthcheck = nil
+	}
+}
+
+// HealthCheckConsumer
+
+func (ep *endpoint) HealthCheckCompleted(result HealthCheckResult) {
+	ep.set.endpointHealthChanged(ep, result.Success)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// hedgeTransport reduces tail latency for reads. When a request hasn't been
+// answered within the hedge delay, a second copy of it is sent to another of
+// the target's healthy endpoints, and whichever responds first is used. The
+// other is cancelled.
+//
+// Only GET and HEAD requests without a body are hedged, since they're safe to
+// run twice, and only when the target has more than one healthy endpoint.
+// Upgrade requests, such as WebSockets, are never hedged.
+type hedgeTransport struct {
+	target *Target
+	next   http.RoundTripper
+	delay  time.Duration
+	paths  []*regexp.Regexp
+}
+
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	cancel context.CancelFunc
+	hedge  bool
+}
+
+func newHedgeTransport(target *Target, next http.RoundTripper, delay time.Duration, paths []string) *hedgeTransport {
+	patterns := []*regexp.Regexp{}
+	for _, path := range paths {
+		patterns = append(patterns, globToRegexp(path))
+	}
+
+	return &hedgeTransport{
+		target: target,
+		next:   next,
+		delay:  delay,
+		paths:  patterns,
+	}
+}
+
+func (t *hedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.shouldHedge(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	results := make(chan hedgeResult, 2)
+	cancelOriginal := t.send(req, false, results)
+
+	timer := time.NewTimer(t.delay)
+	defer timer.Stop()
+
+	select {
+	case result := <-results:
+		return t.finish(result, results, nil)
+
+	case <-timer.C:
+		hedge, ok := t.prepareHedge(req)
+		if !ok {
+			return t.finish(<-results, results, nil)
+		}
+
+		slog.Debug("Hedging slow request", "target", t.target.Target(), "path", req.URL.Path, "endpoint", hedge.URL.Host)
+		cancelHedge := t.send(hedge, true, results)
+
+		first := <-results
+		if first.err != nil && req.Context().Err() == nil {
+			// The first to finish failed, so give the other a chance.
+			first.cancel()
+			return t.finish(<-results, results, nil)
+		}
+
+		if first.hedge {
+			slog.Debug("Hedged request responded first", "target", t.target.Target(), "path", req.URL.Path)
+			return t.finish(first, results, cancelOriginal)
+		}
+		return t.finish(first, results, cancelHedge)
+	}
+}
+
+// Private
+
+func (t *hedgeTransport) shouldHedge(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	if req.Header.Get("Upgrade") != "" {
+		return false
+	}
+	if len(t.paths) > 0 && !slices.ContainsFunc(t.paths, func(pattern *regexp.Regexp) bool { return pattern.MatchString(req.URL.Path) }) {
+		return false
+	}
+
+	return len(t.target.endpoints.Healthy()) > 1
+}
+
+func (t *hedgeTransport) send(req *http.Request, hedge bool, results chan<- hedgeResult) context.CancelFunc {
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(ctx)
+
+	go func() {
+		resp, err := t.next.RoundTrip(req)
+		results <- hedgeResult{resp: resp, err: err, cancel: cancel, hedge: hedge}
+	}()
+
+	return cancel
+}
+
+// prepareHedge copies the request, to be sent to a different endpoint than
+// the original.
+func (t *hedgeTransport) prepareHedge(req *http.Request) (*http.Request, bool) {
+	for _, endpoint := range t.target.endpoints.Healthy() {
+		if endpoint != req.URL.Host {
+			hedge := req.Clone(req.Context())
+			hedge.URL.Host = endpoint
+			return hedge, true
+		}
+	}
+	return nil, false
+}
+
+// finish returns the winning result. If another request is still running,
+// cancelLoser stops it, and its response is discarded once it completes. The
+// winner's request is cancelled when its response body is closed.
+func (t *hedgeTransport) finish(winner hedgeResult, results <-chan hedgeResult, cancelLoser context.CancelFunc) (*http.Response, error) {
+	if cancelLoser != nil {
+		cancelLoser()
+		go func() {
+			loser := <-results
+			if loser.resp != nil {
+				loser.resp.Body.Close()
+			}
+		}()
+	}
+
+	if winner.err != nil {
+		winner.cancel()
+		return nil, winner.err
+	}
+
+	winner.resp.Body = &hedgeResponseBody{ReadCloser: winner.resp.Body, cancel: winner.cancel}
+	return winner.resp, nil
+}
+
+type hedgeResponseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *hedgeResponseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEndpointRoundTripper responds from each endpoint after its delay, or
+// with its error, recording which endpoints were sent requests and which of
+// those were cancelled.
+type testEndpointRoundTripper struct {
+	delays map[string]time.Duration
+	errors map[string]error
+
+	lock        sync.Mutex
+	sent        []string
+	cancelled   []string
+	lastContext context.Context
+}
+
+func (rt *testEndpointRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	rt.record(&rt.sent, host)
+
+	rt.lock.Lock()
+	rt.lastContext = req.Context()
+	rt.lock.Unlock()
+
+	select {
+	case <-time.After(rt.delays[host]):
+	case <-req.Context().Done():
+		rt.record(&rt.cancelled, host)
+		return nil, req.Context().Err()
+	}
+
+	if err := rt.errors[host]; err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(host))}, nil
+}
+
+func (rt *testEndpointRoundTripper) record(list *[]string, host string) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	*list = append(*list, host)
+}
+
+func (rt *testEndpointRoundTripper) cancelledEndpoints() []string {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]string{}, rt.cancelled...)
+}
+
+func TestHedgeTransport(t *testing.T) {
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
+	target.endpoints.healthy = []string{"a:80", "b:80"}
+
+	roundTrip := func(transport http.RoundTripper, method, path string) string {
+		req := httptest.NewRequest(method, "http://a:80"+path, nil)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	t.Run("uses the hedge when the original is slow", func(t *testing.T) {
+		next := &testEndpointRoundTripper{delays: map[string]time.Duration{"a:80": time.Second}}
+		transport := newHedgeTransport(target, next, 10*time.Millisecond, nil)
+
+		assert.Equal(t, "b:80", roundTrip(transport, http.MethodGet, "/"))
+		require.Eventually(t, func() bool { return len(next.cancelledEndpoints()) == 1 }, time.Second, time.Millisecond)
+		assert.Equal(t, []string{"a:80"}, next.cancelledEndpoints())
+	})
+
+	t.Run("does not hedge fast requests", func(t *testing.T) {
+		next := &testEndpointRoundTripper{}
+		transport := newHedgeTransport(target, next, 100*time.Millisecond, nil)
+
+		assert.Equal(t, "a:80", roundTrip(transport, http.MethodGet, "/"))
+		assert.Equal(t, []string{"a:80"}, next.sent)
+	})
+
+	t.Run("does not hedge unsafe methods or other paths", func(t *testing.T) {
+		next := &testEndpointRoundTripper{delays: map[string]time.Duration{"a:80": 50 * time.Millisecond}}
+		transport := newHedgeTransport(target, next, time.Millisecond, []string{"/search*"})
+
+		assert.Equal(t, "a:80", roundTrip(transport, http.MethodPost, "/search"))
+		assert.Equal(t, "a:80", roundTrip(transport, http.MethodGet, "/other"))
+		assert.Equal(t, []string{"a:80", "a:80"}, next.sent)
+	})
+
+	t.Run("uses the other response when the first fails", func(t *testing.T) {
+		next := &testEndpointRoundTripper{
+			delays: map[string]time.Duration{"a:80": 20 * time.Millisecond, "b:80": 40 * time.Millisecond},
+			errors: map[string]error{"a:80": errors.New("connection reset")},
+		}
+		transport := newHedgeTransport(target, next, 5*time.Millisecond, nil)
+
+		assert.Equal(t, "b:80", roundTrip(transport, http.MethodGet, "/"))
+	})
+
+	t.Run("does not hedge with a single endpoint", func(t *testing.T) {
+		single := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
+		next := &testEndpointRoundTripper{delays: map[string]time.Duration{"a:80": 20 * time.Millisecond}}
+		transport := newHedgeTransport(single, next, time.Millisecond, nil)
+
+		assert.Equal(t, "a:80", roundTrip(transport, http.MethodGet, "/"))
+		assert.Equal(t, []string{"a:80"}, next.sent)
+	})
+
+	t.Run("winner is cancelled once its body is closed", func(t *testing.T) {
+		next := &testEndpointRoundTripper{}
+		transport := newHedgeTransport(target, next, time.Second, nil)
+
+		resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a:80/", nil))
+		require.NoError(t, err)
+		assert.NoError(t, next.lastContext.Err())
+
+		require.NoError(t, resp.Body.Close())
+		assert.ErrorIs(t, next.lastContext.Err(), context.Canceled)
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+)
+
+var (
+	ErrorInvalidPoolName    = errors.New("pool names may only contain letters, numbers, dashes and underscores")
+	ErrorInvalidPoolAddress = errors.New("pool addresses must be given as host:port")
+	ErrorPoolNotFound       = errors.New("pool not found")
+)
+
+var poolNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// TargetPools are named sets of addresses, such as web or canary, that are
+// managed independently of the services that use them. A service that names
+// a pool balances its requests across the pool's addresses, and follows
+// them as they're added and removed, so one set of backends can be shared by
+// several services.
+type TargetPools map[string][]string
+
+func ValidatePoolName(name string) error {
+	if !poolNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %s", ErrorInvalidPoolName, name)
+	}
+	return nil
+}
+
+// Add adds addresses to a pool, creating it if needed.
+func (p TargetPools) Add(name string, addresses []string) error {
+	err := ValidatePoolName(name)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("%w: %s", ErrorInvalidPoolAddress, address)
+		}
+	}
+
+	pool := slices.Clone(p[name])
+	for _, address := range addresses {
+		if !slices.Contains(pool, address) {
+			pool = append(pool, address)
+		}
+	}
+	slices.Sort(pool)
+	p[name] = pool
+	return nil
+}
+
+// Remove removes addresses from a pool, or the whole pool if no addresses
+// are given. Pools with no addresses left are removed.
+func (p TargetPools) Remove(name string, addresses []string) error {
+	pool, ok := p[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrorPoolNotFound, name)
+	}
+
+	if len(addresses) > 0 {
+		pool = slices.DeleteFunc(slices.Clone(pool), func(address string) bool {
+			return slices.Contains(addresses, address)
+		})
+	} else {
+		pool = nil
+	}
+
+	if len(pool) == 0 {
+		delete(p, name)
+	} else {
+		p[name] = pool
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetPools_AddAndRemove(t *testing.T) {
+	pools := TargetPools{}
+
+	require.NoError(t, pools.Add("web", []string{"web-2:3000", "web-1:3000"}))
+	require.NoError(t, pools.Add("web", []string{"web-1:3000", "web-3:3000"}))
+	assert.Equal(t, []string{"web-1:3000", "web-2:3000", "web-3:3000"}, pools["web"])
+
+	require.NoError(t, pools.Remove("web", []string{"web-2:3000"}))
+	assert.Equal(t, []string{"web-1:3000", "web-3:3000"}, pools["web"])
+
+	require.NoError(t, pools.Remove("web", []string{"web-1:3000", "web-3:3000"}))
+	assert.NotContains(t, pools, "web")
+
+	require.NoError(t, pools.Add("jobs-ui", []string{"jobs:3000"}))
+	require.NoError(t, pools.Remove("jobs-ui", nil))
+	assert.Empty(t, pools)
+
+	assert.ErrorIs(t, pools.Remove("missing", nil), ErrorPoolNotFound)
+	assert.ErrorIs(t, pools.Add("web pool", []string{"web-1:3000"}), ErrorInvalidPoolName)
+	assert.ErrorIs(t, pools.Add("web", []string{"web-1"}), ErrorInvalidPoolAddress)
+}
+
+func TestRouter_ServicesSharePools(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	_, first := testBackend(t, "first", http.StatusOK)
+	_, second := testBackend(t, "second", http.StatusOK)
+	_, shared := testBackend(t, "shared", http.StatusOK)
+
+	router := NewRouter(statePath)
+	require.NoError(t, router.SetServiceTarget("service1", []string{"one.example.com"}, first, ServiceOptions{Pool: "web"}, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetServiceTarget("service2", []string{"two.example.com"}, second, ServiceOptions{Pool: "web"}, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	// An empty pool leaves services using their own targets
+	_, body := sendGETRequest(router, "http://one.example.com/")
+	assert.Equal(t, "first", body)
+
+	require.NoError(t, router.AddPoolTargets("web", []string{shared}))
+	for _, host := range []string{"one.example.com", "two.example.com"} {
+		require.Eventually(t, func() bool {
+			_, body := sendGETRequest(router, "http://"+host+"/")
+			return body == "shared"
+		}, time.Second, time.Millisecond*10)
+	}
+	assert.Equal(t, TargetPools{"web": {shared}}, router.Pools())
+
+	// Pools are saved with the state
+	restored := NewRouter(statePath)
+	require.NoError(t, restored.RestoreLastSavedState())
+	assert.Equal(t, TargetPools{"web": {shared}}, restored.Pools())
+
+	require.NoError(t, router.RemovePoolTargets("web", nil))
+	_, body = sendGETRequest(router, "http://two.example.com/")
+	assert.Equal(t, "second", body)
+	assert.Empty(t, router.Pools())
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	TargetProtocolHTTP1 = "http1"
+	TargetProtocolH2C   = "h2c"
+)
+
+var ErrorUnknownTargetProtocol = errors.New("unknown target protocol")
+
+func ValidateTargetProtocol(protocol string) error {
+	switch protocol {
+	case "", TargetProtocolHTTP1, TargetProtocolH2C:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrorUnknownTargetProtocol, protocol)
+	}
+}
+
+// targetTransport is the transport that requests are sent to the target
+// with, before any balancing, retries or hedging.
+type targetTransport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// newTargetTransport creates a transport for the protocol that the target
+// speaks. Unless it's h2c (HTTP/2 without TLS), requests are always sent
+// with HTTP/1.1, whatever version the client used, since some application
+// servers misbehave when given HTTP/2.
+func newTargetTransport(protocol string, dial dialFunc, responseTimeout time.Duration) targetTransport {
+	if protocol == TargetProtocolH2C {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}
+	}
+
+	return &http.Transport{
+		DialContext:           dial,
+		MaxIdleConnsPerHost:   MaxIdleConnsPerHost,
+		ResponseHeaderTimeout: responseTimeout,
+		ExpectContinueTimeout: ExpectContinueTimeout,
+		TLSNextProto:          map[string]func(string, *tls.Conn) http.RoundTripper{},
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+type TargetResolverConsumer interface {
+	EndpointsResolved(endpoints []string)
+}
+
+// TargetResolver periodically discovers a target's addresses, reporting them
+// to its consumer whenever they change.
+type TargetResolver struct {
+	consumer  TargetResolverConsumer
+	discovery Discovery
+	interval  time.Duration
+	current   []string
+
+	shutdown chan (bool)
+}
+
+func NewTargetResolver(consumer TargetResolverConsumer, discovery Discovery, interval time.Duration) *TargetResolver {
+	r := &TargetResolver{
+		consumer:  consumer,
+		discovery: discovery,
+		interval:  interval,
+
+		shutdown: make(chan bool),
+	}
+
+	r.resolve()
+	go r.run()
+	return r
+}
+
+func (r *TargetResolver) Close() {
+	close(r.shutdown)
+}
+
+// Private
+
+func (r *TargetResolver) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.shutdown:
+			return
+		case <-ticker.C:
+			r.resolve()
+		}
+	}
+}
+
+func (r *TargetResolver) resolve() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+	defer cancel()
+
+	endpoints, err := r.discovery.Discover(ctx)
+	if err != nil || len(endpoints) == 0 {
+		// Keep using the addresses we already have, rather than losing the
+		// target because of a transient lookup problem.
+		slog.Warn("Unable to resolve target", "error", err)
+		return
+	}
+
+	slices.Sort(endpoints)
+	endpoints = slices.Compact(endpoints)
+
+	if !slices.Equal(endpoints, r.current) {
+		r.current = endpoints
+		slog.Info("Target resolved", "endpoints", endpoints)
+		r.consumer.EndpointsResolved(endpoints)
+	}
+}
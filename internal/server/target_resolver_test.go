@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testResolverConsumer struct {
+	lock    sync.Mutex
+	results [][]string
+}
+
+func (c *testResolverConsumer) EndpointsResolved(endpoints []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.results = append(c.results, endpoints)
+}
+
+func (c *testResolverConsumer) Results() [][]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.results
+}
+
+func TestTargetResolver_ReportsChanges(t *testing.T) {
+	var lock sync.Mutex
+	answers := [][]string{{"10.0.0.2", "10.0.0.1"}, {"10.0.0.1", "10.0.0.2"}, nil, {"10.0.0.3"}}
+
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if len(answers) == 0 {
+			return []string{"10.0.0.3"}, nil
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		if answer == nil {
+			return nil, errors.New("lookup failed")
+		}
+		return answer, nil
+	}
+
+	consumer := &testResolverConsumer{}
+	resolver := NewTargetResolver(consumer, &hostDiscovery{host: "app.internal", port: "3000", lookup: lookup}, time.Millisecond)
+	defer resolver.Close()
+
+	require.Eventually(t, func() bool { return len(consumer.Results()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, [][]string{{"10.0.0.1:3000", "10.0.0.2:3000"}, {"10.0.0.3:3000"}}, consumer.Results())
+}
+
+func TestTarget_BalancesAcrossResolvedAddresses(t *testing.T) {
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+	}))
+	t.Cleanup(first.Close)
+
+	port := first.Listener.Addr().(*net.TCPAddr).Port
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", strconv.Itoa(port)))
+	if err != nil {
+		t.Skip("unable to listen on a second loopback address")
+	}
+	second := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("second"))
+	}))
+	second.Listener = l
+	second.Start()
+	t.Cleanup(second.Close)
+
+	target, err := NewTarget("app.internal:"+strconv.Itoa(port), defaultTargetOptions)
+	require.NoError(t, err)
+
+	target.startResolving(&hostDiscovery{host: "app.internal", port: strconv.Itoa(port), lookup: func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.1", "127.0.0.2"}, nil
+	}}, time.Hour)
+	defer target.StopResolving()
+
+	require.Eventually(t, func() bool { return len(target.Endpoints()) == 2 }, time.Second, time.Millisecond)
+
+	bodies := map[string]int{}
+	for range 4 {
+		w := httptest.NewRecorder()
+		testServeRequestWithTarget(t, target, w, httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil))
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+		bodies[w.Body.String()]++
+	}
+
+	assert.Equal(t, map[string]int{"first": 2, "second": 2}, bodies)
+}
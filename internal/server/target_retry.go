@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"syscall"
+)
+
+// retryTransport retries requests that failed before the target could have
+// started handling them, such as when the connection was refused, as well as
+// idempotent requests that lost their connection to the target. Requests
+// are only retried when their body can be sent again, which means it must
+// either be empty, or have been buffered in memory.
+type retryTransport struct {
+	target  *Target
+	next    http.RoundTripper
+	retries int
+}
+
+func newRetryTransport(target *Target, next http.RoundTripper, retries int) *retryTransport {
+	return &retryTransport{
+		target:  target,
+		next:    next,
+		retries: retries,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil || attempt > t.retries || !t.shouldRetry(req, err) {
+			return resp, err
+		}
+
+		retry, retryErr := t.prepareRetry(req)
+		if retryErr != nil {
+			return nil, err
+		}
+
+		slog.Info("Retrying request", "target", t.target.Target(), "path", req.URL.Path, "attempt", attempt, "error", err)
+		req = retry
+	}
+}
+
+// Private
+
+func (t *retryTransport) shouldRetry(req *http.Request, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if t.target.isDialError(err) {
+		return true
+	}
+
+	// Other connection failures may have happened after the target received
+	// the request, so we only retry them when it's safe to repeat it.
+	connectionLost := errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	return connectionLost && t.isIdempotent(req)
+}
+
+func (t *retryTransport) isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (t *retryTransport) prepareRetry(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	retry.URL.Host = t.target.endpointHost()
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	return retry, nil
+}
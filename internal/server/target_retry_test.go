@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testRoundTripper struct {
+	errors []error
+	bodies []string
+	next   http.RoundTripper
+}
+
+func (rt *testRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(rt.errors) > 0 {
+		err := rt.errors[0]
+		rt.errors = rt.errors[1:]
+		rt.recordBody(req)
+		return nil, err
+	}
+
+	if rt.next != nil {
+		return rt.next.RoundTrip(req)
+	}
+
+	rt.recordBody(req)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func (rt *testRoundTripper) recordBody(req *http.Request) {
+	body := ""
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+	}
+	rt.bodies = append(rt.bodies, body)
+}
+
+func TestRetryTransport(t *testing.T) {
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+	bufferedRequest := func(method, body string, maxMemBytes int64) *http.Request {
+		req := httptest.NewRequest(method, "http://example.com/", nil)
+		brc, err := NewBufferedReadCloser(io.NopCloser(strings.NewReader(body)), 0, maxMemBytes)
+		require.NoError(t, err)
+		t.Cleanup(func() { brc.Close() })
+
+		req.Body = brc
+		if brc.(*Buffer).Replayable() {
+			req.GetBody = brc.(*Buffer).NewReader
+		}
+		return req
+	}
+
+	t.Run("replays buffered bodies", func(t *testing.T) {
+		next := &testRoundTripper{errors: []error{dialErr, dialErr}}
+		resp, err := newRetryTransport(target, next, 2).RoundTrip(bufferedRequest(http.MethodPost, "hello", 1024))
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"hello", "hello", "hello"}, next.bodies)
+	})
+
+	t.Run("gives up after the limit", func(t *testing.T) {
+		next := &testRoundTripper{errors: []error{dialErr, dialErr}}
+		_, err := newRetryTransport(target, next, 1).RoundTrip(bufferedRequest(http.MethodPost, "hello", 1024))
+
+		require.Equal(t, dialErr, err)
+		assert.Len(t, next.bodies, 2)
+	})
+
+	t.Run("does not retry bodies that were too large to replay", func(t *testing.T) {
+		next := &testRoundTripper{errors: []error{dialErr}}
+		_, err := newRetryTransport(target, next, 2).RoundTrip(bufferedRequest(http.MethodPost, "hello", 2))
+
+		require.Equal(t, dialErr, err)
+		assert.Len(t, next.bodies, 1)
+	})
+
+	t.Run("does not retry unbuffered bodies", func(t *testing.T) {
+		next := &testRoundTripper{errors: []error{dialErr}}
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/", io.NopCloser(strings.NewReader("hello")))
+		_, err := newRetryTransport(target, next, 2).RoundTrip(req)
+
+		require.Equal(t, dialErr, err)
+		assert.Len(t, next.bodies, 1)
+	})
+
+	t.Run("only retries lost connections for idempotent requests", func(t *testing.T) {
+		next := &testRoundTripper{errors: []error{io.EOF}}
+		_, err := newRetryTransport(target, next, 2).RoundTrip(bufferedRequest(http.MethodPost, "hello", 1024))
+		require.Equal(t, io.EOF, err)
+
+		next = &testRoundTripper{errors: []error{io.EOF}}
+		_, err = newRetryTransport(target, next, 2).RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+		require.NoError(t, err)
+		assert.Len(t, next.bodies, 2)
+	})
+}
+
+func TestTarget_RetriesRequestsWithBufferedBody(t *testing.T) {
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	proxy := target.createProxyHandler().(*httputil.ReverseProxy)
+	proxy.Transport = newRetryTransport(target, &testRoundTripper{
+		errors: []error{&net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+		next:   target.transport,
+	}, 1)
+	target.proxyHandler = WithRequestBufferMiddleware(1024, 0, nil, proxy)
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader("hello"))
+	w := httptest.NewRecorder()
+	testServeRequestWithTarget(t, target, w, req)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "hello", w.Body.String())
+}
@@ -3,9 +3,13 @@ package server
 import (
 	"bufio"
 	"context"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,6 +19,8 @@ import (
 	"github.com/coder/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func TestTarget_Serve(t *testing.T) {
@@ -161,6 +167,53 @@ func TestTarget_CancelledRequestsHaveStatus499(t *testing.T) {
 	require.Empty(t, string(w.Body.String()))
 }
 
+func TestTarget_TimeoutsRespondWithGatewayTimeout(t *testing.T) {
+	timeoutPagePath := filepath.Join(t.TempDir(), "timeout.json")
+	require.NoError(t, os.WriteFile(timeoutPagePath, []byte(`{"error":"timeout"}`), 0644))
+
+	sendRequest := func(timeoutPagePath string) *httptest.ResponseRecorder {
+		targetOptions := defaultTargetOptions
+		targetOptions.ResponseTimeout = time.Millisecond * 10
+		targetOptions.TimeoutPagePath = timeoutPagePath
+
+		target := testTargetWithOptions(t, targetOptions, func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond * 50)
+		})
+
+		w := httptest.NewRecorder()
+		testServeRequestWithTarget(t, target, w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	t.Run("default response", func(t *testing.T) {
+		w := sendRequest("")
+		require.Equal(t, http.StatusGatewayTimeout, w.Result().StatusCode)
+	})
+
+	t.Run("custom timeout page", func(t *testing.T) {
+		w := sendRequest(timeoutPagePath)
+		require.Equal(t, http.StatusGatewayTimeout, w.Result().StatusCode)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, `{"error":"timeout"}`, w.Body.String())
+	})
+
+	t.Run("missing timeout page", func(t *testing.T) {
+		targetOptions := defaultTargetOptions
+		targetOptions.TimeoutPagePath = "not valid"
+
+		_, err := NewTarget("localhost:3000", targetOptions)
+		require.ErrorIs(t, err, ErrorUnableToLoadTimeoutPage)
+	})
+}
+
+func TestTarget_DistinguishDialAndResponseTimeouts(t *testing.T) {
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	dialErr := &net.OpError{Op: "dial", Err: context.DeadlineExceeded}
+	assert.True(t, target.isDialError(fmt.Errorf("wrapped: %w", dialErr)))
+	assert.False(t, target.isDialError(&net.OpError{Op: "read", Err: context.DeadlineExceeded}))
+}
+
 func TestTarget_PreserveTargetHeader(t *testing.T) {
 	var requestTarget string
 
@@ -302,6 +355,38 @@ func TestTarget_AddedTargetBecomesHealthy(t *testing.T) {
 	require.Equal(t, "ok", string(w.Body.String()))
 }
 
+func TestTarget_PrewarmConnections(t *testing.T) {
+	var connections, requests atomic.Int32
+	allArrived := make(chan struct{})
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold the prewarming requests until they have all arrived, so they
+		// can't share a connection.
+		if requests.Add(1) == 4 {
+			close(allArrived)
+		}
+		<-allArrived
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	targetOptions := defaultTargetOptions
+	targetOptions.PrewarmConnections = 4
+	target, err := NewTarget(strings.TrimPrefix(server.URL, "http://"), targetOptions)
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, target.PrewarmConnections())
+	assert.Equal(t, int32(4), connections.Load())
+
+	target.SendRequest(httptest.NewRecorder(), testStartRequest(t, target, httptest.NewRequest(http.MethodGet, "/", nil)))
+	assert.Equal(t, int32(4), connections.Load())
+}
+
 func TestTarget_DrainWhenEmpty(t *testing.T) {
 	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {})
 
@@ -333,6 +418,88 @@ func TestTarget_DrainRequestsThatCompleteWithinTimeout(t *testing.T) {
 	require.Equal(t, uint32(n), served.Load())
 }
 
+func TestTarget_DrainWritesLeavesReadsAlone(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(2)
+	release := make(chan bool)
+
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+
+	read := httptest.NewRecorder()
+	write := httptest.NewRecorder()
+	var done sync.WaitGroup
+	done.Add(2)
+	go func() {
+		defer done.Done()
+		testServeRequestWithTarget(t, target, read, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	go func() {
+		defer done.Done()
+		testServeRequestWithTarget(t, target, write, httptest.NewRequest(http.MethodPost, "/", nil))
+	}()
+
+	started.Wait()
+	target.DrainWrites(time.Millisecond * 50)
+
+	require.Eventually(t, func() bool { return target.inflightCount() == 1 }, time.Second, time.Millisecond)
+
+	req, err := target.StartRequest(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	target.endInflightRequest(req)
+
+	close(release)
+	done.Wait()
+
+	assert.Equal(t, http.StatusOK, read.Result().StatusCode)
+	assert.Equal(t, http.StatusGatewayTimeout, write.Result().StatusCode)
+}
+
+func TestTarget_DrainResponseHeaders(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		started := make(chan bool)
+		draining := make(chan bool)
+
+		target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-draining
+			w.Write([]byte("ok"))
+		})
+		target.options.DrainResponseHeaders = enabled
+
+		w := httptest.NewRecorder()
+		done := make(chan bool)
+		go func() {
+			testServeRequestWithTarget(t, target, w, httptest.NewRequest(http.MethodGet, "/", nil))
+			close(done)
+		}()
+
+		<-started
+		go target.Drain(time.Second * 5)
+		require.Eventually(t, func() bool {
+			target.inflightLock.Lock()
+			defer target.inflightLock.Unlock()
+			return target.state == TargetStateDraining
+		}, time.Second, time.Millisecond)
+
+		close(draining)
+		<-done
+
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+		if enabled {
+			assert.Equal(t, "close", w.Result().Header.Get("Connection"))
+			assert.Equal(t, "true", w.Result().Header.Get("X-Deploy-In-Progress"))
+		} else {
+			assert.Empty(t, w.Result().Header.Get("X-Deploy-In-Progress"))
+		}
+	}
+}
+
 func TestTarget_DrainRequestsThatNeedToBeCancelled(t *testing.T) {
 	n := 20
 	served := 0
@@ -539,6 +706,174 @@ func TestTarget_EnforceMaxBodySizes(t *testing.T) {
 	})
 }
 
+func TestTarget_ExpectContinueWaitsForTarget(t *testing.T) {
+	target := testTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testServeRequestWithTarget(t, target, w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Run("rejected before the body is sent", func(t *testing.T) {
+		_, reader := testSendExpectContinueRequest(t, server.Listener.Addr().String(), "/reject", 5)
+		assert.Equal(t, "HTTP/1.1 403 Forbidden", testReadStatusLine(t, reader))
+	})
+
+	t.Run("accepted", func(t *testing.T) {
+		conn, reader := testSendExpectContinueRequest(t, server.Listener.Addr().String(), "/", 5)
+		assert.Equal(t, "HTTP/1.1 100 Continue", testReadStatusLine(t, reader))
+
+		conn.Write([]byte("hello"))
+		assert.Equal(t, "HTTP/1.1 200 OK", testReadStatusLine(t, reader))
+	})
+}
+
+func TestTarget_DeduplicateRequests(t *testing.T) {
+	var calls atomic.Int32
+	options := defaultTargetOptions
+	options.DeduplicateRequests = true
+	options.MaxMemoryBufferSize = DefaultMaxMemoryBufferSize
+
+	target := testTargetWithOptions(t, options, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("charged"))
+	})
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 2)
+	for i := range responses {
+		responses[i] = httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/charge", nil)
+		req.Header.Set(IdempotencyKeyHeader, "abc")
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testServeRequestWithTarget(t, target, responses[i], req)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	for _, w := range responses {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "charged", w.Body.String())
+	}
+}
+
+func TestTarget_ShedsLoad(t *testing.T) {
+	options := defaultTargetOptions
+	options.ShedNormalAt = 1
+	options.PriorityRules = []string{"critical:path=/admin"}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	target := testTargetWithOptions(t, options, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		testServeRequestWithTarget(t, target, httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	testServeRequestWithTarget(t, target, w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	testServeRequestWithTarget(t, target, w, httptest.NewRequest("GET", "/admin", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	close(release)
+	<-done
+
+	w = httptest.NewRecorder()
+	testServeRequestWithTarget(t, target, w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func testSendExpectContinueRequest(t *testing.T, addr string, path string, contentLength int) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: example.com\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", path, contentLength)
+
+	return conn, bufio.NewReader(conn)
+}
+
+func testReadStatusLine(t *testing.T, reader *bufio.Reader) string {
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+
+		if strings.HasPrefix(line, "HTTP/") {
+			status := strings.TrimSpace(line)
+			// Skip the rest of the headers
+			for line != "\r\n" {
+				line, err = reader.ReadString('\n')
+				require.NoError(t, err)
+			}
+			return status
+		}
+	}
+}
+
+func testStartRequest(t *testing.T, target *Target, r *http.Request) *http.Request {
+	r, err := target.StartRequest(r)
+	require.NoError(t, err)
+	return r
+}
+
+func TestTarget_TargetProtocol(t *testing.T) {
+	protocol := func(targetProtocol string) string {
+		handler := h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}), &http2.Server{})
+
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+
+		options := defaultTargetOptions
+		options.TargetProtocol = targetProtocol
+		target, err := NewTarget(strings.TrimPrefix(server.URL, "http://"), options)
+		require.NoError(t, err)
+		require.True(t, target.WaitUntilHealthy(time.Second))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+
+		w := httptest.NewRecorder()
+		testServeRequestWithTarget(t, target, w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "HTTP/1.1", protocol(""))
+	assert.Equal(t, "HTTP/1.1", protocol(TargetProtocolHTTP1))
+	assert.Equal(t, "HTTP/2.0", protocol(TargetProtocolH2C))
+
+	_, err := NewTarget("localhost:3000", TargetOptions{TargetProtocol: "spdy", HealthCheckConfig: defaultHealthCheckConfig})
+	assert.ErrorIs(t, err, ErrorUnknownTargetProtocol)
+}
+
 func testServeRequestWithTarget(t *testing.T, target *Target, w http.ResponseWriter, r *http.Request) {
 	r, err := target.StartRequest(r)
 	require.NoError(t, err)
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// TCPTuningListener sets TCP options on each connection that it accepts.
+// Nagle's algorithm is normally disabled (TCP_NODELAY), so that small writes
+// are sent straight away; re-enabling it trades latency for fewer packets.
+// Keep-alive probes find dead clients on idle connections, such as those of
+// long-polling requests. The period is both how long a connection is idle
+// before the first probe, and the time between probes; a negative period
+// turns them off.
+type TCPTuningListener struct {
+	net.Listener
+	delay     bool
+	keepAlive time.Duration
+}
+
+func NewTCPTuningListener(l net.Listener, delay bool, keepAlive time.Duration) net.Listener {
+	if !delay && keepAlive == 0 {
+		return l
+	}
+
+	return &TCPTuningListener{
+		Listener:  l,
+		delay:     delay,
+		keepAlive: keepAlive,
+	}
+}
+
+func (l *TCPTuningListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+
+	if l.delay {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			slog.Debug("Unable to enable Nagle's algorithm", "error", err)
+		}
+	}
+
+	switch {
+	case l.keepAlive < 0:
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			slog.Debug("Unable to disable TCP keep-alive", "error", err)
+		}
+	case l.keepAlive > 0:
+		config := net.KeepAliveConfig{Enable: true, Idle: l.keepAlive, Interval: l.keepAlive, Count: -1}
+		if err := tcpConn.SetKeepAliveConfig(config); err != nil {
+			slog.Debug("Unable to set TCP keep-alive period", "period", l.keepAlive, "error", err)
+		}
+	}
+
+	return tcpConn, nil
+}
+
+// tcpListenSocketOptions returns the options to set on a listening socket
+// before it's bound. With deferAccept, connections aren't accepted until the
+// client has sent some data (TCP_DEFER_ACCEPT), or the time has passed, so
+// that idle connections don't occupy the server.
+func tcpListenSocketOptions(deferAccept time.Duration) func(fd int) error {
+	if deferAccept <= 0 {
+		return nil
+	}
+
+	seconds := int((deferAccept + time.Second - 1) / time.Second)
+	return func(fd int) error {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, seconds)
+	}
+}
+
+// setListenBacklog changes the length of the queue of connections waiting to
+// be accepted, which Go otherwise sets to the system's maximum. Listening
+// again on a socket that's already listening only changes its backlog.
+func setListenBacklog(l net.Listener, backlog int) error {
+	if backlog <= 0 {
+		return nil
+	}
+
+	tcpListener, ok := l.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+
+	raw, err := tcpListener.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var listenErr error
+	err = raw.Control(func(fd uintptr) {
+		listenErr = unix.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestTCPTuningListener_SetsConnectionOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l = NewTCPTuningListener(l, true, 30*time.Second)
+	t.Cleanup(func() { l.Close() })
+
+	conn := testAcceptConnection(t, l)
+
+	assert.Equal(t, 0, testSocketOptionAt(t, conn, unix.IPPROTO_TCP, unix.TCP_NODELAY))
+	assert.Equal(t, 1, testSocketOptionAt(t, conn, unix.SOL_SOCKET, unix.SO_KEEPALIVE))
+	assert.Equal(t, 30, testSocketOptionAt(t, conn, unix.IPPROTO_TCP, unix.TCP_KEEPIDLE))
+	assert.Equal(t, 30, testSocketOptionAt(t, conn, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL))
+}
+
+func TestTCPTuningListener_DisablesKeepAlive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l = NewTCPTuningListener(l, false, -1)
+	t.Cleanup(func() { l.Close() })
+
+	conn := testAcceptConnection(t, l)
+
+	assert.Equal(t, 1, testSocketOptionAt(t, conn, unix.IPPROTO_TCP, unix.TCP_NODELAY))
+	assert.Equal(t, 0, testSocketOptionAt(t, conn, unix.SOL_SOCKET, unix.SO_KEEPALIVE))
+}
+
+func TestTCPTuningListener_NotNeededWithoutOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	assert.Same(t, l, NewTCPTuningListener(l, false, 0))
+}
+
+func TestTCPTuning_ListenerSocketOptions(t *testing.T) {
+	l, err := listenWithSocketOptions(IPFamilyAny, "127.0.0.1:0", tcpListenSocketOptions(1500*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	assert.Positive(t, testSocketOptionAt(t, l.(*net.TCPListener), unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT))
+
+	require.NoError(t, setListenBacklog(l, 16))
+	testAcceptConnection(t, l)
+}
+
+// Helpers
+
+func testAcceptConnection(t *testing.T, l net.Listener) *net.TCPConn {
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err == nil {
+			conn.Write([]byte("x"))
+			conn.Close()
+		}
+	}()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	require.True(t, ok, "connections should not be wrapped")
+	return tcpConn
+}
+
+func testSocketOptionAt(t *testing.T, conn syscall.Conn, level, option int) int {
+	raw, err := conn.SyscallConn()
+	require.NoError(t, err)
+
+	var value int
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		value, optErr = unix.GetsockoptInt(int(fd), level, option)
+	})
+	require.NoError(t, err)
+	require.NoError(t, optErr)
+	return value
+}
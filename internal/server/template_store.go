@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"sync"
+)
+
+var (
+	ErrorTemplateNotFound    = errors.New("template not found")
+	ErrorTemplateNameMissing = errors.New("template name is required")
+)
+
+// TemplateStore holds named sets of deploy options, so that similar services
+// can be deployed without repeating them. Each template is kept as the
+// command line arguments that it was created with, and is persisted to disk.
+type TemplateStore struct {
+	path      string
+	templates map[string][]string
+	lock      sync.RWMutex
+}
+
+func NewTemplateStore(path string) *TemplateStore {
+	return &TemplateStore{
+		path:      path,
+		templates: map[string][]string{},
+	}
+}
+
+func (s *TemplateStore) Load() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		slog.Error("Failed to load templates", "path", s.path, "error", err)
+		return err
+	}
+	defer f.Close()
+
+	templates := map[string][]string{}
+	err = json.NewDecoder(f).Decode(&templates)
+	if err != nil {
+		slog.Error("Failed to decode templates", "path", s.path, "error", err)
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.templates = templates
+	return nil
+}
+
+func (s *TemplateStore) Get(name string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	args, ok := s.templates[name]
+	if !ok {
+		return nil, ErrorTemplateNotFound
+	}
+	return args, nil
+}
+
+func (s *TemplateStore) List() map[string][]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return maps.Clone(s.templates)
+}
+
+func (s *TemplateStore) Set(name string, args []string) error {
+	if name == "" {
+		return ErrorTemplateNameMissing
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.templates[name] = args
+	return s.save()
+}
+
+func (s *TemplateStore) Remove(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.templates[name]; !ok {
+		return ErrorTemplateNotFound
+	}
+
+	delete(s.templates, name)
+	return s.save()
+}
+
+// Private
+
+func (s *TemplateStore) save() error {
+	f, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = json.NewEncoder(f).Encode(s.templates)
+	if err != nil {
+		slog.Error("Unable to save templates", "error", err, "path", s.path)
+		return err
+	}
+
+	return nil
+}
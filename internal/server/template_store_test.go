@@ -0,0 +1,53 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateStore(t *testing.T) {
+	store := NewTemplateStore(filepath.Join(t.TempDir(), "templates.json"))
+	require.NoError(t, store.Load())
+
+	_, err := store.Get("web")
+	assert.Equal(t, ErrorTemplateNotFound, err)
+
+	require.NoError(t, store.Set("web", []string{"--tls", "--log-request-header", "X-Request-Start"}))
+	require.NoError(t, store.Set("worker", []string{"--buffer-requests"}))
+	assert.Equal(t, ErrorTemplateNameMissing, store.Set("", nil))
+
+	args, err := store.Get("web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--tls", "--log-request-header", "X-Request-Start"}, args)
+
+	require.NoError(t, store.Remove("worker"))
+	assert.Equal(t, ErrorTemplateNotFound, store.Remove("worker"))
+	assert.Equal(t, []string{"web"}, testTemplateNames(store))
+}
+
+func TestTemplateStore_Persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+
+	store := NewTemplateStore(path)
+	require.NoError(t, store.Set("web", []string{"--tls"}))
+
+	restored := NewTemplateStore(path)
+	require.NoError(t, restored.Load())
+
+	args, err := restored.Get("web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--tls"}, args)
+}
+
+// Helpers
+
+func testTemplateNames(store *TemplateStore) []string {
+	names := []string{}
+	for name := range store.List() {
+		names = append(names, name)
+	}
+	return names
+}
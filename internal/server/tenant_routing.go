@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorTenantNotFound             = errors.New("tenant not found")
+	ErrorTenantRoutingNotConfigured = errors.New("tenant routing requires a tenant header or subdomain to be configured for the service")
+	ErrorInvalidTenant              = errors.New("tenant must not be empty")
+)
+
+// TenantRouting identifies the tenant that each request belongs to, so that
+// tenants that have been given their own target can be sent to it. Tenants
+// are named by the value of a header, or by the first label of a request's
+// host, such as acme for acme.app.example.com. The header is used when both
+// are configured, and the request has it.
+type TenantRouting struct {
+	Header    string
+	Subdomain bool
+}
+
+func (tr TenantRouting) Enabled() bool {
+	return tr.Header != "" || tr.Subdomain
+}
+
+// Tenant returns the tenant for a request, or an empty string if it doesn't
+// name one.
+func (tr TenantRouting) Tenant(r *http.Request) string {
+	if tr.Header != "" {
+		if tenant := r.Header.Get(tr.Header); tenant != "" {
+			return strings.ToLower(tenant)
+		}
+	}
+
+	if tr.Subdomain {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		labels := strings.Split(host, ".")
+		if len(labels) > 2 && net.ParseIP(host) == nil {
+			return strings.ToLower(labels[0])
+		}
+	}
+
+	return ""
+}
+
+// SetTenantTarget sends a tenant's requests to their own target, draining
+// any target that they had before.
+func (s *Service) SetTenantTarget(tenant string, target *Target, drainTimeout time.Duration) {
+	s.targetLock.Lock()
+	replaced := s.tenants[tenant]
+	s.tenants[tenant] = target
+	s.targetLock.Unlock()
+
+	slog.Info("Set tenant target", "service", s.name, "tenant", tenant, "target", target.Target())
+	s.retireTenantTarget(replaced, drainTimeout)
+}
+
+// RemoveTenant sends a tenant's requests back to the service's own target.
+func (s *Service) RemoveTenant(tenant string, drainTimeout time.Duration) error {
+	s.targetLock.Lock()
+	replaced, ok := s.tenants[tenant]
+	delete(s.tenants, tenant)
+	s.targetLock.Unlock()
+
+	if !ok {
+		return ErrorTenantNotFound
+	}
+
+	slog.Info("Removed tenant target", "service", s.name, "tenant", tenant)
+	s.retireTenantTarget(replaced, drainTimeout)
+	return nil
+}
+
+// TenantTargets maps each tenant that has its own target to that target.
+func (s *Service) TenantTargets() map[string]string {
+	s.targetLock.RLock()
+	defer s.targetLock.RUnlock()
+
+	result := map[string]string{}
+	for tenant, target := range s.tenants {
+		result[tenant] = target.Target()
+	}
+	return result
+}
+
+// Private
+
+func (s *Service) tenantTarget(req *http.Request) *Target {
+	if len(s.tenants) == 0 {
+		return nil
+	}
+
+	tenant := s.options.tenantRouting().Tenant(req)
+	if tenant == "" {
+		return nil
+	}
+	return s.tenants[tenant]
+}
+
+// tenantTargetList returns the targets of all the service's tenants.
+func (s *Service) tenantTargetList() []*Target {
+	s.targetLock.RLock()
+	defer s.targetLock.RUnlock()
+
+	return slices.Collect(maps.Values(s.tenants))
+}
+
+func (s *Service) retireTenantTarget(target *Target, drainTimeout time.Duration) {
+	if target != nil {
+		target.StopHealthChecks()
+		target.Drain(drainTimeout)
+		target.StopResolving()
+	}
+}
+
+func (s *Service) restoreTenantTargets(tenants map[string]string, options TargetOptions) {
+	s.tenants = map[string]*Target{}
+	for tenant, savedTarget := range tenants {
+		target, err := NewTarget(savedTarget, options)
+		if err != nil {
+			slog.Error("Unable to restore tenant target", "service", s.name, "tenant", tenant, "target", savedTarget, "error", err)
+			continue
+		}
+
+		// As with the service's own targets, restored targets are considered
+		// healthy, because they would have been that way when they were saved.
+		target.state = TargetStateHealthy
+		s.tenants[tenant] = target
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantRouting_Tenant(t *testing.T) {
+	tenant := func(routing TenantRouting, host string, header string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		if header != "" {
+			req.Header.Set("X-Tenant", header)
+		}
+		return routing.Tenant(req)
+	}
+
+	byHeader := TenantRouting{Header: "X-Tenant"}
+	assert.Equal(t, "acme", tenant(byHeader, "app.example.com", "Acme"))
+	assert.Equal(t, "", tenant(byHeader, "acme.app.example.com", ""))
+
+	bySubdomain := TenantRouting{Subdomain: true}
+	assert.Equal(t, "acme", tenant(bySubdomain, "acme.app.example.com:8080", ""))
+	assert.Equal(t, "", tenant(bySubdomain, "example.com", ""))
+	assert.Equal(t, "", tenant(bySubdomain, "10.0.0.1", ""))
+
+	both := TenantRouting{Header: "X-Tenant", Subdomain: true}
+	assert.Equal(t, "other", tenant(both, "acme.app.example.com", "other"))
+	assert.Equal(t, "acme", tenant(both, "acme.app.example.com", ""))
+}
+
+func TestRouter_TenantTargets(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	_, shared := testBackend(t, "shared", http.StatusOK)
+	_, dedicated := testBackend(t, "dedicated", http.StatusOK)
+
+	sendTenantRequest := func(router *Router, tenant string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+		req.Header.Set("X-Tenant", tenant)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	router := NewRouter(statePath)
+	serviceOptions := ServiceOptions{TenantHeader: "X-Tenant"}
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, shared, serviceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetTenantTarget("service1", "Acme", dedicated, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	assert.Equal(t, "dedicated", sendTenantRequest(router, "acme"))
+	assert.Equal(t, "shared", sendTenantRequest(router, "other"))
+
+	tenants, err := router.TenantTargets("service1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"acme": dedicated}, tenants)
+
+	// Tenant targets are restored along with the service
+	router = NewRouter(statePath)
+	require.NoError(t, router.RestoreLastSavedState())
+	assert.Equal(t, "dedicated", sendTenantRequest(router, "acme"))
+
+	require.NoError(t, router.RemoveTenant("service1", "acme", DefaultDrainTimeout))
+	assert.Equal(t, "shared", sendTenantRequest(router, "acme"))
+	assert.ErrorIs(t, router.RemoveTenant("service1", "acme", DefaultDrainTimeout), ErrorTenantNotFound)
+}
+
+func TestRouter_TenantTargetsRequireTenantRouting(t *testing.T) {
+	router := testRouter(t)
+	_, backend := testBackend(t, "shared", http.StatusOK)
+
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, backend, defaultServiceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	assert.ErrorIs(t, router.SetTenantTarget("service1", "acme", backend, DefaultDeployTimeout, DefaultDrainTimeout), ErrorTenantRoutingNotConfigured)
+	assert.ErrorIs(t, router.SetTenantTarget("missing", "acme", backend, DefaultDeployTimeout, DefaultDrainTimeout), ErrorServiceNotFound)
+}
+
+func TestRouter_StopDrainsTenantTargets(t *testing.T) {
+	router := testRouter(t)
+	_, shared := testBackend(t, "shared", http.StatusOK)
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	_, dedicated := testBackendWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		}
+	})
+	t.Cleanup(func() { close(release) })
+
+	serviceOptions := ServiceOptions{TenantHeader: "X-Tenant"}
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, shared, serviceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetTenantTarget("service1", "acme", dedicated, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com/slow", nil)
+		req.Header.Set("X-Tenant", "acme")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+
+	require.NoError(t, router.StopService("service1", time.Millisecond*20, ""))
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("tenant request was not drained")
+	}
+}
+
+func TestRouter_RemoveServiceRetiresTenantTargets(t *testing.T) {
+	router := testRouter(t)
+	_, shared := testBackend(t, "shared", http.StatusOK)
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	_, dedicated := testBackendWithHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+	})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(releaseOnce)
+
+	serviceOptions := ServiceOptions{TenantHeader: "X-Tenant"}
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, shared, serviceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetTenantTarget("service1", "acme", dedicated, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	service := router.serviceForName("service1")
+	tenantTarget := service.tenantTargetList()[0]
+
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com/slow", nil)
+		req.Header.Set("X-Tenant", "acme")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+
+	removed := make(chan error)
+	go func() { removed <- router.RemoveService("service1") }()
+
+	require.Eventually(t, func() bool {
+		tenantTarget.inflightLock.Lock()
+		defer tenantTarget.inflightLock.Unlock()
+		return tenantTarget.state == TargetStateDraining
+	}, time.Second, 10*time.Millisecond)
+
+	releaseOnce()
+	require.NoError(t, <-removed)
+	assert.Empty(t, service.TenantTargets())
+}
+
+func TestRouter_RemoveServiceDrainsTargetsTogetherWithoutLockingRouter(t *testing.T) {
+	router := testRouter(t)
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	slowHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+	}
+	_, shared := testBackendWithHandler(t, slowHandler)
+	_, dedicated := testBackendWithHandler(t, slowHandler)
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(releaseOnce)
+
+	serviceOptions := ServiceOptions{TenantHeader: "X-Tenant"}
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, shared, serviceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetTenantTarget("service1", "acme", dedicated, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	service := router.serviceForName("service1")
+	targets := append(service.tenantTargetList(), service.ActiveTarget())
+
+	for _, tenant := range []string{"", "acme"} {
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "http://app.example.com/slow", nil)
+			req.Header.Set("X-Tenant", tenant)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+		<-started
+	}
+
+	removed := make(chan error)
+	go func() { removed <- router.RemoveService("service1") }()
+
+	require.Eventually(t, func() bool {
+		for _, target := range targets {
+			target.inflightLock.Lock()
+			draining := target.state == TargetStateDraining
+			target.inflightLock.Unlock()
+			if !draining {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	listed := make(chan ServiceDescriptionMap)
+	go func() { listed <- router.ListActiveServices() }()
+	select {
+	case services := <-listed:
+		assert.NotContains(t, services, "service1")
+	case <-time.After(time.Second):
+		t.Fatal("router was locked while the service drained")
+	}
+
+	releaseOnce()
+	require.NoError(t, <-removed)
+}
+
+func TestRouter_ExportAndImportTenantTargets(t *testing.T) {
+	_, shared := testBackend(t, "shared", http.StatusOK)
+	_, dedicated := testBackend(t, "dedicated", http.StatusOK)
+
+	router := testRouter(t)
+	serviceOptions := ServiceOptions{TenantHeader: "X-Tenant"}
+	require.NoError(t, router.SetServiceTarget("service1", defaultEmptyHosts, shared, serviceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+	require.NoError(t, router.SetTenantTarget("service1", "acme", dedicated, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	data, err := router.ExportState()
+	require.NoError(t, err)
+
+	imported := testRouter(t)
+	require.NoError(t, imported.ImportState(data, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	tenants, err := imported.TenantTargets("service1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"acme": dedicated}, tenants)
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+	req.Header.Set("X-Tenant", "acme")
+	w := httptest.NewRecorder()
+	imported.ServeHTTP(w, req)
+	assert.Equal(t, "dedicated", w.Body.String())
+}
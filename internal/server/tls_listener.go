@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// TLSListener accepts TLS connections, completing their handshakes before
+// handing them to the server, so that we can see how each one went. The
+// server would otherwise do the handshake itself, and only log its errors.
+//
+// Each handshake is recorded in metrics by the host the client asked for:
+// how long it took, the version, cipher suite and protocol negotiated,
+// whether a session was resumed, and, for those that fail, why. Hosts are
+// named by hostLabel, so that clients can't create new metrics by sending
+// arbitrary server names.
+//
+// Handshakes run concurrently, so that a slow client doesn't hold up the
+// connections accepted after it.
+type TLSListener struct {
+	net.Listener
+	config    *tls.Config
+	hostLabel func(host string) string
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewTLSListener(l net.Listener, config *tls.Config, hostLabel func(host string) string) *TLSListener {
+	tl := &TLSListener{
+		Listener:  l,
+		config:    config,
+		hostLabel: hostLabel,
+		conns:     make(chan net.Conn),
+		errs:      make(chan error),
+		done:      make(chan struct{}),
+	}
+
+	go tl.acceptConnections()
+	return tl
+}
+
+func (l *TLSListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *TLSListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// Private
+
+func (l *TLSListener) acceptConnections() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		go l.handshake(conn)
+	}
+}
+
+func (l *TLSListener) handshake(conn net.Conn) {
+	tlsConn := tls.Server(conn, l.config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+
+	started := time.Now()
+	err := tlsConn.HandshakeContext(ctx)
+	state := tlsConn.ConnectionState()
+	host := l.hostLabel(state.ServerName)
+
+	if err != nil {
+		reason := tlsHandshakeFailureReason(err)
+		tlsHandshakeFailuresCounter.WithLabelValues(host, reason).Inc()
+		slog.Debug("TLS handshake failed", "host", state.ServerName, "remote_addr", conn.RemoteAddr().String(), "reason", reason, "error", err)
+
+		tlsConn.Close()
+		return
+	}
+
+	tlsHandshakeDurationSeconds.WithLabelValues(host).Observe(time.Since(started).Seconds())
+	tlsHandshakesCounter.WithLabelValues(host, tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite),
+		cmpOrNone(state.NegotiatedProtocol), strconv.FormatBool(state.DidResume)).Inc()
+
+	select {
+	case l.conns <- tlsConn:
+	case <-l.done:
+		tlsConn.Close()
+	}
+}
+
+// tlsHandshakeFailureReason sorts handshake errors into a few kinds, for
+// metrics. The crypto/tls package doesn't export most of its errors, so some
+// can only be recognized by their messages.
+func tlsHandshakeFailureReason(err error) string {
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrorNoServerName):
+		return "no_server_name"
+	case errors.Is(err, ErrorUnknownServerName):
+		return "unknown_server_name"
+	case errors.As(err, &recordErr):
+		return "not_tls"
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		return "rejected_by_client"
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return "timeout"
+	case errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET):
+		return "client_closed"
+	case strings.Contains(err.Error(), "unsupported versions"):
+		return "unsupported_version"
+	case strings.Contains(err.Error(), "no cipher suite"):
+		return "no_shared_cipher"
+	case strings.Contains(err.Error(), "certificate"):
+		return "certificate"
+	default:
+		return "other"
+	}
+}
+
+func cmpOrNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSListener_RecordsHandshakes(t *testing.T) {
+	addr := testTLSServer(t)
+
+	before := testutil.ToFloat64(tlsHandshakesCounter.WithLabelValues("app.example.com", "TLS 1.3", "TLS_AES_128_GCM_SHA256", "h2", "false"))
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{ServerName: "app.example.com", InsecureSkipVerify: true, CipherSuites: []uint16{tls.TLS_AES_128_GCM_SHA256}},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + addr)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, before+1, testutil.ToFloat64(tlsHandshakesCounter.WithLabelValues("app.example.com", "TLS 1.3", "TLS_AES_128_GCM_SHA256", "h2", "false")))
+}
+
+func TestTLSListener_RecordsFailures(t *testing.T) {
+	addr := testTLSServer(t)
+
+	failures := func(host, reason string) float64 {
+		return testutil.ToFloat64(tlsHandshakeFailuresCounter.WithLabelValues(host, reason))
+	}
+	notTLS, unknownHost := failures("other", "not_tls"), failures("other", "unknown_server_name")
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: app.example.com\r\n\r\n")
+	io.ReadAll(conn)
+	conn.Close()
+
+	_, err = tls.Dial("tcp", addr, &tls.Config{ServerName: "unknown.example.com", InsecureSkipVerify: true})
+	require.Error(t, err)
+
+	require.Eventually(t, func() bool {
+		return failures("other", "not_tls") == notTLS+1 && failures("other", "unknown_server_name") == unknownHost+1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTLSListener_FailureReasons(t *testing.T) {
+	assert.Equal(t, "no_server_name", tlsHandshakeFailureReason(ErrorNoServerName))
+	assert.Equal(t, "timeout", tlsHandshakeFailureReason(context.DeadlineExceeded))
+	assert.Equal(t, "client_closed", tlsHandshakeFailureReason(io.EOF))
+	assert.Equal(t, "rejected_by_client", tlsHandshakeFailureReason(&net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}))
+	assert.Equal(t, "no_shared_cipher", tlsHandshakeFailureReason(errors.New("tls: no cipher suite supported by both client and server")))
+}
+
+// Helpers
+
+func testTLSServer(t *testing.T) string {
+	cert, err := tls.X509KeyPair([]byte(certPem), []byte(keyPem))
+	require.NoError(t, err)
+
+	config := &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "app.example.com" {
+				return nil, ErrorUnknownServerName
+			}
+			return &cert, nil
+		},
+	}
+	hostLabel := func(host string) string {
+		if host == "app.example.com" {
+			return host
+		}
+		return "other"
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), TLSConfig: config}
+	go server.Serve(NewTLSListener(l, config, hostLabel))
+	t.Cleanup(func() { server.Close() })
+
+	return l.Addr().String()
+}
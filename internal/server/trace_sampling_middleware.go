@@ -0,0 +1,118 @@
+package server
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+)
+
+const traceParentHeader = "traceparent"
+
+var ErrorInvalidTraceSampleRate = errors.New("trace sample rate must be between 0 and 1")
+
+type TraceSamplingConfig struct {
+	SampleRate  float64
+	ForceHeader string
+}
+
+func (c TraceSamplingConfig) Enabled() bool {
+	return c.SampleRate > 0 || c.ForceHeader != ""
+}
+
+func (c TraceSamplingConfig) Validate() error {
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return ErrorInvalidTraceSampleRate
+	}
+	return nil
+}
+
+// TraceSamplingMiddleware makes the decision about whether a request is
+// traced, so that it's made once for each service, rather than by each of
+// its targets. The decision is passed on in the sampled flag of a W3C
+// traceparent header, which the target's tracer should follow.
+//
+// Requests that already have a traceparent keep their caller's decision.
+// Those that don't are given a new trace, sampled at the configured rate.
+// Requests with the force header are always sampled, so that a debugging
+// session can get full traces however low the rate is.
+type TraceSamplingMiddleware struct {
+	config TraceSamplingConfig
+	next   http.Handler
+}
+
+func WithTraceSamplingMiddleware(config TraceSamplingConfig, next http.Handler) http.Handler {
+	return &TraceSamplingMiddleware{
+		config: config,
+		next:   next,
+	}
+}
+
+func (h *TraceSamplingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	forced := h.config.ForceHeader != "" && r.Header.Get(h.config.ForceHeader) != ""
+
+	traceID, parentID, sampled, ok := parseTraceParent(r.Header.Get(traceParentHeader))
+	if !ok {
+		traceID, parentID = newTraceID(), newTraceParentID()
+		sampled = rand.Float64() < h.config.SampleRate
+	}
+
+	r.Header.Set(traceParentHeader, formatTraceParent(traceID, parentID, sampled || forced))
+	h.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func parseTraceParent(value string) (traceID, parentID string, sampled bool, ok bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return "", "", false, false
+	}
+
+	traceID, parentID, flags := parts[1], parts[2], parts[3]
+	if !validTraceHex(traceID, 32) || !validTraceHex(parentID, 16) || !validTraceHex(flags, 2) {
+		return "", "", false, false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false, false
+	}
+
+	flagBits, _ := hex.DecodeString(flags)
+	return traceID, parentID, flagBits[0]&0x01 != 0, true
+}
+
+func formatTraceParent(traceID, parentID string, sampled bool) string {
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	return "00-" + traceID + "-" + parentID + "-" + flags
+}
+
+func validTraceHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	for _, c := range value {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newTraceID() string {
+	return randomTraceHex(16)
+}
+
+func newTraceParentID() string {
+	return randomTraceHex(8)
+}
+
+func randomTraceHex(size int) string {
+	b := make([]byte, size)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceSamplingMiddleware_StartsTracesAtTheSampleRate(t *testing.T) {
+	for rate, want := range map[float64]string{0: "00", 1: "01"} {
+		traceParent := testTraceSamplingRequest(TraceSamplingConfig{SampleRate: rate, ForceHeader: "X-Debug-Trace"}, nil)
+
+		traceID, parentID, _, ok := parseTraceParent(traceParent)
+		require.True(t, ok, traceParent)
+		assert.Len(t, traceID, 32)
+		assert.Len(t, parentID, 16)
+		assert.True(t, strings.HasSuffix(traceParent, "-"+want))
+	}
+}
+
+func TestTraceSamplingMiddleware_KeepsCallersDecision(t *testing.T) {
+	caller := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+
+	traceParent := testTraceSamplingRequest(TraceSamplingConfig{SampleRate: 1}, map[string]string{"traceparent": caller})
+	assert.Equal(t, caller, traceParent)
+}
+
+func TestTraceSamplingMiddleware_ForceHeader(t *testing.T) {
+	config := TraceSamplingConfig{ForceHeader: "X-Debug-Trace"}
+
+	traceParent := testTraceSamplingRequest(config, map[string]string{"X-Debug-Trace": "1"})
+	assert.True(t, strings.HasSuffix(traceParent, "-01"))
+
+	caller := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+	traceParent = testTraceSamplingRequest(config, map[string]string{"X-Debug-Trace": "1", "traceparent": caller})
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", traceParent)
+}
+
+func TestTraceSamplingMiddleware_ReplacesInvalidTraceParents(t *testing.T) {
+	for _, invalid := range []string{"garbage", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"} {
+		traceParent := testTraceSamplingRequest(TraceSamplingConfig{SampleRate: 1}, map[string]string{"traceparent": invalid})
+
+		_, _, sampled, ok := parseTraceParent(traceParent)
+		assert.True(t, ok)
+		assert.True(t, sampled)
+		assert.NotEqual(t, invalid, traceParent)
+	}
+}
+
+func TestTraceSamplingConfig_Validate(t *testing.T) {
+	assert.NoError(t, TraceSamplingConfig{SampleRate: 0.25}.Validate())
+	assert.ErrorIs(t, TraceSamplingConfig{SampleRate: 1.5}.Validate(), ErrorInvalidTraceSampleRate)
+	assert.ErrorIs(t, TraceSamplingConfig{SampleRate: -1}.Validate(), ErrorInvalidTraceSampleRate)
+}
+
+// Helpers
+
+func testTraceSamplingRequest(config TraceSamplingConfig, headers map[string]string) string {
+	var traceParent string
+	handler := WithTraceSamplingMiddleware(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	return traceParent
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// idleTimeoutConn closes an upgraded connection, such as a WebSocket, once
+// nothing has been sent over it in either direction for the timeout. Each
+// read or write pushes the connection's deadline back, which also extends
+// any read or write that's already waiting.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func newIdleTimeoutConn(conn net.Conn, timeout time.Duration) *idleTimeoutConn {
+	c := &idleTimeoutConn{Conn: conn, timeout: timeout}
+	c.extendDeadline()
+	return c
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	c.extendDeadline()
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.extendDeadline()
+	}
+	return n, err
+}
+
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	c.extendDeadline()
+	return c.Conn.Write(p)
+}
+
+// Private
+
+func (c *idleTimeoutConn) extendDeadline() {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+}
+
+// upgradedCount is the number of connections to the target that have been
+// upgraded, such as WebSockets, and are still open.
+func (t *Target) upgradedCount() int64 {
+	return t.upgraded.Load()
+}
+
+// wrapUpgraded applies the target's idle timeout to an upgraded connection.
+func (t *Target) wrapUpgraded(conn net.Conn) net.Conn {
+	if t.options.UpgradedIdleTimeout <= 0 {
+		return conn
+	}
+	return newIdleTimeoutConn(conn, t.options.UpgradedIdleTimeout)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarget_UpgradedIdleTimeout(t *testing.T) {
+	targetOptions := defaultTargetOptions
+	targetOptions.UpgradedIdleTimeout = time.Millisecond * 200
+
+	target := testTargetWithOptions(t, targetOptions, func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{})
+		require.NoError(t, err)
+		defer c.CloseNow()
+
+		for {
+			kind, body, err := c.Read(context.Background())
+			if err != nil {
+				return
+			}
+			c.Write(context.Background(), kind, body)
+		}
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, err := target.StartRequest(r)
+		require.NoError(t, err)
+		target.SendRequest(w, r)
+	}))
+	defer server.Close()
+
+	websocketURL := strings.Replace(server.URL, "http:", "ws:", 1)
+	c, _, err := websocket.Dial(context.Background(), websocketURL, nil)
+	require.NoError(t, err)
+	defer c.CloseNow()
+
+	assert.Eventually(t, func() bool { return target.upgradedCount() == 1 }, time.Second, time.Millisecond*10)
+
+	// Traffic keeps the connection open past the timeout
+	for range 4 {
+		time.Sleep(time.Millisecond * 100)
+		require.NoError(t, c.Write(context.Background(), websocket.MessageText, []byte("ping")))
+		_, body, err := c.Read(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(body))
+	}
+
+	// But it's closed once it has been idle for longer
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	_, _, err = c.Read(ctx)
+	require.Error(t, err)
+	require.NoError(t, ctx.Err())
+
+	assert.Eventually(t, func() bool { return target.upgradedCount() == 0 }, time.Second, time.Millisecond*10)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+const (
+	DefaultVersionPath = "/version"
+
+	versionMismatchDetailLimit = 64
+)
+
+var (
+	ErrorTargetVersionMismatch = errors.New("target is not running the expected version")
+	ErrorInvalidVersionPath    = errors.New("version path must be an absolute request path, such as /version")
+)
+
+// ValidateVersionPath checks that the version path, if given, is an absolute
+// request path.
+func ValidateVersionPath(versionPath string) error {
+	if versionPath == "" {
+		return nil
+	}
+	if err := ValidateSmokeTestPaths([]string{versionPath}); err != nil {
+		return fmt.Errorf("%w: %s", ErrorInvalidVersionPath, versionPath)
+	}
+	return nil
+}
+
+// VerifyVersion checks that the target is running the version it was
+// deployed as, by requesting its version path as a client of the given host
+// would. The response must be a 2xx whose body, ignoring surrounding
+// whitespace, is the expected version, such as a commit SHA. This catches
+// deploys of the wrong image before they receive traffic.
+func (t *Target) VerifyVersion(host string, tlsEnabled bool) error {
+	if t.options.ExpectedVersion == "" || t.isDirectory() {
+		return nil
+	}
+
+	versionPath := t.options.VersionPath
+	if versionPath == "" {
+		versionPath = DefaultVersionPath
+	}
+
+	statusCode, body, err := t.requestAsClient(t.checkClient(), versionPath, host, tlsEnabled)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrorTargetVersionMismatch, versionPath, err)
+	}
+	if statusCode < 200 || statusCode > 299 {
+		return fmt.Errorf("%w: %s returned %d", ErrorTargetVersionMismatch, versionPath, statusCode)
+	}
+
+	version := strings.TrimSpace(string(body))
+	if !strings.EqualFold(version, t.options.ExpectedVersion) {
+		if len(version) > versionMismatchDetailLimit {
+			version = version[:versionMismatchDetailLimit] + "..."
+		}
+		slog.Info("Target version mismatch", "target", t.Target(), "expected", t.options.ExpectedVersion, "actual", version)
+		return fmt.Errorf("%w: expected %s, got %q", ErrorTargetVersionMismatch, t.options.ExpectedVersion, version)
+	}
+
+	slog.Info("Target version verified", "target", t.Target(), "version", version)
+	return nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarget_VerifyVersion(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/version":
+			w.Write([]byte("abc123\n"))
+		case "/build":
+			w.Write([]byte(`{"sha":"abc123"}`))
+		case "/page":
+			w.Write([]byte(strings.Repeat("x", 1000)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+
+	targetWith := func(expected, versionPath string) *Target {
+		targetOptions := defaultTargetOptions
+		targetOptions.ExpectedVersion = expected
+		targetOptions.VersionPath = versionPath
+		return testTargetWithOptions(t, targetOptions, handler)
+	}
+
+	assert.NoError(t, targetWith("", "").VerifyVersion("example.com", false))
+	assert.NoError(t, targetWith("abc123", "").VerifyVersion("example.com", false))
+	assert.NoError(t, targetWith("ABC123", "/version").VerifyVersion("example.com", false))
+
+	err := targetWith("def456", "").VerifyVersion("example.com", false)
+	assert.ErrorIs(t, err, ErrorTargetVersionMismatch)
+	assert.ErrorContains(t, err, `expected def456, got "abc123"`)
+
+	assert.ErrorIs(t, targetWith("abc123", "/build").VerifyVersion("example.com", false), ErrorTargetVersionMismatch)
+	assert.ErrorContains(t, targetWith("abc123", "/missing").VerifyVersion("example.com", false), "/missing returned 404")
+
+	err = targetWith("abc123", "/page").VerifyVersion("example.com", false)
+	assert.ErrorIs(t, err, ErrorTargetVersionMismatch)
+	assert.Less(t, len(err.Error()), 200)
+}
+
+func TestRouter_DeployFailsWithUnexpectedVersion(t *testing.T) {
+	router := testRouter(t)
+	_, first := testBackend(t, "abc123", http.StatusOK)
+	_, second := testBackend(t, "def456", http.StatusOK)
+
+	targetOptions := defaultTargetOptions
+	targetOptions.ExpectedVersion = "abc123"
+	require.NoError(t, router.SetServiceTarget("service1", []string{"example.com"}, first, defaultServiceOptions, targetOptions, DefaultDeployTimeout, DefaultDrainTimeout))
+
+	err := router.SetServiceTarget("service1", []string{"example.com"}, second, defaultServiceOptions, targetOptions, DefaultDeployTimeout, DefaultDrainTimeout)
+	assert.ErrorIs(t, err, ErrorTargetVersionMismatch)
+
+	_, body := sendGETRequest(router, "http://example.com/")
+	assert.Equal(t, "abc123", body)
+
+	// Rollouts are of a new version, so they aren't held to the active one
+	require.NoError(t, router.SetRolloutTarget("service1", second, DefaultDeployTimeout, DefaultDrainTimeout))
+}
+
+func TestValidateVersionPath(t *testing.T) {
+	assert.NoError(t, ValidateVersionPath(""))
+	assert.NoError(t, ValidateVersionPath("/version"))
+	assert.ErrorIs(t, ValidateVersionPath("version"), ErrorInvalidVersionPath)
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// WebSocketSameOrigin, given as an allowed origin, allows WebSockets to be
+// opened by pages served from the same host as the service.
+const WebSocketSameOrigin = "same-origin"
+
+var (
+	ErrorInvalidWebSocketOrigin         = errors.New("WebSocket origins must be given as scheme://host, which may include * wildcards, or as same-origin")
+	ErrorInvalidWebSocketMaxConnections = errors.New("maximum WebSocket connections must not be negative")
+)
+
+// WebSocketPolicy limits the WebSockets that can be opened to a service.
+// When AllowedOrigins are given, the upgrade request's Origin must match one
+// of them, which protects against other sites opening WebSockets with a
+// user's cookies. When Subprotocols are given, only those are offered to the
+// target. At most MaxConnections can be open at once, if it's set.
+type WebSocketPolicy struct {
+	AllowedOrigins []string
+	Subprotocols   []string
+	MaxConnections int
+}
+
+func (p WebSocketPolicy) Enabled() bool {
+	return len(p.AllowedOrigins) > 0 || len(p.Subprotocols) > 0 || p.MaxConnections > 0
+}
+
+func (p WebSocketPolicy) Validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if origin == WebSocketSameOrigin {
+			continue
+		}
+		if _, err := path.Match(origin, ""); err != nil || !strings.Contains(origin, "://") {
+			return fmt.Errorf("%w: %s", ErrorInvalidWebSocketOrigin, origin)
+		}
+	}
+
+	if p.MaxConnections < 0 {
+		return ErrorInvalidWebSocketMaxConnections
+	}
+	return nil
+}
+
+// WebSocketConnections counts a service's open WebSocket connections. It's
+// kept by the service, rather than its middleware, so that connections that
+// stay open while the service is redeployed are still counted.
+type WebSocketConnections struct {
+	count atomic.Int64
+}
+
+func (c *WebSocketConnections) Count() int64 {
+	return c.count.Load()
+}
+
+type WebSocketPolicyMiddleware struct {
+	policy      WebSocketPolicy
+	connections *WebSocketConnections
+	service     string
+	next        http.Handler
+}
+
+func WithWebSocketPolicyMiddleware(policy WebSocketPolicy, connections *WebSocketConnections, service string, next http.Handler) http.Handler {
+	return &WebSocketPolicyMiddleware{
+		policy:      policy,
+		connections: connections,
+		service:     service,
+		next:        next,
+	}
+}
+
+func (h *WebSocketPolicyMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isWebSocketUpgrade(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if !h.originAllowed(r) {
+		h.reject(w, r, "origin", http.StatusForbidden)
+		return
+	}
+
+	if !h.restrictSubprotocols(r) {
+		h.reject(w, r, "subprotocol", http.StatusBadRequest)
+		return
+	}
+
+	open := h.connections.count.Add(1)
+	defer h.connections.count.Add(-1)
+	if h.policy.MaxConnections > 0 && open > int64(h.policy.MaxConnections) {
+		h.reject(w, r, "limit", http.StatusServiceUnavailable)
+		return
+	}
+
+	websocketConnectionsGauge.WithLabelValues(h.service).Inc()
+	defer websocketConnectionsGauge.WithLabelValues(h.service).Dec()
+
+	h.next.ServeHTTP(w, r)
+}
+
+// Private
+
+func (h *WebSocketPolicyMiddleware) originAllowed(r *http.Request) bool {
+	if len(h.policy.AllowedOrigins) == 0 {
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	for _, allowed := range h.policy.AllowedOrigins {
+		if allowed == WebSocketSameOrigin {
+			if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+				return true
+			}
+			continue
+		}
+
+		if matched, _ := path.Match(strings.ToLower(allowed), strings.ToLower(origin)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictSubprotocols removes any subprotocols that aren't allowed from
+// those that the client offers. It reports false when the client offered
+// some, but none of them are allowed.
+func (h *WebSocketPolicyMiddleware) restrictSubprotocols(r *http.Request) bool {
+	offered := r.Header.Values("Sec-WebSocket-Protocol")
+	if len(h.policy.Subprotocols) == 0 || len(offered) == 0 {
+		return true
+	}
+
+	allowed := []string{}
+	for _, value := range offered {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if slices.Contains(h.policy.Subprotocols, protocol) {
+				allowed = append(allowed, protocol)
+			}
+		}
+	}
+
+	if len(allowed) == 0 {
+		return false
+	}
+
+	r.Header.Set("Sec-WebSocket-Protocol", strings.Join(allowed, ", "))
+	return true
+}
+
+func (h *WebSocketPolicyMiddleware) reject(w http.ResponseWriter, r *http.Request, reason string, statusCode int) {
+	websocketRejectionsCounter.WithLabelValues(h.service, reason).Inc()
+	SetErrorResponse(w, r, statusCode, nil)
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	for _, value := range r.Header.Values("Upgrade") {
+		for _, protocol := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(protocol), "websocket") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketPolicyMiddleware(t *testing.T) {
+	check := func(policy WebSocketPolicy, header http.Header) (int, string) {
+		var protocols string
+		handler := WithWebSocketPolicyMiddleware(policy, &WebSocketConnections{}, "test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			protocols = r.Header.Get("Sec-WebSocket-Protocol")
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com/cable", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result().StatusCode, protocols
+	}
+
+	t.Run("allowed origins", func(t *testing.T) {
+		policy := WebSocketPolicy{AllowedOrigins: []string{"https://*.example.com", WebSocketSameOrigin}}
+
+		status, _ := check(policy, http.Header{"Origin": {"https://www.example.com"}})
+		assert.Equal(t, http.StatusOK, status)
+
+		status, _ = check(policy, http.Header{"Origin": {"http://app.example.com"}})
+		assert.Equal(t, http.StatusOK, status)
+
+		status, _ = check(policy, http.Header{"Origin": {"https://evil.com"}})
+		assert.Equal(t, http.StatusForbidden, status)
+
+		status, _ = check(policy, nil)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("subprotocols", func(t *testing.T) {
+		policy := WebSocketPolicy{Subprotocols: []string{"actioncable-v1-json"}}
+
+		status, protocols := check(policy, http.Header{"Sec-Websocket-Protocol": {"actioncable-v1-json, actioncable-unsupported"}})
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "actioncable-v1-json", protocols)
+
+		status, _ = check(policy, http.Header{"Sec-Websocket-Protocol": {"graphql-ws"}})
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, protocols = check(policy, nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Empty(t, protocols)
+	})
+
+	t.Run("requests that aren't upgrades are not checked", func(t *testing.T) {
+		handler := WithWebSocketPolicyMiddleware(WebSocketPolicy{AllowedOrigins: []string{WebSocketSameOrigin}}, &WebSocketConnections{}, "test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+		req.Header.Set("Origin", "https://evil.com")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	})
+}
+
+func TestWebSocketPolicyMiddleware_MaxConnections(t *testing.T) {
+	connections := &WebSocketConnections{}
+	opened := make(chan struct{})
+	release := make(chan struct{})
+
+	handler := WithWebSocketPolicyMiddleware(WebSocketPolicy{MaxConnections: 1}, connections, "test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(opened)
+		<-release
+	}))
+
+	upgrade := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/cable", nil)
+		req.Header.Set("Upgrade", "websocket")
+		return req
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), upgrade())
+		close(done)
+	}()
+	<-opened
+	assert.Equal(t, int64(1), connections.Count())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, upgrade())
+	assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+
+	close(release)
+	<-done
+	assert.Equal(t, int64(0), connections.Count())
+}
+
+func TestWebSocketPolicy_Validate(t *testing.T) {
+	require.NoError(t, WebSocketPolicy{AllowedOrigins: []string{"https://*.example.com", WebSocketSameOrigin}, MaxConnections: 10}.Validate())
+
+	assert.ErrorIs(t, WebSocketPolicy{AllowedOrigins: []string{"example.com"}}.Validate(), ErrorInvalidWebSocketOrigin)
+	assert.ErrorIs(t, WebSocketPolicy{AllowedOrigins: []string{"https://[example.com"}}.Validate(), ErrorInvalidWebSocketOrigin)
+	assert.ErrorIs(t, WebSocketPolicy{MaxConnections: -1}.Validate(), ErrorInvalidWebSocketMaxConnections)
+}
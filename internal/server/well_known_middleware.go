@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const blockIndexingRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+var ErrorInvalidWellKnownPath = errors.New("well-known file paths must be absolute request paths, such as /robots.txt")
+
+type wellKnownFile struct {
+	content     []byte
+	contentType string
+	modTime     time.Time
+}
+
+// WellKnownMiddleware answers requests for files such as /robots.txt and
+// /.well-known/security.txt with content configured for the service, rather
+// than passing them to the target. The files are read when the service is
+// deployed.
+//
+// With blockIndexing, crawlers are asked not to index anything: /robots.txt
+// disallows everything (unless another one is given), and every response
+// has an X-Robots-Tag of noindex.
+type WellKnownMiddleware struct {
+	files         map[string]wellKnownFile
+	blockIndexing bool
+	next          http.Handler
+}
+
+func WithWellKnownMiddleware(files map[string]string, blockIndexing bool, next http.Handler) (http.Handler, error) {
+	loaded, err := loadWellKnownFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := loaded["/robots.txt"]; blockIndexing && !ok {
+		loaded["/robots.txt"] = wellKnownFile{content: []byte(blockIndexingRobotsTxt), contentType: "text/plain; charset=utf-8"}
+	}
+
+	return &WellKnownMiddleware{
+		files:         loaded,
+		blockIndexing: blockIndexing,
+		next:          next,
+	}, nil
+}
+
+func (h *WellKnownMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.blockIndexing {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+
+	file, ok := h.files[r.URL.Path]
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", file.contentType)
+	http.ServeContent(w, r, r.URL.Path, file.modTime, bytes.NewReader(file.content))
+}
+
+// ValidateWellKnownFiles checks that each file is served at an absolute
+// request path.
+func ValidateWellKnownFiles(files map[string]string) error {
+	for requestPath := range files {
+		if !strings.HasPrefix(requestPath, "/") || path.Clean(requestPath) != requestPath {
+			return fmt.Errorf("%w: %s", ErrorInvalidWellKnownPath, requestPath)
+		}
+	}
+	return nil
+}
+
+// Private
+
+func loadWellKnownFiles(files map[string]string) (map[string]wellKnownFile, error) {
+	err := ValidateWellKnownFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := map[string]wellKnownFile{}
+	for requestPath, filePath := range files {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(requestPath))
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+
+		loaded[requestPath] = wellKnownFile{content: content, contentType: contentType, modTime: info.ModTime()}
+	}
+	return loaded, nil
+}
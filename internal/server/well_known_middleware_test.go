@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWellKnownMiddleware_ServesConfiguredFiles(t *testing.T) {
+	securityTxt := filepath.Join(t.TempDir(), "security.txt")
+	require.NoError(t, os.WriteFile(securityTxt, []byte("Contact: mailto:security@example.com\n"), 0o644))
+
+	handler := testWellKnownHandler(t, map[string]string{"/.well-known/security.txt": securityTxt}, false)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/security.txt", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Contact: mailto:security@example.com\n", w.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/.well-known/security.txt", nil))
+	assert.Equal(t, "target", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	assert.Equal(t, "target", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Robots-Tag"))
+}
+
+func TestWellKnownMiddleware_BlockIndexing(t *testing.T) {
+	handler := testWellKnownHandler(t, nil, true)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "User-agent: *\nDisallow: /\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "target", w.Body.String())
+	assert.Equal(t, "noindex", w.Header().Get("X-Robots-Tag"))
+}
+
+func TestWellKnownMiddleware_BlockIndexingPrefersConfiguredRobotsTxt(t *testing.T) {
+	robotsTxt := filepath.Join(t.TempDir(), "robots.txt")
+	require.NoError(t, os.WriteFile(robotsTxt, []byte("User-agent: *\nDisallow: /admin\n"), 0o644))
+
+	handler := testWellKnownHandler(t, map[string]string{"/robots.txt": robotsTxt}, true)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	assert.Equal(t, "User-agent: *\nDisallow: /admin\n", w.Body.String())
+}
+
+func TestWellKnownMiddleware_InvalidConfiguration(t *testing.T) {
+	_, err := WithWellKnownMiddleware(map[string]string{"robots.txt": "/dev/null"}, false, http.NotFoundHandler())
+	assert.ErrorIs(t, err, ErrorInvalidWellKnownPath)
+
+	_, err = WithWellKnownMiddleware(map[string]string{"/robots.txt": filepath.Join(t.TempDir(), "missing.txt")}, false, http.NotFoundHandler())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestWellKnownMiddleware_ServedForService(t *testing.T) {
+	_, target := testBackend(t, "target", http.StatusOK)
+	router := testRouter(t)
+	serviceOptions := defaultServiceOptions
+	serviceOptions.BlockIndexing = true
+	_, err := router.DeployServiceTarget("service1", defaultEmptyHosts, target, serviceOptions, defaultTargetOptions, DefaultDeployTimeout, DefaultDrainTimeout, 0, false)
+	require.NoError(t, err)
+
+	statusCode, body := sendGETRequest(router, "http://example.com/robots.txt")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "User-agent: *\nDisallow: /\n", body)
+
+	statusCode, body = sendGETRequest(router, "http://example.com/")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "target", body)
+}
+
+// Helpers
+
+func testWellKnownHandler(t *testing.T, files map[string]string, blockIndexing bool) http.Handler {
+	handler, err := WithWellKnownMiddleware(files, blockIndexing, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	}))
+	require.NoError(t, err)
+	return handler
+}